@@ -10,10 +10,13 @@ package changefeedccl
 
 import (
 	"context"
+	"sort"
 
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
@@ -30,9 +33,17 @@ func init() {
 	sql.AddPlanHook("alter changefeed", alterChangefeedPlanHook)
 }
 
+// alterChangefeedOpts are the commands of an ALTER CHANGEFEED statement.
+// There is no command to hold back the emission of a running changefeed
+// while its frontier keeps advancing, e.g. during the maintenance of its
+// sink: the rows held back would have to be kept, across node restarts and
+// resumptions of the job, in a bounded buffer spilling to disk, which
+// changefeeds don't have. Pausing the job with protect_data_from_gc_on_pause
+// holds it instead, at the cost of a catch-up scan once it's resumed.
 type alterChangefeedOpts struct {
 	AddTargets  []tree.TargetList
 	DropTargets []tree.TargetList
+	Resync      bool
 }
 
 // alterChangefeedPlanHook implements sql.PlanHookFn.
@@ -72,10 +83,6 @@ func alterChangefeedPlanHook(
 			return errors.Errorf(`job %d is not changefeed job`, jobID)
 		}
 
-		if job.Status() != jobs.StatusPaused {
-			return errors.Errorf(`job %d is not paused`, jobID)
-		}
-
 		var opts alterChangefeedOpts
 		for _, cmd := range alterChangefeedStmt.Cmds {
 			switch v := cmd.(type) {
@@ -83,14 +90,30 @@ func alterChangefeedPlanHook(
 				opts.AddTargets = append(opts.AddTargets, v.Targets)
 			case *tree.AlterChangefeedDropTarget:
 				opts.DropTargets = append(opts.DropTargets, v.Targets)
+			case *tree.AlterChangefeedResync:
+				opts.Resync = true
 			}
 		}
 
+		// The running processors of a changefeed only pick up its altered
+		// details when they're restarted, so the commands require the job to
+		// be paused, except for a RESYNC alone: the change frontier of a
+		// running changefeed notices the request when it next checkpoints its
+		// progress, and restarts the changefeed to perform it.
+		resyncOnly := opts.Resync && opts.AddTargets == nil && opts.DropTargets == nil
+		if status := job.Status(); status != jobs.StatusPaused &&
+			!(resyncOnly && status == jobs.StatusRunning) {
+			return errors.WithHintf(errors.Errorf(`job %d is not paused`, jobID),
+				`ALTER CHANGEFEED requires the changefeed to be paused, unless it only RESYNCs: `+
+					`run PAUSE JOB %[1]d first, then RESUME JOB %[1]d to apply the changes`, jobID)
+		}
+
 		var initialHighWater hlc.Timestamp
 		statementTime := hlc.Timestamp{
 			WallTime: p.ExtendedEvalContext().GetStmtTimestamp().UnixNano(),
 		}
 
+		addedTargets := make(map[descpb.ID]struct{})
 		if opts.AddTargets != nil {
 			var targetDescs []catalog.Descriptor
 
@@ -102,10 +125,15 @@ func alterChangefeedPlanHook(
 				targetDescs = append(targetDescs, descs...)
 			}
 
-			newTargets, err := getTargets(ctx, p, targetDescs, details.Opts)
+			newTargets, _, err := getTargets(ctx, p, targetDescs, details.Opts, statementTime)
 			if err != nil {
 				return err
 			}
+			for id := range newTargets {
+				if _, ok := details.Targets[id]; !ok {
+					addedTargets[id] = struct{}{}
+				}
+			}
 			// add old targets
 			for id, target := range details.Targets {
 				newTargets[id] = target
@@ -129,7 +157,7 @@ func alterChangefeedPlanHook(
 					if err := p.CheckPrivilege(ctx, desc, privilege.SELECT); err != nil {
 						return err
 					}
-					delete(details.Targets, table.GetID())
+					delete(details.Targets, targetIDOf(details.Targets, table.GetID()))
 				}
 			}
 		}
@@ -138,7 +166,46 @@ func alterChangefeedPlanHook(
 			return errors.Errorf("cannot drop all targets for changefeed job %d", jobID)
 		}
 
-		if err := validateSink(ctx, p, jobID, details, details.Opts); err != nil {
+		// A resync re-scans every target at the high-water of the changefeed
+		// once it's resumed, or restarted if it's running. No resolved
+		// timestamp beyond the high-water is emitted until the re-scan
+		// completes, so the first resolved timestamp after the snapshot rows
+		// indicates that the full snapshot has been emitted.
+		if opts.Resync {
+			highWater := job.Progress().GetHighWater()
+			if highWater == nil || highWater.IsEmpty() {
+				return errors.Errorf(
+					`cannot resync changefeed job %d before its initial scan has completed`, jobID)
+			}
+			if err := validateResync(details); err != nil {
+				return err
+			}
+			details.Opts[changefeedbase.ResyncRequested] = statementTime.String()
+		}
+
+		// Newly added targets are scanned at the current high-water once the job
+		// is resumed, while the pre-existing targets are recorded in the job's
+		// checkpoint so that they are not scanned again. As with RESYNC, no
+		// resolved timestamp beyond the high-water is emitted until the scan of
+		// the new targets completes. A changefeed which has not yet completed its
+		// initial scan, or a pending resync, simply includes the new targets
+		// in that scan.
+		highWater := job.Progress().GetHighWater()
+		_, resyncPending := details.Opts[changefeedbase.ResyncRequested]
+		scanAddedTargets := len(addedTargets) > 0 && highWater != nil && !highWater.IsEmpty() &&
+			!resyncPending &&
+			!timestampOption(details, changefeedbase.ResyncTimestamp).Equal(*highWater)
+		if scanAddedTargets {
+			if cf := job.Progress().GetChangefeed(); cf != nil && cf.Checkpoint != nil && len(cf.Checkpoint.Spans) > 0 {
+				return errors.Errorf(
+					`cannot add targets to changefeed job %d while it is performing a backfill`, jobID)
+			}
+			details.Opts[changefeedbase.BackfillTimestamp] = highWater.String()
+		}
+
+		// The tables of the altered changefeed are validated by sinks when
+		// they emit their rows.
+		if err := validateSink(ctx, p, jobID, details, details.Opts, nil /* targetTables */); err != nil {
 			return err
 		}
 
@@ -152,12 +219,18 @@ func alterChangefeedPlanHook(
 		}
 
 		var targets tree.TargetList
+		var targetOpts []tree.KVOptions
 		for _, target := range details.Targets {
 			targetName := tree.MakeTableNameFromPrefix(tree.ObjectNamePrefix{}, tree.Name(target.StatementTimeName))
 			targets.Tables = append(targets.Tables, &targetName)
+			targetOpts = append(targetOpts, makeTargetKVOptions(target.Opts))
 		}
 
 		oldChangefeedStmt.Targets = targets
+		oldChangefeedStmt.TargetOptions = nil
+		if changefeedbase.HasTargetOptions(details.Targets) {
+			oldChangefeedStmt.TargetOptions = targetOpts
+		}
 		jobDescription := tree.AsString(oldChangefeedStmt)
 
 		newPayload := job.Payload()
@@ -176,10 +249,33 @@ func alterChangefeedPlanHook(
 			return sqlDescIDs
 		}()
 
+		var existingTargetSpans []roachpb.Span
+		if scanAddedTargets {
+			for _, desc := range finalDescs {
+				table, isTable := desc.(catalog.TableDescriptor)
+				// The tables of views aren't among the descriptors, so they
+				// are scanned again along with the added targets.
+				if !isTable || table.IsView() {
+					continue
+				}
+				if _, added := addedTargets[table.GetID()]; !added {
+					existingTargetSpans = append(existingTargetSpans, table.PrimaryIndexSpan(p.ExecCfg().Codec))
+				}
+			}
+		}
+
 		err = p.ExecCfg().JobRegistry.UpdateJobWithTxn(ctx, jobID, p.ExtendedEvalContext().Txn, lockForUpdate, func(
 			txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
 		) error {
 			ju.UpdatePayload(&newPayload)
+			if scanAddedTargets {
+				if progress := md.Progress.GetChangefeed(); progress != nil {
+					progress.Checkpoint = &jobspb.ChangefeedProgress_Checkpoint{
+						Spans: existingTargetSpans,
+					}
+				}
+				ju.UpdateProgress(md.Progress)
+			}
 			return nil
 		})
 
@@ -200,3 +296,59 @@ func alterChangefeedPlanHook(
 
 	return fn, header, nil, false, nil
 }
+
+// validateResync checks that the rows of a resync of the changefeed can be
+// told apart from its incremental changes: only JSON values tag them, in the
+// snapshot field of the metadata of their envelope or, with the Debezium
+// envelope, as snapshot reads. The keys of envelope=key_only and the values
+// of the Connect envelope have no room for the tag.
+func validateResync(details jobspb.ChangefeedDetails) error {
+	check := func(opts map[string]string) error {
+		switch changefeedbase.FormatType(opts[changefeedbase.OptFormat]) {
+		case ``, changefeedbase.OptFormatJSON:
+		default:
+			return errors.Errorf(`RESYNC is only usable with %s=%s, which tags the rows of the resync`,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+		switch envelope := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]); envelope {
+		case changefeedbase.OptEnvelopeKeyOnly, changefeedbase.OptEnvelopeConnect:
+			return errors.Errorf(`RESYNC is not supported with %s=%s, which can't tag the rows of the resync`,
+				changefeedbase.OptEnvelope, envelope)
+		}
+		return nil
+	}
+	if err := check(details.Opts); err != nil {
+		return err
+	}
+	for _, target := range details.Targets {
+		if len(target.Opts) == 0 {
+			continue
+		}
+		if err := check(changefeedbase.OptionsForTarget(details.Opts, target)); err != nil {
+			return errors.Wrapf(err, `options for table %s`, target.StatementTimeName)
+		}
+	}
+	return nil
+}
+
+// makeTargetKVOptions returns the options overridden for a target, in the
+// form of a CREATE CHANGEFEED statement, or nil if there are none.
+func makeTargetKVOptions(opts map[string]string) tree.KVOptions {
+	if len(opts) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvOpts := make(tree.KVOptions, 0, len(keys))
+	for _, k := range keys {
+		opt := tree.KVOption{Key: tree.Name(k)}
+		if v := opts[k]; v != `` {
+			opt.Value = tree.NewDString(v)
+		}
+		kvOpts = append(kvOpts, opt)
+	}
+	return kvOpts
+}
@@ -15,9 +15,11 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -124,6 +126,16 @@ func TestAlterChangefeedErrors(t *testing.T) {
 			fmt.Sprintf(`job %d is not paused`, feed.JobID()),
 			fmt.Sprintf(`ALTER CHANGEFEED %d ADD bar`, feed.JobID()),
 		)
+
+		// Only JSON values can tag the rows of a resync.
+		keyOnlyFeed := feed(t, f, `CREATE CHANGEFEED FOR foo WITH envelope = 'key_only'`)
+		defer closeFeed(t, keyOnlyFeed)
+		keyOnlyJobID := keyOnlyFeed.(cdctest.EnterpriseTestFeed).JobID()
+		waitForHighWater(t, sqlDB, keyOnlyJobID)
+		sqlDB.ExpectErr(t,
+			`RESYNC is not supported with envelope=key_only`,
+			fmt.Sprintf(`ALTER CHANGEFEED %d RESYNC`, keyOnlyJobID),
+		)
 	}
 
 	t.Run(`kafka`, kafkaTest(testFn))
@@ -155,3 +167,134 @@ func TestAlterChangefeedDropAllTargetsError(t *testing.T) {
 
 	t.Run(`kafka`, kafkaTest(testFn))
 }
+
+func TestAlterChangefeedResync(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'b')`)
+
+		testFeed := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved = '10ms', min_checkpoint_frequency = '10ms'`)
+		defer closeFeed(t, testFeed)
+
+		feed, ok := testFeed.(cdctest.EnterpriseTestFeed)
+		require.True(t, ok)
+
+		assertPayloads(t, testFeed, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+			`foo: [2]->{"after": {"a": 2, "b": "b"}}`,
+		})
+		expectResolvedTimestamp(t, testFeed)
+		waitForHighWater(t, sqlDB, feed.JobID())
+
+		sqlDB.Exec(t, `PAUSE JOB $1`, feed.JobID())
+		waitForJobStatus(sqlDB, t, feed.JobID(), `paused`)
+
+		sqlDB.Exec(t, fmt.Sprintf(`ALTER CHANGEFEED %d RESYNC`, feed.JobID()))
+		sqlDB.Exec(t, `UPDATE foo SET b = 'c' WHERE a = 2`)
+
+		sqlDB.Exec(t, fmt.Sprintf(`RESUME JOB %d`, feed.JobID()))
+		waitForJobStatus(sqlDB, t, feed.JobID(), `running`)
+
+		// The resync emits every row as of the high-water, followed by the
+		// incremental change made while the feed was paused.
+		assertPayloads(t, testFeed, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}, "snapshot": true}`,
+			`foo: [2]->{"after": {"a": 2, "b": "b"}, "snapshot": true}`,
+			`foo: [2]->{"after": {"a": 2, "b": "c"}}`,
+		})
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (3, 'd')`)
+		assertPayloads(t, testFeed, []string{
+			`foo: [3]->{"after": {"a": 3, "b": "d"}}`,
+		})
+
+		// A running changefeed restarts to resync once its high-water, which
+		// the rows are scanned at, is checkpointed. Wait for it to pass the
+		// rows written so far, so that none is emitted again as a change.
+		var written string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&written)
+		testutils.SucceedsSoon(t, func() error {
+			var passed bool
+			sqlDB.QueryRow(t, `SELECT high_water_timestamp > $1::DECIMAL FROM crdb_internal.jobs WHERE job_id = $2`,
+				written, feed.JobID()).Scan(&passed)
+			if !passed {
+				return errors.New("waiting for high-water")
+			}
+			return nil
+		})
+		sqlDB.Exec(t, fmt.Sprintf(`ALTER CHANGEFEED %d RESYNC`, feed.JobID()))
+		assertPayloads(t, testFeed, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}, "snapshot": true}`,
+			`foo: [2]->{"after": {"a": 2, "b": "c"}, "snapshot": true}`,
+			`foo: [3]->{"after": {"a": 3, "b": "d"}, "snapshot": true}`,
+		})
+		waitForJobStatus(sqlDB, t, feed.JobID(), `running`)
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestAlterChangefeedAddTargetInitialScan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `CREATE TABLE bar (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1), (2)`)
+
+		testFeed := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved = '10ms'`)
+		defer closeFeed(t, testFeed)
+
+		feed, ok := testFeed.(cdctest.EnterpriseTestFeed)
+		require.True(t, ok)
+
+		assertPayloads(t, testFeed, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+		})
+		expectResolvedTimestamp(t, testFeed)
+		waitForHighWater(t, sqlDB, feed.JobID())
+
+		sqlDB.Exec(t, `PAUSE JOB $1`, feed.JobID())
+		waitForJobStatus(sqlDB, t, feed.JobID(), `paused`)
+
+		sqlDB.Exec(t, fmt.Sprintf(`ALTER CHANGEFEED %d ADD bar`, feed.JobID()))
+
+		sqlDB.Exec(t, fmt.Sprintf(`RESUME JOB %d`, feed.JobID()))
+		waitForJobStatus(sqlDB, t, feed.JobID(), `running`)
+
+		// Only the added table is scanned; foo continues from the high-water.
+		assertPayloads(t, testFeed, []string{
+			`bar: [1]->{"after": {"a": 1}}`,
+			`bar: [2]->{"after": {"a": 2}}`,
+		})
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		assertPayloads(t, testFeed, []string{
+			`foo: [2]->{"after": {"a": 2}}`,
+		})
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+// waitForHighWater waits until the changefeed job has checkpointed a
+// high-water timestamp.
+func waitForHighWater(t *testing.T, sqlDB *sqlutils.SQLRunner, jobID jobspb.JobID) {
+	t.Helper()
+	testutils.SucceedsSoon(t, func() error {
+		var highWater gosql.NullString
+		sqlDB.QueryRow(t, `SELECT high_water_timestamp FROM crdb_internal.jobs WHERE job_id = $1`,
+			jobID).Scan(&highWater)
+		if !highWater.Valid {
+			return errors.New("waiting for high-water")
+		}
+		return nil
+	})
+}
@@ -11,6 +11,7 @@ package changefeedccl
 import (
 	"encoding/json"
 	"math/big"
+	"strconv"
 	"time"
 
 	"github.com/cockroachdb/apd/v3"
@@ -18,6 +19,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/geo"
 	"github.com/cockroachdb/cockroach/pkg/geo/geopb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
@@ -66,6 +68,7 @@ const (
 	avroSchemaBoolean = `boolean`
 	avroSchemaBytes   = `bytes`
 	avroSchemaDouble  = `double`
+	avroSchemaFixed   = `fixed`
 	avroSchemaInt     = `int`
 	avroSchemaLong    = `long`
 	avroSchemaNull    = `null`
@@ -77,6 +80,34 @@ type avroLogicalType struct {
 	LogicalType string         `json:"logicalType"`
 	Precision   int            `json:"precision,omitempty"`
 	Scale       int            `json:"scale,omitempty"`
+
+	// ConnectName, ConnectVersion and ConnectParameters are the properties
+	// which Kafka Connect's avro converter maps the type to one of Connect's
+	// logical types with. They're set with the avro_connect_compatible option.
+	ConnectName       string            `json:"connect.name,omitempty"`
+	ConnectVersion    int               `json:"connect.version,omitempty"`
+	ConnectParameters map[string]string `json:"connect.parameters,omitempty"`
+}
+
+// The names of Kafka Connect's logical types, and the version of their
+// schemas, which avro logical types are annotated with for Connect.
+const (
+	connectDecimalName   = `org.apache.kafka.connect.data.Decimal`
+	connectDateName      = `org.apache.kafka.connect.data.Date`
+	connectTimeName      = `org.apache.kafka.connect.data.Time`
+	connectTimestampName = `org.apache.kafka.connect.data.Timestamp`
+	connectTypeVersion   = 1
+
+	// connectDecimalPrecisionParameter is the Connect schema parameter which
+	// holds the precision of decimals, whose scale is the scale parameter.
+	connectDecimalPrecisionParameter = `connect.decimal.precision`
+)
+
+// avroCollatedStringType is an avro string with a custom property recording
+// the collation of a SQL collated string, which avro can't represent.
+type avroCollatedStringType struct {
+	SchemaType avroSchemaType `json:"type"`
+	Collation  string         `json:"collation"`
 }
 
 type avroArrayType struct {
@@ -84,6 +115,15 @@ type avroArrayType struct {
 	Items      avroSchemaType `json:"items"`
 }
 
+// avroFixedType is an avro fixed, a named type of byte arrays of the given
+// size, which BYTES columns are mapped to by the avro_fixed_columns option.
+type avroFixedType struct {
+	SchemaType avroSchemaType `json:"type"`
+	Name       string         `json:"name"`
+	Namespace  string         `json:"namespace,omitempty"`
+	Size       int            `json:"size"`
+}
+
 func avroUnionKey(t avroSchemaType) string {
 	switch s := t.(type) {
 	case string:
@@ -92,6 +132,13 @@ func avroUnionKey(t avroSchemaType) string {
 		return avroUnionKey(s.SchemaType) + `.` + s.LogicalType
 	case avroArrayType:
 		return avroUnionKey(s.SchemaType)
+	case avroCollatedStringType:
+		return avroUnionKey(s.SchemaType)
+	case avroFixedType:
+		if s.Namespace == "" {
+			return s.Name
+		}
+		return s.Namespace + `.` + s.Name
 	case *avroRecord:
 		if s.Namespace == "" {
 			return s.Name
@@ -114,6 +161,8 @@ type avroSchemaField struct {
 	Default    *string        `json:"default"`
 	Metadata   string         `json:"__crdb__,omitempty"`
 	Namespace  string         `json:"namespace,omitempty"`
+	// Doc is the comment of the column, with the column_comments option.
+	Doc string `json:"doc,omitempty"`
 
 	typ *types.T
 
@@ -147,7 +196,20 @@ type avroRecord struct {
 	Name       string             `json:"name"`
 	Fields     []*avroSchemaField `json:"fields"`
 	Namespace  string             `json:"namespace,omitempty"`
-	codec      *goavro.Codec
+	// ConnectName is the full name of the record, which Kafka Connect's avro
+	// converter names the Connect schema of the record after. It's set with
+	// the avro_connect_compatible option.
+	ConnectName string `json:"connect.name,omitempty"`
+	codec       *goavro.Codec
+}
+
+// connectRecordName returns the full name of an avro record, which is the
+// connect.name property of records with the avro_connect_compatible option.
+func connectRecordName(name, namespace string) string {
+	if namespace == `` {
+		return name
+	}
+	return namespace + `.` + name
 }
 
 // avroDataRecord is an `avroRecord` that represents the schema of a SQL table
@@ -171,6 +233,10 @@ type avroMetadata map[string]interface{}
 type avroEnvelopeOpts struct {
 	beforeField, afterField     bool
 	updatedField, resolvedField bool
+	idempotencyTokenField       bool
+	// connect annotates the schema with the properties of Kafka Connect,
+	// with the avro_connect_compatible option.
+	connect bool
 }
 
 // avroEnvelopeRecord is an `avroRecord` that wraps a changed SQL row and some
@@ -182,8 +248,12 @@ type avroEnvelopeRecord struct {
 	before, after *avroDataRecord
 }
 
-// typeToAvroSchema converts a database type to an avro field
-func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
+// typeToAvroSchema converts a database type to an avro field. If connect is
+// set, the logical types of the field are annotated with the properties which
+// Kafka Connect's avro converter maps them back to Connect's logical types
+// with, and times and timestamps are encoded in milliseconds, the precision of
+// Connect's Time and Timestamp, rather than microseconds.
+func typeToAvroSchema(typ *types.T, connect bool) (*avroSchemaField, error) {
 	schema := &avroSchemaField{
 		typ: typ,
 	}
@@ -384,7 +454,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 		)
 	case types.CollatedStringFamily:
 		setNullable(
-			avroSchemaString,
+			avroCollatedStringType{SchemaType: avroSchemaString, Collation: typ.Locale()},
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
 				return d.(*tree.DCollatedString).Contents, nil
 			},
@@ -403,11 +473,16 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			},
 		)
 	case types.DateFamily:
+		dateType := avroLogicalType{
+			SchemaType:  avroSchemaInt,
+			LogicalType: `date`,
+		}
+		if connect {
+			dateType.ConnectName = connectDateName
+			dateType.ConnectVersion = connectTypeVersion
+		}
 		setNullable(
-			avroLogicalType{
-				SchemaType:  avroSchemaInt,
-				LogicalType: `date`,
-			},
+			dateType,
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
 				date := *d.(*tree.DDate)
 				if !date.IsFinite() {
@@ -423,6 +498,26 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			},
 		)
 	case types.TimeFamily:
+		if connect {
+			setNullable(
+				avroLogicalType{
+					SchemaType:     avroSchemaInt,
+					LogicalType:    `time-millis`,
+					ConnectName:    connectTimeName,
+					ConnectVersion: connectTypeVersion,
+				},
+				func(d tree.Datum, _ interface{}) (interface{}, error) {
+					// The avro library truncates durations to milliseconds.
+					return time.Duration(*d.(*tree.DTime)) * time.Microsecond, nil
+				},
+				func(x interface{}) (tree.Datum, error) {
+					// The avro library hands this back as a time.Duration.
+					micros := x.(time.Duration) / time.Microsecond
+					return tree.MakeDTime(timeofday.TimeOfDay(micros)), nil
+				},
+			)
+			break
+		}
 		setNullable(
 			avroLogicalType{
 				SchemaType:  avroSchemaLong,
@@ -455,10 +550,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 		)
 	case types.TimestampFamily:
 		setNullable(
-			avroLogicalType{
-				SchemaType:  avroSchemaLong,
-				LogicalType: `timestamp-micros`,
-			},
+			timestampAvroType(connect),
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
 				return d.(*tree.DTimestamp).Time, nil
 			},
@@ -468,10 +560,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 		)
 	case types.TimestampTZFamily:
 		setNullable(
-			avroLogicalType{
-				SchemaType:  avroSchemaLong,
-				LogicalType: `timestamp-micros`,
-			},
+			timestampAvroType(connect),
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
 				return d.(*tree.DTimestampTZ).Time, nil
 			},
@@ -513,6 +602,14 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			Precision:   prec,
 			Scale:       width,
 		}
+		if connect {
+			decimalType.ConnectName = connectDecimalName
+			decimalType.ConnectVersion = connectTypeVersion
+			decimalType.ConnectParameters = map[string]string{
+				`scale`:                          strconv.Itoa(width),
+				connectDecimalPrecisionParameter: strconv.Itoa(prec),
+			}
+		}
 		setNullableWithStringFallback(
 			decimalType,
 			func(d tree.Datum, _ interface{}) (interface{}, error) {
@@ -596,7 +693,7 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 			},
 		)
 	case types.ArrayFamily:
-		itemSchema, err := typeToAvroSchema(typ.ArrayContents())
+		itemSchema, err := typeToAvroSchema(typ.ArrayContents(), connect)
 		if err != nil {
 			return nil, errors.Wrapf(err, `could not create item schema for %s`,
 				typ)
@@ -686,10 +783,27 @@ func typeToAvroSchema(typ *types.T) (*avroSchemaField, error) {
 	return schema, nil
 }
 
+// timestampAvroType returns the avro type of timestamps, which are in
+// milliseconds, annotated for Kafka Connect, if connect is set.
+func timestampAvroType(connect bool) avroLogicalType {
+	if connect {
+		return avroLogicalType{
+			SchemaType:     avroSchemaLong,
+			LogicalType:    `timestamp-millis`,
+			ConnectName:    connectTimestampName,
+			ConnectVersion: connectTypeVersion,
+		}
+	}
+	return avroLogicalType{
+		SchemaType:  avroSchemaLong,
+		LogicalType: `timestamp-micros`,
+	}
+}
+
 // columnToAvroSchema converts a column descriptor into its corresponding
-// avro field schema.
-func columnToAvroSchema(col catalog.Column) (*avroSchemaField, error) {
-	schema, err := typeToAvroSchema(col.GetType())
+// avro field schema, annotated for Kafka Connect if connect is set.
+func columnToAvroSchema(col catalog.Column, connect bool) (*avroSchemaField, error) {
+	schema, err := typeToAvroSchema(col.GetType(), connect)
 	if err != nil {
 		return nil, errors.Wrapf(err, "column %s", col.GetName())
 	}
@@ -700,11 +814,82 @@ func columnToAvroSchema(col catalog.Column) (*avroSchemaField, error) {
 	return schema, nil
 }
 
+// columnToFixedAvroSchema converts a BYTES column into an avro field schema
+// of the given fixed type. Encoding a value whose length differs from the
+// size of the fixed type is an error.
+func columnToFixedAvroSchema(col catalog.Column, fixed avroFixedType) (*avroSchemaField, error) {
+	if col.GetType().Family() != types.BytesFamily {
+		return nil, errors.Errorf(`column %s of type %s cannot be encoded as avro fixed`,
+			col.GetName(), col.GetType().SQLString())
+	}
+	schema, err := columnToAvroSchema(col, false /* connect */)
+	if err != nil {
+		return nil, err
+	}
+	fixed.SchemaType = avroSchemaFixed
+	schema.SchemaType = []avroSchemaType{avroSchemaNull, fixed}
+	unionKey := avroUnionKey(fixed)
+	schema.nativeEncoded = map[string]interface{}{unionKey: nil}
+	schema.encodeDatum = func(d tree.Datum, _ interface{}) (interface{}, error) {
+		b := []byte(*d.(*tree.DBytes))
+		if len(b) != fixed.Size {
+			return nil, errors.Errorf(`value of %d bytes of column %s does not match the size %d of avro fixed %s`,
+				len(b), col.GetName(), fixed.Size, unionKey)
+		}
+		return b, nil
+	}
+	schema.encodeFn = func(d tree.Datum) (interface{}, error) {
+		if d == tree.DNull {
+			return nil /* value */, nil
+		}
+		encoded, err := schema.encodeDatum(d, nil /* memo */)
+		if err != nil {
+			return nil, err
+		}
+		schema.nativeEncoded[unionKey] = encoded
+		return schema.nativeEncoded, nil
+	}
+	schema.decodeFn = func(x interface{}) (tree.Datum, error) {
+		if x == nil {
+			return tree.DNull, nil
+		}
+		return tree.NewDBytes(tree.DBytes(x.(map[string]interface{})[unionKey].([]byte))), nil
+	}
+	return schema, nil
+}
+
+// recordFieldToAvroSchema converts a column of the record with the given name
+// into its avro field schema, which is of a fixed type named after the record
+// and the column if fixedColumns, keyed by column name, maps the column to a
+// size. Fixed types are named types, so they need unique names within the
+// schemas which reference them. Other columns are annotated for Kafka Connect
+// if connect is set.
+func recordFieldToAvroSchema(
+	col catalog.Column, recordName string, namespace string, fixedColumns map[string]int, connect bool,
+) (*avroSchemaField, error) {
+	size, ok := fixedColumns[col.GetName()]
+	if !ok {
+		return columnToAvroSchema(col, connect)
+	}
+	return columnToFixedAvroSchema(col, avroFixedType{
+		Name:      recordName + `_` + SQLNameToAvroName(col.GetName()),
+		Namespace: namespace,
+		Size:      size,
+	})
+}
+
 // indexToAvroSchema converts a column descriptor into its corresponding avro
 // record schema. The fields are kept in the same order as columns in the index.
-// sqlName can be any string but should uniquely identify a schema.
+// sqlName can be any string but should uniquely identify a schema. Columns
+// in fixedColumns are encoded as avro fixed of the mapped sizes. The schema is
+// annotated for Kafka Connect if connect is set.
 func indexToAvroSchema(
-	tableDesc catalog.TableDescriptor, index catalog.Index, sqlName string, namespace string,
+	tableDesc catalog.TableDescriptor,
+	index catalog.Index,
+	sqlName string,
+	namespace string,
+	fixedColumns map[string]int,
+	connect bool,
 ) (*avroDataRecord, error) {
 	schema := &avroDataRecord{
 		avroRecord: avroRecord{
@@ -716,6 +901,9 @@ func indexToAvroSchema(
 		colIdxByFieldIdx: make(map[int]int),
 		fieldIdxByColIdx: make(map[int]int),
 	}
+	if connect {
+		schema.ConnectName = connectRecordName(schema.Name, namespace)
+	}
 	colIdxByID := catalog.ColumnIDToOrdinalMap(tableDesc.PublicColumns())
 	for i := 0; i < index.NumKeyColumns(); i++ {
 		colID := index.GetKeyColumnID(i)
@@ -724,7 +912,7 @@ func indexToAvroSchema(
 			return nil, errors.Errorf(`unknown column id: %d`, colID)
 		}
 		col := tableDesc.PublicColumns()[colIdx]
-		field, err := columnToAvroSchema(col)
+		field, err := recordFieldToAvroSchema(col, schema.Name, namespace, fixedColumns, connect)
 		if err != nil {
 			return nil, err
 		}
@@ -760,7 +948,27 @@ func tableToAvroSchema(
 	namespace string,
 	virtualColumnVisibility string,
 ) (*avroDataRecord, error) {
-	name := SQLNameToAvroName(tableDesc.GetName())
+	return tableToNamedAvroSchema(
+		tableDesc, SQLNameToAvroName(tableDesc.GetName()), nameSuffix, namespace, virtualColumnVisibility,
+		nil /* fixedColumns */, nil /* docs */, false /* connect */)
+}
+
+// tableToNamedAvroSchema is like tableToAvroSchema, but the record is given
+// the provided name, which must be a valid avro name, rather than the name of
+// the table, the columns in fixedColumns are encoded as avro fixed of the
+// mapped sizes, the fields of the columns in docs are documented with the
+// mapped comments, and the schema is annotated for Kafka Connect if connect is
+// set.
+func tableToNamedAvroSchema(
+	tableDesc catalog.TableDescriptor,
+	name string,
+	nameSuffix string,
+	namespace string,
+	virtualColumnVisibility string,
+	fixedColumns map[string]int,
+	docs map[descpb.ColumnID]string,
+	connect bool,
+) (*avroDataRecord, error) {
 	if nameSuffix != avroSchemaNoSuffix {
 		name = name + `_` + nameSuffix
 	}
@@ -774,14 +982,18 @@ func tableToAvroSchema(
 		colIdxByFieldIdx: make(map[int]int),
 		fieldIdxByColIdx: make(map[int]int),
 	}
+	if connect {
+		schema.ConnectName = connectRecordName(name, namespace)
+	}
 	for _, col := range tableDesc.PublicColumns() {
 		if col.IsVirtual() && virtualColumnVisibility == string(changefeedbase.OptVirtualColumnsOmitted) {
 			continue
 		}
-		field, err := columnToAvroSchema(col)
+		field, err := recordFieldToAvroSchema(col, name, namespace, fixedColumns, connect)
 		if err != nil {
 			return nil, err
 		}
+		field.Doc = docs[col.GetID()]
 		schema.colIdxByFieldIdx[len(schema.Fields)] = col.Ordinal()
 		schema.fieldIdxByName[field.Name] = len(schema.Fields)
 		schema.fieldIdxByColIdx[col.Ordinal()] = len(schema.Fields)
@@ -895,6 +1107,9 @@ func envelopeToAvroSchema(
 		},
 		opts: opts,
 	}
+	if opts.connect {
+		schema.ConnectName = connectRecordName(schema.Name, namespace)
+	}
 
 	if opts.beforeField {
 		schema.before = before
@@ -930,6 +1145,14 @@ func envelopeToAvroSchema(
 		}
 		schema.Fields = append(schema.Fields, resolvedField)
 	}
+	if opts.idempotencyTokenField {
+		tokenField := &avroSchemaField{
+			SchemaType: []avroSchemaType{avroSchemaNull, avroSchemaString},
+			Name:       idempotencyTokenField,
+			Default:    nil,
+		}
+		schema.Fields = append(schema.Fields, tokenField)
+	}
 
 	schemaJSON, err := json.Marshal(schema)
 	if err != nil {
@@ -992,6 +1215,17 @@ func (r *avroEnvelopeRecord) BinaryFromRow(
 			native[`resolved`] = goavro.Union(avroUnionKey(avroSchemaString), ts.AsOfSystemTime())
 		}
 	}
+	if r.opts.idempotencyTokenField {
+		native[idempotencyTokenField] = nil
+		if u, ok := meta[idempotencyTokenField]; ok {
+			delete(meta, idempotencyTokenField)
+			token, ok := u.(string)
+			if !ok {
+				return nil, errors.Errorf(`unknown metadata idempotency token type: %T`, u)
+			}
+			native[idempotencyTokenField] = goavro.Union(avroUnionKey(avroSchemaString), token)
+		}
+	}
 	for k := range meta {
 		return nil, errors.AssertionFailedf(`unhandled meta key: %s`, k)
 	}
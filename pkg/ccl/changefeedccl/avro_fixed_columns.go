@@ -0,0 +1,99 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	gojson "encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// avroFixedColumns is the parsed avro_fixed_columns option, a JSON object
+// mapping BYTES columns to the sizes of the avro fixed types they're encoded
+// as, instead of variable length avro bytes. A column is named either by
+// itself, which maps the column of that name in every table, or qualified by
+// the name of its table, e.g. {"hash": 32, "users.id": 16}.
+type avroFixedColumns map[string]int
+
+// parseAvroFixedColumns parses the value of the avro_fixed_columns option.
+func parseAvroFixedColumns(s string) (avroFixedColumns, error) {
+	var sizes map[string]int
+	if err := gojson.Unmarshal([]byte(s), &sizes); err != nil {
+		return nil, errors.Wrapf(err, `%s must be a JSON object mapping columns to sizes`,
+			changefeedbase.OptAvroFixedColumns)
+	}
+	for column, size := range sizes {
+		if column == `` {
+			return nil, errors.Errorf(`%s must not map an empty column name`,
+				changefeedbase.OptAvroFixedColumns)
+		}
+		if size <= 0 {
+			return nil, errors.Errorf(`%s size of column %q must be a positive integer: %d`,
+				changefeedbase.OptAvroFixedColumns, column, size)
+		}
+	}
+	return sizes, nil
+}
+
+// forTable returns the sizes of the fixed columns of the table, keyed by
+// column name. Sizes qualified by the name of the table take precedence.
+func (c avroFixedColumns) forTable(desc catalog.TableDescriptor) map[string]int {
+	if len(c) == 0 {
+		return nil
+	}
+	sizes := make(map[string]int)
+	for _, col := range desc.PublicColumns() {
+		name := col.GetName()
+		if size, ok := c[desc.GetName()+`.`+name]; ok {
+			sizes[name] = size
+		} else if size, ok := c[name]; ok {
+			sizes[name] = size
+		}
+	}
+	return sizes
+}
+
+// validateAvroFixedColumns returns an error if the avro_fixed_columns option
+// maps a column of the tables which isn't of type BYTES, or maps a column
+// which doesn't exist in any of the tables.
+func validateAvroFixedColumns(c avroFixedColumns, tables []catalog.TableDescriptor) error {
+	used := make(map[string]struct{}, len(c))
+	for _, desc := range tables {
+		for column := range c.forTable(desc) {
+			col, err := desc.FindColumnWithName(tree.Name(column))
+			if err != nil {
+				return err
+			}
+			if col.GetType().Family() != types.BytesFamily {
+				return errors.Errorf(`%s column %q of table %s must be BYTES, not %s`,
+					changefeedbase.OptAvroFixedColumns, column, desc.GetName(), col.GetType().SQLString())
+			}
+			used[column] = struct{}{}
+			used[desc.GetName()+`.`+column] = struct{}{}
+		}
+	}
+	var unknown []string
+	for column := range c {
+		if _, ok := used[column]; !ok {
+			unknown = append(unknown, column)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.Errorf(`%s columns do not exist in any target table: %s`,
+			changefeedbase.OptAvroFixedColumns, strings.Join(unknown, `, `))
+	}
+	return nil
+}
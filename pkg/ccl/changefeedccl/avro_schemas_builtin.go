@@ -0,0 +1,110 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	gojson "encoding/json"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/privilege"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+func init() {
+	builtins.ChangefeedAvroSchemas = changefeedAvroSchemas
+}
+
+// changefeedAvroSchemas implements crdb_internal.changefeed_avro_schema. It
+// returns the schemas the Avro encoder of a changefeed with the given options
+// would register for the named table, so that they can be registered ahead of
+// the changefeed.
+func changefeedAvroSchemas(
+	evalCtx *tree.EvalContext, tableName string, opts map[string]string,
+) (json.JSON, error) {
+	ctx := evalCtx.Ctx()
+	tn, err := parser.ParseQualifiedTableName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	id, err := evalCtx.Planner.ResolveTableName(ctx, tn)
+	if err != nil {
+		return nil, err
+	}
+	execCfg := evalCtx.Planner.ExecutorConfig().(*sql.ExecutorConfig)
+	col := execCfg.CollectionFactory.MakeCollection(ctx, nil /* TemporarySchemaProvider */)
+	defer col.ReleaseAll(ctx)
+	desc, err := col.GetImmutableTableByID(ctx, evalCtx.Txn, descpb.ID(id), tree.ObjectLookupFlagsWithRequired())
+	if err != nil {
+		return nil, err
+	}
+	if err := evalCtx.Planner.(sql.AuthorizationAccessor).CheckPrivilege(ctx, desc, privilege.SELECT); err != nil {
+		return nil, err
+	}
+
+	normalized := make(map[string]string, len(opts))
+	for k, v := range opts {
+		if _, ok := changefeedbase.CaseInsensitiveOpts[k]; ok {
+			v = strings.ToLower(v)
+		}
+		normalized[k] = v
+	}
+	if format, ok := normalized[changefeedbase.OptFormat]; ok {
+		switch changefeedbase.FormatType(format) {
+		case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		default:
+			return nil, errors.Errorf(`Avro schemas are not generated with %s=%s`,
+				changefeedbase.OptFormat, format)
+		}
+	}
+	if _, ok := normalized[changefeedbase.OptEnvelope]; !ok {
+		normalized[changefeedbase.OptEnvelope] = string(changefeedbase.OptEnvelopeWrapped)
+	}
+	name := desc.GetName()
+	if _, ok := normalized[changefeedbase.OptFullTableName]; ok {
+		name = tn.String()
+	}
+	targets := jobspb.ChangefeedTargets{
+		desc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: name},
+	}
+
+	e, err := newAvroSchemaEncoder(normalized, targets)
+	if err != nil {
+		return nil, err
+	}
+	keySchema, err := e.keySchema(desc)
+	if err != nil {
+		return nil, err
+	}
+	tableName := e.rawTableName(desc)
+	schemas := map[string]interface{}{
+		`key_subject`: e.subject(tableName, confluentSubjectSuffixKey),
+		`key_schema`:  gojson.RawMessage(keySchema.codec.Schema()),
+	}
+	if !e.keyOnly {
+		valueSchema, err := e.valueSchema(desc, desc, nil /* docs */)
+		if err != nil {
+			return nil, err
+		}
+		schemas[`value_subject`] = e.subject(tableName, confluentSubjectSuffixValue)
+		schemas[`value_schema`] = gojson.RawMessage(valueSchema.codec.Schema())
+	}
+	b, err := gojson.Marshal(schemas)
+	if err != nil {
+		return nil, err
+	}
+	return json.ParseJSON(string(b))
+}
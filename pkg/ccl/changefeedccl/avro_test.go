@@ -407,7 +407,8 @@ func TestAvroSchema(t *testing.T) {
 				`{"type":["null","long"],"name":"_u0001f366_","default":null,`+
 				`"__crdb__":"🍦 INT8 NOT NULL"}]}`,
 			tableSchema.codec.Schema())
-		indexSchema, err := indexToAvroSchema(tableDesc, tableDesc.GetPrimaryIndex(), tableDesc.GetName(), "")
+		indexSchema, err := indexToAvroSchema(tableDesc, tableDesc.GetPrimaryIndex(), tableDesc.GetName(), "", nil, /* fixedColumns */
+			false /* connect */)
 		require.NoError(t, err)
 		require.Equal(t,
 			`{"type":"record","name":"_u2603_","fields":[`+
@@ -416,6 +417,97 @@ func TestAvroSchema(t *testing.T) {
 			indexSchema.codec.Schema())
 	})
 
+	t.Run("column_comments", func(t *testing.T) {
+		tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		require.NoError(t, err)
+		tableSchema, err := tableToNamedAvroSchema(tableDesc, `foo`, avroSchemaNoSuffix, "",
+			string(changefeedbase.OptVirtualColumnsOmitted), nil, /* fixedColumns */
+			map[descpb.ColumnID]string{2: `the "b" column`}, false /* connect */)
+		require.NoError(t, err)
+		require.Equal(t,
+			`{"type":"record","name":"foo","fields":[`+
+				`{"type":["null","long"],"name":"a","default":null,"__crdb__":"a INT8 NOT NULL"},`+
+				`{"type":["null","string"],"name":"b","default":null,"__crdb__":"b STRING NULL",`+
+				`"doc":"the \"b\" column"}]}`,
+			tableSchema.codec.Schema())
+
+		// Long comments are truncated without splitting characters.
+		require.Equal(t, `short`, truncateColumnComment(`short`))
+		long := strings.Repeat(`a`, maxColumnCommentLength-1) + `☃☃`
+		require.Equal(t, long[:maxColumnCommentLength-1], truncateColumnComment(long))
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		tableDesc, err := parseTableDesc(`CREATE TABLE foo (` +
+			`a INT PRIMARY KEY, d DECIMAL(10,2), dt DATE, t TIME, ts TIMESTAMP, tz TIMESTAMPTZ, ` +
+			`ds DECIMAL(10,2)[])`)
+		require.NoError(t, err)
+		schema, err := tableToNamedAvroSchema(tableDesc, `foo`, avroSchemaNoSuffix, `ns`,
+			string(changefeedbase.OptVirtualColumnsOmitted), nil /* fixedColumns */, nil, /* docs */
+			true /* connect */)
+		require.NoError(t, err)
+
+		// The schemas of Connect's logical types, as Kafka Connect's avro
+		// converter writes them, which it maps back to the logical types.
+		const (
+			connectDecimal = `{"type":"bytes","scale":2,"precision":10,"connect.version":1,` +
+				`"connect.parameters":{"scale":"2","connect.decimal.precision":"10"},` +
+				`"connect.name":"org.apache.kafka.connect.data.Decimal","logicalType":"decimal"}`
+			connectDate = `{"type":"int","connect.version":1,` +
+				`"connect.name":"org.apache.kafka.connect.data.Date","logicalType":"date"}`
+			connectTime = `{"type":"int","connect.version":1,` +
+				`"connect.name":"org.apache.kafka.connect.data.Time","logicalType":"time-millis"}`
+			connectTimestamp = `{"type":"long","connect.version":1,` +
+				`"connect.name":"org.apache.kafka.connect.data.Timestamp","logicalType":"timestamp-millis"}`
+		)
+		expected := map[string]string{
+			`a`:  `["null","long"]`,
+			`d`:  `["null",` + connectDecimal + `,"string"]`,
+			`dt`: `["null",` + connectDate + `]`,
+			`t`:  `["null",` + connectTime + `]`,
+			`ts`: `["null",` + connectTimestamp + `]`,
+			`tz`: `["null",` + connectTimestamp + `]`,
+			`ds`: `["null",{"type":"array","items":["null",` + connectDecimal + `,"string"]}]`,
+		}
+		var parsed struct {
+			Name        string `json:"name"`
+			ConnectName string `json:"connect.name"`
+			Fields      []struct {
+				Name string          `json:"name"`
+				Type json.RawMessage `json:"type"`
+			} `json:"fields"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(schema.codec.Schema()), &parsed))
+		require.Equal(t, `ns.foo`, parsed.ConnectName)
+		require.Len(t, parsed.Fields, len(expected))
+		for _, field := range parsed.Fields {
+			var actualType, expectedType interface{}
+			require.NoError(t, json.Unmarshal(field.Type, &actualType))
+			require.NoError(t, json.Unmarshal([]byte(expected[field.Name]), &expectedType))
+			require.Equal(t, expectedType, actualType, `field %s`, field.Name)
+		}
+
+		// Times and timestamps are truncated to the milliseconds of Connect's
+		// Time and Timestamp.
+		rows, err := parseValues(tableDesc, `VALUES (1, 1.5, '2022-01-02', '01:02:03.456789', `+
+			`'2022-01-02 01:02:03.456789', '2022-01-02 01:02:03.456789+00', ARRAY[2.5])`)
+		require.NoError(t, err)
+		expectedRows, err := parseValues(tableDesc, `VALUES (1, 1.5, '2022-01-02', '01:02:03.456', `+
+			`'2022-01-02 01:02:03.456', '2022-01-02 01:02:03.456+00', ARRAY[2.5])`)
+		require.NoError(t, err)
+		encoded, err := schema.BinaryFromRow(nil, rows[0])
+		require.NoError(t, err)
+		decoded, err := schema.RowFromBinary(encoded)
+		require.NoError(t, err)
+		evalCtx := &tree.EvalContext{
+			SessionDataStack: sessiondata.NewStack(&sessiondata.SessionData{}),
+		}
+		for i := range decoded {
+			require.Equal(t, 0, expectedRows[0][i].Datum.Compare(evalCtx, decoded[i].Datum),
+				`%s != %s`, expectedRows[0][i].Datum, decoded[i].Datum)
+		}
+	})
+
 	// This test shows what avro schema each sql column maps to, for easy
 	// reference.
 	t.Run("type_goldens", func(t *testing.T) {
@@ -433,7 +525,7 @@ func TestAvroSchema(t *testing.T) {
 			`INTERVAL`:          `["null","string"]`,
 			`JSONB`:             `["null","string"]`,
 			`STRING`:            `["null","string"]`,
-			`STRING COLLATE fr`: `["null","string"]`,
+			`STRING COLLATE fr`: `["null",{"type":"string","collation":"fr"}]`,
 			`TIME`:              `["null",{"type":"long","logicalType":"time-micros"}]`,
 			`TIMETZ`:            `["null","string"]`,
 			`TIMESTAMP`:         `["null",{"type":"long","logicalType":"timestamp-micros"}]`,
@@ -456,7 +548,7 @@ func TestAvroSchema(t *testing.T) {
 			colType := typ.SQLString()
 			tableDesc, err := parseTableDesc(`CREATE TABLE foo (pk INT PRIMARY KEY, a ` + colType + `)`)
 			require.NoError(t, err)
-			field, err := columnToAvroSchema(tableDesc.PublicColumns()[1])
+			field, err := columnToAvroSchema(tableDesc.PublicColumns()[1], false /* connect */)
 			require.NoError(t, err)
 			schema, err := json.Marshal(field.SchemaType)
 			require.NoError(t, err)
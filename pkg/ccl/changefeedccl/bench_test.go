@@ -244,7 +244,7 @@ func createBenchmarkChangefeed(
 	}
 	serverCfg := s.DistSQLServer().(*distsql.ServerImpl).ServerConfig
 	eventConsumer := newKVEventToRowConsumer(ctx, &serverCfg, sf, initialHighWater,
-		sink, encoder, details, TestingKnobs{})
+		sink, nil /* deadLetters */, nil /* rekeys */, nil /* families */, encoder, details, 0 /* epoch */, nil /* tableMetrics */, nil /* evalCtx */, TestingKnobs{})
 	tickFn := func(ctx context.Context) (*jobspb.ResolvedSpan, error) {
 		event, err := buf.Get(ctx)
 		if err != nil {
@@ -0,0 +1,72 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// bufferFlushPolicy is the policy set by the buffer_flush_rows,
+// buffer_flush_bytes and buffer_flush_interval options, by which a change
+// aggregator flushes its sink once it has read enough rows or bytes from the
+// buffer between the kvfeed and the aggregator, or has held them for long
+// enough, since its last flush. Without it, the sink is only flushed along with
+// the frontier, or when the buffer runs out of memory.
+//
+// Like the min_checkpoint_frequency option, the interval is only checked as
+// events are read from the buffer, which includes the resolved timestamps of
+// idle ranges.
+type bufferFlushPolicy struct {
+	rows     int64
+	bytes    int64
+	interval time.Duration
+}
+
+// shouldFlush returns whether rows and bytes, read from the buffer since the
+// last flush, at time since the last flush, are to be flushed.
+func (p bufferFlushPolicy) shouldFlush(rows, bytes int64, since time.Duration) bool {
+	if rows == 0 {
+		return false
+	}
+	return (p.rows > 0 && rows >= p.rows) ||
+		(p.bytes > 0 && bytes >= p.bytes) ||
+		(p.interval > 0 && since >= p.interval)
+}
+
+// getBufferFlushPolicy returns the policy set by the buffer flush options.
+func getBufferFlushPolicy(opts map[string]string) (bufferFlushPolicy, error) {
+	var p bufferFlushPolicy
+	if v, ok := opts[changefeedbase.OptBufferFlushRows]; ok {
+		var err error
+		if p.rows, err = strconv.ParseInt(v, 10, 64); err != nil || p.rows <= 0 {
+			return bufferFlushPolicy{}, errors.Errorf(`%s must be a positive integer: %q`,
+				changefeedbase.OptBufferFlushRows, v)
+		}
+	}
+	if v, ok := opts[changefeedbase.OptBufferFlushBytes]; ok {
+		var err error
+		if p.bytes, err = humanizeutil.ParseBytes(v); err != nil || p.bytes <= 0 {
+			return bufferFlushPolicy{}, errors.Errorf(`%s must be a positive byte size: %q`,
+				changefeedbase.OptBufferFlushBytes, v)
+		}
+	}
+	if v, ok := opts[changefeedbase.OptBufferFlushInterval]; ok {
+		var err error
+		if p.interval, err = time.ParseDuration(v); err != nil || p.interval <= 0 {
+			return bufferFlushPolicy{}, errors.Errorf(`%s must be a positive duration: %q`,
+				changefeedbase.OptBufferFlushInterval, v)
+		}
+	}
+	return p, nil
+}
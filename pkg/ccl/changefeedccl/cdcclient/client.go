@@ -0,0 +1,299 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+// Package cdcclient is a client for consuming sinkless changefeeds, which
+// return their rows and resolved timestamps over pgwire.
+//
+// A Feed iterates over the rows and resolved timestamps of a changefeed, and
+// reconnects, resuming the changefeed from its last resolved timestamp, when
+// its connection fails. Rows the changefeed emits again once resumed are
+// skipped, so the rows of a key are returned once, in the order of their
+// updates, and resolved timestamps never regress.
+package cdcclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Config configures a Feed.
+type Config struct {
+	// ConnConfig configures the connections to the cluster.
+	ConnConfig *pgx.ConnConfig
+	// Statement is the EXPERIMENTAL CHANGEFEED statement of the changefeed,
+	// which must use the json format. Without the resolved option, a Feed
+	// which reconnects restarts the changefeed from its cursor, if any.
+	Statement string
+	// Args are the arguments of the placeholders of Statement.
+	Args []interface{}
+	// Retry is the backoff between reconnects. Its MaxRetries, if non-zero,
+	// bounds the attempts to reconnect after each failure.
+	Retry retry.Options
+}
+
+// Event is a row or a resolved timestamp of a changefeed.
+type Event struct {
+	// Table is the table of a row, and empty for a resolved timestamp.
+	Table string
+	// Key and Value are the JSON encoded key and value of a row.
+	Key, Value []byte
+	// Updated is the timestamp of the update of a row, with the updated
+	// option.
+	Updated hlc.Timestamp
+	// Resolved is the resolved timestamp, for a resolved timestamp.
+	Resolved hlc.Timestamp
+}
+
+// IsResolved returns whether the event is a resolved timestamp.
+func (e Event) IsResolved() bool {
+	return e.Table == ``
+}
+
+// Feed iterates over the events of a sinkless changefeed.
+type Feed struct {
+	cfg  Config
+	stmt *tree.CreateChangefeed
+
+	conn *pgx.Conn
+	rows pgx.Rows
+
+	// resolved is the latest resolved timestamp, from which the changefeed is
+	// resumed.
+	resolved hlc.Timestamp
+	// seen holds the rows returned since the latest resolved timestamp, which
+	// the changefeed emits again once resumed from it. Without the updated
+	// option, identical updates of a row are indistinguishable from these.
+	seen map[string]struct{}
+}
+
+// Open starts the changefeed of the config.
+func Open(ctx context.Context, cfg Config) (*Feed, error) {
+	stmt, err := parseStatement(cfg.Statement)
+	if err != nil {
+		return nil, err
+	}
+	f := &Feed{cfg: cfg, stmt: stmt, seen: make(map[string]struct{})}
+	if err := f.start(ctx); err != nil {
+		f.closeConn(ctx)
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseStatement parses a sinkless changefeed statement, which must use the
+// json format.
+func parseStatement(sql string) (*tree.CreateChangefeed, error) {
+	parsed, err := parser.ParseOne(sql)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := parsed.AST.(*tree.CreateChangefeed)
+	if !ok || stmt.SinkURI != nil {
+		return nil, errors.Errorf(`expected an EXPERIMENTAL CHANGEFEED statement: %s`, sql)
+	}
+	for _, opt := range stmt.Options {
+		if string(opt.Key) != `format` {
+			continue
+		}
+		if format := tree.AsStringWithFlags(opt.Value, tree.FmtBareStrings); format != `json` {
+			return nil, errors.Errorf(`format=%s is not supported, only json is`, format)
+		}
+	}
+	return stmt, nil
+}
+
+// Resolved returns the latest resolved timestamp returned by the feed.
+func (f *Feed) Resolved() hlc.Timestamp {
+	return f.resolved
+}
+
+// Next returns the next event of the changefeed, reconnecting if its
+// connection fails. It returns io.EOF once the changefeed ends.
+func (f *Feed) Next(ctx context.Context) (Event, error) {
+	for {
+		if f.rows == nil {
+			return Event{}, errors.New(`feed is closed`)
+		}
+		if !f.rows.Next() {
+			err := f.rows.Err()
+			if err == nil {
+				return Event{}, io.EOF
+			}
+			if err := f.reconnect(ctx, err); err != nil {
+				return Event{}, err
+			}
+			continue
+		}
+		var table *string
+		var e Event
+		if err := f.rows.Scan(&table, &e.Key, &e.Value); err != nil {
+			return Event{}, err
+		}
+		meta, err := decodeMeta(e.Value)
+		if err != nil {
+			return Event{}, err
+		}
+		if table == nil || *table == `` {
+			if meta.Resolved == `` {
+				return Event{}, errors.Errorf(`resolved timestamp without resolved field: %s`, e.Value)
+			}
+			if e.Resolved, err = tree.ParseHLC(meta.Resolved); err != nil {
+				return Event{}, err
+			}
+			if e.Resolved.LessEq(f.resolved) {
+				continue
+			}
+			f.resolved = e.Resolved
+			for k := range f.seen {
+				delete(f.seen, k)
+			}
+			e.Key, e.Value = nil, nil
+			return e, nil
+		}
+		e.Table = *table
+		seenKey := e.Table + "\x00" + string(e.Key) + "\x00" + string(e.Value)
+		if _, ok := f.seen[seenKey]; ok {
+			continue
+		}
+		f.seen[seenKey] = struct{}{}
+		if meta.Updated != `` {
+			if e.Updated, err = tree.ParseHLC(meta.Updated); err != nil {
+				return Event{}, err
+			}
+		}
+		return e, nil
+	}
+}
+
+// Close closes the connection of the feed, ending the changefeed.
+func (f *Feed) Close(ctx context.Context) error {
+	f.rows = nil
+	if f.conn == nil {
+		return nil
+	}
+	err := f.conn.Close(ctx)
+	f.conn = nil
+	return err
+}
+
+// start connects to the cluster and starts the changefeed, from the latest
+// resolved timestamp if there is one.
+func (f *Feed) start(ctx context.Context) error {
+	var err error
+	if f.conn, err = pgx.ConnectConfig(ctx, f.cfg.ConnConfig); err != nil {
+		return err
+	}
+	stmt := f.statement()
+	f.rows, err = f.conn.Query(ctx, stmt, f.cfg.Args...)
+	return err
+}
+
+// statement returns the statement starting the changefeed, which replaces the
+// cursor of the statement with the latest resolved timestamp if there is one.
+func (f *Feed) statement() string {
+	if f.resolved.IsEmpty() {
+		return tree.AsString(f.stmt)
+	}
+	stmt := *f.stmt
+	stmt.Options = nil
+	for _, opt := range f.stmt.Options {
+		if string(opt.Key) != `cursor` {
+			stmt.Options = append(stmt.Options, opt)
+		}
+	}
+	stmt.Options = append(stmt.Options, tree.KVOption{
+		Key:   `cursor`,
+		Value: tree.NewStrVal(f.resolved.AsOfSystemTime()),
+	})
+	return tree.AsString(&stmt)
+}
+
+// reconnect restarts the changefeed after its connection failed with cause,
+// unless the failure isn't one a new connection can recover from.
+func (f *Feed) reconnect(ctx context.Context, cause error) error {
+	for r := retry.StartWithCtx(ctx, f.cfg.Retry); r.Next(); {
+		f.closeConn(ctx)
+		if !isRetryable(cause) {
+			return cause
+		}
+		if cause = f.start(ctx); cause == nil {
+			return nil
+		}
+	}
+	f.closeConn(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Wrap(cause, `reconnecting changefeed`)
+}
+
+// closeConn closes the connection of the feed, ignoring its error, which is
+// the one the feed is already handling.
+func (f *Feed) closeConn(ctx context.Context) {
+	if f.rows != nil {
+		f.rows.Close()
+	}
+	if f.conn != nil {
+		_ = f.conn.Close(ctx)
+	}
+	f.conn, f.rows = nil, nil
+}
+
+// isRetryable returns whether a new connection may recover from err. Errors
+// returned by the cluster, other than those of a failed connection or of a
+// node shutting down, are returned by a resumed changefeed as well.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+	switch pgcode.MakeCode(pgErr.Code) {
+	case pgcode.AdminShutdown, pgcode.SerializationFailure:
+		return true
+	}
+	return strings.HasPrefix(pgErr.Code, `08`)
+}
+
+// meta holds the timestamp fields of a JSON value, which are top-level fields
+// with the wrapped envelope, and are under the __crdb__ field otherwise.
+type meta struct {
+	Updated  string `json:"updated"`
+	Resolved string `json:"resolved"`
+}
+
+// decodeMeta returns the timestamp fields of a JSON value.
+func decodeMeta(value []byte) (meta, error) {
+	if len(value) == 0 {
+		return meta{}, nil
+	}
+	var v struct {
+		meta
+		Crdb *meta `json:"__crdb__"`
+	}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return meta{}, errors.Wrapf(err, `decoding %s`, value)
+	}
+	if v.Crdb != nil {
+		return *v.Crdb, nil
+	}
+	return v.meta, nil
+}
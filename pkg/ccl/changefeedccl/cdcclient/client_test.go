@@ -0,0 +1,172 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cdcclient
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestFeed starts a server with a table foo and returns a config for
+// feeds on it, along with a connection to the server.
+func startTestFeed(t *testing.T) (Config, *sqlutils.SQLRunner, func()) {
+	s, db, _ := serverutils.StartServer(t, base.TestServerArgs{UseDatabase: `d`})
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `SET CLUSTER SETTING kv.rangefeed.enabled = true`)
+	sqlDB.Exec(t, `SET CLUSTER SETTING kv.closed_timestamp.target_duration = '100ms'`)
+	sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.experimental_poll_interval = '10ms'`)
+	sqlDB.Exec(t, `CREATE DATABASE d`)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+
+	pgURL, cleanup := sqlutils.PGUrl(t, s.ServingSQLAddr(), t.Name(), url.User(security.RootUser))
+	pgURL.Path = `d`
+	connCfg, err := pgx.ParseConfig(pgURL.String())
+	require.NoError(t, err)
+	cfg := Config{
+		ConnConfig: connCfg,
+		Statement:  `EXPERIMENTAL CHANGEFEED FOR foo WITH updated, resolved = '10ms'`,
+	}
+	return cfg, sqlDB, func() {
+		cleanup()
+		s.Stopper().Stop(context.Background())
+	}
+}
+
+// readUntil reads events from the feed until done returns true for one,
+// checking that the rows of each key are returned once, in the order of their
+// updates, and that resolved timestamps don't regress.
+func readUntil(
+	t *testing.T,
+	f *Feed,
+	rows map[string][]string,
+	updated map[string]hlc.Timestamp,
+	done func(Event) bool,
+) {
+	ctx := context.Background()
+	var resolved hlc.Timestamp
+	for {
+		e, err := f.Next(ctx)
+		require.NoError(t, err)
+		if e.IsResolved() {
+			require.True(t, resolved.Less(e.Resolved), `%s after %s`, e.Resolved, resolved)
+			resolved = e.Resolved
+		} else {
+			key := string(e.Key)
+			require.True(t, resolved.Less(e.Updated), `%s row at %s after resolved %s`, key, e.Updated, resolved)
+			require.True(t, updated[key].Less(e.Updated), `%s row at %s after %s`, key, e.Updated, updated[key])
+			updated[key] = e.Updated
+			rows[key] = append(rows[key], string(e.Value))
+		}
+		if done(e) {
+			return
+		}
+	}
+}
+
+func TestFeedOrdering(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	cfg, sqlDB, cleanup := startTestFeed(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	f, err := Open(ctx, cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, f.Close(ctx)) }()
+
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'a')`)
+	sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 1`)
+	sqlDB.Exec(t, `UPDATE foo SET b = 'c' WHERE a = 1`)
+	sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+
+	rows := make(map[string][]string)
+	updated := make(map[string]hlc.Timestamp)
+	readUntil(t, f, rows, updated, func(Event) bool {
+		return len(rows[`[1]`]) == 3 && len(rows[`[2]`]) == 2
+	})
+	require.Len(t, rows[`[1]`], 3)
+	require.Len(t, rows[`[2]`], 2)
+	require.Contains(t, rows[`[1]`][2], `"b": "c"`)
+	require.Contains(t, rows[`[2]`][1], `"after": null`)
+}
+
+func TestFeedReconnect(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	cfg, sqlDB, cleanup := startTestFeed(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	f, err := Open(ctx, cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, f.Close(ctx)) }()
+
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'a')`)
+	rows := make(map[string][]string)
+	updated := make(map[string]hlc.Timestamp)
+	readUntil(t, f, rows, updated, func(e Event) bool {
+		return e.IsResolved() && !updated[`[1]`].IsEmpty() && !updated[`[2]`].IsEmpty() &&
+			updated[`[1]`].Less(e.Resolved) && updated[`[2]`].Less(e.Resolved)
+	})
+	resolved := f.Resolved()
+
+	// Rows written while the connection is down are returned once the feed
+	// resumes from its latest resolved timestamp.
+	sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 1`)
+	require.NoError(t, f.conn.PgConn().Conn().Close())
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (3, 'a')`)
+	readUntil(t, f, rows, updated, func(Event) bool {
+		return len(rows[`[1]`]) == 2 && len(rows[`[3]`]) == 1
+	})
+	require.Len(t, rows[`[1]`], 2)
+	require.Len(t, rows[`[2]`], 1)
+	require.Len(t, rows[`[3]`], 1)
+	require.Contains(t, f.statement(), `cursor = '`+f.Resolved().AsOfSystemTime()+`'`)
+	require.False(t, f.Resolved().Less(resolved))
+}
+
+func TestParseStatement(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for stmt, expectedErr := range map[string]string{
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH resolved`:        ``,
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH format = 'json'`: ``,
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH format = 'avro'`: `format=avro is not supported, only json is`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://host'`:        `expected an EXPERIMENTAL CHANGEFEED statement: CREATE CHANGEFEED FOR foo INTO 'kafka://host'`,
+		`SELECT 1`: `expected an EXPERIMENTAL CHANGEFEED statement: SELECT 1`,
+	} {
+		_, err := parseStatement(stmt)
+		if expectedErr == `` {
+			require.NoError(t, err, stmt)
+		} else {
+			require.EqualError(t, err, expectedErr, stmt)
+		}
+	}
+
+	stmt, err := parseStatement(`EXPERIMENTAL CHANGEFEED FOR foo WITH cursor = '1', resolved`)
+	require.NoError(t, err)
+	f := &Feed{stmt: stmt}
+	require.Equal(t, `EXPERIMENTAL CHANGEFEED FOR TABLE foo WITH cursor = '1', resolved`, f.statement())
+	f.resolved = hlc.Timestamp{WallTime: 5, Logical: 1}
+	require.Equal(t,
+		`EXPERIMENTAL CHANGEFEED FOR TABLE foo WITH resolved, cursor = '5.0000000001'`, f.statement())
+}
@@ -158,6 +158,16 @@ func (s *MockWebhookSink) requestHandler(hw http.ResponseWriter, hr *http.Reques
 			}
 		}
 		err = s.publish(hw, hr)
+	case method == http.MethodHead:
+		if s.basicAuth {
+			username, password, ok := hr.BasicAuth()
+			if !ok || s.username != username || s.password != password {
+				hw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		hw.WriteHeader(http.StatusOK)
+		return
 	default:
 		hw.WriteHeader(http.StatusNotFound)
 		return
@@ -31,6 +31,9 @@ type SchemaRegistry struct {
 		idAlloc  int32
 		schemas  map[int32]string
 		subjects map[string]int32
+		// failures holds the statuses with which the next requests fail, as
+		// injected by InjectFailures.
+		failures []int
 	}
 }
 
@@ -73,6 +76,27 @@ func (r *SchemaRegistry) URL() string {
 	return r.server.URL
 }
 
+// InjectFailures makes the next requests to the registry fail with the given
+// HTTP statuses, one request per status, in order.
+func (r *SchemaRegistry) InjectFailures(statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.failures = append(r.mu.failures, statuses...)
+}
+
+// nextFailure returns the status with which the current request is to fail,
+// or 0 if it isn't.
+func (r *SchemaRegistry) nextFailure() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.mu.failures) == 0 {
+		return 0
+	}
+	status := r.mu.failures[0]
+	r.mu.failures = r.mu.failures[1:]
+	return status
+}
+
 // Subjects returns a copy of currently registered subjects.
 func (r *SchemaRegistry) Subjects() (subjects []string) {
 	r.mu.Lock()
@@ -112,6 +136,11 @@ func (r *SchemaRegistry) requestHandler(hw http.ResponseWriter, hr *http.Request
 	path := hr.URL.Path
 	method := hr.Method
 
+	if status := r.nextFailure(); status != 0 {
+		http.Error(hw, http.StatusText(status), status)
+		return
+	}
+
 	var err error
 	switch {
 	case method == http.MethodPost && subjectVersionsRegexp.MatchString(path):
@@ -168,15 +197,10 @@ func (r *SchemaRegistry) mode(hw http.ResponseWriter, _ *http.Request) error {
 // EncodedAvroToNative decodes bytes that were previously encoded by
 // confluent avro encoder, into GO native representation.
 func (r *SchemaRegistry) EncodedAvroToNative(b []byte) (interface{}, error) {
-	if len(b) == 0 || b[0] != changefeedbase.ConfluentAvroWireFormatMagic {
-		return ``, errors.Errorf(`bad magic byte`)
-	}
-	b = b[1:]
-	if len(b) < 4 {
-		return ``, errors.Errorf(`missing registry id`)
+	id, b, err := changefeedbase.DecodeConfluentAvroHeader(b)
+	if err != nil {
+		return ``, err
 	}
-	id := int32(binary.BigEndian.Uint32(b[:4]))
-	b = b[4:]
 
 	r.mu.Lock()
 	jsonSchema := r.mu.schemas[id]
@@ -203,3 +227,38 @@ func (r *SchemaRegistry) AvroToJSON(avroBytes []byte) ([]byte, error) {
 	// which sorts its object keys and so is deterministic.
 	return json.Marshal(native)
 }
+
+// SplitAvroFileRecords splits the contents of a data file written by the cloud
+// storage sink with format=avro into its key and value messages. Each record
+// is a 4 byte big-endian length followed by the key, then a 4 byte big-endian
+// length followed by the value. Both messages are in the confluent wire
+// format, so the schema used to encode them can be looked up using the
+// schema registry ID they are prefixed with.
+func SplitAvroFileRecords(b []byte) (keys, values [][]byte, _ error) {
+	next := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, errors.Errorf(`truncated record length`)
+		}
+		n := binary.BigEndian.Uint32(b[:4])
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return nil, errors.Errorf(`truncated record: expected %d bytes, found %d`, n, len(b))
+		}
+		msg := b[:n]
+		b = b[n:]
+		return msg, nil
+	}
+	for len(b) > 0 {
+		key, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
@@ -111,5 +111,6 @@ func initialScanFromOptions(opts map[string]string) bool {
 	_, cursor := opts[changefeedbase.OptCursor]
 	_, initialScan := opts[changefeedbase.OptInitialScan]
 	_, noInitialScan := opts[changefeedbase.OptNoInitialScan]
-	return (cursor && initialScan) || (!cursor && !noInitialScan)
+	_, initialScanOnly := opts[changefeedbase.OptInitialScanOnly]
+	return (cursor && (initialScan || initialScanOnly)) || (!cursor && !noInitialScan)
 }
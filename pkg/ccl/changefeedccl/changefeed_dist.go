@@ -11,6 +11,7 @@ package changefeedccl
 import (
 	"context"
 
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeeddist"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/kv"
@@ -20,6 +21,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/rowexec"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
 )
 
 func init() {
@@ -104,24 +106,36 @@ func distChangefeedFlow(
 			spansTS = spansTS.Next()
 		}
 		var err error
-		trackedSpans, err = fetchSpansForTargets(ctx, execCfg, details.Targets, spansTS)
+		trackedSpans, err = fetchSpansForTargets(
+			ctx, execCfg, &execCtx.ExtendedEvalContext().EvalContext, details.Targets, details.Opts, spansTS)
 		if err != nil {
 			return err
 		}
 	}
 
 	var checkpoint jobspb.ChangefeedProgress_Checkpoint
-	if cf := progress.GetChangefeed(); cf != nil && cf.Checkpoint != nil {
-		checkpoint = *cf.Checkpoint
+	var sequences []jobspb.ChangefeedSequence
+	var epoch int64
+	if cf := progress.GetChangefeed(); cf != nil {
+		if cf.Checkpoint != nil {
+			checkpoint = *cf.Checkpoint
+		}
+		sequences = cf.Sequences
+		epoch = cf.Epoch
 	}
 	return changefeeddist.StartDistChangefeed(
-		ctx, execCtx, jobID, details, trackedSpans, initialHighWater, checkpoint, resultsCh)
+		ctx, execCtx, jobID, details, trackedSpans, initialHighWater, checkpoint, sequences, epoch, resultsCh)
 }
 
+// fetchSpansForTargets returns the spans of the primary indexes of the targets
+// as of ts, restricted to the key_range option of the targets for which it's
+// set.
 func fetchSpansForTargets(
 	ctx context.Context,
 	execCfg *sql.ExecutorConfig,
+	evalCtx *tree.EvalContext,
 	targets jobspb.ChangefeedTargets,
+	opts map[string]string,
 	ts hlc.Timestamp,
 ) ([]roachpb.Span, error) {
 	var spans []roachpb.Span
@@ -133,14 +147,23 @@ func fetchSpansForTargets(
 			return err
 		}
 		// Note that all targets are currently guaranteed to be tables.
-		for tableID := range targets {
+		for tableID, target := range targets {
 			flags := tree.ObjectLookupFlagsWithRequired()
 			flags.AvoidLeased = true
 			tableDesc, err := descriptors.GetImmutableTableByID(ctx, txn, tableID, flags)
 			if err != nil {
 				return err
 			}
-			spans = append(spans, tableDesc.PrimaryIndexSpan(execCfg.Codec))
+			keyRange, ok := changefeedbase.OptionsForTarget(opts, target)[changefeedbase.OptKeyRange]
+			if !ok {
+				spans = append(spans, tableDesc.PrimaryIndexSpan(execCfg.Codec))
+				continue
+			}
+			span, err := keyRangeSpan(ctx, evalCtx, execCfg.Codec, tableDesc, keyRange)
+			if err != nil {
+				return errors.Wrapf(err, `table %s`, target.StatementTimeName)
+			}
+			spans = append(spans, span)
 		}
 		return nil
 	}
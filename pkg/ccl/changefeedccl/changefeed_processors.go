@@ -9,10 +9,13 @@
 package changefeedccl
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcutils"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeeddist"
@@ -21,13 +24,16 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/schemafeed"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/rangecache"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/closedts"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/protectedts"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/lease"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
@@ -39,11 +45,13 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logcrash"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
@@ -69,6 +77,13 @@ type changeAggregator struct {
 	// sink is the Sink to write rows to. Resolved timestamps are never written
 	// by changeAggregator.
 	sink Sink
+	// deadLetters, if set, is the deadLetterSink wrapped by sink, to which rows
+	// which cannot be encoded are emitted.
+	deadLetters *deadLetterSink
+	// sequencedSink, if set, is the sink wrapped by sink which numbers the
+	// rows it emits, with the sequence_numbers option. Its sequence numbers
+	// are forwarded to the changeFrontier along with the resolved spans.
+	sequencedSink sequencedSink
 	// changedRowBuf, if non-nil, contains changed rows to be emitted. Anything
 	// queued in `resolvedSpanBuf` is dependent on these having been emitted, so
 	// this one must be empty before moving on to that one.
@@ -85,6 +100,49 @@ type changeAggregator struct {
 	// lastFlush and flushFrequency keep track of the flush frequency.
 	lastFlush      time.Time
 	flushFrequency time.Duration
+	// heartbeat, if non-zero, is the interval at which the frontier is
+	// flushed even if it hasn't advanced, so that the changeFrontier can
+	// emit heartbeats for idle changefeeds.
+	heartbeat time.Duration
+	// watermarkLag, if non-zero, is the duration by which rows and resolved
+	// spans are held back from the frontier, in laggingSink.
+	watermarkLag time.Duration
+	// emitWindow, if set, is the window of the emit_window option, outside of
+	// which rows and resolved spans are held back in laggingSink.
+	emitWindow  *emitWindow
+	laggingSink *laggingSink
+	// dedupSink, if set, drops the rows emitted again with the key and MVCC
+	// timestamp of a row already emitted, per the dedup option.
+	dedupSink *dedupSink
+	// rekeys, if set, holds rows back until the frontier passes them, to emit
+	// primary key changes as rekey events, per the rekey option.
+	rekeys *rekeyBuffer
+	// families, if set, collapses the KVs of the families of each row version
+	// into a single row, per the collapse_families option.
+	families *familyCollapser
+	// rangeFreshness is set with freshness=range, with which the aggregator
+	// emits the resolved timestamps of its own frontier, at most every
+	// freqEmitResolved, rather than leaving them to the changeFrontier.
+	// lastResolved is the last one it emitted.
+	rangeFreshness   bool
+	freqEmitResolved time.Duration
+	lastResolved     hlc.Timestamp
+	// initialScanOnly is set with the initial_scan_only option, with which
+	// the boundary ending the initial scan is forwarded even though it doesn't
+	// advance the frontier.
+	initialScanOnly bool
+	// durableResolved is set with the durable_resolved option, with which
+	// resolved spans are only forwarded once the sink has durably accepted
+	// the rows below them.
+	durableResolved bool
+	// flushPolicy is the policy of the buffer flush options, by which the sink
+	// is flushed as rows are read from the buffer. bufferedRows and
+	// bufferedBytes count the rows read since the sink was last flushed, at
+	// lastBufferFlush.
+	flushPolicy     bufferFlushPolicy
+	bufferedRows    int64
+	bufferedBytes   int64
+	lastBufferFlush time.Time
 
 	// frontier keeps track of resolved timestamps for spans along with schema change
 	// boundary information.
@@ -92,7 +150,10 @@ type changeAggregator struct {
 
 	metrics    *Metrics
 	sliMetrics *sliMetrics
-	knobs      TestingKnobs
+	// tableMetrics counts the messages emitted for each target, which are
+	// forwarded to the change frontier along with the resolved spans.
+	tableMetrics *tableMetrics
+	knobs        TestingKnobs
 }
 
 type timestampLowerBoundOracle interface {
@@ -163,6 +224,16 @@ func newChangeAggregatorProcessor(
 	if ca.encoder, err = getEncoder(ca.spec.Feed.Opts, ca.spec.Feed.Targets); err != nil {
 		return nil, err
 	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptColumnComments]; ok {
+		setColumnComments(ca.encoder, makeColumnCommentsFetcher(flowCtx.Cfg.Executor))
+	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptSourceCluster]; ok {
+		field, err := sourceClusterField(flowCtx.Cfg)
+		if err != nil {
+			return nil, err
+		}
+		setSourceCluster(ca.encoder, field)
+	}
 
 	// MinCheckpointFrequency controls how frequently the changeAggregator flushes the sink
 	// and checkpoints the local frontier to changeFrontier. It is used as a rough
@@ -187,6 +258,35 @@ func newChangeAggregatorProcessor(
 	} else {
 		ca.flushFrequency = changefeedbase.DefaultMinCheckpointFrequency
 	}
+	if r, ok := ca.spec.Feed.Opts[changefeedbase.OptHeartbeat]; ok {
+		if ca.heartbeat, err = time.ParseDuration(r); err != nil {
+			return nil, err
+		}
+	}
+	if r, ok := ca.spec.Feed.Opts[changefeedbase.OptWatermarkLag]; ok {
+		if ca.watermarkLag, err = time.ParseDuration(r); err != nil {
+			return nil, err
+		}
+	}
+	if r, ok := ca.spec.Feed.Opts[changefeedbase.OptEmitWindow]; ok {
+		if ca.emitWindow, err = parseEmitWindow(r); err != nil {
+			return nil, err
+		}
+	}
+	ca.rangeFreshness = changefeedbase.Freshness(ca.spec.Feed.Opts[changefeedbase.OptFreshness]) ==
+		changefeedbase.OptFreshnessRange
+	_, ca.initialScanOnly = ca.spec.Feed.Opts[changefeedbase.OptInitialScanOnly]
+	_, ca.durableResolved = ca.spec.Feed.Opts[changefeedbase.OptDurableResolved]
+	if ca.flushPolicy, err = getBufferFlushPolicy(ca.spec.Feed.Opts); err != nil {
+		return nil, err
+	}
+	if r, ok := ca.spec.Feed.Opts[changefeedbase.OptResolvedTimestamps]; !ok {
+		ca.freqEmitResolved = emitNoResolved
+	} else if r != `` {
+		if ca.freqEmitResolved, err = time.ParseDuration(r); err != nil {
+			return nil, err
+		}
+	}
 
 	return ca, nil
 }
@@ -251,8 +351,11 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 		ca.cancel()
 		return
 	}
+	ca.tableMetrics = ca.metrics.getTableMetrics(ca.spec.Feed.Opts, ca.spec.Feed.Targets)
+	setSchemaRegistryMetrics(ca.encoder, ca.metrics)
 
-	ca.sink, err = getSink(ctx, ca.flowCtx.Cfg, ca.spec.Feed, timestampOracle,
+	ca.sink, err = getSink(ctx, ca.flowCtx.Cfg,
+		aggregatorSinkConfig(ca.spec.Feed, ca.spec.NumAggregators), timestampOracle,
 		ca.spec.User(), ca.spec.JobID, ca.sliMetrics)
 
 	if err != nil {
@@ -268,8 +371,64 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 	if b, ok := ca.sink.(*bufferSink); ok {
 		ca.changedRowBuf = &b.buf
 	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptSequenceNumbers]; ok {
+		if s, ok := ca.sink.(sequencedSink); ok {
+			s.startSequences(ca.flowCtx.NodeID.SQLInstanceID().String(), ca.spec.Sequences)
+			ca.sequencedSink = s
+		}
+	}
+
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptDeadLetterSink]; ok {
+		ca.deadLetters, err = makeDeadLetterSink(ctx, ca.flowCtx.Cfg, ca.spec.Feed, ca.sink,
+			timestampOracle, ca.spec.User(), ca.spec.JobID, ca.metrics, ca.sliMetrics)
+		if err != nil {
+			_ = ca.sink.Close()
+			ca.MoveToDraining(changefeedbase.MarkRetryableError(err))
+			ca.cancel()
+			return
+		}
+		ca.sink = ca.deadLetters
+	}
+
+	bytesPerSec, rowsPerSec, err := getEmitRateLimits(ca.spec.Feed.Opts)
+	if err != nil {
+		ca.MoveToDraining(err)
+		ca.cancel()
+		return
+	}
+	if bytesPerSec > 0 || rowsPerSec > 0 {
+		ca.sink = makeRateLimitingSink(ca.sink,
+			aggregatorShare(bytesPerSec, ca.spec.NumAggregators),
+			aggregatorShare(rowsPerSec, ca.spec.NumAggregators), ca.sliMetrics)
+	}
+	if ca.watermarkLag > 0 || ca.emitWindow != nil {
+		heldBytes := ca.sliMetrics.LagHeldBytes
+		if ca.emitWindow != nil {
+			heldBytes = ca.sliMetrics.WindowHeldBytes
+		}
+		acc := ca.kvFeedMemMon.MakeBoundAccount()
+		ca.laggingSink = makeLaggingSink(ca.sink, &acc, ca.watermarkLag, heldBytes,
+			ca.emitWindow, ca.flowCtx.Cfg.DB.Clock().PhysicalTime)
+		ca.sink = ca.laggingSink
+	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptDedup]; ok {
+		acc := ca.kvFeedMemMon.MakeBoundAccount()
+		ca.dedupSink = makeDedupSink(ca.sink, &acc, ca.sliMetrics)
+		ca.sink = ca.dedupSink
+	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptAtMostOnce]; ok {
+		ca.sink = makeAtMostOnceSink(ca.sink, ca.sliMetrics)
+	}
 
 	ca.sink = &errorWrapperSink{wrapped: ca.sink}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptRekey]; ok {
+		acc := ca.kvFeedMemMon.MakeBoundAccount()
+		ca.rekeys = makeRekeyBuffer(ca.sink, &acc)
+	}
+	if _, ok := ca.spec.Feed.Opts[changefeedbase.OptCollapseFamilies]; ok {
+		acc := ca.kvFeedMemMon.MakeBoundAccount()
+		ca.families = makeFamilyCollapser(ca.flowCtx.Cfg.DB, &acc)
+	}
 
 	ca.eventProducer, err = ca.startKVFeed(ctx, spans, initialHighWater, needsInitialScan, ca.sliMetrics)
 	if err != nil {
@@ -278,13 +437,14 @@ func (ca *changeAggregator) Start(ctx context.Context) {
 		ca.cancel()
 		return
 	}
+	ca.lastBufferFlush = timeutil.Now()
 
 	if ca.spec.Feed.Opts[changefeedbase.OptFormat] == string(changefeedbase.OptFormatNative) {
 		ca.eventConsumer = newNativeKVConsumer(ca.sink)
 	} else {
 		ca.eventConsumer = newKVEventToRowConsumer(
 			ctx, ca.flowCtx.Cfg, ca.frontier.SpanFrontier(), initialHighWater,
-			ca.sink, ca.encoder, ca.spec.Feed, ca.knobs)
+			ca.sink, ca.deadLetters, ca.rekeys, ca.families, ca.encoder, ca.spec.Feed, ca.spec.Epoch, ca.tableMetrics, ca.flowCtx.NewEvalCtx(), ca.knobs)
 	}
 }
 
@@ -302,7 +462,10 @@ func (ca *changeAggregator) startKVFeed(
 
 	// KVFeed takes ownership of the kvevent.Writer portion of the buffer, while
 	// we return the kvevent.Reader part to the caller.
-	kvfeedCfg := ca.makeKVFeedCfg(ctx, spans, buf, initialHighWater, needsInitialScan, sm)
+	kvfeedCfg, err := ca.makeKVFeedCfg(ctx, spans, buf, initialHighWater, needsInitialScan, sm)
+	if err != nil {
+		return nil, err
+	}
 
 	// Give errCh enough buffer both possible errors from supporting goroutines,
 	// but only the first one is ever used.
@@ -334,22 +497,40 @@ func (ca *changeAggregator) makeKVFeedCfg(
 	initialHighWater hlc.Timestamp,
 	needsInitialScan bool,
 	sm *sliMetrics,
-) kvfeed.Config {
+) (kvfeed.Config, error) {
 	schemaChangeEvents := changefeedbase.SchemaChangeEventClass(
 		ca.spec.Feed.Opts[changefeedbase.OptSchemaChangeEvents])
 	schemaChangePolicy := changefeedbase.SchemaChangePolicy(
 		ca.spec.Feed.Opts[changefeedbase.OptSchemaChangePolicy])
-	_, withDiff := ca.spec.Feed.Opts[changefeedbase.OptDiff]
+	_, initialScanOnly := ca.spec.Feed.Opts[changefeedbase.OptInitialScanOnly]
+	_, initialScanOrdered := ca.spec.Feed.Opts[changefeedbase.OptInitialScanOrdered]
+	withDiff := needsPrevValues(ca.spec.Feed.Opts)
+	for _, target := range ca.spec.Feed.Targets {
+		withDiff = withDiff || needsPrevValues(changefeedbase.OptionsForTarget(ca.spec.Feed.Opts, target))
+	}
 	cfg := ca.flowCtx.Cfg
 
 	var sf schemafeed.SchemaFeed
 	if schemaChangePolicy == changefeedbase.OptSchemaChangePolicyIgnore {
 		sf = schemafeed.DoNothingSchemaFeed
 	} else {
-		sf = schemafeed.New(ctx, cfg, schemaChangeEvents, ca.spec.Feed.Targets,
+		sf = schemafeed.New(ctx, cfg, schemaChangeEvents, ca.spec.Feed.Targets, ca.spec.Feed.Opts,
 			initialHighWater, &ca.metrics.SchemaFeedMetrics)
 	}
 
+	scanRequestBatchBytes, err := getScanRequestBatchBytes(ca.spec.Feed.Opts)
+	if err != nil {
+		return kvfeed.Config{}, err
+	}
+	initialScanConcurrency, err := getInitialScanConcurrency(ca.spec.Feed.Opts)
+	if err != nil {
+		return kvfeed.Config{}, err
+	}
+	scanChunkBytes, err := getInitialScanChunkSize(ca.spec.Feed.Opts)
+	if err != nil {
+		return kvfeed.Config{}, err
+	}
+
 	return kvfeed.Config{
 		Writer:             buf,
 		Settings:           cfg.Settings,
@@ -369,8 +550,102 @@ func (ca *changeAggregator) makeKVFeedCfg(
 		SchemaChangeEvents: schemaChangeEvents,
 		SchemaChangePolicy: schemaChangePolicy,
 		SchemaFeed:         sf,
+		InitialScanOnly:    initialScanOnly,
+		InitialScanOrdered: initialScanOrdered,
 		Knobs:              ca.knobs.FeedKnobs,
+
+		InitialScanConcurrency: initialScanConcurrency,
+		ScanRequestBatchBytes:  scanRequestBatchBytes,
+		ScanChunkBytes:         scanChunkBytes,
+		OnScanThroughput:       ca.sliMetrics.getScanThroughputCallback(),
+		OnSpanScan:             ca.sliMetrics.getSpanScanCallback(),
+	}, nil
+}
+
+// getInitialScanConcurrency returns the number of spans the initial scan
+// exports concurrently set by the initial_scan_concurrency option, or 0 if the
+// option isn't set. Higher concurrency trades memory, since each concurrent
+// ScanRequest buffers its response, and load on the cluster for faster initial
+// scans.
+func getInitialScanConcurrency(opts map[string]string) (int, error) {
+	v, ok := opts[changefeedbase.OptInitialScanConcurrency]
+	if !ok {
+		return 0, nil
+	}
+	concurrency, err := strconv.Atoi(v)
+	if err != nil || concurrency <= 0 {
+		return 0, errors.Errorf(`%s must be a positive integer: %q`,
+			changefeedbase.OptInitialScanConcurrency, v)
+	}
+	return concurrency, nil
+}
+
+// Bounds of the scan_request_batch_bytes option. Each concurrent ScanRequest
+// of an initial scan buffers up to this many bytes, so larger batches trade
+// memory for scan throughput.
+const (
+	minScanRequestBatchBytes = 1 << 20   // 1 MiB
+	maxScanRequestBatchBytes = 256 << 20 // 256 MiB
+)
+
+// getScanRequestBatchBytes returns the target size of the ScanRequests issued
+// by initial scans set by the scan_request_batch_bytes option, or 0 if the
+// option isn't set.
+func getScanRequestBatchBytes(opts map[string]string) (int64, error) {
+	v, ok := opts[changefeedbase.OptScanRequestBatchBytes]
+	if !ok {
+		return 0, nil
 	}
+	batchBytes, err := humanizeutil.ParseBytes(v)
+	if err != nil || batchBytes < minScanRequestBatchBytes || batchBytes > maxScanRequestBatchBytes {
+		return 0, errors.Errorf(`%s must be a byte size between %s and %s: %q`,
+			changefeedbase.OptScanRequestBatchBytes,
+			humanizeutil.IBytes(minScanRequestBatchBytes), humanizeutil.IBytes(maxScanRequestBatchBytes), v)
+	}
+	return batchBytes, nil
+}
+
+// minInitialScanChunkSize is the lower bound of the initial_scan_chunk_size
+// option, below which the overhead of the requests of each chunk dominates.
+const minInitialScanChunkSize = 1 << 20 // 1 MiB
+
+// getInitialScanChunkSize returns the size of the units in which the initial
+// scan reads the spans of large ranges set by the initial_scan_chunk_size
+// option, or 0 if the option isn't set. Smaller chunks bound the memory of
+// each ScanRequest, and let the scans of other ranges proceed in between
+// the chunks of large ranges.
+func getInitialScanChunkSize(opts map[string]string) (int64, error) {
+	v, ok := opts[changefeedbase.OptInitialScanChunkSize]
+	if !ok {
+		return 0, nil
+	}
+	chunkSize, err := humanizeutil.ParseBytes(v)
+	if err != nil || chunkSize < minInitialScanChunkSize {
+		return 0, errors.Errorf(`%s must be a byte size of at least %s: %q`,
+			changefeedbase.OptInitialScanChunkSize, humanizeutil.IBytes(minInitialScanChunkSize), v)
+	}
+	return chunkSize, nil
+}
+
+// needsPrevValues returns true if the changefeed options require the previous
+// value of each changed row, either to emit it (diff, delete_full_row,
+// envelope=debezium) or to
+// determine which columns changed (sparse_updates, suppress_no_op_updates), whether a deleted row
+// had expired (ttl_deletes), which topic a deleted row is routed to
+// (topic_from_column) or whether a row was inserted (rekey).
+func needsPrevValues(opts map[string]string) bool {
+	_, withDiff := opts[changefeedbase.OptDiff]
+	_, sparseUpdates := opts[changefeedbase.OptSparseUpdates]
+	_, ttlDeletes := opts[changefeedbase.OptTTLDeletes]
+	_, topicFromColumn := opts[changefeedbase.OptTopicFromColumn]
+	_, suppressNoOpUpdates := opts[changefeedbase.OptSuppressNoOpUpdates]
+	_, rekey := opts[changefeedbase.OptRekey]
+	_, watchColumns := opts[changefeedbase.OptWatchColumns]
+	_, statementTag := opts[changefeedbase.OptStatementTag]
+	_, deleteFullRow := opts[changefeedbase.OptDeleteFullRow]
+	debezium := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeDebezium
+	return withDiff || sparseUpdates || ttlDeletes || topicFromColumn || suppressNoOpUpdates || rekey ||
+		watchColumns || statementTag || deleteFullRow || debezium
 }
 
 // getKVFeedInitialParameters determines the starting timestamp for the kv and
@@ -397,9 +672,30 @@ func getKVFeedInitialParameters(
 	if needsInitialScan = initialHighWater.IsEmpty(); needsInitialScan {
 		initialHighWater = spec.Feed.StatementTime
 	}
+	// A resync requested via ALTER CHANGEFEED ... RESYNC, or the addition of
+	// targets via ALTER CHANGEFEED ... ADD, is performed as an initial scan at
+	// the high-water recorded by the ALTER. In the latter case the job's
+	// checkpoint covers the pre-existing targets, so only the new targets are
+	// scanned. Once the high-water advances past that timestamp, the scan has
+	// completed.
+	for _, opt := range []string{changefeedbase.ResyncTimestamp, changefeedbase.BackfillTimestamp} {
+		if ts := timestampOption(spec.Feed, opt); !ts.IsEmpty() && ts.Equal(initialHighWater) {
+			needsInitialScan = true
+		}
+	}
 	return initialHighWater, needsInitialScan
 }
 
+// timestampOption returns the timestamp stored in the given internal option,
+// or an empty timestamp if there is none.
+func timestampOption(details jobspb.ChangefeedDetails, opt string) hlc.Timestamp {
+	ts, err := hlc.ParseTimestamp(details.Opts[opt])
+	if err != nil {
+		return hlc.Timestamp{}
+	}
+	return ts
+}
+
 // setupSpans is called on start to extract the spans for this changefeed as a
 // slice and creates a span frontier with the initial resolved timestampsc. This
 // SpanFrontier only tracks the spans being watched on this node. There is a
@@ -457,6 +753,14 @@ func (ca *changeAggregator) close() {
 			log.Warningf(ca.Ctx, `error closing sink. goroutines may have leaked: %v`, err)
 		}
 	}
+	if ca.rekeys != nil {
+		ca.rekeys.close(ca.Ctx)
+	}
+	if ca.families != nil {
+		ca.families.close(ca.Ctx)
+	}
+	closeSchemaRegistries(ca.encoder)
+	ca.tableMetrics.release()
 
 	ca.memAcc.Close(ca.Ctx)
 	if ca.kvFeedMemMon != nil {
@@ -520,21 +824,93 @@ func (ca *changeAggregator) tick() error {
 		if event.BackfillTimestamp().IsEmpty() {
 			ca.sliMetrics.AdmitLatency.RecordValue(timeutil.Since(event.Timestamp().GoTime()).Nanoseconds())
 		}
-		return ca.eventConsumer.ConsumeEvent(ca.Ctx, event)
+		size := event.ApproximateSize()
+		if err := ca.eventConsumer.ConsumeEvent(ca.Ctx, event); err != nil {
+			return err
+		}
+		ca.bufferedRows++
+		ca.bufferedBytes += int64(size)
+		return ca.maybeFlushBuffer()
 	case kvevent.TypeResolved:
 		a := event.DetachAlloc()
 		a.Release(ca.Ctx)
+		if err := ca.maybeFlushBuffer(); err != nil {
+			return err
+		}
 		resolved := event.Resolved()
 		if ca.knobs.ShouldSkipResolved == nil || !ca.knobs.ShouldSkipResolved(resolved) {
+			if ca.laggingSink != nil {
+				if resolved, err = ca.lagResolvedSpan(resolved); err != nil {
+					return err
+				}
+			}
 			return ca.noteResolvedSpan(resolved)
 		}
 	case kvevent.TypeFlush:
-		return ca.sink.Flush(ca.Ctx)
+		return ca.flushBuffer()
 	}
 
 	return nil
 }
 
+// maybeFlushBuffer flushes the sink if the rows read from the buffer since it
+// was last flushed are due to be flushed by the buffer flush options.
+func (ca *changeAggregator) maybeFlushBuffer() error {
+	if !ca.flushPolicy.shouldFlush(
+		ca.bufferedRows, ca.bufferedBytes, timeutil.Since(ca.lastBufferFlush)) {
+		return nil
+	}
+	return ca.flushBuffer()
+}
+
+// flushBuffer flushes the sink to hand off the rows read from the buffer,
+// either per the buffer flush options or because the buffer is out of memory.
+func (ca *changeAggregator) flushBuffer() error {
+	if err := ca.sink.Flush(ca.Ctx); err != nil {
+		return err
+	}
+	ca.metrics.BufferFlushes.Inc(1)
+	ca.metrics.BufferFlushRows.RecordValue(ca.bufferedRows)
+	ca.noteSinkFlushed()
+	return nil
+}
+
+// noteSinkFlushed resets the count of the rows read from the buffer once the
+// sink has been flushed.
+func (ca *changeAggregator) noteSinkFlushed() {
+	ca.bufferedRows, ca.bufferedBytes = 0, 0
+	ca.lastBufferFlush = timeutil.Now()
+}
+
+// lagResolvedSpan emits the rows held back by the watermark_lag option which
+// have aged past the lag, or, outside of the window of the emit_window option,
+// which were committed before it last closed, and holds the resolved span back
+// to match. Schema change boundaries are not held back, since the changefeed
+// cannot make progress past them until they are resolved.
+func (ca *changeAggregator) lagResolvedSpan(
+	resolved *jobspb.ResolvedSpan,
+) (*jobspb.ResolvedSpan, error) {
+	if resolved.BoundaryType == jobspb.ResolvedSpan_NONE {
+		now := ca.flowCtx.Cfg.DB.Clock().PhysicalTime()
+		cutoff := hlc.Timestamp{WallTime: now.UnixNano() - ca.watermarkLag.Nanoseconds()}
+		if ca.emitWindow != nil && !ca.emitWindow.contains(now) {
+			closed := hlc.Timestamp{WallTime: ca.emitWindow.lastClose(now).UnixNano()}
+			if closed.Less(cutoff) {
+				cutoff = closed
+			}
+		}
+		if cutoff.Less(resolved.Timestamp) {
+			lagged := *resolved
+			lagged.Timestamp = cutoff
+			resolved = &lagged
+		}
+	}
+	if err := ca.laggingSink.release(ca.Ctx, resolved.Timestamp); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
 // noteResolvedSpan periodically flushes Frontier progress from the current
 // changeAggregator node to the changeFrontier node to allow the changeFrontier
 // to persist the overall changefeed's progress
@@ -546,9 +922,39 @@ func (ca *changeAggregator) noteResolvedSpan(resolved *jobspb.ResolvedSpan) erro
 
 	forceFlush := resolved.BoundaryType != jobspb.ResolvedSpan_NONE
 
+	if advanced && ca.rekeys != nil {
+		if err := ca.rekeys.release(ca.Ctx, ca.frontier.Frontier()); err != nil {
+			return err
+		}
+	}
+	if advanced && ca.dedupSink != nil {
+		ca.dedupSink.release(ca.Ctx, ca.frontier.Frontier())
+	}
+	if advanced && ca.families != nil {
+		ca.families.release(ca.Ctx, ca.frontier.Frontier())
+	}
+
+	if advanced && ca.rangeFreshness {
+		if err := ca.maybeEmitRangeResolved(); err != nil {
+			return err
+		}
+	}
+
 	checkpointFrontier := advanced &&
 		(forceFlush || timeutil.Since(ca.lastFlush) > ca.flushFrequency)
 
+	// The boundary at the initial high-water which ends an initial_scan_only
+	// changefeed doesn't advance the frontier, but must still be forwarded.
+	if forceFlush && ca.initialScanOnly {
+		checkpointFrontier = true
+	}
+
+	// The frontier must hear from the aggregators to emit heartbeats, even if
+	// their frontiers are stalled.
+	if ca.heartbeat > 0 && timeutil.Since(ca.lastFlush) > ca.heartbeat {
+		checkpointFrontier = true
+	}
+
 	// If backfilling we must also consider the Backfill Checkpointing frequency
 	checkpointBackfill := ca.spec.JobID != 0 && /* enterprise changefeed */
 		resolved.Timestamp.Equal(ca.frontier.BackfillTS()) &&
@@ -564,15 +970,51 @@ func (ca *changeAggregator) noteResolvedSpan(resolved *jobspb.ResolvedSpan) erro
 	return nil
 }
 
+// maybeEmitRangeResolved emits the frontier of the aggregator as a resolved
+// timestamp, for freshness=range. It only covers the spans watched by the
+// aggregator: the resolved timestamps of the other aggregators may be behind.
+func (ca *changeAggregator) maybeEmitRangeResolved() error {
+	newResolved := ca.frontier.Frontier()
+	if ca.freqEmitResolved == emitNoResolved || newResolved.IsEmpty() ||
+		!ca.lastResolved.Less(newResolved) {
+		return nil
+	}
+	if !ca.lastResolved.IsEmpty() &&
+		newResolved.GoTime().Sub(ca.lastResolved.GoTime()) < ca.freqEmitResolved &&
+		!ca.frontier.schemaChangeBoundaryReached() {
+		return nil
+	}
+	encoder := makeResolvedEncoder(ca.encoder, ca.lastResolved, ca.frontier.SpanFrontier())
+	// As with the changeFrontier, every row at or below the resolved timestamp
+	// must be flushed before it, and it must not linger in the sink after.
+	if err := ca.sink.Flush(ca.Ctx); err != nil {
+		return err
+	}
+	ca.noteSinkFlushed()
+	if err := emitResolvedTimestamp(ca.Ctx, encoder, ca.sink, newResolved); err != nil {
+		return err
+	}
+	if err := ca.sink.Flush(ca.Ctx); err != nil {
+		return err
+	}
+	ca.lastResolved = newResolved
+	return nil
+}
+
 // flushFrontier flushes sink and emits resolved timestamp if needed.
 func (ca *changeAggregator) flushFrontier() error {
 	// Make sure to flush the sink before forwarding resolved spans,
 	// otherwise, we could lose buffered messages and violate the
 	// at-least-once guarantee. This is also true for checkpointing the
 	// resolved spans in the job progress.
-	if err := ca.sink.Flush(ca.Ctx); err != nil {
+	if ca.durableResolved {
+		if err := flushDurable(ca.Ctx, ca.sink); err != nil {
+			return err
+		}
+	} else if err := ca.sink.Flush(ca.Ctx); err != nil {
 		return err
 	}
+	ca.noteSinkFlushed()
 
 	// Iterate frontier spans and build a list of spans to emit.
 	var batch jobspb.ResolvedSpans
@@ -589,6 +1031,14 @@ func (ca *changeAggregator) flushFrontier() error {
 		})
 		return span.ContinueMatch
 	})
+	// The rows counted so far have been flushed, so their counts can be
+	// checkpointed along with the resolved spans.
+	if len(batch.ResolvedSpans) > 0 {
+		batch.ResolvedSpans[0].EmittedByTable = ca.tableMetrics.takePending()
+		if ca.sequencedSink != nil {
+			batch.ResolvedSpans[0].Sequences = ca.sequencedSink.flushedSequences()
+		}
+	}
 
 	return ca.emitResolved(batch)
 }
@@ -634,6 +1084,77 @@ type kvEventToRowConsumer struct {
 	rfCache   *rowFetcherCache
 	details   jobspb.ChangefeedDetails
 	kvFetcher row.SpanKVFetcher
+
+	// deadLetters, if set, receives the rows which cannot be encoded instead
+	// of failing the changefeed.
+	deadLetters *deadLetterSink
+
+	// rekeys, if set, holds the rows back to emit primary key changes as
+	// rekey events, instead of emitting them to sink.
+	rekeys *rekeyBuffer
+
+	// families, if set, collapses the KVs of the families of each row
+	// version of tables with multiple column families into a single row.
+	families *familyCollapser
+
+	// resyncTS is the timestamp of an in-progress resync. Rows scanned at this
+	// timestamp are tagged as snapshot rows.
+	resyncTS hlc.Timestamp
+
+	// rangeInfo, if set, is used to look up the range and leaseholder of each
+	// row for the range_info and provenance options.
+	rangeInfo *rangeInfoCache
+
+	// emitterInstanceID, if set, is the SQL instance of the change aggregator,
+	// added to each row for the provenance option.
+	emitterInstanceID base.SQLInstanceID
+
+	// tableMetrics, if set, counts the rows emitted for each table.
+	tableMetrics *tableMetrics
+
+	// views, if set, projects the rows of the tables watched for views into
+	// rows of the views.
+	views *viewProjector
+
+	// excludedColumnTypes, if set, drops the columns of the types excluded
+	// by the exclude_column_types option from the rows.
+	excludedColumnTypes *columnTypeExcluder
+
+	// columnDefaults, if set, materializes the default values of columns
+	// which were added after a row was written, for the materialize_defaults
+	// option.
+	columnDefaults *columnDefaults
+
+	// topicRouter, if set, routes each row to the topic named after the value
+	// of the column of the topic_from_column option.
+	topicRouter *columnTopicRouter
+
+	// shardRouter, if set, routes the rows of tables with hash-sharded
+	// primary keys by their logical keys, for the shard_aware_routing option.
+	shardRouter *shardAwareRouter
+
+	// rowHash, if set, adds the hash of each row to its value. See
+	// appendRowHash.
+	rowHash bool
+
+	// suppressNoOpUpdates, if set, drops the updates which didn't change the
+	// row. See isNoOpUpdate.
+	suppressNoOpUpdates bool
+
+	// compactionTombstones, if set, emits deletes with a nil value, which the
+	// Kafka sink produces as records without a value, whatever the format and
+	// envelope, so that log compaction removes their keys.
+	compactionTombstones bool
+
+	// watchColumns, if set, are the columns of the watch_columns option:
+	// updates which change none of them are dropped.
+	watchColumns watchColumns
+	evalCtx      *tree.EvalContext
+	watchAlloc   tree.DatumAlloc
+
+	// epoch is the epoch of the changefeed's run, added to each row for the
+	// changefeed_epoch option.
+	epoch int64
 }
 
 var _ kvEventConsumer = &kvEventToRowConsumer{}
@@ -644,8 +1165,14 @@ func newKVEventToRowConsumer(
 	frontier *span.Frontier,
 	cursor hlc.Timestamp,
 	sink Sink,
+	deadLetters *deadLetterSink,
+	rekeys *rekeyBuffer,
+	families *familyCollapser,
 	encoder Encoder,
 	details jobspb.ChangefeedDetails,
+	epoch int64,
+	tableMetrics *tableMetrics,
+	evalCtx *tree.EvalContext,
 	knobs TestingKnobs,
 ) kvEventConsumer {
 	rfCache := newRowFetcherCache(
@@ -656,15 +1183,71 @@ func newKVEventToRowConsumer(
 		cfg.DB,
 	)
 
-	return &kvEventToRowConsumer{
-		frontier: frontier,
-		encoder:  encoder,
-		sink:     sink,
-		cursor:   cursor,
-		rfCache:  rfCache,
-		details:  details,
-		knobs:    knobs,
+	c := &kvEventToRowConsumer{
+		frontier:     frontier,
+		encoder:      encoder,
+		sink:         sink,
+		deadLetters:  deadLetters,
+		rekeys:       rekeys,
+		families:     families,
+		cursor:       cursor,
+		rfCache:      rfCache,
+		details:      details,
+		epoch:        epoch,
+		tableMetrics: tableMetrics,
+		knobs:        knobs,
+	}
+	if resyncTS := timestampOption(details, changefeedbase.ResyncTimestamp); resyncTS.Equal(cursor) {
+		c.resyncTS = resyncTS
+	}
+	if _, ok := details.Opts[changefeedbase.OptRangeInfo]; ok {
+		c.rangeInfo = &rangeInfoCache{rangeCache: cfg.RangeCache}
+	}
+	if _, ok := details.Opts[changefeedbase.OptProvenance]; ok {
+		c.rangeInfo = &rangeInfoCache{rangeCache: cfg.RangeCache}
+		c.emitterInstanceID = cfg.NodeID.SQLInstanceID()
+	}
+	c.views = makeViewProjector(details.Targets)
+	if v, ok := details.Opts[changefeedbase.OptExcludeColumnTypes]; ok {
+		// The option was validated when the changefeed was created.
+		excluded, _ := parseExcludedColumnTypes(v)
+		c.excludedColumnTypes = makeColumnTypeExcluder(excluded)
+	}
+	if _, ok := details.Opts[changefeedbase.OptMaterializeDefaults]; ok {
+		c.columnDefaults = makeColumnDefaults(rfCache, evalCtx)
+	}
+	if column, ok := details.Opts[changefeedbase.OptTopicFromColumn]; ok {
+		// The option was validated when the changefeed was created.
+		maxTopics, _ := getTopicFromColumnMaxTopics(details.Opts)
+		c.topicRouter = makeColumnTopicRouter(column, maxTopics)
+	}
+	if _, ok := details.Opts[changefeedbase.OptShardAwareRouting]; ok {
+		c.shardRouter = &shardAwareRouter{}
+	}
+	_, c.rowHash = details.Opts[changefeedbase.OptRowHash]
+	_, c.suppressNoOpUpdates = details.Opts[changefeedbase.OptSuppressNoOpUpdates]
+	_, c.compactionTombstones = details.Opts[changefeedbase.OptCompactionTombstones]
+	if v, ok := details.Opts[changefeedbase.OptWatchColumns]; ok {
+		// The option was validated when the changefeed was created.
+		c.watchColumns, _ = parseWatchColumns(v)
+		c.evalCtx = evalCtx
+	}
+	return c
+}
+
+// isNoOpUpdate returns whether the row is an update which wrote the same value
+// as the previous version of the row, as an UPDATE which sets the columns of
+// a row to their current values does. The values are compared as encoded, so
+// an update is only suppressed if the previous version of the row is
+// identical, and was written under the same version of the table, since the
+// columns a value decodes to depend on it. Rows scanned by initial scans and
+// backfills aren't updates.
+func isNoOpUpdate(r encodeRow, ev kvevent.Event) bool {
+	if r.backfill || r.deleted || r.prevDeleted || r.prevTableDesc == nil ||
+		r.prevTableDesc.GetVersion() != r.tableDesc.GetVersion() {
+		return false
 	}
+	return bytes.Equal(ev.KV().Value.TagAndDataBytes(), ev.PrevValue().TagAndDataBytes())
 }
 
 type tableDescriptorTopic struct {
@@ -673,13 +1256,69 @@ type tableDescriptorTopic struct {
 
 var _ TopicDescriptor = &tableDescriptorTopic{}
 
+// rangeInfoRefreshInterval bounds how stale the range and leaseholder of rows
+// looked up by a rangeInfoCache may be after splits and lease transfers.
+const rangeInfoRefreshInterval = time.Second
+
+// rangeInfoCache looks up the range and leaseholder of rows in the range
+// cache, remembering the descriptor of the last range looked up, so that the
+// rows of a range, which its rangefeed delivers together, don't each search
+// the range cache.
+type rangeInfoCache struct {
+	rangeCache *rangecache.RangeCache
+	// desc is the descriptor of the last range looked up, whose leaseholder
+	// was leaseholder when it was looked up at refreshed.
+	desc        roachpb.RangeDescriptor
+	leaseholder roachpb.NodeID
+	refreshed   time.Time
+}
+
+// lookup returns the range of the key and its leaseholder, if known.
+func (c *rangeInfoCache) lookup(
+	ctx context.Context, key roachpb.RKey,
+) (roachpb.RangeID, roachpb.NodeID, error) {
+	if c.desc.RangeID != 0 && c.desc.ContainsKey(key) &&
+		timeutil.Since(c.refreshed) < rangeInfoRefreshInterval {
+		return c.desc.RangeID, c.leaseholder, nil
+	}
+	// The rangefeed of the key's range has populated the cache, so this is not
+	// expected to require a lookup of the range descriptor.
+	entry, err := c.rangeCache.Lookup(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.desc = *entry.Desc()
+	c.leaseholder = 0
+	if leaseholder := entry.Leaseholder(); leaseholder != nil {
+		c.leaseholder = leaseholder.NodeID
+	}
+	c.refreshed = timeutil.Now()
+	return c.desc.RangeID, c.leaseholder, nil
+}
+
 // ConsumeEvent implements kvEventConsumer interface
 func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Event) error {
 	if ev.Type() != kvevent.TypeKV {
 		return errors.AssertionFailedf("expected kv ev, got %v", ev.Type())
 	}
 
-	r, err := c.eventToRow(ctx, ev)
+	var collapsed *collapsedRow
+	if c.families != nil {
+		var ok bool
+		var err error
+		collapsed, ok, err = c.collapseFamilies(ctx, ev)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Another family of the row version was emitted as the whole row.
+			a := ev.DetachAlloc()
+			a.Release(ctx)
+			return nil
+		}
+	}
+
+	r, err := c.eventToRow(ctx, ev, collapsed)
 	if err != nil {
 		return err
 	}
@@ -695,46 +1334,153 @@ func (c *kvEventToRowConsumer) ConsumeEvent(ctx context.Context, ev kvevent.Even
 			"or equal to the local frontier %s.", r.updated, c.frontier.Frontier())
 		return nil
 	}
+	if c.suppressNoOpUpdates && isNoOpUpdate(r, ev) {
+		a := ev.DetachAlloc()
+		a.Release(ctx)
+		return nil
+	}
+	if c.watchColumns != nil {
+		changed, err := c.watchColumns.changed(r, c.evalCtx, &c.watchAlloc)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			a := ev.DetachAlloc()
+			a.Release(ctx)
+			return nil
+		}
+	}
 	var keyCopy, valueCopy []byte
+	encodeStart := timeutil.Now()
 	encodedKey, err := c.encoder.EncodeKey(ctx, r)
 	if err != nil {
-		return err
+		c.tableMetrics.recordEncoded(r.tableDesc.GetID(), 0, err)
+		return c.maybeDeadLetter(ctx, r, err, ev)
 	}
 	c.scratch, keyCopy = c.scratch.Copy(encodedKey, 0 /* extraCap */)
-	encodedValue, err := c.encoder.EncodeValue(ctx, r)
-	if err != nil {
-		return err
+	if c.compactionTombstones && r.deleted {
+		// The value of a tombstone is left nil, rather than copied, which
+		// would make it empty.
+		c.tableMetrics.recordEncoded(r.tableDesc.GetID(), timeutil.Since(encodeStart), nil)
+	} else {
+		encodedValue, err := c.encoder.EncodeValue(ctx, r)
+		c.tableMetrics.recordEncoded(r.tableDesc.GetID(), timeutil.Since(encodeStart), err)
+		if err != nil {
+			return c.maybeDeadLetter(ctx, r, err, ev)
+		}
+		c.scratch, valueCopy = c.scratch.Copy(encodedValue, 0 /* extraCap */)
+	}
+	if c.rowHash {
+		valueCopy = appendRowHash(keyCopy, valueCopy)
+	}
+
+	var topic TopicDescriptor = tableDescriptorTopic{r.tableDesc}
+	if c.topicRouter != nil {
+		if topic, err = c.topicRouter.topicFor(r); err != nil {
+			return c.maybeDeadLetter(ctx, r, err, ev)
+		}
+	}
+	if c.shardRouter != nil {
+		if topic, err = c.shardRouter.route(r, topic); err != nil {
+			return err
+		}
 	}
-	c.scratch, valueCopy = c.scratch.Copy(encodedValue, 0 /* extraCap */)
 
 	if c.knobs.BeforeEmitRow != nil {
 		if err := c.knobs.BeforeEmitRow(ctx); err != nil {
 			return err
 		}
 	}
-	if err := c.sink.EmitRow(
-		ctx, tableDescriptorTopic{r.tableDesc},
-		keyCopy, valueCopy, r.updated, r.mvccTimestamp, ev.DetachAlloc(),
-	); err != nil {
+	if c.rekeys != nil {
+		kind := rekeyKindOf(r)
+		var values string
+		if values, err = rekeyValues(r, kind); err != nil {
+			return err
+		}
+		err = c.rekeys.emitRow(
+			ctx, topic, r.tableDesc.GetID(), kind, values,
+			keyCopy, valueCopy, r.updated, r.mvccTimestamp, ev.DetachAlloc(),
+		)
+	} else {
+		err = c.sink.EmitRow(
+			ctx, topic,
+			keyCopy, valueCopy, r.updated, r.mvccTimestamp, ev.DetachAlloc(),
+		)
+	}
+	if err != nil {
 		return err
 	}
+	c.tableMetrics.recordEmitted(r.tableDesc.GetID(), len(keyCopy)+len(valueCopy))
 	if log.V(3) {
 		log.Infof(ctx, `r %s: %s -> %s`, r.tableDesc.GetName(), keyCopy, valueCopy)
 	}
 	return nil
 }
 
+// maybeDeadLetter emits a row which failed to be encoded or routed with
+// encodeErr to the dead letter sink, if there is one and the error was caused
+// by the row, and otherwise returns encodeErr.
+func (c *kvEventToRowConsumer) maybeDeadLetter(
+	ctx context.Context, r encodeRow, encodeErr error, ev kvevent.Event,
+) error {
+	if c.deadLetters == nil {
+		return encodeErr
+	}
+	reason, ok := deadLetterReason(encodeErr)
+	if !ok {
+		return encodeErr
+	}
+	log.Warningf(ctx, "emitting row of %s to dead letter sink: %v", r.tableDesc.GetName(), encodeErr)
+	if err := c.deadLetters.emitDeadLetter(ctx, r, reason, encodeErr, ev.DetachAlloc()); err != nil {
+		return changefeedbase.MarkRetryableError(err)
+	}
+	return nil
+}
+
+// collapseFamilies returns the whole row version of which ev is a KV, if ev is
+// a KV of a table with multiple column families, for the collapse_families
+// option. It returns false if ev is to be dropped, the row version having
+// already been emitted.
+func (c *kvEventToRowConsumer) collapseFamilies(
+	ctx context.Context, ev kvevent.Event,
+) (*collapsedRow, bool, error) {
+	ts := ev.KV().Value.Timestamp
+	backfillTs := ev.BackfillTimestamp()
+	if !backfillTs.IsEmpty() {
+		ts = backfillTs
+	}
+	desc, err := c.rfCache.TableDescForKey(ctx, ev.KV().Key, ts)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(desc.GetFamilies()) == 1 {
+		return nil, true, nil
+	}
+	// Rows scanned by backfills have no previous values.
+	opts := changefeedbase.OptionsForTarget(c.details.Opts, c.details.Targets[desc.GetID()])
+	withPrev := needsPrevValues(opts) && backfillTs.IsEmpty()
+	return c.families.collapse(ctx, ev, ts, withPrev)
+}
+
+// eventToRow decodes the row of which event is a KV. If collapsed is set, the
+// row, and its previous version, are decoded from its KVs instead, which
+// include those of every column family of the row.
 func (c *kvEventToRowConsumer) eventToRow(
-	ctx context.Context, event kvevent.Event,
+	ctx context.Context, event kvevent.Event, collapsed *collapsedRow,
 ) (encodeRow, error) {
 	var r encodeRow
 	schemaTimestamp := event.KV().Value.Timestamp
 	prevSchemaTimestamp := schemaTimestamp
 	mvccTimestamp := event.MVCCTimestamp()
+	if collapsed != nil {
+		mvccTimestamp = collapsed.mvcc
+	}
 
 	if backfillTs := event.BackfillTimestamp(); !backfillTs.IsEmpty() {
 		schemaTimestamp = backfillTs
 		prevSchemaTimestamp = schemaTimestamp.Prev()
+		r.snapshot = !c.resyncTS.IsEmpty() && backfillTs.Equal(c.resyncTS)
+		r.backfill = true
 	}
 
 	desc, err := c.rfCache.TableDescForKey(ctx, event.KV().Key, schemaTimestamp)
@@ -749,10 +1495,13 @@ func (c *kvEventToRowConsumer) eventToRow(
 	}
 
 	// Get new value.
-	// TODO(dan): Handle tables with multiple column families.
 	// Reuse kvs to save allocations.
 	c.kvFetcher.KVs = c.kvFetcher.KVs[:0]
-	c.kvFetcher.KVs = append(c.kvFetcher.KVs, event.KV())
+	if collapsed != nil {
+		c.kvFetcher.KVs = collapsed.appendKVs(c.kvFetcher.KVs)
+	} else {
+		c.kvFetcher.KVs = append(c.kvFetcher.KVs, event.KV())
+	}
 	if err := rf.StartScanFrom(ctx, &c.kvFetcher, false /* traceKV */); err != nil {
 		return r, err
 	}
@@ -768,9 +1517,45 @@ func (c *kvEventToRowConsumer) eventToRow(
 	r.deleted = rf.RowIsDeleted()
 	r.updated = schemaTimestamp
 	r.mvccTimestamp = mvccTimestamp
+	r.valueSize = len(event.KV().Value.RawBytes)
+	if collapsed != nil {
+		r.valueSize = kvsValueSize(collapsed.kvs)
+	}
+	if r.deleted {
+		// The tombstone is empty: the size of the deleted value is only known
+		// if previous values are fetched.
+		r.valueSize = -1
+		if collapsed != nil {
+			if collapsed.prevRead {
+				r.valueSize = kvsValueSize(collapsed.prevKVs)
+			}
+		} else if prev := event.PrevValue(); prev.IsPresent() {
+			r.valueSize = len(prev.RawBytes)
+		}
+	}
+
+	// Rows scanned by a backfill may have been written by an older version of
+	// the table than the one they are decoded with.
+	if c.columnDefaults != nil {
+		if err := c.columnDefaults.materialize(ctx, &r, event.KV().Key, mvccTimestamp); err != nil {
+			return r, err
+		}
+	}
+
+	if c.rangeInfo != nil {
+		rKey, err := keys.Addr(event.KV().Key)
+		if err != nil {
+			return r, err
+		}
+		if r.rangeID, r.leaseholderNodeID, err = c.rangeInfo.lookup(ctx, rKey); err != nil {
+			return r, err
+		}
+	}
+	r.emitterInstanceID = c.emitterInstanceID
+	r.epoch = c.epoch
 
 	// Assert that we don't get a second row from the row.Fetcher. We
-	// fed it a single KV, so that would be surprising.
+	// fed it the KVs of a single row, so that would be surprising.
 	nextRow := encodeRow{
 		tableDesc: desc,
 	}
@@ -783,8 +1568,8 @@ func (c *kvEventToRowConsumer) eventToRow(
 	}
 
 	// Get prev value, if necessary.
-	_, withDiff := c.details.Opts[changefeedbase.OptDiff]
-	if withDiff {
+	opts := changefeedbase.OptionsForTarget(c.details.Opts, c.details.Targets[desc.GetID()])
+	if needsPrevValues(opts) {
 		prevRF := rf
 		r.prevTableDesc = r.tableDesc
 		if prevSchemaTimestamp != schemaTimestamp {
@@ -803,11 +1588,14 @@ func (c *kvEventToRowConsumer) eventToRow(
 			}
 		}
 
-		prevKV := roachpb.KeyValue{Key: event.KV().Key, Value: event.PrevValue()}
-		// TODO(dan): Handle tables with multiple column families.
 		// Reuse kvs to save allocations.
 		c.kvFetcher.KVs = c.kvFetcher.KVs[:0]
-		c.kvFetcher.KVs = append(c.kvFetcher.KVs, prevKV)
+		if collapsed != nil {
+			c.kvFetcher.KVs = collapsed.appendPrevKVs(c.kvFetcher.KVs)
+		} else {
+			prevKV := roachpb.KeyValue{Key: event.KV().Key, Value: event.PrevValue()}
+			c.kvFetcher.KVs = append(c.kvFetcher.KVs, prevKV)
+		}
 		if err := prevRF.StartScanFrom(ctx, &c.kvFetcher, false /* traceKV */); err != nil {
 			return r, err
 		}
@@ -822,7 +1610,7 @@ func (c *kvEventToRowConsumer) eventToRow(
 		r.prevDeleted = prevRF.RowIsDeleted()
 
 		// Assert that we don't get a second row from the row.Fetcher. We
-		// fed it a single KV, so that would be surprising.
+		// fed it the KVs of a single row, so that would be surprising.
 		nextRow := encodeRow{
 			prevTableDesc: r.prevTableDesc,
 		}
@@ -835,9 +1623,54 @@ func (c *kvEventToRowConsumer) eventToRow(
 		}
 	}
 
+	if _, ok := opts[changefeedbase.OptTTLDeletes]; ok && r.deleted {
+		if r.ttlExpired, err = ttlExpired(r); err != nil {
+			return r, err
+		}
+	}
+	if _, ok := opts[changefeedbase.OptIdempotencyToken]; ok {
+		if r.idempotencyToken, err = makeIdempotencyToken(event.KV().Key, mvccTimestamp); err != nil {
+			return r, err
+		}
+	}
+
+	if c.views != nil {
+		if err := c.views.project(&r); err != nil {
+			return r, err
+		}
+	}
+	if c.excludedColumnTypes != nil {
+		if err := c.excludedColumnTypes.exclude(&r); err != nil {
+			return r, err
+		}
+	}
+
 	return r, nil
 }
 
+// ttlExpired returns whether the row deleted by r had expired under the
+// row-level TTL of its table when it was deleted. There is no record of what
+// issued a delete at the KV layer, but the TTL job only deletes expired rows,
+// and users rarely do, so such deletes are attributed to the TTL job.
+func ttlExpired(r encodeRow) (bool, error) {
+	if r.prevDeleted || r.prevTableDesc == nil || r.prevTableDesc.GetRowLevelTTL() == nil {
+		return false, nil
+	}
+	for i, col := range r.prevTableDesc.PublicColumns() {
+		if col.GetName() != colinfo.TTLDefaultExpirationColumnName {
+			continue
+		}
+		var alloc tree.DatumAlloc
+		datum := r.prevDatums[i]
+		if err := datum.EnsureDecoded(col.GetType(), &alloc); err != nil {
+			return false, err
+		}
+		expiration, ok := datum.Datum.(*tree.DTimestampTZ)
+		return ok && !expiration.After(r.mvccTimestamp.GoTime()), nil
+	}
+	return false, nil
+}
+
 type nativeKVConsumer struct {
 	sink Sink
 }
@@ -904,11 +1737,43 @@ type changeFrontier struct {
 	// sink is the Sink to write resolved timestamps to. Rows are never written
 	// by changeFrontier.
 	sink Sink
+	// sequencedSink, if set, is the sink wrapped by sink which numbers the
+	// resolved timestamps it emits, with the sequence_numbers option.
+	sequencedSink sequencedSink
 	// freqEmitResolved, if >= 0, is a lower bound on the duration between
 	// resolved timestamp emits.
 	freqEmitResolved time.Duration
 	// lastEmitResolved is the last time a resolved timestamp was emitted.
 	lastEmitResolved time.Time
+	// resolvedMinRows, if non-zero, is the number of rows the change
+	// aggregators must have flushed since the last resolved timestamp was
+	// emitted for the next one to be, unless resolvedMaxInterval has elapsed
+	// since, with the resolved_min_rows option. rowsSinceResolved is the
+	// number of rows flushed since the last resolved timestamp was emitted.
+	resolvedMinRows     int64
+	resolvedMaxInterval time.Duration
+	rowsSinceResolved   int64
+	// lastResolved is the last resolved timestamp emitted, or the high-water
+	// of the changefeed when it was started if none has been emitted since.
+	lastResolved hlc.Timestamp
+	// heartbeat, if non-zero, is the interval at which a resolved timestamp
+	// is emitted even if the frontier hasn't advanced. lastHeartbeat is the
+	// wall time at which a resolved timestamp was last emitted, and
+	// heartbeatResolved is the latest resolved timestamp which may be
+	// emitted, which is the frontier as of the last checkpoint.
+	heartbeat         time.Duration
+	lastHeartbeat     time.Time
+	heartbeatResolved hlc.Timestamp
+	// initialScanOnly is set if the changefeed completes, rather than fails,
+	// upon reaching the EXIT boundary at the end of its initial scan.
+	initialScanOnly bool
+	// rangeFreshness is set with freshness=range, with which the change
+	// aggregators emit resolved timestamps rather than the changeFrontier.
+	rangeFreshness bool
+	// durableResolved is set with the durable_resolved option, with which the
+	// high-water is only checkpointed once the sink has durably accepted the
+	// messages emitted below it.
+	durableResolved bool
 
 	// slowLogEveryN rate-limits the logging of slow spans
 	slowLogEveryN log.EveryN
@@ -927,19 +1792,43 @@ type changeFrontier struct {
 	// resolved timestamp to be returned. It depends on everything in
 	// `passthroughBuf` being sent, so that one needs to be emptied first.
 	resolvedBuf *encDatumRowBuffer
+	// replayBuffer, if non-nil, retains the rows and resolved timestamps
+	// returned by a sinkless changefeed with the replay_buffer option.
+	replayBuffer *replayBuffer
 	// metrics are monitoring counters shared between all changefeeds.
 	metrics    *Metrics
 	sliMetrics *sliMetrics
 	// metricsID is used as the unique id of this changefeed in the
 	// metrics.MaxBehindNanos map.
 	metricsID int
+
+	// sinkUnreachable is set by the sink health check goroutine once
+	// sinkHealthCheckFailureThreshold consecutive health checks have failed,
+	// and cleared once a health check succeeds.
+	sinkUnreachable syncutil.AtomicBool
+	// cancelHealthCheck stops the sink health check goroutine;
+	// healthCheckDoneCh is closed when it exits.
+	cancelHealthCheck func()
+	healthCheckDoneCh chan struct{}
 }
 
 const (
 	runStatusUpdateFrequency time.Duration = time.Minute
 	slowSpanMaxFrequency                   = 10 * time.Second
+
+	// sinkHealthCheckFailureThreshold is the number of consecutive failed
+	// health checks after which the sink is considered unreachable.
+	sinkHealthCheckFailureThreshold = 3
+	// sinkHealthCheckDisabledPollInterval is how often the health check
+	// goroutine re-reads the health check interval setting while health
+	// checks are disabled.
+	sinkHealthCheckDisabledPollInterval = time.Minute
 )
 
+// sinkUnreachableStatus is included in the job running status while the sink
+// is unreachable.
+const sinkUnreachableStatus = "sink unreachable"
+
 // jobState encapsulates changefeed job state.
 type jobState struct {
 	job      *jobs.Job
@@ -955,6 +1844,14 @@ type jobState struct {
 	checkpointDuration time.Duration
 	// Flag set if we skip some updates due to rapid progress update requests.
 	progressUpdatesSkipped bool
+	// pendingEmitted holds the counts of the messages emitted for each table
+	// which have been forwarded by the change aggregators, but not yet added
+	// to the totals in the job progress.
+	pendingEmitted map[string]jobspb.ChangefeedTableStats
+	// pendingSequences holds the sequence numbers forwarded by the change
+	// aggregators which haven't been checkpointed yet, with the
+	// sequence_numbers option.
+	pendingSequences []jobspb.ChangefeedSequence
 }
 
 func newJobState(
@@ -1081,10 +1978,32 @@ func newChangeFrontierProcessor(
 	} else {
 		cf.freqEmitResolved = emitNoResolved
 	}
+	if r, ok := cf.spec.Feed.Opts[changefeedbase.OptHeartbeat]; ok {
+		var err error
+		if cf.heartbeat, err = time.ParseDuration(r); err != nil {
+			return nil, err
+		}
+	}
+	if cf.resolvedMinRows, cf.resolvedMaxInterval, err = getResolvedMinRows(cf.spec.Feed.Opts); err != nil {
+		return nil, err
+	}
+	_, cf.initialScanOnly = cf.spec.Feed.Opts[changefeedbase.OptInitialScanOnly]
+	cf.rangeFreshness = changefeedbase.Freshness(cf.spec.Feed.Opts[changefeedbase.OptFreshness]) ==
+		changefeedbase.OptFreshnessRange
+	_, cf.durableResolved = cf.spec.Feed.Opts[changefeedbase.OptDurableResolved]
 
-	if cf.encoder, err = getEncoder(spec.Feed.Opts, spec.Feed.Targets); err != nil {
+	// The frontier only encodes resolved timestamps, which are encoded with
+	// the changefeed's options regardless of the options of its targets.
+	if cf.encoder, err = getFeedEncoder(spec.Feed.Opts, spec.Feed.Targets); err != nil {
 		return nil, err
 	}
+	if _, ok := spec.Feed.Opts[changefeedbase.OptSourceCluster]; ok {
+		field, err := sourceClusterField(flowCtx.Cfg)
+		if err != nil {
+			return nil, err
+		}
+		setSourceCluster(cf.encoder, field)
+	}
 
 	return cf, nil
 }
@@ -1129,6 +2048,12 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 	if b, ok := cf.sink.(*bufferSink); ok {
 		cf.resolvedBuf = &b.buf
 	}
+	if _, ok := cf.spec.Feed.Opts[changefeedbase.OptSequenceNumbers]; ok {
+		cf.sequencedSink, _ = cf.sink.(sequencedSink)
+	}
+	if name, ok := cf.spec.Feed.Opts[changefeedbase.OptReplayBuffer]; ok && cf.isSinkless() {
+		cf.replayBuffer = sinklessReplayBuffers.get(makeReplayBufferKey(cf.spec.User(), cf.spec.Feed.Targets, name))
+	}
 
 	cf.sink = &errorWrapperSink{wrapped: cf.sink}
 
@@ -1156,6 +2081,8 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 		if ts := p.GetHighWater(); ts != nil {
 			cf.highWaterAtStart.Forward(*ts)
 			cf.frontier.initialHighWater = *ts
+			cf.lastResolved = *ts
+			cf.heartbeatResolved = *ts
 			for _, span := range cf.spec.TrackedSpans {
 				if _, err := cf.frontier.Forward(span, *ts); err != nil {
 					cf.MoveToDraining(err)
@@ -1164,6 +2091,14 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 			}
 		}
 
+		if cf.sequencedSink != nil {
+			var last []jobspb.ChangefeedSequence
+			if c := p.GetChangefeed(); c != nil {
+				last = c.Sequences
+			}
+			cf.sequencedSink.startSequences(frontierSequencer, last)
+		}
+
 		if p.RunningStatus != "" {
 			// If we had running status set, that means we're probably retrying
 			// due to a transient error.  In that case, keep the previous
@@ -1188,6 +2123,136 @@ func (cf *changeFrontier) Start(ctx context.Context) {
 		<-ctx.Done()
 		cf.closeMetrics()
 	}()
+
+	if cf.js != nil {
+		cf.startSinkHealthCheck(ctx)
+	}
+}
+
+// startSinkHealthCheck starts a goroutine which periodically checks that the
+// sink is reachable, independently of whether any rows are being emitted. The
+// result is reported via the changefeed.sink_connected metric, and recorded
+// in the job's progress and running status.
+func (cf *changeFrontier) startSinkHealthCheck(ctx context.Context) {
+	hc, ok := cf.sink.(SinkWithHealthCheck)
+	if !ok {
+		return
+	}
+	ctx, cf.cancelHealthCheck = context.WithCancel(ctx)
+	cf.healthCheckDoneCh = make(chan struct{})
+	if err := cf.flowCtx.Stopper().RunAsyncTask(ctx, "changefeed-sink-health", func(ctx context.Context) {
+		defer close(cf.healthCheckDoneCh)
+		cf.runSinkHealthCheck(ctx, hc)
+	}); err != nil {
+		// The closure never ran, so close the channel here so that
+		// (*changeFrontier).close doesn't hang.
+		close(cf.healthCheckDoneCh)
+	}
+}
+
+func (cf *changeFrontier) runSinkHealthCheck(ctx context.Context, hc SinkWithHealthCheck) {
+	// The sink was successfully dialed, so it starts out connected.
+	cf.sliMetrics.SinkConnected.Inc(1)
+	defer func() {
+		if !cf.sinkUnreachable.Get() {
+			cf.sliMetrics.SinkConnected.Dec(1)
+		}
+	}()
+
+	sv := &cf.flowCtx.Cfg.Settings.SV
+	timer := timeutil.NewTimer()
+	defer timer.Stop()
+	failures := 0
+	// recorded is the health last recorded in the job's progress, which is
+	// only updated once the sink has been checked.
+	recorded := jobspb.ChangefeedProgress_SINK_HEALTH_UNKNOWN
+	for {
+		interval := changefeedbase.SinkHealthCheckInterval.Get(sv)
+		enabled := interval > 0
+		if !enabled {
+			interval = sinkHealthCheckDisabledPollInterval
+		}
+		timer.Reset(interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			timer.Read = true
+		}
+		if !enabled {
+			continue
+		}
+
+		err := hc.CheckHealth(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			if failures == sinkHealthCheckFailureThreshold {
+				log.Warningf(ctx, "changefeed sink unreachable after %d failed health checks: %v",
+					failures, err)
+				cf.sinkUnreachable.Set(true)
+				cf.sliMetrics.SinkConnected.Dec(1)
+			}
+		} else {
+			failures = 0
+			if cf.sinkUnreachable.Get() {
+				log.Infof(ctx, "changefeed sink is reachable again")
+				cf.sinkUnreachable.Set(false)
+				cf.sliMetrics.SinkConnected.Inc(1)
+			}
+		}
+
+		health := recorded
+		if failures == 0 {
+			health = jobspb.ChangefeedProgress_SINK_CONNECTED
+		} else if cf.sinkUnreachable.Get() {
+			health = jobspb.ChangefeedProgress_SINK_UNREACHABLE
+		}
+		if health != recorded && cf.recordSinkHealth(ctx, health) {
+			recorded = health
+		}
+	}
+}
+
+// recordSinkHealth records the health of the sink in the job's progress,
+// which SHOW CHANGEFEED JOBS reports in its sink_connected column, and
+// updates the job's running status to reflect it. It returns whether the
+// job was updated.
+func (cf *changeFrontier) recordSinkHealth(
+	ctx context.Context, health jobspb.ChangefeedProgress_SinkHealth,
+) bool {
+	if err := cf.js.job.Update(ctx, nil /* txn */, func(
+		txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+	) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		if md.Progress.GetChangefeed() == nil {
+			md.Progress.Details = &jobspb.Progress_Changefeed{Changefeed: &jobspb.ChangefeedProgress{}}
+		}
+		md.Progress.GetChangefeed().SinkHealth = health
+		var highWater hlc.Timestamp
+		if hw := md.Progress.GetHighWater(); hw != nil {
+			highWater = *hw
+		}
+		md.Progress.RunningStatus = cf.runningStatus(highWater)
+		ju.UpdateProgress(md.Progress)
+		return nil
+	}); err != nil {
+		log.Warningf(ctx, "failed to record sink health: %v", err)
+		return false
+	}
+	return true
+}
+
+// runningStatus returns the job running status for the specified frontier.
+func (cf *changeFrontier) runningStatus(frontier hlc.Timestamp) string {
+	if cf.sinkUnreachable.Get() {
+		return fmt.Sprintf("running: resolved=%s; %s", frontier, sinkUnreachableStatus)
+	}
+	return fmt.Sprintf("running: resolved=%s", frontier)
 }
 
 func (cf *changeFrontier) close() {
@@ -1195,11 +2260,16 @@ func (cf *changeFrontier) close() {
 		if cf.metrics != nil {
 			cf.closeMetrics()
 		}
+		if cf.cancelHealthCheck != nil {
+			cf.cancelHealthCheck()
+			<-cf.healthCheckDoneCh
+		}
 		if cf.sink != nil {
 			if err := cf.sink.Close(); err != nil {
 				log.Warningf(cf.Ctx, `error closing sink. goroutines may have leaked: %v`, err)
 			}
 		}
+		closeSchemaRegistries(cf.encoder)
 		cf.memAcc.Close(cf.Ctx)
 		cf.MemMonitor.Stop(cf.Ctx)
 	}
@@ -1230,9 +2300,37 @@ func (cf *changeFrontier) shouldProtectBoundaries() bool {
 func (cf *changeFrontier) Next() (rowenc.EncDatumRow, *execinfrapb.ProducerMetadata) {
 	for cf.State == execinfra.StateRunning {
 		if !cf.passthroughBuf.IsEmpty() {
-			return cf.ProcessRowHelper(cf.passthroughBuf.Pop()), nil
+			row := cf.passthroughBuf.Pop()
+			if err := cf.maybeRecordReplay(row, hlc.Timestamp{}); err != nil {
+				cf.MoveToDraining(err)
+				break
+			}
+			return cf.ProcessRowHelper(row), nil
 		} else if !cf.resolvedBuf.IsEmpty() {
-			return cf.ProcessRowHelper(cf.resolvedBuf.Pop()), nil
+			// The resolved buffer is drained before any more input is read, so
+			// it holds the last resolved timestamp emitted.
+			row := cf.resolvedBuf.Pop()
+			if err := cf.maybeRecordReplay(row, cf.lastResolved); err != nil {
+				cf.MoveToDraining(err)
+				break
+			}
+			return cf.ProcessRowHelper(row), nil
+		}
+
+		if cf.initialScanOnly && cf.frontier.schemaChangeBoundaryReached() &&
+			cf.frontier.boundaryType == jobspb.ResolvedSpan_EXIT {
+			// The initial scan has completed. Its final resolved timestamp is
+			// emitted regardless of the resolved option, and returned before the
+			// changefeed drains.
+			if boundary := cf.frontier.boundaryTime; cf.lastResolved.Less(boundary) {
+				if err := cf.emitResolved(boundary); err != nil {
+					cf.MoveToDraining(err)
+					break
+				}
+				continue
+			}
+			cf.MoveToDraining(nil /* err */)
+			break
 		}
 
 		if cf.frontier.schemaChangeBoundaryReached() &&
@@ -1281,6 +2379,29 @@ func (cf *changeFrontier) Next() (rowenc.EncDatumRow, *execinfrapb.ProducerMetad
 	return nil, cf.DrainHelper()
 }
 
+// maybeRecordReplay adds a row returned by a sinkless changefeed to its
+// replay buffer, if it has one. resolved is set if the row is a resolved
+// timestamp.
+func (cf *changeFrontier) maybeRecordReplay(row rowenc.EncDatumRow, resolved hlc.Timestamp) error {
+	if cf.replayBuffer == nil {
+		return nil
+	}
+	// Only the topic, key and value are returned to the client.
+	datums := make(tree.Datums, 0, len(row)-1)
+	for i := 1; i < len(row); i++ {
+		if err := row[i].EnsureDecoded(changefeeddist.ChangefeedResultTypes[i], &cf.a); err != nil {
+			return err
+		}
+		datums = append(datums, row[i].Datum)
+	}
+	if resolved.IsEmpty() {
+		cf.replayBuffer.addRow(cf.Ctx, datums)
+	} else {
+		cf.replayBuffer.addResolved(cf.Ctx, resolved, datums)
+	}
+	return nil
+}
+
 func (cf *changeFrontier) noteResolvedSpan(d rowenc.EncDatum) error {
 	if err := d.EnsureDecoded(changefeeddist.ChangefeedResultTypes[0], &cf.a); err != nil {
 		return err
@@ -1294,6 +2415,18 @@ func (cf *changeFrontier) noteResolvedSpan(d rowenc.EncDatum) error {
 		return errors.NewAssertionErrorWithWrappedErrf(err,
 			`unmarshalling resolved span: %x`, raw)
 	}
+	for _, stats := range resolved.EmittedByTable {
+		cf.rowsSinceResolved += stats.EmittedMessages
+	}
+	if cf.js != nil && len(resolved.EmittedByTable) > 0 {
+		if cf.js.pendingEmitted == nil {
+			cf.js.pendingEmitted = make(map[string]jobspb.ChangefeedTableStats)
+		}
+		addTableStats(cf.js.pendingEmitted, resolved.EmittedByTable)
+	}
+	if cf.js != nil && len(resolved.Sequences) > 0 {
+		cf.js.pendingSequences = mergeSequences(cf.js.pendingSequences, resolved.Sequences)
+	}
 
 	// Inserting a timestamp less than the one the changefeed flow started at
 	// could potentially regress the job progress. This is not expected, but it
@@ -1347,10 +2480,13 @@ func (cf *changeFrontier) forwardFrontier(resolved jobspb.ResolvedSpan) error {
 		}
 		cf.metrics.mu.Unlock()
 
-		return cf.maybeEmitResolved(newResolved)
+		cf.heartbeatResolved = newResolved
+		if err := cf.maybeEmitResolved(newResolved); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return cf.maybeEmitHeartbeat()
 }
 
 func (cf *changeFrontier) maybeCheckpointJob(
@@ -1376,6 +2512,14 @@ func (cf *changeFrontier) maybeCheckpointJob(
 		!inBackfill && (cf.frontier.schemaChangeBoundaryReached() || cf.js.canCheckpointHighWatermark(frontierChanged))
 
 	if updateCheckpoint || updateHighWater {
+		// The aggregators durably flushed the rows below the frontier before
+		// forwarding its resolved spans; the frontier's own sink must also
+		// have durably accepted what it emitted before the high-water moves.
+		if updateHighWater && cf.durableResolved {
+			if err := flushDurable(cf.Ctx, cf.sink); err != nil {
+				return false, err
+			}
+		}
 		manageProtected := updateHighWater
 		checkpointStart := timeutil.Now()
 		if err := cf.checkpointJobProgress(
@@ -1390,6 +2534,12 @@ func (cf *changeFrontier) maybeCheckpointJob(
 	return false, nil
 }
 
+// errChangefeedResync is returned by the change frontier once it has
+// checkpointed the progress of a running changefeed whose resync was
+// requested by ALTER CHANGEFEED ... RESYNC, which the changefeed restarts to
+// perform.
+var errChangefeedResync = errors.New(`changefeed restarting to resync`)
+
 // checkpointJobProgress checkpoints a changefeed-level job information.
 // In addition, if 'manageProtected' is true, which only happens when frontier advanced,
 // this method manages the protected timestamp state.
@@ -1407,12 +2557,14 @@ func (cf *changeFrontier) checkpointJobProgress(
 	}
 	cf.metrics.FrontierUpdates.Inc(1)
 
-	return cf.js.job.Update(cf.Ctx, nil, func(
+	var resyncRequested bool
+	if err := cf.js.job.Update(cf.Ctx, nil, func(
 		txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
 	) error {
 		if err := md.CheckRunningOrReverting(); err != nil {
 			return err
 		}
+		_, resyncRequested = md.Payload.GetChangefeed().Opts[changefeedbase.ResyncRequested]
 
 		// Advance resolved timestamp.
 		progress := md.Progress
@@ -1430,8 +2582,24 @@ func (cf *changeFrontier) checkpointJobProgress(
 
 		changefeedProgress.Checkpoint = &checkpoint
 
+		if len(cf.js.pendingEmitted) > 0 {
+			if changefeedProgress.EmittedByTable == nil {
+				changefeedProgress.EmittedByTable = make(map[string]jobspb.ChangefeedTableStats)
+			}
+			addTableStats(changefeedProgress.EmittedByTable, cf.js.pendingEmitted)
+		}
+		// The frontier's sink is flushed whenever it emits resolved
+		// timestamps, so all the messages it numbered have been flushed.
+		sequences := cf.js.pendingSequences
+		if cf.sequencedSink != nil {
+			sequences = mergeSequences(sequences, cf.sequencedSink.flushedSequences())
+		}
+		if len(sequences) > 0 {
+			changefeedProgress.Sequences = mergeSequences(changefeedProgress.Sequences, sequences)
+		}
+
 		if updateRunStatus {
-			md.Progress.RunningStatus = fmt.Sprintf("running: resolved=%s", frontier)
+			md.Progress.RunningStatus = cf.runningStatus(frontier)
 		}
 
 		ju.UpdateProgress(progress)
@@ -1445,7 +2613,15 @@ func (cf *changeFrontier) checkpointJobProgress(
 		}
 
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	cf.js.pendingEmitted = nil
+	cf.js.pendingSequences = nil
+	if resyncRequested {
+		return changefeedbase.MarkRetryableError(errChangefeedResync)
+	}
+	return nil
 }
 
 // manageProtectedTimestamps is called when the resolved timestamp is being
@@ -1522,18 +2698,101 @@ func (cf *changeFrontier) maybeProtectTimestamp(
 }
 
 func (cf *changeFrontier) maybeEmitResolved(newResolved hlc.Timestamp) error {
-	if cf.freqEmitResolved == emitNoResolved || newResolved.IsEmpty() {
+	if cf.freqEmitResolved == emitNoResolved || newResolved.IsEmpty() || cf.rangeFreshness {
 		return nil
 	}
 	sinceEmitted := newResolved.GoTime().Sub(cf.lastEmitResolved)
-	shouldEmit := sinceEmitted >= cf.freqEmitResolved || cf.frontier.schemaChangeBoundaryReached()
+	boundaryReached := cf.frontier.schemaChangeBoundaryReached()
+	shouldEmit := sinceEmitted >= cf.freqEmitResolved || boundaryReached
+	// The resolved timestamp of a boundary, past which the changefeed stops
+	// or restarts, is emitted regardless of the rows flushed.
+	if cf.resolvedMinRows > 0 && !boundaryReached &&
+		cf.rowsSinceResolved < cf.resolvedMinRows && sinceEmitted < cf.resolvedMaxInterval {
+		shouldEmit = false
+	}
 	if !shouldEmit {
 		return nil
 	}
-	if err := emitResolvedTimestamp(cf.Ctx, cf.encoder, cf.sink, newResolved); err != nil {
+	return cf.emitResolved(newResolved)
+}
+
+// defaultResolvedMaxInterval is the longest a resolved timestamp is held back
+// by the resolved_min_rows option, unless resolved_max_interval or the
+// interval of the resolved option says otherwise.
+const defaultResolvedMaxInterval = time.Minute
+
+// getResolvedMinRows returns the number of rows of the resolved_min_rows
+// option, or 0 if it isn't set, and the longest a resolved timestamp may be
+// held back waiting for them.
+func getResolvedMinRows(opts map[string]string) (int64, time.Duration, error) {
+	v, ok := opts[changefeedbase.OptResolvedMinRows]
+	if !ok {
+		if _, ok := opts[changefeedbase.OptResolvedMaxInterval]; ok {
+			return 0, 0, errors.Errorf(`%s requires the %s option`,
+				changefeedbase.OptResolvedMaxInterval, changefeedbase.OptResolvedMinRows)
+		}
+		return 0, 0, nil
+	}
+	minRows, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || minRows <= 0 {
+		return 0, 0, errors.Errorf(`%s must be a positive integer: %q`,
+			changefeedbase.OptResolvedMinRows, v)
+	}
+	var freq time.Duration
+	if r := opts[changefeedbase.OptResolvedTimestamps]; r != `` {
+		if freq, err = time.ParseDuration(r); err != nil {
+			return 0, 0, err
+		}
+	}
+	maxInterval := defaultResolvedMaxInterval
+	if freq > maxInterval {
+		maxInterval = freq
+	}
+	if r, ok := opts[changefeedbase.OptResolvedMaxInterval]; ok {
+		if maxInterval, err = time.ParseDuration(r); err != nil || maxInterval <= 0 {
+			return 0, 0, errors.Errorf(`%s must be a positive duration: %q`,
+				changefeedbase.OptResolvedMaxInterval, r)
+		}
+		if maxInterval < freq {
+			return 0, 0, errors.Errorf(`%s must not be less than the interval of the %s option: %s`,
+				changefeedbase.OptResolvedMaxInterval, changefeedbase.OptResolvedTimestamps, freq)
+		}
+	}
+	return minRows, maxInterval, nil
+}
+
+// maybeEmitHeartbeat re-emits the latest resolved timestamp if none has been
+// emitted for the heartbeat interval, so that consumers of an idle changefeed
+// can tell that it is alive even if its frontier is stalled.
+func (cf *changeFrontier) maybeEmitHeartbeat() error {
+	if cf.heartbeat == 0 || cf.heartbeatResolved.IsEmpty() ||
+		timeutil.Since(cf.lastHeartbeat) < cf.heartbeat {
+		return nil
+	}
+	return cf.emitResolved(cf.heartbeatResolved)
+}
+
+func (cf *changeFrontier) emitResolved(newResolved hlc.Timestamp) error {
+	encoder := makeResolvedEncoder(cf.encoder, cf.lastResolved, cf.frontier.SpanFrontier())
+	// The change aggregators flush their sinks before forwarding the resolved
+	// spans which advanced the frontier, so every row at or below newResolved
+	// has already been flushed. The frontier's sink is flushed before emitting
+	// the resolved timestamp as well, so that nothing it buffered can be
+	// delivered after it, and after, so that sinks which batch messages don't
+	// hold on to the resolved timestamp until the next one.
+	if err := cf.sink.Flush(cf.Ctx); err != nil {
+		return err
+	}
+	if err := emitResolvedTimestamp(cf.Ctx, encoder, cf.sink, newResolved); err != nil {
+		return err
+	}
+	if err := cf.sink.Flush(cf.Ctx); err != nil {
 		return err
 	}
 	cf.lastEmitResolved = newResolved.GoTime()
+	cf.lastResolved = newResolved
+	cf.rowsSinceResolved = 0
+	cf.lastHeartbeat = timeutil.Now()
 	return nil
 }
 
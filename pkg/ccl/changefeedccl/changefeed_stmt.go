@@ -36,6 +36,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/colinfo"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/resolver"
 	"github.com/cockroachdb/cockroach/pkg/sql/flowinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
@@ -86,6 +87,12 @@ func changefeedPlanHook(
 	var header colinfo.ResultColumns
 	unspecifiedSink := changefeedStmt.SinkURI == nil
 	avoidBuffering := false
+	replay := false
+	for _, opt := range changefeedStmt.Options {
+		if string(opt.Key) == changefeedbase.OptReplayFrom {
+			replay = true
+		}
+	}
 
 	if unspecifiedSink {
 		// An unspecified sink triggers a fairly radical change in behavior.
@@ -111,12 +118,30 @@ func changefeedPlanHook(
 		header = colinfo.ResultColumns{
 			{Name: "job_id", Typ: types.Int},
 		}
+		if replay {
+			// Replaying the files of a cloud storage changefeed happens
+			// synchronously, without a job, and returns the number of rows
+			// emitted to the sink.
+			header = colinfo.ResultColumns{
+				{Name: "rows", Typ: types.Int},
+			}
+		}
 	}
 
 	optsFn, err := p.TypeAsStringOpts(ctx, changefeedStmt.Options, changefeedbase.ChangefeedOptionExpectValues)
 	if err != nil {
 		return nil, nil, nil, false, err
 	}
+	targetOptsFns := make([]func() (map[string]string, error), len(changefeedStmt.TargetOptions))
+	for i, targetOpts := range changefeedStmt.TargetOptions {
+		if targetOpts == nil {
+			continue
+		}
+		targetOptsFns[i], err = p.TypeAsStringOpts(ctx, targetOpts, changefeedbase.ChangefeedOptionExpectValues)
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+	}
 
 	fn := func(ctx context.Context, _ []sql.PlanNode, resultsCh chan<- tree.Datums) error {
 		ctx, span := tracing.ChildSpan(ctx, stmt.StatementTag())
@@ -155,6 +180,12 @@ func changefeedPlanHook(
 			)
 			// Still serialize the experimental_ form for backwards compatibility
 		}
+		if _, ok := opts[changefeedbase.OptAtMostOnce]; ok {
+			p.BufferClientNotice(ctx, pgnotice.Newf(
+				`%s is lossy: rows which fail to be emitted to the sink are dropped instead of `+
+					`retried, and counted by the changefeed.dropped_messages metric`,
+				changefeedbase.OptAtMostOnce))
+		}
 
 		jobDescription, err := changefeedJobDescription(p, changefeedStmt, sinkURI, opts)
 		if err != nil {
@@ -182,10 +213,24 @@ func changefeedPlanHook(
 			return err
 		}
 
-		targets, err := getTargets(ctx, p, targetDescs, opts)
+		targets, targetTables, err := getTargets(ctx, p, targetDescs, opts, statementTime)
 		if err != nil {
 			return err
 		}
+		if err := applyTargetOptions(
+			ctx, p, changefeedStmt, targetOptsFns, targets, statementTime, initialHighWater,
+		); err != nil {
+			return err
+		}
+		if hasKeyRange(targets, opts) {
+			// Malformed bounds are reported when the changefeed is created, rather
+			// than when its job plans its spans.
+			if _, err := fetchSpansForTargets(
+				ctx, p.ExecCfg(), p.EvalContext(), targets, opts, statementTime,
+			); err != nil {
+				return err
+			}
+		}
 
 		details := jobspb.ChangefeedDetails{
 			Targets:       targets,
@@ -248,7 +293,15 @@ func changefeedPlanHook(
 			return err
 		}
 
-		if isCloudStorageSink(parsedSink) || isWebhookSink(parsedSink) {
+		// Avro data files written by the cloud storage sink include the key
+		// alongside each value, and avro doesn't support key_in_value anyway.
+		isAvro := changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) == changefeedbase.OptFormatAvro ||
+			changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) == changefeedbase.DeprecatedOptFormatAvro
+		// Lines of file sinks only hold the value, so deletes need their key in
+		// it, which only the wrapped envelope can hold.
+		isWrappedFile := isFileSink(parsedSink) &&
+			changefeedbase.EnvelopeType(details.Opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeWrapped
+		if (isCloudStorageSink(parsedSink) && !isAvro) || isWebhookSink(parsedSink) || isWrappedFile {
 			details.Opts[changefeedbase.OptKeyInValue] = ``
 		}
 		if isWebhookSink(parsedSink) {
@@ -258,6 +311,11 @@ func changefeedPlanHook(
 		if !unspecifiedSink && p.ExecCfg().ExternalIODirConfig.DisableOutbound {
 			return errors.Errorf("Outbound IO is disabled by configuration, cannot create changefeed into %s", parsedSink.Scheme)
 		}
+		if isLocalSink(parsedSink) {
+			if err := checkLocalSinkAccess(ctx, p, parsedSink.Scheme); err != nil {
+				return err
+			}
+		}
 
 		if _, shouldProtect := details.Opts[changefeedbase.OptProtectDataFromGCOnPause]; shouldProtect && !p.ExecCfg().Codec.ForSystemTenant() {
 			return errorutil.UnsupportedWithMultiTenancy(67271)
@@ -293,6 +351,22 @@ func changefeedPlanHook(
 
 		if details.SinkURI == `` {
 			telemetry.Count(`changefeed.create.core`)
+			if name, ok := details.Opts[changefeedbase.OptReplayBuffer]; ok {
+				sv := &p.ExecCfg().Settings.SV
+				key := makeReplayBufferKey(p.User(), details.Targets, name)
+				buf, err := sinklessReplayBuffers.acquire(ctx, key, timeutil.Now(),
+					changefeedbase.SinklessReplayBufferWindow.Get(sv),
+					changefeedbase.SinklessReplayBufferMaxSize.Get(sv),
+					changefeedbase.SinklessReplayBufferMaxCount.Get(sv),
+					p.ExecCfg().DistSQLSrv.BackfillerMonitor)
+				if err != nil {
+					return err
+				}
+				defer func() { sinklessReplayBuffers.release(buf, timeutil.Now()) }()
+				if err := resumeFromReplayBuffer(ctx, p, buf, &details, resultsCh); err != nil {
+					return err
+				}
+			}
 			err := distChangefeedFlow(ctx, p, 0 /* jobID */, details, progress, resultsCh)
 			if err != nil {
 				telemetry.Count(`changefeed.core.error`)
@@ -308,12 +382,26 @@ func changefeedPlanHook(
 
 		telemetry.Count(`changefeed.create.enterprise`)
 
+		if replayFrom, ok := details.Opts[changefeedbase.OptReplayFrom]; ok {
+			telemetry.Count(`changefeed.create.replay`)
+			rows, err := replayCloudStorageFeed(ctx, p, details, targetTables, replayFrom)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case resultsCh <- tree.Datums{tree.NewDInt(tree.DInt(rows))}:
+				return nil
+			}
+		}
+
 		// In the case where a user is executing a CREATE CHANGEFEED and is still
 		// waiting for the statement to return, we take the opportunity to ensure
 		// that the user has not made any obvious errors when specifying the sink in
 		// the CREATE CHANGEFEED statement. To do this, we create a "canary" sink,
 		// which will be immediately closed, only to check for errors.
-		if err := validateSink(ctx, p, jobspb.InvalidJobID, details, opts); err != nil {
+		if err := validateSink(ctx, p, jobspb.InvalidJobID, details, opts, targetTables); err != nil {
 			return err
 		}
 
@@ -332,7 +420,12 @@ func changefeedPlanHook(
 			var protectedTimestampID uuid.UUID
 			var ptr *ptpb.Record
 
-			shouldProtectTimestamp := initialScanFromOptions(details.Opts) && p.ExecCfg().Codec.ForSystemTenant()
+			// Changefeeds which emit the previous values of rows also protect their
+			// statement time without an initial scan: the previous value of the
+			// first change to each row is the version of the row at that time.
+			withPrevValues := needsPrevValues(details.Opts)
+			shouldProtectTimestamp := (initialScanFromOptions(details.Opts) || withPrevValues) &&
+				p.ExecCfg().Codec.ForSystemTenant()
 			if shouldProtectTimestamp {
 				protectedTimestampID = uuid.MakeV4()
 				deprecatedSpansToProtect := makeSpansToProtect(p.ExecCfg().Codec, details.Targets)
@@ -381,6 +474,11 @@ func changefeedPlanHook(
 							log.Warningf(ctx, "failed to cancel job: %v", cancelErr)
 						}
 					}
+					if withPrevValues {
+						err = errors.Wrapf(err, `cannot protect the versions of the rows at %s, `+
+							`which the previous values emitted by the changefeed are read from`,
+							statementTime.AsOfSystemTime())
+					}
 					return err
 				}
 			}
@@ -404,6 +502,42 @@ func changefeedPlanHook(
 	return fn, header, nil, avoidBuffering, nil
 }
 
+// resumeFromReplayBuffer sends a sinkless changefeed resuming from a cursor
+// the messages retained by its replay buffer since the cursor, and advances
+// the changefeed's start to the last of them. If the cursor is outside of the
+// buffer's window, a notice is sent and the changefeed is restarted from the
+// cursor.
+func resumeFromReplayBuffer(
+	ctx context.Context,
+	p sql.PlanHookState,
+	buf *replayBuffer,
+	details *jobspb.ChangefeedDetails,
+	resultsCh chan<- tree.Datums,
+) error {
+	if _, ok := details.Opts[changefeedbase.OptCursor]; !ok || initialScanFromOptions(details.Opts) {
+		buf.reset(ctx)
+		return nil
+	}
+	rows, resumeFrom, ok := buf.resume(ctx, details.StatementTime)
+	if !ok {
+		telemetry.Count(`changefeed.replay_buffer.miss`)
+		p.BufferClientNotice(ctx, pgnotice.Newf(
+			`%s %q does not cover cursor %s, restarting from the cursor`,
+			changefeedbase.OptReplayBuffer, buf.key.name, details.StatementTime.AsOfSystemTime()))
+		return nil
+	}
+	telemetry.Count(`changefeed.replay_buffer.hit`)
+	for _, row := range rows {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case resultsCh <- row:
+		}
+	}
+	details.StatementTime = resumeFrom
+	return nil
+}
+
 func validateSettings(ctx context.Context, p sql.PlanHookState) error {
 	if err := featureflag.CheckEnabled(
 		ctx,
@@ -481,30 +615,186 @@ func getTargets(
 	p sql.PlanHookState,
 	targetDescs []catalog.Descriptor,
 	opts map[string]string,
-) (jobspb.ChangefeedTargets, error) {
+	statementTime hlc.Timestamp,
+) (jobspb.ChangefeedTargets, []catalog.TableDescriptor, error) {
 	targets := make(jobspb.ChangefeedTargets, len(targetDescs))
+	var tables []catalog.TableDescriptor
+	var excludedTypes excludedColumnTypes
+	if v, ok := opts[changefeedbase.OptExcludeColumnTypes]; ok {
+		var err error
+		if excludedTypes, err = parseExcludedColumnTypes(v); err != nil {
+			return nil, nil, err
+		}
+	}
 	for _, desc := range targetDescs {
 		if table, isTable := desc.(catalog.TableDescriptor); isTable {
 			if err := p.CheckPrivilege(ctx, desc, privilege.SELECT); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
-			_, qualified := opts[changefeedbase.OptFullTableName]
-			name, err := getChangefeedTargetName(ctx, table, p.ExecCfg(), p.ExtendedEvalContext().Txn, qualified)
+			if changefeedbase.IsAllowlistedSystemTable(table) {
+				isAdmin, err := p.HasAdminRole(ctx)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !isAdmin {
+					return nil, nil, pgerror.Newf(pgcode.InsufficientPrivilege,
+						"only users with the admin role are allowed to create a changefeed on system table %s",
+						table.GetName())
+				}
+			}
+			qualifiedName, err := getQualifiedTableName(ctx, p.ExecCfg(), p.ExtendedEvalContext().Txn, table)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			name := table.GetName()
+			if _, qualified := opts[changefeedbase.OptFullTableName]; qualified {
+				name = qualifiedName
+			}
+			target := jobspb.ChangefeedTarget{StatementTimeName: name, QualifiedName: qualifiedName}
+			// The table watched for a view is validated as a table, and the
+			// options checked against the columns of its rows, those of the
+			// view.
+			var view catalog.TableDescriptor
+			if table.IsView() {
+				view = table
+				if table, target.View, err = resolveViewTarget(ctx, p, view, statementTime); err != nil {
+					return nil, nil, err
+				}
+			}
+			if existing, ok := targets[table.GetID()]; ok && (existing.View != nil || target.View != nil) {
+				return nil, nil, errors.Errorf(`table %s is targeted more than once, through %s and %s`,
+					table.GetName(), existing.StatementTimeName, name)
+			}
+			targets[table.GetID()] = target
+			if err := changefeedbase.ValidateTable(targets, table, opts); err != nil {
+				return nil, nil, err
+			}
+			if view != nil {
+				if table, err = projectViewTable(table, target.View); err != nil {
+					return nil, nil, err
+				}
+			}
+			// The options naming columns are checked against the columns
+			// left once those of the excluded types are dropped.
+			if excludedTypes != nil {
+				projected, _, err := excludedTypes.project(table)
+				if err != nil {
+					return nil, nil, err
+				}
+				if projected != nil {
+					table = projected
+				}
 			}
-			targets[table.GetID()] = jobspb.ChangefeedTarget{
-				StatementTimeName: name,
+			tables = append(tables, table)
+			if column, ok := opts[changefeedbase.OptTopicFromColumn]; ok {
+				if err := validateTopicColumn(table, column); err != nil {
+					return nil, nil, err
+				}
 			}
-			if err := changefeedbase.ValidateTable(targets, table); err != nil {
-				return nil, err
+			if _, ok := opts[changefeedbase.OptRegion]; ok {
+				if err := validateRegionColumn(table); err != nil {
+					return nil, nil, err
+				}
+			}
+			if v, ok := opts[changefeedbase.OptWatchColumns]; ok {
+				columns, err := parseWatchColumns(v)
+				if err != nil {
+					return nil, nil, err
+				}
+				if err := columns.validate(table); err != nil {
+					return nil, nil, err
+				}
 			}
 			for _, warning := range changefeedbase.WarningsForTable(targets, table, opts) {
 				p.BufferClientNotice(ctx, pgnotice.Newf("%s", warning))
 			}
 		}
 	}
-	return targets, nil
+	if v, ok := opts[changefeedbase.OptAvroFixedColumns]; ok {
+		fixedColumns, err := parseAvroFixedColumns(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := validateAvroFixedColumns(fixedColumns, tables); err != nil {
+			return nil, nil, err
+		}
+	}
+	return targets, tables, nil
+}
+
+// applyTargetOptions sets the options given for the tables targeted by a
+// CREATE CHANGEFEED statement as the overrides of their targets.
+func applyTargetOptions(
+	ctx context.Context,
+	p sql.PlanHookState,
+	changefeedStmt *tree.CreateChangefeed,
+	targetOptsFns []func() (map[string]string, error),
+	targets jobspb.ChangefeedTargets,
+	statementTime hlc.Timestamp,
+	initialHighWater hlc.Timestamp,
+) error {
+	for i, optsFn := range targetOptsFns {
+		if optsFn == nil {
+			continue
+		}
+		opts, err := optsFn()
+		if err != nil {
+			return err
+		}
+		for key, value := range opts {
+			if _, ok := changefeedbase.CaseInsensitiveOpts[key]; ok {
+				opts[key] = strings.ToLower(value)
+			}
+		}
+		table := tree.TargetList{Tables: tree.TablePatterns{changefeedStmt.Targets.Tables[i]}}
+		descs, err := getTableDescriptors(ctx, p, &table, statementTime, initialHighWater)
+		if err != nil {
+			return err
+		}
+		for _, desc := range descs {
+			if _, isTable := desc.(catalog.TableDescriptor); !isTable {
+				continue
+			}
+			id := targetIDOf(targets, desc.GetID())
+			target := targets[id]
+			if target.Opts != nil {
+				return errors.Errorf(`options for table %s specified more than once`, target.StatementTimeName)
+			}
+			target.Opts = opts
+			targets[id] = target
+		}
+	}
+	return nil
+}
+
+// isLocalSink returns whether the sink writes to the local filesystem of the
+// nodes running the changefeed, or to the sockets in it.
+func isLocalSink(u *url.URL) bool {
+	return isPebbleSink(u) || isFileSink(u) || isUnixSink(u)
+}
+
+// checkLocalSinkAccess returns an error if the user can't create a
+// changefeed into a sink writing to the local filesystem of nodes. Such sinks
+// access the filesystem as the node does, so like the implicit credentials of
+// nodes, they are restricted to admins unless the external IO configuration
+// allows otherwise, and aren't available to secondary tenants.
+func checkLocalSinkAccess(ctx context.Context, p sql.PlanHookState, scheme string) error {
+	if !p.ExecCfg().Codec.ForSystemTenant() {
+		return pgerror.Newf(pgcode.FeatureNotSupported,
+			`%s sinks are not supported by secondary tenants`, scheme)
+	}
+	if p.ExecCfg().ExternalIODirConfig.EnableNonAdminImplicitAndArbitraryOutbound {
+		return nil
+	}
+	isAdmin, err := p.HasAdminRole(ctx)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return pgerror.Newf(pgcode.InsufficientPrivilege,
+			`only users with the admin role are allowed to create a changefeed into a %s sink`, scheme)
+	}
+	return nil
 }
 
 func validateSink(
@@ -513,6 +803,7 @@ func validateSink(
 	jobID jobspb.JobID,
 	details jobspb.ChangefeedDetails,
 	opts map[string]string,
+	targetTables []catalog.TableDescriptor,
 ) error {
 	metrics := p.ExecCfg().JobRegistry.MetricsStruct().Changefeed.(*Metrics)
 	sli, err := metrics.getSLIMetrics(opts[changefeedbase.OptMetricsScope])
@@ -525,9 +816,33 @@ func validateSink(
 	if err != nil {
 		return changefeedbase.MaybeStripRetryableErrorMarker(err)
 	}
+	if sink, ok := canarySink.(tableValidatingSink); ok {
+		if err := sink.validateTables(ctx, targetTables); err != nil {
+			_ = canarySink.Close()
+			return err
+		}
+	}
 	if err := canarySink.Close(); err != nil {
 		return err
 	}
+	// Sinks validate the format and envelope they are created with, so check
+	// that they also support those of the targets which override them.
+	for _, target := range details.Targets {
+		if len(target.Opts) == 0 {
+			continue
+		}
+		targetDetails := details
+		targetDetails.Opts = changefeedbase.OptionsForTarget(details.Opts, target)
+		targetSink, err := getSink(ctx, &p.ExecCfg().DistSQLSrv.ServerConfig, targetDetails,
+			nilOracle, p.User(), jobID, sli)
+		if err != nil {
+			return errors.Wrapf(changefeedbase.MaybeStripRetryableErrorMarker(err),
+				`options for table %s`, target.StatementTimeName)
+		}
+		if err := targetSink.Close(); err != nil {
+			return err
+		}
+	}
 	if sink, ok := canarySink.(SinkWithTopics); ok {
 		topics := sink.Topics()
 		for _, topic := range topics {
@@ -541,26 +856,28 @@ func validateSink(
 func changefeedJobDescription(
 	p sql.PlanHookState, changefeed *tree.CreateChangefeed, sinkURI string, opts map[string]string,
 ) (string, error) {
-	cleanedSinkURI, err := cloud.SanitizeExternalStorageURI(sinkURI, []string{
-		changefeedbase.SinkParamSASLPassword,
-		changefeedbase.SinkParamCACert,
-		changefeedbase.SinkParamClientCert,
-	})
-
+	cleanedSinkURI, err := sanitizeSinkURI(sinkURI)
 	if err != nil {
 		return "", err
 	}
 
-	cleanedSinkURI = redactUser(cleanedSinkURI)
-
 	c := &tree.CreateChangefeed{
-		Targets: changefeed.Targets,
-		SinkURI: tree.NewDString(cleanedSinkURI),
+		Targets:       changefeed.Targets,
+		TargetOptions: changefeed.TargetOptions,
+		SinkURI:       tree.NewDString(cleanedSinkURI),
 	}
 	for k, v := range opts {
 		if k == changefeedbase.OptWebhookAuthHeader {
 			v = redactWebhookAuthHeader(v)
 		}
+		if k == changefeedbase.OptGRPCMetadata {
+			v = redactGRPCMetadata(v)
+		}
+		if k == changefeedbase.OptDeadLetterSink {
+			if v, err = sanitizeSinkURI(v); err != nil {
+				return "", err
+			}
+		}
 		opt := tree.KVOption{Key: tree.Name(k)}
 		if len(v) > 0 {
 			opt.Value = tree.NewDString(v)
@@ -572,6 +889,21 @@ func changefeedJobDescription(
 	return tree.AsStringWithFQNames(c, ann), nil
 }
 
+// sanitizeSinkURI redacts the credentials in a sink URI.
+func sanitizeSinkURI(sinkURI string) (string, error) {
+	cleanedSinkURI, err := cloud.SanitizeExternalStorageURI(sinkURI, []string{
+		changefeedbase.SinkParamSASLPassword,
+		changefeedbase.SinkParamCACert,
+		changefeedbase.SinkParamClientCert,
+		changefeedbase.SinkParamBearerToken,
+		changefeedbase.SinkParamStorage,
+	})
+	if err != nil {
+		return "", err
+	}
+	return redactUser(cleanedSinkURI), nil
+}
+
 func redactUser(uri string) string {
 	u, _ := url.Parse(uri)
 	if u.User != nil {
@@ -615,6 +947,35 @@ func validateDetails(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails
 			}
 		}
 	}
+	{
+		const opt = changefeedbase.OptHeartbeat
+		if o, ok := details.Opts[opt]; ok {
+			if err := validateNonNegativeDuration(opt, o); err != nil {
+				return jobspb.ChangefeedDetails{}, err
+			}
+		}
+	}
+	{
+		if _, err := getTopicFromColumnMaxTopics(details.Opts); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+	}
+	{
+		const opt = changefeedbase.OptWatermarkLag
+		if o, ok := details.Opts[opt]; ok {
+			if err := validateNonNegativeDuration(opt, o); err != nil {
+				return jobspb.ChangefeedDetails{}, err
+			}
+		}
+	}
+	if _, _, err := getResolvedMinRows(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if o, ok := details.Opts[changefeedbase.OptEmitWindow]; ok {
+		if _, err := parseEmitWindow(o); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+	}
 	{
 		const opt = changefeedbase.OptSchemaChangeEvents
 		switch v := changefeedbase.SchemaChangeEventClass(details.Opts[opt]); v {
@@ -649,6 +1010,16 @@ func validateDetails(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails
 				`cannot specify both %s and %s`, changefeedbase.OptInitialScan,
 				changefeedbase.OptNoInitialScan)
 		}
+		if _, initialScanOnly := details.Opts[changefeedbase.OptInitialScanOnly]; initialScanOnly && noInitialScan {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`cannot specify both %s and %s`, changefeedbase.OptInitialScanOnly,
+				changefeedbase.OptNoInitialScan)
+		}
+		if _, ordered := details.Opts[changefeedbase.OptInitialScanOrdered]; ordered && noInitialScan {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`cannot specify both %s and %s`, changefeedbase.OptInitialScanOrdered,
+				changefeedbase.OptNoInitialScan)
+		}
 	}
 	{
 		const opt = changefeedbase.OptEnvelope
@@ -659,6 +1030,12 @@ func validateDetails(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails
 			details.Opts[opt] = string(changefeedbase.OptEnvelopeKeyOnly)
 		case ``, changefeedbase.OptEnvelopeWrapped:
 			details.Opts[opt] = string(changefeedbase.OptEnvelopeWrapped)
+		case changefeedbase.OptEnvelopeConnect:
+			details.Opts[opt] = string(changefeedbase.OptEnvelopeConnect)
+		case changefeedbase.OptEnvelopeDebezium:
+			details.Opts[opt] = string(changefeedbase.OptEnvelopeDebezium)
+		case changefeedbase.OptEnvelopeDeletedFlag:
+			details.Opts[opt] = string(changefeedbase.OptEnvelopeDeletedFlag)
 		default:
 			return jobspb.ChangefeedDetails{}, errors.Errorf(
 				`unknown %s: %s`, opt, v)
@@ -669,13 +1046,39 @@ func validateDetails(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails
 		switch v := changefeedbase.FormatType(details.Opts[opt]); v {
 		case ``, changefeedbase.OptFormatJSON:
 			details.Opts[opt] = string(changefeedbase.OptFormatJSON)
-		case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro, changefeedbase.OptFormatORC,
+			changefeedbase.OptFormatMsgpack:
 			// No-op.
 		default:
 			return jobspb.ChangefeedDetails{}, errors.Errorf(
 				`unknown %s: %s`, opt, v)
 		}
 	}
+	if v := changefeedbase.EnvelopeType(details.Opts[changefeedbase.OptEnvelope]); v == changefeedbase.OptEnvelopeConnect ||
+		v == changefeedbase.OptEnvelopeDebezium || v == changefeedbase.OptEnvelopeDeletedFlag {
+		if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s=%s is only usable with %s=%s`, changefeedbase.OptEnvelope, v,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+	}
+	if v := changefeedbase.EnvelopeType(details.Opts[changefeedbase.OptEnvelope]); v == changefeedbase.OptEnvelopeDeletedFlag {
+		// Deletes are represented by their deleted field.
+		if _, ok := details.Opts[changefeedbase.OptDeleteFormat]; ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not usable with %s=%s`, changefeedbase.OptDeleteFormat,
+				changefeedbase.OptEnvelope, v)
+		}
+	}
+	if v := changefeedbase.EnvelopeType(details.Opts[changefeedbase.OptEnvelope]); v == changefeedbase.OptEnvelopeDebezium {
+		// The source of Debezium change events names the database and schema
+		// of their table.
+		if _, ok := details.Opts[changefeedbase.OptFullTableName]; !ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s=%s requires the %s option`, changefeedbase.OptEnvelope, v,
+				changefeedbase.OptFullTableName)
+		}
+	}
 	{
 		const opt = changefeedbase.OptOnError
 		switch v := changefeedbase.OnErrorType(details.Opts[opt]); v {
@@ -702,9 +1105,454 @@ func validateDetails(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails
 				`unknown %s: %s`, opt, v)
 		}
 	}
+	for _, opt := range []string{
+		changefeedbase.OptSparseUpdates, changefeedbase.OptRangeInfo, changefeedbase.OptResolvedWindow,
+		changefeedbase.OptResolvedSpans, changefeedbase.OptRowHash, changefeedbase.OptProvenance,
+		changefeedbase.OptChangefeedEpoch, changefeedbase.OptRekey, changefeedbase.OptValueSize,
+		changefeedbase.OptSourceCluster, changefeedbase.OptRegion, changefeedbase.OptStatementTag,
+	} {
+		if _, ok := details.Opts[opt]; ok {
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+		}
+	}
+	for _, opt := range []string{
+		changefeedbase.OptResolvedWindow, changefeedbase.OptResolvedSpans,
+		changefeedbase.OptResolvedNullValue, changefeedbase.OptResolvedMinRows,
+	} {
+		if _, ok := details.Opts[opt]; ok {
+			if _, ok := details.Opts[changefeedbase.OptResolvedTimestamps]; !ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s requires the %s option`, opt, changefeedbase.OptResolvedTimestamps)
+			}
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptResolvedNullValue]; ok {
+		if details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s requires a sink`, changefeedbase.OptResolvedNullValue)
+		}
+		// Records without a value have no room for the details these options
+		// add to resolved timestamp payloads.
+		for _, opt := range []string{changefeedbase.OptResolvedWindow, changefeedbase.OptResolvedSpans} {
+			if _, ok := details.Opts[opt]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is not supported with %s`, changefeedbase.OptResolvedNullValue, opt)
+			}
+		}
+	}
+	for _, opt := range []string{changefeedbase.OptRowHash, changefeedbase.OptRekey} {
+		if _, ok := details.Opts[opt]; ok {
+			if envelope := details.Opts[changefeedbase.OptEnvelope]; envelope != string(changefeedbase.OptEnvelopeWrapped) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+			}
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptRekey]; ok {
+		// The rekey field is added to the JSON of the envelope.
+		if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s`, changefeedbase.OptRekey,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+		// The row hash is the last field of the envelope, and covers the
+		// value as emitted, while the rekey field is added after the row is
+		// encoded.
+		if _, ok := details.Opts[changefeedbase.OptRowHash]; ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not supported with %s`, changefeedbase.OptRekey, changefeedbase.OptRowHash)
+		}
+	}
+	{
+		// Native KVs are emitted as they are read from the rangefeed, without
+		// being decoded into rows.
+		const opt = changefeedbase.OptCollapseFamilies
+		if _, ok := details.Opts[opt]; ok && details.Opts[changefeedbase.OptFormat] == string(changefeedbase.OptFormatNative) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not supported with %s=%s`, opt,
+				changefeedbase.OptFormat, changefeedbase.OptFormatNative)
+		}
+	}
+	{
+		const opt = changefeedbase.OptSchemaFingerprint
+		if _, ok := details.Opts[opt]; ok && !isAvroFormat(changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat])) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s`, opt,
+				changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+		}
+	}
+	{
+		const opt = changefeedbase.OptDeadLetterSink
+		if _, ok := details.Opts[opt]; ok {
+			switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+			case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+			default:
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+			}
+		}
+	}
+	{
+		const opt = changefeedbase.OptAvroConnectCompatible
+		if _, ok := details.Opts[opt]; ok {
+			switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+			case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+			default:
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+			}
+		}
+	}
+	if prefix, ok := details.Opts[changefeedbase.OptSubjectPrefix]; ok {
+		switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+		case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s`, changefeedbase.OptSubjectPrefix,
+				changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+		}
+		// The prefix is added to subjects made of kafka names, and must be one
+		// too.
+		if prefix == `` || kafkaDisallowedRE.MatchString(prefix) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s must be a non-empty string of letters, digits, '.', '_' and '-': %q`,
+				changefeedbase.OptSubjectPrefix, prefix)
+		}
+	}
+	{
+		const opt = changefeedbase.OptReplayBuffer
+		if _, ok := details.Opts[opt]; ok && details.SinkURI != `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with sinkless changefeeds`, opt)
+		}
+	}
+	{
+		const opt = changefeedbase.OptReplayFrom
+		if _, ok := details.Opts[opt]; ok && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(`%s requires a sink`, opt)
+		}
+	}
+	{
+		const opt = changefeedbase.OptAtMostOnce
+		if _, ok := details.Opts[opt]; ok && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(`%s requires a sink`, opt)
+		}
+	}
+	{
+		const opt = changefeedbase.OptDurableResolved
+		if _, ok := details.Opts[opt]; ok && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(`%s requires a sink`, opt)
+		}
+	}
+	{
+		const opt = changefeedbase.OptSequenceNumbers
+		if _, ok := details.Opts[opt]; ok && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(`%s requires a sink`, opt)
+		}
+	}
+	{
+		// Sinkless changefeeds have no job to persist their epoch in.
+		const opt = changefeedbase.OptChangefeedEpoch
+		if _, ok := details.Opts[opt]; ok && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(`%s requires a sink`, opt)
+		}
+	}
+	{
+		// Column comments are included in the schemas of avro messages, and in
+		// schema sidecar files written next to the data files of cloud storage
+		// sinks for JSON messages, which carry no schema.
+		const opt = changefeedbase.OptColumnComments
+		if _, ok := details.Opts[opt]; ok {
+			switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+			case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+			case changefeedbase.OptFormatJSON:
+				if u, err := url.Parse(details.SinkURI); err != nil || !isCloudStorageSink(u) {
+					return jobspb.ChangefeedDetails{}, errors.Errorf(
+						`%s with %s=%s requires a cloud storage sink`, opt,
+						changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+				}
+			default:
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s or %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatAvro,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptIdempotencyToken]; ok {
+		// The token is added to the metadata of JSON messages and to the
+		// envelope of avro messages, which other formats have no room for.
+		switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+		case changefeedbase.OptFormatJSON, changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s or %s=%s`, changefeedbase.OptIdempotencyToken,
+				changefeedbase.OptFormat, changefeedbase.OptFormatAvro,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptVersionField]; ok {
+		// The version is a field of JSON values, which key_only changefeeds
+		// don't have.
+		if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s`, changefeedbase.OptVersionField,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+		if envelope := details.Opts[changefeedbase.OptEnvelope]; envelope == string(changefeedbase.OptEnvelopeKeyOnly) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not usable with %s=%s`, changefeedbase.OptVersionField,
+				changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeKeyOnly)
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptDeleteFullRow]; ok {
+		// The previous values of deleted rows are emitted in the before field
+		// of the wrapped envelope.
+		switch changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat]) {
+		case changefeedbase.OptFormatJSON, changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s or %s=%s`, changefeedbase.OptDeleteFullRow,
+				changefeedbase.OptFormat, changefeedbase.OptFormatAvro,
+				changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+		if envelope := details.Opts[changefeedbase.OptEnvelope]; envelope != string(changefeedbase.OptEnvelopeWrapped) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only usable with %s=%s`, changefeedbase.OptDeleteFullRow,
+				changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+		}
+	}
+	{
+		const opt = changefeedbase.OptOnTargetDrop
+		switch v := changefeedbase.OnTargetDropPolicy(details.Opts[opt]); v {
+		case ``, changefeedbase.OptOnTargetDropFail:
+			// No-op.
+		case changefeedbase.OptOnTargetDropSkip, changefeedbase.OptOnTargetDropComplete:
+			// The changefeed continues without a dropped target by restarting,
+			// which sinkless changefeeds don't do, at the drop, which is only
+			// detected by the schema feed.
+			if details.SinkURI == `` {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(`%s=%s requires a sink`, opt, v)
+			}
+			if changefeedbase.SchemaChangePolicy(details.Opts[changefeedbase.OptSchemaChangePolicy]) ==
+				changefeedbase.OptSchemaChangePolicyIgnore {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is not usable with %s=%s`, opt, v,
+					changefeedbase.OptSchemaChangePolicy, changefeedbase.OptSchemaChangePolicyIgnore)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	if _, _, err := getEmitRateLimits(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if _, err := getBufferFlushPolicy(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if _, err := getScanRequestBatchBytes(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if _, err := getInitialScanConcurrency(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if _, err := getInitialScanChunkSize(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	{
+		const opt = changefeedbase.OptInitialScanConcurrency
+		if _, ok := details.Opts[opt]; ok {
+			if _, ok := details.Opts[changefeedbase.OptInitialScanOrdered]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is not usable with %s, which scans one span at a time`,
+					opt, changefeedbase.OptInitialScanOrdered)
+			}
+		}
+	}
+	{
+		const opt = changefeedbase.OptTimestampFormat
+		switch v := changefeedbase.TimestampFormat(details.Opts[opt]); v {
+		case ``, changefeedbase.OptTimestampFormatHLC:
+			// No-op.
+		case changefeedbase.OptTimestampFormatRFC3339, changefeedbase.OptTimestampFormatUnixNanos:
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is only usable with %s=%s`, opt, v,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	{
+		const opt = changefeedbase.OptFloatSpecialValues
+		switch v := changefeedbase.FloatSpecialValues(details.Opts[opt]); v {
+		case ``:
+			// No-op.
+		case changefeedbase.OptFloatSpecialValuesString, changefeedbase.OptFloatSpecialValuesNull,
+			changefeedbase.OptFloatSpecialValuesError:
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	{
+		const opt = changefeedbase.OptTTLDeletes
+		if _, ok := details.Opts[opt]; ok {
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+			if envelope := details.Opts[changefeedbase.OptEnvelope]; envelope != string(changefeedbase.OptEnvelopeWrapped) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+			}
+		}
+	}
+	if _, ok := details.Opts[changefeedbase.OptCompactionTombstones]; ok {
+		if _, ok := details.Opts[changefeedbase.OptDeleteFormat]; ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not usable with %s`, changefeedbase.OptCompactionTombstones,
+				changefeedbase.OptDeleteFormat)
+		}
+	}
+	{
+		const opt = changefeedbase.OptDeleteFormat
+		switch v := changefeedbase.DeleteFormat(details.Opts[opt]); v {
+		case ``:
+			// No-op.
+		case changefeedbase.OptDeleteFormatAfterNull, changefeedbase.OptDeleteFormatTombstone,
+			changefeedbase.OptDeleteFormatNull, changefeedbase.OptDeleteFormatOp:
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is only usable with %s=%s`, opt,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+			envelope := changefeedbase.EnvelopeType(details.Opts[changefeedbase.OptEnvelope])
+			if v == changefeedbase.OptDeleteFormatAfterNull && envelope != changefeedbase.OptEnvelopeWrapped {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is only usable with %s=%s`, opt, v,
+					changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	{
+		const opt = changefeedbase.OptKeyFormat
+		switch v := changefeedbase.KeyFormat(details.Opts[opt]); v {
+		case ``, changefeedbase.OptKeyFormatArray:
+			// No-op.
+		case changefeedbase.OptKeyFormatObject:
+			if format := details.Opts[changefeedbase.OptFormat]; format != string(changefeedbase.OptFormatJSON) {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is only usable with %s=%s`, opt, v,
+					changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	{
+		const opt = changefeedbase.OptFreshness
+		switch v := changefeedbase.Freshness(details.Opts[opt]); v {
+		case ``, changefeedbase.OptFreshnessConsistent:
+			// No-op.
+		case changefeedbase.OptFreshnessRange:
+			// Heartbeats re-emit the frontier of the whole changefeed, which
+			// may be behind the resolved timestamps already emitted.
+			if _, ok := details.Opts[changefeedbase.OptHeartbeat]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is not usable with %s`, opt, v, changefeedbase.OptHeartbeat)
+			}
+			// The change aggregators emit the resolved timestamps, without
+			// counting the rows emitted by the others.
+			if _, ok := details.Opts[changefeedbase.OptResolvedMinRows]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s=%s is not usable with %s`, opt, v, changefeedbase.OptResolvedMinRows)
+			}
+		default:
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`unknown %s: %s`, opt, v)
+		}
+	}
+	if err := validateTargetOptions(details); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
 	return details, nil
 }
 
+// validateTargetOptions validates the options overridden by the targets of a
+// changefeed, which must be valid in combination with the changefeed's other
+// options, and normalizes them.
+func validateTargetOptions(details jobspb.ChangefeedDetails) error {
+	for _, target := range details.Targets {
+		if len(target.Opts) == 0 {
+			continue
+		}
+		for opt := range target.Opts {
+			if _, ok := changefeedbase.TargetOptions[opt]; !ok {
+				return errors.Errorf(`%s cannot be set for table %s`, opt, target.StatementTimeName)
+			}
+		}
+		targetDetails, err := validateDetails(jobspb.ChangefeedDetails{
+			SinkURI: details.SinkURI,
+			Opts:    changefeedbase.OptionsForTarget(details.Opts, target),
+		})
+		if err != nil {
+			return errors.Wrapf(err, `options for table %s`, target.StatementTimeName)
+		}
+		for opt := range target.Opts {
+			target.Opts[opt] = targetDetails.Opts[opt]
+		}
+
+		// Sinks which write files can only write one format.
+		feedFormat := changefeedbase.FormatType(details.Opts[changefeedbase.OptFormat])
+		targetFormat := changefeedbase.FormatType(targetDetails.Opts[changefeedbase.OptFormat])
+		if (isAvroFormat(feedFormat) && isAvroFormat(targetFormat)) || feedFormat == targetFormat {
+			continue
+		}
+		if feedFormat == changefeedbase.OptFormatORC || targetFormat == changefeedbase.OptFormatORC {
+			return errors.Errorf(`%s=%s cannot be combined with other formats`,
+				changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+		}
+		parsedSink, err := url.Parse(details.SinkURI)
+		if err != nil {
+			return err
+		}
+		if newScheme, ok := changefeedbase.NoLongerExperimental[parsedSink.Scheme]; ok {
+			parsedSink.Scheme = newScheme
+		}
+		if isCloudStorageSink(parsedSink) {
+			return errors.Errorf(`%s cannot be set for table %s with a cloud storage sink`,
+				changefeedbase.OptFormat, target.StatementTimeName)
+		}
+	}
+	return nil
+}
+
+func isAvroFormat(format changefeedbase.FormatType) bool {
+	return format == changefeedbase.OptFormatAvro || format == changefeedbase.DeprecatedOptFormatAvro
+}
+
 type changefeedResumer struct {
 	job *jobs.Job
 }
@@ -805,16 +1653,53 @@ func (b *changefeedResumer) resumeWithRetries(
 		// a dummy channel.
 		startedCh := make(chan tree.Datums, 1)
 
-		if err = distChangefeedFlow(ctx, jobExec, jobID, details, progress, startedCh); err == nil {
-			return nil
+		// Errors preparing the flow are retried like those of the flow itself,
+		// with the job progress reloaded before the next attempt.
+		err = nil
+		switch policy := changefeedbase.OnTargetDropPolicy(details.Opts[changefeedbase.OptOnTargetDrop]); policy {
+		case changefeedbase.OptOnTargetDropSkip, changefeedbase.OptOnTargetDropComplete:
+			// The changefeed restarts at the drop of one of its targets, with the
+			// dropped table resolved up to it.
+			if err = b.removeDroppedTargets(ctx, execCfg, &details, progress); err != nil {
+				err = changefeedbase.MarkRetryableError(
+					errors.Wrap(err, `could not remove dropped targets`))
+			} else if len(details.Targets) == 0 {
+				if policy == changefeedbase.OptOnTargetDropComplete {
+					log.Infof(ctx, `CHANGEFEED job %d completing since all of its targets were dropped`, jobID)
+					return nil
+				}
+				return errors.Errorf(`all targets of the changefeed were dropped`)
+			}
+		}
+
+		if _, ok := details.Opts[changefeedbase.OptChangefeedEpoch]; ok && err == nil {
+			if err = b.startEpoch(ctx, &progress); err != nil {
+				err = changefeedbase.MarkRetryableError(
+					errors.Wrap(err, `could not start a new epoch`))
+			}
 		}
 
-		if knobs, ok := execCfg.DistSQLSrv.TestingKnobs.Changefeed.(*TestingKnobs); ok {
-			if knobs != nil && knobs.HandleDistChangefeedError != nil {
-				err = knobs.HandleDistChangefeedError(err)
+		if _, ok := details.Opts[changefeedbase.ResyncRequested]; ok && err == nil {
+			if err = b.startResync(ctx, &details, &progress); err != nil {
+				err = changefeedbase.MarkRetryableError(
+					errors.Wrap(err, `could not start a resync`))
 			}
 		}
 
+		if err == nil {
+			if err = distChangefeedFlow(ctx, jobExec, jobID, details, progress, startedCh); err == nil {
+				return nil
+			}
+
+			if knobs, ok := execCfg.DistSQLSrv.TestingKnobs.Changefeed.(*TestingKnobs); ok {
+				if knobs != nil && knobs.HandleDistChangefeedError != nil {
+					err = knobs.HandleDistChangefeedError(err)
+				}
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if !changefeedbase.IsRetryableError(err) {
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -834,17 +1719,22 @@ func (b *changefeedResumer) resumeWithRetries(
 			return err
 		}
 
-		log.Warningf(ctx, `WARNING: CHANGEFEED job %d encountered retryable error: %v`, jobID, err)
-		lastRunStatusUpdate = b.setJobRunningStatus(ctx, lastRunStatusUpdate, "retryable error: %s", err)
-		if metrics, ok := execCfg.JobRegistry.MetricsStruct().Changefeed.(*Metrics); ok {
-			sli, err := metrics.getSLIMetrics(details.Opts[changefeedbase.OptMetricsScope])
-			if err != nil {
-				return err
+		if errors.Is(err, errChangefeedResync) {
+			log.Infof(ctx, `CHANGEFEED job %d restarting to resync`, jobID)
+		} else {
+			log.Warningf(ctx, `WARNING: CHANGEFEED job %d encountered retryable error: %v`, jobID, err)
+			lastRunStatusUpdate = b.setJobRunningStatus(ctx, lastRunStatusUpdate, "retryable error: %s", err)
+			if metrics, ok := execCfg.JobRegistry.MetricsStruct().Changefeed.(*Metrics); ok {
+				sli, err := metrics.getSLIMetrics(details.Opts[changefeedbase.OptMetricsScope])
+				if err != nil {
+					return err
+				}
+				sli.ErrorRetries.Inc(1)
 			}
-			sli.ErrorRetries.Inc(1)
 		}
 		// Re-load the job in order to update our progress object, which may have
-		// been updated by the changeFrontier processor since the flow started.
+		// been updated by the changeFrontier processor since the flow started,
+		// and our details, which ALTER CHANGEFEED ... RESYNC may have updated.
 		reloadedJob, reloadErr := execCfg.JobRegistry.LoadClaimedJob(ctx, jobID)
 		if reloadErr != nil {
 			if ctx.Err() != nil {
@@ -855,11 +1745,143 @@ func (b *changefeedResumer) resumeWithRetries(
 				jobID, progress.GetHighWater(), reloadErr)
 		} else {
 			progress = reloadedJob.Progress()
+			details = reloadedJob.Details().(jobspb.ChangefeedDetails)
 		}
 	}
 	return errors.Wrap(err, `ran out of retries`)
 }
 
+// removeDroppedTargets removes the targets which are dropped as of the
+// high-water of the changefeed, which it restarts at when one of them is
+// dropped, from details, and from the details of the job.
+func (b *changefeedResumer) removeDroppedTargets(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	details *jobspb.ChangefeedDetails,
+	progress jobspb.Progress,
+) error {
+	// The high-water is the last timestamp the targets were resolved at, and
+	// the changefeed starts after it, or scans the targets at its statement
+	// time.
+	ts := details.StatementTime
+	if highWater := progress.GetHighWater(); highWater != nil && !highWater.IsEmpty() {
+		ts = highWater.Next()
+	}
+	var dropped []descpb.ID
+	if err := sql.DescsTxn(ctx, execCfg, func(
+		ctx context.Context, txn *kv.Txn, descriptors *descs.Collection,
+	) error {
+		dropped = nil
+		if err := txn.SetFixedTimestamp(ctx, ts); err != nil {
+			return err
+		}
+		for tableID := range details.Targets {
+			flags := tree.ObjectLookupFlagsWithRequired()
+			flags.AvoidLeased = true
+			flags.IncludeDropped = true
+			tableDesc, err := descriptors.GetImmutableTableByID(ctx, txn, tableID, flags)
+			if pgerror.GetPGCode(err) == pgcode.UndefinedTable {
+				// The descriptor is deleted once the data of the dropped table is
+				// garbage collected.
+				dropped = append(dropped, tableID)
+				continue
+			} else if err != nil {
+				return err
+			}
+			if tableDesc.Dropped() {
+				dropped = append(dropped, tableID)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	newDetails := *details
+	newDetails.Targets = make(jobspb.ChangefeedTargets, len(details.Targets))
+	for id, target := range details.Targets {
+		newDetails.Targets[id] = target
+	}
+	for _, id := range dropped {
+		log.Infof(ctx, `CHANGEFEED job %d no longer watches dropped table %s`,
+			b.job.ID(), newDetails.Targets[id].StatementTimeName)
+		delete(newDetails.Targets, id)
+	}
+	if err := b.job.Update(ctx, nil /* txn */, func(
+		txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+	) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		md.Payload.Details = jobspb.WrapPayloadDetails(newDetails)
+		ju.UpdatePayload(md.Payload)
+		return nil
+	}); err != nil {
+		return err
+	}
+	*details = newDetails
+	return nil
+}
+
+// startEpoch increments the epoch of the changefeed in its job progress, for
+// the changefeed_epoch option, and updates progress with it. Each run of the
+// changefeed's flow gets a new epoch, whether it follows a resume, the
+// adoption of the job after a node restart or a retry, since each of them
+// may re-emit the messages emitted after the last checkpoint of the previous
+// run. Consumers can reset their deduplication state when the epoch changes.
+func (b *changefeedResumer) startEpoch(ctx context.Context, progress *jobspb.Progress) error {
+	return b.job.Update(ctx, nil /* txn */, func(
+		txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+	) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		if md.Progress.GetChangefeed() == nil {
+			md.Progress.Details = &jobspb.Progress_Changefeed{Changefeed: &jobspb.ChangefeedProgress{}}
+		}
+		md.Progress.GetChangefeed().Epoch++
+		ju.UpdateProgress(md.Progress)
+		*progress = *md.Progress
+		return nil
+	})
+}
+
+// startResync starts the resync requested by ALTER CHANGEFEED ... RESYNC,
+// replacing the request with the high-water of the changefeed, which its
+// targets are re-scanned at, in details and in the job. Any backfill
+// checkpoint is dropped, as the resync scans all spans from scratch.
+func (b *changefeedResumer) startResync(
+	ctx context.Context, details *jobspb.ChangefeedDetails, progress *jobspb.Progress,
+) error {
+	return b.job.Update(ctx, nil /* txn */, func(
+		txn *kv.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+	) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		newDetails := *details
+		newDetails.Opts = make(map[string]string, len(details.Opts))
+		for k, v := range details.Opts {
+			newDetails.Opts[k] = v
+		}
+		delete(newDetails.Opts, changefeedbase.ResyncRequested)
+		if highWater := md.Progress.GetHighWater(); highWater != nil && !highWater.IsEmpty() {
+			newDetails.Opts[changefeedbase.ResyncTimestamp] = highWater.String()
+		}
+		if cf := md.Progress.GetChangefeed(); cf != nil {
+			cf.Checkpoint = nil
+		}
+		md.Payload.Details = jobspb.WrapPayloadDetails(newDetails)
+		ju.UpdatePayload(md.Payload)
+		ju.UpdateProgress(md.Progress)
+		*details, *progress = newDetails, *md.Progress
+		return nil
+	})
+}
+
 // OnFailOrCancel is part of the jobs.Resumer interface.
 func (b *changefeedResumer) OnFailOrCancel(ctx context.Context, jobExec interface{}) error {
 	exec := jobExec.(sql.JobExecContext)
@@ -956,17 +1978,3 @@ func getQualifiedTableName(
 	)
 	return tbName.String(), nil
 }
-
-// getChangefeedTargetName gets a table name with or without the dots
-func getChangefeedTargetName(
-	ctx context.Context,
-	desc catalog.TableDescriptor,
-	execCfg *sql.ExecutorConfig,
-	txn *kv.Txn,
-	qualified bool,
-) (string, error) {
-	if qualified {
-		return getQualifiedTableName(ctx, execCfg, txn, desc)
-	}
-	return desc.GetName(), nil
-}
@@ -287,6 +287,47 @@ func TestChangefeedDiff(t *testing.T) {
 	t.Run(`pubsub`, pubsubTest(testFn))
 }
 
+func TestChangefeedSparseUpdates(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 'b')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH sparse_updates`)
+		defer closeFeed(t, foo)
+
+		// Rows emitted by the initial scan and inserts contain all columns.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'c', 'd')`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "c": "b"}}`,
+			`foo: [2]->{"after": {"a": 2, "b": "c", "c": "d"}}`,
+		})
+
+		// Updates contain only the key and the changed columns.
+		sqlDB.Exec(t, `UPDATE foo SET c = 'e' WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "c": "e"}}`,
+		})
+		sqlDB.Exec(t, `UPDATE foo SET b = NULL, c = 'f' WHERE a = 2`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": null, "c": "f"}}`,
+		})
+
+		// Deletes contain only the key.
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": null}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestChangefeedTenants(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -445,6 +486,194 @@ func TestChangefeedEnvelope(t *testing.T) {
 	t.Run(`kafka`, kafkaTest(testFn))
 }
 
+// TestChangefeedDeleteFormat verifies that each delete_format represents
+// deletes distinguishably from inserts.
+func TestChangefeedDeleteFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		for _, tc := range []struct {
+			opts           string
+			insert, delete string
+		}{
+			{
+				opts:   `envelope='wrapped'`,
+				insert: `foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+				delete: `foo: [1]->{"after": null}`,
+			},
+			{
+				opts:   `envelope='wrapped', delete_format='after_null'`,
+				insert: `foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+				delete: `foo: [1]->{"after": null}`,
+			},
+			{
+				opts:   `envelope='row'`,
+				insert: `foo: [1]->{"a": 1, "b": "a"}`,
+				delete: `foo: [1]->`,
+			},
+			{
+				opts:   `envelope='wrapped', delete_format='tombstone'`,
+				insert: `foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+				delete: `foo: [1]->`,
+			},
+			{
+				opts:   `envelope='row', delete_format='null'`,
+				insert: `foo: [1]->{"a": 1, "b": "a"}`,
+				delete: `foo: [1]->null`,
+			},
+			{
+				opts:   `envelope='wrapped', delete_format='op'`,
+				insert: `foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+				delete: `foo: [1]->{"key": [1], "op": "delete"}`,
+			},
+			{
+				opts:   `envelope='key_only', delete_format='op'`,
+				insert: `foo: [1]->`,
+				delete: `foo: [1]->{"key": [1], "op": "delete"}`,
+			},
+		} {
+			t.Run(tc.opts, func(t *testing.T) {
+				sqlDB.Exec(t, `UPSERT INTO foo VALUES (1, 'a')`)
+				foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH `+tc.opts)
+				defer closeFeed(t, foo)
+				assertPayloads(t, foo, []string{tc.insert})
+				sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+				assertPayloads(t, foo, []string{tc.delete})
+			})
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+// TestChangefeedTTLDeletes verifies that the ttl_deletes option tells apart
+// the deletes of expired rows from those of live rows.
+func TestChangefeedDeletedFlagEnvelope(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT, b STRING, c INT, PRIMARY KEY (a, b))`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 10), (2, 'b', 20)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH envelope='deleted_flag'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1, "a"]->{"a": 1, "b": "a", "c": 10, "deleted": false}`,
+			`foo: [2, "b"]->{"a": 2, "b": "b", "c": 20, "deleted": false}`,
+		})
+
+		// Deletes hold their key, and null values of their other columns.
+		sqlDB.Exec(t, `UPDATE foo SET c = 11 WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertPayloads(t, foo, []string{
+			`foo: [1, "a"]->{"a": 1, "b": "a", "c": 11, "deleted": false}`,
+			`foo: [2, "b"]->{"a": 2, "b": "b", "c": null, "deleted": true}`,
+		})
+
+		sqlDB.Exec(t, `CREATE TABLE bar (a INT PRIMARY KEY, deleted BOOL)`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1, false)`)
+		bar := feed(t, f, `CREATE CHANGEFEED FOR bar WITH envelope='deleted_flag'`)
+		defer closeFeed(t, bar)
+		_, err := bar.Next()
+		require.Regexp(t, `column deleted of table bar conflicts with the deleted field of envelope=deleted_flag`, err)
+
+		sqlDB.ExpectErr(t, `delete_format is not usable with envelope=deleted_flag`,
+			`CREATE CHANGEFEED FOR foo WITH envelope='deleted_flag', delete_format='null'`)
+		sqlDB.ExpectErr(t, `envelope=deleted_flag is only usable with format=json`,
+			`CREATE CHANGEFEED FOR foo WITH envelope='deleted_flag', format=msgpack`)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedTTLDeletes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY) WITH (ttl_expire_after = '10 minutes')`)
+		sqlDB.Exec(t, `CREATE TABLE bar (a INT PRIMARY KEY)`)
+		// The row of foo which expired is deleted as the TTL job would.
+		sqlDB.Exec(t, `INSERT INTO foo (a, crdb_internal_expiration) VALUES (1, now() - '1 hour'), (2, now() + '1 hour')`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo, bar WITH ttl_deletes, no_initial_scan`)
+		defer closeFeed(t, foo)
+
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a IN (1, 2)`)
+		sqlDB.Exec(t, `DELETE FROM bar WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": null, "ttl_delete": true}`,
+			`foo: [2]->{"after": null, "ttl_delete": false}`,
+			`bar: [1]->{"after": null, "ttl_delete": false}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedDebeziumEnvelope(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH envelope='debezium', full_table_name`)
+		defer closeFeed(t, foo)
+
+		// The processing time of the events is not deterministic, so it is
+		// not compared.
+		assertEvent := func(op, before, after string) {
+			t.Helper()
+			m, err := foo.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			require.Equal(t, `d.public.foo`, m.Topic)
+			var event struct {
+				Before, After json.RawMessage
+				Op            string
+				Source        struct {
+					Snapshot, DB, Schema, Table string
+				}
+			}
+			require.NoError(t, json.Unmarshal(m.Value, &event), string(m.Value))
+			require.Equal(t, op, event.Op, string(m.Value))
+			require.JSONEq(t, before, string(event.Before), string(m.Value))
+			require.JSONEq(t, after, string(event.After), string(m.Value))
+			require.Equal(t, []string{`d`, `public`, `foo`},
+				[]string{event.Source.DB, event.Source.Schema, event.Source.Table})
+			require.Equal(t, op == `r`, event.Source.Snapshot == `true`)
+		}
+		assertEvent(`r`, `null`, `{"a": 1, "b": "a"}`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'b')`)
+		assertEvent(`c`, `null`, `{"a": 2, "b": "b"}`)
+		sqlDB.Exec(t, `UPDATE foo SET b = 'c' WHERE a = 2`)
+		assertEvent(`u`, `{"a": 2, "b": "b"}`, `{"a": 2, "b": "c"}`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertEvent(`d`, `{"a": 2, "b": "c"}`, `null`)
+	}
+
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestChangefeedFullTableName(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -495,6 +724,36 @@ func TestChangefeedMultiTable(t *testing.T) {
 	t.Run(`pubsub`, pubsubTest(testFn))
 }
 
+func TestChangefeedTargetOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE log (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO log VALUES (1, 'a')`)
+		sqlDB.Exec(t, `CREATE TABLE config (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO config VALUES (2, 'b')`)
+
+		logAndConfig := feed(t, f, `CREATE CHANGEFEED FOR TABLE log (envelope='key_only'), config (diff)`)
+		defer closeFeed(t, logAndConfig)
+
+		assertPayloads(t, logAndConfig, []string{
+			`log: [1]->`,
+			`config: [2]->{"after": {"a": 2, "b": "b"}, "before": null}`,
+		})
+		sqlDB.Exec(t, `UPSERT INTO log VALUES (1, 'c')`)
+		sqlDB.Exec(t, `UPSERT INTO config VALUES (2, 'd')`)
+		assertPayloads(t, logAndConfig, []string{
+			`log: [1]->`,
+			`config: [2]->{"after": {"a": 2, "b": "d"}, "before": {"a": 2, "b": "b"}}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestChangefeedCursor(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -649,92 +908,1196 @@ func TestChangefeedMVCCTimestamps(t *testing.T) {
 	t.Run(`sinkless`, sinklessTest(testFn))
 	t.Run(`enterprise`, enterpriseTest(testFn))
 	t.Run(`kafka`, kafkaTest(testFn))
-	t.Run(`webhook`, webhookTest(testFn))
-	t.Run(`pubsub`, pubsubTest(testFn))
+	t.Run(`webhook`, webhookTest(testFn))
+	t.Run(`pubsub`, pubsubTest(testFn))
+}
+
+func TestChangefeedRangeInfo(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		var rangeID, leaseholder int
+		sqlDB.QueryRow(t, `SELECT range_id, lease_holder FROM [SHOW RANGES FROM TABLE foo]`).Scan(
+			&rangeID, &leaseholder)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH range_info`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			fmt.Sprintf(`foo: [1]->{"after": {"a": 1}, "leaseholder_node_id": %d, "range_id": %d}`,
+				leaseholder, rangeID),
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedProvenance(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		var rangeID, leaseholder int
+		sqlDB.QueryRow(t, `SELECT range_id, lease_holder FROM [SHOW RANGES FROM TABLE foo]`).Scan(
+			&rangeID, &leaseholder)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH provenance`)
+		defer closeFeed(t, foo)
+		const provenance = `"provenance": {"emitter_node_id": 1, "leaseholder_node_id": %d, "range_id": %d, "source": "%s"}`
+		assertPayloads(t, foo, []string{
+			fmt.Sprintf(`foo: [1]->{"after": {"a": 1}, `+provenance+`}`, leaseholder, rangeID, `scan`),
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		assertPayloads(t, foo, []string{
+			fmt.Sprintf(`foo: [2]->{"after": {"a": 2}, `+provenance+`}`, leaseholder, rangeID, `rangefeed`),
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedSourceCluster(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		var clusterID string
+		sqlDB.QueryRow(t, `SELECT crdb_internal.cluster_id()`).Scan(&clusterID)
+		sourceCluster := fmt.Sprintf(`{"cluster_id": "%s", "tenant_id": 1}`, clusterID)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH source_cluster, resolved`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1}, "source_cluster": ` + sourceCluster + `}`,
+		})
+
+		// Resolved timestamps carry the same field as rows.
+		for {
+			m, err := foo.Next()
+			require.NoError(t, err)
+			if m.Resolved == nil {
+				continue
+			}
+			var resolved struct {
+				SourceCluster map[string]interface{} `json:"source_cluster"`
+			}
+			require.NoError(t, json.Unmarshal(m.Resolved, &resolved))
+			require.Equal(t, map[string]interface{}{
+				`cluster_id`: clusterID,
+				`tenant_id`:  float64(1),
+			}, resolved.SourceCluster)
+			break
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedEpoch(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH changefeed_epoch, resolved`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1}, "changefeed_epoch": 1}`,
+		})
+		// Make sure the initial scan is checkpointed, so that it's not repeated
+		// after the resume.
+		expectResolvedTimestamp(t, foo)
+
+		// Each run of the changefeed gets a new epoch.
+		jobFeed := foo.(cdctest.EnterpriseTestFeed)
+		require.NoError(t, jobFeed.Pause())
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		require.NoError(t, jobFeed.Resume())
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2}, "changefeed_epoch": 2}`,
+		})
+	}
+
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedSuppressNoOpUpdates(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH suppress_no_op_updates, resolved = '10ms'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+		})
+
+		// The update writes a new version of the row with the same value, which
+		// is dropped, while the resolved timestamps advance past it.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'a' WHERE a = 1`)
+		var updated string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&updated)
+		updatedTS := parseTimeToHLC(t, updated)
+		for {
+			// expectResolvedTimestamp fails on rows.
+			if resolved, _ := expectResolvedTimestamp(t, foo); updatedTS.Less(resolved) {
+				break
+			}
+		}
+
+		// Updates which change the row, and deletes, are emitted.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "b"}}`,
+			`foo: [1]->{"after": null}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedWatchColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, status STRING, note STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'new', 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH watch_columns = 'status', resolved = '10ms'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "note": "a", "status": "new"}}`,
+		})
+
+		// Updates of the columns which aren't watched are dropped, while the
+		// resolved timestamps advance past them.
+		sqlDB.Exec(t, `UPDATE foo SET note = 'b' WHERE a = 1`)
+		var updated string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&updated)
+		updatedTS := parseTimeToHLC(t, updated)
+		for {
+			// expectResolvedTimestamp fails on rows.
+			if resolved, _ := expectResolvedTimestamp(t, foo); updatedTS.Less(resolved) {
+				break
+			}
+		}
+
+		// Updates of the watched column, inserts and deletes are emitted.
+		sqlDB.Exec(t, `UPDATE foo SET status = 'done', note = 'c' WHERE a = 1`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'new', 'a')`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "note": "c", "status": "done"}}`,
+			`foo: [2]->{"after": {"a": 2, "note": "a", "status": "new"}}`,
+			`foo: [1]->{"after": null}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedResolvedMinRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved = '10ms', `+
+			`resolved_min_rows = '2', resolved_max_interval = '1h'`)
+		defer closeFeed(t, foo)
+		expectRow := func(expected string) {
+			t.Helper()
+			m, err := foo.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m.Key, `expected row, got resolved timestamp %s`, m.Resolved)
+			require.Equal(t, expected, fmt.Sprintf(`%s: %s->%s`, m.Topic, m.Key, m.Value))
+		}
+
+		// The first resolved timestamp is emitted right away, and the next once
+		// two rows have been flushed since. The rows are read in the order they
+		// were emitted, so no resolved timestamp comes between them.
+		expectResolvedTimestamp(t, foo)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+		expectRow(`foo: [1]->{"after": {"a": 1}}`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		expectRow(`foo: [2]->{"after": {"a": 2}}`)
+		expectResolvedTimestamp(t, foo)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+}
+
+func TestChangefeedBufferFlushPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		registry := f.Server().JobRegistry().(*jobs.Registry)
+		metrics := registry.MetricsStruct().Changefeed.(*Metrics)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1), (2), (3)`)
+
+		// Every row read from the buffer is flushed on its own.
+		before := metrics.BufferFlushes.Count()
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH buffer_flush_rows = '1', `+
+			`buffer_flush_bytes = '1MiB', buffer_flush_interval = '1h'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+			`foo: [2]->{"after": {"a": 2}}`,
+			`foo: [3]->{"after": {"a": 3}}`,
+		})
+		testutils.SucceedsSoon(t, func() error {
+			if flushes := metrics.BufferFlushes.Count() - before; flushes < 3 {
+				return errors.Errorf(`expected at least 3 buffer flushes, got %d`, flushes)
+			}
+			return nil
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedResolvedFrequency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		const freq = 10 * time.Millisecond
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved=$1`, freq.String())
+		defer closeFeed(t, foo)
+
+		// We get each resolved timestamp notification once in each partition.
+		// Grab the first `2 * #partitions`, sort because we might get all from
+		// one partition first, and compare the first and last.
+		resolved := make([]hlc.Timestamp, 2*len(foo.Partitions()))
+		for i := range resolved {
+			resolved[i], _ = expectResolvedTimestamp(t, foo)
+		}
+		sort.Slice(resolved, func(i, j int) bool { return resolved[i].Less(resolved[j]) })
+		first, last := resolved[0], resolved[len(resolved)-1]
+
+		if d := last.GoTime().Sub(first.GoTime()); d < freq {
+			t.Errorf(`expected %s between resolved timestamps, but got %s`, freq, d)
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`webhook`, webhookTest(testFn))
+	t.Run(`pubsub`, pubsubTest(testFn))
+}
+
+// TestChangefeedHeartbeat verifies that the heartbeat option emits resolved
+// timestamps for an idle changefeed without the resolved option.
+func TestChangefeedHeartbeat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH heartbeat='10ms'`)
+		defer closeFeed(t, foo)
+
+		// Heartbeats never regress, and keep coming although the table is
+		// never written to.
+		last := make(map[string]hlc.Timestamp)
+		for i := 0; i < 2*len(foo.Partitions()); i++ {
+			resolved, partition := expectResolvedTimestamp(t, foo)
+			if resolved.Less(last[partition]) {
+				t.Errorf(`resolved timestamp %s regressed from %s`, resolved, last[partition])
+			}
+			last[partition] = resolved
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`webhook`, webhookTest(testFn))
+	t.Run(`pubsub`, pubsubTest(testFn))
+}
+
+func TestChangefeedWatermarkLag(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const lag = 2 * time.Second
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved='10ms', watermark_lag='2s'`)
+		defer closeFeed(t, foo)
+
+		// The row is held back until it ages past the lag.
+		beforeInsert := timeutil.Now()
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+		require.GreaterOrEqual(t, timeutil.Since(beforeInsert), lag)
+
+		// And so are the resolved timestamps.
+		for i := 0; i < len(foo.Partitions()); i++ {
+			resolved, _ := expectResolvedTimestamp(t, foo)
+			require.LessOrEqual(t, resolved.WallTime, timeutil.Now().Add(-lag).UnixNano())
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedFreshnessRange(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved, freshness='range'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+
+		// The resolved timestamps, emitted by the change aggregator, keep
+		// advancing past the row.
+		var ts string
+		sqlDB.QueryRow(t, `INSERT INTO foo VALUES (2) RETURNING cluster_logical_timestamp()`).Scan(&ts)
+		insertTS := parseTimeToHLC(t, ts)
+		assertPayloads(t, foo, []string{`foo: [2]->{"after": {"a": 2}}`})
+		var prev hlc.Timestamp
+		for {
+			resolved, _ := expectResolvedTimestamp(t, foo)
+			require.True(t, prev.LessEq(resolved), `%s went backwards from %s`, resolved, prev)
+			prev = resolved
+			if insertTS.Less(resolved) {
+				break
+			}
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+// TestChangefeedNoRowsAfterResolved verifies that once a resolved timestamp
+// has been emitted, no row at or below it is emitted on the same partition.
+func TestChangefeedNoRowsAfterResolved(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b INT)`)
+		sqlDB.Exec(t, `INSERT INTO foo SELECT i, 0 FROM generate_series(1, 10) AS g(i)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH updated, resolved='10ms'`)
+		defer closeFeed(t, foo)
+
+		var done int64
+		g := ctxgroup.WithContext(context.Background())
+		g.GoCtx(func(ctx context.Context) error {
+			for i := 0; atomic.LoadInt64(&done) == 0; i++ {
+				if _, err := db.Exec(`UPDATE foo SET b = $1 WHERE a = $2`, i, i%10+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		defer func() {
+			atomic.StoreInt64(&done, 1)
+			require.NoError(t, g.Wait())
+		}()
+
+		resolved := make(map[string]hlc.Timestamp)
+		var rows, resolvedAfterRows int
+		for resolvedAfterRows < 5*len(foo.Partitions()) {
+			m, err := foo.Next()
+			require.NoError(t, err)
+			if m.Resolved != nil {
+				ts := extractResolvedTimestamp(t, m)
+				if resolved[m.Partition].Less(ts) {
+					resolved[m.Partition] = ts
+				}
+				if rows > 0 {
+					resolvedAfterRows++
+				}
+				continue
+			}
+			updated, _, err := cdctest.ParseJSONValueTimestamps(m.Value)
+			require.NoError(t, err)
+			if r := resolved[m.Partition]; updated.LessEq(r) {
+				t.Fatalf(`partition %s: row %s -> %s at %s emitted after resolved timestamp %s`,
+					m.Partition, m.Key, m.Value, updated.AsOfSystemTime(), r.AsOfSystemTime())
+			}
+			rows++
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`cloudstorage`, cloudStorageTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`webhook`, webhookTest(testFn))
+	t.Run(`pubsub`, pubsubTest(testFn))
+}
+
+func TestChangefeedResolvedWindow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved='10ms', resolved_window`)
+		defer closeFeed(t, foo)
+
+		// The first resolved timestamp has no previous one, and each of the
+		// following ones starts its window at the one before it.
+		var previous hlc.Timestamp
+		for i := 0; i < 3; i++ {
+			resolved := expectResolvedTimestampWindow(t, foo, previous)
+			require.True(t, previous.Less(resolved))
+			previous = resolved
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedResolvedSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved='10ms', resolved_spans`)
+		defer closeFeed(t, foo)
+
+		m, err := foo.Next()
+		require.NoError(t, err)
+		require.NotNil(t, m)
+		resolved := extractResolvedTimestamp(t, m)
+
+		// Every span of the table is resolved at least as far as the
+		// changefeed.
+		var spansRaw struct {
+			Spans []struct {
+				Start    string `json:"start"`
+				End      string `json:"end"`
+				Resolved string `json:"resolved"`
+			} `json:"spans"`
+			SpansTruncated bool `json:"spans_truncated"`
+		}
+		require.NoError(t, json.Unmarshal(m.Resolved, &spansRaw))
+		require.NotEmpty(t, spansRaw.Spans, string(m.Resolved))
+		require.False(t, spansRaw.SpansTruncated)
+		for _, s := range spansRaw.Spans {
+			require.NotEmpty(t, s.Start)
+			require.NotEmpty(t, s.End)
+			require.False(t, parseTimeToHLC(t, s.Resolved).Less(resolved), string(m.Resolved))
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+// Test how Changefeeds react to schema changes that do not require a backfill
+// operation.
+func TestChangefeedInitialScan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		t.Run(`no cursor - no initial scan`, func(t *testing.T) {
+			sqlDB.Exec(t, `CREATE TABLE no_initial_scan (a INT PRIMARY KEY)`)
+			sqlDB.Exec(t, `INSERT INTO no_initial_scan VALUES (1)`)
+
+			noInitialScan := feed(t, f, `CREATE CHANGEFEED FOR no_initial_scan `+
+				`WITH no_initial_scan, resolved='1s'`)
+			defer closeFeed(t, noInitialScan)
+			expectResolvedTimestamp(t, noInitialScan)
+			sqlDB.Exec(t, `INSERT INTO no_initial_scan VALUES (2)`)
+			assertPayloads(t, noInitialScan, []string{
+				`no_initial_scan: [2]->{"after": {"a": 2}}`,
+			})
+		})
+
+		t.Run(`cursor - with initial scan`, func(t *testing.T) {
+			sqlDB.Exec(t, `CREATE TABLE initial_scan (a INT PRIMARY KEY)`)
+			sqlDB.Exec(t, `INSERT INTO initial_scan VALUES (1), (2), (3)`)
+			var tsStr string
+			var i int
+			sqlDB.QueryRow(t, `SELECT count(*), cluster_logical_timestamp() from initial_scan`).Scan(&i, &tsStr)
+			initialScan := feed(t, f, `CREATE CHANGEFEED FOR initial_scan `+
+				`WITH initial_scan, resolved='1s', cursor='`+tsStr+`'`)
+			defer closeFeed(t, initialScan)
+			assertPayloads(t, initialScan, []string{
+				`initial_scan: [1]->{"after": {"a": 1}}`,
+				`initial_scan: [2]->{"after": {"a": 2}}`,
+				`initial_scan: [3]->{"after": {"a": 3}}`,
+			})
+			sqlDB.Exec(t, `INSERT INTO initial_scan VALUES (4)`)
+			assertPayloads(t, initialScan, []string{
+				`initial_scan: [4]->{"after": {"a": 4}}`,
+			})
+		})
+
+		t.Run(`initial scan only`, func(t *testing.T) {
+			sqlDB.Exec(t, `CREATE TABLE initial_scan_only (a INT PRIMARY KEY)`)
+			sqlDB.Exec(t, `INSERT INTO initial_scan_only VALUES (1), (2)`)
+			var tsStr string
+			sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&tsStr)
+			initialScanOnly := feed(t, f, `CREATE CHANGEFEED FOR initial_scan_only `+
+				`WITH initial_scan_only, cursor='`+tsStr+`'`)
+			defer closeFeed(t, initialScanOnly)
+			sqlDB.Exec(t, `INSERT INTO initial_scan_only VALUES (3)`)
+			assertPayloads(t, initialScanOnly, []string{
+				`initial_scan_only: [1]->{"after": {"a": 1}}`,
+				`initial_scan_only: [2]->{"after": {"a": 2}}`,
+			})
+			// The final resolved timestamp is the time of the scan, although the
+			// resolved option wasn't specified.
+			resolved, _ := expectResolvedTimestamp(t, initialScanOnly)
+			require.Equal(t, parseTimeToHLC(t, tsStr), resolved)
+			if jf, ok := initialScanOnly.(cdctest.EnterpriseTestFeed); ok {
+				waitForJobStatus(sqlDB, t, jf.JobID(), `succeeded`)
+			}
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`webhook`, webhookTest(testFn))
+	t.Run(`pubsub`, pubsubTest(testFn))
+}
+
+func TestChangefeedInitialScanOrdered(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		// Insert the rows out of order, and spread them over several ranges
+		// which would otherwise be scanned concurrently.
+		sqlDB.Exec(t, `INSERT INTO foo SELECT (i * 7) % 50 FROM generate_series(0, 49) AS g(i)`)
+		sqlDB.Exec(t, `ALTER TABLE foo SPLIT AT VALUES (10), (20), (30), (40)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH initial_scan_ordered`)
+		defer closeFeed(t, foo)
+
+		for i := 0; i < 50; i++ {
+			m, err := foo.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			require.Equal(t, fmt.Sprintf(`[%d]`, i), string(m.Key))
+		}
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedRowHash(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		var cursor string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&cursor)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		next := func(t *testing.T, f cdctest.TestFeed) *cdctest.TestFeedMessage {
+			m, err := f.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			require.Contains(t, string(m.Value), `"row_hash": "`)
+			require.True(t, verifyRowHash(m.Key, m.Value), string(m.Value))
+			return m
+		}
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH updated, row_hash`)
+		defer closeFeed(t, foo)
+		inserted := next(t, foo)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		deleted := next(t, foo)
+		require.Contains(t, string(deleted.Value), `"after": null`)
+
+		// The hash covers the key and the value.
+		tampered := []byte(strings.Replace(string(inserted.Value), `"b": "a"`, `"b": "c"`, 1))
+		require.NotEqual(t, inserted.Value, tampered)
+		require.False(t, verifyRowHash(inserted.Key, tampered))
+		require.False(t, verifyRowHash([]byte(`[2]`), inserted.Value))
+
+		// The same version of a row has the same hash whenever it's emitted.
+		restarted := feed(t, f, `CREATE CHANGEFEED FOR foo WITH updated, row_hash, cursor=$1`, cursor)
+		defer closeFeed(t, restarted)
+		require.Equal(t, string(inserted.Value), string(next(t, restarted).Value))
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedRekey(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH rekey`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+		})
+
+		// The deletion of the old key is folded into the rekey event.
+		sqlDB.Exec(t, `UPDATE foo SET a = 2 WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "a"}, "rekey": {"new_key": [2], "old_key": [1]}}`,
+		})
+
+		// Other changes are emitted as usual.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 2`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (3, 'c')`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "b"}}`,
+			`foo: [3]->{"after": {"a": 3, "b": "c"}}`,
+			`foo: [2]->{"after": null}`,
+		})
+
+		// Changing the keys of several rows at once can't be told apart from
+		// deleting some rows and inserting others.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (4, 'd')`)
+		sqlDB.Exec(t, `UPDATE foo SET a = a + 10 WHERE a IN (3, 4)`)
+		assertPayloads(t, foo, []string{
+			`foo: [4]->{"after": {"a": 4, "b": "d"}}`,
+			`foo: [3]->{"after": null}`,
+			`foo: [4]->{"after": null}`,
+			`foo: [13]->{"after": {"a": 13, "b": "c"}}`,
+			`foo: [14]->{"after": {"a": 14, "b": "d"}}`,
+		})
+
+		// Neither can changing the key of a row along with its other columns.
+		sqlDB.Exec(t, `UPDATE foo SET a = 15, b = 'e' WHERE a = 13`)
+		assertPayloads(t, foo, []string{
+			`foo: [13]->{"after": null}`,
+			`foo: [15]->{"after": {"a": 15, "b": "e"}}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedValueSize(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'small')`)
+
+		// nextSize returns the value_size field of the next message.
+		nextSize := func(t *testing.T, f cdctest.TestFeed) *int64 {
+			m, err := f.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			var value struct {
+				ValueSize *int64 `json:"value_size"`
+			}
+			require.NoError(t, json.Unmarshal(m.Value, &value), string(m.Value))
+			require.Contains(t, string(m.Value), `"value_size": `)
+			return value.ValueSize
+		}
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH value_size, diff`)
+		defer closeFeed(t, foo)
+		small := nextSize(t, foo)
+		require.NotNil(t, small)
+		require.Greater(t, *small, int64(len(`small`)))
+		require.Less(t, *small, int64(64))
+
+		sqlDB.Exec(t, `UPSERT INTO foo VALUES (1, repeat('x', 1000))`)
+		large := nextSize(t, foo)
+		require.NotNil(t, large)
+		require.Greater(t, *large, int64(1000))
+		require.Less(t, *large, int64(1064))
+
+		// Deletes report the size of the deleted value, which is only known
+		// with previous values.
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		deleted := nextSize(t, foo)
+		require.NotNil(t, deleted)
+		require.Equal(t, *large, *deleted)
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'small')`)
+		withoutDiff := feed(t, f, `CREATE CHANGEFEED FOR foo WITH value_size, no_initial_scan`)
+		defer closeFeed(t, withoutDiff)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		require.Nil(t, nextSize(t, withoutDiff))
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedStatementTag(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (0, 'scanned')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH statement_tag`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [0]->{"after": {"a": 0, "b": "scanned"}, "stmt": null}`,
+		})
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'insert')`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "insert"}, "stmt": "INSERT"}`,
+		})
+		sqlDB.Exec(t, `UPDATE foo SET b = 'update' WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "update"}, "stmt": "UPDATE"}`,
+		})
+		// The statement isn't recoverable from the KVs, so upserts are tagged
+		// by whether the row existed.
+		sqlDB.Exec(t, `UPSERT INTO foo VALUES (1, 'upsert')`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "upsert"}, "stmt": "UPDATE"}`,
+		})
+		sqlDB.Exec(t, `UPSERT INTO foo VALUES (2, 'upsert')`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "upsert"}, "stmt": "INSERT"}`,
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'on conflict') ON CONFLICT (a) DO UPDATE SET b = excluded.b`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "on conflict"}, "stmt": "UPDATE"}`,
+		})
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": null, "stmt": "DELETE"}`,
+		})
+
+		// Unwrapped rows carry the tag in their metadata.
+		bare := feed(t, f, `CREATE CHANGEFEED FOR foo WITH statement_tag, envelope=row, no_initial_scan`)
+		defer closeFeed(t, bare)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (3, 'bare')`)
+		assertPayloads(t, bare, []string{
+			`foo: [3]->{"__crdb__": {"stmt": "INSERT"}, "a": 3, "b": "bare"}`,
+		})
+
+		sqlDB.ExpectErr(t, `statement_tag is only usable with format=json`,
+			`CREATE CHANGEFEED FOR foo WITH statement_tag, format=avro, confluent_schema_registry='http://localhost'`)
+		sqlDB.ExpectErr(t, `statement_tag is not supported with envelope=connect`,
+			`CREATE CHANGEFEED FOR foo WITH statement_tag, envelope=connect`)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+}
+
+func TestChangefeedIdempotencyToken(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// readTokens reads n messages from the feed, and returns the idempotency
+	// tokens of their row versions by key.
+	readTokens := func(t *testing.T, f cdctest.TestFeed, n int) map[string]string {
+		tokens := make(map[string]string)
+		for i := 0; i < n; i++ {
+			m, err := f.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			var value map[string]interface{}
+			require.NoError(t, json.Unmarshal(m.Value, &value))
+			if meta, ok := value[jsonMetaSentinel].(map[string]interface{}); ok {
+				value = meta
+			}
+			token := value[idempotencyTokenField]
+			// Avro messages are rendered as JSON, with unions as objects.
+			if union, ok := token.(map[string]interface{}); ok {
+				token = union[`string`]
+			}
+			require.IsType(t, ``, token, string(m.Value))
+			require.Len(t, token, 64)
+			// The keys of avro messages hold the same columns as those of JSON
+			// messages, but are rendered differently.
+			var key interface{}
+			require.NoError(t, json.Unmarshal(m.Key, &key))
+			if k, ok := key.(map[string]interface{}); ok {
+				key = []interface{}{k[`a`].(map[string]interface{})[`long`]}
+			}
+			tokens[fmt.Sprint(key)] = token.(string)
+		}
+		return tokens
+	}
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'b')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH idempotency_token`)
+		tokens := readTokens(t, foo, 2)
+		require.NotEqual(t, tokens[`[1]`], tokens[`[2]`])
+		closeFeed(t, foo)
+
+		// The same row versions have the same tokens when they're emitted
+		// again, whatever the envelope.
+		restarted := feed(t, f, `CREATE CHANGEFEED FOR foo WITH idempotency_token, envelope=row`)
+		defer closeFeed(t, restarted)
+		assertPayloads(t, restarted, []string{
+			fmt.Sprintf(`foo: [1]->{"__crdb__": {"idempotency_token": "%s"}, "a": 1, "b": "a"}`, tokens[`[1]`]),
+			fmt.Sprintf(`foo: [2]->{"__crdb__": {"idempotency_token": "%s"}, "a": 2, "b": "b"}`, tokens[`[2]`]),
+		})
+
+		// Other versions of a row have other tokens.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'c' WHERE a = 1`)
+		updated := readTokens(t, restarted, 1)
+		require.NotEqual(t, tokens[`[1]`], updated[`[1]`])
+
+		sqlDB.ExpectErr(t, `idempotency_token is only usable with format=avro or format=json`,
+			`CREATE CHANGEFEED FOR foo WITH idempotency_token, format=msgpack`)
+		sqlDB.ExpectErr(t, `idempotency_token is not supported with envelope=connect`,
+			`CREATE CHANGEFEED FOR foo WITH idempotency_token, envelope=connect`)
+	}
+
+	// The tokens of row versions emitted in JSON and avro match.
+	testFnAvro := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (2, 'b')`)
+
+		fooJSON := feed(t, f, `CREATE CHANGEFEED FOR foo WITH idempotency_token`)
+		defer closeFeed(t, fooJSON)
+		fooAvro := feed(t, f, `CREATE CHANGEFEED FOR foo WITH idempotency_token, format=avro`)
+		defer closeFeed(t, fooAvro)
+		require.Equal(t, readTokens(t, fooJSON, 2), readTokens(t, fooAvro, 2))
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`kafka/format=avro`, kafkaTest(testFnAvro))
+}
+
+func TestChangefeedDeleteFullRow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c INT)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 10)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH delete_full_row`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "c": 10}}`,
+		})
+
+		// Only deletes carry the previous values of their rows.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "b", "c": 10}}`,
+			`foo: [1]->{"after": null, "before": {"a": 1, "b": "b", "c": 10}}`,
+		})
+
+		sqlDB.ExpectErr(t, `delete_full_row is only usable with envelope=wrapped`,
+			`CREATE CHANGEFEED FOR foo WITH delete_full_row, envelope=row`)
+		sqlDB.ExpectErr(t, `delete_full_row is only usable with format=avro or format=json`,
+			`CREATE CHANGEFEED FOR foo WITH delete_full_row, format=msgpack`)
+	}
+
+	testFnAvro := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH delete_full_row, format=avro`)
+		defer closeFeed(t, foo)
+		sqlDB.Exec(t, `UPDATE foo SET b = 'b' WHERE a = 1`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: {"a":{"long":1}}->{"after":{"foo":{"a":{"long":1},"b":{"string":"a"}}},"before":null}`,
+			`foo: {"a":{"long":1}}->{"after":{"foo":{"a":{"long":1},"b":{"string":"b"}}},"before":null}`,
+			`foo: {"a":{"long":1}}->{"after":null,"before":{"foo_before":{"a":{"long":1},"b":{"string":"b"}}}}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+	t.Run(`kafka/format=avro`, kafkaTest(testFnAvro))
+}
+
+func TestChangefeedVersionField(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b INT)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 0), (2, 0)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH version_field, mvcc_timestamp`)
+		defer closeFeed(t, foo)
+
+		// Update both keys repeatedly, both in separate transactions and in the
+		// same one, whose versions share its commit timestamp.
+		const updates = 10
+		for i := 1; i <= updates; i++ {
+			sqlDB.Exec(t, `UPDATE foo SET b = $1 WHERE a = 1`, i)
+			sqlDB.Exec(t, `UPDATE foo SET b = $1 WHERE a IN (1, 2)`, -i)
+		}
+
+		versions := make(map[string]string)
+		for n := 0; n < 2+3*updates; n++ {
+			m, err := foo.Next()
+			require.NoError(t, err)
+			require.NotNil(t, m)
+			// Decode the value as consumers parsing JSON numbers as doubles do,
+			// which the version must survive.
+			var value map[string]interface{}
+			require.NoError(t, json.Unmarshal(m.Value, &value))
+
+			// The version is the MVCC timestamp of the row without its decimal
+			// point, zero-padded to a fixed width.
+			version, ok := value[`__crdb_version__`].(string)
+			require.True(t, ok, string(m.Value))
+			require.Len(t, version, 29)
+			require.Equal(t, strings.Replace(value[`mvcc_timestamp`].(string), `.`, ``, 1),
+				strings.TrimLeft(version, `0`))
+
+			key := string(m.Key)
+			if prev, ok := versions[key]; ok {
+				require.Greater(t, version, prev, `version %s of key %s follows %s`, version, key, prev)
+			}
+			versions[key] = version
+		}
+
+		sqlDB.ExpectErr(t, `version_field is only usable with format=json`,
+			`CREATE CHANGEFEED FOR foo WITH version_field, format=msgpack`)
+		sqlDB.ExpectErr(t, `version_field is not usable with envelope=key_only`,
+			`CREATE CHANGEFEED FOR foo WITH version_field, envelope=key_only`)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
 }
 
-func TestChangefeedResolvedFrequency(t *testing.T) {
+func TestChangefeedExcludeColumnTypes(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
 
 	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
 		sqlDB := sqlutils.MakeSQLRunner(db)
-		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `CREATE TABLE foo (a BYTES PRIMARY KEY, b BYTES, c STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES ('a', 'blob', 'x')`)
 
-		const freq = 10 * time.Millisecond
-		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH resolved=$1`, freq.String())
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH exclude_column_types='BYTES'`)
 		defer closeFeed(t, foo)
 
-		// We get each resolved timestamp notification once in each partition.
-		// Grab the first `2 * #partitions`, sort because we might get all from
-		// one partition first, and compare the first and last.
-		resolved := make([]hlc.Timestamp, 2*len(foo.Partitions()))
-		for i := range resolved {
-			resolved[i], _ = expectResolvedTimestamp(t, foo)
-		}
-		sort.Slice(resolved, func(i, j int) bool { return resolved[i].Less(resolved[j]) })
-		first, last := resolved[0], resolved[len(resolved)-1]
+		// The primary key is kept even though it's BYTES.
+		assertPayloads(t, foo, []string{
+			`foo: ["\\x61"]->{"after": {"a": "\\x61", "c": "x"}}`,
+		})
 
-		if d := last.GoTime().Sub(first.GoTime()); d < freq {
-			t.Errorf(`expected %s between resolved timestamps, but got %s`, freq, d)
-		}
+		// Columns of the excluded types added later on are dropped as well.
+		sqlDB.Exec(t, `ALTER TABLE foo ADD COLUMN d BYTES`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES ('b', 'blob', 'y', 'blob')`)
+		assertPayloads(t, foo, []string{
+			`foo: ["\\x62"]->{"after": {"a": "\\x62", "c": "y"}}`,
+		})
+
+		sqlDB.ExpectErr(t, `exclude_column_types type "nosuchtype" is not a built-in type`,
+			`CREATE CHANGEFEED FOR foo WITH exclude_column_types='BYTES, nosuchtype'`)
+		sqlDB.ExpectErr(t, `exclude_column_types drops every column of table foo outside of its primary key`,
+			`CREATE CHANGEFEED FOR foo WITH exclude_column_types='BYTES, STRING'`)
+		sqlDB.ExpectErr(t, `watch_columns column "b" does not exist in table foo`,
+			`CREATE CHANGEFEED FOR foo WITH exclude_column_types='BYTES', watch_columns='b'`)
 	}
 
 	t.Run(`sinkless`, sinklessTest(testFn))
 	t.Run(`enterprise`, enterpriseTest(testFn))
 	t.Run(`kafka`, kafkaTest(testFn))
-	t.Run(`webhook`, webhookTest(testFn))
-	t.Run(`pubsub`, pubsubTest(testFn))
 }
 
-// Test how Changefeeds react to schema changes that do not require a backfill
-// operation.
-func TestChangefeedInitialScan(t *testing.T) {
+func TestChangefeedKeyRange(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
 
 	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
 		sqlDB := sqlutils.MakeSQLRunner(db)
-		t.Run(`no cursor - no initial scan`, func(t *testing.T) {
-			sqlDB.Exec(t, `CREATE TABLE no_initial_scan (a INT PRIMARY KEY)`)
-			sqlDB.Exec(t, `INSERT INTO no_initial_scan VALUES (1)`)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT, b STRING, PRIMARY KEY (a, b))`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a'), (5, 'a'), (5, 'c'), (9, 'a')`)
 
-			noInitialScan := feed(t, f, `CREATE CHANGEFEED FOR no_initial_scan `+
-				`WITH no_initial_scan, resolved='1s'`)
-			defer closeFeed(t, noInitialScan)
-			expectResolvedTimestamp(t, noInitialScan)
-			sqlDB.Exec(t, `INSERT INTO no_initial_scan VALUES (2)`)
-			assertPayloads(t, noInitialScan, []string{
-				`no_initial_scan: [2]->{"after": {"a": 2}}`,
-			})
+		// Changefeeds watching complementary ranges together emit every row
+		// exactly once.
+		lower := feed(t, f, `CREATE CHANGEFEED FOR foo WITH key_range = '(NULL, (5, ''b''))'`)
+		defer closeFeed(t, lower)
+		upper := feed(t, f, `CREATE CHANGEFEED FOR foo WITH key_range = '((5, ''b''), NULL)'`)
+		defer closeFeed(t, upper)
+		assertPayloads(t, lower, []string{
+			`foo: [1, "a"]->{"after": {"a": 1, "b": "a"}}`,
+			`foo: [5, "a"]->{"after": {"a": 5, "b": "a"}}`,
 		})
-
-		t.Run(`cursor - with initial scan`, func(t *testing.T) {
-			sqlDB.Exec(t, `CREATE TABLE initial_scan (a INT PRIMARY KEY)`)
-			sqlDB.Exec(t, `INSERT INTO initial_scan VALUES (1), (2), (3)`)
-			var tsStr string
-			var i int
-			sqlDB.QueryRow(t, `SELECT count(*), cluster_logical_timestamp() from initial_scan`).Scan(&i, &tsStr)
-			initialScan := feed(t, f, `CREATE CHANGEFEED FOR initial_scan `+
-				`WITH initial_scan, resolved='1s', cursor='`+tsStr+`'`)
-			defer closeFeed(t, initialScan)
-			assertPayloads(t, initialScan, []string{
-				`initial_scan: [1]->{"after": {"a": 1}}`,
-				`initial_scan: [2]->{"after": {"a": 2}}`,
-				`initial_scan: [3]->{"after": {"a": 3}}`,
-			})
-			sqlDB.Exec(t, `INSERT INTO initial_scan VALUES (4)`)
-			assertPayloads(t, initialScan, []string{
-				`initial_scan: [4]->{"after": {"a": 4}}`,
-			})
+		assertPayloads(t, upper, []string{
+			`foo: [5, "c"]->{"after": {"a": 5, "b": "c"}}`,
+			`foo: [9, "a"]->{"after": {"a": 9, "b": "a"}}`,
 		})
+
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (0, 'z'), (5, 'b'), (10, 'a')`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, lower, []string{
+			`foo: [0, "z"]->{"after": {"a": 0, "b": "z"}}`,
+			`foo: [1, "a"]->{"after": null}`,
+		})
+		assertPayloads(t, upper, []string{
+			`foo: [5, "b"]->{"after": {"a": 5, "b": "b"}}`,
+			`foo: [10, "a"]->{"after": {"a": 10, "b": "a"}}`,
+		})
+
+		sqlDB.ExpectErr(t, `key_range must be a tuple of a lower and an upper bound`,
+			`CREATE CHANGEFEED FOR foo WITH key_range = '5'`)
+		sqlDB.ExpectErr(t, `the lower bound of key_range must be less than its upper bound`,
+			`CREATE CHANGEFEED FOR foo WITH key_range = '(5, 1)'`)
+		sqlDB.ExpectErr(t, `key_range bound \(1, 'a', 2\) has more values than the 2 columns of the primary key of foo`,
+			`CREATE CHANGEFEED FOR foo WITH key_range = '((1, ''a'', 2), NULL)'`)
+		sqlDB.ExpectErr(t, `key_range bound \(NULL\) cannot contain NULL`,
+			`CREATE CHANGEFEED FOR foo WITH key_range = '((NULL), 5)'`)
+		sqlDB.ExpectErr(t, `key_range bound 'x'`,
+			`CREATE CHANGEFEED FOR foo WITH key_range = '(''x'', NULL)'`)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedView(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c INT)`)
+		sqlDB.Exec(t, `CREATE VIEW vw (id, name) AS SELECT a, b FROM foo`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'one', 10)`)
+
+		vw := feed(t, f, `CREATE CHANGEFEED FOR vw WITH diff`)
+		defer closeFeed(t, vw)
+		assertPayloads(t, vw, []string{
+			`vw: [1]->{"after": {"id": 1, "name": "one"}, "before": null}`,
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'two', 20)`)
+		sqlDB.Exec(t, `UPDATE foo SET b = 'uno' WHERE a = 1`)
+		assertPayloads(t, vw, []string{
+			`vw: [2]->{"after": {"id": 2, "name": "two"}, "before": null}`,
+			`vw: [1]->{"after": {"id": 1, "name": "uno"}, "before": {"id": 1, "name": "one"}}`,
+		})
+		// Columns which the view doesn't select don't show up, so updating
+		// them emits rows of the view which didn't change.
+		sqlDB.Exec(t, `UPDATE foo SET c = 11 WHERE a = 1`)
+		assertPayloads(t, vw, []string{
+			`vw: [1]->{"after": {"id": 1, "name": "uno"}, "before": {"id": 1, "name": "uno"}}`,
+		})
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertPayloads(t, vw, []string{
+			`vw: [2]->{"after": null, "before": {"id": 2, "name": "two"}}`,
+		})
+
+		for name, tc := range map[string]struct {
+			view, expected string
+		}{
+			`filtered`: {`CREATE VIEW filtered AS SELECT a, b FROM foo WHERE c > 0`,
+				`CHANGEFEED cannot target view filtered: its query filters rows`},
+			`computed`: {`CREATE VIEW computed AS SELECT a, b || 'x' AS b FROM foo`,
+				`CHANGEFEED cannot target view computed: its query selects an expression`},
+			`keyless`: {`CREATE VIEW keyless AS SELECT b, c FROM foo`,
+				`CHANGEFEED cannot target view keyless: its query does not select primary key column a of table foo`},
+			`joined`: {`CREATE VIEW joined AS SELECT foo.a, foo.b FROM foo JOIN foo AS bar ON foo.a = bar.c`,
+				`CHANGEFEED cannot target view joined: its query joins tables`},
+			`nested`: {`CREATE VIEW nested AS SELECT id, name FROM vw`,
+				`CHANGEFEED cannot target view nested: its query selects from view vw`},
+			`materialized`: {`CREATE MATERIALIZED VIEW materialized AS SELECT a, b FROM foo`,
+				`CHANGEFEED cannot target materialized views: materialized`},
+		} {
+			sqlDB.Exec(t, tc.view)
+			sqlDB.ExpectErr(t, tc.expected, `EXPERIMENTAL CHANGEFEED FOR `+name)
+		}
+		sqlDB.ExpectErr(t, `table foo is targeted more than once`,
+			`EXPERIMENTAL CHANGEFEED FOR vw, foo`)
 	}
 
 	t.Run(`sinkless`, sinklessTest(testFn))
 	t.Run(`enterprise`, enterpriseTest(testFn))
 	t.Run(`kafka`, kafkaTest(testFn))
-	t.Run(`webhook`, webhookTest(testFn))
-	t.Run(`pubsub`, pubsubTest(testFn))
 }
 
 func TestChangefeedUserDefinedTypes(t *testing.T) {
@@ -1474,6 +2837,40 @@ func TestChangefeedSchemaChangeAllowBackfill(t *testing.T) {
 	}
 }
 
+func TestChangefeedMaterializeDefaults(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH materialize_defaults`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+
+		sqlDB.Exec(t, `ALTER TABLE foo ADD COLUMN b STRING DEFAULT 'd'`)
+		// Schema change backfill
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1}}`})
+		// Changefeed level backfill
+		assertPayloads(t, foo, []string{`foo: [1]->{"after": {"a": 1, "b": "d"}}`})
+
+		// Rows written with the column are emitted as written, including
+		// explicit NULLs.
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (3, NULL)`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "d"}}`,
+			`foo: [3]->{"after": {"a": 3, "b": null}}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 // Test schema changes that require a backfill on only some watched tables within a changefeed.
 func TestChangefeedSchemaChangeBackfillScope(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -1671,6 +3068,56 @@ func TestChangefeedColumnFamily(t *testing.T) {
 	t.Run(`pubsub`, pubsubTest(testFn))
 }
 
+func TestChangefeedCollapseFamilies(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c STRING, FAMILY f_ab (a, b), FAMILY f_c (c))`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a', 'x')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH collapse_families`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "c": "x"}}`,
+		})
+
+		// Each row version is emitted once, with every column, whichever
+		// families were written.
+		sqlDB.Exec(t, `UPDATE foo SET c = 'y' WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a", "c": "y"}}`,
+		})
+		sqlDB.Exec(t, `UPDATE foo SET b = 'b', c = 'z' WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "b", "c": "z"}}`,
+		})
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (2, 'c', NULL)`)
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "c", "c": null}}`,
+			`foo: [1]->{"after": null}`,
+		})
+
+		// Previous values are read whole as well.
+		fooDiff := feed(t, f, `CREATE CHANGEFEED FOR foo WITH collapse_families, diff, no_initial_scan`)
+		defer closeFeed(t, fooDiff)
+		sqlDB.Exec(t, `UPDATE foo SET c = 'w' WHERE a = 2`)
+		assertPayloads(t, fooDiff, []string{
+			`foo: [2]->{"after": {"a": 2, "b": "c", "c": "w"}, "before": {"a": 2, "b": "c", "c": null}}`,
+		})
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 2`)
+		assertPayloads(t, fooDiff, []string{
+			`foo: [2]->{"after": null, "before": {"a": 2, "b": "c", "c": "w"}}`,
+		})
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+	t.Run(`enterprise`, enterpriseTest(testFn))
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestChangefeedAuthorization(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -1690,6 +3137,14 @@ func TestChangefeedAuthorization(t *testing.T) {
 			statement: `EXPERIMENTAL CHANGEFEED FOR d.table_a WITH resolved='1'`,
 			errMsg:    `missing unit in duration`,
 		},
+		{name: `pebble`,
+			statement: `CREATE CHANGEFEED FOR d.table_a INTO 'pebble:///nope'`,
+			errMsg:    `only users with the admin role are allowed to create a changefeed into a pebble sink`,
+		},
+		{name: `unix`,
+			statement: `CREATE CHANGEFEED FOR d.table_a INTO 'unix:///nope.sock'`,
+			errMsg:    `only users with the admin role are allowed to create a changefeed into a unix sink`,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			s, db, stop := startTestServer(t, feedTestOptions{})
@@ -1817,6 +3272,43 @@ func TestChangefeedOutputTopics(t *testing.T) {
 	t.Run(`kafka`, kafkaTest(testFn))
 }
 
+func TestChangefeedTopicFromColumn(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, tenant STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 't1'), (2, 't2'), (3, NULL)`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH topic_from_column='tenant'`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`t1: [1]->{"after": {"a": 1, "tenant": "t1"}}`,
+			`t2: [2]->{"after": {"a": 2, "tenant": "t2"}}`,
+			`foo: [3]->{"after": {"a": 3, "tenant": null}}`,
+		})
+		// Deletes are routed by the value of the column before the delete.
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		assertPayloads(t, foo, []string{
+			`t1: [1]->{"after": null}`,
+		})
+
+		// Routing to more topics than allowed fails the changefeed.
+		limited := feed(t, f, `CREATE CHANGEFEED FOR foo WITH topic_from_column='tenant', `+
+			`topic_from_column_max_topics='1'`)
+		defer closeFeed(t, limited)
+		for {
+			if _, err := limited.Next(); err != nil {
+				require.Contains(t, err.Error(), `topic_from_column derived more than 1 topics`)
+				break
+			}
+		}
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func requireErrorSoon(
 	ctx context.Context, t *testing.T, f cdctest.TestFeed, errRegex *regexp.Regexp,
 ) {
@@ -2065,6 +3557,58 @@ func TestChangefeedWorksOnRBRChange(t *testing.T) {
 	t.Run("kafka/format=avro", kafkaTest(testFnAvro, opts...))
 }
 
+func TestChangefeedRegion(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE rbr (a INT PRIMARY KEY, b INT) LOCALITY REGIONAL BY ROW`)
+		sqlDB.Exec(t, `INSERT INTO rbr VALUES (0, 1)`)
+
+		rbr := feed(t, f, `CREATE CHANGEFEED FOR rbr WITH region`)
+		defer closeFeed(t, rbr)
+		sqlDB.Exec(t, `DELETE FROM rbr WHERE a = 0`)
+		assertPayloads(t, rbr, []string{
+			`rbr: ["us-east-1", 0]->{"after": {"a": 0, "b": 1, "crdb_region": "us-east-1"}, "crdb_region": "us-east-1"}`,
+			`rbr: ["us-east-1", 0]->{"after": null, "crdb_region": "us-east-1"}`,
+		})
+
+		// The region is that of the region column the table is partitioned
+		// by, whatever its name.
+		sqlDB.Exec(t, `CREATE TABLE rbr_as (a INT PRIMARY KEY, region crdb_internal_region NOT NULL DEFAULT 'us-east-1') `+
+			`LOCALITY REGIONAL BY ROW AS region`)
+		sqlDB.Exec(t, `INSERT INTO rbr_as VALUES (0)`)
+		rbrAs := feed(t, f, `CREATE CHANGEFEED FOR rbr_as WITH region`)
+		defer closeFeed(t, rbrAs)
+		assertPayloads(t, rbrAs, []string{
+			`rbr_as: ["us-east-1", 0]->{"after": {"a": 0, "region": "us-east-1"}, "crdb_region": "us-east-1"}`,
+		})
+
+		sqlDB.Exec(t, `CREATE TABLE global (a INT PRIMARY KEY) LOCALITY GLOBAL`)
+		sqlDB.ExpectErr(t, `region requires table global to be REGIONAL BY ROW`,
+			`CREATE CHANGEFEED FOR global WITH region`)
+		sqlDB.ExpectErr(t, `region is only usable with format=json`,
+			`CREATE CHANGEFEED FOR rbr WITH region, format=avro`)
+	}
+
+	withTestServerRegion := func(args *base.TestServerArgs) {
+		args.Locality.Tiers = append(args.Locality.Tiers, roachpb.Tier{
+			Key:   "region",
+			Value: testServerRegion,
+		})
+	}
+	// Tenants are skipped since multi-region databases are unsupported in
+	// multi-tenancy mode.
+	opts := []feedTestOption{
+		feedTestNoTenants,
+		withArgsFn(withTestServerRegion),
+	}
+	t.Run(`sinkless`, sinklessTest(testFn, opts...))
+	t.Run(`enterprise`, enterpriseTest(testFn, opts...))
+	t.Run(`kafka`, kafkaTest(testFn, opts...))
+}
+
 func TestChangefeedRBRAvroAddRegion(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -2579,6 +4123,107 @@ func TestChangefeedTruncateOrDrop(t *testing.T) {
 	// will sometimes fail, non deterministic
 }
 
+func TestChangefeedOnTargetDrop(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		registry := f.Server().JobRegistry().(*jobs.Registry)
+		createTables := func(t *testing.T, a, b string) {
+			sqlDB.Exec(t, `CREATE TABLE `+a+` (a INT PRIMARY KEY)`)
+			sqlDB.Exec(t, `CREATE TABLE `+b+` (b INT PRIMARY KEY)`)
+			sqlDB.Exec(t, `INSERT INTO `+a+` VALUES (1)`)
+			sqlDB.Exec(t, `INSERT INTO `+b+` VALUES (1)`)
+		}
+		targetNames := func(t *testing.T, feed cdctest.TestFeed) []string {
+			job, err := registry.LoadJob(context.Background(), feed.(cdctest.EnterpriseTestFeed).JobID())
+			require.NoError(t, err)
+			var names []string
+			for _, target := range job.Details().(jobspb.ChangefeedDetails).Targets {
+				names = append(names, target.StatementTimeName)
+			}
+			sort.Strings(names)
+			return names
+		}
+
+		t.Run(`fail`, func(t *testing.T) {
+			createTables(t, `fail_a`, `fail_b`)
+			foo := feed(t, f, `CREATE CHANGEFEED FOR fail_a, fail_b WITH on_target_drop = 'fail'`)
+			defer closeFeed(t, foo)
+			assertPayloads(t, foo, []string{
+				`fail_a: [1]->{"after": {"a": 1}}`,
+				`fail_b: [1]->{"after": {"b": 1}}`,
+			})
+			sqlDB.Exec(t, `DROP TABLE fail_a`)
+			if err := drainUntilErr(foo); !testutils.IsError(err, `"fail_a" was dropped`) {
+				t.Fatalf(`expected ""fail_a" was dropped" error got: %+v`, err)
+			}
+		})
+
+		t.Run(`skip`, func(t *testing.T) {
+			createTables(t, `skip_a`, `skip_b`)
+			foo := feed(t, f, `CREATE CHANGEFEED FOR skip_a, skip_b WITH on_target_drop = 'skip', resolved = '10ms'`)
+			defer closeFeed(t, foo)
+			assertPayloads(t, foo, []string{
+				`skip_a: [1]->{"after": {"a": 1}}`,
+				`skip_b: [1]->{"after": {"b": 1}}`,
+			})
+			var beforeDropStr string
+			sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&beforeDropStr)
+			beforeDrop := parseTimeToHLC(t, beforeDropStr)
+			sqlDB.Exec(t, `DROP TABLE skip_a`)
+			sqlDB.Exec(t, `INSERT INTO skip_b VALUES (2)`)
+
+			// The dropped table gets a final resolved timestamp at its drop, past
+			// which only the remaining target is resolved.
+			var sawRow bool
+			resolved := make(map[string]hlc.Timestamp)
+			for !sawRow || resolved[`skip_a`].Less(beforeDrop) ||
+				!resolved[`skip_a`].Less(resolved[`skip_b`]) {
+				m, err := foo.Next()
+				require.NoError(t, err)
+				if m.Key != nil {
+					require.Equal(t, `skip_b: [2]->{"after": {"b": 2}}`,
+						fmt.Sprintf(`%s: %s->%s`, m.Topic, m.Key, m.Value))
+					sawRow = true
+					continue
+				}
+				resolved[m.Topic] = extractResolvedTimestamp(t, m)
+			}
+			require.Equal(t, []string{`skip_b`}, targetNames(t, foo))
+
+			// The changefeed fails once its last target is dropped.
+			sqlDB.Exec(t, `DROP TABLE skip_b`)
+			feedJob := foo.(cdctest.EnterpriseTestFeed)
+			require.NoError(t, feedJob.WaitForStatus(func(s jobs.Status) bool { return s == jobs.StatusFailed }))
+			require.Regexp(t, `all targets of the changefeed were dropped`, feedJob.FetchTerminalJobErr())
+		})
+
+		t.Run(`complete`, func(t *testing.T) {
+			createTables(t, `complete_a`, `complete_b`)
+			foo := feed(t, f, `CREATE CHANGEFEED FOR complete_a, complete_b WITH on_target_drop = 'complete'`)
+			defer closeFeed(t, foo)
+			assertPayloads(t, foo, []string{
+				`complete_a: [1]->{"after": {"a": 1}}`,
+				`complete_b: [1]->{"after": {"b": 1}}`,
+			})
+			sqlDB.Exec(t, `DROP TABLE complete_a`)
+			sqlDB.Exec(t, `INSERT INTO complete_b VALUES (2)`)
+			assertPayloads(t, foo, []string{
+				`complete_b: [2]->{"after": {"b": 2}}`,
+			})
+			require.Equal(t, []string{`complete_b`}, targetNames(t, foo))
+
+			// The changefeed completes once its last target is dropped.
+			sqlDB.Exec(t, `DROP TABLE complete_b`)
+			waitForJobStatus(sqlDB, t, foo.(cdctest.EnterpriseTestFeed).JobID(), `succeeded`)
+		})
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestChangefeedMonitoring(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -2678,17 +4323,88 @@ func TestChangefeedMonitoring(t *testing.T) {
 		require.NoError(t, foo.Close())
 		require.NoError(t, fooCopy.Close())
 		testutils.SucceedsSoon(t, func() error {
-			if c := s.MustGetSQLCounter(`changefeed.max_behind_nanos`); c != 0 {
-				return errors.Errorf(`expected 0 got %d`, c)
-			}
-			if c := s.MustGetSQLCounter(`changefeed.running`); c != 0 {
-				return errors.Errorf(`expected 0 got %d`, c)
+			if c := s.MustGetSQLCounter(`changefeed.max_behind_nanos`); c != 0 {
+				return errors.Errorf(`expected 0 got %d`, c)
+			}
+			if c := s.MustGetSQLCounter(`changefeed.running`); c != 0 {
+				return errors.Errorf(`expected 0 got %d`, c)
+			}
+			return nil
+		})
+	}
+	// TODO(ssd): tenant tests skipped because of f.Server() use
+	t.Run(`sinkless`, sinklessTest(testFn, feedTestNoTenants))
+}
+
+func TestChangefeedEmittedByTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		registry := f.Server().JobRegistry().(*jobs.Registry)
+		metrics := registry.MetricsStruct().Changefeed.(*Metrics)
+
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `CREATE TABLE bar (b INT PRIMARY KEY)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1), (2), (3)`)
+		sqlDB.Exec(t, `INSERT INTO bar VALUES (1)`)
+
+		foobar := feed(t, f, `CREATE CHANGEFEED FOR foo, bar WITH resolved = '10ms'`)
+		assertPayloads(t, foobar, []string{
+			`foo: [1]->{"after": {"a": 1}}`,
+			`foo: [2]->{"after": {"a": 2}}`,
+			`foo: [3]->{"after": {"a": 3}}`,
+			`bar: [1]->{"after": {"b": 1}}`,
+		})
+
+		metrics.mu.Lock()
+		// The per-table metrics are labeled by the fully qualified names of
+		// the tables, while the topics and job progress use their bare names.
+		fooMetrics, barMetrics := metrics.mu.tables[`d.public.foo`], metrics.mu.tables[`d.public.bar`]
+		metrics.mu.Unlock()
+		require.NotNil(t, fooMetrics)
+		require.NotNil(t, barMetrics)
+		testutils.SucceedsSoon(t, func() error {
+			if foo, bar := fooMetrics.messages.Value(), barMetrics.messages.Value(); foo != 3 || bar != 1 {
+				return errors.Errorf(`expected 3 messages for foo and 1 for bar, found %d and %d`, foo, bar)
+			}
+			return nil
+		})
+		require.Less(t, int64(0), fooMetrics.bytes.Value())
+
+		jobFeed := foobar.(cdctest.EnterpriseTestFeed)
+		testutils.SucceedsSoon(t, func() error {
+			var emitted []byte
+			sqlDB.QueryRow(t,
+				`SELECT emitted_by_table FROM [SHOW CHANGEFEED JOB $1]`, jobFeed.JobID(),
+			).Scan(&emitted)
+			var byTable map[string]struct {
+				EmittedMessages int64 `json:"emittedMessages,string"`
+			}
+			if err := json.Unmarshal(emitted, &byTable); err != nil {
+				return err
+			}
+			if byTable[`foo`].EmittedMessages != 3 || byTable[`bar`].EmittedMessages != 1 {
+				return errors.Errorf(`unexpected emitted_by_table: %s`, emitted)
+			}
+			return nil
+		})
+
+		// The per-table metrics are removed once no changefeed targets the table.
+		closeFeed(t, foobar)
+		testutils.SucceedsSoon(t, func() error {
+			metrics.mu.Lock()
+			defer metrics.mu.Unlock()
+			if len(metrics.mu.tables) != 0 {
+				return errors.Errorf(`expected no per-table metrics, found %d`, len(metrics.mu.tables))
 			}
 			return nil
 		})
 	}
-	// TODO(ssd): tenant tests skipped because of f.Server() use
-	t.Run(`sinkless`, sinklessTest(testFn, feedTestNoTenants))
+
+	t.Run(`enterprise`, enterpriseTest(testFn, feedTestNoTenants))
+	t.Run(`kafka`, kafkaTest(testFn, feedTestNoTenants))
 }
 
 func TestChangefeedRetryableError(t *testing.T) {
@@ -3152,9 +4868,9 @@ func TestChangefeedErrors(t *testing.T) {
 		t, `CHANGEFEED cannot target sequences: seq`,
 		`EXPERIMENTAL CHANGEFEED FOR seq`,
 	)
-	sqlDB.Exec(t, `CREATE VIEW vw AS SELECT a, b FROM foo`)
+	sqlDB.Exec(t, `CREATE VIEW vw AS SELECT a, b FROM foo WHERE a > 0`)
 	sqlDB.ExpectErr(
-		t, `CHANGEFEED cannot target views: vw`,
+		t, `CHANGEFEED cannot target view vw: its query filters rows`,
 		`EXPERIMENTAL CHANGEFEED FOR vw`,
 	)
 
@@ -3312,6 +5028,10 @@ func TestChangefeedErrors(t *testing.T) {
 		t, `client has run out of available brokers`,
 		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_sink_config='{"Flush": {"Messages": 100, "Frequency": "1s"}}'`,
 	)
+	sqlDB.ExpectErr(
+		t, `kafka_idempotent requires RequiredAcks to be "ALL" in kafka_sink_config`,
+		`CREATE CHANGEFEED FOR foo INTO 'kafka://nope/' WITH kafka_idempotent='true', kafka_sink_config='{"RequiredAcks": "ONE"}'`,
+	)
 	sqlDB.ExpectErr(
 		t, `this sink is incompatible with option webhook_client_timeout`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH webhook_client_timeout=''`,
@@ -3328,6 +5048,53 @@ func TestChangefeedErrors(t *testing.T) {
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH topic_in_value, format='experimental_avro'`,
 		`kafka://nope`,
 	)
+	sqlDB.ExpectErr(
+		t, `invalid avro_namespace: "com..acme" is not a valid avro namespace`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', avro_namespace='com..acme'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `avro_fixed_columns column "a" of table foo must be BYTES, not INT8`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', avro_fixed_columns='{"a": 16}'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `avro_fixed_columns columns do not exist in any target table: bar.a, nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', avro_fixed_columns='{"nope": 16, "bar.a": 16}'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `avro_fixed_columns size of column "a" must be a positive integer: 0`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', avro_fixed_columns='{"a": 0}'`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `row_hash is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', row_hash`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `rekey is only usable with envelope=wrapped`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope='row', rekey`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `rekey is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format=avro, rekey`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `rekey is not supported with row_hash`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH rekey, row_hash`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `row_hash is only usable with envelope=wrapped`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope='row', row_hash`,
+		`kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `invalid avro_record_name: "1_table" is not a valid avro name`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', avro_record_name='1_{table}'`,
+		`kafka://nope`,
+	)
 
 	// The topics option should not be exposed to users since it is used
 	// internally to display topics in the show changefeed jobs query
@@ -3339,15 +5106,20 @@ func TestChangefeedErrors(t *testing.T) {
 
 	// The cloudStorageSink is particular about the options it will work with.
 	sqlDB.ExpectErr(
-		t, `this sink is incompatible with option confluent_schema_registry`,
-		`CREATE CHANGEFEED FOR foo INTO $1 WITH format='avro', confluent_schema_registry=$2`,
-		`experimental-nodelocal://0/bar`, schemaReg.URL(),
+		t, `this sink is incompatible with option kafka_sink_config`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH kafka_sink_config='{}'`,
+		`experimental-nodelocal://0/bar`,
 	)
 	sqlDB.ExpectErr(
 		t, `this sink is incompatible with envelope=key_only`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope='key_only'`,
 		`experimental-nodelocal://0/bar`,
 	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option sink_concurrency`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sink_concurrency='4'`,
+		`experimental-nodelocal://0/bar`,
+	)
 
 	// WITH key_in_value requires envelope=wrapped
 	sqlDB.ExpectErr(
@@ -3388,6 +5160,22 @@ func TestChangefeedErrors(t *testing.T) {
 		t, `cannot specify both initial_scan and no_initial_scan`,
 		`CREATE CHANGEFEED FOR foo INTO $1 WITH no_initial_scan, initial_scan`, `kafka://nope`,
 	)
+	sqlDB.ExpectErr(
+		t, `topic_from_column column "nope" does not exist in table foo`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH topic_from_column='nope'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `topic_from_column_max_topics must be a positive integer: "0"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH topic_from_column='a', topic_from_column_max_topics='0'`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `cannot specify both initial_scan_only and no_initial_scan`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan_only, no_initial_scan`, `kafka://nope`,
+	)
+	sqlDB.ExpectErr(
+		t, `emit_window must be a window of the form '22:00-06:00 America/New_York': "22:00"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH emit_window='22:00'`, `kafka://nope`,
+	)
 
 	// Sanity check schema registry tls parameters.
 	sqlDB.ExpectErr(
@@ -3519,6 +5307,227 @@ func TestChangefeedErrors(t *testing.T) {
 		t, `unknown on_error: not_valid, valid values are 'pause' and 'fail'`,
 		`CREATE CHANGEFEED FOR foo into $1 WITH on_error='not_valid'`,
 		`kafka://nope`)
+
+	sqlDB.ExpectErr(
+		t, `max_rows_per_sec must be a positive integer: "0"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH max_rows_per_sec = '0'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `max_emit_bytes_per_sec must be a positive byte size: "lots"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH max_emit_bytes_per_sec = 'lots'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `scan_request_batch_bytes must be a byte size between 1.0 MiB and 256 MiB: "1KiB"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH scan_request_batch_bytes = '1KiB'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `format=orc is only supported by cloud storage and iceberg sinks`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format=orc`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `durable_resolved requires a sink`,
+		`CREATE CHANGEFEED FOR foo WITH durable_resolved`)
+	sqlDB.ExpectErr(
+		t, `durable_resolved is not supported by syslog sinks`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH durable_resolved`, `syslog://nope`)
+	sqlDB.ExpectErr(
+		t, `column_comments with format=json requires a cloud storage sink`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH column_comments`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `column_comments is only usable with format=avro or format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format=orc, column_comments`, `nodelocal://0/nope`)
+	sqlDB.ExpectErr(
+		t, `changefeed_epoch requires a sink`,
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH changefeed_epoch`,
+	)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option event_time`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH event_time`,
+		`webhook-https://fake-host`,
+	)
+	sqlDB.ExpectErr(
+		t, `sequence_numbers requires a sink`,
+		`CREATE CHANGEFEED FOR foo WITH sequence_numbers`)
+	sqlDB.ExpectErr(
+		t, `sequence_numbers is not supported by syslog sinks`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH sequence_numbers`, `syslog://nope`)
+	sqlDB.ExpectErr(
+		t, `local file access is disabled`,
+		`CREATE CHANGEFEED FOR foo INTO $1`, `unix:///nope.sock`)
+	sqlDB.ExpectErr(
+		t, `replay_from requires a sink`,
+		`CREATE CHANGEFEED FOR foo WITH replay_from = 'nodelocal://0/feed'`)
+	sqlDB.ExpectErr(
+		t, `avro_connect_compatible is only usable with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH avro_connect_compatible`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `schema_registry_subject_prefix is only usable with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH schema_registry_subject_prefix = 'dev.'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `schema_registry_subject_prefix must be a non-empty string of letters, digits, '.', '_' and '-': "dev/"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = avro, schema_registry_subject_prefix = 'dev/', confluent_schema_registry = 'http://localhost'`,
+		`kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown on_target_drop: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH on_target_drop = 'nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `on_target_drop=skip requires a sink`,
+		`CREATE CHANGEFEED FOR foo WITH on_target_drop = 'skip'`)
+	sqlDB.ExpectErr(
+		t, `on_target_drop=complete is not usable with schema_change_policy=ignore`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH on_target_drop = 'complete', schema_change_policy = 'ignore'`,
+		`kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown key_format: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH key_format = 'nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `key_format=object is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = 'avro', key_format = 'object', confluent_schema_registry = 'http://nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `watch_columns column "nope" does not exist in table foo`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH watch_columns = 'b, nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `watch_columns must be a comma separated list of column names: "b,,c"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH watch_columns = 'b,,c'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_min_rows requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_min_rows = '10'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_min_rows must be a positive integer: "0"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved, resolved_min_rows = '0'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_max_interval requires the resolved_min_rows option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved, resolved_max_interval = '1m'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_max_interval must not be less than the interval of the resolved option: 1m0s`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved = '1m', resolved_min_rows = '10', resolved_max_interval = '10s'`,
+		`kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `buffer_flush_rows must be a positive integer: "0"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH buffer_flush_rows = '0'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `buffer_flush_bytes must be a positive byte size: "nope"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH buffer_flush_bytes = 'nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `buffer_flush_interval must be a positive duration: "-1s"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH buffer_flush_interval = '-1s'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `compaction_tombstones is not usable with delete_format`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compaction_tombstones, delete_format = 'null'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option compaction_tombstones`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH compaction_tombstones`, `webhook-https://nope`)
+	sqlDB.ExpectErr(
+		t, `partition_time_bucket must be a positive duration: "0s"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH partition_time_bucket = '0s'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `partition_time_bucket is not usable with compaction_tombstones, which requires the rows of a key to stay in one partition`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH partition_time_bucket = '1h', compaction_tombstones`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `schema_fingerprint is only usable with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH schema_fingerprint`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `collapse_families is not supported with format=native`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH collapse_families, format=native`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `source_cluster is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = 'avro', source_cluster, confluent_schema_registry = 'http://nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `value_size is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = 'avro', value_size, confluent_schema_registry = 'http://nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown float_special_values: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH float_special_values = 'nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `float_special_values is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH format = 'avro', float_special_values = 'null', confluent_schema_registry = 'http://nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `initial_scan_concurrency must be a positive integer: "0"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan_concurrency = '0'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `initial_scan_concurrency is not usable with initial_scan_ordered`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan_concurrency = '8', initial_scan_ordered`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `initial_scan_chunk_size must be a byte size of at least 1.0 MiB: "64KiB"`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan_chunk_size = '64KiB'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `at_most_once requires a sink`,
+		`CREATE CHANGEFEED FOR foo WITH at_most_once`)
+	sqlDB.ExpectErr(
+		t, `provenance is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH provenance, format='experimental_avro', confluent_schema_registry=$2`,
+		`kafka://nope`, `http://nope`)
+	sqlDB.ExpectErr(
+		t, `range_info is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH range_info, format='experimental_avro', confluent_schema_registry=$2`,
+		`kafka://nope`, `http://nope`)
+	sqlDB.ExpectErr(
+		t, `dead_letter_sink is only usable with format=avro`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH dead_letter_sink = 'kafka://nope'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_window requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_window`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_spans requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_spans`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_null_value requires the resolved option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved_null_value`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved_null_value is not supported with resolved_window`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved, resolved_null_value, resolved_window`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `this sink is incompatible with option resolved_null_value`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved, resolved_null_value`, `webhook-https://fake-host`)
+	sqlDB.ExpectErr(
+		t, `resolved_null_value requires a sink`,
+		`EXPERIMENTAL CHANGEFEED FOR foo WITH resolved, resolved_null_value`)
+	sqlDB.ExpectErr(
+		t, `cannot specify both initial_scan_ordered and no_initial_scan`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH initial_scan_ordered, no_initial_scan`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `envelope=connect is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH envelope=connect, format='experimental_avro', confluent_schema_registry=$2`,
+		`kafka://nope`, `http://nope`)
+	sqlDB.ExpectErr(
+		t, `ttl_deletes is only usable with envelope=wrapped`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope=row, ttl_deletes`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `delete_format=after_null is only usable with envelope=wrapped`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope=row, delete_format=after_null`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown delete_format: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH delete_format=nope`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown freshness: nope`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH freshness=nope`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `freshness=range is not usable with heartbeat`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH resolved, freshness=range, heartbeat='1s'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `envelope=debezium requires the full_table_name option`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH envelope=debezium`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `replay_buffer is only usable with sinkless changefeeds`,
+		`CREATE CHANGEFEED FOR foo INTO $1 WITH replay_buffer='feed'`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `resolved cannot be set for table foo`,
+		`CREATE CHANGEFEED FOR foo (resolved) INTO $1`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `options for table foo: diff is only usable with envelope=wrapped`,
+		`CREATE CHANGEFEED FOR foo (envelope='key_only') INTO $1 WITH diff`, `kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `format cannot be set for table foo with a cloud storage sink`,
+		`CREATE CHANGEFEED FOR foo (format='experimental_avro') INTO $1 WITH confluent_schema_registry=$2`,
+		`experimental-nodelocal://0/bar`, `http://nope`)
+	sqlDB.ExpectErr(
+		t, `sparse_updates is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH sparse_updates, format='experimental_avro', confluent_schema_registry=$2`,
+		`kafka://nope`, `http://nope`)
+	sqlDB.ExpectErr(
+		t, `unknown timestamp_format: not_valid`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH timestamp_format='not_valid'`,
+		`kafka://nope`)
+	sqlDB.ExpectErr(
+		t, `timestamp_format=rfc3339 is only usable with format=json`,
+		`CREATE CHANGEFEED FOR foo into $1 WITH timestamp_format='rfc3339', format='experimental_avro', confluent_schema_registry=$2`,
+		`kafka://nope`, `http://nope`)
 }
 
 func TestChangefeedDescription(t *testing.T) {
@@ -5272,3 +7281,256 @@ func TestChangefeedFlushesSinkToReleaseMemory(t *testing.T) {
 	<-allEmitted
 	require.Greater(t, sink.numFlushes(), 0)
 }
+
+// healthCheckingSink wraps a sink and fails health checks on demand.
+type healthCheckingSink struct {
+	Sink
+	unhealthy *syncutil.AtomicBool
+}
+
+var _ SinkWithHealthCheck = (*healthCheckingSink)(nil)
+
+func (s *healthCheckingSink) CheckHealth(ctx context.Context) error {
+	if s.unhealthy.Get() {
+		return errors.New("sink is unhealthy")
+	}
+	return nil
+}
+
+// routingKeys are the routing keys of the rows emitted by a changefeed, by
+// topic and key.
+type routingKeys struct {
+	syncutil.Mutex
+	byTopicAndKey map[string][]byte
+}
+
+// routingRecordingSink wraps a sink and records the routing keys of the rows
+// emitted to it.
+type routingRecordingSink struct {
+	Sink
+	recorded *routingKeys
+}
+
+func (s *routingRecordingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	var routingKey []byte
+	if rt, ok := topic.(routedTopic); ok {
+		routingKey = rt.routingKey
+	}
+	s.recorded.Lock()
+	s.recorded.byTopicAndKey[topic.GetName()+`: `+string(key)] = routingKey
+	s.recorded.Unlock()
+	return s.Sink.EmitRow(ctx, topic, key, value, updated, mvcc, alloc)
+}
+
+func TestChangefeedShardAwareRouting(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, stopServer := startTestServer(t, newTestOptions())
+	defer stopServer()
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	knobs := s.TestingKnobs().
+		DistSQL.(*execinfra.TestingKnobs).
+		Changefeed.(*TestingKnobs)
+	recorded := &routingKeys{byTopicAndKey: make(map[string][]byte)}
+	knobs.WrapSink = func(s Sink, _ jobspb.JobID) Sink {
+		return &routingRecordingSink{Sink: s, recorded: recorded}
+	}
+
+	// foo and bar hold the same logical keys, sharded into different numbers
+	// of buckets.
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT, b STRING, PRIMARY KEY (a, b) USING HASH WITH (bucket_count=8))`)
+	sqlDB.Exec(t, `CREATE TABLE bar (a INT, b STRING, PRIMARY KEY (a, b) USING HASH WITH (bucket_count=16))`)
+	sqlDB.Exec(t, `CREATE TABLE baz (a INT, b STRING, PRIMARY KEY (a, b))`)
+	for _, table := range []string{`foo`, `bar`, `baz`} {
+		sqlDB.Exec(t, `INSERT INTO `+table+` VALUES (1, 'x'), (2, 'y'), (3, 'z')`)
+	}
+	sqlDB.Exec(t, `CREATE CHANGEFEED FOR foo, bar, baz INTO 'null://' WITH shard_aware_routing`)
+
+	routingKeysOf := func(table string) map[string][]byte {
+		recorded.Lock()
+		defer recorded.Unlock()
+		byLogicalKey := make(map[string][]byte)
+		for topicAndKey, routingKey := range recorded.byTopicAndKey {
+			if !strings.HasPrefix(topicAndKey, table+`: `) {
+				continue
+			}
+			// Strip the shard column from the key, e.g. [5, 1, "x"].
+			var key []interface{}
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(topicAndKey, table+`: `)), &key))
+			if table != `baz` {
+				key = key[1:]
+			}
+			byLogicalKey[fmt.Sprint(key)] = routingKey
+		}
+		return byLogicalKey
+	}
+	testutils.SucceedsSoon(t, func() error {
+		for _, table := range []string{`foo`, `bar`, `baz`} {
+			if n := len(routingKeysOf(table)); n != 3 {
+				return errors.Errorf(`expected 3 rows of %s, found %d`, table, n)
+			}
+		}
+		return nil
+	})
+
+	// The rows of a logical key are routed alike whatever their shards, and
+	// the rows of tables which aren't sharded by their keys.
+	foo, bar, baz := routingKeysOf(`foo`), routingKeysOf(`bar`), routingKeysOf(`baz`)
+	require.Equal(t, foo, bar)
+	for logicalKey, routingKey := range foo {
+		require.NotEmpty(t, routingKey, logicalKey)
+		require.Nil(t, baz[logicalKey], logicalKey)
+	}
+
+	// The partition of a row is picked by its routing key.
+	partitioner := newChangefeedPartitioner(`foo`)
+	for logicalKey, routingKey := range foo {
+		withRoutingKey, err := partitioner.Partition(&sarama.ProducerMessage{
+			Topic: `foo`, Key: sarama.StringEncoder(`key`), Metadata: messageMetadata{routingKey: routingKey},
+		}, 1000)
+		require.NoError(t, err)
+		byKey, err := partitioner.Partition(&sarama.ProducerMessage{
+			Topic: `foo`, Key: sarama.ByteEncoder(routingKey),
+		}, 1000)
+		require.NoError(t, err)
+		require.Equal(t, byKey, withRoutingKey, logicalKey)
+	}
+}
+
+func TestChangefeedCompactionTombstones(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+		foo := feed(t, f, `CREATE CHANGEFEED FOR foo WITH compaction_tombstones`)
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: [1]->{"after": {"a": 1, "b": "a"}}`,
+		})
+
+		// The delete is produced as a record without a value, rather than
+		// with {"after": null}.
+		sqlDB.Exec(t, `DELETE FROM foo WHERE a = 1`)
+		m, err := foo.Next()
+		require.NoError(t, err)
+		require.Equal(t, `[1]`, string(m.Key))
+		require.Nil(t, m.Value)
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestChangefeedSinkHealthCheck(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	s, db, stopServer := startTestServer(t, newTestOptions())
+	defer stopServer()
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	knobs := s.TestingKnobs().
+		DistSQL.(*execinfra.TestingKnobs).
+		Changefeed.(*TestingKnobs)
+
+	var unhealthy syncutil.AtomicBool
+	knobs.WrapSink = func(s Sink, _ jobspb.JobID) Sink {
+		return &healthCheckingSink{Sink: s, unhealthy: &unhealthy}
+	}
+
+	registry := s.JobRegistry().(*jobs.Registry)
+	sli, err := registry.MetricsStruct().Changefeed.(*Metrics).getSLIMetrics(defaultSLIScope)
+	require.NoError(t, err)
+
+	sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.sink_health_check_interval = '10ms'`)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY)`)
+
+	var jobID jobspb.JobID
+	sqlDB.QueryRow(t, `CREATE CHANGEFEED FOR foo INTO 'null://'`).Scan(&jobID)
+
+	expectConnected := func(connected bool) {
+		testutils.SucceedsSoon(t, func() error {
+			expectedCount := int64(0)
+			if connected {
+				expectedCount = 1
+			}
+			if got := sli.SinkConnected.Value(); got != expectedCount {
+				return errors.Errorf("expected %d connected sinks, found %d", expectedCount, got)
+			}
+			// The column is NULL until the sink has been checked.
+			var sinkConnected gosql.NullBool
+			sqlDB.QueryRow(t,
+				`SELECT sink_connected FROM [SHOW CHANGEFEED JOB $1]`, jobID,
+			).Scan(&sinkConnected)
+			if !sinkConnected.Valid || sinkConnected.Bool != connected {
+				return errors.Errorf("expected sink_connected=%t, found %v", connected, sinkConnected)
+			}
+			return nil
+		})
+	}
+
+	// An idle feed with a healthy sink reports connected.
+	expectConnected(true)
+
+	unhealthy.Set(true)
+	expectConnected(false)
+	var runningStatus string
+	sqlDB.QueryRow(t,
+		`SELECT running_status FROM [SHOW CHANGEFEED JOB $1]`, jobID,
+	).Scan(&runningStatus)
+	require.Contains(t, runningStatus, sinkUnreachableStatus)
+
+	unhealthy.Set(false)
+	expectConnected(true)
+
+	sqlDB.Exec(t, `CANCEL JOB $1`, jobID)
+	testutils.SucceedsSoon(t, func() error {
+		if got := sli.SinkConnected.Value(); got != 0 {
+			return errors.Errorf("expected no connected sinks after cancel, found %d", got)
+		}
+		return nil
+	})
+}
+
+func TestChangefeedAllowlistedSystemTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		var cursor string
+		sqlDB.QueryRow(t, `SELECT cluster_logical_timestamp()`).Scan(&cursor)
+
+		settingsFeed := feed(t, f, `CREATE CHANGEFEED FOR system.settings WITH cursor=$1`, cursor)
+		defer closeFeed(t, settingsFeed)
+
+		sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.sink_health_check_interval = '1m'`)
+		testutils.SucceedsSoon(t, func() error {
+			msgs, err := readNextMessages(settingsFeed, 1)
+			if err != nil {
+				return err
+			}
+			if key := string(msgs[0].Key); key != `["changefeed.sink_health_check_interval"]` {
+				return errors.Errorf(`unexpected key %s`, key)
+			}
+			return nil
+		})
+
+		// Tables outside of the allowlist are rejected with a hint.
+		sqlDB.ExpectErr(t, `not supported on system tables`,
+			`CREATE CHANGEFEED FOR system.users`)
+	}
+
+	t.Run(`sinkless`, sinklessTest(testFn))
+}
@@ -8,5 +8,27 @@
 
 package changefeedbase
 
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+)
+
 // ConfluentAvroWireFormatMagic is the "magic" header bytes for kafka messages.
 const ConfluentAvroWireFormatMagic = byte(0)
+
+// ConfluentAvroHeaderLen is the length of the confluent wire format header:
+// the magic byte followed by the 4 byte big-endian schema registry ID.
+const ConfluentAvroHeaderLen = 5
+
+// DecodeConfluentAvroHeader returns the schema registry ID of a message
+// encoded in the confluent wire format, along with the remaining avro payload.
+func DecodeConfluentAvroHeader(b []byte) (schemaID int32, payload []byte, err error) {
+	if len(b) == 0 || b[0] != ConfluentAvroWireFormatMagic {
+		return 0, nil, errors.Errorf(`bad magic byte`)
+	}
+	if len(b) < ConfluentAvroHeaderLen {
+		return 0, nil, errors.Errorf(`missing registry id`)
+	}
+	return int32(binary.BigEndian.Uint32(b[1:ConfluentAvroHeaderLen])), b[ConfluentAvroHeaderLen:], nil
+}
@@ -31,6 +31,31 @@ type SchemaChangePolicy string
 // include virtual columns in an event
 type VirtualColumnVisibility string
 
+// TimestampFormat describes how timestamps are rendered in the JSON envelope.
+type TimestampFormat string
+
+// DeleteFormat describes how deletes are represented in the JSON envelope.
+type DeleteFormat string
+
+// Freshness describes when resolved timestamps are emitted, and which rows
+// they guarantee have been emitted.
+type Freshness string
+
+// KeyFormat describes how keys are rendered by the JSON encoder.
+type KeyFormat string
+
+// KafkaRecordTimestamp describes the timestamp of the records emitted to
+// Kafka.
+type KafkaRecordTimestamp string
+
+// FloatSpecialValues describes how the JSON encoder renders the NaN and
+// infinite values of floats, which JSON numbers cannot represent.
+type FloatSpecialValues string
+
+// OnTargetDropPolicy describes what a changefeed does when one of its target
+// tables is dropped.
+type OnTargetDropPolicy string
+
 // Constants for the options.
 const (
 	OptAvroSchemaPrefix         = `avro_schema_prefix`
@@ -55,10 +80,162 @@ const (
 	OptOnError                  = `on_error`
 	OptMetricsScope             = `metrics_label`
 	OptVirtualColumns           = `virtual_columns`
+	OptTimestampFormat          = `timestamp_format`
+	OptSparseUpdates            = `sparse_updates`
+	OptMaxEmitBytesPerSec       = `max_emit_bytes_per_sec`
+	OptMaxRowsPerSec            = `max_rows_per_sec`
+	OptGRPCMetadata             = `grpc_metadata`
+	OptReplayFrom               = `replay_from`
+	OptRangeInfo                = `range_info`
+	OptDeadLetterSink           = `dead_letter_sink`
+	OptScanRequestBatchBytes    = `scan_request_batch_bytes`
+	OptResolvedWindow           = `resolved_window`
+	OptReplayBuffer             = `replay_buffer`
+	OptMaterializeDefaults      = `materialize_defaults`
+	OptHeartbeat                = `heartbeat`
+	OptDeleteFormat             = `delete_format`
+	OptTTLDeletes               = `ttl_deletes`
+	OptWatermarkLag             = `watermark_lag`
+	OptEmitWindow               = `emit_window`
+	OptRekey                    = `rekey`
+	OptFloatSpecialValues       = `float_special_values`
+	OptValueSize                = `value_size`
+	OptWatchColumns             = `watch_columns`
+	OptResolvedMinRows          = `resolved_min_rows`
+	OptResolvedMaxInterval      = `resolved_max_interval`
+	OptShardAwareRouting        = `shard_aware_routing`
+	OptBufferFlushRows          = `buffer_flush_rows`
+	OptBufferFlushBytes         = `buffer_flush_bytes`
+	OptBufferFlushInterval      = `buffer_flush_interval`
+	OptSourceCluster            = `source_cluster`
+	OptCompactionTombstones     = `compaction_tombstones`
+	OptSchemaFingerprint        = `schema_fingerprint`
+	OptPartitionTimeBucket      = `partition_time_bucket`
+	OptCollapseFamilies         = `collapse_families`
+	OptRegion                   = `region`
+	OptStatementTag             = `statement_tag`
+	OptInitialScanChunkSize     = `initial_scan_chunk_size`
+	OptIdempotencyToken         = `idempotency_token`
+	OptOnTargetDrop             = `on_target_drop`
+	OptAvroConnectCompatible    = `avro_connect_compatible`
+	OptKeyRange                 = `key_range`
+	OptDeleteFullRow            = `delete_full_row`
+	OptSubjectPrefix            = `schema_registry_subject_prefix`
+	OptVersionField             = `version_field`
+	OptExcludeColumnTypes       = `exclude_column_types`
+	OptFreshness                = `freshness`
+	OptAvroNamespace            = `avro_namespace`
+	OptAvroRecordName           = `avro_record_name`
+	OptResolvedSpans            = `resolved_spans`
+	OptInitialScanOrdered       = `initial_scan_ordered`
+	OptDedup                    = `dedup`
+	OptAvroFixedColumns         = `avro_fixed_columns`
+	OptRowHash                  = `row_hash`
+	OptAtMostOnce               = `at_most_once`
+	OptKeyFormat                = `key_format`
+	OptProvenance               = `provenance`
+	OptInitialScanConcurrency   = `initial_scan_concurrency`
+	OptSuppressNoOpUpdates      = `suppress_no_op_updates`
+	OptDurableResolved          = `durable_resolved`
+	OptColumnComments           = `column_comments`
+	OptSequenceNumbers          = `sequence_numbers`
+	OptChangefeedEpoch          = `changefeed_epoch`
 
 	OptVirtualColumnsOmitted VirtualColumnVisibility = `omitted`
 	OptVirtualColumnsNull    VirtualColumnVisibility = `null`
 
+	// OptTimestampFormatHLC renders timestamps as HLC decimals, which preserve
+	// the logical component of the timestamp.
+	OptTimestampFormatHLC TimestampFormat = `hlc`
+	// OptTimestampFormatRFC3339 renders timestamps as RFC3339 strings with
+	// nanosecond precision. The logical component of the timestamp is dropped.
+	OptTimestampFormatRFC3339 TimestampFormat = `rfc3339`
+	// OptTimestampFormatUnixNanos renders timestamps as the number of
+	// nanoseconds since the Unix epoch, as a string. The logical component of
+	// the timestamp is dropped.
+	OptTimestampFormatUnixNanos TimestampFormat = `unix_nanos`
+
+	// OptDeleteFormatAfterNull represents a delete as a value whose after
+	// field is null. It is the default with envelope=wrapped, and is only
+	// usable with it.
+	OptDeleteFormatAfterNull DeleteFormat = `after_null`
+	// OptDeleteFormatTombstone represents a delete as an empty value. It is
+	// the default with the other envelopes. Kafka and Pub/Sub deliver it as a
+	// message without a value, the cloud storage sink writes it as an empty
+	// line, the SQL sink as an empty value and the webhook sink, whose
+	// payloads are JSON, as null.
+	OptDeleteFormatTombstone DeleteFormat = `tombstone`
+	// OptDeleteFormatNull represents a delete as the JSON value null, which
+	// every sink delivers as is.
+	OptDeleteFormatNull DeleteFormat = `null`
+	// OptDeleteFormatOp represents a delete as an object with an op field
+	// set to delete and a key field holding the primary key of the row. With
+	// envelope=key_only, it distinguishes deletes from inserts and updates,
+	// which have no value.
+	OptDeleteFormatOp DeleteFormat = `op`
+
+	// OptKeyFormatArray renders the primary key of a row as a JSON array of
+	// the values of its columns, in the order of the primary index. It is the
+	// default.
+	OptKeyFormatArray KeyFormat = `array`
+	// OptKeyFormatObject renders the primary key of a row as a JSON object
+	// mapping the names of its columns to their values, with the columns in
+	// alphabetical order. The keys differ from those of the array format, so
+	// switching the format of a changefeed emitting to a compacted Kafka topic
+	// leaves the rows emitted before the switch in the topic until the row is
+	// deleted, since compaction only collapses messages with identical keys.
+	OptKeyFormatObject KeyFormat = `object`
+
+	// OptKafkaRecordTimestampProduce leaves the timestamp of records to the
+	// producer, which sets it to the time they're produced. It is the default.
+	OptKafkaRecordTimestampProduce KafkaRecordTimestamp = `produce`
+	// OptKafkaRecordTimestampMVCC sets the timestamp of records to the MVCC
+	// commit time of their row, so that the time-based retention and lookups
+	// of Kafka follow the time of the data. It requires the topics to keep the
+	// timestamps set by producers, i.e. message.timestamp.type=CreateTime,
+	// since brokers overwrite them with LogAppendTime. Brokers also reject
+	// records whose timestamp is further than message.timestamp.difference.max.ms
+	// from their clock, which rows emitted by a catch-up scan after a long
+	// pause, or by an initial scan with a cursor, may be.
+	OptKafkaRecordTimestampMVCC KafkaRecordTimestamp = `mvcc`
+
+	// OptFloatSpecialValuesString renders NaN and infinite floats as the
+	// strings "NaN", "Infinity" and "-Infinity". It is the default.
+	OptFloatSpecialValuesString FloatSpecialValues = `string`
+	// OptFloatSpecialValuesNull renders NaN and infinite floats as null.
+	OptFloatSpecialValuesNull FloatSpecialValues = `null`
+	// OptFloatSpecialValuesError fails the changefeed on NaN and infinite
+	// floats.
+	OptFloatSpecialValuesError FloatSpecialValues = `error`
+
+	// OptOnTargetDropFail fails the changefeed when one of its targets is
+	// dropped. It is the default.
+	OptOnTargetDropFail OnTargetDropPolicy = `fail`
+	// OptOnTargetDropSkip resolves all of the targets up to the drop, which
+	// emits a final resolved timestamp for the dropped table, and continues the
+	// changefeed with the remaining targets. The changefeed fails if no targets
+	// remain.
+	OptOnTargetDropSkip OnTargetDropPolicy = `skip`
+	// OptOnTargetDropComplete behaves as OptOnTargetDropSkip, but completes the
+	// changefeed when its last target is dropped.
+	OptOnTargetDropComplete OnTargetDropPolicy = `complete`
+
+	// OptFreshnessConsistent emits resolved timestamps as the frontier of the
+	// whole changefeed advances, which waits on its slowest range. A resolved
+	// timestamp T guarantees that every row of every target changed at or
+	// below T has been emitted. It is the default.
+	OptFreshnessConsistent Freshness = `consistent`
+	// OptFreshnessRange emits resolved timestamps as soon as the rangefeed
+	// checkpoints of the ranges watched by each change aggregator advance
+	// them, without waiting on the other aggregators. A resolved timestamp T
+	// only guarantees that the rows changed at or below T in the ranges of
+	// the aggregator which emitted it have been emitted; rows of other ranges
+	// at or below T may still follow. Consumers which need the consistent
+	// guarantee must track the resolved timestamps of each aggregator, or
+	// partition, themselves. Job checkpoints still follow the frontier of the
+	// whole changefeed.
+	OptFreshnessRange Freshness = `range`
+
 	// OptSchemaChangeEventClassColumnChange corresponds to all schema change
 	// events which add or remove any column.
 	OptSchemaChangeEventClassColumnChange SchemaChangeEventClass = `column_changes`
@@ -90,6 +267,10 @@ const (
 	// cursor is specified. This option is useful to create a changefeed which
 	// subscribes only to new messages.
 	OptNoInitialScan = `no_initial_scan`
+	// OptInitialScanOnly performs an initial scan and then completes the
+	// changefeed, with a final resolved timestamp at the time of the scan,
+	// instead of continuing with the changes made after it.
+	OptInitialScanOnly = `initial_scan_only`
 	// Sentinel value to indicate that all resolved timestamp events should be emitted.
 	OptEmitAllResolvedTimestamps = ``
 
@@ -97,9 +278,16 @@ const (
 	OptEnvelopeRow           EnvelopeType = `row`
 	OptEnvelopeDeprecatedRow EnvelopeType = `deprecated_row`
 	OptEnvelopeWrapped       EnvelopeType = `wrapped`
+	OptEnvelopeConnect       EnvelopeType = `connect`
+	OptEnvelopeDebezium      EnvelopeType = `debezium`
+	OptEnvelopeDeletedFlag   EnvelopeType = `deleted_flag`
 
 	OptFormatJSON FormatType = `json`
 	OptFormatAvro FormatType = `avro`
+	OptFormatORC  FormatType = `orc`
+	// OptFormatMsgpack encodes keys and values as MessagePack, in the layout
+	// of the JSON format.
+	OptFormatMsgpack FormatType = `msgpack`
 
 	OptFormatNative FormatType = `native`
 
@@ -117,17 +305,76 @@ const (
 	// OptKafkaSinkConfig is a JSON configuration for kafka sink (kafkaSinkConfig).
 	OptKafkaSinkConfig   = `kafka_sink_config`
 	OptWebhookSinkConfig = `webhook_sink_config`
-
+	// OptKafkaIdempotent enables sarama's idempotent producer.
+	OptKafkaIdempotent = `kafka_idempotent`
+	// OptTopicFromColumn routes each row to a Kafka topic named after the
+	// value of the given column, prefixed by the topic_prefix sink parameter.
+	// OptTopicFromColumnMaxTopics caps the number of topics each change
+	// aggregator may route rows to. Rows whose value can't name a topic are
+	// emitted to the dead_letter_sink if there is one, and otherwise fail the
+	// changefeed.
+	OptTopicFromColumn          = `topic_from_column`
+	OptTopicFromColumnMaxTopics = `topic_from_column_max_topics`
+	// OptSinkConcurrency caps the number of messages a changefeed may have in
+	// flight to Kafka, awaiting their acknowledgement. The cap is split evenly
+	// across the change aggregators of the changefeed, each of which gets at
+	// least one slot, while the change frontier, which only emits resolved
+	// timestamps, gets the whole cap. Lower values bound the buffers and
+	// connections used by a changefeed, at the cost of throughput, since
+	// emitting blocks on the acknowledgements, and latency, which includes the
+	// wait for a slot. Only Kafka sinks accept it: cloud storage sinks already
+	// upload one file at a time for each change aggregator.
+	OptSinkConcurrency = `sink_concurrency`
+	// OptKafkaRecordTimestamp sets the timestamp of the records emitted to
+	// Kafka. With OptKafkaRecordTimestampMVCC, it's the MVCC commit time of
+	// their row, rather than the time they were produced.
+	OptKafkaRecordTimestamp = `kafka_record_timestamp`
+	// OptResolvedNullValue emits resolved timestamps to Kafka as records
+	// without a value, which stream processors can use as watermarks, with
+	// the timestamp in a header rather than in a JSON payload.
+	OptResolvedNullValue = `resolved_null_value`
+	// OptEventTime adds the MVCC timestamp of each row, in RFC3339Nano, to
+	// the rows written by the cloud storage sink as their event_time, and
+	// partitions their files by it, under a directory per topic, in the Hive
+	// layout, e.g. `foo/dt=2022-01-02/`.
+	OptEventTime = `event_time`
+	// OptMaxOpenFiles bounds the number of files the cloud storage sink
+	// buffers at once, flushing the least recently written one to make room
+	// for another.
+	OptMaxOpenFiles = `max_open_files`
+
+	SinkParamBearerToken            = `bearer_token`
 	SinkParamCACert                 = `ca_cert`
 	SinkParamClientCert             = `client_cert`
 	SinkParamClientKey              = `client_key`
+	SinkParamConsistency            = `consistency`
+	SinkParamDNSCacheTTL            = `dns_cache_ttl`
 	SinkParamFileSize               = `file_size`
+	SinkParamInfluxDBBucket         = `bucket`
+	SinkParamInfluxDBFieldColumns   = `field_columns`
+	SinkParamInfluxDBMeasurement    = `measurement`
+	SinkParamInfluxDBOrg            = `org`
+	SinkParamInfluxDBTagColumns     = `tag_columns`
+	SinkParamInfluxDBTimeColumn     = `time_column`
+	SinkParamInfluxDBToken          = `token`
 	SinkParamPartitionFormat        = `partition_format`
+	SinkParamRedisMaxLen            = `maxlen`
+	SinkParamResolvedTopic          = `resolved_topic`
 	SinkParamSchemaTopic            = `schema_topic`
+	SinkParamSignColumn             = `sign_column`
+	SinkParamStorage                = `storage`
 	SinkParamTLSEnabled             = `tls_enabled`
 	SinkParamSkipTLSVerify          = `insecure_tls_skip_verify`
+	SinkParamSyslogAppName          = `app_name`
+	SinkParamSyslogFacility         = `facility`
+	SinkParamSyslogSDID             = `sd_id`
+	SinkParamSyslogSeverity         = `severity`
 	SinkParamTopicPrefix            = `topic_prefix`
 	SinkParamTopicName              = `topic_name`
+	SinkParamVersionColumn          = `version_column`
+	SinkParamWarehouse              = `warehouse`
+	SinkSchemeCassandra             = `cassandra`
+	SinkSchemeClickHouse            = `clickhouse`
 	SinkSchemeCloudStorageAzure     = `azure`
 	SinkSchemeCloudStorageGCS       = `gs`
 	SinkSchemeCloudStorageHTTP      = `http`
@@ -135,10 +382,22 @@ const (
 	SinkSchemeCloudStorageNodelocal = `nodelocal`
 	SinkSchemeCloudStorageS3        = `s3`
 	SinkSchemeExperimentalSQL       = `experimental-sql`
+	SinkSchemeFile                  = `file`
+	SinkSchemeGRPC                  = `grpc`
+	SinkSchemeGRPCTLS               = `grpcs`
 	SinkSchemeHTTP                  = `http`
 	SinkSchemeHTTPS                 = `https`
+	SinkSchemeIceberg               = `iceberg`
+	SinkSchemeInfluxDB              = `influxdb`
 	SinkSchemeKafka                 = `kafka`
 	SinkSchemeNull                  = `null`
+	SinkSchemePebble                = `pebble`
+	SinkSchemeRedis                 = `redis`
+	SinkSchemeRedisTLS              = `rediss`
+	SinkSchemeSyslog                = `syslog`
+	SinkSchemeSyslogTCP             = `syslog-tcp`
+	SinkSchemeSyslogTLS             = `syslog-tls`
+	SinkSchemeUnix                  = `unix`
 	SinkSchemeWebhookHTTP           = `webhook-http`
 	SinkSchemeWebhookHTTPS          = `webhook-https`
 	SinkParamSASLEnabled            = `sasl_enabled`
@@ -147,12 +406,32 @@ const (
 	SinkParamSASLPassword           = `sasl_password`
 	SinkParamSASLMechanism          = `sasl_mechanism`
 
-	RegistryParamCACert = `ca_cert`
+	RegistryParamCACert      = `ca_cert`
+	RegistryParamRetryMax    = `retry_max`
+	RegistryParamDNSCacheTTL = `dns_cache_ttl`
 
 	// Topics is used to store the topics generated by the sink in the options
 	// struct so that they can be displayed in the show changefeed jobs query.
 	// Hence, this option is not available to users
 	Topics = `topics`
+
+	// ResyncRequested is set by ALTER CHANGEFEED ... RESYNC, of a paused or a
+	// running changefeed, which restarts to perform the resync. Like Topics,
+	// this option is not available to users.
+	ResyncRequested = `resync_requested`
+
+	// ResyncTimestamp replaces ResyncRequested as the changefeed starts the
+	// resync, and is set to the job's high-water at that time. While the
+	// high-water remains at this timestamp, the changefeed re-scans all of
+	// its targets. This option is not available to users.
+	ResyncTimestamp = `resync_timestamp`
+
+	// BackfillTimestamp is set by ALTER CHANGEFEED ... ADD to the job's
+	// high-water at the time the targets were added. While the high-water
+	// remains at this timestamp, a resumed changefeed performs an initial scan
+	// of the spans which are not covered by the job's checkpoint, that is, of
+	// the newly added targets. This option is not available to users.
+	BackfillTimestamp = `backfill_timestamp`
 )
 
 // ChangefeedOptionExpectValues is used to parse changefeed options using
@@ -176,14 +455,83 @@ var ChangefeedOptionExpectValues = map[string]sql.KVStringOptValidate{
 	OptSchemaChangePolicy:       sql.KVStringOptRequireValue,
 	OptInitialScan:              sql.KVStringOptRequireNoValue,
 	OptNoInitialScan:            sql.KVStringOptRequireNoValue,
+	OptInitialScanOnly:          sql.KVStringOptRequireNoValue,
 	OptProtectDataFromGCOnPause: sql.KVStringOptRequireNoValue,
 	OptKafkaSinkConfig:          sql.KVStringOptRequireValue,
+	OptKafkaIdempotent:          sql.KVStringOptRequireValue,
+	OptTopicFromColumn:          sql.KVStringOptRequireValue,
+	OptTopicFromColumnMaxTopics: sql.KVStringOptRequireValue,
+	OptSinkConcurrency:          sql.KVStringOptRequireValue,
+	OptKafkaRecordTimestamp:     sql.KVStringOptRequireValue,
 	OptWebhookSinkConfig:        sql.KVStringOptRequireValue,
 	OptWebhookAuthHeader:        sql.KVStringOptRequireValue,
 	OptWebhookClientTimeout:     sql.KVStringOptRequireValue,
 	OptOnError:                  sql.KVStringOptRequireValue,
 	OptMetricsScope:             sql.KVStringOptRequireValue,
 	OptVirtualColumns:           sql.KVStringOptRequireValue,
+	OptTimestampFormat:          sql.KVStringOptRequireValue,
+	OptSparseUpdates:            sql.KVStringOptRequireNoValue,
+	OptMaxEmitBytesPerSec:       sql.KVStringOptRequireValue,
+	OptMaxRowsPerSec:            sql.KVStringOptRequireValue,
+	OptGRPCMetadata:             sql.KVStringOptRequireValue,
+	OptReplayFrom:               sql.KVStringOptRequireValue,
+	OptRangeInfo:                sql.KVStringOptRequireNoValue,
+	OptDeadLetterSink:           sql.KVStringOptRequireValue,
+	OptScanRequestBatchBytes:    sql.KVStringOptRequireValue,
+	OptResolvedWindow:           sql.KVStringOptRequireNoValue,
+	OptReplayBuffer:             sql.KVStringOptRequireValue,
+	OptMaterializeDefaults:      sql.KVStringOptRequireNoValue,
+	OptHeartbeat:                sql.KVStringOptRequireValue,
+	OptDeleteFormat:             sql.KVStringOptRequireValue,
+	OptTTLDeletes:               sql.KVStringOptRequireNoValue,
+	OptWatermarkLag:             sql.KVStringOptRequireValue,
+	OptEmitWindow:               sql.KVStringOptRequireValue,
+	OptRekey:                    sql.KVStringOptRequireNoValue,
+	OptFloatSpecialValues:       sql.KVStringOptRequireValue,
+	OptValueSize:                sql.KVStringOptRequireNoValue,
+	OptWatchColumns:             sql.KVStringOptRequireValue,
+	OptResolvedMinRows:          sql.KVStringOptRequireValue,
+	OptResolvedMaxInterval:      sql.KVStringOptRequireValue,
+	OptShardAwareRouting:        sql.KVStringOptRequireNoValue,
+	OptBufferFlushRows:          sql.KVStringOptRequireValue,
+	OptBufferFlushBytes:         sql.KVStringOptRequireValue,
+	OptBufferFlushInterval:      sql.KVStringOptRequireValue,
+	OptSourceCluster:            sql.KVStringOptRequireNoValue,
+	OptCompactionTombstones:     sql.KVStringOptRequireNoValue,
+	OptSchemaFingerprint:        sql.KVStringOptRequireNoValue,
+	OptPartitionTimeBucket:      sql.KVStringOptRequireValue,
+	OptCollapseFamilies:         sql.KVStringOptRequireNoValue,
+	OptRegion:                   sql.KVStringOptRequireNoValue,
+	OptStatementTag:             sql.KVStringOptRequireNoValue,
+	OptInitialScanChunkSize:     sql.KVStringOptRequireValue,
+	OptIdempotencyToken:         sql.KVStringOptRequireNoValue,
+	OptOnTargetDrop:             sql.KVStringOptRequireValue,
+	OptAvroConnectCompatible:    sql.KVStringOptRequireNoValue,
+	OptKeyRange:                 sql.KVStringOptRequireValue,
+	OptDeleteFullRow:            sql.KVStringOptRequireNoValue,
+	OptSubjectPrefix:            sql.KVStringOptRequireValue,
+	OptVersionField:             sql.KVStringOptRequireNoValue,
+	OptExcludeColumnTypes:       sql.KVStringOptRequireValue,
+	OptFreshness:                sql.KVStringOptRequireValue,
+	OptAvroNamespace:            sql.KVStringOptRequireValue,
+	OptAvroRecordName:           sql.KVStringOptRequireValue,
+	OptResolvedSpans:            sql.KVStringOptRequireNoValue,
+	OptInitialScanOrdered:       sql.KVStringOptRequireNoValue,
+	OptDedup:                    sql.KVStringOptRequireNoValue,
+	OptAvroFixedColumns:         sql.KVStringOptRequireValue,
+	OptRowHash:                  sql.KVStringOptRequireNoValue,
+	OptAtMostOnce:               sql.KVStringOptRequireNoValue,
+	OptKeyFormat:                sql.KVStringOptRequireValue,
+	OptProvenance:               sql.KVStringOptRequireNoValue,
+	OptInitialScanConcurrency:   sql.KVStringOptRequireValue,
+	OptSuppressNoOpUpdates:      sql.KVStringOptRequireNoValue,
+	OptDurableResolved:          sql.KVStringOptRequireNoValue,
+	OptColumnComments:           sql.KVStringOptRequireNoValue,
+	OptSequenceNumbers:          sql.KVStringOptRequireNoValue,
+	OptChangefeedEpoch:          sql.KVStringOptRequireNoValue,
+	OptEventTime:                sql.KVStringOptRequireNoValue,
+	OptMaxOpenFiles:             sql.KVStringOptRequireValue,
+	OptResolvedNullValue:        sql.KVStringOptRequireNoValue,
 }
 
 func makeStringSet(opts ...string) map[string]struct{} {
@@ -202,17 +550,25 @@ var CommonOptions = makeStringSet(OptCursor, OptEnvelope,
 	OptMVCCTimestamps, OptDiff,
 	OptSchemaChangeEvents, OptSchemaChangePolicy,
 	OptProtectDataFromGCOnPause, OptOnError,
-	OptInitialScan, OptNoInitialScan,
-	OptMinCheckpointFrequency, OptMetricsScope, OptVirtualColumns, Topics)
+	OptInitialScan, OptNoInitialScan, OptInitialScanOnly,
+	OptMinCheckpointFrequency, OptMetricsScope, OptVirtualColumns, OptTimestampFormat, OptSparseUpdates,
+	OptMaxEmitBytesPerSec, OptMaxRowsPerSec, OptReplayFrom, OptRangeInfo, OptDeadLetterSink, OptScanRequestBatchBytes, OptResolvedWindow, OptReplayBuffer, OptMaterializeDefaults, OptHeartbeat, OptDeleteFormat, OptTTLDeletes, OptWatermarkLag, OptFreshness, OptAvroNamespace, OptAvroRecordName, OptAvroFixedColumns, OptResolvedSpans, OptInitialScanOrdered, OptDedup, OptRowHash, OptAtMostOnce, OptKeyFormat, OptProvenance, OptInitialScanConcurrency, OptSuppressNoOpUpdates, OptDurableResolved, OptColumnComments, OptSequenceNumbers, OptChangefeedEpoch, OptEmitWindow, OptRekey, OptFloatSpecialValues, OptValueSize, OptWatchColumns, OptResolvedMinRows, OptResolvedMaxInterval, OptBufferFlushRows, OptBufferFlushBytes, OptBufferFlushInterval, OptSourceCluster, OptSchemaFingerprint, OptCollapseFamilies, OptRegion, OptStatementTag, OptInitialScanChunkSize, OptIdempotencyToken, OptOnTargetDrop, OptAvroConnectCompatible, OptKeyRange, OptDeleteFullRow, OptVersionField, OptExcludeColumnTypes, Topics, ResyncRequested, ResyncTimestamp, BackfillTimestamp)
+
+// TargetOptions is options which can be overridden for individual targets of
+// a changefeed.
+var TargetOptions = makeStringSet(OptEnvelope, OptFormat,
+	OptKeyInValue, OptTopicInValue,
+	OptUpdatedTimestamps, OptMVCCTimestamps, OptDiff,
+	OptVirtualColumns, OptKeyRange)
 
 // SQLValidOptions is options exclusive to SQL sink
-var SQLValidOptions map[string]struct{} = nil
+var SQLValidOptions = makeStringSet(OptCompression)
 
 // KafkaValidOptions is options exclusive to Kafka sink
-var KafkaValidOptions = makeStringSet(OptAvroSchemaPrefix, OptConfluentSchemaRegistry, OptKafkaSinkConfig)
+var KafkaValidOptions = makeStringSet(OptAvroSchemaPrefix, OptAvroNamespace, OptAvroRecordName, OptAvroFixedColumns, OptAvroConnectCompatible, OptSubjectPrefix, OptConfluentSchemaRegistry, OptKafkaSinkConfig, OptKafkaIdempotent, OptTopicFromColumn, OptTopicFromColumnMaxTopics, OptSinkConcurrency, OptKafkaRecordTimestamp, OptResolvedNullValue, OptShardAwareRouting, OptCompactionTombstones, OptPartitionTimeBucket)
 
 // CloudStorageValidOptions is options exclusive to cloud storage sink
-var CloudStorageValidOptions = makeStringSet(OptCompression)
+var CloudStorageValidOptions = makeStringSet(OptCompression, OptAvroSchemaPrefix, OptAvroNamespace, OptAvroRecordName, OptAvroFixedColumns, OptAvroConnectCompatible, OptSubjectPrefix, OptConfluentSchemaRegistry, OptEventTime, OptMaxOpenFiles)
 
 // WebhookValidOptions is options exclusive to webhook sink
 var WebhookValidOptions = makeStringSet(OptWebhookAuthHeader, OptWebhookClientTimeout, OptWebhookSinkConfig)
@@ -220,8 +576,14 @@ var WebhookValidOptions = makeStringSet(OptWebhookAuthHeader, OptWebhookClientTi
 // PubsubValidOptions is options exclusice to pubsub sink
 var PubsubValidOptions = makeStringSet()
 
+// RedisValidOptions is options exclusive to redis sink
+var RedisValidOptions = makeStringSet(OptAvroSchemaPrefix, OptAvroNamespace, OptAvroRecordName, OptAvroFixedColumns, OptAvroConnectCompatible, OptSubjectPrefix, OptConfluentSchemaRegistry)
+
+// GRPCValidOptions is options exclusive to gRPC sink
+var GRPCValidOptions = makeStringSet(OptGRPCMetadata)
+
 // CaseInsensitiveOpts options which supports case Insensitive value
-var CaseInsensitiveOpts = makeStringSet(OptFormat, OptEnvelope, OptCompression, OptSchemaChangeEvents, OptSchemaChangePolicy, OptOnError)
+var CaseInsensitiveOpts = makeStringSet(OptFormat, OptEnvelope, OptCompression, OptSchemaChangeEvents, OptSchemaChangePolicy, OptOnError, OptKeyFormat, OptOnTargetDrop)
 
 // NoLongerExperimental aliases options prefixed with experimental that no longer need to be
 var NoLongerExperimental = map[string]string{
@@ -98,6 +98,16 @@ var ScanRequestLimit = settings.RegisterIntSetting(
 	0,
 )
 
+// MaxInitialScanConcurrency caps the initial_scan_concurrency option.
+var MaxInitialScanConcurrency = settings.RegisterIntSetting(
+	settings.TenantWritable,
+	"changefeed.backfill.max_initial_scan_concurrency",
+	"maximum number of spans the initial scan of a changefeed with the initial_scan_concurrency "+
+		"option exports concurrently on each node",
+	100,
+	settings.PositiveInt,
+)
+
 // SinkThrottleConfig describes throttling configuration for the sink.
 // 0 values for any of the settings disable that setting.
 type SinkThrottleConfig struct {
@@ -167,3 +177,42 @@ var EventMemoryMultiplier = settings.RegisterFloatSetting(
 		return nil
 	},
 )
+
+// SinkHealthCheckInterval controls how often a changefeed verifies that it is
+// able to reach its sink, independently of whether any rows are being emitted.
+var SinkHealthCheckInterval = settings.RegisterDurationSetting(
+	settings.TenantWritable,
+	"changefeed.sink_health_check_interval",
+	"how often a changefeed checks connectivity to its sink; if 0, health checks are disabled",
+	30*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// SinklessReplayBufferWindow controls how far back in resolved timestamps the
+// replay buffer of a sinkless changefeed reaches.
+var SinklessReplayBufferWindow = settings.RegisterDurationSetting(
+	settings.TenantWritable,
+	"changefeed.sinkless_replay_buffer.window",
+	"how far behind its latest resolved timestamp the replay buffer of a sinkless changefeed retains messages",
+	time.Minute,
+	settings.PositiveDuration,
+)
+
+// SinklessReplayBufferMaxSize bounds the memory used by the replay buffer of a
+// sinkless changefeed.
+var SinklessReplayBufferMaxSize = settings.RegisterByteSizeSetting(
+	settings.TenantWritable,
+	"changefeed.sinkless_replay_buffer.max_size",
+	"maximum size of the messages retained by the replay buffer of a sinkless changefeed",
+	8<<20,
+)
+
+// SinklessReplayBufferMaxCount bounds the number of replay buffers of
+// sinkless changefeeds retained by a node.
+var SinklessReplayBufferMaxCount = settings.RegisterIntSetting(
+	settings.TenantWritable,
+	"changefeed.sinkless_replay_buffer.max_count",
+	"maximum number of replay buffers of sinkless changefeeds retained by a node",
+	64,
+	settings.PositiveInt,
+)
@@ -9,13 +9,54 @@
 package changefeedbase
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/catconstants"
 	"github.com/cockroachdb/errors"
 )
 
-// ValidateTable validates that a table descriptor can be watched by a CHANGEFEED.
-func ValidateTable(targets jobspb.ChangefeedTargets, tableDesc catalog.TableDescriptor) error {
+// systemTableAllowlist is the set of system tables which may be watched by a
+// CHANGEFEED. These are small, infrequently written tables that are useful for
+// building operational tooling (e.g. auditing cluster settings or role
+// changes), that are not written by the changefeed machinery itself, and that
+// have a single column family.
+var systemTableAllowlist = map[catconstants.SystemTableName]struct{}{
+	catconstants.SettingsTableName:             {},
+	catconstants.LocationsTableName:            {},
+	catconstants.RoleMembersTableName:          {},
+	catconstants.RoleOptionsTableName:          {},
+	catconstants.DatabaseRoleSettingsTableName: {},
+}
+
+// IsAllowlistedSystemTable returns true if the table is a system table which
+// may be watched by a CHANGEFEED. Watching such tables requires the admin
+// role.
+func IsAllowlistedSystemTable(tableDesc catalog.TableDescriptor) bool {
+	if tableDesc.GetParentID() != keys.SystemDatabaseID {
+		return false
+	}
+	_, ok := systemTableAllowlist[catconstants.SystemTableName(tableDesc.GetName())]
+	return ok
+}
+
+func allowlistedSystemTableNames() string {
+	names := make([]string, 0, len(systemTableAllowlist))
+	for name := range systemTableAllowlist {
+		names = append(names, `system.`+string(name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// ValidateTable validates that a table descriptor can be watched by a CHANGEFEED
+// with the given options.
+func ValidateTable(
+	targets jobspb.ChangefeedTargets, tableDesc catalog.TableDescriptor, opts map[string]string,
+) error {
 	t, ok := targets[tableDesc.GetID()]
 	if !ok {
 		return errors.Errorf(`unwatched table: %s`, tableDesc.GetName())
@@ -24,10 +65,12 @@ func ValidateTable(targets jobspb.ChangefeedTargets, tableDesc catalog.TableDesc
 	// Technically, the only non-user table known not to work is system.jobs
 	// (which creates a cycle since the resolved timestamp high-water mark is
 	// saved in it), but there are subtle differences in the way many of them
-	// work and this will be under-tested, so disallow them all until demand
-	// dictates.
-	if catalog.IsSystemDescriptor(tableDesc) {
-		return errors.Errorf(`CHANGEFEEDs are not supported on system tables`)
+	// work and this will be under-tested, so disallow all but an allowlist
+	// until demand dictates.
+	if catalog.IsSystemDescriptor(tableDesc) && !IsAllowlistedSystemTable(tableDesc) {
+		return errors.WithHintf(
+			errors.Errorf(`CHANGEFEEDs are not supported on system tables`),
+			`the system tables which may be watched are: %s`, allowlistedSystemTableNames())
 	}
 	if tableDesc.IsView() {
 		return errors.Errorf(`CHANGEFEED cannot target views: %s`, tableDesc.GetName())
@@ -38,7 +81,11 @@ func ValidateTable(targets jobspb.ChangefeedTargets, tableDesc catalog.TableDesc
 	if tableDesc.IsSequence() {
 		return errors.Errorf(`CHANGEFEED cannot target sequences: %s`, tableDesc.GetName())
 	}
-	if len(tableDesc.GetFamilies()) != 1 {
+	// Each KV is decoded into a row on its own, so the KVs of the other
+	// families of a row would be emitted as rows missing their columns. With
+	// the collapse_families option, the whole row is read for each of its
+	// versions instead.
+	if _, ok := opts[OptCollapseFamilies]; !ok && len(tableDesc.GetFamilies()) != 1 {
 		return errors.Errorf(
 			`CHANGEFEEDs are currently supported on tables with exactly 1 column family: %s has %d`,
 			tableDesc.GetName(), len(tableDesc.GetFamilies()))
@@ -71,3 +118,30 @@ func WarningsForTable(
 	}
 	return warnings
 }
+
+// OptionsForTarget returns the options of a changefeed which apply to one of
+// its targets: the changefeed's options, with the target's overrides applied.
+func OptionsForTarget(opts map[string]string, target jobspb.ChangefeedTarget) map[string]string {
+	if len(target.Opts) == 0 {
+		return opts
+	}
+	res := make(map[string]string, len(opts)+len(target.Opts))
+	for k, v := range opts {
+		res[k] = v
+	}
+	for k, v := range target.Opts {
+		res[k] = v
+	}
+	return res
+}
+
+// HasTargetOptions returns true if any of the targets overrides the
+// changefeed's options.
+func HasTargetOptions(targets jobspb.ChangefeedTargets) bool {
+	for _, t := range targets {
+		if len(t.Opts) > 0 {
+			return true
+		}
+	}
+	return false
+}
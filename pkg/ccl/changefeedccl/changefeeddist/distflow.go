@@ -30,7 +30,10 @@ var ChangefeedResultTypes = []*types.T{
 	types.Bytes,  // value
 }
 
-// StartDistChangefeed starts distributed changefeed execution.
+// StartDistChangefeed starts distributed changefeed execution. The
+// aggregators' sinks continue from the checkpointed sequences with the
+// sequence_numbers option, and rows are tagged with the epoch of the run with
+// the changefeed_epoch option.
 func StartDistChangefeed(
 	ctx context.Context,
 	execCtx sql.JobExecContext,
@@ -39,6 +42,8 @@ func StartDistChangefeed(
 	trackedSpans []roachpb.Span,
 	initialHighWater hlc.Timestamp,
 	checkpoint jobspb.ChangefeedProgress_Checkpoint,
+	sequences []jobspb.ChangefeedSequence,
+	epoch int64,
 	resultsCh chan<- tree.Datums,
 ) error {
 	// Changefeed flows handle transactional consistency themselves.
@@ -80,11 +85,14 @@ func StartDistChangefeed(
 		}
 
 		spec := &execinfrapb.ChangeAggregatorSpec{
-			Watches:    watches,
-			Checkpoint: aggregatorCheckpoint,
-			Feed:       details,
-			UserProto:  execCtx.User().EncodeProto(),
-			JobID:      jobID,
+			Watches:        watches,
+			Checkpoint:     aggregatorCheckpoint,
+			Feed:           details,
+			UserProto:      execCtx.User().EncodeProto(),
+			JobID:          jobID,
+			Sequences:      sequences,
+			Epoch:          epoch,
+			NumAggregators: int32(len(spanPartitions)),
 		}
 		corePlacement[i].SQLInstanceID = sp.SQLInstanceID
 		corePlacement[i].Core.ChangeAggregator = spec
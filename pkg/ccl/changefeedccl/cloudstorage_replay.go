@@ -0,0 +1,344 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// cloudStorageDataFileRE matches the names of the data files written by the
+// cloud storage sink, capturing the topic and the file extension. See
+// cloudStorageSink.flushFile.
+var cloudStorageDataFileRE = regexp.MustCompile(
+	`^\d{33}-.+?-\d+-\d+-[0-9a-f]{8}-(.+)-[0-9a-f]+(\.[a-z]+(?:\.gz)?)$`)
+
+// parseCloudStorageTime parses a timestamp formatted by cloudStorageFormatTime.
+func parseCloudStorageTime(s string) (hlc.Timestamp, error) {
+	if len(s) != 33 {
+		return hlc.Timestamp{}, errors.Errorf(`invalid timestamp %q`, s)
+	}
+	t, err := time.Parse(`20060102150405`, s[:14])
+	if err != nil {
+		return hlc.Timestamp{}, errors.Wrapf(err, `invalid timestamp %q`, s)
+	}
+	nanos, err := strconv.ParseInt(s[14:23], 10, 64)
+	if err != nil {
+		return hlc.Timestamp{}, errors.Wrapf(err, `invalid timestamp %q`, s)
+	}
+	logical, err := strconv.ParseInt(s[23:], 10, 32)
+	if err != nil {
+		return hlc.Timestamp{}, errors.Wrapf(err, `invalid timestamp %q`, s)
+	}
+	return hlc.Timestamp{WallTime: t.UnixNano() + nanos, Logical: int32(logical)}, nil
+}
+
+// cloudStorageReplayer re-emits the output previously written by a changefeed
+// into a cloud storage sink to another sink.
+//
+// Files are read in lexicographic order of their names, which the naming
+// scheme of the cloud storage sink guarantees preserves the changefeed's
+// ordering guarantees (see the comment on cloudStorageSink). Rows are emitted
+// with the key recovered from the `key_in_value` field, and each resolved
+// timestamp file is re-emitted as a resolved timestamp after flushing the
+// rows read before it, so the resolved timestamps carry the same guarantee
+// as in the original feed.
+//
+// Files written by earlier sessions of the original changefeed may contain
+// rows which were emitted again after a restart. Such duplicates are
+// suppressed: a row is only emitted once for each topic, key and updated
+// timestamp (or value, if the files were written without the updated option).
+// Since a restarted changefeed only re-emits rows above its last resolved
+// timestamp, rows at or below a resolved timestamp are forgotten once it has
+// been replayed.
+type cloudStorageReplayer struct {
+	es      cloud.ExternalStorage
+	sink    Sink
+	encoder Encoder
+	// topics maps the topic names of the files to replay to the topic
+	// descriptors used to emit their rows. Files of other topics are skipped.
+	topics map[string]TopicDescriptor
+
+	// resolved is the highest resolved timestamp replayed so far.
+	resolved hlc.Timestamp
+	// seen holds the updated timestamp of every row emitted since the last
+	// resolved timestamp, keyed by its identity.
+	seen map[string]hlc.Timestamp
+
+	emittedRows int64
+}
+
+var _ timestampLowerBoundOracle = (*cloudStorageReplayer)(nil)
+
+// inclusiveLowerBoundTS implements the timestampLowerBoundOracle interface,
+// which the cloud storage sink uses to name the files it writes when the
+// output is replayed into another cloud storage location.
+func (r *cloudStorageReplayer) inclusiveLowerBoundTS() hlc.Timestamp {
+	return r.resolved.Next()
+}
+
+// replay emits the rows and resolved timestamps of every file, returning once
+// all of them have been flushed to the sink.
+func (r *cloudStorageReplayer) replay(ctx context.Context) error {
+	var files []string
+	if err := r.es.List(ctx, ``, ``, func(f string) error {
+		// Skip files in the process of being written.
+		if !strings.HasSuffix(f, `.tmp`) {
+			files = append(files, f)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		var err error
+		if base := path.Base(f); strings.HasSuffix(base, `.RESOLVED`) {
+			err = r.replayResolvedFile(ctx, base)
+		} else {
+			err = r.replayDataFile(ctx, f)
+		}
+		if err != nil {
+			return errors.Wrapf(err, `replaying %s`, f)
+		}
+	}
+	return r.sink.Flush(ctx)
+}
+
+func (r *cloudStorageReplayer) replayResolvedFile(ctx context.Context, base string) error {
+	resolved, err := parseCloudStorageTime(strings.TrimSuffix(base, `.RESOLVED`))
+	if err != nil {
+		return err
+	}
+	if resolved.LessEq(r.resolved) {
+		return nil
+	}
+	if err := r.sink.Flush(ctx); err != nil {
+		return err
+	}
+	if err := r.sink.EmitResolvedTimestamp(ctx, r.encoder, resolved); err != nil {
+		return err
+	}
+	r.resolved = resolved
+	for k, updated := range r.seen {
+		if updated.LessEq(resolved) {
+			delete(r.seen, k)
+		}
+	}
+	return nil
+}
+
+func (r *cloudStorageReplayer) replayDataFile(ctx context.Context, name string) error {
+	subs := cloudStorageDataFileRE.FindStringSubmatch(path.Base(name))
+	if subs == nil {
+		log.Warningf(ctx, `skipping unexpected file %s`, name)
+		return nil
+	}
+	topicName, ext := subs[1], subs[2]
+	topic, ok := r.topics[topicName]
+	if !ok {
+		return nil
+	}
+
+	f, err := r.es.ReadFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(ext, `.gz`) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in, ext = gz, strings.TrimSuffix(ext, `.gz`)
+	}
+	if ext != `.ndjson` {
+		return errors.Errorf(`only files written with %s=%s can be replayed`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+	}
+
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 64<<20)
+	for s.Scan() {
+		if err := r.replayRow(ctx, topic, s.Bytes()); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// replayRow emits a single row of a JSON data file, which must have been
+// written with the key_in_value option.
+func (r *cloudStorageReplayer) replayRow(
+	ctx context.Context, topic TopicDescriptor, line []byte,
+) error {
+	value, err := json.ParseJSON(string(line))
+	if err != nil {
+		return err
+	}
+	// The wrapped envelope puts the key and updated timestamp at the top level
+	// of the value, the bare envelope in the jsonMetaSentinel object.
+	meta, err := value.FetchValKey(jsonMetaSentinel)
+	if err != nil {
+		return err
+	}
+	bare := meta != nil
+	if !bare {
+		meta = value
+	}
+	key, err := meta.FetchValKey(`key`)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.Errorf(`rows must include the key, which is written with the %s option`,
+			changefeedbase.OptKeyInValue)
+	}
+	var updated hlc.Timestamp
+	if u, err := meta.FetchValKey(`updated`); err != nil {
+		return err
+	} else if u != nil {
+		text, err := u.AsText()
+		if err != nil {
+			return err
+		}
+		if text != nil {
+			if updated, err = tree.ParseHLC(*text); err != nil {
+				return err
+			}
+		}
+	}
+	// Remove the key from the value, as it is emitted separately.
+	meta, _, err = meta.RemoveString(`key`)
+	if err != nil {
+		return err
+	}
+	if !bare {
+		value = meta
+	} else if value, err = replaceJSONField(value, jsonMetaSentinel, meta); err != nil {
+		return err
+	}
+
+	var keyBuf, valueBuf bytes.Buffer
+	key.Format(&keyBuf)
+	value.Format(&valueBuf)
+
+	identity := topic.GetName() + "\x00" + keyBuf.String() + "\x00"
+	if updated.IsEmpty() {
+		identity += valueBuf.String()
+	} else {
+		identity += updated.String()
+	}
+	if _, ok := r.seen[identity]; ok {
+		return nil
+	}
+	// Rows without an updated timestamp are forgotten at the next resolved
+	// timestamp.
+	r.seen[identity] = updated
+
+	r.emittedRows++
+	return r.sink.EmitRow(ctx, topic, keyBuf.Bytes(), valueBuf.Bytes(), updated, updated, zeroAlloc)
+}
+
+// replaceJSONField returns a copy of the JSON object obj with the value of
+// field replaced by v.
+func replaceJSONField(obj json.JSON, field string, v json.JSON) (json.JSON, error) {
+	it, err := obj.ObjectIter()
+	if err != nil {
+		return nil, err
+	}
+	b := json.NewObjectBuilder(obj.Len())
+	for it.Next() {
+		if it.Key() == field {
+			b.Add(it.Key(), v)
+		} else {
+			b.Add(it.Key(), it.Value())
+		}
+	}
+	return b.Build(), nil
+}
+
+// replayCloudStorageFeed replays the files in the cloud storage location
+// replayFrom into the sink of the changefeed described by details, returning
+// the number of rows emitted.
+func replayCloudStorageFeed(
+	ctx context.Context,
+	p sql.PlanHookState,
+	details jobspb.ChangefeedDetails,
+	targetTables []catalog.TableDescriptor,
+	replayFrom string,
+) (int64, error) {
+	es, err := p.ExecCfg().DistSQLSrv.ExternalStorageFromURI(ctx, replayFrom, p.User())
+	if err != nil {
+		return 0, err
+	}
+	defer es.Close()
+
+	encoder, err := getEncoder(details.Opts, details.Targets)
+	if err != nil {
+		return 0, err
+	}
+	defer closeSchemaRegistries(encoder)
+	r := &cloudStorageReplayer{
+		es:      es,
+		encoder: encoder,
+		topics:  make(map[string]TopicDescriptor),
+		seen:    make(map[string]hlc.Timestamp),
+	}
+	for _, tableDesc := range targetTables {
+		topic := &tableDescriptorTopic{tableDesc}
+		r.topics[tableDesc.GetName()] = topic
+		if target, ok := details.Targets[tableDesc.GetID()]; ok {
+			r.topics[target.StatementTimeName] = topic
+		}
+	}
+
+	metrics := p.ExecCfg().JobRegistry.MetricsStruct().Changefeed.(*Metrics)
+	sli, err := metrics.getSLIMetrics(details.Opts[changefeedbase.OptMetricsScope])
+	if err != nil {
+		return 0, err
+	}
+	if r.sink, err = getSink(ctx, &p.ExecCfg().DistSQLSrv.ServerConfig, details,
+		r, p.User(), jobspb.InvalidJobID, sli); err != nil {
+		return 0, changefeedbase.MaybeStripRetryableErrorMarker(err)
+	}
+	defer func() {
+		if err := r.sink.Close(); err != nil {
+			log.Warningf(ctx, `failed to close sink: %v`, err)
+		}
+	}()
+
+	if err := r.replay(ctx); err != nil {
+		return r.emittedRows, changefeedbase.MaybeStripRetryableErrorMarker(err)
+	}
+	return r.emittedRows, nil
+}
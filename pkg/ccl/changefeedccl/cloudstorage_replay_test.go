@@ -0,0 +1,144 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// replayRecordingSink records the rows and resolved timestamps emitted to it.
+type replayRecordingSink struct {
+	events  []string
+	flushes int
+}
+
+var _ Sink = (*replayRecordingSink)(nil)
+
+func (s *replayRecordingSink) Dial() error { return nil }
+
+func (s *replayRecordingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	s.events = append(s.events, fmt.Sprintf(`%s: %s->%s`, topic.GetName(), key, value))
+	return nil
+}
+
+func (s *replayRecordingSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, ``, resolved)
+	if err != nil {
+		return err
+	}
+	s.events = append(s.events, fmt.Sprintf(`flushes=%d resolved: %s`, s.flushes, payload))
+	return nil
+}
+
+func (s *replayRecordingSink) Flush(ctx context.Context) error {
+	s.flushes++
+	return nil
+}
+
+func (s *replayRecordingSink) Close() error { return nil }
+
+func TestParseCloudStorageTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, ts := range []hlc.Timestamp{
+		{WallTime: 1},
+		{WallTime: 1646318793123456789, Logical: 42},
+	} {
+		parsed, err := parseCloudStorageTime(cloudStorageFormatTime(ts))
+		require.NoError(t, err)
+		require.Equal(t, ts, parsed)
+	}
+	_, err := parseCloudStorageTime(`1970`)
+	require.EqualError(t, err, `invalid timestamp "1970"`)
+}
+
+func TestCloudStorageReplay(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+
+	ts := func(i int64) string { return cloudStorageFormatTime(hlc.Timestamp{WallTime: i}) }
+	row := func(key, updated int) string {
+		return fmt.Sprintf(`{"after": {"a": %[1]d}, "key": [%[1]d], "updated": "%[2]d.0000000000"}`,
+			key, updated)
+	}
+	files := map[string][]string{
+		// Session a emits rows 1 and 2 and resolves 2, then restarts.
+		ts(1) + `-a-1-7-00000000-foo-1.ndjson`: {row(1, 1), row(2, 3)},
+		ts(2) + `.RESOLVED`:                    {`{"resolved":"2.0000000000"}`},
+		// Session b re-emits row 2 as it was above the resolved timestamp.
+		ts(3) + `-b-1-7-00000000-foo-1.ndjson`: {row(2, 3), row(3, 4)},
+		ts(3) + `-b-1-7-00000001-bar-1.ndjson`: {row(4, 4)},
+		ts(4) + `.RESOLVED`:                    {`{"resolved":"4.0000000000"}`},
+		// Files still being written are skipped.
+		ts(4) + `-b-1-7-00000002-foo-1.ndjson.tmp`: {row(5, 5)},
+	}
+	partition := filepath.Join(dir, `feed`, `1970-01-01`)
+	require.NoError(t, os.MkdirAll(partition, 0755))
+	for name, lines := range files {
+		require.NoError(t, ioutil.WriteFile(
+			filepath.Join(partition, name), []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	}
+
+	settings := cluster.MakeTestingClusterSettings()
+	es, err := cloud.ExternalStorageFromURI(ctx, `nodelocal://0/feed`, base.ExternalIODirConfig{},
+		settings, blobs.TestBlobServiceClient(dir), security.RootUserName(), nil, nil)
+	require.NoError(t, err)
+	defer es.Close()
+
+	sink := &replayRecordingSink{}
+	foo := makeTopic(`foo`)
+	r := &cloudStorageReplayer{
+		es:      es,
+		sink:    sink,
+		encoder: &jsonEncoder{wrapped: true},
+		topics:  map[string]TopicDescriptor{`foo`: foo},
+		seen:    make(map[string]hlc.Timestamp),
+	}
+	require.NoError(t, r.replay(ctx))
+
+	require.Equal(t, []string{
+		`foo: [1]->{"after": {"a": 1}, "updated": "1.0000000000"}`,
+		`foo: [2]->{"after": {"a": 2}, "updated": "3.0000000000"}`,
+		`flushes=1 resolved: {"resolved":"2.0000000000"}`,
+		`foo: [3]->{"after": {"a": 3}, "updated": "4.0000000000"}`,
+		`flushes=2 resolved: {"resolved":"4.0000000000"}`,
+	}, sink.events)
+	require.Equal(t, int64(3), r.emittedRows)
+	require.Equal(t, hlc.Timestamp{WallTime: 4}, r.resolved)
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"container/heap"
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+)
+
+// familyCollapser emits each version of the rows of tables with multiple
+// column families as a single row, for the collapse_families option.
+//
+// Each column family of a row is stored under its own key, and the rangefeed
+// delivers a KV for each family written by a transaction, in no particular
+// order relative to the KVs of other keys. Waiting for all the families of a
+// row version to arrive doesn't work: a transaction only writes the families
+// whose columns it changed, and nothing tells which those were. Instead, the
+// first KV of a row version to arrive triggers a read of the whole row at the
+// version's timestamp, which is emitted as the row, and the KVs of its other
+// families, which can only repeat what was read, are dropped. Rows are
+// never held back, so no timeout is needed.
+//
+// The row versions which were emitted are remembered until the resolved
+// frontier of the change aggregator passes them, after which no more of their
+// KVs can arrive. They are charged to acc, an account of the change
+// aggregator's memory monitor, and once it's exhausted the oldest ones are
+// forgotten early: the KVs of their other families, if any arrive later, are
+// emitted as duplicates of the whole row, as changefeeds emit at least once
+// anyway.
+type familyCollapser struct {
+	db  *kv.DB
+	acc *mon.BoundAccount
+
+	emitted map[familyVersion]struct{}
+	order   familyVersionHeap
+}
+
+// familyVersion identifies a version of a row, by the prefix of the keys of
+// its families and its timestamp.
+type familyVersion struct {
+	rowPrefix string
+	ts        hlc.Timestamp
+}
+
+// familyVersionOverhead approximates the memory held by a familyVersion on top
+// of its row prefix.
+const familyVersionOverhead = 64
+
+func (v familyVersion) size() int64 {
+	return int64(len(v.rowPrefix) + familyVersionOverhead)
+}
+
+// familyVersionHeap is a min-heap of familyVersions ordered by timestamp.
+type familyVersionHeap []familyVersion
+
+func (h familyVersionHeap) Len() int            { return len(h) }
+func (h familyVersionHeap) Less(i, j int) bool  { return h[i].ts.Less(h[j].ts) }
+func (h familyVersionHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *familyVersionHeap) Push(x interface{}) { *h = append(*h, x.(familyVersion)) }
+func (h *familyVersionHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = familyVersion{}
+	*h = old[:n-1]
+	return x
+}
+
+func makeFamilyCollapser(db *kv.DB, acc *mon.BoundAccount) *familyCollapser {
+	return &familyCollapser{
+		db:      db,
+		acc:     acc,
+		emitted: make(map[familyVersion]struct{}),
+	}
+}
+
+// collapsedRow holds the KVs of all the families of a row version, and of
+// the version before it, as read by a familyCollapser.
+type collapsedRow struct {
+	// key is the key of the row's first family, which stands for the row when
+	// it has no KVs, i.e. when it was deleted.
+	key roachpb.Key
+	// mvcc is the latest MVCC timestamp among the row's KVs.
+	mvcc    hlc.Timestamp
+	kvs     []roachpb.KeyValue
+	prevKVs []roachpb.KeyValue
+	// prevRead is set if prevKVs were read.
+	prevRead bool
+}
+
+// collapse returns the whole row version of which ev is a KV, at ts, along
+// with the version before it if withPrev is set. It returns false if the row
+// version was already emitted for another of its families, in which case ev
+// is to be dropped.
+func (f *familyCollapser) collapse(
+	ctx context.Context, ev kvevent.Event, ts hlc.Timestamp, withPrev bool,
+) (*collapsedRow, bool, error) {
+	prefix, err := keys.EnsureSafeSplitKey(ev.KV().Key)
+	if err != nil {
+		return nil, false, err
+	}
+	v := familyVersion{rowPrefix: string(prefix), ts: ts}
+	if _, ok := f.emitted[v]; ok {
+		return nil, false, nil
+	}
+
+	r := &collapsedRow{
+		key:  keys.MakeFamilyKey(append(roachpb.Key(nil), prefix...), 0),
+		mvcc: ev.MVCCTimestamp(),
+	}
+	if r.kvs, err = f.readRow(ctx, prefix, ts); err != nil {
+		return nil, false, err
+	}
+	for i := range r.kvs {
+		r.mvcc.Forward(r.kvs[i].Value.Timestamp)
+	}
+	if withPrev {
+		if r.prevKVs, err = f.readRow(ctx, prefix, ts.Prev()); err != nil {
+			return nil, false, err
+		}
+		r.prevRead = true
+	}
+
+	for f.acc.Grow(ctx, v.size()) != nil {
+		if len(f.order) == 0 {
+			// The row version can't be remembered at all.
+			return r, true, nil
+		}
+		f.forgetOldest(ctx)
+	}
+	f.emitted[v] = struct{}{}
+	heap.Push(&f.order, v)
+	return r, true, nil
+}
+
+// readRow reads the KVs of the families of the row with the given key prefix
+// as of ts.
+func (f *familyCollapser) readRow(
+	ctx context.Context, prefix roachpb.Key, ts hlc.Timestamp,
+) ([]roachpb.KeyValue, error) {
+	var kvs []roachpb.KeyValue
+	if err := f.db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		kvs = kvs[:0]
+		if err := txn.SetFixedTimestamp(ctx, ts); err != nil {
+			return err
+		}
+		res, err := txn.Scan(ctx, prefix, prefix.PrefixEnd(), 0 /* maxRows */)
+		if err != nil {
+			return err
+		}
+		for _, row := range res {
+			kvs = append(kvs, roachpb.KeyValue{Key: row.Key, Value: *row.Value})
+		}
+		return nil
+	}); err != nil {
+		// As with the reads of table descriptors, none of the errors of a
+		// read at a past timestamp are expected to be terminal.
+		return nil, changefeedbase.MarkRetryableError(err)
+	}
+	return kvs, nil
+}
+
+// release forgets the row versions at or before resolved, the resolved
+// frontier of the change aggregator, all of whose KVs have been received.
+func (f *familyCollapser) release(ctx context.Context, resolved hlc.Timestamp) {
+	for len(f.order) > 0 && !resolved.Less(f.order[0].ts) {
+		f.forgetOldest(ctx)
+	}
+}
+
+// forgetOldest forgets the oldest row version.
+func (f *familyCollapser) forgetOldest(ctx context.Context) {
+	v := heap.Pop(&f.order).(familyVersion)
+	delete(f.emitted, v)
+	f.acc.Shrink(ctx, v.size())
+}
+
+// close forgets every row version.
+func (f *familyCollapser) close(ctx context.Context) {
+	f.emitted, f.order = nil, nil
+	f.acc.Close(ctx)
+}
+
+// appendKVs appends the KVs of the row, or the tombstone of its first family
+// if it was deleted, to kvs.
+func (r *collapsedRow) appendKVs(kvs []roachpb.KeyValue) []roachpb.KeyValue {
+	if len(r.kvs) == 0 {
+		return append(kvs, roachpb.KeyValue{Key: r.key})
+	}
+	return append(kvs, r.kvs...)
+}
+
+// appendPrevKVs appends the KVs of the previous version of the row, or the
+// tombstone of its first family if there was none, to kvs.
+func (r *collapsedRow) appendPrevKVs(kvs []roachpb.KeyValue) []roachpb.KeyValue {
+	if len(r.prevKVs) == 0 {
+		return append(kvs, roachpb.KeyValue{Key: r.key})
+	}
+	return append(kvs, r.prevKVs...)
+}
+
+// kvsValueSize returns the size of the values of kvs.
+func kvsValueSize(kvs []roachpb.KeyValue) int {
+	var n int
+	for i := range kvs {
+		n += len(kvs[i].Value.RawBytes)
+	}
+	return n
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/errors"
+)
+
+// maxColumnCommentLength is the length, in bytes, past which the column
+// comments included in schemas with the column_comments option are truncated.
+// Schema registries limit the size of the schemas they accept, which a table
+// with many long comments would otherwise exceed.
+const maxColumnCommentLength = 1024
+
+// columnCommentsFetcher returns the comments of the columns of a table, by
+// column ID. Columns without comments are omitted.
+//
+// Column comments are not stored in table descriptors but in system.comments,
+// and setting one doesn't change the version of the table descriptor, so
+// schemas generated for a version of a table keep the comments as of when
+// they were generated.
+type columnCommentsFetcher func(
+	ctx context.Context, desc catalog.TableDescriptor,
+) (map[descpb.ColumnID]string, error)
+
+// makeColumnCommentsFetcher returns a columnCommentsFetcher which reads the
+// latest comments from system.comments with the given executor.
+func makeColumnCommentsFetcher(ie sqlutil.InternalExecutor) columnCommentsFetcher {
+	return func(ctx context.Context, desc catalog.TableDescriptor) (map[descpb.ColumnID]string, error) {
+		rows, err := ie.QueryBufferedEx(
+			ctx, `changefeed-column-comments`, nil, /* txn */
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			`SELECT sub_id, comment FROM system.comments WHERE type = $1 AND object_id = $2`,
+			keys.ColumnCommentType, desc.GetID(),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, `fetching column comments of table %s`, desc.GetName())
+		}
+		// Comments are keyed by the attribute number of their column.
+		byAttrNum := make(map[uint32]string, len(rows))
+		for _, row := range rows {
+			byAttrNum[uint32(tree.MustBeDInt(row[0]))] = string(tree.MustBeDString(row[1]))
+		}
+		comments := make(map[descpb.ColumnID]string, len(rows))
+		for _, col := range desc.PublicColumns() {
+			if comment, ok := byAttrNum[col.GetPGAttributeNum()]; ok {
+				comments[col.GetID()] = truncateColumnComment(comment)
+			}
+		}
+		return comments, nil
+	}
+}
+
+// truncateColumnComment truncates comment to at most maxColumnCommentLength
+// bytes, without splitting a multi-byte character.
+func truncateColumnComment(comment string) string {
+	if len(comment) <= maxColumnCommentLength {
+		return comment
+	}
+	end := maxColumnCommentLength
+	for end > 0 && !utf8.RuneStart(comment[end]) {
+		end--
+	}
+	return comment[:end]
+}
+
+// jsonSchemaSidecar describes the columns of a version of a table, for the
+// consumers of changefeeds with format=json, whose messages don't carry their
+// schema. It's written next to the data files of cloud storage sinks with the
+// column_comments option.
+type jsonSchemaSidecar struct {
+	Table   string                    `json:"table"`
+	Version descpb.DescriptorVersion  `json:"version"`
+	Columns []jsonSchemaSidecarColumn `json:"columns"`
+}
+
+type jsonSchemaSidecarColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// makeJSONSchemaSidecar returns the JSON schema sidecar of desc, with the
+// given comments of its columns.
+func makeJSONSchemaSidecar(
+	desc catalog.TableDescriptor, comments map[descpb.ColumnID]string,
+) ([]byte, error) {
+	sidecar := jsonSchemaSidecar{Table: desc.GetName(), Version: desc.GetVersion()}
+	for _, col := range desc.PublicColumns() {
+		sidecar.Columns = append(sidecar.Columns, jsonSchemaSidecarColumn{
+			Name:     col.GetName(),
+			Type:     col.GetType().SQLString(),
+			Nullable: col.IsNullable(),
+			Comment:  comments[col.GetID()],
+		})
+	}
+	return gojson.Marshal(sidecar)
+}
+
+// setColumnComments makes the encoders of e which generate avro schemas
+// document the fields of their value schemas with the comments returned by
+// fetch.
+func setColumnComments(e Encoder, fetch columnCommentsFetcher) {
+	switch e := e.(type) {
+	case *confluentAvroEncoder:
+		e.columnComments = fetch
+	case *perTargetEncoder:
+		setColumnComments(e.Encoder, fetch)
+		for _, targetEncoder := range e.targets {
+			setColumnComments(targetEncoder, fetch)
+		}
+	}
+}
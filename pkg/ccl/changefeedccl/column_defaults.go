@@ -0,0 +1,136 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// columnDefaults materializes the default values of the columns which were
+// added to a table after a row was last written, for the materialize_defaults
+// option. Such rows are decoded with NULLs for the missing columns, whereas a
+// SQL read returns their default value.
+//
+// Only defaults which are constant expressions are materialized, since the
+// values of volatile defaults can't be reproduced, as are the defaults of
+// columns of user defined types.
+type columnDefaults struct {
+	rfCache *rowFetcherCache
+	evalCtx *tree.EvalContext
+	// defaults caches the default values of the public columns of each
+	// table version, by ordinal, or nil if no column has a default that can
+	// be materialized.
+	defaults map[tableIDAndVersion][]tree.Datum
+}
+
+func makeColumnDefaults(rfCache *rowFetcherCache, evalCtx *tree.EvalContext) *columnDefaults {
+	return &columnDefaults{
+		rfCache:  rfCache,
+		evalCtx:  evalCtx,
+		defaults: make(map[tableIDAndVersion][]tree.Datum),
+	}
+}
+
+// materialize replaces the NULL datums of r for columns which were not written
+// by the version of the table in effect at the written timestamp of the row's
+// KV with their default values.
+func (d *columnDefaults) materialize(
+	ctx context.Context, r *encodeRow, key roachpb.Key, written hlc.Timestamp,
+) error {
+	// The descriptor the row is decoded with was in effect when it was
+	// written, so every one of its columns was written.
+	if r.deleted || !written.Less(r.tableDesc.GetModificationTime()) {
+		return nil
+	}
+	defaults, err := d.defaultsFor(ctx, r.tableDesc)
+	if err != nil || defaults == nil {
+		return err
+	}
+	missing := false
+	for i, def := range defaults {
+		if def != nil && r.datums[i].IsNull() {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return nil
+	}
+	writtenDesc, err := d.rfCache.TableDescForKey(ctx, key, written)
+	if err != nil {
+		return err
+	}
+	materializeDefaults(r, writtenDesc, defaults)
+	return nil
+}
+
+// materializeDefaults replaces the NULL datums of r for columns with a
+// default which were not writable in writtenDesc, the version of the table
+// the row was written with.
+func materializeDefaults(r *encodeRow, writtenDesc catalog.TableDescriptor, defaults []tree.Datum) {
+	for i, col := range r.tableDesc.PublicColumns() {
+		if defaults[i] == nil || !r.datums[i].IsNull() {
+			continue
+		}
+		if written, err := writtenDesc.FindColumnWithID(col.GetID()); err == nil && !written.DeleteOnly() {
+			continue
+		}
+		r.datums[i] = rowenc.EncDatum{Datum: defaults[i]}
+	}
+}
+
+// defaultsFor returns the default values of the public columns of desc which
+// can be materialized, by ordinal.
+func (d *columnDefaults) defaultsFor(
+	ctx context.Context, desc catalog.TableDescriptor,
+) ([]tree.Datum, error) {
+	cacheKey := makeTableIDAndVersion(desc.GetID(), desc.GetVersion())
+	if defaults, ok := d.defaults[cacheKey]; ok {
+		return defaults, nil
+	}
+
+	var defaults []tree.Datum
+	semaCtx := tree.MakeSemaContext()
+	for i, col := range desc.PublicColumns() {
+		if !col.HasDefault() || col.IsComputed() || col.GetType().UserDefined() {
+			continue
+		}
+		expr, err := parser.ParseExpr(col.GetDefaultExpr())
+		if err != nil {
+			return nil, err
+		}
+		typedExpr, err := tree.TypeCheck(ctx, expr, &semaCtx, col.GetType())
+		if err != nil {
+			return nil, err
+		}
+		if !tree.IsConst(d.evalCtx, typedExpr) {
+			continue
+		}
+		datum, err := typedExpr.Eval(d.evalCtx)
+		if err != nil {
+			return nil, err
+		}
+		if datum == tree.DNull {
+			continue
+		}
+		if defaults == nil {
+			defaults = make([]tree.Datum, len(desc.PublicColumns()))
+		}
+		defaults[i] = datum
+	}
+	d.defaults[cacheKey] = defaults
+	return defaults, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterializeDefaults(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	written, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b INT)`)
+	require.NoError(t, err)
+	current, err := parseTableDesc(`CREATE TABLE foo (
+		a INT PRIMARY KEY, b INT, c INT DEFAULT 7, d STRING DEFAULT 'x' || 'y', e INT,
+		f TIMESTAMPTZ DEFAULT now()
+	)`)
+	require.NoError(t, err)
+
+	evalCtx := tree.NewTestingEvalContext(cluster.MakeTestingClusterSettings())
+	defer evalCtx.Stop(ctx)
+	d := makeColumnDefaults(nil /* rfCache */, evalCtx)
+	defaults, err := d.defaultsFor(ctx, current)
+	require.NoError(t, err)
+	// Columns without a default, or with a volatile one, are not materialized.
+	require.Equal(t, []tree.Datum{
+		nil, nil, tree.NewDInt(7), tree.NewDString(`xy`), nil, nil,
+	}, defaults)
+
+	row := encodeRow{tableDesc: current}
+	for _, datum := range []tree.Datum{
+		tree.NewDInt(1), tree.DNull, tree.DNull, tree.DNull, tree.DNull, tree.DNull,
+	} {
+		row.datums = append(row.datums, rowenc.EncDatum{Datum: datum})
+	}
+	materializeDefaults(&row, written, defaults)
+	var datums tree.Datums
+	for _, datum := range row.datums {
+		datums = append(datums, datum.Datum)
+	}
+	require.Equal(t, tree.Datums{
+		tree.NewDInt(1), tree.DNull, tree.NewDInt(7), tree.NewDString(`xy`), tree.DNull, tree.DNull,
+	}, datums)
+
+	// Columns which were written as NULL are left as is.
+	row.datums[2] = rowenc.EncDatum{Datum: tree.DNull}
+	materializeDefaults(&row, current, defaults)
+	require.Equal(t, tree.DNull, row.datums[2].Datum)
+}
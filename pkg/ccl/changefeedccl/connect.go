@@ -0,0 +1,219 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	gojson "encoding/json"
+	"math"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// The names of the Kafka Connect logical types used by envelope=connect.
+const (
+	connectLogicalDate      = `org.apache.kafka.connect.data.Date`
+	connectLogicalTimestamp = `org.apache.kafka.connect.data.Timestamp`
+)
+
+// connectSchema is a Kafka Connect schema, as serialized by Connect's
+// JsonConverter with schemas enabled.
+type connectSchema struct {
+	Type     string          `json:"type"`
+	Optional bool            `json:"optional"`
+	Name     string          `json:"name,omitempty"`
+	Version  int             `json:"version,omitempty"`
+	Field    string          `json:"field,omitempty"`
+	Fields   []connectSchema `json:"fields,omitempty"`
+}
+
+// connectEnvelope is a message in the envelope expected by Connect's
+// JsonConverter with schemas enabled.
+type connectEnvelope struct {
+	Schema  *connectSchema         `json:"schema"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// connectTableSchemas holds the Connect schemas of the keys and values of a
+// version of a table.
+type connectTableSchemas struct {
+	key, value *connectSchema
+}
+
+// connectColumnSchema returns the Connect schema of the values of a column.
+// Types without a Connect equivalent are emitted as strings.
+func connectColumnSchema(col catalog.Column) connectSchema {
+	s := connectSchema{Optional: col.IsNullable(), Field: col.GetName()}
+	typ := col.GetType()
+	switch typ.Family() {
+	case types.IntFamily:
+		switch typ.Width() {
+		case 16:
+			s.Type = `int16`
+		case 32:
+			s.Type = `int32`
+		default:
+			s.Type = `int64`
+		}
+	case types.FloatFamily:
+		if typ.Width() == 32 {
+			s.Type = `float32`
+		} else {
+			s.Type = `float64`
+		}
+	case types.BoolFamily:
+		s.Type = `boolean`
+	case types.BytesFamily:
+		s.Type = `bytes`
+	case types.DateFamily:
+		s.Type, s.Name, s.Version = `int32`, connectLogicalDate, 1
+	case types.TimestampFamily, types.TimestampTZFamily:
+		s.Type, s.Name, s.Version = `int64`, connectLogicalTimestamp, 1
+	default:
+		s.Type = `string`
+	}
+	return s
+}
+
+// connectPayloadValue converts a datum to the value of its Connect schema, as
+// returned by connectColumnSchema.
+func connectPayloadValue(d tree.Datum) (interface{}, error) {
+	if d == tree.DNull {
+		return nil, nil
+	}
+	switch t := d.(type) {
+	case *tree.DInt:
+		return int64(*t), nil
+	case *tree.DFloat:
+		if f := float64(*t); math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.Errorf(`%s cannot be encoded with %s=%s`,
+				t, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeConnect)
+		}
+		return float64(*t), nil
+	case *tree.DBool:
+		return bool(*t), nil
+	case *tree.DBytes:
+		// Encoded as base64 by encoding/json, as expected by Connect.
+		return []byte(*t), nil
+	case *tree.DDate:
+		if !t.IsFinite() {
+			return nil, errors.Errorf(`infinite dates cannot be encoded with %s=%s`,
+				changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeConnect)
+		}
+		return t.UnixEpochDays(), nil
+	case *tree.DTimestamp:
+		return t.UnixNano() / int64(time.Millisecond), nil
+	case *tree.DTimestampTZ:
+		return t.UnixNano() / int64(time.Millisecond), nil
+	default:
+		return tree.AsStringWithFlags(d, tree.FmtBareStrings), nil
+	}
+}
+
+// connectSchemas returns the Connect schemas of the keys and values of the
+// table of row.
+func (e *jsonEncoder) connectSchemas(row encodeRow) (*connectTableSchemas, error) {
+	cacheKey := makeTableIDAndVersion(row.tableDesc.GetID(), row.tableDesc.GetVersion())
+	if s, ok := e.connectCache[cacheKey]; ok {
+		return s, nil
+	}
+
+	name := row.tableDesc.GetName()
+	if target, ok := e.targets[row.tableDesc.GetID()]; ok {
+		name = target.StatementTimeName
+	}
+	s := &connectTableSchemas{
+		key:   &connectSchema{Type: `struct`, Name: SQLNameToKafkaName(name) + `.Key`},
+		value: &connectSchema{Type: `struct`, Name: SQLNameToKafkaName(name) + `.Value`},
+	}
+	primaryIndex := row.tableDesc.GetPrimaryIndex()
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		col, err := row.tableDesc.FindColumnWithID(primaryIndex.GetKeyColumnID(i))
+		if err != nil {
+			return nil, err
+		}
+		s.key.Fields = append(s.key.Fields, connectColumnSchema(col))
+	}
+	for _, col := range row.tableDesc.PublicColumns() {
+		if col.IsVirtual() && e.virtualColumnVisibility == string(changefeedbase.OptVirtualColumnsOmitted) {
+			continue
+		}
+		s.value.Fields = append(s.value.Fields, connectColumnSchema(col))
+	}
+
+	if e.connectCache == nil {
+		e.connectCache = make(map[tableIDAndVersion]*connectTableSchemas)
+	}
+	e.connectCache[cacheKey] = s
+	return s, nil
+}
+
+// encodeConnectKey encodes the primary key of row in the Connect envelope.
+func (e *jsonEncoder) encodeConnectKey(row encodeRow) ([]byte, error) {
+	s, err := e.connectSchemas(row)
+	if err != nil {
+		return nil, err
+	}
+	colIdxByID := catalog.ColumnIDToOrdinalMap(row.tableDesc.PublicColumns())
+	primaryIndex := row.tableDesc.GetPrimaryIndex()
+	payload := make(map[string]interface{}, primaryIndex.NumKeyColumns())
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		colID := primaryIndex.GetKeyColumnID(i)
+		idx, ok := colIdxByID.Get(colID)
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		v, err := e.connectColumnValue(row.tableDesc.PublicColumns()[idx], row.datums[idx])
+		if err != nil {
+			return nil, err
+		}
+		payload[primaryIndex.GetKeyColumnName(i)] = v
+	}
+	return gojson.Marshal(connectEnvelope{Schema: s.key, Payload: payload})
+}
+
+// encodeConnectValue encodes the columns of row in the Connect envelope.
+// Deletes are encoded as tombstones, which Connect sinks interpret as
+// deletions of the key.
+func (e *jsonEncoder) encodeConnectValue(row encodeRow) ([]byte, error) {
+	if row.deleted {
+		return nil, nil
+	}
+	s, err := e.connectSchemas(row)
+	if err != nil {
+		return nil, err
+	}
+	columns := row.tableDesc.PublicColumns()
+	payload := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		if col.IsVirtual() && e.virtualColumnVisibility == string(changefeedbase.OptVirtualColumnsOmitted) {
+			continue
+		}
+		v, err := e.connectColumnValue(col, row.datums[i])
+		if err != nil {
+			return nil, err
+		}
+		payload[col.GetName()] = v
+	}
+	return gojson.Marshal(connectEnvelope{Schema: s.value, Payload: payload})
+}
+
+func (e *jsonEncoder) connectColumnValue(
+	col catalog.Column, datum rowenc.EncDatum,
+) (interface{}, error) {
+	if err := datum.EnsureDecoded(col.GetType(), &e.alloc); err != nil {
+		return nil, err
+	}
+	return connectPayloadValue(datum.Datum)
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	gojson "encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeConnectMessage decodes a message in the Kafka Connect envelope the
+// way Connect's JsonConverter does with schemas enabled, checking that the
+// payload conforms to the schema. It returns the name of the schema and the
+// fields of the payload, with logical types converted to their Go types.
+func decodeConnectMessage(t *testing.T, msg []byte) (string, map[string]interface{}) {
+	t.Helper()
+	var envelope struct {
+		Schema  *connectSchema
+		Payload map[string]gojson.RawMessage
+	}
+	dec := gojson.NewDecoder(bytes.NewReader(msg))
+	dec.DisallowUnknownFields()
+	require.NoError(t, dec.Decode(&envelope), string(msg))
+	require.NotNil(t, envelope.Schema, string(msg))
+	require.Equal(t, `struct`, envelope.Schema.Type)
+	require.Len(t, envelope.Payload, len(envelope.Schema.Fields), string(msg))
+
+	fields := make(map[string]interface{}, len(envelope.Payload))
+	for _, field := range envelope.Schema.Fields {
+		raw, ok := envelope.Payload[field.Field]
+		require.True(t, ok, `missing field %s: %s`, field.Field, msg)
+		if string(raw) == `null` {
+			require.True(t, field.Optional, `null value for required field %s`, field.Field)
+			fields[field.Field] = nil
+			continue
+		}
+		var v interface{}
+		var err error
+		switch field.Type {
+		case `int8`, `int16`, `int32`, `int64`:
+			var i int64
+			err = gojson.Unmarshal(raw, &i)
+			v = i
+		case `float32`, `float64`:
+			var f float64
+			err = gojson.Unmarshal(raw, &f)
+			v = f
+		case `boolean`:
+			var b bool
+			err = gojson.Unmarshal(raw, &b)
+			v = b
+		case `bytes`:
+			var s string
+			if err = gojson.Unmarshal(raw, &s); err == nil {
+				v, err = base64.StdEncoding.DecodeString(s)
+			}
+		case `string`:
+			var s string
+			err = gojson.Unmarshal(raw, &s)
+			v = s
+		default:
+			t.Fatalf(`unexpected type %s of field %s`, field.Type, field.Field)
+		}
+		require.NoError(t, err, `field %s: %s`, field.Field, raw)
+
+		switch field.Name {
+		case ``:
+		case connectLogicalDate:
+			require.Equal(t, `int32`, field.Type)
+			v = time.Unix(0, 0).UTC().AddDate(0, 0, int(v.(int64)))
+		case connectLogicalTimestamp:
+			require.Equal(t, `int64`, field.Type)
+			v = time.Unix(0, v.(int64)*int64(time.Millisecond)).UTC()
+		default:
+			t.Fatalf(`unexpected logical type %s of field %s`, field.Name, field.Field)
+		}
+		fields[field.Field] = v
+	}
+	return envelope.Schema.Name, fields
+}
+
+func TestConnectEnvelope(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (
+		a INT PRIMARY KEY, i2 INT2, i4 INT4, f4 FLOAT4, f8 FLOAT8, b BOOL, bt BYTES,
+		d DATE, ts TIMESTAMP, tz TIMESTAMPTZ, dc DECIMAL, s STRING NOT NULL, n STRING
+	)`)
+	require.NoError(t, err)
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+
+	ts := time.Date(2022, 3, 4, 5, 6, 7, 8000000, time.UTC)
+	date, err := tree.NewDDateFromTime(ts)
+	require.NoError(t, err)
+	dec, err := tree.ParseDDecimal(`1.25`)
+	require.NoError(t, err)
+	datums := []tree.Datum{
+		tree.NewDInt(1), tree.NewDInt(2), tree.NewDInt(4), tree.NewDFloat(1.5), tree.NewDFloat(2.25),
+		tree.DBoolTrue, tree.NewDBytes(`abc`), date, tree.MustMakeDTimestamp(ts, time.Microsecond),
+		tree.MustMakeDTimestampTZ(ts, time.Microsecond), dec, tree.NewDString(`bar`), tree.DNull,
+	}
+	row := encodeRow{updated: hlc.Timestamp{WallTime: 1}, tableDesc: tableDesc}
+	for _, d := range datums {
+		row.datums = append(row.datums, rowenc.EncDatum{Datum: d})
+	}
+
+	e, err := makeJSONEncoder(map[string]string{
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeConnect),
+	}, targets)
+	require.NoError(t, err)
+
+	key, err := e.EncodeKey(ctx, row)
+	require.NoError(t, err)
+	name, keyFields := decodeConnectMessage(t, key)
+	require.Equal(t, `foo.Key`, name)
+	require.Equal(t, map[string]interface{}{`a`: int64(1)}, keyFields)
+
+	value, err := e.EncodeValue(ctx, row)
+	require.NoError(t, err)
+	name, valueFields := decodeConnectMessage(t, value)
+	require.Equal(t, `foo.Value`, name)
+	require.Equal(t, map[string]interface{}{
+		`a`:  int64(1),
+		`i2`: int64(2),
+		`i4`: int64(4),
+		`f4`: 1.5,
+		`f8`: 2.25,
+		`b`:  true,
+		`bt`: []byte(`abc`),
+		`d`:  time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC),
+		`ts`: ts,
+		`tz`: ts,
+		`dc`: `1.25`,
+		`s`:  `bar`,
+		`n`:  nil,
+	}, valueFields)
+
+	// Deletes are emitted as tombstones.
+	row.deleted = true
+	value, err = e.EncodeValue(ctx, row)
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	for _, opt := range []string{changefeedbase.OptUpdatedTimestamps, changefeedbase.OptMVCCTimestamps} {
+		_, err := makeJSONEncoder(map[string]string{
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeConnect),
+			opt:                        ``,
+		}, targets)
+		require.EqualError(t, err, opt+` is not supported with envelope=connect`)
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// This file contains the client of the native protocol of Apache Cassandra
+// used by the cassandra sink. It only implements what the sink needs, over
+// version 4 of the protocol: authenticating with the PasswordAuthenticator,
+// preparing statements, running queries whose results hold text and integer
+// columns, and sending batches of prepared statements, which are pipelined on
+// a single connection. See
+// https://github.com/apache/cassandra/blob/trunk/doc/native_protocol_v4.spec.
+
+const (
+	cqlProtocolVersion = 0x04
+	// cqlResponseFlag is set on the version of response frames.
+	cqlResponseFlag = 0x80
+	cqlHeaderSize   = 9
+	// cqlMaxFrameSize is the largest frame accepted by Cassandra.
+	cqlMaxFrameSize = 256 << 20
+	// cqlMaxInflight bounds the number of requests which are pipelined
+	// before their responses are read.
+	cqlMaxInflight = 128
+)
+
+// cqlOpcode is the opcode of a frame.
+type cqlOpcode byte
+
+const (
+	cqlOpError        cqlOpcode = 0x00
+	cqlOpStartup      cqlOpcode = 0x01
+	cqlOpReady        cqlOpcode = 0x02
+	cqlOpAuthenticate cqlOpcode = 0x03
+	cqlOpQuery        cqlOpcode = 0x07
+	cqlOpResult       cqlOpcode = 0x08
+	cqlOpPrepare      cqlOpcode = 0x09
+	cqlOpBatch        cqlOpcode = 0x0D
+	cqlOpAuthResponse cqlOpcode = 0x0F
+	cqlOpAuthSuccess  cqlOpcode = 0x10
+)
+
+// The flags of frame headers.
+const (
+	cqlFlagCompression   = 0x01
+	cqlFlagTracing       = 0x02
+	cqlFlagCustomPayload = 0x04
+	cqlFlagWarning       = 0x08
+)
+
+// The kinds of RESULT responses.
+const (
+	cqlResultRows     = 0x0002
+	cqlResultPrepared = 0x0004
+)
+
+// The flags of the metadata of rows.
+const (
+	cqlMetadataGlobalTableSpec = 0x0001
+	cqlMetadataHasMorePages    = 0x0002
+	cqlMetadataNoMetadata      = 0x0004
+)
+
+// cqlQueryFlagValues is the flag of QUERY requests which bind values.
+const cqlQueryFlagValues = 0x01
+
+// cqlBatchUnlogged is the type of batches which are applied without the
+// batch log. Cassandra applies the statements of a batch for a single
+// partition atomically and in isolation regardless of the type.
+const cqlBatchUnlogged = 1
+
+// cqlBatchKindPrepared is the kind of statements of batches which execute a
+// prepared statement.
+const cqlBatchKindPrepared = 1
+
+// cqlConsistency is the consistency level of requests.
+type cqlConsistency uint16
+
+// cqlConsistencies maps the names of consistency levels to their values.
+var cqlConsistencies = map[string]cqlConsistency{
+	`ANY`:          0x0000,
+	`ONE`:          0x0001,
+	`TWO`:          0x0002,
+	`THREE`:        0x0003,
+	`QUORUM`:       0x0004,
+	`ALL`:          0x0005,
+	`LOCAL_QUORUM`: 0x0006,
+	`EACH_QUORUM`:  0x0007,
+	`LOCAL_ONE`:    0x000A,
+}
+
+// cqlError is an ERROR response returned by Cassandra.
+type cqlError struct {
+	code    int32
+	message string
+}
+
+func (e *cqlError) Error() string {
+	return fmt.Sprintf(`cassandra error 0x%04x: %s`, e.code, e.message)
+}
+
+// cqlStatement is a prepared statement of a batch, with its bound values.
+type cqlStatement struct {
+	id     []byte
+	values [][]byte
+}
+
+// cqlConn is a connection to a Cassandra node. It is not concurrency-safe.
+type cqlConn struct {
+	conn net.Conn
+	w    *bufio.Writer
+	r    *bufio.Reader
+	// nextStream is the stream of the next request, and inflight the number
+	// of requests sent whose response hasn't been read.
+	nextStream int16
+	inflight   int
+	header     [cqlHeaderSize]byte
+	// timeout bounds the time spent writing or reading each frame.
+	timeout time.Duration
+}
+
+func newCQLConn(conn net.Conn, timeout time.Duration) *cqlConn {
+	return &cqlConn{
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		r:       bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// startup initializes the connection, authenticating with the given
+// credentials if the node requires it.
+func (c *cqlConn) startup(username, password string) error {
+	body := appendCQLStringMap(nil, map[string]string{`CQL_VERSION`: `3.0.0`})
+	op, res, err := c.roundTrip(cqlOpStartup, body)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case cqlOpReady:
+		return nil
+	case cqlOpAuthenticate:
+	default:
+		return errors.Errorf(`cassandra: unexpected response 0x%02x to STARTUP`, op)
+	}
+	authenticator := newCQLReader(res).readString()
+	if username == `` {
+		return errors.Errorf(`cassandra requires authentication with %s`, authenticator)
+	}
+	// The PasswordAuthenticator expects a SASL PLAIN token.
+	token := make([]byte, 0, 2+len(username)+len(password))
+	token = append(append(append(append(token, 0), username...), 0), password...)
+	if op, _, err = c.roundTrip(cqlOpAuthResponse, appendCQLBytes(nil, token)); err != nil {
+		return errors.Wrap(err, `authenticating to cassandra`)
+	}
+	if op != cqlOpAuthSuccess {
+		return errors.Errorf(`cassandra: unexpected response 0x%02x to AUTH_RESPONSE`, op)
+	}
+	return nil
+}
+
+// query runs a statement with the given text values bound, returning the raw
+// values of the columns of the rows of its result.
+func (c *cqlConn) query(stmt string, values ...string) ([][][]byte, error) {
+	body := appendCQLLongString(nil, stmt)
+	body = appendCQLShort(body, uint16(cqlConsistencies[`ONE`]))
+	body = append(body, cqlQueryFlagValues)
+	body = appendCQLShort(body, uint16(len(values)))
+	for _, v := range values {
+		body = appendCQLBytes(body, []byte(v))
+	}
+	op, res, err := c.roundTrip(cqlOpQuery, body)
+	if err != nil {
+		return nil, err
+	}
+	if op != cqlOpResult {
+		return nil, errors.Errorf(`cassandra: unexpected response 0x%02x to QUERY`, op)
+	}
+	r := newCQLReader(res)
+	if kind := r.readInt(); kind != cqlResultRows {
+		return nil, errors.Errorf(`cassandra: unexpected result kind 0x%04x to QUERY`, kind)
+	}
+	flags, numColumns := r.readInt(), int(r.readInt())
+	if flags&cqlMetadataHasMorePages != 0 {
+		return nil, errors.Errorf(`cassandra: unexpected paged result`)
+	}
+	if flags&cqlMetadataNoMetadata == 0 {
+		globalSpec := flags&cqlMetadataGlobalTableSpec != 0
+		if globalSpec {
+			r.readString()
+			r.readString()
+		}
+		for i := 0; i < numColumns; i++ {
+			if !globalSpec {
+				r.readString()
+				r.readString()
+			}
+			r.readString()
+			r.skipOption()
+		}
+	}
+	numRows := r.readInt()
+	if r.err != nil || numRows < 0 {
+		return nil, errors.New(`cassandra: malformed rows result`)
+	}
+	rows := make([][][]byte, 0, numRows)
+	for i := int32(0); i < numRows && r.err == nil; i++ {
+		row := make([][]byte, numColumns)
+		for j := range row {
+			row[j] = r.readBytes()
+		}
+		rows = append(rows, row)
+	}
+	return rows, r.err
+}
+
+// prepare prepares a statement, returning its ID.
+func (c *cqlConn) prepare(stmt string) ([]byte, error) {
+	op, res, err := c.roundTrip(cqlOpPrepare, appendCQLLongString(nil, stmt))
+	if err != nil {
+		return nil, err
+	}
+	if op != cqlOpResult {
+		return nil, errors.Errorf(`cassandra: unexpected response 0x%02x to PREPARE`, op)
+	}
+	r := newCQLReader(res)
+	if kind := r.readInt(); kind != cqlResultPrepared {
+		return nil, errors.Errorf(`cassandra: unexpected result kind 0x%04x to PREPARE`, kind)
+	}
+	// The metadata of the statement's bind markers and result aren't needed.
+	id := r.readShortBytes()
+	return append([]byte(nil), id...), r.err
+}
+
+// sendBatch pipelines an unlogged batch of the given statements. Its
+// response is read by a later call to awaitInflight.
+func (c *cqlConn) sendBatch(stmts []cqlStatement, consistency cqlConsistency) error {
+	body := append([]byte(nil), cqlBatchUnlogged)
+	body = appendCQLShort(body, uint16(len(stmts)))
+	for _, stmt := range stmts {
+		body = append(body, cqlBatchKindPrepared)
+		body = appendCQLShortBytes(body, stmt.id)
+		body = appendCQLShort(body, uint16(len(stmt.values)))
+		for _, v := range stmt.values {
+			body = appendCQLBytes(body, v)
+		}
+	}
+	body = appendCQLShort(body, uint16(consistency))
+	body = append(body, 0 /* flags */)
+	if c.inflight >= cqlMaxInflight {
+		if err := c.awaitInflight(); err != nil {
+			return err
+		}
+	}
+	return c.writeFrame(cqlOpBatch, body)
+}
+
+// awaitInflight reads the responses of all the pipelined requests, returning
+// the first error.
+func (c *cqlConn) awaitInflight() error {
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for c.inflight > 0 {
+		_, _, err := c.readFrame()
+		if err != nil {
+			if !errors.HasType(err, (*cqlError)(nil)) {
+				// The connection is in an unknown state; the changefeed must
+				// reconnect before emitting again.
+				c.inflight = 0
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// roundTrip sends a request and waits for its response. It must not be called
+// while requests are pipelined.
+func (c *cqlConn) roundTrip(op cqlOpcode, body []byte) (cqlOpcode, []byte, error) {
+	if c.inflight > 0 {
+		return 0, nil, errors.AssertionFailedf(`%d cassandra requests are in flight`, c.inflight)
+	}
+	if err := c.writeFrame(op, body); err != nil {
+		return 0, nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, nil, err
+	}
+	return c.readFrame()
+}
+
+func (c *cqlConn) writeFrame(op cqlOpcode, body []byte) error {
+	if len(body) > cqlMaxFrameSize {
+		return errors.Errorf(`cassandra request of %d bytes exceeds the maximum of %d`,
+			len(body), cqlMaxFrameSize)
+	}
+	if err := c.conn.SetWriteDeadline(timeutil.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	h := c.header[:]
+	h[0], h[1] = cqlProtocolVersion, 0
+	binary.BigEndian.PutUint16(h[2:], uint16(c.nextStream))
+	h[4] = byte(op)
+	binary.BigEndian.PutUint32(h[5:], uint32(len(body)))
+	c.nextStream = (c.nextStream + 1) & 0x7fff
+	c.inflight++
+	if _, err := c.w.Write(h); err != nil {
+		return err
+	}
+	_, err := c.w.Write(body)
+	return err
+}
+
+// readFrame reads the next response, returning ERROR responses as a
+// cqlError.
+func (c *cqlConn) readFrame() (cqlOpcode, []byte, error) {
+	if err := c.conn.SetReadDeadline(timeutil.Now().Add(c.timeout)); err != nil {
+		return 0, nil, err
+	}
+	h := c.header[:]
+	if _, err := io.ReadFull(c.r, h); err != nil {
+		return 0, nil, err
+	}
+	if h[0] != cqlProtocolVersion|cqlResponseFlag {
+		return 0, nil, errors.Errorf(`cassandra: unsupported protocol version 0x%02x`, h[0])
+	}
+	flags, op := h[1], cqlOpcode(h[4])
+	length := binary.BigEndian.Uint32(h[5:])
+	if length > cqlMaxFrameSize {
+		return 0, nil, errors.Errorf(`cassandra: response of %d bytes is too large`, length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	c.inflight--
+	if flags&(cqlFlagCompression|cqlFlagTracing|cqlFlagCustomPayload) != 0 {
+		// None of these are requested.
+		return 0, nil, errors.Errorf(`cassandra: unexpected response flags 0x%02x`, flags)
+	}
+	r := newCQLReader(body)
+	if flags&cqlFlagWarning != 0 {
+		// Warnings, e.g. about the size of batches, are not surfaced.
+		for i := r.readShort(); i > 0; i-- {
+			r.readString()
+		}
+	}
+	if op == cqlOpError {
+		code := r.readInt()
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		return 0, nil, &cqlError{code: code, message: r.readString()}
+	}
+	return op, r.b, r.err
+}
+
+// Close closes the connection.
+func (c *cqlConn) Close() error {
+	return c.conn.Close()
+}
+
+// cqlReader reads the notations of the protocol from a frame body. Errors are
+// sticky: once the body is exhausted, the zero value is returned for every
+// read and err is set.
+type cqlReader struct {
+	b   []byte
+	err error
+}
+
+func newCQLReader(b []byte) *cqlReader {
+	return &cqlReader{b: b}
+}
+
+func (r *cqlReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errors.New(`cassandra: truncated response`)
+		return nil
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *cqlReader) readShort() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *cqlReader) readInt() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *cqlReader) readString() string {
+	return string(r.next(int(r.readShort())))
+}
+
+// readBytes reads a [bytes], returning nil for a null value.
+func (r *cqlReader) readBytes() []byte {
+	n := r.readInt()
+	if n < 0 {
+		return nil
+	}
+	return r.next(int(n))
+}
+
+func (r *cqlReader) readShortBytes() []byte {
+	return r.next(int(r.readShort()))
+}
+
+// skipOption skips the [option] describing the type of a column.
+func (r *cqlReader) skipOption() {
+	switch id := r.readShort(); id {
+	case 0x0000: // custom
+		r.readString()
+	case 0x0020, 0x0022: // list, set
+		r.skipOption()
+	case 0x0021: // map
+		r.skipOption()
+		r.skipOption()
+	case 0x0030: // user defined type
+		r.readString()
+		r.readString()
+		for i := r.readShort(); i > 0 && r.err == nil; i-- {
+			r.readString()
+			r.skipOption()
+		}
+	case 0x0031: // tuple
+		for i := r.readShort(); i > 0 && r.err == nil; i-- {
+			r.skipOption()
+		}
+	}
+}
+
+func appendCQLShort(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendCQLInt(b []byte, v int32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendCQLString(b []byte, s string) []byte {
+	return append(appendCQLShort(b, uint16(len(s))), s...)
+}
+
+func appendCQLLongString(b []byte, s string) []byte {
+	return append(appendCQLInt(b, int32(len(s))), s...)
+}
+
+// appendCQLBytes appends a [bytes], which is null if v is nil.
+func appendCQLBytes(b []byte, v []byte) []byte {
+	if v == nil {
+		return appendCQLInt(b, -1)
+	}
+	return append(appendCQLInt(b, int32(len(v))), v...)
+}
+
+func appendCQLShortBytes(b []byte, v []byte) []byte {
+	return append(appendCQLShort(b, uint16(len(v))), v...)
+}
+
+func appendCQLStringMap(b []byte, m map[string]string) []byte {
+	b = appendCQLShort(b, uint16(len(m)))
+	for k, v := range m {
+		b = appendCQLString(appendCQLString(b, k), v)
+	}
+	return b
+}
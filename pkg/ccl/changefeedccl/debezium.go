@@ -0,0 +1,188 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	gojson "encoding/json"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The operations of Debezium change events.
+const (
+	debeziumOpCreate = `c`
+	debeziumOpUpdate = `u`
+	debeziumOpDelete = `d`
+	debeziumOpRead   = `r`
+)
+
+// debeziumConnector is the name of the connector in the source of Debezium
+// change events.
+const debeziumConnector = `cockroachdb`
+
+// debeziumSource is the source of a Debezium change event, with the fields
+// of Debezium's Postgres connector which have a CockroachDB equivalent.
+type debeziumSource struct {
+	Connector string `json:"connector"`
+	// TsMs is the commit time of the change, in milliseconds since the epoch.
+	TsMs     int64  `json:"ts_ms"`
+	Snapshot string `json:"snapshot"`
+	DB       string `json:"db"`
+	Schema   string `json:"schema"`
+	Table    string `json:"table"`
+}
+
+// debeziumEnvelope is a change event in the envelope of Debezium's Postgres
+// connector, as serialized by Connect's JsonConverter with schemas disabled.
+type debeziumEnvelope struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source debeziumSource         `json:"source"`
+	Op     string                 `json:"op"`
+	// TsMs is the time at which the change was encoded, in milliseconds
+	// since the epoch.
+	TsMs int64 `json:"ts_ms"`
+}
+
+// debeziumOp returns the operation of the Debezium change event of row. Rows
+// scanned by an initial scan or a backfill are snapshot reads.
+func debeziumOp(row encodeRow) string {
+	switch {
+	case row.deleted:
+		return debeziumOpDelete
+	case row.backfill:
+		return debeziumOpRead
+	case row.prevDeleted:
+		return debeziumOpCreate
+	default:
+		return debeziumOpUpdate
+	}
+}
+
+// debeziumSourceFor returns the source of the change events of the table of
+// row, which is named by its fully qualified name at statement time.
+func (e *jsonEncoder) debeziumSourceFor(row encodeRow) (debeziumSource, error) {
+	if s, ok := e.debeziumSources[row.tableDesc.GetID()]; ok {
+		return s, nil
+	}
+	target, ok := e.targets[row.tableDesc.GetID()]
+	if !ok {
+		return debeziumSource{}, errors.Errorf(
+			`table with name %s and descriptor ID %d not found in changefeed target list`,
+			row.tableDesc.GetName(), row.tableDesc.GetID())
+	}
+	tn, err := parser.ParseQualifiedTableName(target.StatementTimeName)
+	if err != nil {
+		return debeziumSource{}, err
+	}
+	s := debeziumSource{
+		Connector: debeziumConnector,
+		DB:        tn.Catalog(),
+		Schema:    tn.Schema(),
+		Table:     tn.Table(),
+	}
+	if e.debeziumSources == nil {
+		e.debeziumSources = make(map[descpb.ID]debeziumSource)
+	}
+	e.debeziumSources[row.tableDesc.GetID()] = s
+	return s, nil
+}
+
+// encodeDebeziumKey encodes the primary key of row as an object mapping the
+// primary key columns to their values, as Debezium does.
+func (e *jsonEncoder) encodeDebeziumKey(row encodeRow) ([]byte, error) {
+	colIdxByID := catalog.ColumnIDToOrdinalMap(row.tableDesc.PublicColumns())
+	primaryIndex := row.tableDesc.GetPrimaryIndex()
+	key := make(map[string]interface{}, primaryIndex.NumKeyColumns())
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		colID := primaryIndex.GetKeyColumnID(i)
+		idx, ok := colIdxByID.Get(colID)
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		v, err := e.debeziumColumnValue(row.tableDesc.PublicColumns()[idx], row.datums[idx])
+		if err != nil {
+			return nil, err
+		}
+		key[primaryIndex.GetKeyColumnName(i)] = v
+	}
+	return gojson.Marshal(key)
+}
+
+// encodeDebeziumValue encodes row as a Debezium change event. Unlike
+// Debezium, deletes are not followed by a tombstone.
+func (e *jsonEncoder) encodeDebeziumValue(row encodeRow) ([]byte, error) {
+	source, err := e.debeziumSourceFor(row)
+	if err != nil {
+		return nil, err
+	}
+	source.TsMs = row.updated.GoTime().UnixNano() / int64(time.Millisecond)
+	source.Snapshot = `false`
+	if row.backfill {
+		source.Snapshot = `true`
+	}
+	envelope := debeziumEnvelope{
+		Source: source,
+		Op:     debeziumOp(row),
+		TsMs:   timeutil.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if !row.deleted {
+		if envelope.After, err = e.debeziumRow(row.tableDesc, row.datums); err != nil {
+			return nil, err
+		}
+	}
+	if row.prevDatums != nil && !row.prevDeleted {
+		if envelope.Before, err = e.debeziumRow(row.prevTableDesc, row.prevDatums); err != nil {
+			return nil, err
+		}
+	}
+	return gojson.Marshal(envelope)
+}
+
+func (e *jsonEncoder) debeziumRow(
+	desc catalog.TableDescriptor, datums rowenc.EncDatumRow,
+) (map[string]interface{}, error) {
+	columns := desc.PublicColumns()
+	fields := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		if col.IsVirtual() && e.virtualColumnVisibility == string(changefeedbase.OptVirtualColumnsOmitted) {
+			continue
+		}
+		v, err := e.debeziumColumnValue(col, datums[i])
+		if err != nil {
+			return nil, err
+		}
+		fields[col.GetName()] = v
+	}
+	return fields, nil
+}
+
+// debeziumColumnValue converts a datum to the value of its column in a
+// Debezium change event, which is its JSON representation.
+func (e *jsonEncoder) debeziumColumnValue(
+	col catalog.Column, datum rowenc.EncDatum,
+) (interface{}, error) {
+	if err := datum.EnsureDecoded(col.GetType(), &e.alloc); err != nil {
+		return nil, err
+	}
+	j, err := e.datumAsJSON(datum.Datum)
+	if err != nil {
+		return nil, err
+	}
+	// The JSON is embedded in the envelope as is.
+	return gojson.RawMessage(j.String()), nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebeziumEnvelope(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: `d.public.foo`},
+	}
+	e, err := makeJSONEncoder(map[string]string{
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeDebezium),
+	}, targets)
+	require.NoError(t, err)
+
+	makeRow := func(b string) rowenc.EncDatumRow {
+		return rowenc.EncDatumRow{
+			{Datum: tree.NewDInt(1)}, {Datum: tree.NewDString(b)},
+		}
+	}
+	updated := hlc.Timestamp{WallTime: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC).UnixNano()}
+
+	// The fixtures are the events Debezium's Postgres connector emits for the
+	// same changes, less their fields without a CockroachDB equivalent, and
+	// with the processing time zeroed.
+	for _, tc := range []struct {
+		name     string
+		row      encodeRow
+		expected string
+	}{
+		{
+			name: `read`,
+			row: encodeRow{
+				datums: makeRow(`x`), prevDeleted: true, backfill: true,
+			},
+			expected: `{"before":null,"after":{"a":1,"b":"x"},"source":{"connector":"cockroachdb",` +
+				`"ts_ms":1646370367000,"snapshot":"true","db":"d","schema":"public","table":"foo"},` +
+				`"op":"r","ts_ms":0}`,
+		},
+		{
+			name: `create`,
+			row: encodeRow{
+				datums: makeRow(`x`), prevDeleted: true,
+			},
+			expected: `{"before":null,"after":{"a":1,"b":"x"},"source":{"connector":"cockroachdb",` +
+				`"ts_ms":1646370367000,"snapshot":"false","db":"d","schema":"public","table":"foo"},` +
+				`"op":"c","ts_ms":0}`,
+		},
+		{
+			name: `update`,
+			row: encodeRow{
+				datums: makeRow(`y`), prevDatums: makeRow(`x`),
+			},
+			expected: `{"before":{"a":1,"b":"x"},"after":{"a":1,"b":"y"},"source":{"connector":"cockroachdb",` +
+				`"ts_ms":1646370367000,"snapshot":"false","db":"d","schema":"public","table":"foo"},` +
+				`"op":"u","ts_ms":0}`,
+		},
+		{
+			name: `delete`,
+			row: encodeRow{
+				datums: makeRow(`y`), deleted: true, prevDatums: makeRow(`y`),
+			},
+			expected: `{"before":{"a":1,"b":"y"},"after":null,"source":{"connector":"cockroachdb",` +
+				`"ts_ms":1646370367000,"snapshot":"false","db":"d","schema":"public","table":"foo"},` +
+				`"op":"d","ts_ms":0}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			row := tc.row
+			row.updated, row.tableDesc, row.prevTableDesc = updated, tableDesc, tableDesc
+
+			key, err := e.EncodeKey(ctx, row)
+			require.NoError(t, err)
+			require.JSONEq(t, `{"a":1}`, string(key))
+
+			value, err := e.EncodeValue(ctx, row)
+			require.NoError(t, err)
+			// Decode the event strictly, as a Debezium consumer would, and
+			// encode it again to compare it to the fixture.
+			var event debeziumEnvelope
+			dec := gojson.NewDecoder(bytes.NewReader(value))
+			dec.DisallowUnknownFields()
+			require.NoError(t, dec.Decode(&event), string(value))
+			require.Greater(t, event.TsMs, int64(0))
+			event.TsMs = 0
+			roundTripped, err := gojson.Marshal(event)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.expected, string(roundTripped))
+		})
+	}
+
+	for _, opt := range []string{changefeedbase.OptUpdatedTimestamps, changefeedbase.OptDiff} {
+		_, err := makeJSONEncoder(map[string]string{
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeDebezium),
+			opt:                        ``,
+		}, targets)
+		require.Error(t, err, opt)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/errors"
+)
+
+// deletedFlagField is the field of the values of envelope=deleted_flag which
+// holds whether the row was deleted.
+const deletedFlagField = `deleted`
+
+// deletedFlagEntries returns the value of a row in envelope=deleted_flag,
+// given the columns of its new value, which are nil for deletes.
+//
+// Every value holds all the columns of the row and the deleted field, so that
+// sinks writing rows into tables, which can't express a key without a value,
+// can apply deletes like any other change. The columns of deletes are those
+// of their primary key, with the values of the deleted row, and the others,
+// which are null.
+func (e *jsonEncoder) deletedFlagEntries(
+	row encodeRow, after map[string]interface{},
+) (map[string]interface{}, error) {
+	if _, err := row.tableDesc.FindColumnWithName(deletedFlagField); err == nil {
+		return nil, errors.Errorf(`column %s of table %s conflicts with the %s field of %s=%s`,
+			deletedFlagField, row.tableDesc.GetName(), deletedFlagField,
+			changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeDeletedFlag)
+	}
+	if !row.deleted {
+		after[deletedFlagField] = false
+		return after, nil
+	}
+
+	keyColumns := row.tableDesc.GetPrimaryIndex().CollectKeyColumnIDs()
+	entries := make(map[string]interface{})
+	for i, col := range row.tableDesc.PublicColumns() {
+		if col.IsVirtual() && e.virtualColumnVisibility == string(changefeedbase.OptVirtualColumnsOmitted) {
+			continue
+		}
+		if !keyColumns.Contains(col.GetID()) {
+			entries[col.GetName()] = nil
+			continue
+		}
+		datum := row.datums[i]
+		if err := datum.EnsureDecoded(col.GetType(), &e.alloc); err != nil {
+			return nil, err
+		}
+		var err error
+		if entries[col.GetName()], err = e.datumAsJSON(datum.Datum); err != nil {
+			return nil, err
+		}
+	}
+	entries[deletedFlagField] = true
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// emitWindow is the daily window of the emit_window option, e.g.
+// `22:00-06:00 America/New_York`, outside of which the changefeed holds back
+// the changes it reads rather than emit them.
+//
+// The rangefeeds and the frontiers of the change aggregators keep advancing
+// outside of the window, but the rows committed after the window last closed
+// are held back by the aggregators' laggingSink, and their resolved spans are
+// held back at the close, so that the changefeed's high-water, and with it its
+// resolved timestamps and protected timestamp, stays at the close: the rows
+// held back when the changefeed restarts are read again by its catch-up scans.
+// Once the window opens, the held rows are emitted as their resolved spans
+// come in. Rows committed while the window was open are emitted as soon as
+// they're resolved, which may be shortly after it closes, as are, after a
+// restart, the rows of earlier windows which weren't resolved yet, and the
+// rows up to schema change boundaries, past which the changefeed can't make
+// progress until they're resolved.
+//
+// The rows held by each aggregator are charged to its memory monitor, which
+// is bounded by the changefeed.memory.per_changefeed_limit setting. If they
+// exhaust it while the window is closed, the aggregator drops them and fails
+// with a retryable error, rather than stall for hours until the window
+// opens: the changefeed restarts from its high-water, at the close, and reads
+// them again. A changefeed whose changes outside of the window exceed the
+// limit keeps restarting until the window opens.
+type emitWindow struct {
+	startHour, startMinute int
+	endHour, endMinute     int
+	loc                    *time.Location
+}
+
+// parseEmitWindow parses the value of the emit_window option, the start and
+// end times of day of the window, in the 24-hour clock, optionally followed by
+// the name of the time zone they're in, which defaults to UTC. A window whose
+// end precedes its start spans midnight.
+func parseEmitWindow(s string) (*emitWindow, error) {
+	invalid := func() error {
+		return errors.Errorf(`%s must be a window of the form '22:00-06:00 America/New_York': %q`,
+			changefeedbase.OptEmitWindow, s)
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, invalid()
+	}
+	bounds := strings.Split(fields[0], `-`)
+	if len(bounds) != 2 {
+		return nil, invalid()
+	}
+	start, err := time.Parse(`15:04`, bounds[0])
+	if err != nil {
+		return nil, invalid()
+	}
+	end, err := time.Parse(`15:04`, bounds[1])
+	if err != nil {
+		return nil, invalid()
+	}
+	if start.Equal(end) {
+		return nil, errors.Errorf(`%s must not be empty: %q`, changefeedbase.OptEmitWindow, s)
+	}
+	w := &emitWindow{
+		startHour: start.Hour(), startMinute: start.Minute(),
+		endHour: end.Hour(), endMinute: end.Minute(),
+		loc: time.UTC,
+	}
+	if len(fields) == 2 {
+		if w.loc, err = timeutil.LoadLocation(fields[1]); err != nil {
+			return nil, errors.Wrapf(err, `parsing %s`, changefeedbase.OptEmitWindow)
+		}
+	}
+	return w, nil
+}
+
+// at returns the given time of day on the day of t, in the window's time
+// zone, days days later.
+func (w *emitWindow) at(t time.Time, hour, minute, days int) time.Time {
+	y, m, d := t.In(w.loc).Date()
+	return time.Date(y, m, d+days, hour, minute, 0, 0, w.loc)
+}
+
+// contains returns whether t is within the window.
+func (w *emitWindow) contains(t time.Time) bool {
+	start := w.at(t, w.startHour, w.startMinute, 0)
+	end := w.at(t, w.endHour, w.endMinute, 0)
+	if start.Before(end) {
+		return !t.Before(start) && t.Before(end)
+	}
+	return !t.Before(start) || t.Before(end)
+}
+
+// lastClose returns the last time at or before t at which the window closed.
+func (w *emitWindow) lastClose(t time.Time) time.Time {
+	end := w.at(t, w.endHour, w.endMinute, 0)
+	if end.After(t) {
+		end = w.at(t, w.endHour, w.endMinute, -1)
+	}
+	return end
+}
+
+// nextOpen returns the first time after t at which the window opens.
+func (w *emitWindow) nextOpen(t time.Time) time.Time {
+	start := w.at(t, w.startHour, w.startMinute, 0)
+	if !start.After(t) {
+		start = w.at(t, w.startHour, w.startMinute, 1)
+	}
+	return start
+}
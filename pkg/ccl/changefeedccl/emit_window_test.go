@@ -0,0 +1,61 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitWindow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, s := range []string{``, `22:00`, `22:00-`, `25:00-06:00`, `22:00-06:00 UTC extra`} {
+		_, err := parseEmitWindow(s)
+		require.Regexp(t, `emit_window must be a window of the form`, err, s)
+	}
+	_, err := parseEmitWindow(`06:00-06:00`)
+	require.Regexp(t, `emit_window must not be empty`, err)
+	_, err = parseEmitWindow(`22:00-06:00 Nowhere/Nope`)
+	require.Regexp(t, `parsing emit_window`, err)
+
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return ts
+	}
+
+	t.Run("daytime", func(t *testing.T) {
+		w, err := parseEmitWindow(`09:30-17:00`)
+		require.NoError(t, err)
+		require.False(t, w.contains(at(`2022-03-01T09:29:00Z`)))
+		require.True(t, w.contains(at(`2022-03-01T09:30:00Z`)))
+		require.True(t, w.contains(at(`2022-03-01T16:59:00Z`)))
+		require.False(t, w.contains(at(`2022-03-01T17:00:00Z`)))
+		require.Equal(t, at(`2022-02-28T17:00:00Z`), w.lastClose(at(`2022-03-01T09:00:00Z`)))
+		require.Equal(t, at(`2022-03-01T17:00:00Z`), w.lastClose(at(`2022-03-01T18:00:00Z`)))
+		require.Equal(t, at(`2022-03-01T09:30:00Z`), w.nextOpen(at(`2022-03-01T09:00:00Z`)))
+		require.Equal(t, at(`2022-03-02T09:30:00Z`), w.nextOpen(at(`2022-03-01T18:00:00Z`)))
+	})
+
+	t.Run("overnight", func(t *testing.T) {
+		w, err := parseEmitWindow(`22:00-06:00 America/New_York`)
+		require.NoError(t, err)
+		// 22:00 and 06:00 in New York are 03:00 and 11:00 UTC.
+		require.True(t, w.contains(at(`2022-03-01T03:00:00Z`)))
+		require.True(t, w.contains(at(`2022-03-01T10:59:00Z`)))
+		require.False(t, w.contains(at(`2022-03-01T11:00:00Z`)))
+		require.False(t, w.contains(at(`2022-03-01T02:59:00Z`)))
+		require.Equal(t, at(`2022-03-01T11:00:00Z`), w.lastClose(at(`2022-03-01T20:00:00Z`)))
+		require.Equal(t, at(`2022-03-02T03:00:00Z`), w.nextOpen(at(`2022-03-01T20:00:00Z`)))
+	})
+}
@@ -14,19 +14,27 @@ import (
 	"encoding/binary"
 	gojson "encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util/cache"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -61,6 +69,35 @@ type encodeRow struct {
 	// prevTableDesc is a TableDescriptor for the table containing `prevDatums`.
 	// It's valid for interpreting the row at `updated.Prev()`.
 	prevTableDesc catalog.TableDescriptor
+	// snapshot is true if the row was emitted as part of a resync requested
+	// by ALTER CHANGEFEED ... RESYNC rather than as an incremental change.
+	snapshot bool
+	// backfill is true if the row was scanned by an initial scan, a backfill
+	// or a resync rather than emitted for an incremental change.
+	backfill bool
+	// ttlExpired is true if row is a deletion of a row which had expired
+	// under the row-level TTL of its table, which was most likely deleted by
+	// the TTL job. It is only set with the ttl_deletes option.
+	ttlExpired bool
+	// rangeID and leaseholderNodeID identify the range containing the row and
+	// the node holding its lease when the row was emitted. They are only set
+	// with the range_info option, and leaseholderNodeID is zero if the
+	// leaseholder was unknown.
+	rangeID           roachpb.RangeID
+	leaseholderNodeID roachpb.NodeID
+	// emitterInstanceID is the SQL instance of the change aggregator which
+	// emitted the row. It is only set with the provenance option.
+	emitterInstanceID base.SQLInstanceID
+	// epoch is the epoch of the run of the changefeed which emitted the row.
+	// It is only set with the changefeed_epoch option.
+	epoch int64
+	// idempotencyToken identifies the version of the row. It is only set with
+	// the idempotency_token option. See makeIdempotencyToken.
+	idempotencyToken string
+	// valueSize is the size in bytes of the KV value of the row or, for a
+	// delete, of the value it deleted, or -1 if the deleted value wasn't
+	// fetched.
+	valueSize int
 }
 
 // Encoder turns a row into a serialized changefeed key, value, or resolved
@@ -82,12 +119,40 @@ type Encoder interface {
 	EncodeResolvedTimestamp(context.Context, string, hlc.Timestamp) ([]byte, error)
 }
 
+// getEncoder returns the Encoder for a changefeed. If some of its targets
+// override the changefeed's options, rows of those targets are encoded with
+// their own encoder. See perTargetEncoder.
 func getEncoder(opts map[string]string, targets jobspb.ChangefeedTargets) (Encoder, error) {
+	e, err := getFeedEncoder(opts, targets)
+	if err != nil || !changefeedbase.HasTargetOptions(targets) {
+		return e, err
+	}
+	perTarget := &perTargetEncoder{Encoder: e, targets: make(map[descpb.ID]Encoder)}
+	for id, target := range targets {
+		if len(target.Opts) == 0 {
+			continue
+		}
+		targetEncoder, err := getFeedEncoder(changefeedbase.OptionsForTarget(opts, target), targets)
+		if err != nil {
+			return nil, errors.Wrapf(err, `options for table %s`, target.StatementTimeName)
+		}
+		perTarget.targets[id] = targetEncoder
+	}
+	return perTarget, nil
+}
+
+// getFeedEncoder returns the Encoder for the given changefeed options,
+// ignoring the options overridden by targets.
+func getFeedEncoder(opts map[string]string, targets jobspb.ChangefeedTargets) (Encoder, error) {
 	switch changefeedbase.FormatType(opts[changefeedbase.OptFormat]) {
 	case ``, changefeedbase.OptFormatJSON:
 		return makeJSONEncoder(opts, targets)
 	case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
 		return newConfluentAvroEncoder(opts, targets)
+	case changefeedbase.OptFormatORC:
+		return makeORCEncoder(opts)
+	case changefeedbase.OptFormatMsgpack:
+		return makeMsgpackEncoder(opts, targets)
 	case changefeedbase.OptFormatNative:
 		return &nativeEncoder{}, nil
 	default:
@@ -95,17 +160,110 @@ func getEncoder(opts map[string]string, targets jobspb.ChangefeedTargets) (Encod
 	}
 }
 
+// perTargetEncoder encodes the rows of the targets which override the
+// changefeed's options with an encoder for their options, and everything
+// else, including resolved timestamps, with the changefeed's encoder.
+type perTargetEncoder struct {
+	Encoder
+	targets map[descpb.ID]Encoder
+}
+
+var _ Encoder = (*perTargetEncoder)(nil)
+
+func (e *perTargetEncoder) encoderFor(row encodeRow) Encoder {
+	if targetEncoder, ok := e.targets[row.tableDesc.GetID()]; ok {
+		return targetEncoder
+	}
+	return e.Encoder
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *perTargetEncoder) EncodeKey(ctx context.Context, row encodeRow) ([]byte, error) {
+	return e.encoderFor(row).EncodeKey(ctx, row)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *perTargetEncoder) EncodeValue(ctx context.Context, row encodeRow) ([]byte, error) {
+	return e.encoderFor(row).EncodeValue(ctx, row)
+}
+
 // jsonEncoder encodes changefeed entries as JSON. Keys are the primary key
 // columns in a JSON array. Values are a JSON object mapping every column name
 // to its value. Updated timestamps in rows and resolved timestamp payloads are
 // stored in a sub-object under the `__crdb__` key in the top-level JSON object.
 type jsonEncoder struct {
 	updatedField, mvccTimestampField, beforeField, wrapped, keyOnly, keyInValue, topicInValue bool
+	// sparseUpdates, if set, restricts the `after` value of updates to the
+	// primary key columns and the columns which changed.
+	sparseUpdates bool
+	// rangeInfoField, if set, adds the range and leaseholder of each row to
+	// its metadata.
+	rangeInfoField bool
+	// provenanceField, if set, adds where each row was read and emitted from
+	// to its metadata. See encodeProvenance.
+	provenanceField bool
+	// epochField, if set, adds the epoch of the changefeed's run which emitted
+	// each row to its metadata.
+	epochField bool
+	// eventTimeField, if set, adds the MVCC timestamp of each row, in
+	// RFC3339Nano, to its metadata as its event time.
+	eventTimeField bool
+	// valueSizeField, if set, adds the size of the KV value of each row to
+	// its metadata.
+	valueSizeField bool
+	// sourceClusterField, if set, adds the cluster and tenant which emitted
+	// each row and resolved timestamp to its metadata. sourceCluster is set by
+	// the processors; see sourceClusterField.
+	sourceClusterField bool
+	sourceCluster      map[string]interface{}
+	// regionField, if set, adds the region of each row of REGIONAL BY ROW
+	// tables to its metadata. See rowRegion.
+	regionField bool
+	// stmtField, if set, adds the kind of statement which wrote each row to
+	// its metadata. See rowStatementTag.
+	stmtField bool
+	// idempotencyTokenField, if set, adds the idempotency token of each row
+	// to its metadata.
+	idempotencyTokenField bool
+	// deleteFullRow, if set, adds the previous value of each deleted row to
+	// its delete, even without beforeField.
+	deleteFullRow bool
+	// versionField, if set, adds the version of each row to its value. See
+	// rowVersion.
+	versionField bool
+	// resolvedWindow, if set, adds the previously emitted resolved timestamp
+	// to resolved timestamp payloads, and resolvedSpans the spans of the
+	// frontier. See resolvedDetailEncoder.
+	resolvedWindow, resolvedSpans bool
+	// deleteFormat is the representation of deletes.
+	deleteFormat changefeedbase.DeleteFormat
+	// ttlDeletesField, if set, adds whether each delete was a TTL expiration
+	// to its metadata.
+	ttlDeletesField bool
+	// keyObject, if set, encodes keys as objects keyed by column name rather
+	// than arrays, per key_format=object.
+	keyObject bool
+	// floatSpecialValues is the representation of NaN and infinite floats.
+	// See datumAsJSON.
+	floatSpecialValues changefeedbase.FloatSpecialValues
+	// connect, if set, encodes keys and values in the Kafka Connect envelope.
+	// See encodeConnectKey and encodeConnectValue.
+	connect      bool
+	connectCache map[tableIDAndVersion]*connectTableSchemas
+	// debezium, if set, encodes keys and values in the envelope of
+	// Debezium's Postgres connector. See encodeDebeziumKey and
+	// encodeDebeziumValue.
+	debezium        bool
+	debeziumSources map[descpb.ID]debeziumSource
+	// deletedFlag, if set, encodes values with all the columns of their rows
+	// and whether they were deleted. See deletedFlagEntries.
+	deletedFlag bool
 
 	targets                 jobspb.ChangefeedTargets
 	alloc                   tree.DatumAlloc
 	buf                     bytes.Buffer
 	virtualColumnVisibility string
+	timestampFormat         changefeedbase.TimestampFormat
 }
 
 var _ Encoder = &jsonEncoder{}
@@ -118,10 +276,41 @@ func makeJSONEncoder(
 		keyOnly:                 changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeKeyOnly,
 		wrapped:                 changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeWrapped,
 		virtualColumnVisibility: opts[changefeedbase.OptVirtualColumns],
+		timestampFormat:         changefeedbase.TimestampFormat(opts[changefeedbase.OptTimestampFormat]),
 	}
 	_, e.updatedField = opts[changefeedbase.OptUpdatedTimestamps]
 	_, e.mvccTimestampField = opts[changefeedbase.OptMVCCTimestamps]
 	_, e.beforeField = opts[changefeedbase.OptDiff]
+	_, e.sparseUpdates = opts[changefeedbase.OptSparseUpdates]
+	_, e.rangeInfoField = opts[changefeedbase.OptRangeInfo]
+	_, e.provenanceField = opts[changefeedbase.OptProvenance]
+	_, e.epochField = opts[changefeedbase.OptChangefeedEpoch]
+	_, e.eventTimeField = opts[changefeedbase.OptEventTime]
+	_, e.valueSizeField = opts[changefeedbase.OptValueSize]
+	_, e.sourceClusterField = opts[changefeedbase.OptSourceCluster]
+	_, e.regionField = opts[changefeedbase.OptRegion]
+	_, e.stmtField = opts[changefeedbase.OptStatementTag]
+	_, e.idempotencyTokenField = opts[changefeedbase.OptIdempotencyToken]
+	_, e.deleteFullRow = opts[changefeedbase.OptDeleteFullRow]
+	_, e.versionField = opts[changefeedbase.OptVersionField]
+	_, e.resolvedWindow = opts[changefeedbase.OptResolvedWindow]
+	_, e.resolvedSpans = opts[changefeedbase.OptResolvedSpans]
+	_, e.ttlDeletesField = opts[changefeedbase.OptTTLDeletes]
+	e.keyObject = changefeedbase.KeyFormat(opts[changefeedbase.OptKeyFormat]) == changefeedbase.OptKeyFormatObject
+	e.floatSpecialValues = changefeedbase.FloatSpecialValues(opts[changefeedbase.OptFloatSpecialValues])
+	if e.floatSpecialValues == `` {
+		e.floatSpecialValues = changefeedbase.OptFloatSpecialValuesString
+	}
+	e.deletedFlag = changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeDeletedFlag
+	e.deleteFormat = changefeedbase.DeleteFormat(opts[changefeedbase.OptDeleteFormat])
+	// Deletes of envelope=deleted_flag are values, without a delete format.
+	if e.deleteFormat == `` && !e.deletedFlag {
+		if e.wrapped {
+			e.deleteFormat = changefeedbase.OptDeleteFormatAfterNull
+		} else {
+			e.deleteFormat = changefeedbase.OptDeleteFormatTombstone
+		}
+	}
 	if e.beforeField && !e.wrapped {
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
 			changefeedbase.OptDiff, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
@@ -136,16 +325,48 @@ func makeJSONEncoder(
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
 			changefeedbase.OptTopicInValue, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
 	}
+	e.connect = changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeConnect
+	e.debezium = changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]) == changefeedbase.OptEnvelopeDebezium
+	if e.connect || e.debezium {
+		// The Connect and Debezium envelopes have no room for the metadata of
+		// the rows, which would not match their schema.
+		for _, opt := range []string{
+			changefeedbase.OptUpdatedTimestamps, changefeedbase.OptMVCCTimestamps,
+			changefeedbase.OptRangeInfo, changefeedbase.OptSparseUpdates,
+			changefeedbase.OptDeleteFormat, changefeedbase.OptProvenance,
+			changefeedbase.OptChangefeedEpoch, changefeedbase.OptValueSize,
+			changefeedbase.OptSourceCluster, changefeedbase.OptRegion,
+			changefeedbase.OptStatementTag, changefeedbase.OptIdempotencyToken,
+			changefeedbase.OptVersionField,
+		} {
+			if _, ok := opts[opt]; ok {
+				return nil, errors.Errorf(`%s is not supported with %s=%s`,
+					opt, changefeedbase.OptEnvelope, opts[changefeedbase.OptEnvelope])
+			}
+		}
+		// Their keys have a schema of their own.
+		if e.keyObject {
+			return nil, errors.Errorf(`%s=%s is not supported with %s=%s`,
+				changefeedbase.OptKeyFormat, changefeedbase.OptKeyFormatObject,
+				changefeedbase.OptEnvelope, opts[changefeedbase.OptEnvelope])
+		}
+	}
 	return e, nil
 }
 
 // EncodeKey implements the Encoder interface.
 func (e *jsonEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
-	jsonEntries, err := e.encodeKeyRaw(row)
+	if e.connect {
+		return e.encodeConnectKey(row)
+	}
+	if e.debezium {
+		return e.encodeDebeziumKey(row)
+	}
+	key, err := e.encodeKeyRaw(row)
 	if err != nil {
 		return nil, err
 	}
-	j, err := json.MakeJSON(jsonEntries)
+	j, err := json.MakeJSON(key)
 	if err != nil {
 		return nil, err
 	}
@@ -154,10 +375,16 @@ func (e *jsonEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error
 	return e.buf.Bytes(), nil
 }
 
-func (e *jsonEncoder) encodeKeyRaw(row encodeRow) ([]interface{}, error) {
+// encodeKeyRaw returns the primary key of the row, as an array of the values
+// of its columns or, with key_format=object, as a map of them by name.
+func (e *jsonEncoder) encodeKeyRaw(row encodeRow) (interface{}, error) {
 	colIdxByID := catalog.ColumnIDToOrdinalMap(row.tableDesc.PublicColumns())
 	primaryIndex := row.tableDesc.GetPrimaryIndex()
 	jsonEntries := make([]interface{}, primaryIndex.NumKeyColumns())
+	var names []string
+	if e.keyObject {
+		names = make([]string, primaryIndex.NumKeyColumns())
+	}
 	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
 		colID := primaryIndex.GetKeyColumnID(i)
 		idx, ok := colIdxByID.Get(colID)
@@ -168,16 +395,22 @@ func (e *jsonEncoder) encodeKeyRaw(row encodeRow) ([]interface{}, error) {
 		if err := datum.EnsureDecoded(col.GetType(), &e.alloc); err != nil {
 			return nil, err
 		}
+		if names != nil {
+			names[i] = col.GetName()
+		}
 		var err error
-		jsonEntries[i], err = tree.AsJSON(
-			datum.Datum,
-			sessiondatapb.DataConversionConfig{},
-			time.UTC,
-		)
+		jsonEntries[i], err = e.datumAsJSON(datum.Datum)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if names != nil {
+		keyObject := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			keyObject[name] = jsonEntries[i]
+		}
+		return keyObject, nil
+	}
 	return jsonEntries, nil
 }
 
@@ -194,7 +427,31 @@ func (e *jsonEncoder) encodeTopicRaw(row encodeRow) (interface{}, error) {
 
 // EncodeValue implements the Encoder interface.
 func (e *jsonEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
-	if e.keyOnly || (!e.wrapped && row.deleted) {
+	if e.connect {
+		return e.encodeConnectValue(row)
+	}
+	if e.debezium {
+		return e.encodeDebeziumValue(row)
+	}
+	j, err := e.encodeValueJSON(row)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	e.buf.Reset()
+	j.Format(&e.buf)
+	return e.buf.Bytes(), nil
+}
+
+// encodeValueJSON returns the value of the row, or nil if it has none.
+func (e *jsonEncoder) encodeValueJSON(row encodeRow) (json.JSON, error) {
+	if row.deleted {
+		switch e.deleteFormat {
+		case changefeedbase.OptDeleteFormatTombstone:
+			return nil, nil
+		case changefeedbase.OptDeleteFormatNull:
+			return json.NullJSONValue, nil
+		}
+	} else if e.keyOnly {
 		return nil, nil
 	}
 
@@ -211,11 +468,7 @@ func (e *jsonEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, err
 				return nil, err
 			}
 			var err error
-			after[col.GetName()], err = tree.AsJSON(
-				datum.Datum,
-				sessiondatapb.DataConversionConfig{},
-				time.UTC,
-			)
+			after[col.GetName()], err = e.datumAsJSON(datum.Datum)
 			if err != nil {
 				return nil, err
 			}
@@ -235,25 +488,37 @@ func (e *jsonEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, err
 				return nil, err
 			}
 			var err error
-			before[col.GetName()], err = tree.AsJSON(
-				datum.Datum,
-				sessiondatapb.DataConversionConfig{},
-				time.UTC,
-			)
+			before[col.GetName()], err = e.datumAsJSON(datum.Datum)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 
+	if e.sparseUpdates && after != nil && before != nil {
+		var err error
+		if after, err = sparseAfter(row.tableDesc, after, before); err != nil {
+			return nil, err
+		}
+	}
+
 	var jsonEntries map[string]interface{}
-	if e.wrapped {
+	if row.deleted && e.deleteFormat == changefeedbase.OptDeleteFormatOp {
+		keyEntries, err := e.encodeKeyRaw(row)
+		if err != nil {
+			return nil, err
+		}
+		jsonEntries = map[string]interface{}{`op`: `delete`, `key`: keyEntries}
+		if e.deleteFullRow {
+			jsonEntries[`before`] = before
+		}
+	} else if e.wrapped {
 		if after != nil {
 			jsonEntries = map[string]interface{}{`after`: after}
 		} else {
 			jsonEntries = map[string]interface{}{`after`: nil}
 		}
-		if e.beforeField {
+		if e.beforeField || (e.deleteFullRow && row.deleted) {
 			if before != nil {
 				jsonEntries[`before`] = before
 			} else {
@@ -274,11 +539,19 @@ func (e *jsonEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, err
 			}
 			jsonEntries[`topic`] = topicEntry
 		}
+	} else if e.deletedFlag {
+		var err error
+		if jsonEntries, err = e.deletedFlagEntries(row, after); err != nil {
+			return nil, err
+		}
 	} else {
 		jsonEntries = after
 	}
 
-	if e.updatedField || e.mvccTimestampField {
+	ttlDelete := e.ttlDeletesField && row.deleted
+	if e.updatedField || e.mvccTimestampField || e.rangeInfoField || e.provenanceField ||
+		e.epochField || e.eventTimeField || e.valueSizeField || e.sourceClusterField ||
+		e.regionField || e.stmtField || e.idempotencyTokenField || row.snapshot || ttlDelete {
 		var meta map[string]interface{}
 		if e.wrapped {
 			meta = jsonEntries
@@ -287,28 +560,263 @@ func (e *jsonEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, err
 			jsonEntries[jsonMetaSentinel] = meta
 		}
 		if e.updatedField {
-			meta[`updated`] = row.updated.AsOfSystemTime()
+			meta[`updated`] = e.formatTimestamp(row.updated, row.updated.AsOfSystemTime())
 		}
 		if e.mvccTimestampField {
 			meta[`mvcc_timestamp`] = row.mvccTimestamp.AsOfSystemTime()
 		}
+		if e.rangeInfoField {
+			meta[`range_id`] = int64(row.rangeID)
+			if row.leaseholderNodeID != 0 {
+				meta[`leaseholder_node_id`] = int64(row.leaseholderNodeID)
+			} else {
+				meta[`leaseholder_node_id`] = nil
+			}
+		}
+		if e.provenanceField {
+			meta[`provenance`] = encodeProvenance(row)
+		}
+		if e.epochField {
+			meta[`changefeed_epoch`] = row.epoch
+		}
+		if e.eventTimeField {
+			meta[`event_time`] = row.mvccTimestamp.GoTime().Format(time.RFC3339Nano)
+		}
+		if e.valueSizeField {
+			if row.valueSize >= 0 {
+				meta[`value_size`] = int64(row.valueSize)
+			} else {
+				meta[`value_size`] = nil
+			}
+		}
+		if e.sourceClusterField {
+			meta[`source_cluster`] = e.sourceCluster
+		}
+		if e.regionField {
+			region, err := rowRegion(row, &e.alloc)
+			if err != nil {
+				return nil, err
+			}
+			meta[rowRegionField] = nil
+			if region != nil {
+				if meta[rowRegionField], err = e.datumAsJSON(region); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if e.stmtField {
+			meta[`stmt`] = rowStatementTag(row)
+		}
+		if e.idempotencyTokenField {
+			meta[idempotencyTokenField] = row.idempotencyToken
+		}
+		if row.snapshot {
+			meta[`snapshot`] = true
+		}
+		if ttlDelete {
+			meta[`ttl_delete`] = row.ttlExpired
+		}
+	}
+	if e.versionField {
+		jsonEntries[rowVersionField] = rowVersion(row.mvccTimestamp)
 	}
 
-	j, err := json.MakeJSON(jsonEntries)
-	if err != nil {
-		return nil, err
+	return json.MakeJSON(jsonEntries)
+}
+
+// rowVersionField is the field of the values of the version_field option
+// which holds the version of the row.
+const rowVersionField = `__crdb_version__`
+
+// rowVersion returns the version of a row written at the MVCC timestamp ts,
+// for the version_field option: the 19 digits of the wall time of ts,
+// zero-padded, followed by the 10 digits of its logical component. That is
+// the timestamp of the mvcc_timestamp option without its decimal point,
+// padded to a fixed width of 29 digits.
+//
+// The versions of a key are written at distinct, increasing MVCC timestamps,
+// so their versions are strictly increasing, and can be compared by stores
+// which resolve concurrent writes as components of version vectors. Since
+// they have a fixed width, they compare as strings, byte by byte, as they do
+// as integers. They don't fit in 64 bits, let alone in the 53 bits of the
+// integers JSON decoders parsing numbers as doubles keep exactly, so they're
+// encoded as JSON strings.
+func rowVersion(ts hlc.Timestamp) string {
+	return fmt.Sprintf(`%019d%010d`, ts.WallTime, ts.Logical)
+}
+
+// encodeProvenance returns the provenance field of the row for the provenance
+// option, which tells audit consumers where the change was read and emitted
+// from: the range containing the row and the node holding its lease, the node
+// of the change aggregator which emitted it, and whether it was read by a scan
+// (an initial scan, backfill or resync) or from a rangefeed. The application
+// name and session of the transaction which wrote the row aren't available:
+// they aren't stored with the MVCC versions of rows, which rangefeeds and
+// scans return without any metadata of the writing transaction.
+func encodeProvenance(row encodeRow) map[string]interface{} {
+	provenance := map[string]interface{}{
+		`range_id`:            int64(row.rangeID),
+		`leaseholder_node_id`: nil,
+		`emitter_node_id`:     int64(row.emitterInstanceID),
+		`source`:              `rangefeed`,
+	}
+	if row.leaseholderNodeID != 0 {
+		provenance[`leaseholder_node_id`] = int64(row.leaseholderNodeID)
+	}
+	if row.backfill {
+		provenance[`source`] = `scan`
+	}
+	return provenance
+}
+
+// rowStatementTag returns the stmt field of the row for the statement_tag
+// option: the tag of the kind of SQL statement which wrote the row, as
+// inferred from the row and its previous value. The statement itself isn't
+// recoverable: KV writes, as stored and as delivered by rangefeeds, carry no
+// trace of the statement which issued them, so an UPSERT or an INSERT ... ON
+// CONFLICT is tagged INSERT if the row didn't exist and UPDATE if it did, and
+// a TTL expiration is tagged DELETE. Rows read by a scan weren't written by a
+// statement the changefeed saw, and are tagged null.
+func rowStatementTag(row encodeRow) interface{} {
+	switch {
+	case row.deleted:
+		return `DELETE`
+	case row.backfill:
+		return nil
+	case row.prevDeleted:
+		return `INSERT`
+	default:
+		return `UPDATE`
+	}
+}
+
+// sparseAfter returns the subset of the after columns which are part of the
+// primary key or whose value differs from the before columns.
+func sparseAfter(
+	tableDesc catalog.TableDescriptor, after, before map[string]interface{},
+) (map[string]interface{}, error) {
+	primaryIndex := tableDesc.GetPrimaryIndex()
+	sparse := make(map[string]interface{}, primaryIndex.NumKeyColumns())
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		name := primaryIndex.GetKeyColumnName(i)
+		sparse[name] = after[name]
+	}
+	for name, value := range after {
+		if prev, ok := before[name]; ok {
+			cmp, err := value.(json.JSON).Compare(prev.(json.JSON))
+			if err != nil {
+				return nil, err
+			}
+			if cmp == 0 {
+				continue
+			}
+		}
+		sparse[name] = value
+	}
+	return sparse, nil
+}
+
+// formatTimestamp renders ts according to the timestamp_format option,
+// returning hlcFormatted for the default HLC format. The non-HLC formats only
+// carry the wall time of the timestamp, so rows and resolved timestamps which
+// differ only in their logical component are rendered identically. Consumers
+// that rely on the ordering of timestamps for correctness should use the HLC
+// format.
+// datumAsJSON returns the JSON representation of a datum. JSON numbers cannot
+// represent the NaN and infinite values of floats, which are rendered, alone
+// or in arrays, as float_special_values says.
+func (e *jsonEncoder) datumAsJSON(d tree.Datum) (json.JSON, error) {
+	switch t := tree.UnwrapDatum(nil /* evalCtx */, d).(type) {
+	case *tree.DFloat:
+		if f := float64(*t); math.IsNaN(f) || math.IsInf(f, 0) {
+			return e.floatSpecialValueAsJSON(f)
+		}
+	case *tree.DArray:
+		if t.ParamTyp.Family() == types.FloatFamily {
+			b := json.NewArrayBuilder(len(t.Array))
+			for _, elem := range t.Array {
+				j, err := e.datumAsJSON(elem)
+				if err != nil {
+					return nil, err
+				}
+				b.Add(j)
+			}
+			return b.Build(), nil
+		}
+	}
+	return tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+}
+
+// floatSpecialValueAsJSON returns the JSON representation of a NaN or
+// infinite float.
+func (e *jsonEncoder) floatSpecialValueAsJSON(f float64) (json.JSON, error) {
+	switch e.floatSpecialValues {
+	case changefeedbase.OptFloatSpecialValuesNull:
+		return json.NullJSONValue, nil
+	case changefeedbase.OptFloatSpecialValuesError:
+		return nil, errors.Errorf(`%g cannot be encoded as JSON with %s=%s`,
+			f, changefeedbase.OptFloatSpecialValues, changefeedbase.OptFloatSpecialValuesError)
+	default:
+		switch {
+		case math.IsNaN(f):
+			return json.FromString(`NaN`), nil
+		case f > 0:
+			return json.FromString(`Infinity`), nil
+		default:
+			return json.FromString(`-Infinity`), nil
+		}
+	}
+}
+
+func (e *jsonEncoder) formatTimestamp(ts hlc.Timestamp, hlcFormatted string) string {
+	switch e.timestampFormat {
+	case changefeedbase.OptTimestampFormatRFC3339:
+		return timeutil.Unix(0, ts.WallTime).Format(time.RFC3339Nano)
+	case changefeedbase.OptTimestampFormatUnixNanos:
+		return strconv.FormatInt(ts.WallTime, 10)
+	default:
+		return hlcFormatted
 	}
-	e.buf.Reset()
-	j.Format(&e.buf)
-	return e.buf.Bytes(), nil
 }
 
 // EncodeResolvedTimestamp implements the Encoder interface.
 func (e *jsonEncoder) EncodeResolvedTimestamp(
 	_ context.Context, _ string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return e.encodeResolvedTimestamp(resolved, nil /* previous */, nil /* spans */)
+}
+
+// encodeResolvedTimestamp encodes a resolved timestamp payload, which also
+// holds the previous resolved timestamp if it's non-nil, and the spans of the
+// frontier if they're non-nil.
+func (e *jsonEncoder) encodeResolvedTimestamp(
+	resolved hlc.Timestamp, previous *hlc.Timestamp, spans *frontierSpans,
 ) ([]byte, error) {
 	meta := map[string]interface{}{
-		`resolved`: tree.TimestampToDecimalDatum(resolved).Decimal.String(),
+		`resolved`: e.formatTimestamp(resolved, tree.TimestampToDecimalDatum(resolved).Decimal.String()),
+	}
+	if previous != nil {
+		if previous.IsEmpty() {
+			meta[`previous_resolved`] = nil
+		} else {
+			meta[`previous_resolved`] = e.formatTimestamp(
+				*previous, tree.TimestampToDecimalDatum(*previous).Decimal.String())
+		}
+	}
+	if spans != nil {
+		entries := make([]interface{}, len(spans.spans))
+		for i, s := range spans.spans {
+			entries[i] = map[string]interface{}{
+				`start`:    s.Span.Key.String(),
+				`end`:      s.Span.EndKey.String(),
+				`resolved`: e.formatTimestamp(s.Timestamp, tree.TimestampToDecimalDatum(s.Timestamp).Decimal.String()),
+			}
+		}
+		meta[`spans`] = entries
+		meta[`spans_truncated`] = spans.truncated
+	}
+	if e.sourceClusterField {
+		meta[`source_cluster`] = e.sourceCluster
 	}
 	var jsonEntries interface{}
 	if e.wrapped {
@@ -321,6 +829,98 @@ func (e *jsonEncoder) EncodeResolvedTimestamp(
 	return gojson.Marshal(jsonEntries)
 }
 
+// resolvedDetailEncoder wraps the jsonEncoder of a changefeed with the
+// resolved_window or resolved_spans options to add the detail they require to
+// resolved timestamp payloads.
+//
+// With resolved_window, the resolved timestamp emitted before each resolved
+// timestamp is added under `previous_resolved`. Together they bound the window
+// of updates the resolved timestamp completes: every row with an updated
+// timestamp in (previous_resolved, resolved] has been emitted.
+// previous_resolved is null for the first resolved timestamp of a new
+// changefeed. After a restart, it's the high-water of the changefeed, which
+// may be lower than the last resolved timestamp emitted before the restart;
+// since rows above the high-water are emitted again, the windows overlap.
+//
+// With resolved_spans, the spans of the frontier the resolved timestamp was
+// emitted from are added under `spans`, each with its own resolved timestamp,
+// which may be ahead of the resolved timestamp of the payload: every row of a
+// span with an updated timestamp at or below the span's resolved timestamp
+// has been emitted. See resolvedFrontierSpans for how they're bounded.
+type resolvedDetailEncoder struct {
+	*jsonEncoder
+	previous *hlc.Timestamp
+	spans    *frontierSpans
+}
+
+// makeResolvedEncoder returns the Encoder of the resolved timestamps emitted
+// from frontier, whose last resolved timestamp was previous: the changefeed's
+// encoder, wrapped in a resolvedDetailEncoder if its options require it.
+func makeResolvedEncoder(encoder Encoder, previous hlc.Timestamp, frontier *span.Frontier) Encoder {
+	e, ok := encoder.(*jsonEncoder)
+	if !ok || !(e.resolvedWindow || e.resolvedSpans) {
+		return encoder
+	}
+	d := resolvedDetailEncoder{jsonEncoder: e}
+	if e.resolvedWindow {
+		d.previous = &previous
+	}
+	if e.resolvedSpans {
+		d.spans = resolvedFrontierSpans(frontier, maxResolvedSpans)
+	}
+	return d
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e resolvedDetailEncoder) EncodeResolvedTimestamp(
+	_ context.Context, _ string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return e.encodeResolvedTimestamp(resolved, e.previous, e.spans)
+}
+
+// maxResolvedSpans bounds the number of spans listed in the resolved
+// timestamp payloads of the resolved_spans option, for changefeeds watching
+// many ranges.
+const maxResolvedSpans = 1000
+
+// frontierSpans are the spans of a frontier listed by the resolved_spans
+// option.
+type frontierSpans struct {
+	spans []jobspb.ResolvedSpan
+	// truncated is set if spans only holds the spans furthest behind.
+	truncated bool
+}
+
+// resolvedFrontierSpans returns the spans of frontier, ordered by key, with
+// the adjacent spans resolved at the same timestamp merged. If there are more
+// than maxSpans of them, only the maxSpans spans resolved at the lowest
+// timestamps are returned, so every span which is left out is resolved at or
+// above the highest timestamp returned.
+func resolvedFrontierSpans(frontier *span.Frontier, maxSpans int) *frontierSpans {
+	var spans []jobspb.ResolvedSpan
+	frontier.Entries(func(s roachpb.Span, ts hlc.Timestamp) span.OpResult {
+		if n := len(spans); n > 0 && spans[n-1].Timestamp.Equal(ts) &&
+			spans[n-1].Span.EndKey.Equal(s.Key) {
+			spans[n-1].Span.EndKey = s.EndKey
+		} else {
+			spans = append(spans, jobspb.ResolvedSpan{Span: s, Timestamp: ts})
+		}
+		return span.ContinueMatch
+	})
+	res := &frontierSpans{spans: spans}
+	if len(spans) > maxSpans {
+		sort.SliceStable(spans, func(i, j int) bool {
+			return spans[i].Timestamp.Less(spans[j].Timestamp)
+		})
+		spans = spans[:maxSpans]
+		sort.Slice(spans, func(i, j int) bool {
+			return spans[i].Span.Key.Compare(spans[j].Span.Key) < 0
+		})
+		res.spans, res.truncated = spans, true
+	}
+	return res
+}
+
 // confluentAvroEncoder encodes changefeed entries as Avro's binary or textual
 // JSON format. Keys are the primary key columns in a record. Values are all
 // columns in a record.
@@ -331,6 +931,34 @@ type confluentAvroEncoder struct {
 	targets                            jobspb.ChangefeedTargets
 	virtualColumnVisibility            string
 
+	// subjectPrefix, set with the schema_registry_subject_prefix option, is
+	// prepended to the subjects the schemas are registered under, so that
+	// changefeeds of several environments sharing a schema registry don't
+	// register their schemas under the same subjects.
+	subjectPrefix string
+	// idempotencyTokenField, set with the idempotency_token option, adds the
+	// idempotency token of each row to its envelope.
+	idempotencyTokenField bool
+	// beforeDeletesOnly, set with the delete_full_row option without the diff
+	// option, restricts the before field to the previous values of deleted
+	// rows.
+	beforeDeletesOnly bool
+
+	// namespaceTemplate and recordNameTemplate are the avro_namespace and
+	// avro_record_name options, if set. See namespace and dataSchema.
+	namespaceTemplate, recordNameTemplate string
+	// fixedColumns is the avro_fixed_columns option, if set.
+	fixedColumns avroFixedColumns
+	// connect, set with the avro_connect_compatible option, annotates the
+	// schemas with the properties of Kafka Connect. See typeToAvroSchema.
+	connect bool
+	// columnComments, if set, returns the comments which document the fields
+	// of the value schemas. It's set with the column_comments option.
+	columnComments columnCommentsFetcher
+	// singleObject, set with the schema_fingerprint option, encodes messages
+	// in Avro's single-object encoding. See header.
+	singleObject bool
+
 	keyCache   *cache.UnorderedCache // [tableIDAndVersion]confluentRegisteredKeySchema
 	valueCache *cache.UnorderedCache // [tableIDAndVersionPair]confluentRegisteredEnvelopeSchema
 
@@ -368,11 +996,59 @@ var encoderCacheConfig = cache.Config{
 
 func newConfluentAvroEncoder(
 	opts map[string]string, targets jobspb.ChangefeedTargets,
+) (*confluentAvroEncoder, error) {
+	e, err := newAvroSchemaEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts[changefeedbase.OptConfluentSchemaRegistry]) == 0 {
+		return nil, errors.Errorf(`WITH option %s is required for %s=%s`,
+			changefeedbase.OptConfluentSchemaRegistry, changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+	}
+
+	reg, err := newConfluentSchemaRegistry(opts[changefeedbase.OptConfluentSchemaRegistry])
+	if err != nil {
+		return nil, err
+	}
+
+	e.schemaRegistry = reg
+	e.keyCache = cache.NewUnorderedCache(encoderCacheConfig)
+	e.valueCache = cache.NewUnorderedCache(encoderCacheConfig)
+	e.resolvedCache = make(map[string]confluentRegisteredEnvelopeSchema)
+	return e, nil
+}
+
+// newAvroSchemaEncoder returns a confluentAvroEncoder which can only generate
+// the schemas of tables, without a schema registry to register them with.
+func newAvroSchemaEncoder(
+	opts map[string]string, targets jobspb.ChangefeedTargets,
 ) (*confluentAvroEncoder, error) {
 	e := &confluentAvroEncoder{
 		schemaPrefix:            opts[changefeedbase.OptAvroSchemaPrefix],
+		subjectPrefix:           opts[changefeedbase.OptSubjectPrefix],
 		targets:                 targets,
 		virtualColumnVisibility: opts[changefeedbase.OptVirtualColumns],
+		namespaceTemplate:       opts[changefeedbase.OptAvroNamespace],
+		recordNameTemplate:      opts[changefeedbase.OptAvroRecordName],
+	}
+	_, e.connect = opts[changefeedbase.OptAvroConnectCompatible]
+	// Table names are escaped into valid avro names, so the templates are
+	// valid for every table if they are for one.
+	if _, ok := opts[changefeedbase.OptAvroNamespace]; ok {
+		if err := validateAvroNamespace(expandAvroNameTemplate(e.namespaceTemplate, `table`)); err != nil {
+			return nil, errors.Wrapf(err, `invalid %s`, changefeedbase.OptAvroNamespace)
+		}
+	}
+	if _, ok := opts[changefeedbase.OptAvroRecordName]; ok {
+		if err := validateAvroName(expandAvroNameTemplate(e.recordNameTemplate, `table`)); err != nil {
+			return nil, errors.Wrapf(err, `invalid %s`, changefeedbase.OptAvroRecordName)
+		}
+	}
+	if v, ok := opts[changefeedbase.OptAvroFixedColumns]; ok {
+		var err error
+		if e.fixedColumns, err = parseAvroFixedColumns(v); err != nil {
+			return nil, err
+		}
 	}
 
 	switch opts[changefeedbase.OptEnvelope] {
@@ -383,6 +1059,7 @@ func newConfluentAvroEncoder(
 		return nil, errors.Errorf(`%s=%s is not supported with %s=%s`,
 			changefeedbase.OptEnvelope, opts[changefeedbase.OptEnvelope], changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
 	}
+	_, e.singleObject = opts[changefeedbase.OptSchemaFingerprint]
 	_, e.updatedField = opts[changefeedbase.OptUpdatedTimestamps]
 	if e.updatedField && e.keyOnly {
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
@@ -393,6 +1070,18 @@ func newConfluentAvroEncoder(
 		return nil, errors.Errorf(`%s is only usable with %s=%s`,
 			changefeedbase.OptDiff, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
 	}
+	if _, ok := opts[changefeedbase.OptDeleteFullRow]; ok && !e.beforeField {
+		if e.keyOnly {
+			return nil, errors.Errorf(`%s is only usable with %s=%s`,
+				changefeedbase.OptDeleteFullRow, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+		}
+		e.beforeField, e.beforeDeletesOnly = true, true
+	}
+	_, e.idempotencyTokenField = opts[changefeedbase.OptIdempotencyToken]
+	if e.idempotencyTokenField && e.keyOnly {
+		return nil, errors.Errorf(`%s is only usable with %s=%s`,
+			changefeedbase.OptIdempotencyToken, changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeWrapped)
+	}
 
 	if _, ok := opts[changefeedbase.OptKeyInValue]; ok {
 		return nil, errors.Errorf(`%s is not supported with %s=%s`,
@@ -402,27 +1091,87 @@ func newConfluentAvroEncoder(
 		return nil, errors.Errorf(`%s is not supported with %s=%s`,
 			changefeedbase.OptTopicInValue, changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
 	}
-	if len(opts[changefeedbase.OptConfluentSchemaRegistry]) == 0 {
-		return nil, errors.Errorf(`WITH option %s is required for %s=%s`,
-			changefeedbase.OptConfluentSchemaRegistry, changefeedbase.OptFormat, changefeedbase.OptFormatAvro)
+	return e, nil
+}
+
+// Get the raw SQL-formatted string for a table name
+// and apply full_table_name and avro_schema_prefix options
+func (e *confluentAvroEncoder) rawTableName(desc catalog.TableDescriptor) string {
+	return e.schemaPrefix + e.targets[desc.GetID()].StatementTimeName
+}
+
+// namespace returns the namespace of the schemas of the named table: the
+// avro_namespace option, with the name of the table substituted for {table},
+// or avro_schema_prefix if it's not set.
+func (e *confluentAvroEncoder) namespace(tableName string) string {
+	if e.namespaceTemplate == `` {
+		return e.schemaPrefix
+	}
+	return expandAvroNameTemplate(e.namespaceTemplate, tableName)
+}
+
+// dataSchema returns the schema of the record holding the columns of the
+// rows of desc. It's named after the table, unless the avro_record_name
+// option is set, in which case it's named after the option, with the name of
+// the table substituted for {table}.
+func (e *confluentAvroEncoder) dataSchema(
+	desc catalog.TableDescriptor, nameSuffix string, docs map[descpb.ColumnID]string,
+) (*avroDataRecord, error) {
+	namespace := e.namespace(desc.GetName())
+	name := SQLNameToAvroName(desc.GetName())
+	if e.recordNameTemplate != `` {
+		name = expandAvroNameTemplate(e.recordNameTemplate, desc.GetName())
 	}
+	return tableToNamedAvroSchema(desc, name, nameSuffix, namespace, e.virtualColumnVisibility,
+		e.fixedColumns.forTable(desc), docs, e.connect)
+}
 
-	reg, err := newConfluentSchemaRegistry(opts[changefeedbase.OptConfluentSchemaRegistry])
+// keySchema returns the schema of the keys of the rows of desc.
+func (e *confluentAvroEncoder) keySchema(desc catalog.TableDescriptor) (*avroDataRecord, error) {
+	return indexToAvroSchema(desc, desc.GetPrimaryIndex(), e.rawTableName(desc), e.namespace(desc.GetName()),
+		e.fixedColumns.forTable(desc), e.connect)
+}
+
+// valueSchema returns the schema of the values of the rows of desc. prevDesc
+// is the descriptor of the previous values of the rows, or nil if they are
+// unknown. The fields of the columns in docs are documented with the mapped
+// comments.
+func (e *confluentAvroEncoder) valueSchema(
+	desc, prevDesc catalog.TableDescriptor, docs map[descpb.ColumnID]string,
+) (*avroEnvelopeRecord, error) {
+	var beforeDataSchema *avroDataRecord
+	if e.beforeField && prevDesc != nil {
+		var err error
+		beforeDataSchema, err = e.dataSchema(prevDesc, `before`, docs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	afterDataSchema, err := e.dataSchema(desc, avroSchemaNoSuffix, docs)
 	if err != nil {
 		return nil, err
 	}
 
-	e.schemaRegistry = reg
-	e.keyCache = cache.NewUnorderedCache(encoderCacheConfig)
-	e.valueCache = cache.NewUnorderedCache(encoderCacheConfig)
-	e.resolvedCache = make(map[string]confluentRegisteredEnvelopeSchema)
-	return e, nil
+	opts := avroEnvelopeOpts{
+		afterField:            true,
+		beforeField:           e.beforeField,
+		updatedField:          e.updatedField,
+		idempotencyTokenField: e.idempotencyTokenField,
+		connect:               e.connect,
+	}
+	return envelopeToAvroSchema(e.rawTableName(desc), opts, beforeDataSchema, afterDataSchema, e.namespace(desc.GetName()))
 }
 
-// Get the raw SQL-formatted string for a table name
-// and apply full_table_name and avro_schema_prefix options
-func (e *confluentAvroEncoder) rawTableName(desc catalog.TableDescriptor) string {
-	return e.schemaPrefix + e.targets[desc.GetID()].StatementTimeName
+// columnDocs returns the comments documenting the fields of the columns of
+// desc, or nil without the column_comments option.
+func (e *confluentAvroEncoder) columnDocs(
+	ctx context.Context, desc catalog.TableDescriptor,
+) (map[descpb.ColumnID]string, error) {
+	if e.columnComments == nil {
+		return nil, nil
+	}
+	return e.columnComments(ctx, desc)
 }
 
 // EncodeKey implements the Encoder interface.
@@ -437,14 +1186,12 @@ func (e *confluentAvroEncoder) EncodeKey(ctx context.Context, row encodeRow) ([]
 	} else {
 		var err error
 		tableName := e.rawTableName(row.tableDesc)
-		registered.schema, err = indexToAvroSchema(row.tableDesc, row.tableDesc.GetPrimaryIndex(), tableName, e.schemaPrefix)
+		registered.schema, err = e.keySchema(row.tableDesc)
 		if err != nil {
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(tableName) + confluentSubjectSuffixKey
+		subject := e.subject(tableName, confluentSubjectSuffixKey)
 		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
 		if err != nil {
 			return nil, err
@@ -452,13 +1199,12 @@ func (e *confluentAvroEncoder) EncodeKey(ctx context.Context, row encodeRow) ([]
 		e.keyCache.Add(cacheKey, registered)
 	}
 
-	// https://docs.confluent.io/current/schema-registry/docs/serializer-formatter.html#wire-format
-	header := []byte{
-		changefeedbase.ConfluentAvroWireFormatMagic,
-		0, 0, 0, 0, // Placeholder for the ID.
+	header := e.header(&registered.schema.avroRecord, registered.registryID)
+	b, err := registered.schema.BinaryFromRow(header, row.datums)
+	if err != nil {
+		return nil, errors.Mark(err, errAvroUnsupportedValue)
 	}
-	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
-	return registered.schema.BinaryFromRow(header, row.datums)
+	return b, nil
 }
 
 // EncodeValue implements the Encoder interface.
@@ -482,30 +1228,16 @@ func (e *confluentAvroEncoder) EncodeValue(ctx context.Context, row encodeRow) (
 			registered.schema.before.refreshTypeMetadata(row.prevTableDesc)
 		}
 	} else {
-		var beforeDataSchema *avroDataRecord
-		if e.beforeField && row.prevTableDesc != nil {
-			var err error
-			beforeDataSchema, err = tableToAvroSchema(row.prevTableDesc, `before`, e.schemaPrefix, e.virtualColumnVisibility)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		afterDataSchema, err := tableToAvroSchema(row.tableDesc, avroSchemaNoSuffix, e.schemaPrefix, e.virtualColumnVisibility)
+		docs, err := e.columnDocs(ctx, row.tableDesc)
 		if err != nil {
 			return nil, err
 		}
-
-		opts := avroEnvelopeOpts{afterField: true, beforeField: e.beforeField, updatedField: e.updatedField}
-		registered.schema, err = envelopeToAvroSchema(e.rawTableName(row.tableDesc), opts, beforeDataSchema, afterDataSchema, e.schemaPrefix)
-
+		registered.schema, err = e.valueSchema(row.tableDesc, row.prevTableDesc, docs)
 		if err != nil {
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(e.rawTableName(row.tableDesc)) + confluentSubjectSuffixValue
+		subject := e.subject(e.rawTableName(row.tableDesc), confluentSubjectSuffixValue)
 		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
 		if err != nil {
 			return nil, err
@@ -513,26 +1245,26 @@ func (e *confluentAvroEncoder) EncodeValue(ctx context.Context, row encodeRow) (
 		e.valueCache.Add(cacheKey, registered)
 	}
 
-	var meta avroMetadata
+	meta := avroMetadata{}
 	if registered.schema.opts.updatedField {
-		meta = map[string]interface{}{
-			`updated`: row.updated,
-		}
+		meta[`updated`] = row.updated
+	}
+	if registered.schema.opts.idempotencyTokenField {
+		meta[idempotencyTokenField] = row.idempotencyToken
 	}
 	var beforeDatums, afterDatums rowenc.EncDatumRow
-	if row.prevDatums != nil && !row.prevDeleted {
+	if row.prevDatums != nil && !row.prevDeleted && (row.deleted || !e.beforeDeletesOnly) {
 		beforeDatums = row.prevDatums
 	}
 	if !row.deleted {
 		afterDatums = row.datums
 	}
-	// https://docs.confluent.io/current/schema-registry/docs/serializer-formatter.html#wire-format
-	header := []byte{
-		changefeedbase.ConfluentAvroWireFormatMagic,
-		0, 0, 0, 0, // Placeholder for the ID.
+	header := e.header(&registered.schema.avroRecord, registered.registryID)
+	b, err := registered.schema.BinaryFromRow(header, meta, beforeDatums, afterDatums)
+	if err != nil {
+		return nil, errors.Mark(err, errAvroUnsupportedValue)
 	}
-	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
-	return registered.schema.BinaryFromRow(header, meta, beforeDatums, afterDatums)
+	return b, nil
 }
 
 // EncodeResolvedTimestamp implements the Encoder interface.
@@ -541,16 +1273,14 @@ func (e *confluentAvroEncoder) EncodeResolvedTimestamp(
 ) ([]byte, error) {
 	registered, ok := e.resolvedCache[topic]
 	if !ok {
-		opts := avroEnvelopeOpts{resolvedField: true}
+		opts := avroEnvelopeOpts{resolvedField: true, connect: e.connect}
 		var err error
-		registered.schema, err = envelopeToAvroSchema(topic, opts, nil /* before */, nil /* after */, e.schemaPrefix /* namespace */)
+		registered.schema, err = envelopeToAvroSchema(topic, opts, nil /* before */, nil /* after */, e.namespace(topic))
 		if err != nil {
 			return nil, err
 		}
 
-		// NB: This uses the kafka name escaper because it has to match the name
-		// of the kafka topic.
-		subject := SQLNameToKafkaName(topic) + confluentSubjectSuffixValue
+		subject := e.subject(topic, confluentSubjectSuffixValue)
 		registered.registryID, err = e.register(ctx, &registered.schema.avroRecord, subject)
 		if err != nil {
 			return nil, err
@@ -564,13 +1294,52 @@ func (e *confluentAvroEncoder) EncodeResolvedTimestamp(
 			`resolved`: resolved,
 		}
 	}
+	header := e.header(&registered.schema.avroRecord, registered.registryID)
+	return registered.schema.BinaryFromRow(header, meta, nil /* beforeRow */, nil /* afterRow */)
+}
+
+// Avro's single-object encoding prefixes the binary encoding of a message with
+// a two byte marker and the 64-bit Rabin fingerprint of its schema.
+//
+// https://avro.apache.org/docs/current/spec.html#single_object_encoding
+const avroSingleObjectHeaderLen = 10
+
+var avroSingleObjectMarker = [2]byte{0xc3, 0x01}
+
+// header returns the header of the messages encoded with schema, whose ID in
+// the schema registry is registryID.
+//
+// Messages are framed in Confluent's wire format by default, whose header
+// holds the registry ID of their schema. With the schema_fingerprint option,
+// they're framed in Avro's single-object encoding, whose header holds the
+// Rabin fingerprint of the parsing canonical form of their schema instead.
+// The fingerprint is stable for identical schemas, and changes when the
+// schema evolves, so consumers can cache their decoders by fingerprint and
+// decode the messages with any library implementing the encoding. The schemas
+// are still registered with the schema registry, from which consumers can
+// fetch the schemas of fingerprints they haven't seen.
+func (e *confluentAvroEncoder) header(schema *avroRecord, registryID int32) []byte {
+	if e.singleObject {
+		header := make([]byte, avroSingleObjectHeaderLen)
+		copy(header, avroSingleObjectMarker[:])
+		binary.LittleEndian.PutUint64(header[2:], schema.codec.Rabin)
+		return header
+	}
 	// https://docs.confluent.io/current/schema-registry/docs/serializer-formatter.html#wire-format
 	header := []byte{
 		changefeedbase.ConfluentAvroWireFormatMagic,
 		0, 0, 0, 0, // Placeholder for the ID.
 	}
-	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
-	return registered.schema.BinaryFromRow(header, meta, nil /* beforeRow */, nil /* afterRow */)
+	binary.BigEndian.PutUint32(header[1:5], uint32(registryID))
+	return header
+}
+
+// subject returns the subject the schemas of the keys or values, per the
+// suffix, of the topic with the given name are registered under.
+func (e *confluentAvroEncoder) subject(name, suffix string) string {
+	// NB: This uses the kafka name escaper because it has to match the name
+	// of the kafka topic.
+	return e.subjectPrefix + SQLNameToKafkaName(name) + suffix
 }
 
 func (e *confluentAvroEncoder) register(
@@ -579,6 +1348,37 @@ func (e *confluentAvroEncoder) register(
 	return e.schemaRegistry.RegisterSchemaForSubject(ctx, subject, schema.codec.Schema())
 }
 
+var (
+	// errAvroUnsupportedValue marks errors encoding a row whose datums cannot
+	// be represented under its avro schema.
+	errAvroUnsupportedValue = errors.New(`value cannot be encoded as avro`)
+	// errAvroSchemaRejected marks errors registering a schema which the schema
+	// registry rejected as invalid or incompatible with the subject.
+	errAvroSchemaRejected = errors.New(`schema rejected by schema registry`)
+)
+
+// Reasons for which rows are emitted to the dead letter sink.
+const (
+	deadLetterReasonUnsupportedValue = `unsupported_value`
+	deadLetterReasonSchemaRejected   = `schema_rejected`
+	deadLetterReasonInvalidTopic     = `invalid_topic`
+)
+
+// avroDeadLetterReason returns the reason for which a row which failed to be
+// encoded as avro with the given error should be dead lettered, if the error
+// was caused by the row itself rather than, for instance, an unavailable
+// schema registry.
+func avroDeadLetterReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errAvroUnsupportedValue):
+		return deadLetterReasonUnsupportedValue, true
+	case errors.Is(err, errAvroSchemaRejected):
+		return deadLetterReasonSchemaRejected, true
+	default:
+		return ``, false
+	}
+}
+
 // nativeEncoder only implements EncodeResolvedTimestamp.
 // Unfortunately, the encoder assumes that it operates with encodeRow -- something
 // that's just not the case when emitting raw KVs.
@@ -11,23 +11,36 @@ package changefeedccl
 import (
 	"context"
 	gosql "database/sql"
+	"encoding/binary"
+	gojson "encoding/json"
 	"fmt"
+	"math"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
 	"github.com/cockroachdb/cockroach/pkg/workload/ledger"
 	"github.com/cockroachdb/cockroach/pkg/workload/workloadsql"
+	"github.com/linkedin/goavro/v2"
 	"github.com/stretchr/testify/require"
 )
 
@@ -258,6 +271,392 @@ func TestEncoders(t *testing.T) {
 	}
 }
 
+func TestJSONEncoderTimestampFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{rowenc.EncDatum{Datum: tree.NewDInt(1)}}
+	ts := hlc.Timestamp{WallTime: 1646337600123456789, Logical: 3}
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+
+	for _, tc := range []struct {
+		format changefeedbase.TimestampFormat
+		// parse converts a rendered timestamp back into an HLC timestamp.
+		parse func(t *testing.T, s string) hlc.Timestamp
+		// expected is the timestamp recovered from the rendered timestamp.
+		expected hlc.Timestamp
+	}{
+		{
+			format: ``,
+			parse: func(t *testing.T, s string) hlc.Timestamp {
+				return parseTimeToHLC(t, s)
+			},
+			expected: ts,
+		},
+		{
+			format: changefeedbase.OptTimestampFormatHLC,
+			parse: func(t *testing.T, s string) hlc.Timestamp {
+				return parseTimeToHLC(t, s)
+			},
+			expected: ts,
+		},
+		{
+			format: changefeedbase.OptTimestampFormatRFC3339,
+			parse: func(t *testing.T, s string) hlc.Timestamp {
+				parsed, err := time.Parse(time.RFC3339Nano, s)
+				require.NoError(t, err)
+				return hlc.Timestamp{WallTime: parsed.UnixNano()}
+			},
+			// The logical component is not representable.
+			expected: hlc.Timestamp{WallTime: ts.WallTime},
+		},
+		{
+			format: changefeedbase.OptTimestampFormatUnixNanos,
+			parse: func(t *testing.T, s string) hlc.Timestamp {
+				nanos, err := strconv.ParseInt(s, 10, 64)
+				require.NoError(t, err)
+				return hlc.Timestamp{WallTime: nanos}
+			},
+			// The logical component is not representable.
+			expected: hlc.Timestamp{WallTime: ts.WallTime},
+		},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			opts := map[string]string{
+				changefeedbase.OptFormat:            string(changefeedbase.OptFormatJSON),
+				changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeWrapped),
+				changefeedbase.OptUpdatedTimestamps: ``,
+			}
+			if tc.format != `` {
+				opts[changefeedbase.OptTimestampFormat] = string(tc.format)
+			}
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+
+			value, err := e.EncodeValue(context.Background(), encodeRow{
+				datums:    row,
+				updated:   ts,
+				tableDesc: tableDesc,
+			})
+			require.NoError(t, err)
+			var valueRaw struct {
+				Updated string `json:"updated"`
+			}
+			require.NoError(t, gojson.Unmarshal(value, &valueRaw))
+			require.Equal(t, tc.expected, tc.parse(t, valueRaw.Updated))
+
+			resolved, err := e.EncodeResolvedTimestamp(context.Background(), tableDesc.GetName(), ts)
+			require.NoError(t, err)
+			var resolvedRaw struct {
+				Resolved string `json:"resolved"`
+			}
+			require.NoError(t, gojson.Unmarshal(resolved, &resolvedRaw))
+			require.Equal(t, tc.expected, tc.parse(t, resolvedRaw.Resolved))
+		})
+	}
+}
+
+func TestJSONEncoderEventTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY)`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{rowenc.EncDatum{Datum: tree.NewDInt(1)}}
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+	opts := map[string]string{
+		changefeedbase.OptFormat:    string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:  string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptEventTime: ``,
+	}
+	e, err := getEncoder(opts, targets)
+	require.NoError(t, err)
+
+	mvcc := hlc.Timestamp{WallTime: time.Date(2000, time.January, 1, 23, 59, 59, 123456789, time.UTC).UnixNano()}
+	value, err := e.EncodeValue(context.Background(), encodeRow{
+		datums:        row,
+		updated:       mvcc,
+		mvccTimestamp: mvcc,
+		tableDesc:     tableDesc,
+	})
+	require.NoError(t, err)
+	require.Equal(t, `{"after": {"a": 1}, "event_time": "2000-01-01T23:59:59.123456789Z"}`, string(value))
+}
+
+func TestJSONEncoderFloatSpecialValues(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b FLOAT, c FLOAT[])`)
+	require.NoError(t, err)
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+	rowWith := func(f float64) encodeRow {
+		arr := tree.NewDArray(types.Float)
+		require.NoError(t, arr.Append(tree.NewDFloat(1.5)))
+		require.NoError(t, arr.Append(tree.NewDFloat(tree.DFloat(f))))
+		return encodeRow{
+			datums: rowenc.EncDatumRow{
+				rowenc.EncDatum{Datum: tree.NewDInt(1)},
+				rowenc.EncDatum{Datum: tree.NewDFloat(tree.DFloat(f))},
+				rowenc.EncDatum{Datum: arr},
+			},
+			tableDesc: tableDesc,
+		}
+	}
+
+	specials := []float64{math.NaN(), math.Inf(1), math.Inf(-1)}
+
+	for _, tc := range []struct {
+		format changefeedbase.FloatSpecialValues
+		// expected is the JSON of the b column, or the expected error, for
+		// each of specials.
+		expected []string
+	}{
+		{
+			format: ``,
+			expected: []string{
+				`"NaN"`,
+				`"Infinity"`,
+				`"-Infinity"`,
+			},
+		},
+		{
+			format: changefeedbase.OptFloatSpecialValuesString,
+			expected: []string{
+				`"NaN"`,
+				`"Infinity"`,
+				`"-Infinity"`,
+			},
+		},
+		{
+			format: changefeedbase.OptFloatSpecialValuesNull,
+			expected: []string{
+				`null`,
+				`null`,
+				`null`,
+			},
+		},
+		{
+			format: changefeedbase.OptFloatSpecialValuesError,
+			expected: []string{
+				`NaN cannot be encoded as JSON with float_special_values=error`,
+				`\+Inf cannot be encoded as JSON with float_special_values=error`,
+				`-Inf cannot be encoded as JSON with float_special_values=error`,
+			},
+		},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			opts := map[string]string{
+				changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+				changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+			}
+			if tc.format != `` {
+				opts[changefeedbase.OptFloatSpecialValues] = string(tc.format)
+			}
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+
+			for i, expected := range tc.expected {
+				value, err := e.EncodeValue(context.Background(), rowWith(specials[i]))
+				if tc.format == changefeedbase.OptFloatSpecialValuesError {
+					require.Regexp(t, expected, err)
+					continue
+				}
+				require.NoError(t, err)
+				require.True(t, gojson.Valid(value), string(value))
+				require.Equal(t, fmt.Sprintf(`{"after": {"a": 1, "b": %s, "c": [1.5, %s]}}`, expected, expected),
+					string(value))
+			}
+
+			// Other floats are numbers.
+			value, err := e.EncodeValue(context.Background(), rowWith(2.5))
+			require.NoError(t, err)
+			require.Equal(t, `{"after": {"a": 1, "b": 2.5, "c": [1.5, 2.5]}}`, string(value))
+		})
+	}
+}
+
+func TestJSONEncoderKeyFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(
+		`CREATE TABLE foo (b STRING, a INT, c INT, PRIMARY KEY (b, a))`)
+	require.NoError(t, err)
+	row := rowenc.EncDatumRow{
+		rowenc.EncDatum{Datum: tree.NewDString(`x`)},
+		rowenc.EncDatum{Datum: tree.NewDInt(1)},
+		rowenc.EncDatum{Datum: tree.NewDInt(2)},
+	}
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+
+	for _, tc := range []struct {
+		format        changefeedbase.KeyFormat
+		expectedKey   string
+		expectedValue string
+	}{
+		{
+			format:        ``,
+			expectedKey:   `["x", 1]`,
+			expectedValue: `{"after": {"a": 1, "b": "x", "c": 2}, "key": ["x", 1]}`,
+		},
+		{
+			format:        changefeedbase.OptKeyFormatArray,
+			expectedKey:   `["x", 1]`,
+			expectedValue: `{"after": {"a": 1, "b": "x", "c": 2}, "key": ["x", 1]}`,
+		},
+		{
+			format:        changefeedbase.OptKeyFormatObject,
+			expectedKey:   `{"a": 1, "b": "x"}`,
+			expectedValue: `{"after": {"a": 1, "b": "x", "c": 2}, "key": {"a": 1, "b": "x"}}`,
+		},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			opts := map[string]string{
+				changefeedbase.OptFormat:     string(changefeedbase.OptFormatJSON),
+				changefeedbase.OptEnvelope:   string(changefeedbase.OptEnvelopeWrapped),
+				changefeedbase.OptKeyInValue: ``,
+			}
+			if tc.format != `` {
+				opts[changefeedbase.OptKeyFormat] = string(tc.format)
+			}
+			e, err := getEncoder(opts, targets)
+			require.NoError(t, err)
+
+			encRow := encodeRow{datums: row, tableDesc: tableDesc}
+			key, err := e.EncodeKey(context.Background(), encRow)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedKey, string(key))
+			value, err := e.EncodeValue(context.Background(), encRow)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedValue, string(value))
+		})
+	}
+
+	_, err = getEncoder(map[string]string{
+		changefeedbase.OptFormat:    string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope:  string(changefeedbase.OptEnvelopeDebezium),
+		changefeedbase.OptKeyFormat: string(changefeedbase.OptKeyFormatObject),
+	}, targets)
+	require.EqualError(t, err, `key_format=object is not supported with envelope=debezium`)
+}
+
+func TestPerTargetEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	logDesc, err := parseTableDesc(`CREATE TABLE log (a INT PRIMARY KEY)`)
+	require.NoError(t, err)
+	configProto := protoutil.Clone(logDesc.TableDesc()).(*descpb.TableDescriptor)
+	configProto.ID++
+	configProto.Name = `config`
+	configDesc := tabledesc.NewBuilder(configProto).BuildImmutableTable()
+
+	row := func(desc catalog.TableDescriptor) encodeRow {
+		return encodeRow{
+			datums:    rowenc.EncDatumRow{rowenc.EncDatum{Datum: tree.NewDInt(1)}},
+			updated:   hlc.Timestamp{WallTime: 1},
+			tableDesc: desc,
+		}
+	}
+	opts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	targets := jobspb.ChangefeedTargets{
+		logDesc.GetID(): jobspb.ChangefeedTarget{
+			StatementTimeName: `log`,
+			Opts:              map[string]string{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeKeyOnly)},
+		},
+		configDesc.GetID(): jobspb.ChangefeedTarget{
+			StatementTimeName: `config`,
+			Opts:              map[string]string{changefeedbase.OptUpdatedTimestamps: ``},
+		},
+	}
+	e, err := getEncoder(opts, targets)
+	require.NoError(t, err)
+
+	value, err := e.EncodeValue(ctx, row(logDesc))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	value, err = e.EncodeValue(ctx, row(configDesc))
+	require.NoError(t, err)
+	require.Equal(t, `{"after": {"a": 1}, "updated": "1.0000000000"}`, string(value))
+	key, err := e.EncodeKey(ctx, row(configDesc))
+	require.NoError(t, err)
+	require.Equal(t, `[1]`, string(key))
+
+	// Resolved timestamps are encoded with the changefeed's options.
+	resolved, err := e.EncodeResolvedTimestamp(ctx, ``, hlc.Timestamp{WallTime: 1})
+	require.NoError(t, err)
+	require.Equal(t, `{"resolved":"1.0000000000"}`, string(resolved))
+
+	// Overrides must be valid along with the changefeed's other options.
+	opts[changefeedbase.OptDiff] = ``
+	_, err = getEncoder(opts, targets)
+	require.EqualError(t, err, `options for table log: diff is only usable with envelope=wrapped`)
+}
+
+func TestResolvedSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+	f, err := span.MakeFrontier(sp(`a`, `b`), sp(`b`, `c`), sp(`c`, `d`), sp(`d`, `e`))
+	require.NoError(t, err)
+	for _, s := range []jobspb.ResolvedSpan{
+		{Span: sp(`a`, `b`), Timestamp: ts(3)},
+		{Span: sp(`b`, `c`), Timestamp: ts(3)},
+		{Span: sp(`c`, `d`), Timestamp: ts(1)},
+		{Span: sp(`d`, `e`), Timestamp: ts(2)},
+	} {
+		_, err := f.Forward(s.Span, s.Timestamp)
+		require.NoError(t, err)
+	}
+
+	// Adjacent spans resolved at the same timestamp are merged.
+	require.Equal(t, &frontierSpans{spans: []jobspb.ResolvedSpan{
+		{Span: sp(`a`, `c`), Timestamp: ts(3)},
+		{Span: sp(`c`, `d`), Timestamp: ts(1)},
+		{Span: sp(`d`, `e`), Timestamp: ts(2)},
+	}}, resolvedFrontierSpans(f, 3))
+
+	// Past the limit, only the spans furthest behind are kept.
+	require.Equal(t, &frontierSpans{spans: []jobspb.ResolvedSpan{
+		{Span: sp(`c`, `d`), Timestamp: ts(1)},
+		{Span: sp(`d`, `e`), Timestamp: ts(2)},
+	}, truncated: true}, resolvedFrontierSpans(f, 2))
+
+	opts := map[string]string{
+		changefeedbase.OptEnvelope:      string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptResolvedSpans: ``,
+	}
+	e, err := makeJSONEncoder(opts, jobspb.ChangefeedTargets{})
+	require.NoError(t, err)
+	resolved, err := makeResolvedEncoder(e, hlc.Timestamp{}, f).EncodeResolvedTimestamp(ctx, ``, ts(1))
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"resolved":"0.0000000001","spans_truncated":false,"spans":[`+
+		`{"start":%[1]q,"end":%[2]q,"resolved":"0.0000000003"},`+
+		`{"start":%[2]q,"end":%[3]q,"resolved":"0.0000000001"},`+
+		`{"start":%[3]q,"end":%[4]q,"resolved":"0.0000000002"}]}`,
+		roachpb.Key(`a`), roachpb.Key(`c`), roachpb.Key(`d`), roachpb.Key(`e`)), string(resolved))
+}
+
 func TestAvroEncoder(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -535,6 +934,33 @@ func TestAvroCollatedString(t *testing.T) {
 	t.Run(`kafka`, kafkaTest(testFn))
 }
 
+func TestAvroCollatedStringKey(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		// The collation is case insensitive, so 'Foo' and 'FOO' are the same key.
+		sqlDB.Exec(t, `CREATE TABLE foo (a STRING COLLATE "en-u-ks-level2" PRIMARY KEY, b INT)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES ('Foo' COLLATE "en-u-ks-level2", 1)`)
+
+		foo := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR foo `+
+			`WITH format=%s`,
+			changefeedbase.OptFormatAvro))
+		defer closeFeed(t, foo)
+		assertPayloads(t, foo, []string{
+			`foo: {"a":{"string":"Foo"}}->{"after":{"foo":{"a":{"string":"Foo"},"b":{"long":1}}}}`,
+		})
+
+		sqlDB.Exec(t, `UPSERT INTO foo VALUES ('FOO' COLLATE "en-u-ks-level2", 2)`)
+		assertPayloads(t, foo, []string{
+			`foo: {"a":{"string":"FOO"}}->{"after":{"foo":{"a":{"string":"FOO"},"b":{"long":2}}}}`,
+		})
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestAvroEnum(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -670,6 +1096,24 @@ func TestAvroSchemaNaming(t *testing.T) {
 		//Both changes to the subject are also reflected in the schema name in the posted schemas
 		require.Contains(t, foo.registry.SchemaForSubject(`supermovr.public.drivers-key`), `supermovr`)
 		require.Contains(t, foo.registry.SchemaForSubject(`supermovr.public.drivers-value`), `supermovr`)
+
+		// The subject prefix only changes the subjects, not the schemas.
+		subjectPrefixFeed := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR movr.drivers `+
+			`WITH format=%s, schema_registry_subject_prefix='staging.', avro_schema_prefix=super`,
+			changefeedbase.OptFormatAvro))
+		defer closeFeed(t, subjectPrefixFeed)
+
+		foo = subjectPrefixFeed.(*kafkaFeed)
+
+		assertPayloads(t, subjectPrefixFeed, []string{
+			`drivers: {"id":{"long":1}}->{"after":{"super.drivers":{"id":{"long":1},"name":{"string":"Alice"}}}}`,
+		})
+
+		assertRegisteredSubjects(t, foo.registry, []string{
+			`staging.superdrivers-key`,
+			`staging.superdrivers-value`,
+		})
+		require.NotContains(t, foo.registry.SchemaForSubject(`staging.superdrivers-value`), `staging`)
 	}
 
 	t.Run(`kafka`, kafkaTest(testFn))
@@ -717,6 +1161,183 @@ func TestAvroSchemaNamespace(t *testing.T) {
 	t.Run(`kafka`, kafkaTest(testFn))
 }
 
+func TestAvroNamespaceAndRecordName(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE DATABASE movr`)
+		sqlDB.Exec(t, `CREATE TABLE movr.drivers (id INT PRIMARY KEY, name STRING)`)
+		sqlDB.Exec(t, `INSERT INTO movr.drivers VALUES (1, 'Alice')`)
+
+		namedFeed := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR movr.drivers `+
+			`WITH format=%s, diff, avro_namespace='com.acme.{table}', avro_record_name='{table}_row'`,
+			changefeedbase.OptFormatAvro))
+		defer closeFeed(t, namedFeed)
+
+		assertPayloads(t, namedFeed, []string{
+			`drivers: {"id":{"long":1}}->{"after":{"com.acme.drivers.drivers_row":` +
+				`{"id":{"long":1},"name":{"string":"Alice"}}},"before":null}`,
+		})
+
+		// The namespace applies to every schema, and the record name to the
+		// records of the columns of rows. Subjects are unchanged.
+		foo := namedFeed.(*kafkaFeed)
+		require.Contains(t, foo.registry.SchemaForSubject(`drivers-key`), `"namespace":"com.acme.drivers"`)
+		valueSchema := foo.registry.SchemaForSubject(`drivers-value`)
+		require.Contains(t, valueSchema, `"namespace":"com.acme.drivers"`)
+		require.Contains(t, valueSchema, `"name":"drivers_row"`)
+		require.Contains(t, valueSchema, `"name":"drivers_row_before"`)
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+func TestAvroFixedColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE TABLE foo (a BYTES PRIMARY KEY, b BYTES, c BYTES)`)
+		sqlDB.Exec(t, `INSERT INTO foo VALUES ('ab', 'wxyz', 'v')`)
+
+		fixedFeed := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR foo `+
+			`WITH format=%s, diff, avro_fixed_columns='{"a": 2, "foo.b": 4}'`,
+			changefeedbase.OptFormatAvro))
+		defer closeFeed(t, fixedFeed)
+
+		// Values round trip through the schemas registered with the registry.
+		assertPayloads(t, fixedFeed, []string{
+			`foo: {"a":{"foo_a":"ab"}}->{"after":{"foo":{"a":{"foo_a":"ab"},"b":{"foo_b":"wxyz"},` +
+				`"c":{"bytes":"v"}}},"before":null}`,
+		})
+		sqlDB.Exec(t, `UPDATE foo SET c = NULL`)
+		assertPayloads(t, fixedFeed, []string{
+			`foo: {"a":{"foo_a":"ab"}}->{"after":{"foo":{"a":{"foo_a":"ab"},"b":{"foo_b":"wxyz"},"c":null}},` +
+				`"before":{"foo_before":{"a":{"foo_before_a":"ab"},"b":{"foo_before_b":"wxyz"},"c":{"bytes":"v"}}}}`,
+		})
+
+		foo := fixedFeed.(*kafkaFeed)
+		require.Contains(t, foo.registry.SchemaForSubject(`foo-key`), `{"type":"fixed","name":"foo_a","size":2}`)
+		require.Contains(t, foo.registry.SchemaForSubject(`foo-value`), `{"type":"fixed","name":"foo_b","size":4}`)
+
+		// Values of the wrong size can't be encoded.
+		sqlDB.Exec(t, `UPDATE foo SET b = 'xyz'`)
+		_, err := fixedFeed.Next()
+		require.Regexp(t, `value of 3 bytes of column b does not match the size 4 of avro fixed foo_b`, err)
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
+// TestAvroSchemaBuiltin verifies that crdb_internal.changefeed_avro_schema
+// returns the schemas a changefeed registers.
+func TestAvroSchemaFingerprint(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	reg := cdctest.StartTestSchemaRegistry()
+	defer reg.Close()
+	opts := map[string]string{
+		changefeedbase.OptFormat:                  string(changefeedbase.OptFormatAvro),
+		changefeedbase.OptEnvelope:                string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptConfluentSchemaRegistry: reg.URL(),
+		changefeedbase.OptSchemaFingerprint:       ``,
+	}
+	ts := hlc.Timestamp{WallTime: 1, Logical: 2}
+
+	// encode encodes a row of the table created by create with a new encoder,
+	// and returns the fingerprint from its header, after checking that it
+	// decodes with the schema registered for its value.
+	encode := func(create string, datums ...tree.Datum) uint64 {
+		t.Helper()
+		tableDesc, err := parseTableDesc(create)
+		require.NoError(t, err)
+		targets := jobspb.ChangefeedTargets{
+			tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+		}
+		e, err := getEncoder(opts, targets)
+		require.NoError(t, err)
+		var row rowenc.EncDatumRow
+		for _, d := range datums {
+			row = append(row, rowenc.EncDatum{Datum: d})
+		}
+		value, err := e.EncodeValue(context.Background(), encodeRow{
+			datums: row, updated: ts, tableDesc: tableDesc,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xc3, 0x01}, value[:2])
+
+		codec, err := goavro.NewCodec(reg.SchemaForSubject(`foo-value`))
+		require.NoError(t, err)
+		require.Equal(t, codec.Rabin, binary.LittleEndian.Uint64(value[2:10]))
+		_, _, err = codec.NativeFromSingle(value)
+		require.NoError(t, err)
+		return codec.Rabin
+	}
+
+	// The fingerprint is stable for identical schemas, whatever their rows,
+	// and changes when they evolve.
+	fingerprint := encode(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`,
+		tree.NewDInt(1), tree.NewDString(`bar`))
+	require.Equal(t, fingerprint, encode(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`,
+		tree.NewDInt(2), tree.NewDString(`baz`)))
+	require.NotEqual(t, fingerprint, encode(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c INT)`,
+		tree.NewDInt(1), tree.NewDString(`bar`), tree.NewDInt(3)))
+}
+
+func TestAvroSchemaBuiltin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testFn := func(t *testing.T, db *gosql.DB, f cdctest.TestFeedFactory) {
+		sqlDB := sqlutils.MakeSQLRunner(db)
+		sqlDB.Exec(t, `CREATE DATABASE movr`)
+		sqlDB.Exec(t, `CREATE TABLE movr.drivers (id INT PRIMARY KEY, name STRING)`)
+		sqlDB.Exec(t, `INSERT INTO movr.drivers VALUES (1, 'Alice')`)
+
+		for _, tc := range []struct {
+			with, opts, subject string
+		}{
+			{with: ``, opts: `{}`, subject: `drivers`},
+			{
+				with:    `, diff, updated, avro_schema_prefix=super, full_table_name`,
+				opts:    `{"diff": null, "updated": null, "avro_schema_prefix": "super", "full_table_name": null}`,
+				subject: `supermovr.public.drivers`,
+			},
+			{
+				with:    `, schema_registry_subject_prefix='staging.'`,
+				opts:    `{"schema_registry_subject_prefix": "staging."}`,
+				subject: `staging.drivers`,
+			},
+		} {
+			testFeed := feed(t, f, fmt.Sprintf(`CREATE CHANGEFEED FOR movr.drivers WITH format=%s%s`,
+				changefeedbase.OptFormatAvro, tc.with))
+			// Wait for the schemas to be registered.
+			_, err := readNextMessages(testFeed, 1)
+			require.NoError(t, err)
+			closeFeed(t, testFeed)
+			registry := testFeed.(*kafkaFeed).registry
+
+			var keySubject, keySchema, valueSubject, valueSchema string
+			sqlDB.QueryRow(t, `SELECT s->>'key_subject', s->>'key_schema', s->>'value_subject', s->>'value_schema'
+FROM (SELECT crdb_internal.changefeed_avro_schema('movr.drivers', $1) AS s)`, tc.opts,
+			).Scan(&keySubject, &keySchema, &valueSubject, &valueSchema)
+			require.Equal(t, tc.subject+`-key`, keySubject)
+			require.Equal(t, tc.subject+`-value`, valueSubject)
+			require.JSONEq(t, registry.SchemaForSubject(keySubject), keySchema)
+			require.JSONEq(t, registry.SchemaForSubject(valueSubject), valueSchema)
+		}
+
+		sqlDB.ExpectErr(t, `Avro schemas are not generated with format=json`,
+			`SELECT crdb_internal.changefeed_avro_schema('movr.drivers', '{"format": "json"}')`)
+	}
+
+	t.Run(`kafka`, kafkaTest(testFn))
+}
+
 func TestTableNameCollision(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
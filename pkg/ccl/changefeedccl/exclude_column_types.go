@@ -0,0 +1,175 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// excludedColumnTypes are the types of the columns which the
+// exclude_column_types option drops from the rows of a changefeed, e.g. large
+// BYTES columns which its consumers never read. A column is dropped if its
+// type is equivalent to one of them, regardless of width or precision, so
+// STRING drops VARCHAR(10) columns as well. Primary key columns are never
+// dropped, since they key the rows.
+//
+// The columns are dropped by projecting the rows of each version of a table
+// like the rows of a view over it, so the changefeed emits them as if the
+// table didn't have the columns, and the options naming columns of the table
+// can't name dropped columns.
+type excludedColumnTypes []*types.T
+
+// parseExcludedColumnTypes parses the value of the exclude_column_types
+// option, a comma separated list of the names of built-in types.
+func parseExcludedColumnTypes(s string) (excludedColumnTypes, error) {
+	var excluded excludedColumnTypes
+	for _, name := range strings.Split(s, `,`) {
+		name = strings.TrimSpace(name)
+		if name == `` {
+			return nil, errors.Errorf(`%s must be a comma separated list of types: %q`,
+				changefeedbase.OptExcludeColumnTypes, s)
+		}
+		ref, err := parser.GetTypeFromValidSQLSyntax(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, `parsing %s type %q`, changefeedbase.OptExcludeColumnTypes, name)
+		}
+		typ, ok := tree.GetStaticallyKnownType(ref)
+		if !ok {
+			return nil, errors.Errorf(`%s type %q is not a built-in type`,
+				changefeedbase.OptExcludeColumnTypes, name)
+		}
+		excluded = append(excluded, typ)
+	}
+	return excluded, nil
+}
+
+// excludes returns whether the column is dropped.
+func (e excludedColumnTypes) excludes(col catalog.Column) bool {
+	for _, typ := range e {
+		if col.GetType().Equivalent(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// project returns a descriptor of the rows of a version of a table without
+// the columns it drops, along with the ordinals of the public columns of the
+// table which make up the columns of its rows, or a nil descriptor if it
+// doesn't drop any column of the table. Tables whose rows would be left
+// with nothing but their primary key, and so with a value which can't be
+// told apart from their key, are rejected.
+func (e excludedColumnTypes) project(
+	table catalog.TableDescriptor,
+) (catalog.TableDescriptor, []int, error) {
+	primaryIndex := table.GetPrimaryIndex()
+	isKey := make(map[descpb.ColumnID]struct{}, primaryIndex.NumKeyColumns())
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		isKey[primaryIndex.GetKeyColumnID(i)] = struct{}{}
+	}
+
+	projection := &jobspb.ChangefeedView{ID: table.GetID(), Name: table.GetName()}
+	var ordinals []int
+	var dropped, keptValues int
+	for i, col := range table.PublicColumns() {
+		_, key := isKey[col.GetID()]
+		if !key {
+			if e.excludes(col) {
+				dropped++
+				continue
+			}
+			keptValues++
+		}
+		projection.Columns = append(projection.Columns, jobspb.ChangefeedView_Column{
+			Name:     col.GetName(),
+			ColumnID: col.GetID(),
+		})
+		ordinals = append(ordinals, i)
+	}
+	if dropped == 0 {
+		return nil, nil, nil
+	}
+	if keptValues == 0 {
+		return nil, nil, errors.Errorf(
+			`%s drops every column of table %s outside of its primary key`,
+			changefeedbase.OptExcludeColumnTypes, table.GetName())
+	}
+	projected, err := projectViewTable(table, projection)
+	if err != nil {
+		return nil, nil, err
+	}
+	return projected, ordinals, nil
+}
+
+// columnTypeExcluder drops the columns of the excluded types from the rows
+// of a changefeed.
+type columnTypeExcluder struct {
+	excluded excludedColumnTypes
+	// projections are the projections of each version of the tables, which
+	// are nil for the versions without any dropped column.
+	projections map[tableIDAndVersion]*viewProjection
+}
+
+func makeColumnTypeExcluder(excluded excludedColumnTypes) *columnTypeExcluder {
+	return &columnTypeExcluder{
+		excluded:    excluded,
+		projections: make(map[tableIDAndVersion]*viewProjection),
+	}
+}
+
+// exclude drops the columns of the excluded types from the row, and from its
+// previous version if any.
+func (c *columnTypeExcluder) exclude(r *encodeRow) error {
+	var err error
+	if r.tableDesc, r.datums, err = c.excludeFromRow(r.tableDesc, r.datums); err != nil {
+		return err
+	}
+	if r.prevTableDesc != nil {
+		r.prevTableDesc, r.prevDatums, err = c.excludeFromRow(r.prevTableDesc, r.prevDatums)
+	}
+	return err
+}
+
+func (c *columnTypeExcluder) excludeFromRow(
+	desc catalog.TableDescriptor, datums rowenc.EncDatumRow,
+) (catalog.TableDescriptor, rowenc.EncDatumRow, error) {
+	key := makeTableIDAndVersion(desc.GetID(), desc.GetVersion())
+	p, ok := c.projections[key]
+	if !ok {
+		projected, ordinals, err := c.excluded.project(desc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if projected != nil {
+			p = &viewProjection{desc: projected, ordinals: ordinals}
+		}
+		c.projections[key] = p
+	}
+	if p == nil {
+		return desc, datums, nil
+	}
+	if datums == nil {
+		return p.desc, nil, nil
+	}
+	projected := make(rowenc.EncDatumRow, len(p.ordinals))
+	for i, ord := range p.ordinals {
+		projected[i] = datums[ord]
+	}
+	return p.desc, projected, nil
+}
@@ -281,6 +281,39 @@ func extractResolvedTimestamp(t testing.TB, m *cdctest.TestFeedMessage) hlc.Time
 	return parseTimeToHLC(t, resolvedRaw.Resolved)
 }
 
+// expectResolvedTimestampWindow is like expectResolvedTimestamp for
+// changefeeds with the resolved_window option, additionally asserting that
+// the previous resolved timestamp in the payload is expectedPrevious, or null
+// if it's empty.
+func expectResolvedTimestampWindow(
+	t testing.TB, f cdctest.TestFeed, expectedPrevious hlc.Timestamp,
+) hlc.Timestamp {
+	t.Helper()
+	m, err := f.Next()
+	if err != nil {
+		t.Fatal(err)
+	} else if m == nil {
+		t.Fatal(`expected message`)
+	}
+	resolved := extractResolvedTimestamp(t, m)
+
+	var windowRaw struct {
+		PreviousResolved *string `json:"previous_resolved"`
+	}
+	if err := gojson.Unmarshal(m.Resolved, &windowRaw); err != nil {
+		t.Fatal(err)
+	}
+	var previous hlc.Timestamp
+	if windowRaw.PreviousResolved != nil {
+		previous = parseTimeToHLC(t, *windowRaw.PreviousResolved)
+	}
+	if previous != expectedPrevious {
+		t.Fatalf(`expected previous resolved timestamp %s got %s: %s`,
+			expectedPrevious, previous, m.Resolved)
+	}
+	return resolved
+}
+
 func expectResolvedTimestampAvro(t testing.TB, f cdctest.TestFeed) hlc.Timestamp {
 	t.Helper()
 	m, err := f.Next()
@@ -0,0 +1,85 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+const (
+	// defaultDNSCacheTTL is how long the addresses of the hosts of HTTP sinks
+	// and schema registries are cached for, unless their dns_cache_ttl
+	// parameter says otherwise.
+	defaultDNSCacheTTL = 30 * time.Second
+	// sinkHTTPMaxIdleConnsPerHost bounds the idle connections kept open to
+	// each host, which must be at least the number of requests a sink sends
+	// at once for all of them to reuse connections.
+	sinkHTTPMaxIdleConnsPerHost = 64
+	// sinkHTTPIdleConnTimeout is how long an idle connection is kept open.
+	sinkHTTPIdleConnTimeout = 90 * time.Second
+)
+
+// sinkHTTPStats counts the connections of the HTTP clients of all the sinks
+// and schema registries of the node, and the lookups of their hosts. They're
+// exported by the changefeed.http.* metrics.
+var sinkHTTPStats = struct {
+	connsOpened  *metric.Counter
+	connsReused  *metric.Counter
+	dnsLookups   *metric.Counter
+	dnsCacheHits *metric.Counter
+}{
+	connsOpened:  metric.NewCounter(metaChangefeedHTTPConnsOpened),
+	connsReused:  metric.NewCounter(metaChangefeedHTTPConnsReused),
+	dnsLookups:   metric.NewCounter(metaChangefeedHTTPDNSLookups),
+	dnsCacheHits: metric.NewCounter(metaChangefeedHTTPDNSCacheHits),
+}
+
+// sinkHTTPTransport is the transport of the HTTP clients of sinks and schema
+// registries. Unlike the transport of httputil.DefaultClient, it keeps the
+// connections of long-running changefeeds open between requests, and caches
+// the addresses of their hosts rather than resolving them for each
+// connection. Requests sent over reused connections are counted by
+// sinkHTTPStats.
+type sinkHTTPTransport struct {
+	*http.Transport
+}
+
+// newSinkHTTPTransport returns a sinkHTTPTransport whose connections time out
+// after dialTimeout, and which caches the addresses of hosts for dnsCacheTTL,
+// or resolves them for each connection if it's zero.
+func newSinkHTTPTransport(dialTimeout, dnsCacheTTL time.Duration) *sinkHTTPTransport {
+	dialer := httputil.NewCachingDialer(&net.Dialer{Timeout: dialTimeout}, dnsCacheTTL, httputil.DialerStats{
+		ConnsOpened:  sinkHTTPStats.connsOpened,
+		DNSLookups:   sinkHTTPStats.dnsLookups,
+		DNSCacheHits: sinkHTTPStats.dnsCacheHits,
+	})
+	return &sinkHTTPTransport{Transport: &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConnsPerHost: sinkHTTPMaxIdleConnsPerHost,
+		IdleConnTimeout:     sinkHTTPIdleConnTimeout,
+	}}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *sinkHTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				sinkHTTPStats.connsReused.Inc(1)
+			}
+		},
+	}
+	return t.Transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// idempotencyTokenField is the metadata field, and the field of the Avro
+// envelope, which holds the idempotency token of a row for the
+// idempotency_token option.
+const idempotencyTokenField = `idempotency_token`
+
+// makeIdempotencyToken returns the idempotency token of the version of the
+// row with the given KV key written at the given MVCC timestamp: the hex
+// encoded SHA-256 of the key of the row, without its column family suffix,
+// followed by the wall time and logical component of the timestamp, both big
+// endian.
+//
+// The token is computed from the KVs of the row rather than from the encoded
+// message, so it's the same whenever the changefeed emits the row version,
+// including after restarts and from the KVs of any of its column families,
+// whatever the format and envelope of the message. Consumers applying
+// changes as upserts can use it to recognize the versions they've already
+// applied. The key holds the IDs of the table and of its primary index, so
+// tokens are only stable as long as those are, which isn't the case of
+// tables restored into another cluster.
+func makeIdempotencyToken(key roachpb.Key, mvcc hlc.Timestamp) (string, error) {
+	rowKey, err := keys.EnsureSafeSplitKey(key)
+	if err != nil {
+		return ``, err
+	}
+	var ts [12]byte
+	binary.BigEndian.PutUint64(ts[:8], uint64(mvcc.WallTime))
+	binary.BigEndian.PutUint32(ts[8:], uint32(mvcc.Logical))
+	h := sha256.New()
+	h.Write(rowKey)
+	h.Write(ts[:])
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
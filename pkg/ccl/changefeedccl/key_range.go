@@ -0,0 +1,133 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+// keyRangeSpan returns the span of the primary index of a table watched with
+// the key_range option, which restricts a changefeed to the rows whose primary
+// keys are in a range, so that several changefeeds can each watch a part of a
+// large table.
+//
+// The option is a tuple of a lower and an upper bound, e.g. '(100, 200)'. The
+// lower bound is inclusive and the upper bound exclusive, in the order of the
+// primary index, and either may be NULL for a range which is unbounded on
+// that side. A bound is a value of the first column of the primary key, or a
+// tuple of values of the first columns of the primary key, which bounds the
+// rows whose primary keys start with them. Ranges which don't overlap can be
+// watched by separate changefeeds without them emitting the same rows.
+func keyRangeSpan(
+	ctx context.Context,
+	evalCtx *tree.EvalContext,
+	codec keys.SQLCodec,
+	tableDesc catalog.TableDescriptor,
+	keyRange string,
+) (roachpb.Span, error) {
+	span := tableDesc.PrimaryIndexSpan(codec)
+	expr, err := parser.ParseExpr(keyRange)
+	if err != nil {
+		return roachpb.Span{}, errors.Wrapf(err, `parsing %s`, changefeedbase.OptKeyRange)
+	}
+	bounds, ok := expr.(*tree.Tuple)
+	if !ok || len(bounds.Exprs) != 2 {
+		return roachpb.Span{}, errors.Errorf(
+			`%s must be a tuple of a lower and an upper bound, e.g. '(100, 200)': %q`,
+			changefeedbase.OptKeyRange, keyRange)
+	}
+	if bounds.Exprs[0] != tree.DNull {
+		if span.Key, err = keyRangeBound(ctx, evalCtx, codec, tableDesc, bounds.Exprs[0]); err != nil {
+			return roachpb.Span{}, err
+		}
+	}
+	if bounds.Exprs[1] != tree.DNull {
+		if span.EndKey, err = keyRangeBound(ctx, evalCtx, codec, tableDesc, bounds.Exprs[1]); err != nil {
+			return roachpb.Span{}, err
+		}
+	}
+	if !span.Valid() {
+		return roachpb.Span{}, errors.Errorf(
+			`the lower bound of %s must be less than its upper bound: %q`,
+			changefeedbase.OptKeyRange, keyRange)
+	}
+	return span, nil
+}
+
+// keyRangeBound returns the primary index key which a bound of the key_range
+// option is encoded as: the prefix of the keys of the rows whose primary keys
+// start with the values of the bound.
+func keyRangeBound(
+	ctx context.Context,
+	evalCtx *tree.EvalContext,
+	codec keys.SQLCodec,
+	tableDesc catalog.TableDescriptor,
+	bound tree.Expr,
+) (roachpb.Key, error) {
+	exprs := []tree.Expr{bound}
+	if tuple, ok := bound.(*tree.Tuple); ok {
+		exprs = tuple.Exprs
+	}
+	index := tableDesc.GetPrimaryIndex()
+	if len(exprs) > index.NumKeyColumns() {
+		return nil, errors.Errorf(`%s bound %s has more values than the %d columns of the primary key of %s`,
+			changefeedbase.OptKeyRange, bound, index.NumKeyColumns(), tableDesc.GetName())
+	}
+
+	semaCtx := tree.MakeSemaContext()
+	var colMap catalog.TableColMap
+	values := make([]tree.Datum, len(exprs))
+	for i, expr := range exprs {
+		col, err := tableDesc.FindColumnWithID(index.GetKeyColumnID(i))
+		if err != nil {
+			return nil, err
+		}
+		typedExpr, err := tree.TypeCheck(ctx, expr, &semaCtx, col.GetType())
+		if err != nil {
+			return nil, errors.Wrapf(err, `%s bound %s`, changefeedbase.OptKeyRange, bound)
+		}
+		if !tree.IsConst(evalCtx, typedExpr) {
+			return nil, errors.Errorf(`%s bound %s must be constant`, changefeedbase.OptKeyRange, bound)
+		}
+		if values[i], err = typedExpr.Eval(evalCtx); err != nil {
+			return nil, errors.Wrapf(err, `%s bound %s`, changefeedbase.OptKeyRange, bound)
+		}
+		if values[i] == tree.DNull {
+			return nil, errors.Errorf(`%s bound %s cannot contain NULL`, changefeedbase.OptKeyRange, bound)
+		}
+		colMap.Set(col.GetID(), i)
+	}
+	keyPrefix := rowenc.MakeIndexKeyPrefix(codec, tableDesc.GetID(), index.GetID())
+	key, _, err := rowenc.EncodePartialIndexKey(index, len(values), colMap, values, keyPrefix)
+	return key, err
+}
+
+// hasKeyRange returns whether the key_range option is set for any of the
+// targets.
+func hasKeyRange(targets jobspb.ChangefeedTargets, opts map[string]string) bool {
+	if _, ok := opts[changefeedbase.OptKeyRange]; ok {
+		return true
+	}
+	for _, target := range targets {
+		if _, ok := target.Opts[changefeedbase.OptKeyRange]; ok {
+			return true
+		}
+	}
+	return false
+}
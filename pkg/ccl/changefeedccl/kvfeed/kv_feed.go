@@ -63,6 +63,41 @@ type Config struct {
 	// be produced.
 	InitialHighWater hlc.Timestamp
 
+	// If true, the feed stops after the initial scan, resolving all of the
+	// spans at the InitialHighWater as an EXIT boundary.
+	InitialScanOnly bool
+
+	// If true, the initial scan exports its spans one at a time in key order,
+	// rather than concurrently, so that the rows of each span are written to
+	// the Writer in primary key order. Backfills and the changes which follow
+	// the initial scan are unordered.
+	InitialScanOrdered bool
+
+	// InitialScanConcurrency, if nonzero, is the number of spans the initial
+	// scan exports concurrently, capped by the
+	// changefeed.backfill.max_initial_scan_concurrency setting. Backfills use
+	// the changefeed.backfill.concurrent_scan_requests setting regardless.
+	InitialScanConcurrency int
+
+	// ScanRequestBatchBytes is the target size of the response to each
+	// ScanRequest issued by the initial scan and backfills. If zero, a default
+	// of 16 MiB is used.
+	ScanRequestBatchBytes int64
+
+	// ScanChunkBytes, if nonzero, bounds the bytes read from each range by
+	// the initial scan and backfills before the rest of the range is scanned
+	// as a separate unit, independently of range boundaries.
+	ScanChunkBytes int64
+
+	// OnScanThroughput, if set, is called with the throughput of the initial
+	// scan or backfill in progress, in bytes per second, and with 0 once it
+	// finishes.
+	OnScanThroughput func(bytesPerSec int64)
+
+	// OnSpanScan, if set, is called as the initial scan or a backfill starts
+	// exporting each span, and the function it returns once it's done.
+	OnSpanScan func() func()
+
 	// Knobs are kvfeed testing knobs.
 	Knobs TestingKnobs
 }
@@ -74,9 +109,13 @@ func Run(ctx context.Context, cfg Config) error {
 	var sc kvScanner
 	{
 		sc = &scanRequestScanner{
-			settings: cfg.Settings,
-			gossip:   cfg.Gossip,
-			db:       cfg.DB,
+			settings:     cfg.Settings,
+			gossip:       cfg.Gossip,
+			db:           cfg.DB,
+			targetBytes:  cfg.ScanRequestBatchBytes,
+			chunkBytes:   cfg.ScanChunkBytes,
+			onThroughput: cfg.OnScanThroughput,
+			onSpanScan:   cfg.OnSpanScan,
 		}
 	}
 	var pff physicalFeedFactory
@@ -100,11 +139,14 @@ func Run(ctx context.Context, cfg Config) error {
 		cfg.SchemaFeed,
 		sc, pff, bf, cfg.Knobs)
 	f.onBackfillCallback = cfg.OnBackfillCallback
+	f.initialScanOnly = cfg.InitialScanOnly
+	f.initialScanOrdered = cfg.InitialScanOrdered
+	f.initialScanConcurrency = cfg.InitialScanConcurrency
 
 	g := ctxgroup.WithContext(ctx)
 	g.GoCtx(cfg.SchemaFeed.Run)
 	g.GoCtx(f.run)
-	err := g.Wait()
+	err := explainGCError(g.Wait(), cfg.WithDiff)
 
 	// NB: The higher layers of the changefeed should detect the boundary and the
 	// policy and tear everything down. Returning before the higher layers tear down
@@ -113,14 +155,16 @@ func Run(ctx context.Context, cfg Config) error {
 	// changefeedAggregator to exit even if all values haven't been read out of the
 	// provided buffer.
 	var scErr schemaChangeDetectedError
-	if !errors.As(err, &scErr) {
+	if errors.As(err, &scErr) {
+		log.Infof(ctx, "stopping kv feed due to schema change at %v", scErr.ts)
+	} else if errors.Is(err, errInitialScanCompleted) {
+		log.Infof(ctx, "stopping kv feed after initial scan at %v", cfg.InitialHighWater)
+	} else {
 		// Regardless of whether we exited KV feed with or without an error, that error
 		// is not a schema change; so, close the writer and return.
 		return errors.CombineErrors(err, f.writer.CloseWithReason(ctx, err))
 	}
 
-	log.Infof(ctx, "stopping kv feed due to schema change at %v", scErr.ts)
-
 	// Drain the writer before we close it so that all events emitted prior to schema change
 	// boundary are consumed by the change aggregator.
 	// Regardless of whether drain succeeds, we must also close the buffer to release
@@ -137,6 +181,25 @@ func Run(ctx context.Context, cfg Config) error {
 	return err
 }
 
+// explainGCError makes the errors of the scans and rangefeeds of data which
+// was garbage collected before the changefeed could read it actionable.
+// Changefeeds which emit the previous values of rows need the versions
+// preceding the changes they emit, not only the changes themselves.
+func explainGCError(err error, withDiff bool) error {
+	if !errors.HasType(err, (*roachpb.BatchTimestampBeforeGCError)(nil)) {
+		return err
+	}
+	if withDiff {
+		err = errors.Wrap(err, `the data the changefeed reads, including the versions of rows `+
+			`preceding the changes it emits, was garbage collected`)
+	} else {
+		err = errors.Wrap(err, `the data the changefeed reads was garbage collected`)
+	}
+	return errors.WithHint(err, `the changefeed must be restarted from a more recent cursor; `+
+		`to keep it from falling behind the garbage collection of its tables, raise their `+
+		`gc.ttlseconds or create it with the protect_data_from_gc_on_pause option`)
+}
+
 // schemaChangeDetectedError is a sentinel error to indicate to Run() that the
 // schema change is stopping due to a schema change. This is handy to trigger
 // the context group to stop; the error is handled entirely in this package.
@@ -148,6 +211,10 @@ func (e schemaChangeDetectedError) Error() string {
 	return fmt.Sprintf("schema change detected at %v", e.ts)
 }
 
+// errInitialScanCompleted is a sentinel error to indicate to Run() that the
+// feed is stopping because it only performs an initial scan, which completed.
+var errInitialScanCompleted = errors.New("initial scan completed")
+
 type kvFeed struct {
 	spans               []roachpb.Span
 	checkpoint          []roachpb.Span
@@ -157,9 +224,12 @@ type kvFeed struct {
 	writer              kvevent.Writer
 	codec               keys.SQLCodec
 
-	onBackfillCallback func() func()
-	schemaChangeEvents changefeedbase.SchemaChangeEventClass
-	schemaChangePolicy changefeedbase.SchemaChangePolicy
+	onBackfillCallback     func() func()
+	initialScanOnly        bool
+	initialScanOrdered     bool
+	initialScanConcurrency int
+	schemaChangeEvents     changefeedbase.SchemaChangeEventClass
+	schemaChangePolicy     changefeedbase.SchemaChangePolicy
 
 	// These dependencies are made available for test injection.
 	bufferFactory func() kvevent.Buffer
@@ -213,18 +283,36 @@ func (f *kvFeed) run(ctx context.Context) (err error) {
 			return err
 		}
 
+		// The initial scan, if needed, happened at the highWater, which the spans
+		// are resolved at as the boundary the changefeed exits at.
+		if f.initialScanOnly {
+			for _, sp := range f.spans {
+				if err := f.writer.Add(
+					ctx,
+					kvevent.MakeResolvedEvent(sp, highWater, jobspb.ResolvedSpan_EXIT),
+				); err != nil {
+					return err
+				}
+			}
+			return errInitialScanCompleted
+		}
+
 		highWater, err = f.runUntilTableEvent(ctx, highWater)
 		if err != nil {
 			return err
 		}
 
 		boundaryType := jobspb.ResolvedSpan_BACKFILL
-		if f.schemaChangePolicy == changefeedbase.OptSchemaChangePolicyStop {
+		if events, err := f.tableFeed.Peek(ctx, highWater.Next()); err != nil {
+			return err
+		} else if isTargetDrop(events) {
+			// Whatever the schema change policy, the changefeed restarts
+			// without the dropped targets, per its on_target_drop policy.
+			boundaryType = jobspb.ResolvedSpan_RESTART
+		} else if f.schemaChangePolicy == changefeedbase.OptSchemaChangePolicyStop {
 			boundaryType = jobspb.ResolvedSpan_EXIT
-		} else if events, err := f.tableFeed.Peek(ctx, highWater.Next()); err == nil && isPrimaryKeyChange(events) {
+		} else if isPrimaryKeyChange(events) {
 			boundaryType = jobspb.ResolvedSpan_RESTART
-		} else if err != nil {
-			return err
 		}
 		// Resolve all of the spans as a boundary if the policy indicates that
 		// we should do so.
@@ -256,6 +344,15 @@ func isPrimaryKeyChange(events []schemafeed.TableEvent) bool {
 	return false
 }
 
+func isTargetDrop(events []schemafeed.TableEvent) bool {
+	for _, ev := range events {
+		if schemafeed.IsTargetDrop(ev) {
+			return true
+		}
+	}
+	return false
+}
+
 // filterCheckpointSpans filters spans which have already been completed,
 // and returns the list of spans that still need to be done.
 func filterCheckpointSpans(spans []roachpb.Span, completed []roachpb.Span) []roachpb.Span {
@@ -332,12 +429,17 @@ func (f *kvFeed) scanIfShould(
 		defer f.onBackfillCallback()()
 	}
 
-	if err := f.scanner.Scan(ctx, f.writer, physicalConfig{
+	cfg := physicalConfig{
 		Spans:     spansToBackfill,
 		Timestamp: scanTime,
 		WithDiff:  !isInitialScan && f.withDiff,
+		Ordered:   isInitialScan && f.initialScanOrdered,
 		Knobs:     f.knobs,
-	}); err != nil {
+	}
+	if isInitialScan {
+		cfg.Concurrency = f.initialScanConcurrency
+	}
+	if err := f.scanner.Scan(ctx, f.writer, cfg); err != nil {
 		return err
 	}
 
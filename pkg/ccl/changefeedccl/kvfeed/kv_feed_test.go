@@ -31,6 +31,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -422,3 +423,25 @@ func tableSpan(tableID uint32) roachpb.Span {
 		EndKey: keys.SystemSQLCodec.TablePrefix(tableID).PrefixEnd(),
 	}
 }
+
+func TestExplainGCError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	gcErr := roachpb.NewError(&roachpb.BatchTimestampBeforeGCError{
+		Timestamp: hlc.Timestamp{WallTime: 1},
+		Threshold: hlc.Timestamp{WallTime: 2},
+	}).GoError()
+
+	err := explainGCError(gcErr, false /* withDiff */)
+	require.Regexp(t, `the data the changefeed reads was garbage collected: `+
+		`batch timestamp .* must be after replica GC threshold`, err)
+	require.Contains(t, errors.FlattenHints(err), `gc.ttlseconds`)
+
+	err = explainGCError(errors.Wrap(gcErr, `scanning`), true /* withDiff */)
+	require.Regexp(t, `including the versions of rows preceding the changes it emits`, err)
+	require.True(t, errors.HasType(err, (*roachpb.BatchTimestampBeforeGCError)(nil)))
+
+	other := errors.New(`boom`)
+	require.Equal(t, other, explainGCError(other, true /* withDiff */))
+	require.NoError(t, explainGCError(nil, true /* withDiff */))
+}
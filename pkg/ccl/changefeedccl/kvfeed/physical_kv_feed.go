@@ -29,7 +29,14 @@ type physicalConfig struct {
 	Spans     []roachpb.Span
 	Timestamp hlc.Timestamp
 	WithDiff  bool
-	Knobs     TestingKnobs
+	// Ordered, if set, makes scans export their spans one at a time in key
+	// order. It is ignored by rangefeeds.
+	Ordered bool
+	// Concurrency, if nonzero, is the number of spans scans export
+	// concurrently, rather than the number derived from the size of the
+	// cluster. It is ignored by rangefeeds.
+	Concurrency int
+	Knobs       TestingKnobs
 }
 
 type rangefeedFactory func(
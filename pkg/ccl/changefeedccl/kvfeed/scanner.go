@@ -39,14 +39,38 @@ type kvScanner interface {
 	Scan(ctx context.Context, sink kvevent.Writer, cfg physicalConfig) error
 }
 
+// defaultTargetBytesPerScan is the target size of the response to each
+// ScanRequest, unless overridden with the scan_request_batch_bytes option.
+const defaultTargetBytesPerScan = 16 << 20 // 16 MiB
+
 type scanRequestScanner struct {
 	settings *cluster.Settings
 	gossip   gossip.OptionalGossip
 	db       *kv.DB
+	// targetBytes is the target size of the response to each ScanRequest. If
+	// zero, defaultTargetBytesPerScan is used.
+	targetBytes int64
+	// chunkBytes, if nonzero, bounds the bytes read by each scan unit. See
+	// Scan.
+	chunkBytes int64
+	// onThroughput, if set, is called with the throughput of each scan, in
+	// bytes per second, as it progresses, and with 0 once it finishes.
+	onThroughput func(bytesPerSec int64)
+	// onSpanScan, if set, is called as each span starts being exported, and
+	// the function it returns once the span is done.
+	onSpanScan func() func()
 }
 
 var _ kvScanner = (*scanRequestScanner)(nil)
 
+// Scan exports the spans of cfg, split at range boundaries, concurrently. If
+// chunkBytes is set, the span of each range is exported in units of at most
+// about chunkBytes, independently of the size of the range: once a unit has
+// read chunkBytes, the span it read is resolved and the rest of the range's
+// span is exported as a new unit, which waits for the spans already waiting
+// to be exported, so that scanning a large range doesn't hold on to one of
+// the concurrent scans for the whole range. Each ScanRequest then reads at
+// most chunkBytes.
 func (p *scanRequestScanner) Scan(
 	ctx context.Context, sink kvevent.Writer, cfg physicalConfig,
 ) error {
@@ -66,10 +90,37 @@ func (p *scanRequestScanner) Scan(
 	}
 
 	maxConcurrentScans := maxConcurrentScanRequests(p.gossip, &p.settings.SV)
+	// fixedConcurrency is set if the concurrency doesn't follow the
+	// changefeed.backfill.concurrent_scan_requests setting.
+	fixedConcurrency := cfg.Ordered || cfg.Concurrency > 0
+	if cfg.Ordered {
+		// The spans are sorted by key, and each is exported in key order, so
+		// exporting them one at a time writes the KVs to the sink in key order.
+		maxConcurrentScans = 1
+	} else if cfg.Concurrency > 0 {
+		maxConcurrentScans = cfg.Concurrency
+		if max := int(changefeedbase.MaxInitialScanConcurrency.Get(&p.settings.SV)); maxConcurrentScans > max {
+			maxConcurrentScans = max
+		}
+	}
 	exportLim := limit.MakeConcurrentRequestLimiter("changefeedScanRequestLimiter", maxConcurrentScans)
 
 	lastScanLimitUserSetting := changefeedbase.ScanRequestLimit.Get(&p.settings.SV)
 
+	// atomicScannedBytes counts the bytes scanned so far, to report the
+	// throughput of the scan.
+	var atomicScannedBytes int64
+	scanStart := timeutil.Now()
+	recordScanned := func(bytes int64) {
+		scanned := atomic.AddInt64(&atomicScannedBytes, bytes)
+		if elapsed := timeutil.Since(scanStart).Seconds(); p.onThroughput != nil && elapsed > 0 {
+			p.onThroughput(int64(float64(scanned) / elapsed))
+		}
+	}
+	if p.onThroughput != nil {
+		defer p.onThroughput(0)
+	}
+
 	g := ctxgroup.WithContext(ctx)
 	// atomicFinished is used only to enhance debugging messages.
 	var atomicFinished int64
@@ -77,7 +128,7 @@ func (p *scanRequestScanner) Scan(
 		span := span
 
 		// If the user defined scan request limit has changed, recalculate it
-		if currentUserScanLimit := changefeedbase.ScanRequestLimit.Get(&p.settings.SV); currentUserScanLimit != lastScanLimitUserSetting {
+		if currentUserScanLimit := changefeedbase.ScanRequestLimit.Get(&p.settings.SV); !fixedConcurrency && currentUserScanLimit != lastScanLimitUserSetting {
 			lastScanLimitUserSetting = currentUserScanLimit
 			exportLim.SetLimit(maxConcurrentScanRequests(p.gossip, &p.settings.SV))
 		}
@@ -89,8 +140,7 @@ func (p *scanRequestScanner) Scan(
 		}
 
 		g.GoCtx(func(ctx context.Context) error {
-			defer limAlloc.Release()
-			err := p.exportSpan(ctx, span, cfg.Timestamp, cfg.WithDiff, sink, cfg.Knobs)
+			err := p.exportSpan(ctx, span, cfg, &exportLim, limAlloc, sink, recordScanned)
 			finished := atomic.AddInt64(&atomicFinished, 1)
 			if log.V(2) {
 				log.Infof(ctx, `exported %d of %d: %v`, finished, len(spans), err)
@@ -101,24 +151,72 @@ func (p *scanRequestScanner) Scan(
 	return g.Wait()
 }
 
+// exportSpan exports the span in units of at most chunkBytes, if set, holding
+// limAlloc while exporting each of them.
 func (p *scanRequestScanner) exportSpan(
+	ctx context.Context,
+	span roachpb.Span,
+	cfg physicalConfig,
+	exportLim *limit.ConcurrentRequestLimiter,
+	limAlloc limit.Reservation,
+	sink kvevent.Writer,
+	recordScanned func(bytes int64),
+) error {
+	for unit := &span; unit != nil; {
+		var done func()
+		if p.onSpanScan != nil {
+			done = p.onSpanScan()
+		}
+		var err error
+		unit, err = p.exportUnit(ctx, *unit, cfg.Timestamp, cfg.WithDiff, sink, recordScanned, cfg.Knobs)
+		if done != nil {
+			done()
+		}
+		if err != nil {
+			limAlloc.Release()
+			return err
+		}
+		// Ordered scans export one span at a time, so the rest of the span
+		// is exported right away, keeping its rows in key order.
+		if unit != nil && !cfg.Ordered {
+			limAlloc.Release()
+			if limAlloc, err = exportLim.Begin(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	limAlloc.Release()
+	return nil
+}
+
+// exportUnit exports the span, or a prefix of it once chunkBytes have been
+// read, returning the rest of the span in that case.
+func (p *scanRequestScanner) exportUnit(
 	ctx context.Context,
 	span roachpb.Span,
 	ts hlc.Timestamp,
 	withDiff bool,
 	sink kvevent.Writer,
+	recordScanned func(bytes int64),
 	knobs TestingKnobs,
-) error {
+) (*roachpb.Span, error) {
 	txn := p.db.NewTxn(ctx, "changefeed backfill")
 	if log.V(2) {
 		log.Infof(ctx, `sending ScanRequest %s at %s`, span, ts)
 	}
 	if err := txn.SetFixedTimestamp(ctx, ts); err != nil {
-		return err
+		return nil, err
 	}
 	stopwatchStart := timeutil.Now()
 	var scanDuration, bufferDuration time.Duration
-	const targetBytesPerScan = 16 << 20 // 16 MiB
+	targetBytesPerScan := p.targetBytes
+	if targetBytesPerScan == 0 {
+		targetBytesPerScan = defaultTargetBytesPerScan
+	}
+	if p.chunkBytes > 0 && p.chunkBytes < targetBytesPerScan {
+		targetBytesPerScan = p.chunkBytes
+	}
+	var unitBytes int64
 	for remaining := &span; remaining != nil; {
 		start := timeutil.Now()
 		b := txn.NewBatch()
@@ -134,12 +232,18 @@ func (p *scanRequestScanner) exportSpan(
 		}
 
 		if err := txn.Run(ctx, b); err != nil {
-			return errors.Wrapf(err, `fetching changes for %s`, span)
+			return nil, errors.Wrapf(err, `fetching changes for %s`, span)
 		}
 		afterScan := timeutil.Now()
 		res := b.RawResponse().Responses[0].GetScan()
+		var scannedBytes int64
+		for _, br := range res.BatchResponses {
+			scannedBytes += int64(len(br))
+		}
+		recordScanned(scannedBytes)
+		unitBytes += scannedBytes
 		if err := slurpScanResponse(ctx, sink, res, ts, withDiff, *remaining); err != nil {
-			return err
+			return nil, err
 		}
 		afterBuffer := timeutil.Now()
 		scanDuration += afterScan.Sub(start)
@@ -149,7 +253,14 @@ func (p *scanRequestScanner) exportSpan(
 			if err := sink.Add(
 				ctx, kvevent.MakeResolvedEvent(consumed, ts, jobspb.ResolvedSpan_NONE),
 			); err != nil {
-				return err
+				return nil, err
+			}
+			if p.chunkBytes > 0 && unitBytes >= p.chunkBytes {
+				if log.V(2) {
+					log.Infof(ctx, `exported chunk of %s at %s, %d bytes`,
+						span, ts.AsOfSystemTime(), unitBytes)
+				}
+				return res.ResumeSpan, nil
 			}
 		}
 		remaining = res.ResumeSpan
@@ -158,13 +269,13 @@ func (p *scanRequestScanner) exportSpan(
 	if err := sink.Add(
 		ctx, kvevent.MakeResolvedEvent(span, ts, jobspb.ResolvedSpan_NONE),
 	); err != nil {
-		return err
+		return nil, err
 	}
 	if log.V(2) {
 		log.Infof(ctx, `finished Scan of %s at %s took %s`,
 			span, ts.AsOfSystemTime(), timeutil.Since(stopwatchStart))
 	}
-	return nil
+	return nil, nil
 }
 
 func getSpansToProcess(
@@ -11,8 +11,10 @@ package kvfeed
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/gossip"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
@@ -24,6 +26,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -95,3 +98,204 @@ INSERT INTO t VALUES (1), (2), (3);
 	require.Equal(t, span, sink.resolved[2].Span)
 	require.Equal(t, exportTime, sink.resolved[2].Timestamp)
 }
+
+func TestScanRequestTargetBytes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, db, kvdb := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `
+CREATE TABLE t (a INT PRIMARY KEY, b STRING);
+INSERT INTO t SELECT i, repeat('x', 1024) FROM generate_series(1, 100) AS g(i);
+`)
+
+	descr := desctestutils.TestingGetPublicTableDescriptor(kvdb, keys.SystemSQLCodec, "defaultdb", "t")
+	span := tableSpan(uint32(descr.GetID()))
+
+	for _, tc := range []struct {
+		targetBytes, expected int64
+	}{
+		{targetBytes: 0, expected: defaultTargetBytesPerScan},
+		{targetBytes: 1 << 20, expected: 1 << 20},
+	} {
+		var requestTargetBytes []int64
+		cfg := physicalConfig{
+			Spans:     []roachpb.Span{span},
+			Timestamp: kvdb.Clock().Now(),
+			Knobs: TestingKnobs{
+				BeforeScanRequest: func(b *kv.Batch) {
+					requestTargetBytes = append(requestTargetBytes, b.Header.TargetBytes)
+				},
+			},
+		}
+
+		var throughput []int64
+		scanner := &scanRequestScanner{
+			settings:    s.ClusterSettings(),
+			gossip:      gossip.MakeOptionalGossip(s.GossipI().(*gossip.Gossip)),
+			db:          kvdb,
+			targetBytes: tc.targetBytes,
+			onThroughput: func(bytesPerSec int64) {
+				throughput = append(throughput, bytesPerSec)
+			},
+		}
+		require.NoError(t, scanner.Scan(ctx, &recordResolvedWriter{}, cfg))
+
+		require.Equal(t, []int64{tc.expected}, requestTargetBytes)
+		// The throughput of the scan is reported after each request, and reset
+		// once the scan finishes.
+		require.Len(t, throughput, 2)
+		require.Greater(t, throughput[0], int64(0))
+		require.Equal(t, int64(0), throughput[1])
+	}
+}
+
+func TestScanConcurrency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, db, kvdb := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `
+CREATE TABLE t (a INT PRIMARY KEY);
+INSERT INTO t SELECT generate_series(1, 100);
+ALTER TABLE t SPLIT AT SELECT generate_series(10, 90, 10);
+`)
+
+	descr := desctestutils.TestingGetPublicTableDescriptor(kvdb, keys.SystemSQLCodec, "defaultdb", "t")
+	span := tableSpan(uint32(descr.GetID()))
+
+	for _, tc := range []struct {
+		concurrency, maxConcurrency, expected int
+	}{
+		{concurrency: 4, maxConcurrency: 100, expected: 4},
+		{concurrency: 4, maxConcurrency: 2, expected: 2},
+	} {
+		changefeedbase.MaxInitialScanConcurrency.Override(ctx, &s.ClusterSettings().SV, int64(tc.maxConcurrency))
+
+		var mu syncutil.Mutex
+		var inflight, maxInflight, scanned int
+		scanner := &scanRequestScanner{
+			settings: s.ClusterSettings(),
+			gossip:   gossip.MakeOptionalGossip(s.GossipI().(*gossip.Gossip)),
+			db:       kvdb,
+			onSpanScan: func() func() {
+				mu.Lock()
+				defer mu.Unlock()
+				inflight++
+				if inflight > maxInflight {
+					maxInflight = inflight
+				}
+				return func() {
+					mu.Lock()
+					defer mu.Unlock()
+					inflight--
+					scanned++
+				}
+			},
+		}
+		cfg := physicalConfig{
+			Spans:       []roachpb.Span{span},
+			Timestamp:   kvdb.Clock().Now(),
+			Concurrency: tc.concurrency,
+			Knobs: TestingKnobs{
+				BeforeScanRequest: func(b *kv.Batch) {
+					// Give the other spans time to start exporting.
+					time.Sleep(10 * time.Millisecond)
+				},
+			},
+		}
+		require.NoError(t, scanner.Scan(ctx, &recordResolvedWriter{}, cfg))
+
+		// Each range of the table is exported as a span.
+		require.GreaterOrEqual(t, scanned, 10)
+		require.LessOrEqual(t, maxInflight, tc.expected)
+		require.Equal(t, 0, inflight)
+	}
+}
+
+// recordChunksWriter records the bytes of the KVs written by each scan unit,
+// and the resolved spans.
+type recordChunksWriter struct {
+	recordResolvedWriter
+	unitBytes []int64
+}
+
+func (r *recordChunksWriter) Add(ctx context.Context, e kvevent.Event) error {
+	if e.Type() == kvevent.TypeKV {
+		kv := e.KV()
+		r.unitBytes[len(r.unitBytes)-1] += int64(len(kv.Key) + len(kv.Value.RawBytes))
+	}
+	return r.recordResolvedWriter.Add(ctx, e)
+}
+
+func TestScanChunks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, db, kvdb := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	// The table is small enough to fit in a single range.
+	sqlDB := sqlutils.MakeSQLRunner(db)
+	sqlDB.Exec(t, `
+CREATE TABLE t (a INT PRIMARY KEY, b STRING);
+INSERT INTO t SELECT i, repeat('x', 1024) FROM generate_series(1, 100) AS g(i);
+`)
+
+	descr := desctestutils.TestingGetPublicTableDescriptor(kvdb, keys.SystemSQLCodec, "defaultdb", "t")
+	span := tableSpan(uint32(descr.GetID()))
+
+	const chunkBytes = 16 << 10
+	var requestTargetBytes []int64
+	sink := &recordChunksWriter{}
+	scanner := &scanRequestScanner{
+		settings:   s.ClusterSettings(),
+		gossip:     gossip.MakeOptionalGossip(s.GossipI().(*gossip.Gossip)),
+		db:         kvdb,
+		chunkBytes: chunkBytes,
+		onSpanScan: func() func() {
+			sink.unitBytes = append(sink.unitBytes, 0)
+			return func() {}
+		},
+	}
+	cfg := physicalConfig{
+		Spans:     []roachpb.Span{span},
+		Timestamp: kvdb.Clock().Now(),
+		Knobs: TestingKnobs{
+			BeforeScanRequest: func(b *kv.Batch) {
+				requestTargetBytes = append(requestTargetBytes, b.Header.TargetBytes)
+			},
+		},
+	}
+	require.NoError(t, scanner.Scan(ctx, sink, cfg))
+
+	// The range is scanned in units of about chunkBytes, each of which reads
+	// at most one row past chunkBytes.
+	require.GreaterOrEqual(t, len(sink.unitBytes), 100*1024/chunkBytes)
+	var total int64
+	for _, b := range sink.unitBytes {
+		require.LessOrEqual(t, b, int64(chunkBytes+2<<10))
+		total += b
+	}
+	require.Greater(t, total, int64(100*1024))
+	for _, b := range requestTargetBytes {
+		require.Equal(t, int64(chunkBytes), b)
+	}
+
+	// The resolved spans cover the whole span, one after the other.
+	startKey := span.Key
+	for _, resolved := range sink.resolved {
+		require.Equal(t, startKey, resolved.Span.Key)
+		startKey = resolved.Span.EndKey
+	}
+	require.Equal(t, span.EndKey, startKey)
+}
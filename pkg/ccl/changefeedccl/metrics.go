@@ -13,9 +13,12 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcutils"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/schemafeed"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
@@ -52,6 +55,16 @@ type AggMetrics struct {
 	ErrorRetries    *aggmetric.AggCounter
 	AdmitLatency    *aggmetric.AggHistogram
 	RunningCount    *aggmetric.AggGauge
+	SinkConnected   *aggmetric.AggGauge
+	RateLimited     *aggmetric.AggGauge
+	ScanThroughput  *aggmetric.AggGauge
+	LagHeldBytes    *aggmetric.AggGauge
+	WindowHeldBytes *aggmetric.AggGauge
+	SinkInflight    *aggmetric.AggGauge
+	Deduplicated    *aggmetric.AggCounter
+	Dropped         *aggmetric.AggCounter
+	ScanConcurrency *aggmetric.AggGauge
+	ScannedSpans    *aggmetric.AggCounter
 
 	// There is always at least 1 sliMetrics created for defaultSLI scope.
 	mu struct {
@@ -76,6 +89,16 @@ type sliMetrics struct {
 	AdmitLatency    *aggmetric.Histogram
 	BackfillCount   *aggmetric.Gauge
 	RunningCount    *aggmetric.Gauge
+	SinkConnected   *aggmetric.Gauge
+	RateLimited     *aggmetric.Gauge
+	ScanThroughput  *aggmetric.Gauge
+	LagHeldBytes    *aggmetric.Gauge
+	WindowHeldBytes *aggmetric.Gauge
+	SinkInflight    *aggmetric.Gauge
+	Deduplicated    *aggmetric.Counter
+	Dropped         *aggmetric.Counter
+	ScanConcurrency *aggmetric.Gauge
+	ScannedSpans    *aggmetric.Counter
 }
 
 // sinkDoesNotCompress is a sentinel value indicating the sink
@@ -140,6 +163,44 @@ func (m *sliMetrics) recordFlushRequestCallback() func() {
 	}
 }
 
+// recordRateLimited marks the start of a wait for rate limit quota and
+// returns a callback marking its end.
+func (m *sliMetrics) recordRateLimited() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.RateLimited.Inc(1)
+	return func() {
+		m.RateLimited.Dec(1)
+	}
+}
+
+// recordDeduplicated counts a message dropped as a duplicate by the dedup
+// option.
+func (m *sliMetrics) recordDeduplicated() {
+	if m == nil {
+		return
+	}
+	m.Deduplicated.Inc(1)
+}
+
+// recordDropped counts n messages dropped by the at_most_once option because
+// they failed to be emitted to the sink.
+func (m *sliMetrics) recordDropped(n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.Dropped.Inc(n)
+}
+
+// recordInflight adds delta to the number of messages in flight to the sink.
+func (m *sliMetrics) recordInflight(delta int64) {
+	if m == nil {
+		return
+	}
+	m.SinkInflight.Inc(delta)
+}
+
 func (m *sliMetrics) getBackfillCallback() func() func() {
 	return func() func() {
 		m.BackfillCount.Inc(1)
@@ -149,12 +210,43 @@ func (m *sliMetrics) getBackfillCallback() func() func() {
 	}
 }
 
+// getSpanScanCallback returns a callback reporting that a scan started
+// exporting a span, which returns a function reporting it's done.
+func (m *sliMetrics) getSpanScanCallback() func() func() {
+	return func() func() {
+		m.ScanConcurrency.Inc(1)
+		return func() {
+			m.ScanConcurrency.Dec(1)
+			m.ScannedSpans.Inc(1)
+		}
+	}
+}
+
+// getScanThroughputCallback returns a callback reporting the throughput of a
+// scan, in bytes per second, which must report 0 once the scan finishes. The
+// throughput of concurrent scans is summed.
+func (m *sliMetrics) getScanThroughputCallback() func(bytesPerSec int64) {
+	var mu syncutil.Mutex
+	var last int64
+	return func(bytesPerSec int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		m.ScanThroughput.Inc(bytesPerSec - last)
+		last = bytesPerSec
+	}
+}
+
 const (
 	changefeedCheckpointHistMaxLatency = 30 * time.Second
 	changefeedBatchHistMaxLatency      = 30 * time.Second
 	changefeedFlushHistMaxLatency      = 1 * time.Minute
 	admitLatencyMaxValue               = 1 * time.Minute
 	commitLatencyMaxValue              = 10 * time.Minute
+	changefeedEncodeHistMaxLatency     = 10 * time.Second
+
+	changefeedBufferFlushRowsMaxValue = 1 << 20
+
+	changefeedSchemaRegistryHistMaxLatency = 30 * time.Second
 )
 
 var (
@@ -208,6 +300,98 @@ var (
 		Measurement: "Updates",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaChangefeedDeadLetteredUnsupportedValue = metric.Metadata{
+		Name:        "changefeed.dead_lettered.unsupported_value",
+		Help:        "Number of rows emitted to a dead letter sink because a value could not be encoded as avro",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedDeadLetteredSchemaRejected = metric.Metadata{
+		Name:        "changefeed.dead_lettered.schema_rejected",
+		Help:        "Number of rows emitted to a dead letter sink because the schema registry rejected their avro schema",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedDeadLetteredInvalidTopic = metric.Metadata{
+		Name:        "changefeed.dead_lettered.invalid_topic",
+		Help:        "Number of rows emitted to a dead letter sink because their topic_from_column value could not name a topic",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedEmittedTableMessages = metric.Metadata{
+		Name:        "changefeed.table.emitted_messages",
+		Help:        "Messages emitted for each table targeted by a running changefeed",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedEmittedTableBytes = metric.Metadata{
+		Name:        "changefeed.table.emitted_bytes",
+		Help:        "Bytes emitted for each table targeted by a running changefeed",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedEncodeHistNanos = metric.Metadata{
+		Name:        "changefeed.encode_hist_nanos",
+		Help:        "Time spent encoding the keys and values of rows, for each format",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaChangefeedEncodeErrors = metric.Metadata{
+		Name:        "changefeed.encode_errors",
+		Help:        "Rows which failed to encode, for each format",
+		Measurement: "Errors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedBufferFlushes = metric.Metadata{
+		Name: "changefeed.buffer_flushes",
+		Help: "Flushes of the sink by change aggregators to hand off the rows read " +
+			"from the buffer, per the buffer_flush_rows, buffer_flush_bytes and " +
+			"buffer_flush_interval options or because the buffer ran out of memory",
+		Measurement: "Flushes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedBufferFlushRows = metric.Metadata{
+		Name:        "changefeed.buffer_flush_rows",
+		Help:        "Rows read from the buffer and handed off to the sink by each buffer flush",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedSchemaRegistryHistNanos = metric.Metadata{
+		Name:        "changefeed.schema_registry.request_hist_nanos",
+		Help:        "Time spent on each request to the schema registry, including failed attempts",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaChangefeedSchemaRegistryRetries = metric.Metadata{
+		Name:        "changefeed.schema_registry.retries",
+		Help:        "Requests to the schema registry which were retried after a transient failure",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedHTTPConnsOpened = metric.Metadata{
+		Name:        "changefeed.http.conns_opened",
+		Help:        "Connections opened by the HTTP clients of sinks and schema registries",
+		Measurement: "Connections",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedHTTPConnsReused = metric.Metadata{
+		Name:        "changefeed.http.conns_reused",
+		Help:        "Requests of the HTTP clients of sinks and schema registries sent over a reused connection",
+		Measurement: "Requests",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedHTTPDNSLookups = metric.Metadata{
+		Name:        "changefeed.http.dns_lookups",
+		Help:        "Lookups of the hosts of HTTP sinks and schema registries which weren't cached",
+		Measurement: "Lookups",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedHTTPDNSCacheHits = metric.Metadata{
+		Name:        "changefeed.http.dns_cache_hits",
+		Help:        "Lookups of the hosts of HTTP sinks and schema registries served by the DNS cache",
+		Measurement: "Lookups",
+		Unit:        metric.Unit_COUNT,
+	}
 )
 
 func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
@@ -278,6 +462,76 @@ func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
 		Measurement: "Changefeeds",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaChangefeedSinkConnected := metric.Metadata{
+		Name: "changefeed.sink_connected",
+		Help: "Number of running changefeeds whose sink passed its most recent health checks; " +
+			"sinks which do not support health checks are always counted as connected",
+		Measurement: "Changefeeds",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedRateLimited := metric.Metadata{
+		Name: "changefeed.rate_limited",
+		Help: "Number of change aggregators currently waiting for quota from the " +
+			"max_emit_bytes_per_sec or max_rows_per_sec limits of their changefeed",
+		Measurement: "Aggregators",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedScanThroughput := metric.Metadata{
+		Name: "changefeed.scan_throughput",
+		Help: "Bytes per second read by the initial scans and backfills of changefeeds; " +
+			"the scan_request_batch_bytes option can be tuned to increase it",
+		Measurement: "Bytes/Sec",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedWatermarkLagHeldBytes := metric.Metadata{
+		Name: "changefeed.watermark_lag_held_bytes",
+		Help: "Bytes of messages held back by the watermark_lag option of changefeeds " +
+			"until they age past the lag",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedEmitWindowHeldBytes := metric.Metadata{
+		Name: "changefeed.emit_window_held_bytes",
+		Help: "Bytes of messages held back by the emit_window option of changefeeds " +
+			"until the window opens",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedSinkInflight := metric.Metadata{
+		Name: "changefeed.sink_inflight",
+		Help: "Messages emitted to Kafka by changefeeds and awaiting acknowledgement; " +
+			"the sink_concurrency option caps it for each changefeed",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedDeduplicated := metric.Metadata{
+		Name: "changefeed.deduplicated_messages",
+		Help: "Messages dropped by the dedup option of changefeeds because a message " +
+			"with the same key and MVCC timestamp was already emitted",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedDropped := metric.Metadata{
+		Name: "changefeed.dropped_messages",
+		Help: "Messages lost by changefeeds with the at_most_once option because " +
+			"they failed to be emitted to the sink; nonzero values mean the sink " +
+			"is missing changes",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedScanConcurrency := metric.Metadata{
+		Name: "changefeed.scan_concurrency",
+		Help: "Spans being exported concurrently by the initial scans and backfills " +
+			"of changefeeds; the initial_scan_concurrency option sets it for initial scans",
+		Measurement: "Spans",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedScannedSpans := metric.Metadata{
+		Name:        "changefeed.scanned_spans",
+		Help:        "Spans whose export by the initial scan or a backfill of a changefeed completed",
+		Measurement: "Spans",
+		Unit:        metric.Unit_COUNT,
+	}
 
 	// NB: When adding new histograms, use sigFigs = 1.  Older histograms
 	// retain significant figures of 2.
@@ -297,8 +551,18 @@ func newAggregateMetrics(histogramWindow time.Duration) *AggMetrics {
 			histogramWindow, commitLatencyMaxValue.Nanoseconds(), 1),
 		AdmitLatency: b.Histogram(metaAdmitLatency, histogramWindow,
 			admitLatencyMaxValue.Nanoseconds(), 1),
-		BackfillCount: b.Gauge(metaChangefeedBackfillCount),
-		RunningCount:  b.Gauge(metaChangefeedRunning),
+		BackfillCount:   b.Gauge(metaChangefeedBackfillCount),
+		RunningCount:    b.Gauge(metaChangefeedRunning),
+		SinkConnected:   b.Gauge(metaChangefeedSinkConnected),
+		RateLimited:     b.Gauge(metaChangefeedRateLimited),
+		ScanThroughput:  b.Gauge(metaChangefeedScanThroughput),
+		LagHeldBytes:    b.Gauge(metaChangefeedWatermarkLagHeldBytes),
+		WindowHeldBytes: b.Gauge(metaChangefeedEmitWindowHeldBytes),
+		SinkInflight:    b.Gauge(metaChangefeedSinkInflight),
+		Deduplicated:    b.Counter(metaChangefeedDeduplicated),
+		Dropped:         b.Counter(metaChangefeedDropped),
+		ScanConcurrency: b.Gauge(metaChangefeedScanConcurrency),
+		ScannedSpans:    b.Counter(metaChangefeedScannedSpans),
 	}
 	a.mu.sliMetrics = make(map[string]*sliMetrics)
 	_, err := a.getOrCreateScope(defaultSLIScope)
@@ -354,6 +618,16 @@ func (a *AggMetrics) getOrCreateScope(scope string) (*sliMetrics, error) {
 		AdmitLatency:    a.AdmitLatency.AddChild(scope),
 		BackfillCount:   a.BackfillCount.AddChild(scope),
 		RunningCount:    a.RunningCount.AddChild(scope),
+		SinkConnected:   a.SinkConnected.AddChild(scope),
+		RateLimited:     a.RateLimited.AddChild(scope),
+		ScanThroughput:  a.ScanThroughput.AddChild(scope),
+		LagHeldBytes:    a.LagHeldBytes.AddChild(scope),
+		WindowHeldBytes: a.WindowHeldBytes.AddChild(scope),
+		SinkInflight:    a.SinkInflight.AddChild(scope),
+		Deduplicated:    a.Deduplicated.AddChild(scope),
+		Dropped:         a.Dropped.AddChild(scope),
+		ScanConcurrency: a.ScanConcurrency.AddChild(scope),
+		ScannedSpans:    a.ScannedSpans.AddChild(scope),
 	}
 
 	a.mu.sliMetrics[scope] = sm
@@ -372,10 +646,52 @@ type Metrics struct {
 	FrontierUpdates     *metric.Counter
 	ThrottleMetrics     cdcutils.Metrics
 
+	DeadLetteredUnsupportedValue *metric.Counter
+	DeadLetteredSchemaRejected   *metric.Counter
+	DeadLetteredInvalidTopic     *metric.Counter
+
+	// EmittedTableMessages and EmittedTableBytes count the messages emitted
+	// for each table targeted by a running changefeed, labeled by the fully
+	// qualified name of the table, so that tables of the same name in
+	// different databases or schemas are counted apart.
+	EmittedTableMessages *aggmetric.AggCounter
+	EmittedTableBytes    *aggmetric.AggCounter
+
+	// EncodeHistNanos and EncodeErrors record the time spent encoding rows
+	// and the rows which failed to encode, labeled by format.
+	EncodeHistNanos *aggmetric.AggHistogram
+	EncodeErrors    *aggmetric.AggCounter
+
+	// BufferFlushes and BufferFlushRows record the flushes by which change
+	// aggregators hand off the rows read from the buffer to their sinks, and
+	// the number of rows in each.
+	BufferFlushes   *metric.Counter
+	BufferFlushRows *metric.Histogram
+
+	// SchemaRegistryHistNanos and SchemaRegistryRetries record the time spent
+	// on each request to the schema registry of avro changefeeds, and the
+	// requests which were retried.
+	SchemaRegistryHistNanos *metric.Histogram
+	SchemaRegistryRetries   *metric.Counter
+
+	// HTTPConnsOpened, HTTPConnsReused, HTTPDNSLookups and HTTPDNSCacheHits
+	// export the sinkHTTPStats of the connections of the HTTP clients of
+	// sinks and schema registries, which are shared by the node.
+	HTTPConnsOpened  *metric.Counter
+	HTTPConnsReused  *metric.Counter
+	HTTPDNSLookups   *metric.Counter
+	HTTPDNSCacheHits *metric.Counter
+
 	mu struct {
 		syncutil.Mutex
 		id       int
 		resolved map[int]hlc.Timestamp
+		// tables holds the children of the per-table metrics, which are
+		// shared by all the changefeeds targeting a table with that label.
+		tables map[string]*sharedTableMetrics
+		// formats holds the children of the per-format metrics, which are
+		// few enough to be kept for the lifetime of the node.
+		formats map[changefeedbase.FormatType]*formatMetrics
 	}
 	MaxBehindNanos *metric.Gauge
 }
@@ -401,9 +717,35 @@ func MakeMetrics(histogramWindow time.Duration) metric.Struct {
 			changefeedCheckpointHistMaxLatency.Nanoseconds(), 2),
 		FrontierUpdates: metric.NewCounter(metaChangefeedFrontierUpdates),
 		ThrottleMetrics: cdcutils.MakeMetrics(histogramWindow),
+
+		DeadLetteredUnsupportedValue: metric.NewCounter(metaChangefeedDeadLetteredUnsupportedValue),
+		DeadLetteredSchemaRejected:   metric.NewCounter(metaChangefeedDeadLetteredSchemaRejected),
+		DeadLetteredInvalidTopic:     metric.NewCounter(metaChangefeedDeadLetteredInvalidTopic),
+
+		EmittedTableMessages: aggmetric.NewCounter(metaChangefeedEmittedTableMessages, "table"),
+		EmittedTableBytes:    aggmetric.NewCounter(metaChangefeedEmittedTableBytes, "table"),
+
+		EncodeHistNanos: aggmetric.NewHistogram(metaChangefeedEncodeHistNanos, histogramWindow,
+			changefeedEncodeHistMaxLatency.Nanoseconds(), 1, "format"),
+		EncodeErrors: aggmetric.NewCounter(metaChangefeedEncodeErrors, "format"),
+
+		BufferFlushes: metric.NewCounter(metaChangefeedBufferFlushes),
+		BufferFlushRows: metric.NewHistogram(metaChangefeedBufferFlushRows, histogramWindow,
+			changefeedBufferFlushRowsMaxValue, 1),
+
+		SchemaRegistryHistNanos: metric.NewHistogram(metaChangefeedSchemaRegistryHistNanos, histogramWindow,
+			changefeedSchemaRegistryHistMaxLatency.Nanoseconds(), 1),
+		SchemaRegistryRetries: metric.NewCounter(metaChangefeedSchemaRegistryRetries),
+
+		HTTPConnsOpened:  sinkHTTPStats.connsOpened,
+		HTTPConnsReused:  sinkHTTPStats.connsReused,
+		HTTPDNSLookups:   sinkHTTPStats.dnsLookups,
+		HTTPDNSCacheHits: sinkHTTPStats.dnsCacheHits,
 	}
 
 	m.mu.resolved = make(map[int]hlc.Timestamp)
+	m.mu.tables = make(map[string]*sharedTableMetrics)
+	m.mu.formats = make(map[changefeedbase.FormatType]*formatMetrics)
 	m.mu.id = 1 // start the first id at 1 so we can detect initialization
 	m.MaxBehindNanos = metric.NewFunctionalGauge(metaChangefeedMaxBehindNanos, func() int64 {
 		now := timeutil.Now()
@@ -420,6 +762,177 @@ func MakeMetrics(histogramWindow time.Duration) metric.Struct {
 	return m
 }
 
+type sharedTableMetrics struct {
+	messages, bytes *aggmetric.Counter
+	refs            int
+}
+
+// formatMetrics are the children of the per-format metrics.
+type formatMetrics struct {
+	encodeNanos  *aggmetric.Histogram
+	encodeErrors *aggmetric.Counter
+}
+
+// getFormatMetricsLocked returns the per-format metrics of the format set by
+// the given options. m.mu must be held.
+func (m *Metrics) getFormatMetricsLocked(opts map[string]string) *formatMetrics {
+	format := changefeedbase.FormatType(opts[changefeedbase.OptFormat])
+	switch format {
+	case ``:
+		format = changefeedbase.OptFormatJSON
+	case changefeedbase.DeprecatedOptFormatAvro:
+		format = changefeedbase.OptFormatAvro
+	}
+	f, ok := m.mu.formats[format]
+	if !ok {
+		f = &formatMetrics{
+			encodeNanos:  m.EncodeHistNanos.AddChild(string(format)),
+			encodeErrors: m.EncodeErrors.AddChild(string(format)),
+		}
+		m.mu.formats[format] = f
+	}
+	return f
+}
+
+// tableMetrics counts the messages emitted by a change aggregator for each of
+// its changefeed's tables. The counts are recorded in the node's per-table
+// metrics, and accumulated until they are forwarded to the change frontier,
+// which records them in the job progress.
+type tableMetrics struct {
+	metrics *Metrics
+	byID    map[descpb.ID]*emittedTable
+}
+
+type emittedTable struct {
+	// name is the name of the table in the changefeed's topics, which keys
+	// its counts in the job progress, and label is the label of its
+	// per-table metrics.
+	name, label string
+	shared      *sharedTableMetrics
+	// format holds the metrics of the format the table's rows are encoded
+	// with.
+	format *formatMetrics
+	// pending holds the counts which have yet to be forwarded to the change
+	// frontier.
+	pending jobspb.ChangefeedTableStats
+}
+
+// getTableMetrics returns the per-table metrics of a changefeed with the
+// given targets. They must be released once the changefeed stops, so that
+// the metrics only have children for the tables of running changefeeds.
+func (m *Metrics) getTableMetrics(
+	opts map[string]string, targets jobspb.ChangefeedTargets,
+) *tableMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &tableMetrics{metrics: m, byID: make(map[descpb.ID]*emittedTable, len(targets))}
+	for id, target := range targets {
+		label := target.QualifiedName
+		if label == `` {
+			label = target.StatementTimeName
+		}
+		shared, ok := m.mu.tables[label]
+		if !ok {
+			shared = &sharedTableMetrics{
+				messages: m.EmittedTableMessages.AddChild(label),
+				bytes:    m.EmittedTableBytes.AddChild(label),
+			}
+			m.mu.tables[label] = shared
+		}
+		shared.refs++
+		t.byID[id] = &emittedTable{
+			name:   target.StatementTimeName,
+			label:  label,
+			shared: shared,
+			format: m.getFormatMetricsLocked(changefeedbase.OptionsForTarget(opts, target)),
+		}
+	}
+	return t
+}
+
+// recordEncoded records the time spent encoding the key and value of a row
+// of the table with the given ID, or the failure to encode it. Since it's
+// called for every row, it only records a histogram sample and, on failure,
+// increments a counter.
+func (t *tableMetrics) recordEncoded(id descpb.ID, elapsed time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	table, ok := t.byID[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		table.format.encodeErrors.Inc(1)
+		return
+	}
+	table.format.encodeNanos.RecordValue(elapsed.Nanoseconds())
+}
+
+// recordEmitted records a message emitted for the table with the given ID.
+func (t *tableMetrics) recordEmitted(id descpb.ID, bytes int) {
+	if t == nil {
+		return
+	}
+	table, ok := t.byID[id]
+	if !ok {
+		return
+	}
+	table.shared.messages.Inc(1)
+	table.shared.bytes.Inc(int64(bytes))
+	table.pending.EmittedMessages++
+	table.pending.EmittedBytes += int64(bytes)
+}
+
+// takePending returns the counts recorded since it was last called, by table
+// name, or nil if no messages were emitted.
+func (t *tableMetrics) takePending() map[string]jobspb.ChangefeedTableStats {
+	if t == nil {
+		return nil
+	}
+	var pending map[string]jobspb.ChangefeedTableStats
+	for _, table := range t.byID {
+		if table.pending.EmittedMessages == 0 {
+			continue
+		}
+		if pending == nil {
+			pending = make(map[string]jobspb.ChangefeedTableStats)
+		}
+		pending[table.name] = table.pending
+		table.pending = jobspb.ChangefeedTableStats{}
+	}
+	return pending
+}
+
+// release removes the children of the per-table metrics which are no longer
+// used by any changefeed.
+func (t *tableMetrics) release() {
+	if t == nil {
+		return
+	}
+	t.metrics.mu.Lock()
+	defer t.metrics.mu.Unlock()
+	for _, table := range t.byID {
+		table.shared.refs--
+		if table.shared.refs == 0 {
+			table.shared.messages.Destroy()
+			table.shared.bytes.Destroy()
+			delete(t.metrics.mu.tables, table.label)
+		}
+	}
+	t.byID = nil
+}
+
+// addTableStats adds the counts in delta to totals.
+func addTableStats(totals, delta map[string]jobspb.ChangefeedTableStats) {
+	for name, d := range delta {
+		s := totals[name]
+		s.EmittedMessages += d.EmittedMessages
+		s.EmittedBytes += d.EmittedBytes
+		totals[name] = s
+	}
+}
+
 func init() {
 	jobs.MakeChangefeedMetricsHook = MakeMetrics
 }
@@ -0,0 +1,88 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableMetricsRecordEncoded(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	metrics := MakeMetrics(time.Minute).(*Metrics)
+	targets := jobspb.ChangefeedTargets{
+		1: jobspb.ChangefeedTarget{StatementTimeName: `foo`},
+		2: jobspb.ChangefeedTarget{
+			StatementTimeName: `bar`,
+			Opts:              map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatAvro)},
+		},
+	}
+	tm := metrics.getTableMetrics(map[string]string{}, targets)
+	defer tm.release()
+
+	tm.recordEncoded(1, time.Millisecond, nil)
+	tm.recordEncoded(2, time.Millisecond, nil)
+	tm.recordEncoded(2, 0, errors.New(`boom`))
+
+	// The rows of each table are recorded under the format they're encoded
+	// with, the changefeed's unless the table overrides it.
+	json := metrics.mu.formats[changefeedbase.OptFormatJSON]
+	avro := metrics.mu.formats[changefeedbase.OptFormatAvro]
+	require.Equal(t, uint64(1), json.encodeNanos.ToPrometheusMetric().Histogram.GetSampleCount())
+	require.Equal(t, int64(0), json.encodeErrors.Value())
+	require.Equal(t, uint64(1), avro.encodeNanos.ToPrometheusMetric().Histogram.GetSampleCount())
+	require.Equal(t, int64(1), avro.encodeErrors.Value())
+	require.Equal(t, int64(1), metrics.EncodeErrors.Count())
+
+	// The deprecated name of the avro format shares its metrics.
+	tm2 := metrics.getTableMetrics(map[string]string{
+		changefeedbase.OptFormat: string(changefeedbase.DeprecatedOptFormatAvro),
+	}, targets)
+	defer tm2.release()
+	require.Len(t, metrics.mu.formats, 2)
+
+	// Rows of tables the changefeed doesn't target aren't recorded.
+	tm.recordEncoded(3, time.Millisecond, nil)
+	require.Equal(t, uint64(1), json.encodeNanos.ToPrometheusMetric().Histogram.GetSampleCount())
+}
+
+func TestTableMetricsLabels(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	metrics := MakeMetrics(time.Minute).(*Metrics)
+	tm := metrics.getTableMetrics(map[string]string{}, jobspb.ChangefeedTargets{
+		1: jobspb.ChangefeedTarget{StatementTimeName: `foo`, QualifiedName: `d.public.foo`},
+		2: jobspb.ChangefeedTarget{StatementTimeName: `foo`, QualifiedName: `e.public.foo`},
+		// Changefeeds created before qualified names were recorded are
+		// labeled by the names of their topics.
+		3: jobspb.ChangefeedTarget{StatementTimeName: `bar`},
+	})
+	tm.recordEmitted(1, 10)
+	tm.recordEmitted(2, 20)
+
+	metrics.mu.Lock()
+	require.Len(t, metrics.mu.tables, 3)
+	require.Equal(t, int64(1), metrics.mu.tables[`d.public.foo`].messages.Value())
+	require.Equal(t, int64(1), metrics.mu.tables[`e.public.foo`].messages.Value())
+	require.NotNil(t, metrics.mu.tables[`bar`])
+	metrics.mu.Unlock()
+
+	tm.release()
+	require.Empty(t, metrics.mu.tables)
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"math"
+
+	"github.com/cockroachdb/apd/v3"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+// msgpackEncoder encodes keys, values and resolved timestamps for
+// `format=msgpack` as MessagePack (https://msgpack.org), in the layout of the
+// JSON encoder: a key, value or resolved timestamp decodes to the document the
+// JSON encoder would have emitted for it, with the fields of objects in the
+// same order. Deletes with delete_format=null are the MessagePack nil.
+//
+// JSON numbers are arbitrary precision decimals, while MessagePack has
+// integers and floats. Numbers which are integers are encoded as the smallest
+// MessagePack integer which holds them, numbers a float64 holds exactly as
+// float64s, and the others, such as DECIMALs with more digits than a float64
+// holds, as strings of their decimal representation, as with JSON they're
+// otherwise silently rounded by most consumers.
+type msgpackEncoder struct {
+	*jsonEncoder
+	buf []byte
+}
+
+var _ Encoder = &msgpackEncoder{}
+
+func makeMsgpackEncoder(
+	opts map[string]string, targets jobspb.ChangefeedTargets,
+) (*msgpackEncoder, error) {
+	switch v := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]); v {
+	case changefeedbase.OptEnvelopeConnect, changefeedbase.OptEnvelopeDebezium:
+		return nil, errors.Errorf(`%s=%s is not supported with %s=%s`,
+			changefeedbase.OptEnvelope, v, changefeedbase.OptFormat, changefeedbase.OptFormatMsgpack)
+	}
+	e, err := makeJSONEncoder(opts, targets)
+	if err != nil {
+		return nil, err
+	}
+	return &msgpackEncoder{jsonEncoder: e}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *msgpackEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
+	key, err := e.encodeKeyRaw(row)
+	if err != nil {
+		return nil, err
+	}
+	j, err := json.MakeJSON(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.marshal(j)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *msgpackEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
+	j, err := e.encodeValueJSON(row)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return e.marshal(j)
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface. Resolved
+// timestamps are few, so the payload of the JSON encoder is simply
+// transcoded.
+func (e *msgpackEncoder) EncodeResolvedTimestamp(
+	ctx context.Context, topic string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	payload, err := e.jsonEncoder.EncodeResolvedTimestamp(ctx, topic, resolved)
+	if err != nil {
+		return nil, err
+	}
+	j, err := json.ParseJSON(string(payload))
+	if err != nil {
+		return nil, err
+	}
+	return e.marshal(j)
+}
+
+func (e *msgpackEncoder) marshal(j json.JSON) ([]byte, error) {
+	var err error
+	e.buf, err = appendMsgpack(e.buf[:0], j)
+	return e.buf, err
+}
+
+// The MessagePack type markers used by appendMsgpack.
+const (
+	msgpackNil     = 0xc0
+	msgpackFalse   = 0xc2
+	msgpackTrue    = 0xc3
+	msgpackFloat64 = 0xcb
+	msgpackUint8   = 0xcc
+	msgpackUint16  = 0xcd
+	msgpackUint32  = 0xce
+	msgpackUint64  = 0xcf
+	msgpackInt8    = 0xd0
+	msgpackInt16   = 0xd1
+	msgpackInt32   = 0xd2
+	msgpackInt64   = 0xd3
+	msgpackStr8    = 0xd9
+	msgpackStr16   = 0xda
+	msgpackStr32   = 0xdb
+	msgpackArray16 = 0xdc
+	msgpackArray32 = 0xdd
+	msgpackMap16   = 0xde
+	msgpackMap32   = 0xdf
+
+	msgpackFixMap   = 0x80
+	msgpackFixArray = 0x90
+	msgpackFixStr   = 0xa0
+)
+
+// appendMsgpack appends the MessagePack encoding of j to buf.
+func appendMsgpack(buf []byte, j json.JSON) ([]byte, error) {
+	switch j.Type() {
+	case json.NullJSONType:
+		return append(buf, msgpackNil), nil
+	case json.FalseJSONType:
+		return append(buf, msgpackFalse), nil
+	case json.TrueJSONType:
+		return append(buf, msgpackTrue), nil
+	case json.StringJSONType:
+		s, err := j.AsText()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpackString(buf, *s), nil
+	case json.NumberJSONType:
+		d, _ := j.AsDecimal()
+		return appendMsgpackNumber(buf, d), nil
+	case json.ArrayJSONType:
+		buf = appendMsgpackHeader(buf, j.Len(), msgpackFixArray, msgpackArray16, msgpackArray32)
+		for i := 0; i < j.Len(); i++ {
+			elem, err := j.FetchValIdx(i)
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = appendMsgpack(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case json.ObjectJSONType:
+		buf = appendMsgpackHeader(buf, j.Len(), msgpackFixMap, msgpackMap16, msgpackMap32)
+		it, err := j.ObjectIter()
+		if err != nil {
+			return nil, err
+		}
+		for it.Next() {
+			buf = appendMsgpackString(buf, it.Key())
+			if buf, err = appendMsgpack(buf, it.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, errors.AssertionFailedf(`unknown JSON type %s`, j.Type())
+	}
+}
+
+// appendMsgpackHeader appends the header of an array or map of n elements,
+// whose markers are given, to buf.
+func appendMsgpackHeader(buf []byte, n int, fix, marker16, marker32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(buf, marker16), uint64(n), 2)
+	default:
+		return appendBigEndian(append(buf, marker32), uint64(n), 4)
+	}
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, msgpackFixStr|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, msgpackStr8, byte(n))
+	case n <= math.MaxUint16:
+		buf = appendBigEndian(append(buf, msgpackStr16), uint64(n), 2)
+	default:
+		buf = appendBigEndian(append(buf, msgpackStr32), uint64(n), 4)
+	}
+	return append(buf, s...)
+}
+
+// appendMsgpackNumber appends a JSON number to buf. See msgpackEncoder for
+// how numbers are represented.
+func appendMsgpackNumber(buf []byte, d *apd.Decimal) []byte {
+	if i, err := d.Int64(); err == nil {
+		return appendMsgpackInt(buf, i)
+	}
+	if f, err := d.Float64(); err == nil {
+		var exact apd.Decimal
+		if _, err := exact.SetFloat64(f); err == nil && exact.Cmp(d) == 0 {
+			return appendBigEndian(append(buf, msgpackFloat64), math.Float64bits(f), 8)
+		}
+	}
+	return appendMsgpackString(buf, d.String())
+}
+
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(buf, byte(i))
+	case i >= -32 && i < 0:
+		return append(buf, byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, msgpackUint8, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return appendBigEndian(append(buf, msgpackUint16), uint64(i), 2)
+	case i >= 0 && i <= math.MaxUint32:
+		return appendBigEndian(append(buf, msgpackUint32), uint64(i), 4)
+	case i >= 0:
+		return appendBigEndian(append(buf, msgpackUint64), uint64(i), 8)
+	case i >= math.MinInt8:
+		return append(buf, msgpackInt8, byte(int8(i)))
+	case i >= math.MinInt16:
+		return appendBigEndian(append(buf, msgpackInt16), uint64(int16(i)), 2)
+	case i >= math.MinInt32:
+		return appendBigEndian(append(buf, msgpackInt32), uint64(int32(i)), 4)
+	default:
+		return appendBigEndian(append(buf, msgpackInt64), uint64(i), 8)
+	}
+}
+
+// appendBigEndian appends the n low order bytes of v to buf, most significant
+// first.
+func appendBigEndian(buf []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+	return buf
+}
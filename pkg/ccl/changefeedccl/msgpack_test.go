@@ -0,0 +1,261 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeMsgpack decodes the MessagePack value at the start of b into the Go
+// representation of JSON accepted by json.MakeJSON, and returns the rest of
+// b. It only supports the types written by appendMsgpack.
+func decodeMsgpack(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errors.New(`unexpected end of input`)
+	}
+	be := func(n int) uint64 {
+		var v uint64
+		for _, c := range b[1 : 1+n] {
+			v = v<<8 | uint64(c)
+		}
+		return v
+	}
+	number := func(i int64, n int) (interface{}, []byte, error) {
+		return gojson.Number(strconv.FormatInt(i, 10)), b[1+n:], nil
+	}
+	str := func(n, lenBytes int) (interface{}, []byte, error) {
+		rest := b[1+lenBytes:]
+		return string(rest[:n]), rest[n:], nil
+	}
+	array := func(n, lenBytes int) (interface{}, []byte, error) {
+		rest := b[1+lenBytes:]
+		arr := make([]interface{}, n)
+		for i := range arr {
+			var err error
+			if arr[i], rest, err = decodeMsgpack(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return arr, rest, nil
+	}
+	object := func(n, lenBytes int) (interface{}, []byte, error) {
+		rest := b[1+lenBytes:]
+		obj := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, r, err := decodeMsgpack(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			if obj[k.(string)], rest, err = decodeMsgpack(r); err != nil {
+				return nil, nil, err
+			}
+		}
+		return obj, rest, nil
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return number(int64(c), 0)
+	case c >= 0xe0:
+		return number(int64(int8(c)), 0)
+	case c&0xf0 == msgpackFixMap:
+		return object(int(c&0x0f), 0)
+	case c&0xf0 == msgpackFixArray:
+		return array(int(c&0x0f), 0)
+	case c&0xe0 == msgpackFixStr:
+		return str(int(c&0x1f), 0)
+	}
+	switch b[0] {
+	case msgpackNil:
+		return nil, b[1:], nil
+	case msgpackFalse:
+		return false, b[1:], nil
+	case msgpackTrue:
+		return true, b[1:], nil
+	case msgpackFloat64:
+		f := math.Float64frombits(be(8))
+		return gojson.Number(strconv.FormatFloat(f, 'g', -1, 64)), b[9:], nil
+	case msgpackUint8:
+		return number(int64(be(1)), 1)
+	case msgpackUint16:
+		return number(int64(be(2)), 2)
+	case msgpackUint32:
+		return number(int64(be(4)), 4)
+	case msgpackUint64:
+		return gojson.Number(strconv.FormatUint(be(8), 10)), b[9:], nil
+	case msgpackInt8:
+		return number(int64(int8(be(1))), 1)
+	case msgpackInt16:
+		return number(int64(int16(be(2))), 2)
+	case msgpackInt32:
+		return number(int64(int32(be(4))), 4)
+	case msgpackInt64:
+		return number(int64(be(8)), 8)
+	case msgpackStr8:
+		return str(int(be(1)), 1)
+	case msgpackStr16:
+		return str(int(be(2)), 2)
+	case msgpackStr32:
+		return str(int(be(4)), 4)
+	case msgpackArray16:
+		return array(int(be(2)), 2)
+	case msgpackArray32:
+		return array(int(be(4)), 4)
+	case msgpackMap16:
+		return object(int(be(2)), 2)
+	case msgpackMap32:
+		return object(int(be(4)), 4)
+	}
+	return nil, nil, errors.Errorf(`unsupported MessagePack type 0x%x`, b[0])
+}
+
+// msgpackToJSON decodes a MessagePack payload to the form of the payloads of
+// the JSON encoder.
+func msgpackToJSON(t *testing.T, b []byte) string {
+	t.Helper()
+	if b == nil {
+		return ``
+	}
+	v, rest, err := decodeMsgpack(b)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+	j, err := json.MakeJSON(v)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	j.Format(&buf)
+	return buf.String()
+}
+
+func TestMsgpackEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(
+		`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c FLOAT, d DECIMAL, e BOOL, f INT[], g JSONB)`)
+	require.NoError(t, err)
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+
+	ints := tree.NewDArray(types.Int)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, ints.Append(tree.NewDInt(tree.DInt(i*1000))))
+	}
+	doc, err := json.ParseJSON(`{"x": [1, -1, -32, -33, 255, 65536, 4294967296, -129, -40000, -3000000000],` +
+		` "y": null, "z": false, "w": 0.25}`)
+	require.NoError(t, err)
+	row := func(a int64, b string) rowenc.EncDatumRow {
+		d, err := tree.ParseDDecimal(`1.25`)
+		require.NoError(t, err)
+		return rowenc.EncDatumRow{
+			rowenc.EncDatum{Datum: tree.NewDInt(tree.DInt(a))},
+			rowenc.EncDatum{Datum: tree.NewDString(b)},
+			rowenc.EncDatum{Datum: tree.NewDFloat(-2.5)},
+			rowenc.EncDatum{Datum: d},
+			rowenc.EncDatum{Datum: tree.DBoolTrue},
+			rowenc.EncDatum{Datum: ints},
+			rowenc.EncDatum{Datum: tree.NewDJSON(doc)},
+		}
+	}
+	ts := hlc.Timestamp{WallTime: 1, Logical: 2}
+	rows := []encodeRow{
+		{datums: row(1, `short`), updated: ts, mvccTimestamp: ts, tableDesc: tableDesc},
+		{
+			datums: row(-100000, strings.Repeat(`x`, 40)), updated: ts, mvccTimestamp: ts, tableDesc: tableDesc,
+			prevDatums: row(-100000, strings.Repeat(`y`, 300)), prevTableDesc: tableDesc,
+		},
+		{
+			datums: row(math.MaxInt64, ``), updated: ts, mvccTimestamp: ts, tableDesc: tableDesc,
+			deleted: true, prevDatums: row(math.MaxInt64, `z`), prevTableDesc: tableDesc,
+		},
+	}
+
+	for _, opts := range []map[string]string{
+		{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped)},
+		{
+			changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeWrapped),
+			changefeedbase.OptUpdatedTimestamps: ``,
+			changefeedbase.OptMVCCTimestamps:    ``,
+			changefeedbase.OptDiff:              ``,
+			changefeedbase.OptKeyInValue:        ``,
+			changefeedbase.OptTopicInValue:      ``,
+		},
+		{
+			changefeedbase.OptEnvelope:  string(changefeedbase.OptEnvelopeWrapped),
+			changefeedbase.OptKeyFormat: string(changefeedbase.OptKeyFormatObject),
+		},
+		{
+			changefeedbase.OptEnvelope:     string(changefeedbase.OptEnvelopeWrapped),
+			changefeedbase.OptDeleteFormat: string(changefeedbase.OptDeleteFormatNull),
+		},
+		{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeRow)},
+		{changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeKeyOnly)},
+	} {
+		jsonEnc, err := getEncoder(opts, targets)
+		require.NoError(t, err)
+		msgpackOpts := map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatMsgpack)}
+		for k, v := range opts {
+			msgpackOpts[k] = v
+		}
+		msgpackEnc, err := getEncoder(msgpackOpts, targets)
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		for _, r := range rows {
+			expected, err := jsonEnc.EncodeKey(ctx, r)
+			require.NoError(t, err)
+			actual, err := msgpackEnc.EncodeKey(ctx, r)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), msgpackToJSON(t, actual))
+
+			expected, err = jsonEnc.EncodeValue(ctx, r)
+			require.NoError(t, err)
+			actual, err = msgpackEnc.EncodeValue(ctx, r)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), msgpackToJSON(t, actual), "%v", opts)
+		}
+
+		expected, err := jsonEnc.EncodeResolvedTimestamp(ctx, `foo`, ts)
+		require.NoError(t, err)
+		actual, err := msgpackEnc.EncodeResolvedTimestamp(ctx, `foo`, ts)
+		require.NoError(t, err)
+		expectedJSON, err := json.ParseJSON(string(expected))
+		require.NoError(t, err)
+		require.Equal(t, expectedJSON.String(), msgpackToJSON(t, actual))
+	}
+
+	// Numbers which a float64 can't hold exactly are strings.
+	d, err := tree.ParseDDecimal(`1.00000000000000000000001`)
+	require.NoError(t, err)
+	j, err := tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+	require.NoError(t, err)
+	b, err := appendMsgpack(nil, j)
+	require.NoError(t, err)
+	require.Equal(t, `"1.00000000000000000000001"`, msgpackToJSON(t, b))
+}
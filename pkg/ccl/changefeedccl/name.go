@@ -14,11 +14,18 @@ import (
 	"strconv"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/cockroachdb/errors"
 )
 
 var escapeRE = regexp.MustCompile(`_u[0-9a-fA-F]{2,8}_`)
 var kafkaDisallowedRE = regexp.MustCompile(`[^a-zA-Z0-9\._\-]`)
 var avroDisallowedRE = regexp.MustCompile(`[^A-Za-z0-9_]`)
+var avroNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroTableToken is replaced by the name of the table, escaped with
+// SQLNameToAvroName, in the avro_namespace and avro_record_name options.
+const avroTableToken = `{table}`
 
 func escapeRune(r rune) string {
 	if r <= 1<<16 {
@@ -75,6 +82,32 @@ func SQLNameToAvroName(s string) string {
 	return escapeSQLName(s, avroDisallowedRE)
 }
 
+// expandAvroNameTemplate replaces avroTableToken in the template of an
+// avro_namespace or avro_record_name option with the name of a table.
+func expandAvroNameTemplate(template, tableName string) string {
+	return strings.ReplaceAll(template, avroTableToken, SQLNameToAvroName(tableName))
+}
+
+// validateAvroName returns an error if s isn't a valid avro record name.
+func validateAvroName(s string) error {
+	if !avroNameRE.MatchString(s) {
+		return errors.Errorf(`%q is not a valid avro name: names must match [A-Za-z_][A-Za-z0-9_]*`, s)
+	}
+	return nil
+}
+
+// validateAvroNamespace returns an error if s isn't a valid avro namespace,
+// which is a dot-separated sequence of names.
+func validateAvroNamespace(s string) error {
+	for _, name := range strings.Split(s, `.`) {
+		if !avroNameRE.MatchString(name) {
+			return errors.Errorf(`%q is not a valid avro namespace: it must be a dot-separated `+
+				`sequence of names matching [A-Za-z_][A-Za-z0-9_]*`, s)
+		}
+	}
+	return nil
+}
+
 // AvroNameToSQLName is the inverse of SQLNameToAvroName.
 func AvroNameToSQLName(s string) string {
 	return unescapeSQLName(s)
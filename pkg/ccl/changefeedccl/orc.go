@@ -0,0 +1,629 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	gojson "encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc/valueside"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// This file contains the encoder and the file writer used by the cloud storage
+// sink for `format=orc`. ORC is a columnar format, so rows can't be written
+// out one at a time as they are for the other formats. Instead, orcEncoder
+// encodes each row as a sequence of value-encoded datums, which the cloud
+// storage sink decodes and buffers in an orcWriter per file.
+//
+// The writer only produces the subset of the format needed by changefeeds:
+// files are uncompressed, all columns use the DIRECT encoding with version 1
+// run length encoding, and no row indexes are written. See
+// https://orc.apache.org/specification/ORCv1/ for the details.
+
+// orcMagic is written at the start of each ORC file and in its postscript.
+const orcMagic = `ORC`
+
+// orcTargetStripeSize is the approximate amount of row data buffered before
+// it is written out as a stripe. Files are only rotated once the current
+// stripe is complete, so a stripe never spans two files.
+const orcTargetStripeSize = 4 << 20 // 4MB
+
+// orcTimestampBase is the epoch of ORC timestamps, which are stored as the
+// number of seconds since 2015-01-01 00:00:00 UTC.
+var orcTimestampBase = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// The names of the columns holding envelope metadata in ORC files.
+const (
+	orcDeletedColumn = `__crdb__deleted`
+	orcUpdatedColumn = `__crdb__updated`
+)
+
+// orcTypeKind is the Type.Kind enum of the ORC file footer.
+type orcTypeKind uint64
+
+const (
+	orcBoolean   orcTypeKind = 0
+	orcShort     orcTypeKind = 2
+	orcInt       orcTypeKind = 3
+	orcLong      orcTypeKind = 4
+	orcFloat     orcTypeKind = 5
+	orcDouble    orcTypeKind = 6
+	orcString    orcTypeKind = 7
+	orcBinary    orcTypeKind = 8
+	orcTimestamp orcTypeKind = 9
+	orcStruct    orcTypeKind = 12
+	orcDate      orcTypeKind = 15
+	// orcTimestampInstant is a timestamp which is always interpreted in UTC,
+	// rather than in the time zone of the reader. It's used for the TIMESTAMPTZ
+	// columns of Iceberg tables.
+	orcTimestampInstant orcTypeKind = 18
+)
+
+// orcStreamKind is the Stream.Kind enum of ORC stripe footers.
+type orcStreamKind uint64
+
+const (
+	orcStreamPresent   orcStreamKind = 0
+	orcStreamData      orcStreamKind = 1
+	orcStreamLength    orcStreamKind = 2
+	orcStreamSecondary orcStreamKind = 5
+)
+
+// orcKindForType returns the ORC type used to store columns of the given SQL
+// type. Types without an ORC counterpart are stored as strings. This includes
+// decimals, which may hold values such as NaN that ORC decimals can't
+// represent.
+func orcKindForType(typ *types.T) orcTypeKind {
+	switch typ.Family() {
+	case types.BoolFamily:
+		return orcBoolean
+	case types.IntFamily:
+		switch typ.Width() {
+		case 16:
+			return orcShort
+		case 32:
+			return orcInt
+		default:
+			return orcLong
+		}
+	case types.FloatFamily:
+		if typ.Width() == 32 {
+			return orcFloat
+		}
+		return orcDouble
+	case types.BytesFamily:
+		return orcBinary
+	case types.DateFamily:
+		return orcDate
+	case types.TimestampFamily, types.TimestampTZFamily:
+		return orcTimestamp
+	default:
+		return orcString
+	}
+}
+
+// orcOptions are the changefeed options which determine the columns of ORC
+// files.
+type orcOptions struct {
+	updatedField bool
+	omitVirtual  bool
+}
+
+func makeORCOptions(opts map[string]string) orcOptions {
+	_, updated := opts[changefeedbase.OptUpdatedTimestamps]
+	return orcOptions{
+		updatedField: updated,
+		omitVirtual:  opts[changefeedbase.OptVirtualColumns] == string(changefeedbase.OptVirtualColumnsOmitted),
+	}
+}
+
+// includeColumn returns whether the given table column is written to ORC
+// files.
+func (o orcOptions) includeColumn(col catalog.Column) bool {
+	return !(o.omitVirtual && col.IsVirtual())
+}
+
+// orcEncoder encodes changefeed rows for the ORC writer of the cloud storage
+// sink. The value is the value encoding of every written table column,
+// followed by the envelope metadata columns. Keys aren't written, as the
+// primary key columns are included in the value.
+type orcEncoder struct {
+	opts    orcOptions
+	alloc   tree.DatumAlloc
+	buf     []byte
+	scratch []byte
+}
+
+var _ Encoder = &orcEncoder{}
+
+func makeORCEncoder(opts map[string]string) (*orcEncoder, error) {
+	if _, ok := opts[changefeedbase.OptDiff]; ok {
+		return nil, errors.Errorf(`%s is not supported with %s=%s`,
+			changefeedbase.OptDiff, changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+	}
+	return &orcEncoder{opts: makeORCOptions(opts)}, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *orcEncoder) EncodeKey(context.Context, encodeRow) ([]byte, error) {
+	return nil, nil
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *orcEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
+	e.buf = e.buf[:0]
+	var err error
+	for i, col := range row.tableDesc.PublicColumns() {
+		if !e.opts.includeColumn(col) {
+			continue
+		}
+		datum := row.datums[i]
+		if err := datum.EnsureDecoded(col.GetType(), &e.alloc); err != nil {
+			return nil, err
+		}
+		if e.buf, err = valueside.Encode(e.buf, valueside.NoColumnID, datum.Datum, e.scratch); err != nil {
+			return nil, err
+		}
+	}
+	if e.buf, err = valueside.Encode(
+		e.buf, valueside.NoColumnID, tree.MakeDBool(tree.DBool(row.deleted)), e.scratch,
+	); err != nil {
+		return nil, err
+	}
+	if e.opts.updatedField {
+		if e.buf, err = valueside.Encode(
+			e.buf, valueside.NoColumnID, tree.NewDString(row.updated.AsOfSystemTime()), e.scratch,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface. Resolved
+// timestamp files are written as JSON, as they are for the other formats.
+func (e *orcEncoder) EncodeResolvedTimestamp(
+	_ context.Context, _ string, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return gojson.Marshal(map[string]interface{}{
+		`resolved`: tree.TimestampToDecimalDatum(resolved).Decimal.String(),
+	})
+}
+
+// orcColumn buffers the values of one column of the current stripe.
+type orcColumn struct {
+	name string
+	typ  *types.T
+	kind orcTypeKind
+
+	// present has an entry for every row of the stripe; the remaining slices
+	// only have entries for non-NULL values.
+	present   []bool
+	hasNull   bool
+	bools     []bool
+	ints      []int64
+	floats    []float64
+	data      []byte
+	lengths   []int64
+	secondary []int64
+
+	// fileValues and fileHasNull are the column statistics of the whole file.
+	fileValues  uint64
+	fileHasNull bool
+
+	// icebergID, if non-zero, is the Iceberg field ID of the column, which is
+	// written to the file footer as the iceberg.id attribute of its type.
+	icebergID int
+}
+
+func (c *orcColumn) add(d tree.Datum) {
+	c.present = append(c.present, d != tree.DNull)
+	if d == tree.DNull {
+		c.hasNull = true
+		c.fileHasNull = true
+		return
+	}
+	c.fileValues++
+	switch c.kind {
+	case orcBoolean:
+		c.bools = append(c.bools, bool(*d.(*tree.DBool)))
+	case orcShort, orcInt, orcLong:
+		c.ints = append(c.ints, int64(*d.(*tree.DInt)))
+	case orcFloat, orcDouble:
+		c.floats = append(c.floats, float64(*d.(*tree.DFloat)))
+	case orcBinary:
+		b := *d.(*tree.DBytes)
+		c.data = append(c.data, b...)
+		c.lengths = append(c.lengths, int64(len(b)))
+	case orcDate:
+		c.ints = append(c.ints, d.(*tree.DDate).UnixEpochDays())
+	case orcTimestamp, orcTimestampInstant:
+		var t time.Time
+		switch d := d.(type) {
+		case *tree.DTimestamp:
+			t = d.Time
+		case *tree.DTimestampTZ:
+			t = d.Time
+		}
+		c.ints = append(c.ints, t.Unix()-orcTimestampBase)
+		c.secondary = append(c.secondary, orcFormatNanos(int64(t.Nanosecond())))
+	default:
+		var s string
+		switch d := d.(type) {
+		case *tree.DString:
+			s = string(*d)
+		case *tree.DCollatedString:
+			s = d.Contents
+		default:
+			s = tree.AsStringWithFlags(d, tree.FmtBareStrings)
+		}
+		c.data = append(c.data, s...)
+		c.lengths = append(c.lengths, int64(len(s)))
+	}
+}
+
+// appendStreams appends the streams of the column for the current stripe to
+// buf, calling addStream with the kind and length of each one.
+func (c *orcColumn) appendStreams(
+	buf []byte, addStream func(kind orcStreamKind, length int),
+) []byte {
+	emit := func(kind orcStreamKind, appendStream func([]byte) []byte) {
+		start := len(buf)
+		buf = appendStream(buf)
+		addStream(kind, len(buf)-start)
+	}
+	if c.hasNull {
+		emit(orcStreamPresent, func(b []byte) []byte { return orcAppendBoolRLE(b, c.present) })
+	}
+	switch c.kind {
+	case orcBoolean:
+		emit(orcStreamData, func(b []byte) []byte { return orcAppendBoolRLE(b, c.bools) })
+	case orcShort, orcInt, orcLong, orcDate:
+		emit(orcStreamData, func(b []byte) []byte { return orcAppendIntRLE(b, c.ints, true /* signed */) })
+	case orcFloat:
+		emit(orcStreamData, func(b []byte) []byte {
+			var scratch [4]byte
+			for _, f := range c.floats {
+				binary.LittleEndian.PutUint32(scratch[:], math.Float32bits(float32(f)))
+				b = append(b, scratch[:]...)
+			}
+			return b
+		})
+	case orcDouble:
+		emit(orcStreamData, func(b []byte) []byte {
+			var scratch [8]byte
+			for _, f := range c.floats {
+				binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(f))
+				b = append(b, scratch[:]...)
+			}
+			return b
+		})
+	case orcTimestamp, orcTimestampInstant:
+		emit(orcStreamData, func(b []byte) []byte { return orcAppendIntRLE(b, c.ints, true /* signed */) })
+		emit(orcStreamSecondary, func(b []byte) []byte { return orcAppendIntRLE(b, c.secondary, false /* signed */) })
+	default:
+		emit(orcStreamData, func(b []byte) []byte { return append(b, c.data...) })
+		emit(orcStreamLength, func(b []byte) []byte { return orcAppendIntRLE(b, c.lengths, false /* signed */) })
+	}
+	return buf
+}
+
+func (c *orcColumn) resetStripe() {
+	c.present = c.present[:0]
+	c.hasNull = false
+	c.bools = c.bools[:0]
+	c.ints = c.ints[:0]
+	c.floats = c.floats[:0]
+	c.data = c.data[:0]
+	c.lengths = c.lengths[:0]
+	c.secondary = c.secondary[:0]
+}
+
+type orcStripeInfo struct {
+	offset, dataLength, footerLength, numRows uint64
+}
+
+// orcWriter assembles an ORC file for rows encoded by orcEncoder. Complete
+// stripes are written to out as soon as they reach orcTargetStripeSize, the
+// file footer is written by finish.
+type orcWriter struct {
+	out     *bytes.Buffer
+	columns []*orcColumn
+	alloc   tree.DatumAlloc
+
+	stripes     []orcStripeInfo
+	numRows     uint64
+	stripeRows  uint64
+	stripeBytes int
+	scratch     []byte
+}
+
+func makeORCWriter(
+	desc catalog.TableDescriptor, opts orcOptions, out *bytes.Buffer,
+) *orcWriter {
+	var columns []*orcColumn
+	for _, col := range desc.PublicColumns() {
+		if !opts.includeColumn(col) {
+			continue
+		}
+		columns = append(columns, &orcColumn{
+			name: col.GetName(), typ: col.GetType(), kind: orcKindForType(col.GetType()),
+		})
+	}
+	columns = append(columns, &orcColumn{name: orcDeletedColumn, typ: types.Bool, kind: orcBoolean})
+	if opts.updatedField {
+		columns = append(columns, &orcColumn{name: orcUpdatedColumn, typ: types.String, kind: orcString})
+	}
+	return makeORCWriterForColumns(columns, out)
+}
+
+// makeORCWriterForColumns returns a writer of a file with the given columns,
+// whose rows are added with addDatums.
+func makeORCWriterForColumns(columns []*orcColumn, out *bytes.Buffer) *orcWriter {
+	out.WriteString(orcMagic)
+	return &orcWriter{out: out, columns: columns}
+}
+
+// addRow buffers a row encoded by orcEncoder, writing out the current stripe
+// if it is full.
+func (w *orcWriter) addRow(value []byte) error {
+	w.stripeBytes += len(value)
+	for _, c := range w.columns {
+		d, rest, err := valueside.Decode(&w.alloc, c.typ, value)
+		if err != nil {
+			return err
+		}
+		c.add(d)
+		value = rest
+	}
+	if len(value) != 0 {
+		return errors.AssertionFailedf(`%d unexpected trailing bytes in ORC row`, len(value))
+	}
+	w.finishRow()
+	return nil
+}
+
+// addDatums buffers a row holding a decoded datum for each column, writing
+// out the current stripe if it is full.
+func (w *orcWriter) addDatums(datums tree.Datums) error {
+	if len(datums) != len(w.columns) {
+		return errors.AssertionFailedf(`expected %d datums in ORC row, found %d`,
+			len(w.columns), len(datums))
+	}
+	for i, c := range w.columns {
+		w.stripeBytes += int(datums[i].Size())
+		c.add(datums[i])
+	}
+	w.finishRow()
+	return nil
+}
+
+func (w *orcWriter) finishRow() {
+	w.stripeRows++
+	w.numRows++
+	if w.stripeBytes >= orcTargetStripeSize {
+		w.flushStripe()
+	}
+}
+
+// size returns the approximate size of the file, including the buffered
+// rows of the current stripe.
+func (w *orcWriter) size() int {
+	return w.out.Len() + w.stripeBytes
+}
+
+// flushStripe writes the buffered rows to out as a stripe.
+func (w *orcWriter) flushStripe() {
+	if w.stripeRows == 0 {
+		return
+	}
+	offset := w.out.Len()
+	var footer []byte
+	data := w.scratch[:0]
+	for i, c := range w.columns {
+		column := uint64(i + 1)
+		data = c.appendStreams(data, func(kind orcStreamKind, length int) {
+			var stream []byte
+			stream = orcAppendProtoVarint(stream, 1, uint64(kind))
+			stream = orcAppendProtoVarint(stream, 2, column)
+			stream = orcAppendProtoVarint(stream, 3, uint64(length))
+			footer = orcAppendProtoBytes(footer, 1, stream)
+		})
+		c.resetStripe()
+	}
+	// Every column, including the root struct, uses the DIRECT encoding.
+	directEncoding := orcAppendProtoVarint(nil, 1, 0)
+	for i := 0; i <= len(w.columns); i++ {
+		footer = orcAppendProtoBytes(footer, 2, directEncoding)
+	}
+	footer = orcAppendProtoBytes(footer, 3, []byte(`UTC`))
+
+	w.out.Write(data)
+	w.out.Write(footer)
+	w.scratch = data[:0]
+	w.stripes = append(w.stripes, orcStripeInfo{
+		offset:       uint64(offset),
+		dataLength:   uint64(len(data)),
+		footerLength: uint64(len(footer)),
+		numRows:      w.stripeRows,
+	})
+	w.stripeRows = 0
+	w.stripeBytes = 0
+}
+
+// finish writes out the last stripe followed by the file footer and
+// postscript. The writer must not be used afterwards.
+func (w *orcWriter) finish() {
+	w.flushStripe()
+
+	var footer []byte
+	footer = orcAppendProtoVarint(footer, 1, uint64(len(orcMagic)))
+	footer = orcAppendProtoVarint(footer, 2, uint64(w.out.Len()))
+	for _, s := range w.stripes {
+		var stripe []byte
+		stripe = orcAppendProtoVarint(stripe, 1, s.offset)
+		stripe = orcAppendProtoVarint(stripe, 2, 0 /* indexLength */)
+		stripe = orcAppendProtoVarint(stripe, 3, s.dataLength)
+		stripe = orcAppendProtoVarint(stripe, 4, s.footerLength)
+		stripe = orcAppendProtoVarint(stripe, 5, s.numRows)
+		footer = orcAppendProtoBytes(footer, 3, stripe)
+	}
+
+	// The root of the schema is a struct with one field per column.
+	var root, subtypes []byte
+	root = orcAppendProtoVarint(root, 1, uint64(orcStruct))
+	for i := range w.columns {
+		subtypes = orcAppendUvarint(subtypes, uint64(i+1))
+	}
+	root = orcAppendProtoBytes(root, 2, subtypes)
+	for _, c := range w.columns {
+		root = orcAppendProtoBytes(root, 3, []byte(c.name))
+	}
+	footer = orcAppendProtoBytes(footer, 4, root)
+	for _, c := range w.columns {
+		typ := orcAppendProtoVarint(nil, 1, uint64(c.kind))
+		if c.icebergID != 0 {
+			var attr []byte
+			attr = orcAppendProtoBytes(attr, 1, []byte(`iceberg.id`))
+			attr = orcAppendProtoBytes(attr, 2, []byte(strconv.Itoa(c.icebergID)))
+			typ = orcAppendProtoBytes(typ, 7, attr)
+		}
+		footer = orcAppendProtoBytes(footer, 4, typ)
+	}
+	footer = orcAppendProtoVarint(footer, 6, w.numRows)
+
+	footer = orcAppendProtoBytes(footer, 7, orcAppendProtoVarint(nil, 1, w.numRows))
+	for _, c := range w.columns {
+		stats := orcAppendProtoVarint(nil, 1, c.fileValues)
+		if c.fileHasNull {
+			stats = orcAppendProtoVarint(stats, 10, 1)
+		}
+		footer = orcAppendProtoBytes(footer, 7, stats)
+	}
+	footer = orcAppendProtoVarint(footer, 8, 0 /* rowIndexStride */)
+
+	var postscript []byte
+	postscript = orcAppendProtoVarint(postscript, 1, uint64(len(footer)))
+	postscript = orcAppendProtoVarint(postscript, 2, 0 /* compression: NONE */)
+	postscript = orcAppendProtoBytes(postscript, 4, []byte{0, 12} /* version 0.12 */)
+	postscript = orcAppendProtoVarint(postscript, 5, 0 /* metadataLength */)
+	postscript = orcAppendProtoBytes(postscript, 8000, []byte(orcMagic))
+
+	w.out.Write(footer)
+	w.out.Write(postscript)
+	w.out.WriteByte(byte(len(postscript)))
+}
+
+// orcFormatNanos encodes the nanoseconds of a timestamp as ORC does, with the
+// number of trailing decimal zeros stored in the low three bits.
+func orcFormatNanos(nanos int64) int64 {
+	if nanos == 0 {
+		return 0
+	}
+	if nanos%100 != 0 {
+		return nanos << 3
+	}
+	nanos /= 100
+	trailingZeros := int64(1)
+	for nanos%10 == 0 && trailingZeros < 7 {
+		nanos /= 10
+		trailingZeros++
+	}
+	return nanos<<3 | trailingZeros
+}
+
+// orcMaxLiteralRun is the maximum number of values in a literal run of the
+// ORC run length encodings.
+const orcMaxLiteralRun = 128
+
+// orcAppendByteRLE appends vals to buf using the ORC byte run length
+// encoding. Only literal runs are written.
+func orcAppendByteRLE(buf []byte, vals []byte) []byte {
+	for len(vals) > 0 {
+		n := len(vals)
+		if n > orcMaxLiteralRun {
+			n = orcMaxLiteralRun
+		}
+		buf = append(buf, byte(-n))
+		buf = append(buf, vals[:n]...)
+		vals = vals[n:]
+	}
+	return buf
+}
+
+// orcAppendBoolRLE appends vals to buf as a bitmap, most significant bit
+// first, using the ORC byte run length encoding.
+func orcAppendBoolRLE(buf []byte, vals []bool) []byte {
+	packed := make([]byte, (len(vals)+7)/8)
+	for i, v := range vals {
+		if v {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return orcAppendByteRLE(buf, packed)
+}
+
+// orcAppendIntRLE appends vals to buf using version 1 of the ORC integer run
+// length encoding. Only literal runs are written.
+func orcAppendIntRLE(buf []byte, vals []int64, signed bool) []byte {
+	for len(vals) > 0 {
+		n := len(vals)
+		if n > orcMaxLiteralRun {
+			n = orcMaxLiteralRun
+		}
+		buf = append(buf, byte(-n))
+		for _, v := range vals[:n] {
+			if signed {
+				buf = orcAppendVarint(buf, v)
+			} else {
+				buf = orcAppendUvarint(buf, uint64(v))
+			}
+		}
+		vals = vals[n:]
+	}
+	return buf
+}
+
+func orcAppendUvarint(buf []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buf, scratch[:binary.PutUvarint(scratch[:], v)]...)
+}
+
+// orcAppendVarint appends the zigzag varint encoding of v, which is the one
+// used by both ORC and protobuf.
+func orcAppendVarint(buf []byte, v int64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buf, scratch[:binary.PutVarint(scratch[:], v)]...)
+}
+
+// orcAppendProtoVarint appends a protobuf varint field. The ORC file metadata
+// is made up of a handful of protobuf messages, which are simple enough to
+// encode by hand.
+func orcAppendProtoVarint(buf []byte, field int, v uint64) []byte {
+	buf = orcAppendUvarint(buf, uint64(field)<<3)
+	return orcAppendUvarint(buf, v)
+}
+
+// orcAppendProtoBytes appends a protobuf length-delimited field.
+func orcAppendProtoBytes(buf []byte, field int, b []byte) []byte {
+	buf = orcAppendUvarint(buf, uint64(field)<<3|2)
+	buf = orcAppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
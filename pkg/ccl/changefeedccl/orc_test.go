@@ -0,0 +1,192 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// orcProtoMessage is a decoded protobuf message, mapping field numbers to
+// their varint or length-delimited values in order of appearance.
+type orcProtoMessage map[int][]interface{}
+
+func parseORCProto(t *testing.T, b []byte) orcProtoMessage {
+	m := make(orcProtoMessage)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			m[field] = append(m[field], v)
+		case 2:
+			l, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			m[field] = append(m[field], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf(`unexpected wire type %d`, tag&7)
+		}
+	}
+	return m
+}
+
+func (m orcProtoMessage) uint(field int) uint64 {
+	return m[field][0].(uint64)
+}
+
+func (m orcProtoMessage) message(t *testing.T, field int, i int) orcProtoMessage {
+	return parseORCProto(t, m[field][i].([]byte))
+}
+
+// readORCIntRLE decodes n values written using version 1 of the ORC integer
+// run length encoding with literal runs.
+func readORCIntRLE(t *testing.T, b []byte, n int, signed bool) []int64 {
+	var vals []int64
+	for len(vals) < n {
+		run := -int(int8(b[0]))
+		require.True(t, run > 0, `expected a literal run`)
+		b = b[1:]
+		for i := 0; i < run; i++ {
+			if signed {
+				v, l := binary.Varint(b)
+				vals, b = append(vals, v), b[l:]
+			} else {
+				v, l := binary.Uvarint(b)
+				vals, b = append(vals, int64(v)), b[l:]
+			}
+		}
+	}
+	return vals
+}
+
+func TestORCWriter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	tableDesc, err := parseTableDesc(
+		`CREATE TABLE foo (a INT PRIMARY KEY, b STRING, c FLOAT, d BOOL, e TIMESTAMP, f DECIMAL)`)
+	require.NoError(t, err)
+	rows, err := parseValues(tableDesc, `VALUES
+		(1, 'one', 1.5, true, '2022-01-02 03:04:05.6', 1.23),
+		(2, NULL, NULL, false, NULL, NULL),
+		(-3, 'three', 3, NULL, '2015-01-01', 'NaN')`)
+	require.NoError(t, err)
+
+	opts := map[string]string{
+		changefeedbase.OptFormat:            string(changefeedbase.OptFormatORC),
+		changefeedbase.OptUpdatedTimestamps: ``,
+	}
+	encoder, err := makeORCEncoder(opts)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := makeORCWriter(tableDesc, makeORCOptions(opts), &buf)
+	for i, row := range rows {
+		value, err := encoder.EncodeValue(context.Background(), encodeRow{
+			datums:    row,
+			updated:   hlc.Timestamp{WallTime: int64(i + 1)},
+			deleted:   i == 1,
+			tableDesc: tableDesc,
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.addRow(value))
+	}
+	// Rows are buffered until the stripe is written.
+	require.Equal(t, len(orcMagic), buf.Len())
+	w.finish()
+
+	b := buf.Bytes()
+	require.Equal(t, orcMagic, string(b[:len(orcMagic)]))
+	psLen := int(b[len(b)-1])
+	ps := parseORCProto(t, b[len(b)-1-psLen:len(b)-1])
+	require.Equal(t, orcMagic, string(ps[8000][0].([]byte)))
+	require.Equal(t, uint64(0), ps.uint(2), `compression`)
+	footerLen := int(ps.uint(1))
+	footer := parseORCProto(t, b[len(b)-1-psLen-footerLen:len(b)-1-psLen])
+
+	require.Equal(t, uint64(3), footer.uint(6), `number of rows`)
+
+	// The schema is a struct of the table columns followed by the metadata
+	// columns.
+	root := footer.message(t, 4, 0)
+	require.Equal(t, uint64(orcStruct), root.uint(1))
+	var names []string
+	for _, name := range root[3] {
+		names = append(names, string(name.([]byte)))
+	}
+	require.Equal(t, []string{
+		`a`, `b`, `c`, `d`, `e`, `f`, orcDeletedColumn, orcUpdatedColumn,
+	}, names)
+	var kinds []orcTypeKind
+	for i := 1; i < len(footer[4]); i++ {
+		kinds = append(kinds, orcTypeKind(footer.message(t, 4, i).uint(1)))
+	}
+	require.Equal(t, []orcTypeKind{
+		orcLong, orcString, orcDouble, orcBoolean, orcTimestamp, orcString, orcBoolean, orcString,
+	}, kinds)
+
+	// Column statistics count the non-NULL values of each column.
+	var numValues []uint64
+	for i := range footer[7] {
+		numValues = append(numValues, footer.message(t, 7, i).uint(1))
+	}
+	require.Equal(t, []uint64{3, 3, 2, 2, 2, 2, 2, 3, 3}, numValues)
+
+	// Decode the streams of the single stripe.
+	require.Len(t, footer[3], 1)
+	stripe := footer.message(t, 3, 0)
+	require.Equal(t, uint64(3), stripe.uint(5))
+	offset, dataLen := stripe.uint(1), stripe.uint(3)
+	stripeFooter := parseORCProto(t, b[offset+dataLen:offset+dataLen+stripe.uint(4)])
+	require.Equal(t, `UTC`, string(stripeFooter[3][0].([]byte)))
+	require.Len(t, stripeFooter[2], len(names)+1, `column encodings`)
+
+	streams := make(map[[2]uint64][]byte)
+	pos := offset
+	for i := range stripeFooter[1] {
+		stream := stripeFooter.message(t, 1, i)
+		length := stream.uint(3)
+		streams[[2]uint64{stream.uint(2), stream.uint(1)}] = b[pos : pos+length]
+		pos += length
+	}
+	require.Equal(t, offset+dataLen, pos)
+
+	stream := func(column int, kind orcStreamKind) []byte {
+		return streams[[2]uint64{uint64(column), uint64(kind)}]
+	}
+	require.Equal(t, []int64{1, 2, -3}, readORCIntRLE(t, stream(1, orcStreamData), 3, true))
+	require.Nil(t, stream(1, orcStreamPresent), `a has no NULLs`)
+	// Present bitmap of b is 101 as a literal run of one byte.
+	require.Equal(t, []byte{0xff, 0xa0}, stream(2, orcStreamPresent))
+	require.Equal(t, `onethree`, string(stream(2, orcStreamData)))
+	require.Equal(t, []int64{3, 5}, readORCIntRLE(t, stream(2, orcStreamLength), 2, false))
+	require.Len(t, stream(3, orcStreamData), 16)
+	require.Equal(t, []byte{0xff, 0x80}, stream(4, orcStreamData))
+	require.Equal(t, []int64{221022245, 0}, readORCIntRLE(t, stream(5, orcStreamData), 2, true))
+	require.Equal(t, []int64{6<<3 | 7, 0}, readORCIntRLE(t, stream(5, orcStreamSecondary), 2, false))
+	require.Equal(t, `1.23NaN`, string(stream(6, orcStreamData)))
+	require.Equal(t, []byte{0xff, 0x40}, stream(7, orcStreamData), `deleted`)
+	require.Equal(t, `1.0000000000`+`2.0000000000`+`3.0000000000`,
+		string(stream(8, orcStreamData)))
+}
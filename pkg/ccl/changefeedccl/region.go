@@ -0,0 +1,64 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+// rowRegionField is the metadata field holding the region of each row, for
+// the region option.
+const rowRegionField = `crdb_region`
+
+// validateRegionColumn checks that the rows of a table have a region, for the
+// region option: the table must be REGIONAL BY ROW, and its region column,
+// crdb_region unless set with REGIONAL BY ROW AS, must exist.
+func validateRegionColumn(tableDesc catalog.TableDescriptor) error {
+	if !tableDesc.IsLocalityRegionalByRow() {
+		return errors.Errorf(`%s requires table %s to be REGIONAL BY ROW`,
+			changefeedbase.OptRegion, tableDesc.GetName())
+	}
+	name, err := tableDesc.GetRegionalByRowTableRegionColumnName()
+	if err != nil {
+		return err
+	}
+	if col, err := tableDesc.FindColumnWithName(name); err != nil || !col.Public() {
+		return errors.Errorf(`%s column %q does not exist in table %s`,
+			changefeedbase.OptRegion, name, tableDesc.GetName())
+	}
+	return nil
+}
+
+// rowRegion returns the value of the region column of a row, for the region
+// option, or nil if its table is no longer REGIONAL BY ROW. The region column
+// is part of the primary key of REGIONAL BY ROW tables, so the region of
+// deleted rows is known as well.
+func rowRegion(row encodeRow, alloc *tree.DatumAlloc) (tree.Datum, error) {
+	if !row.tableDesc.IsLocalityRegionalByRow() {
+		return nil, nil
+	}
+	name, err := row.tableDesc.GetRegionalByRowTableRegionColumnName()
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range row.tableDesc.PublicColumns() {
+		if col.GetName() != string(name) {
+			continue
+		}
+		datum := row.datums[i]
+		if err := datum.EnsureDecoded(col.GetType(), alloc); err != nil {
+			return nil, err
+		}
+		return datum.Datum, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"container/heap"
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/errors"
+)
+
+// rekeyField is the field of the wrapped envelope which holds the old and new
+// keys of a row whose primary key was changed, for the rekey option.
+const rekeyField = `rekey`
+
+// rekeyKind classifies the rows held by a rekeyBuffer.
+type rekeyKind int
+
+const (
+	// rekeyNone is a row which can't be half of a primary key change.
+	rekeyNone rekeyKind = iota
+	// rekeyDelete is the deletion of an existing row, which may be the old
+	// half of a primary key change.
+	rekeyDelete
+	// rekeyInsert is the insertion of a new row, which may be the new half of
+	// a primary key change.
+	rekeyInsert
+)
+
+// rekeyKindOf returns the kind of r.
+func rekeyKindOf(r encodeRow) rekeyKind {
+	switch {
+	case r.backfill:
+		return rekeyNone
+	case r.deleted && !r.prevDeleted:
+		return rekeyDelete
+	case !r.deleted && r.prevDeleted:
+		return rekeyInsert
+	default:
+		return rekeyNone
+	}
+}
+
+// rekeyValues returns the encoded values of the columns outside of the
+// primary key of the row inserted by r, or of the row deleted by r as it was
+// before its deletion, for a row of the given kind. A change of the primary
+// key of a row which leaves its other columns alone deletes and inserts rows
+// with the same values.
+func rekeyValues(r encodeRow, kind rekeyKind) (string, error) {
+	switch kind {
+	case rekeyDelete:
+		return encodeNonKeyValues(r.prevTableDesc, r.prevDatums)
+	case rekeyInsert:
+		return encodeNonKeyValues(r.tableDesc, r.datums)
+	default:
+		return ``, nil
+	}
+}
+
+func encodeNonKeyValues(desc catalog.TableDescriptor, datums rowenc.EncDatumRow) (string, error) {
+	if desc == nil {
+		return ``, nil
+	}
+	primaryIndex := desc.GetPrimaryIndex()
+	isKey := make(map[descpb.ColumnID]struct{}, primaryIndex.NumKeyColumns())
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		isKey[primaryIndex.GetKeyColumnID(i)] = struct{}{}
+	}
+	var alloc tree.DatumAlloc
+	var buf strings.Builder
+	for i, col := range desc.PublicColumns() {
+		if _, ok := isKey[col.GetID()]; ok || i >= len(datums) {
+			continue
+		}
+		if err := datums[i].EnsureDecoded(col.GetType(), &alloc); err != nil {
+			return ``, err
+		}
+		buf.WriteString(col.GetName())
+		buf.WriteByte('=')
+		buf.WriteString(tree.AsStringWithFlags(datums[i].Datum, tree.FmtParsable))
+		buf.WriteByte(',')
+	}
+	return buf.String(), nil
+}
+
+// rekeyBuffer holds the rows of a change aggregator back until its resolved
+// frontier passes them, to emit the primary key changes among them as single
+// rekey events, for the rekey option.
+//
+// The KV layer sees a change of the primary key of a row as the deletion of
+// the row under its old key and the insertion of a row under its new key, in
+// the same transaction. Once the frontier passes a timestamp, every row of
+// the aggregator's spans committed at it has been received. Since the
+// transaction isn't known, a primary key change is inferred, and only if the
+// rows of a table at that timestamp include exactly one deletion of an
+// existing row and one insertion of a new row, and the previous values of the
+// deleted row match the values of the inserted row outside of their primary
+// keys. The deletion is then dropped, and the insertion is emitted with the
+// rekey field added to its value, holding the old and new keys.
+//
+// The inference is a heuristic: a transaction which deletes a row and inserts
+// another with the same values under another key is emitted as a rekey
+// event. Conversely, the changes which can't be confirmed are emitted as
+// deletions and insertions: those which also change other columns of the row,
+// those of transactions changing the keys of several rows of a table, whose
+// deletions and insertions can't be paired up, and those whose old and new
+// keys fall in the spans of different aggregators.
+//
+// Rows are emitted in the order of their MVCC timestamps, so the order of the
+// changes of each key is kept, before the resolved timestamps which cover
+// them are forwarded. As with laggingSink, held rows are copied out of the
+// kvfeed's memory buffer and charged to acc, an account of the change
+// aggregator's memory monitor. Once it's exhausted, the oldest rows are
+// emitted early, unpaired.
+type rekeyBuffer struct {
+	sink Sink
+	acc  *mon.BoundAccount
+
+	held rekeyRowHeap
+	seq  uint64
+}
+
+// rekeyRow is a row held by a rekeyBuffer.
+type rekeyRow struct {
+	laggedRow
+	tableID descpb.ID
+	kind    rekeyKind
+	// values are the rekeyValues of the row, which confirm that a deletion and
+	// an insertion are the halves of a primary key change.
+	values string
+}
+
+func (r rekeyRow) size() int64 {
+	return r.laggedRow.size() + int64(len(r.values))
+}
+
+// rekeyRowHeap is a min-heap of rekeyRows ordered by MVCC timestamp.
+type rekeyRowHeap []rekeyRow
+
+func (h rekeyRowHeap) Len() int { return len(h) }
+func (h rekeyRowHeap) Less(i, j int) bool {
+	if h[i].mvcc.Equal(h[j].mvcc) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].mvcc.Less(h[j].mvcc)
+}
+func (h rekeyRowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *rekeyRowHeap) Push(x interface{}) { *h = append(*h, x.(rekeyRow)) }
+func (h *rekeyRowHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = rekeyRow{}
+	*h = old[:n-1]
+	return x
+}
+
+func makeRekeyBuffer(sink Sink, acc *mon.BoundAccount) *rekeyBuffer {
+	return &rekeyBuffer{sink: sink, acc: acc}
+}
+
+// emitRow holds back a row of the table tableID of the given kind, with the
+// given rekeyValues.
+func (b *rekeyBuffer) emitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	tableID descpb.ID,
+	kind rekeyKind,
+	values string,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	row := rekeyRow{
+		laggedRow: laggedRow{
+			topic:   topic,
+			key:     append([]byte(nil), key...),
+			value:   append([]byte(nil), value...),
+			updated: updated,
+			mvcc:    mvcc,
+		},
+		tableID: tableID,
+		kind:    kind,
+		values:  values,
+	}
+	alloc.Release(ctx)
+
+	for b.acc.Grow(ctx, row.size()) != nil {
+		if len(b.held) == 0 || row.mvcc.Less(b.held[0].mvcc) {
+			// The row is older than any held row, so it's emitted first.
+			return b.emit(ctx, row, nil /* oldKey */)
+		}
+		if err := b.emit(ctx, b.pop(ctx), nil /* oldKey */); err != nil {
+			return err
+		}
+	}
+	b.seq++
+	row.seq = b.seq
+	heap.Push(&b.held, row)
+	return nil
+}
+
+// release emits the held rows committed at or before resolved, the resolved
+// frontier of the change aggregator, pairing up the primary key changes among
+// them.
+func (b *rekeyBuffer) release(ctx context.Context, resolved hlc.Timestamp) error {
+	for len(b.held) > 0 && !resolved.Less(b.held[0].mvcc) {
+		mvcc := b.held[0].mvcc
+		var rows []rekeyRow
+		for len(b.held) > 0 && b.held[0].mvcc.Equal(mvcc) {
+			rows = append(rows, b.pop(ctx))
+		}
+		if err := b.emitCommitted(ctx, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitCommitted emits the rows committed at the same timestamp, in the order
+// in which they were received, pairing up the primary key changes among them.
+func (b *rekeyBuffer) emitCommitted(ctx context.Context, rows []rekeyRow) error {
+	deletes := make(map[descpb.ID][]int)
+	inserts := make(map[descpb.ID][]int)
+	for i, row := range rows {
+		switch row.kind {
+		case rekeyDelete:
+			deletes[row.tableID] = append(deletes[row.tableID], i)
+		case rekeyInsert:
+			inserts[row.tableID] = append(inserts[row.tableID], i)
+		}
+	}
+	// oldKeys maps the index of the insertion of each primary key change to
+	// the index of its deletion.
+	oldKeys := make(map[int]int)
+	for tableID, d := range deletes {
+		if i := inserts[tableID]; len(d) == 1 && len(i) == 1 && rows[d[0]].values == rows[i[0]].values {
+			oldKeys[i[0]] = d[0]
+		}
+	}
+	dropped := make(map[int]struct{}, len(oldKeys))
+	for _, d := range oldKeys {
+		dropped[d] = struct{}{}
+	}
+
+	for i, row := range rows {
+		if _, ok := dropped[i]; ok {
+			continue
+		}
+		var oldKey []byte
+		if d, ok := oldKeys[i]; ok {
+			oldKey = rows[d].key
+		}
+		if err := b.emit(ctx, row, oldKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pop removes the oldest held row.
+func (b *rekeyBuffer) pop(ctx context.Context) rekeyRow {
+	row := heap.Pop(&b.held).(rekeyRow)
+	b.acc.Shrink(ctx, row.size())
+	return row
+}
+
+// emit emits a row, as the new half of a change of its primary key from
+// oldKey if oldKey is set.
+func (b *rekeyBuffer) emit(ctx context.Context, row rekeyRow, oldKey []byte) error {
+	value := row.value
+	if oldKey != nil {
+		var err error
+		if value, err = appendRekey(oldKey, row.key, value); err != nil {
+			return err
+		}
+	}
+	return b.sink.EmitRow(ctx, row.topic, row.key, value, row.updated, row.mvcc, kvevent.Alloc{})
+}
+
+// close drops the held rows, which are emitted again when the changefeed
+// resumes from its last resolved timestamp.
+func (b *rekeyBuffer) close(ctx context.Context) {
+	b.held = nil
+	b.acc.Close(ctx)
+}
+
+// appendRekey adds the rekey field to value, the encoded wrapped envelope of
+// the row inserted under newKey by a change of its primary key from oldKey,
+// and returns the result. The field holds the old and new keys, as encoded in
+// the keys of messages:
+//
+//   {"after": {"a": 2, "b": "foo"}, "rekey": {"new_key": [2], "old_key": [1]}}
+//
+func appendRekey(oldKey, newKey, value []byte) ([]byte, error) {
+	envelope, err := json.ParseJSON(string(value))
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing envelope`)
+	}
+	it, err := envelope.ObjectIter()
+	if err != nil {
+		return nil, err
+	}
+	if it == nil {
+		return nil, errors.AssertionFailedf(`envelope is not an object: %s`, value)
+	}
+	oldKeyJSON, err := json.ParseJSON(string(oldKey))
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing old key`)
+	}
+	newKeyJSON, err := json.ParseJSON(string(newKey))
+	if err != nil {
+		return nil, errors.Wrap(err, `parsing new key`)
+	}
+	rekey := json.NewObjectBuilder(2)
+	rekey.Add(`new_key`, newKeyJSON)
+	rekey.Add(`old_key`, oldKeyJSON)
+
+	b := json.NewObjectBuilder(envelope.Len() + 1)
+	for it.Next() {
+		b.Add(it.Key(), it.Value())
+	}
+	b.Add(rekeyField, rekey.Build())
+	return []byte(b.Build().String()), nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// replayBufferEntry is a message emitted by a sinkless changefeed, as
+// returned to the client.
+type replayBufferEntry struct {
+	// resolved is set if the message is a resolved timestamp.
+	resolved hlc.Timestamp
+	row      tree.Datums
+	size     int64
+}
+
+// replayBuffer retains the messages recently emitted by a sinkless
+// changefeed, in the order they were emitted, so that a client which
+// reconnects with a cursor within the buffer's window can be sent the
+// messages it may have missed without restarting the changefeed from the
+// cursor.
+//
+// The buffer always begins with a resolved timestamp. Messages are evicted
+// from the front a resolved timestamp at a time, once the following resolved
+// timestamp is older than the window, or the buffer exceeds its maximum size.
+// The retained messages are charged to acc, and the buffer is emptied if they
+// can't be.
+type replayBuffer struct {
+	key      replayBufferKey
+	window   time.Duration
+	maxBytes int64
+	acc      *mon.BoundAccount
+
+	mu struct {
+		syncutil.Mutex
+		entries []replayBufferEntry
+		// resolved holds the positions of the resolved timestamps among
+		// entries, counted from the first entry added since the buffer was
+		// last reset, of which dropped have been evicted since.
+		resolved []int
+		dropped  int
+		bytes    int64
+	}
+}
+
+// addRow adds a row emitted by the changefeed. Rows emitted before the
+// changefeed's first resolved timestamp are not retained, as they cannot be
+// replayed from any cursor.
+func (b *replayBuffer) addRow(ctx context.Context, row tree.Datums) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.mu.entries) == 0 {
+		return
+	}
+	b.addLocked(ctx, replayBufferEntry{row: row})
+}
+
+// addResolved adds a resolved timestamp emitted by the changefeed.
+func (b *replayBuffer) addResolved(ctx context.Context, resolved hlc.Timestamp, row tree.Datums) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLocked(ctx, replayBufferEntry{resolved: resolved, row: row})
+}
+
+func (b *replayBuffer) addLocked(ctx context.Context, e replayBufferEntry) {
+	for _, d := range e.row {
+		e.size += int64(d.Size())
+	}
+	if err := b.acc.Grow(ctx, e.size); err != nil {
+		// Without the memory to retain the message, the buffer can't replay
+		// it, and starts over at the next resolved timestamp.
+		b.resetLocked(ctx)
+		return
+	}
+	if !e.resolved.IsEmpty() {
+		b.mu.resolved = append(b.mu.resolved, b.mu.dropped+len(b.mu.entries))
+	}
+	b.mu.entries = append(b.mu.entries, e)
+	b.mu.bytes += e.size
+	// Only resolved timestamps move the window.
+	if e.resolved.IsEmpty() && b.mu.bytes <= b.maxBytes {
+		return
+	}
+
+	oldest := e.resolved.Add(-b.window.Nanoseconds(), 0)
+	for len(b.mu.resolved) > 1 {
+		next := b.mu.resolved[1] - b.mu.dropped
+		if b.mu.bytes <= b.maxBytes && (e.resolved.IsEmpty() || oldest.Less(b.mu.entries[next].resolved)) {
+			break
+		}
+		b.dropLocked(ctx, next)
+	}
+	if b.mu.bytes > b.maxBytes {
+		// A single resolved timestamp's worth of rows doesn't fit.
+		b.resetLocked(ctx)
+	}
+}
+
+// dropLocked evicts the first n entries of the buffer, which are followed by
+// a resolved timestamp. The evicted entries are cleared rather than copied
+// out, so that their rows can be collected; the space of the entries is
+// reclaimed once append reallocates them.
+func (b *replayBuffer) dropLocked(ctx context.Context, n int) {
+	var dropped int64
+	for i := range b.mu.entries[:n] {
+		dropped += b.mu.entries[i].size
+		b.mu.entries[i] = replayBufferEntry{}
+	}
+	b.mu.bytes -= dropped
+	b.acc.Shrink(ctx, dropped)
+	b.mu.entries = b.mu.entries[n:]
+	b.mu.dropped += n
+	for len(b.mu.resolved) > 0 && b.mu.resolved[0] < b.mu.dropped {
+		b.mu.resolved = b.mu.resolved[1:]
+	}
+}
+
+func (b *replayBuffer) resetLocked(ctx context.Context) {
+	b.acc.Shrink(ctx, b.mu.bytes)
+	b.mu.entries = nil
+	b.mu.resolved = nil
+	b.mu.dropped = 0
+	b.mu.bytes = 0
+}
+
+// reset empties the buffer, for a changefeed which is not resuming from it.
+func (b *replayBuffer) reset(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetLocked(ctx)
+}
+
+// resume returns the messages a client which has seen everything up to
+// cursor needs to be sent again, along with the resolved timestamp the
+// changefeed should then be restarted from, which is that of the last
+// returned message. It returns false if cursor is outside of the window of
+// the buffer, in which case the buffer is emptied and the changefeed must be
+// restarted from cursor.
+//
+// The rows emitted after the last resolved timestamp in the buffer are
+// dropped, as the restarted changefeed emits them again.
+func (b *replayBuffer) resume(
+	ctx context.Context, cursor hlc.Timestamp,
+) ([]tree.Datums, hlc.Timestamp, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	from, last := -1, -1
+	for _, pos := range b.mu.resolved {
+		i := pos - b.mu.dropped
+		if b.mu.entries[i].resolved.LessEq(cursor) {
+			from = i
+		}
+		last = i
+	}
+	if from < 0 || b.mu.entries[last].resolved.Less(cursor) {
+		b.resetLocked(ctx)
+		return nil, hlc.Timestamp{}, false
+	}
+
+	var dropped int64
+	for i := last + 1; i < len(b.mu.entries); i++ {
+		dropped += b.mu.entries[i].size
+		b.mu.entries[i] = replayBufferEntry{}
+	}
+	b.mu.bytes -= dropped
+	b.acc.Shrink(ctx, dropped)
+	b.mu.entries = b.mu.entries[:last+1]
+	rows := make([]tree.Datums, 0, last-from)
+	for _, e := range b.mu.entries[from+1:] {
+		rows = append(rows, e.row)
+	}
+	return rows, b.mu.entries[last].resolved, true
+}
+
+// replayBufferKey identifies a replay buffer. Buffers are named by their
+// changefeeds' replay_buffer option, and only shared by the changefeeds of the
+// same user over the same targets, so that a changefeed can't be sent the
+// rows of tables its user can't read by reusing the name of another's buffer.
+type replayBufferKey struct {
+	user    security.SQLUsername
+	targets string
+	name    string
+}
+
+// makeReplayBufferKey returns the key of the replay buffer with the given
+// name of a changefeed of the user over the targets.
+func makeReplayBufferKey(
+	user security.SQLUsername, targets jobspb.ChangefeedTargets, name string,
+) replayBufferKey {
+	ids := make([]string, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	sort.Strings(ids)
+	return replayBufferKey{user: user, targets: strings.Join(ids, `,`), name: name}
+}
+
+// replayBufferRegistry holds the replay buffers of the sinkless changefeeds
+// run on this node, by key. A buffer outlives the changefeed which filled it
+// for the duration of its window, so that a client can reconnect to it.
+type replayBufferRegistry struct {
+	mu struct {
+		syncutil.Mutex
+		buffers map[replayBufferKey]*registeredReplayBuffer
+	}
+}
+
+type registeredReplayBuffer struct {
+	*replayBuffer
+	inUse      bool
+	releasedAt time.Time
+}
+
+var sinklessReplayBuffers = func() *replayBufferRegistry {
+	r := &replayBufferRegistry{}
+	r.mu.buffers = make(map[replayBufferKey]*registeredReplayBuffer)
+	return r
+}()
+
+// acquire returns the replay buffer with the given key, creating it if it
+// doesn't exist or has expired, with an account of memMon charged for its
+// messages. A buffer can only be used by one changefeed at a time, and there
+// can be at most maxBuffers buffers.
+func (r *replayBufferRegistry) acquire(
+	ctx context.Context,
+	key replayBufferKey,
+	now time.Time,
+	window time.Duration,
+	maxBytes int64,
+	maxBuffers int64,
+	memMon *mon.BytesMonitor,
+) (*replayBuffer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, b := range r.mu.buffers {
+		if !b.inUse && now.Sub(b.releasedAt) > b.window {
+			b.reset(ctx)
+			b.acc.Close(ctx)
+			delete(r.mu.buffers, k)
+		}
+	}
+
+	b, ok := r.mu.buffers[key]
+	if !ok {
+		if int64(len(r.mu.buffers)) >= maxBuffers {
+			return nil, errors.Errorf(`too many replay buffers: there are already %d`, len(r.mu.buffers))
+		}
+		var acc *mon.BoundAccount
+		if memMon != nil {
+			a := memMon.MakeBoundAccount()
+			acc = &a
+		}
+		b = &registeredReplayBuffer{replayBuffer: &replayBuffer{key: key, acc: acc}}
+		r.mu.buffers[key] = b
+	}
+	if b.inUse {
+		return nil, errors.Errorf(`replay buffer %q is in use by another changefeed`, key.name)
+	}
+	b.inUse = true
+	b.window, b.maxBytes = window, maxBytes
+	return b.replayBuffer, nil
+}
+
+// release marks the buffer as no longer used by a changefeed.
+func (r *replayBufferRegistry) release(buf *replayBuffer, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.mu.buffers[buf.key]; ok && b.replayBuffer == buf {
+		b.inUse = false
+		b.releasedAt = now
+	}
+}
+
+// get returns the replay buffer with the given key if it is in use, or nil.
+func (r *replayBufferRegistry) get(key replayBufferKey) *replayBuffer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.mu.buffers[key]; ok && b.inUse {
+		return b.replayBuffer
+	}
+	return nil
+}
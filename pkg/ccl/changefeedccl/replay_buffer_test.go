@@ -0,0 +1,180 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+	row := func(s string) tree.Datums {
+		return tree.Datums{tree.NewDString(`foo`), tree.NewDBytes(`[1]`), tree.NewDBytes(tree.DBytes(s))}
+	}
+	resolved := func(i int64) tree.Datums {
+		return tree.Datums{tree.DNull, tree.DNull, tree.NewDBytes(tree.DBytes(fmt.Sprintf(`resolved %d`, i)))}
+	}
+	values := func(rows []tree.Datums) []string {
+		var res []string
+		for _, r := range rows {
+			res = append(res, string(*r[2].(*tree.DBytes)))
+		}
+		return res
+	}
+	fill := func(b *replayBuffer) {
+		b.addRow(ctx, row(`dropped`))
+		for i := int64(1); i <= 4; i++ {
+			b.addRow(ctx, row(fmt.Sprintf(`row %d`, i)))
+			b.addResolved(ctx, ts(i), resolved(i))
+		}
+		b.addRow(ctx, row(`unresolved`))
+	}
+
+	b := &replayBuffer{window: 10, maxBytes: 1 << 20}
+	fill(b)
+	rows, resumeFrom, ok := b.resume(ctx, ts(2))
+	require.True(t, ok)
+	require.Equal(t, ts(4), resumeFrom)
+	require.Equal(t, []string{`row 3`, `resolved 3`, `row 4`, `resolved 4`}, values(rows))
+	// Cursors between resolved timestamps replay from the previous one.
+	rows, _, ok = b.resume(ctx, ts(2).Next())
+	require.True(t, ok)
+	require.Equal(t, []string{`row 3`, `resolved 3`, `row 4`, `resolved 4`}, values(rows))
+	// Nothing is replayed from the last resolved timestamp, and the unresolved
+	// row was dropped by the first resume.
+	rows, resumeFrom, ok = b.resume(ctx, ts(4))
+	require.True(t, ok)
+	require.Equal(t, ts(4), resumeFrom)
+	require.Empty(t, rows)
+	// Cursors before the first resolved timestamp aren't covered, and empty
+	// the buffer.
+	_, _, ok = b.resume(ctx, ts(0))
+	require.False(t, ok)
+	_, _, ok = b.resume(ctx, ts(4))
+	require.False(t, ok)
+
+	// Resolved timestamps older than the window are evicted.
+	b = &replayBuffer{window: 2, maxBytes: 1 << 20}
+	fill(b)
+	_, _, ok = b.resume(ctx, ts(1))
+	require.False(t, ok)
+	fill(b)
+	rows, _, ok = b.resume(ctx, ts(2))
+	require.True(t, ok)
+	require.Equal(t, []string{`row 3`, `resolved 3`, `row 4`, `resolved 4`}, values(rows))
+
+	// So are resolved timestamps which don't fit in the buffer.
+	b = &replayBuffer{window: 10, maxBytes: 1 << 20}
+	fill(b)
+	b.mu.Lock()
+	b.maxBytes = b.mu.bytes - 1
+	b.mu.Unlock()
+	b.addResolved(ctx, ts(5), resolved(5))
+	_, _, ok = b.resume(ctx, ts(1))
+	require.False(t, ok)
+	fill(b)
+	b.addResolved(ctx, ts(5), resolved(5))
+	rows, _, ok = b.resume(ctx, ts(3))
+	require.True(t, ok)
+	require.Equal(t, []string{`row 4`, `resolved 4`, `unresolved`, `resolved 5`}, values(rows))
+
+	// The window keeps sliding over many evictions.
+	b = &replayBuffer{window: 2, maxBytes: 1 << 20}
+	for i := int64(1); i <= 100; i++ {
+		b.addRow(ctx, row(fmt.Sprintf(`row %d`, i)))
+		b.addResolved(ctx, ts(i), resolved(i))
+	}
+	b.mu.Lock()
+	require.Len(t, b.mu.entries, 5)
+	b.mu.Unlock()
+	rows, resumeFrom, ok = b.resume(ctx, ts(98))
+	require.True(t, ok)
+	require.Equal(t, ts(100), resumeFrom)
+	require.Equal(t, []string{`row 99`, `resolved 99`, `row 100`, `resolved 100`}, values(rows))
+}
+
+func TestReplayBufferRegistry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	mm := mon.NewMonitorWithLimit(
+		"test-mm", mon.MemoryResource, 1<<20,
+		nil, nil,
+		128 /* small allocation increment */, 100,
+		cluster.MakeTestingClusterSettings())
+	mm.Start(ctx, nil, mon.MakeStandaloneBudget(1<<20))
+	defer mm.Stop(ctx)
+
+	r := &replayBufferRegistry{}
+	r.mu.buffers = make(map[replayBufferKey]*registeredReplayBuffer)
+	now := time.Unix(0, 0)
+	user := security.MakeSQLUsernameFromPreNormalizedString(`foo`)
+	targets := jobspb.ChangefeedTargets{52: {StatementTimeName: `foo`}}
+	a := makeReplayBufferKey(user, targets, `a`)
+	acquire := func(key replayBufferKey, now time.Time) (*replayBuffer, error) {
+		return r.acquire(ctx, key, now, time.Minute, 1<<20, 3, mm)
+	}
+
+	require.Nil(t, r.get(a))
+	b, err := acquire(a, now)
+	require.NoError(t, err)
+	require.Equal(t, b, r.get(a))
+	_, err = acquire(a, now)
+	require.EqualError(t, err, `replay buffer "a" is in use by another changefeed`)
+
+	// The buffer's messages are charged to the monitor.
+	b.addResolved(ctx, hlc.Timestamp{WallTime: 1}, tree.Datums{tree.NewDBytes(`resolved`)})
+	require.NotZero(t, b.acc.Used())
+	require.GreaterOrEqual(t, mm.AllocBytes(), b.acc.Used())
+
+	// Buffers of the same name are separate for other users and targets.
+	otherUser := makeReplayBufferKey(security.MakeSQLUsernameFromPreNormalizedString(`bar`), targets, `a`)
+	otherTargets := makeReplayBufferKey(user, jobspb.ChangefeedTargets{53: {StatementTimeName: `bar`}}, `a`)
+	for _, key := range []replayBufferKey{otherUser, otherTargets} {
+		other, err := acquire(key, now)
+		require.NoError(t, err)
+		require.NotSame(t, b, other)
+		r.release(other, now)
+	}
+	// There can be at most 3 buffers.
+	_, err = acquire(makeReplayBufferKey(user, targets, `b`), now)
+	require.EqualError(t, err, `too many replay buffers: there are already 3`)
+
+	r.release(b, now)
+	require.Nil(t, r.get(a))
+	again, err := acquire(a, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, b, again)
+
+	// Buffers are dropped once released for longer than their window, and
+	// their memory is released.
+	r.release(b, now)
+	expired, err := acquire(a, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.NotSame(t, b, expired)
+	require.Zero(t, mm.AllocBytes())
+	r.release(expired, now)
+	expired.reset(ctx)
+	expired.acc.Close(ctx)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// rowHashField is the field of the wrapped envelope which holds the hash of a
+// row for the row_hash option.
+const rowHashField = `row_hash`
+
+// rowHashSuffixLen is the length of the suffix appendRowHash adds to a value,
+// which consumers strip to recover the hashed value.
+var rowHashSuffixLen = len(`, "`+rowHashField+`": ""`) + hex.EncodedLen(sha256.Size)
+
+// appendRowHash adds the row_hash field to the end of value, the encoded
+// wrapped envelope of a row whose encoded key is key, and returns the result.
+// The field holds the hex encoded SHA-256 of key followed by value. It's
+// computed over the bytes emitted to the sink, so the hash of a version of a
+// row is the same whenever the changefeed emits it, and consumers verify it by
+// removing the field, which is always the last one of the envelope, and
+// hashing the key and the remaining value. Values which aren't JSON objects,
+// such as those of deletes with delete_format=tombstone or null, are returned
+// unchanged.
+func appendRowHash(key, value []byte) []byte {
+	if len(value) < 2 || value[len(value)-1] != '}' {
+		return value
+	}
+	h := sha256.New()
+	h.Write(key)
+	h.Write(value)
+	sum := h.Sum(nil)
+
+	var buf bytes.Buffer
+	buf.Grow(len(value) + rowHashSuffixLen)
+	buf.Write(value[:len(value)-1])
+	buf.WriteString(`, "` + rowHashField + `": "`)
+	buf.WriteString(hex.EncodeToString(sum))
+	buf.WriteString(`"}`)
+	return buf.Bytes()
+}
+
+// verifyRowHash returns whether value, which was emitted with the row_hash
+// option for a row whose encoded key is key, holds the hash of the row. It's
+// the reference implementation of the verification done by consumers.
+func verifyRowHash(key, value []byte) bool {
+	if len(value) < rowHashSuffixLen+1 {
+		return false
+	}
+	hashed := make([]byte, 0, len(value)-rowHashSuffixLen)
+	hashed = append(hashed, value[:len(value)-rowHashSuffixLen-1]...)
+	hashed = append(hashed, '}')
+	return bytes.Equal(appendRowHash(key, hashed), value)
+}
@@ -15,14 +15,18 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -51,11 +55,13 @@ type confluentSchemaVersionResponse struct {
 
 type confluentSchemaRegistry struct {
 	baseURL *url.URL
-	// The current defaults for httputil.Client sets
-	// DisableKeepAlive's true so we don't have persistent
-	// connections to clean up on teardown.
+	// client keeps its connections to the registry open between requests,
+	// see sinkHTTPTransport.
 	client    *httputil.Client
 	retryOpts retry.Options
+	// metrics, if set, records the latency of the requests to the registry
+	// and their retries.
+	metrics *Metrics
 }
 
 var _ schemaRegistry = (*confluentSchemaRegistry)(nil)
@@ -78,18 +84,36 @@ func newConfluentSchemaRegistry(baseURL string) (*confluentSchemaRegistry, error
 			return nil, errors.Wrapf(err, "param %s must be base 64 encoded", changefeedbase.RegistryParamCACert)
 		}
 	}
-	// remove query param to ensure compatibility with schema
+	retryOpts := base.DefaultRetryOptions()
+	retryOpts.MaxRetries = 2
+	if retryMax := query.Get(changefeedbase.RegistryParamRetryMax); retryMax != "" {
+		n, err := strconv.Atoi(retryMax)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("param %s must be a non-negative integer: %q",
+				changefeedbase.RegistryParamRetryMax, retryMax)
+		}
+		retryOpts.MaxRetries = n
+	}
+	dnsCacheTTL := defaultDNSCacheTTL
+	if v := query.Get(changefeedbase.RegistryParamDNSCacheTTL); v != "" {
+		var err error
+		if dnsCacheTTL, err = time.ParseDuration(v); err != nil || dnsCacheTTL < 0 {
+			return nil, errors.Errorf("param %s must be a non-negative duration: %q",
+				changefeedbase.RegistryParamDNSCacheTTL, v)
+		}
+	}
+	// remove query params to ensure compatibility with schema
 	// registry implementation
 	query.Del(changefeedbase.RegistryParamCACert)
+	query.Del(changefeedbase.RegistryParamRetryMax)
+	query.Del(changefeedbase.RegistryParamDNSCacheTTL)
 	u.RawQuery = query.Encode()
 
-	httpClient, err := setupHTTPClient(u, caCert)
+	httpClient, err := setupHTTPClient(u, caCert, dnsCacheTTL)
 	if err != nil {
 		return nil, err
 	}
 
-	retryOpts := base.DefaultRetryOptions()
-	retryOpts.MaxRetries = 2
 	return &confluentSchemaRegistry{
 		baseURL:   u,
 		client:    httpClient,
@@ -99,19 +123,25 @@ func newConfluentSchemaRegistry(baseURL string) (*confluentSchemaRegistry, error
 
 // Setup the httputil.Client to use when dialing Confluent schema registry. If `ca_cert`
 // is set as a query param in the registry URL, client should trust the corresponding
-// cert while dialing. Otherwise, use the DefaultClient.
-func setupHTTPClient(baseURL *url.URL, caCert []byte) (*httputil.Client, error) {
+// cert while dialing. The addresses of the registry are cached for dnsCacheTTL.
+func setupHTTPClient(
+	baseURL *url.URL, caCert []byte, dnsCacheTTL time.Duration,
+) (*httputil.Client, error) {
+	transport := newSinkHTTPTransport(httputil.StandardHTTPTimeout, dnsCacheTTL)
 	if caCert != nil {
-		httpClient, err := newClientFromTLSKeyPair(caCert)
+		tlsConfig, err := newTLSConfigFromCACert(caCert)
 		if err != nil {
 			return nil, err
 		}
+		transport.TLSClientConfig = tlsConfig
 		if baseURL.Scheme == "http" {
 			log.Warningf(context.Background(), "CA certificate provided but schema registry %s uses HTTP", baseURL)
 		}
-		return httpClient, nil
 	}
-	return httputil.DefaultClient, nil
+	return &httputil.Client{Client: &http.Client{
+		Timeout:   httputil.StandardHTTPTimeout,
+		Transport: transport,
+	}}, nil
 }
 
 // Ping checks connectivity to the schema registry using the /mode
@@ -125,14 +155,15 @@ func (r *confluentSchemaRegistry) Ping(ctx context.Context) error {
 	return r.doWithRetry(ctx, func() error {
 		resp, err := r.client.Get(ctx, u)
 		if err != nil {
-			return err
+			return markTransientRegistryError(err)
 		}
 		defer gracefulClose(ctx, resp.Body)
 		// We allow other non-Success statuses because we
 		// don't care about the response here, only that the
 		// service is up.
 		if resp.StatusCode >= 500 {
-			return errors.Errorf("unexpected schema registry response: %s", resp.Status)
+			return markTransientRegistryError(
+				errors.Errorf("unexpected schema registry response: %s", resp.Status))
 		}
 		return nil
 	})
@@ -159,14 +190,25 @@ func (r *confluentSchemaRegistry) RegisterSchemaForSubject(
 
 	var id int32
 	err := r.doWithRetry(ctx, func() error {
-		resp, err := r.client.Post(ctx, u, confluentSchemaContentType, &buf)
+		// Each attempt sends the request body from the start.
+		body := bytes.NewReader(buf.Bytes())
+		resp, err := r.client.Post(ctx, u, confluentSchemaContentType, body)
 		if err != nil {
-			return errors.Wrap(err, "contacting confluent schema registry")
+			return markTransientRegistryError(errors.Wrap(err, "contacting confluent schema registry"))
 		}
 		defer gracefulClose(ctx, resp.Body)
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			body, _ := ioutil.ReadAll(resp.Body)
-			return errors.Errorf("registering schema to %s %s: %s", u, resp.Status, body)
+			err := errors.Errorf("registering schema to %s %s: %s", u, resp.Status, body)
+			switch resp.StatusCode {
+			case http.StatusConflict, http.StatusUnprocessableEntity:
+				// The schema is incompatible with the subject or invalid.
+				err = errors.Mark(err, errAvroSchemaRejected)
+			}
+			if isTransientRegistryStatus(resp.StatusCode) {
+				err = markTransientRegistryError(err)
+			}
+			return err
 		}
 		var res confluentSchemaVersionResponse
 		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
@@ -193,30 +235,93 @@ func (r *confluentSchemaRegistry) doWithRetry(ctx context.Context, fn func() err
 	// should revisit this more broadly as this pattern can easily mask real,
 	// actionable issues in the operator's environment that which they might be
 	// able to resolve if we made them visible in a failure instead.
+	//
+	// Only transient failures are retried: other errors, such as the rejection
+	// of a schema which is incompatible with its subject, would only be
+	// returned again, so they are returned as they are.
 	var err error
 	for retrier := retry.StartWithCtx(ctx, r.retryOpts); retrier.Next(); {
+		if err != nil && r.metrics != nil {
+			// The previous attempt failed transiently.
+			r.metrics.SchemaRegistryRetries.Inc(1)
+		}
+		start := timeutil.Now()
 		err = fn()
+		if r.metrics != nil {
+			r.metrics.SchemaRegistryHistNanos.RecordValue(timeutil.Since(start).Nanoseconds())
+		}
 		if err == nil {
 			return nil
 		}
+		if !errors.Is(err, errTransientRegistryFailure) {
+			return err
+		}
 		log.VInfof(ctx, 2, "retrying schema registry operation: %s", err.Error())
 	}
 	return changefeedbase.MarkRetryableError(err)
 }
 
+// errTransientRegistryFailure marks the errors of requests to the schema
+// registry which may succeed if retried.
+var errTransientRegistryFailure = errors.New(`transient schema registry failure`)
+
+func markTransientRegistryError(err error) error {
+	return errors.Mark(err, errTransientRegistryFailure)
+}
+
+// isTransientRegistryStatus returns whether a request to the schema registry
+// which failed with the given HTTP status may succeed if retried: the server
+// errors, and the requests which timed out or were throttled.
+func isTransientRegistryStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	default:
+		return status >= 500
+	}
+}
+
+// setSchemaRegistryMetrics sets the metrics recording the requests of the
+// schema registry of e, if it has one.
+func setSchemaRegistryMetrics(e Encoder, metrics *Metrics) {
+	switch e := e.(type) {
+	case *confluentAvroEncoder:
+		if reg, ok := e.schemaRegistry.(*confluentSchemaRegistry); ok {
+			reg.metrics = metrics
+		}
+	case *perTargetEncoder:
+		setSchemaRegistryMetrics(e.Encoder, metrics)
+		for _, targetEncoder := range e.targets {
+			setSchemaRegistryMetrics(targetEncoder, metrics)
+		}
+	}
+}
+
+// closeSchemaRegistries closes the idle connections to the schema registry
+// of e, if it has one. e may still be used afterwards, reopening them.
+func closeSchemaRegistries(e Encoder) {
+	switch e := e.(type) {
+	case *confluentAvroEncoder:
+		if reg, ok := e.schemaRegistry.(*confluentSchemaRegistry); ok {
+			reg.client.CloseIdleConnections()
+		}
+	case *perTargetEncoder:
+		closeSchemaRegistries(e.Encoder)
+		for _, targetEncoder := range e.targets {
+			closeSchemaRegistries(targetEncoder)
+		}
+	}
+}
+
 func gracefulClose(ctx context.Context, toClose io.ReadCloser) {
 	// NOTE(ssd): To reuse the connection we have to be sure to
 	// read to EOF and close the response body.
 	//
-	// Right now this is wasted worked, since currently, httputil
-	// sets options that will mean we never actually re-use
-	// connections.
-	//
 	// We read upto 4k to try to reach io.EOF.
 	const respExtraReadLimit = 4096
 	_, _ = io.CopyN(ioutil.Discard, toClose, respExtraReadLimit)
 	if err := toClose.Close(); err != nil {
-		log.VInfof(ctx, 2, "failure to close schema registry connection", err)
+		log.VInfof(ctx, 2, "failure to close HTTP response body: %v", err)
 	}
 }
 
@@ -10,11 +10,16 @@ package changefeedccl
 
 import (
 	"context"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -55,4 +60,75 @@ func TestConfluentSchemaRegistryPing(t *testing.T) {
 		require.NoError(t, err)
 		require.Error(t, reg.Ping(context.Background()))
 	})
+	t.Run("idle connections are closed", func(t *testing.T) {
+		reg, err := newConfluentSchemaRegistry(regServer.URL())
+		require.NoError(t, err)
+		connsOpened := sinkHTTPStats.connsOpened.Count()
+		require.NoError(t, reg.Ping(context.Background()))
+		require.NoError(t, reg.Ping(context.Background()))
+		require.Equal(t, int64(1), sinkHTTPStats.connsOpened.Count()-connsOpened)
+
+		closeSchemaRegistries(&confluentAvroEncoder{schemaRegistry: reg})
+		require.NoError(t, reg.Ping(context.Background()))
+		require.Equal(t, int64(2), sinkHTTPStats.connsOpened.Count()-connsOpened)
+		closeSchemaRegistries(&confluentAvroEncoder{schemaRegistry: reg})
+	})
+}
+
+func TestConfluentSchemaRegistryRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	regServer := cdctest.StartTestSchemaRegistry()
+	defer regServer.Close()
+
+	metrics := MakeMetrics(base.DefaultHistogramWindowInterval()).(*Metrics)
+	makeRegistry := func(t *testing.T, params string) *confluentSchemaRegistry {
+		reg, err := newConfluentSchemaRegistry(regServer.URL() + params)
+		require.NoError(t, err)
+		reg.retryOpts.InitialBackoff = time.Millisecond
+		reg.metrics = metrics
+		return reg
+	}
+	const schema = `{"type": "record", "name": "foo", "fields": []}`
+
+	t.Run("transient failures are retried", func(t *testing.T) {
+		reg := makeRegistry(t, "?retry_max=3")
+		retries := metrics.SchemaRegistryRetries.Count()
+		regServer.InjectFailures(http.StatusServiceUnavailable, http.StatusRequestTimeout, http.StatusBadGateway)
+		_, err := reg.RegisterSchemaForSubject(ctx, "foo-value", schema)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), metrics.SchemaRegistryRetries.Count()-retries)
+		require.Equal(t, "foo-value", regServer.Subjects()[0])
+		require.NotZero(t, metrics.SchemaRegistryHistNanos.TotalCount())
+	})
+	t.Run("retries are capped", func(t *testing.T) {
+		reg := makeRegistry(t, "?retry_max=1")
+		regServer.InjectFailures(http.StatusInternalServerError, http.StatusInternalServerError)
+		_, err := reg.RegisterSchemaForSubject(ctx, "foo-value", schema)
+		require.Regexp(t, "500 Internal Server Error", err)
+		require.True(t, changefeedbase.IsRetryableError(err))
+
+		regServer.InjectFailures(http.StatusServiceUnavailable)
+		require.Error(t, makeRegistry(t, "?retry_max=0").Ping(ctx))
+	})
+	t.Run("permanent failures are not retried", func(t *testing.T) {
+		reg := makeRegistry(t, "")
+		retries := metrics.SchemaRegistryRetries.Count()
+		regServer.InjectFailures(http.StatusConflict)
+		_, err := reg.RegisterSchemaForSubject(ctx, "foo-value", schema)
+		require.Regexp(t, "409 Conflict", err)
+		require.True(t, errors.Is(err, errAvroSchemaRejected))
+		require.False(t, changefeedbase.IsRetryableError(err))
+		require.Equal(t, int64(0), metrics.SchemaRegistryRetries.Count()-retries)
+	})
+	t.Run("invalid retry_max", func(t *testing.T) {
+		_, err := newConfluentSchemaRegistry(regServer.URL() + "?retry_max=-1")
+		require.Regexp(t, "param retry_max must be a non-negative integer", err)
+	})
+	t.Run("invalid dns_cache_ttl", func(t *testing.T) {
+		_, err := newConfluentSchemaRegistry(regServer.URL() + "?dns_cache_ttl=soon")
+		require.Regexp(t, "param dns_cache_ttl must be a non-negative duration", err)
+	})
 }
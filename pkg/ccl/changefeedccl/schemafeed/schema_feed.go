@@ -79,6 +79,7 @@ func New(
 	cfg *execinfra.ServerConfig,
 	events changefeedbase.SchemaChangeEventClass,
 	targets jobspb.ChangefeedTargets,
+	opts map[string]string,
 	initialHighwater hlc.Timestamp,
 	metrics *Metrics,
 ) SchemaFeed {
@@ -88,11 +89,16 @@ func New(
 		clock:             cfg.DB.Clock(),
 		settings:          cfg.Settings,
 		targets:           targets,
+		opts:              opts,
 		leaseMgr:          cfg.LeaseManager.(*lease.Manager),
 		ie:                cfg.SessionBoundInternalExecutorFactory(ctx, &sessiondata.SessionData{}),
 		collectionFactory: cfg.CollectionFactory,
 		metrics:           metrics,
 	}
+	switch changefeedbase.OnTargetDropPolicy(opts[changefeedbase.OptOnTargetDrop]) {
+	case changefeedbase.OptOnTargetDropSkip, changefeedbase.OptOnTargetDropComplete:
+		m.skipDroppedTargets = true
+	}
 	m.mu.previousTableVersion = make(map[descpb.ID]catalog.TableDescriptor)
 	m.mu.highWater = initialHighwater
 	m.mu.typeDeps = typeDependencyTracker{deps: make(map[descpb.ID][]descpb.ID)}
@@ -115,9 +121,15 @@ type schemaFeed struct {
 	clock    *hlc.Clock
 	settings *cluster.Settings
 	targets  jobspb.ChangefeedTargets
+	opts     map[string]string
 	ie       sqlutil.InternalExecutor
 	metrics  *Metrics
 
+	// skipDroppedTargets is set for the on_target_drop policies which continue
+	// the changefeed without a dropped target, whose drop is then an event,
+	// rather than an error.
+	skipDroppedTargets bool
+
 	// TODO(ajwerner): Should this live underneath the FilterFunc?
 	// Should there be another function to decide whether to update the
 	// lease manager?
@@ -525,8 +537,11 @@ func (tf *schemaFeed) validateDescriptor(
 		// manager to acquire the freshest version of the type.
 		return tf.leaseMgr.AcquireFreshestFromStore(ctx, desc.GetID())
 	case catalog.TableDescriptor:
-		if err := changefeedbase.ValidateTable(tf.targets, desc); err != nil {
-			return err
+		targetDropped := tf.skipDroppedTargets && desc.Dropped()
+		if !targetDropped {
+			if err := changefeedbase.ValidateTable(tf.targets, desc, tf.opts); err != nil {
+				return err
+			}
 		}
 		log.VEventf(ctx, 1, "validate %v", formatDesc(desc))
 		if lastVersion, ok := tf.mu.previousTableVersion[desc.GetID()]; ok {
@@ -541,9 +556,11 @@ func (tf *schemaFeed) validateDescriptor(
 			// descriptor it knows about for the timestamp, assuming it's still
 			// allowed; without this explicit load, the lease manager might therefore
 			// return the previous version of the table, which is still technically
-			// allowed by the schema change system.
-			if err := tf.leaseMgr.AcquireFreshestFromStore(ctx, desc.GetID()); err != nil {
-				return err
+			// allowed by the schema change system. Dropped tables can't be leased.
+			if !targetDropped {
+				if err := tf.leaseMgr.AcquireFreshestFromStore(ctx, desc.GetID()); err != nil {
+					return err
+				}
 			}
 
 			// Purge the old version of the table from the type mapping.
@@ -555,11 +572,16 @@ func (tf *schemaFeed) validateDescriptor(
 				Before: lastVersion,
 				After:  desc,
 			}
-			shouldFilter, err := tf.filter.shouldFilter(ctx, e)
-			log.VEventf(ctx, 1, "validate shouldFilter %v %v", formatEvent(e), shouldFilter)
-			if err != nil {
-				return err
+			// The drop of a target is never filtered, since the changefeed restarts
+			// without it.
+			var shouldFilter bool
+			if !targetDropped {
+				var err error
+				if shouldFilter, err = tf.filter.shouldFilter(ctx, e); err != nil {
+					return err
+				}
 			}
+			log.VEventf(ctx, 1, "validate shouldFilter %v %v", formatEvent(e), shouldFilter)
 			if !shouldFilter {
 				// Only sort the tail of the events from earliestTsBeingIngested.
 				// The head could already have been handed out and sorting is not
@@ -646,6 +668,11 @@ func (tf *schemaFeed) fetchDescriptorVersions(
 				}
 
 				unsafeValue := it.UnsafeValue()
+				if unsafeValue == nil && isTable && tf.skipDroppedTargets {
+					// The descriptor of a dropped target is deleted once its data
+					// is garbage collected, after the version which dropped it.
+					continue
+				}
 				if unsafeValue == nil {
 					name := origName.StatementTimeName
 					if name == "" {
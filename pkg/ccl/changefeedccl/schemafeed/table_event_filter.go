@@ -189,6 +189,13 @@ func IsPrimaryIndexChange(e TableEvent) bool {
 	return et.Contains(tableEventPrimaryKeyChange)
 }
 
+// IsTargetDrop returns true if the event corresponds to the drop of a
+// target, which is only an event, rather than an error, for changefeeds with
+// an on_target_drop policy other than fail.
+func IsTargetDrop(e TableEvent) bool {
+	return e.After.Dropped()
+}
+
 // IsOnlyPrimaryIndexChange returns to true if the event corresponds
 // to a change in the primary index and _only_ a change in the primary
 // index.
@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc/keyside"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/errors"
+)
+
+// routedTopic is the topic of a row of a table with a hash-sharded primary
+// key, with the shard_aware_routing option. routingKey, rather than the
+// encoded key of the row, picks its partition.
+type routedTopic struct {
+	TopicDescriptor
+	routingKey []byte
+}
+
+// shardAwareRouter routes the rows of tables with hash-sharded primary keys
+// by their logical keys, for the shard_aware_routing option.
+//
+// The primary key of a hash-sharded table starts with a hidden shard column
+// computed from the other key columns. The value of the shard column, and so
+// the encoded key of a row, depends on the bucket count, so the rows of a
+// logical key are routed to a different partition once the primary key is
+// altered to use another bucket count, or is no longer sharded. The routing
+// key of a row is its primary key without the shard column, which doesn't.
+type shardAwareRouter struct {
+	alloc tree.DatumAlloc
+}
+
+// route returns the topic of the row, carrying its routing key if its table
+// has a hash-sharded primary key.
+func (r *shardAwareRouter) route(row encodeRow, topic TopicDescriptor) (TopicDescriptor, error) {
+	primaryIndex := row.tableDesc.GetPrimaryIndex()
+	if !primaryIndex.IsSharded() {
+		return topic, nil
+	}
+	routingKey, err := r.routingKey(row.tableDesc, primaryIndex, row)
+	if err != nil {
+		return nil, err
+	}
+	return routedTopic{TopicDescriptor: topic, routingKey: routingKey}, nil
+}
+
+// routingKey returns the key encoding of the primary key columns of the row
+// other than the shard column.
+func (r *shardAwareRouter) routingKey(
+	desc catalog.TableDescriptor, primaryIndex catalog.Index, row encodeRow,
+) ([]byte, error) {
+	colIdxByID := catalog.ColumnIDToOrdinalMap(desc.PublicColumns())
+	shardColumn := primaryIndex.GetShardColumnName()
+	var key []byte
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		colID := primaryIndex.GetKeyColumnID(i)
+		idx, ok := colIdxByID.Get(colID)
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		col := desc.PublicColumns()[idx]
+		if col.GetName() == shardColumn {
+			continue
+		}
+		datum := row.datums[idx]
+		if err := datum.EnsureDecoded(col.GetType(), &r.alloc); err != nil {
+			return nil, err
+		}
+		var err error
+		if key, err = keyside.Encode(key, datum.Datum, encoding.Ascending); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
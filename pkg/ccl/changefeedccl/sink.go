@@ -9,21 +9,28 @@
 package changefeedccl
 
 import (
+	"container/heap"
 	"context"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric/aggmetric"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/quotapool"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
@@ -73,6 +80,99 @@ type SinkWithTopics interface {
 	Topics() []string
 }
 
+// tableValidatingSink is implemented by sinks which check, when a changefeed
+// is created, that they can accept the rows of its target tables.
+type tableValidatingSink interface {
+	validateTables(ctx context.Context, tables []catalog.TableDescriptor) error
+}
+
+// SinkWithDurableAck extends the Sink interface for sinks which report when
+// the downstream system has durably accepted the messages emitted to them,
+// e.g. once Kafka brokers have replicated them to all in-sync replicas, or
+// once a file has been finalized in cloud storage. With the durable_resolved
+// option, changefeeds only checkpoint a high-water once the sinks have
+// reported that every message below it is durable, and sinks which don't
+// implement this interface are rejected.
+type SinkWithDurableAck interface {
+	Sink
+	// FlushDurable flushes the sink as Flush does, and returns once every
+	// message emitted before the call has been durably accepted downstream.
+	FlushDurable(ctx context.Context) error
+}
+
+// sequencedSink is implemented by sinks which number the messages they emit
+// to each partition, with the sequence_numbers option, so that consumers can
+// detect missing messages.
+//
+// Each of the processors of a changefeed emits to the partitions with its own
+// sink, so the sequence numbers are assigned by each sink independently, as a
+// sequencer: the change aggregators number the rows they emit with the ID of
+// their SQL instance, and the change frontier numbers the resolved timestamps
+// it emits as frontierSequencer. Consumers track the sequence numbers of each
+// sequencer in each partition separately. The last sequence numbers assigned
+// to flushed messages are checkpointed in the job progress, and sequencers
+// continue from them when the changefeed restarts, so after a restart a
+// consumer may see sequence numbers again, on the messages emitted again since
+// the checkpoint. A sequence number greater than the next expected one means
+// messages were missed. When the spans of the changefeed are assigned to other
+// SQL instances, e.g. after nodes are added or removed, the rows of a
+// partition may be numbered by new sequencers, which start at 1, and by
+// sequencers which stop emitting to it.
+type sequencedSink interface {
+	Sink
+	// startSequences makes the sink number the messages it emits as the given
+	// sequencer, continuing from the sequence numbers of the sequencer in last.
+	startSequences(sequencer string, last []jobspb.ChangefeedSequence)
+	// flushedSequences returns the last sequence number the sink assigned to
+	// each partition. It must only be called right after a successful Flush,
+	// when every message the sink emitted has been flushed.
+	flushedSequences() []jobspb.ChangefeedSequence
+}
+
+// mergeSequences returns the sequence numbers of the sequencers' partitions in
+// either of a and b, with those of b replacing those of a.
+func mergeSequences(a, b []jobspb.ChangefeedSequence) []jobspb.ChangefeedSequence {
+	type key struct {
+		sequencer, topic string
+		partition        int32
+	}
+	idx := make(map[key]int, len(a))
+	for i, seq := range a {
+		idx[key{seq.Sequencer, seq.Topic, seq.Partition}] = i
+	}
+	for _, seq := range b {
+		if i, ok := idx[key{seq.Sequencer, seq.Topic, seq.Partition}]; ok {
+			a[i].Sequence = seq.Sequence
+		} else {
+			a = append(a, seq)
+		}
+	}
+	return a
+}
+
+// frontierSequencer is the sequencer of the resolved timestamps emitted by the
+// change frontier with the sequence_numbers option. See sequencedSink.
+const frontierSequencer = `frontier`
+
+// flushDurable flushes the sink and, if it is a SinkWithDurableAck, waits for
+// the durable acknowledgement of its messages.
+func flushDurable(ctx context.Context, s Sink) error {
+	if d, ok := s.(SinkWithDurableAck); ok {
+		return d.FlushDurable(ctx)
+	}
+	return s.Flush(ctx)
+}
+
+// SinkWithHealthCheck extends the Sink interface to include a method that
+// verifies the sink is able to reach its downstream system without emitting
+// any messages.
+type SinkWithHealthCheck interface {
+	Sink
+	// CheckHealth returns an error if the downstream system cannot be reached.
+	// It may be called concurrently with other Sink methods.
+	CheckHealth(ctx context.Context) error
+}
+
 func getSink(
 	ctx context.Context,
 	serverCfg *execinfra.ServerConfig,
@@ -90,6 +190,13 @@ func getSink(
 		u.Scheme = scheme
 	}
 
+	// ORC files can only be assembled by the cloud storage and iceberg sinks.
+	if changefeedbase.FormatType(feedCfg.Opts[changefeedbase.OptFormat]) == changefeedbase.OptFormatORC &&
+		!isCloudStorageSink(u) && !isIcebergSink(u) {
+		return nil, errors.Errorf(`%s=%s is only supported by cloud storage and iceberg sinks`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+	}
+
 	// check that options are compatible with the given sink
 	validateOptionsAndMakeSink := func(sinkSpecificOpts map[string]struct{}, makeSink func() (Sink, error)) (Sink, error) {
 		err := validateSinkOptions(feedCfg.Opts, sinkSpecificOpts)
@@ -121,16 +228,68 @@ func getSink(
 			return validateOptionsAndMakeSink(changefeedbase.PubsubValidOptions, func() (Sink, error) {
 				return MakePubsubSink(ctx, u, feedCfg.Opts, feedCfg.Targets)
 			})
+		case isRedisSink(u):
+			return validateOptionsAndMakeSink(changefeedbase.RedisValidOptions, func() (Sink, error) {
+				return makeRedisSink(sinkURL{URL: u}, feedCfg.Targets, m)
+			})
+		case isGRPCSink(u):
+			return validateOptionsAndMakeSink(changefeedbase.GRPCValidOptions, func() (Sink, error) {
+				return makeGRPCSink(sinkURL{URL: u}, feedCfg.Opts, m)
+			})
+		case isUnixSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeUnixSink(sinkURL{URL: u}, serverCfg.Settings.ExternalIODir, m)
+			})
+		case isSyslogSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeSyslogSink(sinkURL{URL: u}, feedCfg.Opts, m)
+			})
+		case isPebbleSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makePebbleSink(sinkURL{URL: u}, serverCfg.Settings.ExternalIODir, m)
+			})
+		case isFileSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeFileSink(sinkURL{URL: u}, feedCfg.Opts, serverCfg.Settings.ExternalIODir, m)
+			})
+		case isCassandraSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeCassandraSink(sinkURL{URL: u}, feedCfg.Opts, m)
+			})
+		case isClickHouseSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeClickHouseSink(sinkURL{URL: u}, feedCfg.Opts, m)
+			})
+		case isInfluxDBSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeInfluxDBSink(sinkURL{URL: u}, feedCfg.Opts, m)
+			})
+		case isIcebergSink(u):
+			return validateOptionsAndMakeSink(nil /* sinkSpecificOpts */, func() (Sink, error) {
+				return makeIcebergSink(
+					ctx, sinkURL{URL: u}, feedCfg.Opts, serverCfg.ExternalStorageFromURI, user, m,
+				)
+			})
 		case isCloudStorageSink(u):
 			return validateOptionsAndMakeSink(changefeedbase.CloudStorageValidOptions, func() (Sink, error) {
-				return makeCloudStorageSink(
+				s, err := makeCloudStorageSink(
 					ctx, sinkURL{URL: u}, serverCfg.NodeID.SQLInstanceID(), serverCfg.Settings,
 					feedCfg.Opts, timestampOracle, serverCfg.ExternalStorageFromURI, user, m,
 				)
+				if err != nil {
+					return nil, err
+				}
+				// Avro messages carry the comments in their schemas.
+				_, columnComments := feedCfg.Opts[changefeedbase.OptColumnComments]
+				if columnComments && changefeedbase.FormatType(feedCfg.Opts[changefeedbase.OptFormat]) ==
+					changefeedbase.OptFormatJSON {
+					s.(*cloudStorageSink).columnComments = makeColumnCommentsFetcher(serverCfg.Executor)
+				}
+				return s, nil
 			})
 		case u.Scheme == changefeedbase.SinkSchemeExperimentalSQL:
 			return validateOptionsAndMakeSink(changefeedbase.SQLValidOptions, func() (Sink, error) {
-				return makeSQLSink(sinkURL{URL: u}, sqlSinkTableName, feedCfg.Targets, m)
+				return makeSQLSink(sinkURL{URL: u}, sqlSinkTableName, feedCfg.Targets, feedCfg.Opts, m)
 			})
 		case u.Scheme == "":
 			return nil, errors.Errorf(`no scheme found for sink URL %q`, feedCfg.SinkURI)
@@ -143,6 +302,18 @@ func getSink(
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := feedCfg.Opts[changefeedbase.OptDurableResolved]; ok {
+		if _, ok := sink.(SinkWithDurableAck); !ok {
+			return nil, errors.Errorf(`%s is not supported by %s sinks`,
+				changefeedbase.OptDurableResolved, u.Scheme)
+		}
+	}
+	if _, ok := feedCfg.Opts[changefeedbase.OptSequenceNumbers]; ok {
+		if _, ok := sink.(sequencedSink); !ok {
+			return nil, errors.Errorf(`%s is not supported by %s sinks`,
+				changefeedbase.OptSequenceNumbers, u.Scheme)
+		}
+	}
 
 	if knobs, ok := serverCfg.TestingKnobs.Changefeed.(*TestingKnobs); ok && knobs.WrapSink != nil {
 		sink = knobs.WrapSink(sink, jobID)
@@ -219,6 +390,9 @@ func (u *sinkURL) decodeBase64(param string, dest *[]byte) error {
 }
 
 func (u *sinkURL) remainingQueryParams() (res []string) {
+	if u.q == nil {
+		u.q = u.Query()
+	}
 	for p := range u.q {
 		res = append(res, p)
 	}
@@ -274,6 +448,14 @@ func (s errorWrapperSink) Flush(ctx context.Context) error {
 	return nil
 }
 
+// FlushDurable implements SinkWithDurableAck interface.
+func (s errorWrapperSink) FlushDurable(ctx context.Context) error {
+	if err := flushDurable(ctx, s.wrapped); err != nil {
+		return changefeedbase.MarkRetryableError(err)
+	}
+	return nil
+}
+
 // Close implements Sink interface.
 func (s errorWrapperSink) Close() error {
 	if err := s.wrapped.Close(); err != nil {
@@ -287,6 +469,626 @@ func (s errorWrapperSink) Dial() error {
 	return s.wrapped.Dial()
 }
 
+// CheckHealth implements SinkWithHealthCheck interface.
+// Sinks which do not support health checks are always considered healthy.
+func (s errorWrapperSink) CheckHealth(ctx context.Context) error {
+	if hc, ok := s.wrapped.(SinkWithHealthCheck); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// getEmitRateLimits returns the limits set by the max_emit_bytes_per_sec and
+// max_rows_per_sec options. A limit of 0 means the option isn't set.
+func getEmitRateLimits(opts map[string]string) (bytesPerSec, rowsPerSec int64, _ error) {
+	if v, ok := opts[changefeedbase.OptMaxEmitBytesPerSec]; ok {
+		var err error
+		if bytesPerSec, err = humanizeutil.ParseBytes(v); err != nil || bytesPerSec <= 0 {
+			return 0, 0, errors.Errorf(`%s must be a positive byte size: %q`,
+				changefeedbase.OptMaxEmitBytesPerSec, v)
+		}
+	}
+	if v, ok := opts[changefeedbase.OptMaxRowsPerSec]; ok {
+		var err error
+		if rowsPerSec, err = strconv.ParseInt(v, 10, 64); err != nil || rowsPerSec <= 0 {
+			return 0, 0, errors.Errorf(`%s must be a positive integer: %q`,
+				changefeedbase.OptMaxRowsPerSec, v)
+		}
+	}
+	return bytesPerSec, rowsPerSec, nil
+}
+
+// aggregatorShare returns the share of each of the n change aggregators of a
+// changefeed of limit, a limit of the whole changefeed. Shares are at least 1
+// if limit is set, so that no aggregator is starved.
+func aggregatorShare(limit int64, n int32) int64 {
+	if limit <= 0 || n <= 1 {
+		return limit
+	}
+	if share := limit / int64(n); share > 0 {
+		return share
+	}
+	return 1
+}
+
+// aggregatorSinkConfig returns the details of a changefeed with which each of
+// its n change aggregators makes its sink, in which the sink_concurrency
+// option, a cap of the whole changefeed, is replaced by the share of each
+// aggregator. Invalid values are left for the sink to reject.
+func aggregatorSinkConfig(
+	feedCfg jobspb.ChangefeedDetails, n int32,
+) jobspb.ChangefeedDetails {
+	v, ok := feedCfg.Opts[changefeedbase.OptSinkConcurrency]
+	if !ok {
+		return feedCfg
+	}
+	concurrency, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || concurrency <= 0 {
+		return feedCfg
+	}
+	opts := make(map[string]string, len(feedCfg.Opts))
+	for k, v := range feedCfg.Opts {
+		opts[k] = v
+	}
+	opts[changefeedbase.OptSinkConcurrency] = strconv.FormatInt(aggregatorShare(concurrency, n), 10)
+	feedCfg.Opts = opts
+	return feedCfg
+}
+
+// rateLimitingSink delegates to another sink, throttling EmitRow with token
+// buckets so that the rows and bytes emitted stay within the limits set by the
+// max_emit_bytes_per_sec and max_rows_per_sec options. Each bucket holds up to
+// a second worth of quota. The limits of the options are those of the whole
+// changefeed, which are divided evenly across its change aggregators, each of
+// which enforces its share independently: a changefeed whose changes are
+// concentrated on some of its aggregators emits less than its limits.
+type rateLimitingSink struct {
+	wrapped      Sink
+	bytesLimiter *quotapool.RateLimiter
+	rowsLimiter  *quotapool.RateLimiter
+	metrics      *sliMetrics
+}
+
+func makeRateLimitingSink(wrapped Sink, bytesPerSec, rowsPerSec int64, m *sliMetrics) Sink {
+	s := &rateLimitingSink{wrapped: wrapped, metrics: m}
+	if bytesPerSec > 0 {
+		s.bytesLimiter = quotapool.NewRateLimiter(
+			"changefeed-emit-bytes", quotapool.Limit(bytesPerSec), bytesPerSec)
+	}
+	if rowsPerSec > 0 {
+		s.rowsLimiter = quotapool.NewRateLimiter(
+			"changefeed-emit-rows", quotapool.Limit(rowsPerSec), rowsPerSec)
+	}
+	return s
+}
+
+// EmitRow implements Sink interface.
+func (s *rateLimitingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	if err := s.wait(ctx, s.rowsLimiter, 1); err != nil {
+		return err
+	}
+	if err := s.wait(ctx, s.bytesLimiter, int64(len(key)+len(value))); err != nil {
+		return err
+	}
+	return s.wrapped.EmitRow(ctx, topic, key, value, updated, mvcc, alloc)
+}
+
+// wait acquires n units of quota from the limiter, if any, recording the time
+// spent blocked in the rate limited metric.
+func (s *rateLimitingSink) wait(ctx context.Context, limiter *quotapool.RateLimiter, n int64) error {
+	if limiter == nil || limiter.AdmitN(n) {
+		return nil
+	}
+	defer s.metrics.recordRateLimited()()
+	return limiter.WaitN(ctx, n)
+}
+
+// EmitResolvedTimestamp implements Sink interface.
+func (s *rateLimitingSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	return s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+// Flush implements Sink interface.
+func (s *rateLimitingSink) Flush(ctx context.Context) error {
+	return s.wrapped.Flush(ctx)
+}
+
+// FlushDurable implements SinkWithDurableAck interface.
+func (s *rateLimitingSink) FlushDurable(ctx context.Context) error {
+	return flushDurable(ctx, s.wrapped)
+}
+
+// Close implements Sink interface.
+func (s *rateLimitingSink) Close() error {
+	return s.wrapped.Close()
+}
+
+// Dial implements Sink interface.
+func (s *rateLimitingSink) Dial() error {
+	return s.wrapped.Dial()
+}
+
+// CheckHealth implements SinkWithHealthCheck interface.
+func (s *rateLimitingSink) CheckHealth(ctx context.Context) error {
+	if hc, ok := s.wrapped.(SinkWithHealthCheck); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// laggingSink delegates to another sink, holding rows back until they age
+// past the lag of the watermark_lag option, or until the window of the
+// emit_window option opens, in which case see emitWindow. Held rows are copied out of the kvfeed's memory
+// buffer, whose quota is released immediately: keeping it would stall the
+// kvfeed, and with it the resolved timestamps which release the rows. The
+// copies are charged to acc instead, an account of the change aggregator's
+// memory monitor, which the kvfeed's buffer draws from as well.
+//
+// Held rows are kept in the order of their updated timestamps, so that they
+// are released from the front. Once the monitor is exhausted, EmitRow applies
+// backpressure: it waits for the oldest held row to age past the lag, emits
+// it and tries again. The aggregator stops consuming changes in the meantime,
+// which in turn stalls its rangefeeds once their buffer is full. No row is
+// emitted ahead of the lag. Outside of the window, EmitRow fails instead.
+type laggingSink struct {
+	wrapped Sink
+	acc     *mon.BoundAccount
+	// lag is the lag of the watermark_lag option, if any.
+	lag time.Duration
+	// heldBytesGauge, if set, tracks heldBytes.
+	heldBytesGauge *aggmetric.Gauge
+	// window, if set, is the window of the emit_window option, and now
+	// returns the current time.
+	window *emitWindow
+	now    func() time.Time
+
+	held      laggedRowHeap
+	heldBytes int64
+	// seq numbers the held rows in the order in which they were received.
+	seq uint64
+}
+
+// laggedRow is a row held back by a laggingSink.
+type laggedRow struct {
+	topic         TopicDescriptor
+	key, value    []byte
+	updated, mvcc hlc.Timestamp
+	// seq orders the rows with the same timestamp in the order in which they
+	// were received, which keeps the order of the changes of each key.
+	seq uint64
+}
+
+// laggedRowOverhead approximates the memory used by each laggedRow on top of
+// its key and value.
+const laggedRowOverhead = 128
+
+func (r laggedRow) size() int64 {
+	return int64(len(r.key) + len(r.value) + laggedRowOverhead)
+}
+
+// laggedRowHeap is a min-heap of laggedRows ordered by updated timestamp.
+type laggedRowHeap []laggedRow
+
+func (h laggedRowHeap) Len() int { return len(h) }
+func (h laggedRowHeap) Less(i, j int) bool {
+	if h[i].updated.Equal(h[j].updated) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].updated.Less(h[j].updated)
+}
+func (h laggedRowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *laggedRowHeap) Push(x interface{}) { *h = append(*h, x.(laggedRow)) }
+func (h *laggedRowHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = laggedRow{}
+	*h = old[:n-1]
+	return x
+}
+
+func makeLaggingSink(
+	wrapped Sink,
+	acc *mon.BoundAccount,
+	lag time.Duration,
+	heldBytesGauge *aggmetric.Gauge,
+	window *emitWindow,
+	now func() time.Time,
+) *laggingSink {
+	return &laggingSink{
+		wrapped:        wrapped,
+		acc:            acc,
+		lag:            lag,
+		heldBytesGauge: heldBytesGauge,
+		window:         window,
+		now:            now,
+	}
+}
+
+// EmitRow implements Sink interface.
+func (s *laggingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	row := laggedRow{
+		topic:   topic,
+		key:     append([]byte(nil), key...),
+		value:   append([]byte(nil), value...),
+		updated: updated,
+		mvcc:    mvcc,
+	}
+	alloc.Release(ctx)
+
+	for {
+		if err := s.acc.Grow(ctx, row.size()); err == nil {
+			break
+		}
+		if len(s.held) == 0 || row.updated.Less(s.held[0].updated) {
+			// The row is older than any held row, so it's emitted first.
+			if err := s.waitReleasable(ctx, row.updated); err != nil {
+				return err
+			}
+			return s.wrapped.EmitRow(
+				ctx, row.topic, row.key, row.value, row.updated, row.mvcc, kvevent.Alloc{})
+		}
+		if err := s.waitReleasable(ctx, s.held[0].updated); err != nil {
+			return err
+		}
+		if err := s.emitOldest(ctx); err != nil {
+			return err
+		}
+	}
+	s.seq++
+	row.seq = s.seq
+	heap.Push(&s.held, row)
+	s.adjustHeldBytes(row.size())
+	return nil
+}
+
+// waitReleasable waits until a row updated at the given timestamp may be
+// emitted, once it has aged past the lag. It fails with a retryable error if
+// the window, if any, is closed, rather than wait for it to open.
+func (s *laggingSink) waitReleasable(ctx context.Context, updated hlc.Timestamp) error {
+	if s.window != nil {
+		if now := s.now(); !s.window.contains(now) {
+			return changefeedbase.MarkRetryableError(errors.Newf(
+				`rows held back by %s until %s exceed the memory budget of the changefeed`,
+				changefeedbase.OptEmitWindow, s.window.nextOpen(now).Format(time.RFC3339)))
+		}
+	}
+	if wait := updated.GoTime().Add(s.lag).Sub(s.now()); wait > 0 {
+		timer := timeutil.NewTimer()
+		defer timer.Stop()
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			timer.Read = true
+		}
+	}
+	return nil
+}
+
+// release emits the held rows updated at or before upTo, in the order of
+// their updated timestamps.
+func (s *laggingSink) release(ctx context.Context, upTo hlc.Timestamp) error {
+	for len(s.held) > 0 && !upTo.Less(s.held[0].updated) {
+		if err := s.emitOldest(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitOldest emits the held row with the oldest updated timestamp.
+func (s *laggingSink) emitOldest(ctx context.Context) error {
+	row := heap.Pop(&s.held).(laggedRow)
+	s.acc.Shrink(ctx, row.size())
+	s.adjustHeldBytes(-row.size())
+	return s.wrapped.EmitRow(
+		ctx, row.topic, row.key, row.value, row.updated, row.mvcc, kvevent.Alloc{})
+}
+
+func (s *laggingSink) adjustHeldBytes(delta int64) {
+	s.heldBytes += delta
+	if s.heldBytesGauge != nil {
+		s.heldBytesGauge.Inc(delta)
+	}
+}
+
+// EmitResolvedTimestamp implements Sink interface.
+func (s *laggingSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	return s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+// Flush implements Sink interface. Held rows are not flushed: the resolved
+// timestamps of the aggregator are held back with them.
+func (s *laggingSink) Flush(ctx context.Context) error {
+	return s.wrapped.Flush(ctx)
+}
+
+// FlushDurable implements SinkWithDurableAck interface.
+func (s *laggingSink) FlushDurable(ctx context.Context) error {
+	return flushDurable(ctx, s.wrapped)
+}
+
+// Close implements Sink interface. Held rows are dropped, and will be emitted
+// again when the changefeed resumes from its last resolved timestamp.
+func (s *laggingSink) Close() error {
+	s.adjustHeldBytes(-s.heldBytes)
+	s.held = nil
+	s.acc.Close(context.Background())
+	return s.wrapped.Close()
+}
+
+// Dial implements Sink interface.
+func (s *laggingSink) Dial() error {
+	return s.wrapped.Dial()
+}
+
+// CheckHealth implements SinkWithHealthCheck interface.
+func (s *laggingSink) CheckHealth(ctx context.Context) error {
+	if hc, ok := s.wrapped.(SinkWithHealthCheck); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// dedupSink delegates to another sink, dropping the rows emitted again with
+// the key and MVCC timestamp of a row it already emitted, as happens when the
+// rangefeeds of the change aggregator restart after range splits or errors.
+// Rows are remembered until the resolved frontier of the aggregator passes
+// their MVCC timestamp, past which they can't be emitted again. They are
+// charged to acc, an account of the change aggregator's memory monitor, and
+// once it's exhausted the oldest rows are forgotten early. The duplicates
+// introduced by restarts of the changefeed itself are not dropped.
+type dedupSink struct {
+	wrapped Sink
+	acc     *mon.BoundAccount
+	metrics *sliMetrics
+
+	seen  map[dedupKey]struct{}
+	order dedupHeap
+}
+
+// dedupKey identifies a row emitted by a dedupSink.
+type dedupKey struct {
+	topic, key string
+	mvcc       hlc.Timestamp
+}
+
+// dedupKeyOverhead approximates the memory used by each dedupKey on top of
+// its topic and key.
+const dedupKeyOverhead = 64
+
+func (k dedupKey) size() int64 {
+	return int64(len(k.topic) + len(k.key) + dedupKeyOverhead)
+}
+
+// dedupHeap is a min-heap of dedupKeys ordered by MVCC timestamp.
+type dedupHeap []dedupKey
+
+func (h dedupHeap) Len() int            { return len(h) }
+func (h dedupHeap) Less(i, j int) bool  { return h[i].mvcc.Less(h[j].mvcc) }
+func (h dedupHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *dedupHeap) Push(x interface{}) { *h = append(*h, x.(dedupKey)) }
+func (h *dedupHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = dedupKey{}
+	*h = old[:n-1]
+	return x
+}
+
+func makeDedupSink(wrapped Sink, acc *mon.BoundAccount, m *sliMetrics) *dedupSink {
+	return &dedupSink{
+		wrapped: wrapped,
+		acc:     acc,
+		metrics: m,
+		seen:    make(map[dedupKey]struct{}),
+	}
+}
+
+// EmitRow implements Sink interface.
+func (s *dedupSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	k := dedupKey{topic: topic.GetName(), key: string(key), mvcc: mvcc}
+	if _, ok := s.seen[k]; ok {
+		alloc.Release(ctx)
+		s.metrics.recordDeduplicated()
+		return nil
+	}
+	if err := s.wrapped.EmitRow(ctx, topic, key, value, updated, mvcc, alloc); err != nil {
+		return err
+	}
+	for s.acc.Grow(ctx, k.size()) != nil {
+		if len(s.order) == 0 {
+			// The row can't be remembered at all.
+			return nil
+		}
+		s.forgetOldest(ctx)
+	}
+	s.seen[k] = struct{}{}
+	heap.Push(&s.order, k)
+	return nil
+}
+
+// release forgets the rows whose MVCC timestamp is at or below the resolved
+// frontier of the change aggregator.
+func (s *dedupSink) release(ctx context.Context, resolved hlc.Timestamp) {
+	for len(s.order) > 0 && !resolved.Less(s.order[0].mvcc) {
+		s.forgetOldest(ctx)
+	}
+}
+
+func (s *dedupSink) forgetOldest(ctx context.Context) {
+	k := heap.Pop(&s.order).(dedupKey)
+	delete(s.seen, k)
+	s.acc.Shrink(ctx, k.size())
+}
+
+// EmitResolvedTimestamp implements Sink interface.
+func (s *dedupSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	return s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+// Flush implements Sink interface.
+func (s *dedupSink) Flush(ctx context.Context) error {
+	return s.wrapped.Flush(ctx)
+}
+
+// FlushDurable implements SinkWithDurableAck interface.
+func (s *dedupSink) FlushDurable(ctx context.Context) error {
+	return flushDurable(ctx, s.wrapped)
+}
+
+// Close implements Sink interface.
+func (s *dedupSink) Close() error {
+	s.seen, s.order = nil, nil
+	s.acc.Close(context.Background())
+	return s.wrapped.Close()
+}
+
+// Dial implements Sink interface.
+func (s *dedupSink) Dial() error {
+	return s.wrapped.Dial()
+}
+
+// CheckHealth implements SinkWithHealthCheck interface.
+func (s *dedupSink) CheckHealth(ctx context.Context) error {
+	if hc, ok := s.wrapped.(SinkWithHealthCheck); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// atMostOnceSink delegates to another sink, dropping the rows it fails to
+// emit rather than returning the error, which would restart the changefeed
+// from its last checkpoint and emit the rows again. It's used by the lossy
+// at_most_once option, which trades the completeness of the changefeed for
+// its liveness when the sink is unavailable. A failed Flush drops every row
+// emitted since the last successful one, so the rows counted as dropped are
+// an upper bound: some of them may have reached the sink before it failed.
+// Errors due to the cancellation of the changefeed are still returned.
+type atMostOnceSink struct {
+	wrapped Sink
+	metrics *sliMetrics
+	// unflushed is the number of rows emitted since the last successful Flush.
+	unflushed int64
+	logEvery  log.EveryN
+}
+
+// atMostOnceLogFrequency bounds how often an atMostOnceSink logs the errors
+// of the rows it drops.
+const atMostOnceLogFrequency = 10 * time.Second
+
+func makeAtMostOnceSink(wrapped Sink, m *sliMetrics) *atMostOnceSink {
+	return &atMostOnceSink{
+		wrapped:  wrapped,
+		metrics:  m,
+		logEvery: log.Every(atMostOnceLogFrequency),
+	}
+}
+
+// drop counts n rows as dropped because of err, returning err instead if the
+// changefeed is shutting down.
+func (s *atMostOnceSink) drop(ctx context.Context, n int64, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	s.metrics.recordDropped(n)
+	if s.logEvery.ShouldLog() {
+		log.Warningf(ctx, "%s: dropped %d rows which failed to be emitted: %v",
+			changefeedbase.OptAtMostOnce, n, err)
+	}
+	return nil
+}
+
+// EmitRow implements Sink interface.
+func (s *atMostOnceSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	if err := s.wrapped.EmitRow(ctx, topic, key, value, updated, mvcc, alloc); err != nil {
+		return s.drop(ctx, 1, err)
+	}
+	s.unflushed++
+	return nil
+}
+
+// EmitResolvedTimestamp implements Sink interface. Resolved timestamps which
+// fail to be emitted aren't counted as dropped, since the next one covers them.
+func (s *atMostOnceSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	if err := s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved); err != nil {
+		return s.drop(ctx, 0, err)
+	}
+	return nil
+}
+
+// Flush implements Sink interface.
+func (s *atMostOnceSink) Flush(ctx context.Context) error {
+	return s.flush(ctx, s.wrapped.Flush)
+}
+
+// FlushDurable implements SinkWithDurableAck interface.
+func (s *atMostOnceSink) FlushDurable(ctx context.Context) error {
+	return s.flush(ctx, func(ctx context.Context) error { return flushDurable(ctx, s.wrapped) })
+}
+
+func (s *atMostOnceSink) flush(ctx context.Context, flush func(context.Context) error) error {
+	unflushed := s.unflushed
+	s.unflushed = 0
+	if err := flush(ctx); err != nil {
+		return s.drop(ctx, unflushed, err)
+	}
+	return nil
+}
+
+// Close implements Sink interface.
+func (s *atMostOnceSink) Close() error {
+	return s.wrapped.Close()
+}
+
+// Dial implements Sink interface.
+func (s *atMostOnceSink) Dial() error {
+	return s.wrapped.Dial()
+}
+
+// CheckHealth implements SinkWithHealthCheck interface.
+func (s *atMostOnceSink) CheckHealth(ctx context.Context) error {
+	if hc, ok := s.wrapped.(SinkWithHealthCheck); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return nil
+}
+
 // encDatumRowBuffer is a FIFO of `EncDatumRow`s.
 //
 // TODO(dan): There's some potential allocation savings here by reusing the same
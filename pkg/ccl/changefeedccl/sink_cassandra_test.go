@@ -0,0 +1,349 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCassandraServer accepts connections and replies as Cassandra would to
+// the requests of the cassandra sink, with the `ks` keyspace holding the
+// tables whose columns are in tables. If password is set, it requires
+// authentication as `user`. It records prepared statements and the batches it
+// receives, and fails batches holding a value which contains `fail`.
+type fakeCassandraServer struct {
+	ln       net.Listener
+	password string
+	// tables holds the name, kind, position and type of the columns of each
+	// table.
+	tables map[string][][4]string
+
+	mu struct {
+		syncutil.Mutex
+		prepared []string
+		batches  []string
+	}
+}
+
+func startFakeCassandraServer(t *testing.T, password string) *fakeCassandraServer {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	require.NoError(t, err)
+	s := &fakeCassandraServer{ln: ln, password: password, tables: make(map[string][][4]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeCassandraServer) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, cqlHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[5:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		op, res := s.handle(cqlOpcode(header[4]), newCQLReader(body))
+		resHeader := []byte{cqlProtocolVersion | cqlResponseFlag, 0, header[2], header[3], byte(op)}
+		resHeader = appendCQLInt(resHeader, int32(len(res)))
+		if _, err := conn.Write(append(resHeader, res...)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeCassandraServer) handle(op cqlOpcode, r *cqlReader) (cqlOpcode, []byte) {
+	errorResponse := func(code int32, msg string) (cqlOpcode, []byte) {
+		return cqlOpError, appendCQLString(appendCQLInt(nil, code), msg)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch op {
+	case cqlOpStartup:
+		if s.password != `` {
+			return cqlOpAuthenticate, appendCQLString(nil, `org.apache.cassandra.auth.PasswordAuthenticator`)
+		}
+		return cqlOpReady, nil
+	case cqlOpAuthResponse:
+		if string(r.readBytes()) != "\x00user\x00"+s.password {
+			return errorResponse(0x0100, `Provided username and/or password are incorrect`)
+		}
+		return cqlOpAuthSuccess, appendCQLBytes(nil, nil)
+	case cqlOpQuery:
+		stmt := string(r.next(int(r.readInt())))
+		r.readShort()
+		r.next(1)
+		var values []string
+		for i := r.readShort(); i > 0; i-- {
+			values = append(values, string(r.readBytes()))
+		}
+		var rows [][][]byte
+		switch {
+		case strings.Contains(stmt, `system_schema.keyspaces`):
+			if values[0] == `ks` {
+				rows = append(rows, [][]byte{[]byte(`ks`)})
+			}
+		case strings.Contains(stmt, `system_schema.columns`):
+			for _, col := range s.tables[values[1]] {
+				var position int32
+				_, _ = fmt.Sscan(col[2], &position)
+				rows = append(rows, [][]byte{
+					[]byte(col[0]), []byte(col[1]), appendCQLInt(nil, position), []byte(col[3]),
+				})
+			}
+		default:
+			return errorResponse(0x2000, `unexpected query`)
+		}
+		res := appendCQLInt(nil, cqlResultRows)
+		res = appendCQLInt(res, cqlMetadataNoMetadata)
+		res = appendCQLInt(res, 4)
+		res = appendCQLInt(res, int32(len(rows)))
+		for _, row := range rows {
+			for _, v := range row {
+				res = appendCQLBytes(res, v)
+			}
+		}
+		return cqlOpResult, res
+	case cqlOpPrepare:
+		s.mu.prepared = append(s.mu.prepared, string(r.next(int(r.readInt()))))
+		res := appendCQLInt(nil, cqlResultPrepared)
+		return cqlOpResult, appendCQLShortBytes(res, []byte{byte(len(s.mu.prepared) - 1)})
+	case cqlOpBatch:
+		r.next(1)
+		var stmts []string
+		fail := false
+		for i := r.readShort(); i > 0; i-- {
+			r.next(1)
+			stmt := s.mu.prepared[r.readShortBytes()[0]]
+			var values []string
+			for j := r.readShort(); j > 0; j-- {
+				v := r.readBytes()
+				fail = fail || strings.Contains(string(v), `fail`)
+				values = append(values, renderCQLValue(v))
+			}
+			stmts = append(stmts, stmt+` `+strings.Join(values, ` `))
+		}
+		consistency := r.readShort()
+		if r.err != nil {
+			return errorResponse(0x000A, r.err.Error())
+		}
+		if fail {
+			return errorResponse(0x2200, `cannot write`)
+		}
+		s.mu.batches = append(s.mu.batches,
+			fmt.Sprintf(`%d: %s`, consistency, strings.Join(stmts, `; `)))
+		return cqlOpResult, appendCQLInt(nil, 0x0001 /* void */)
+	default:
+		return errorResponse(0x000A, `unexpected request`)
+	}
+}
+
+// renderCQLValue renders the timestamps bound to statements as integers, and
+// other values as text.
+func renderCQLValue(v []byte) string {
+	for _, r := range string(v) {
+		if !unicode.IsPrint(r) {
+			return fmt.Sprint(int64(binary.BigEndian.Uint64(v)))
+		}
+	}
+	return string(v)
+}
+
+// batches returns the batches received, sorted as their order is arbitrary.
+func (s *fakeCassandraServer) batches() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := append([]string(nil), s.mu.batches...)
+	sort.Strings(res)
+	return res
+}
+
+func (s *fakeCassandraServer) prepared() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mu.prepared...)
+}
+
+func TestCassandraSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	server := startFakeCassandraServer(t, `pass`)
+	defer server.ln.Close()
+	server.tables[`foo`] = [][4]string{
+		{`a`, `partition_key`, `0`, `bigint`},
+		{`b`, `clustering`, `0`, `text`},
+		{`c`, `regular`, `-1`, `blob`},
+		{`d`, `regular`, `-1`, `timestamp`},
+		{`unused`, `regular`, `-1`, `text`},
+	}
+	server.tables[`wrong_type`] = [][4]string{
+		{`a`, `partition_key`, `0`, `int`},
+	}
+	server.tables[`wrong_key`] = [][4]string{
+		{`a`, `partition_key`, `0`, `bigint`},
+		{`b`, `regular`, `-1`, `bigint`},
+	}
+
+	fooDesc, err := parseTableDesc(`CREATE TABLE foo (a INT, b STRING, c BYTES, d TIMESTAMP, PRIMARY KEY (a, b))`)
+	require.NoError(t, err)
+	fooTopic := tableDescriptorTopic{fooDesc}
+	jsonOpts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+
+	makeSink := func(uri string, opts map[string]string) (*cassandraSink, error) {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeCassandraSink(sinkURL{URL: u}, opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		return sink.(*cassandraSink), nil
+	}
+	dialSink := func(t *testing.T, uri string) *cassandraSink {
+		sink, err := makeSink(uri, jsonOpts)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+	ts := func(micros int64) hlc.Timestamp {
+		return hlc.Timestamp{WallTime: micros * 1000}
+	}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := dialSink(t, fmt.Sprintf(`cassandra://user:pass@%s/ks?consistency=quorum`, server.ln.Addr()))
+		defer func() { require.NoError(t, sink.Close()) }()
+		require.NoError(t, sink.validateTables(ctx, []catalog.TableDescriptor{fooDesc}))
+
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[1, "x"]`),
+			[]byte(`{"after": {"a": 1, "b": "x", "c": "\\x01ff", "d": "2022-01-02T03:04:05.678"}}`),
+			ts(1), ts(1), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[1, "y"]`),
+			[]byte(`{"after": {"a": 1, "b": "y", "c": null, "d": null}}`), ts(2), ts(2), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[12345678901234567, "z"]`),
+			[]byte(`{"after": null}`), ts(3), ts(3), zeroAlloc))
+		require.NoError(t, sink.Flush(ctx))
+
+		quorum := cqlConsistencies[`QUORUM`]
+		require.Equal(t, []string{
+			`INSERT INTO "ks"."foo" JSON ? DEFAULT UNSET USING TIMESTAMP ?`,
+			`DELETE FROM "ks"."foo" USING TIMESTAMP ? WHERE "a" = fromJson(?) AND "b" = fromJson(?)`,
+		}, server.prepared())
+		require.Equal(t, []string{
+			fmt.Sprintf(`%d: DELETE FROM "ks"."foo" USING TIMESTAMP ? WHERE "a" = fromJson(?) AND "b" = fromJson(?) `+
+				`3 12345678901234567 "z"`, quorum),
+			fmt.Sprintf(`%d: INSERT INTO "ks"."foo" JSON ? DEFAULT UNSET USING TIMESTAMP ? `+
+				`{"a":1,"b":"x","c":"0x01ff","d":1641092645678} 1; `+
+				`INSERT INTO "ks"."foo" JSON ? DEFAULT UNSET USING TIMESTAMP ? `+
+				`{"a":1,"b":"y","c":null,"d":null} 2`, quorum),
+		}, server.batches())
+	})
+
+	t.Run(`batch error`, func(t *testing.T) {
+		sink := dialSink(t, fmt.Sprintf(`cassandra://user:pass@%s/ks`, server.ln.Addr()))
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[1, "fail"]`),
+			[]byte(`{"after": {"a": 1, "b": "fail", "c": null, "d": null}}`), ts(1), ts(1), zeroAlloc))
+		require.Regexp(t, `cassandra error 0x2200: cannot write`, sink.Flush(ctx))
+	})
+
+	t.Run(`auth`, func(t *testing.T) {
+		sink, err := makeSink(fmt.Sprintf(`cassandra://%s/ks`, server.ln.Addr()), jsonOpts)
+		require.NoError(t, err)
+		require.Regexp(t, `cassandra requires authentication with .*PasswordAuthenticator`, sink.Dial())
+
+		sink, err = makeSink(fmt.Sprintf(`cassandra://user:wrong@%s/ks`, server.ln.Addr()), jsonOpts)
+		require.NoError(t, err)
+		require.Regexp(t, `username and/or password are incorrect`, sink.Dial())
+
+		sink, err = makeSink(fmt.Sprintf(`cassandra://user:pass@%s/missing`, server.ln.Addr()), jsonOpts)
+		require.NoError(t, err)
+		require.Regexp(t, `cassandra keyspace missing does not exist`, sink.Dial())
+	})
+
+	t.Run(`validate tables`, func(t *testing.T) {
+		sink := dialSink(t, fmt.Sprintf(`cassandra://user:pass@%s/ks`, server.ln.Addr()))
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		for stmt, expected := range map[string]string{
+			`CREATE TABLE missing (a INT PRIMARY KEY)`:                      `cassandra table ks.missing does not exist`,
+			`CREATE TABLE foo (a INT, b STRING, e INT, PRIMARY KEY (a, b))`: `cassandra table ks.foo has no column e`,
+			`CREATE TABLE wrong_type (a INT PRIMARY KEY)`: `column a of cassandra table ks.wrong_type has type int, ` +
+				`column of type INT8 requires one of bigint, varint`,
+			`CREATE TABLE wrong_key (a INT, b INT, PRIMARY KEY (a, b))`: `primary key of cassandra table ks.wrong_key ` +
+				`is \(a\), table wrong_key requires \(a, b\)`,
+			`CREATE TABLE foo (a INT, b STRING, c INTERVAL, PRIMARY KEY (a, b))`: `column c of type INTERVAL ` +
+				`is not supported by cassandra sinks`,
+		} {
+			desc, err := parseTableDesc(stmt)
+			require.NoError(t, err)
+			require.Regexp(t, expected, sink.validateTables(ctx, []catalog.TableDescriptor{desc}))
+		}
+	})
+
+	t.Run(`invalid`, func(t *testing.T) {
+		addr := server.ln.Addr()
+		for uri, expected := range map[string]string{
+			`cassandra:///ks`:                                                    `host must be specified`,
+			fmt.Sprintf(`cassandra://%s`, addr):                                  `keyspace must be specified`,
+			fmt.Sprintf(`cassandra://%s/ks?consistency=some`, addr):              `unknown consistency: some`,
+			fmt.Sprintf(`cassandra://%s/ks?ca_cert=Zm9v`, addr):                  `require tls_enabled=true`,
+			fmt.Sprintf(`cassandra://%s/ks?tables=foo`, addr):                    `unknown cassandra sink query parameters: tables`,
+			fmt.Sprintf(`cassandra://%s/ks?tls_enabled=true&ca_cert=Zm9v`, addr): `failed to parse certificate data`,
+		} {
+			_, err := makeSink(uri, jsonOpts)
+			require.Regexp(t, expected, err, uri)
+		}
+
+		uri := fmt.Sprintf(`cassandra://%s/ks`, addr)
+		for opts, expected := range map[[3]string]string{
+			{`avro`, `wrapped`, ``}:   `cassandra sink requires format=json`,
+			{`json`, `key_only`, ``}:  `envelope=key_only is not supported by cassandra sinks`,
+			{`json`, `wrapped`, `op`}: `delete_format=op is not supported by cassandra sinks`,
+		} {
+			_, err := makeSink(uri, map[string]string{
+				changefeedbase.OptFormat:       opts[0],
+				changefeedbase.OptEnvelope:     opts[1],
+				changefeedbase.OptDeleteFormat: opts[2],
+			})
+			require.Regexp(t, expected, err)
+		}
+	})
+}
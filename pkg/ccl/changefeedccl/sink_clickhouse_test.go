@@ -0,0 +1,261 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClickHouseTable is a table of a fakeClickHouseServer.
+type fakeClickHouseTable struct {
+	engine, sortingKey string
+	// columns holds the names and types of the columns.
+	columns [][2]string
+}
+
+// fakeClickHouseServer replies as ClickHouse would to the requests of the
+// clickhouse sink, for the tables of its `analytics` database, recording the
+// inserts it receives, and rejects inserts holding `fail`.
+type fakeClickHouseServer struct {
+	tables map[string]fakeClickHouseTable
+	mu     struct {
+		syncutil.Mutex
+		inserts []string
+	}
+}
+
+func (s *fakeClickHouseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	params := r.URL.Query()
+	if r.URL.Path == `/ping` {
+		_, _ = w.Write([]byte("Ok.\n"))
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != `/` {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if insert := params.Get(`query`); insert != `` {
+		s.mu.Lock()
+		s.mu.inserts = append(s.mu.inserts, fmt.Sprintf("%s %s\n%s",
+			r.Header.Get(`X-ClickHouse-User`), insert, body))
+		s.mu.Unlock()
+		if strings.Contains(string(body), `fail`) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("Code: 27. DB::Exception: Cannot parse input\n"))
+		}
+		return
+	}
+
+	var rows []interface{}
+	table, tableExists := s.tables[params.Get(`param_table`)]
+	switch query := string(body); {
+	case strings.Contains(query, `system.databases`):
+		if params.Get(`param_database`) == `analytics` {
+			rows = append(rows, map[string]string{`name`: `analytics`})
+		}
+	case strings.Contains(query, `system.tables`):
+		if tableExists {
+			rows = append(rows, map[string]string{`engine`: table.engine, `sorting_key`: table.sortingKey})
+		}
+	case strings.Contains(query, `system.columns`):
+		for _, col := range table.columns {
+			rows = append(rows, map[string]string{`name`: col[0], `type`: col[1]})
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, row := range rows {
+		line, _ := gojson.Marshal(row)
+		_, _ = w.Write(append(line, '\n'))
+	}
+}
+
+func (s *fakeClickHouseServer) inserts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inserts := s.mu.inserts
+	s.mu.inserts = nil
+	return inserts
+}
+
+func TestClickHouseSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	ordersColumns := [][2]string{
+		{`id`, `Int64`}, {`region`, `LowCardinality(String)`}, {`total`, `Nullable(Decimal(18, 2))`},
+		{`placed`, `DateTime64(6, 'UTC')`}, {`tags`, `Array(Nullable(String))`}, {`note`, `String`},
+		{`_version`, `UInt64`}, {`_sign`, `Int8`},
+	}
+	withColumns := func(columns ...[2]string) fakeClickHouseTable {
+		return fakeClickHouseTable{engine: `ReplacingMergeTree`, sortingKey: `region, id`, columns: columns}
+	}
+	fake := &fakeClickHouseServer{tables: map[string]fakeClickHouseTable{
+		`orders`:           withColumns(ordersColumns...),
+		`orders_merge`:     {engine: `MergeTree`, sortingKey: `region, id`, columns: ordersColumns},
+		`orders_sorted`:    {engine: `ReplicatedReplacingMergeTree`, sortingKey: `id`, columns: ordersColumns},
+		`orders_no_note`:   withColumns(ordersColumns[:5]...),
+		`orders_note_int`:  withColumns(append(ordersColumns[:5:5], [2]string{`note`, `Int64`})...),
+		`orders_no_sign`:   withColumns(ordersColumns[:7]...),
+		`orders_version32`: withColumns(append(ordersColumns[:6:6], [2]string{`_version`, `UInt32`}, ordersColumns[7])...),
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, `http://`)
+
+	descFor := func(t *testing.T, name string) catalog.TableDescriptor {
+		desc, err := parseTableDesc(`CREATE TABLE ` + name + ` (id INT, region STRING, total DECIMAL, ` +
+			`placed TIMESTAMP, tags STRING[], note STRING, PRIMARY KEY (region, id))`)
+		require.NoError(t, err)
+		return desc
+	}
+	jsonOpts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	makeSink := func(uri string, opts map[string]string) (*clickHouseSink, error) {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeClickHouseSink(sinkURL{URL: u}, opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		return sink.(*clickHouseSink), nil
+	}
+	ts := hlc.Timestamp{WallTime: 1641092645000000001}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink, err := makeSink(fmt.Sprintf(`clickhouse://writer:secret@%s/analytics`, host), jsonOpts)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		defer func() { require.NoError(t, sink.Close()) }()
+		orders := descFor(t, `orders`)
+		require.NoError(t, sink.validateTables(ctx, []catalog.TableDescriptor{orders}))
+
+		topic := tableDescriptorTopic{orders}
+		require.NoError(t, sink.EmitRow(ctx, topic, []byte(`["eu", 1]`), []byte(`{"after": {"id": 1, `+
+			`"region": "eu", "total": 12345678901234567.89, "placed": "2022-01-02T03:04:05.678", `+
+			`"tags": ["a", null], "note": null}}`), ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, topic, []byte(`["us", 2]`), []byte(`{"after": null}`),
+			ts, ts.Next(), zeroAlloc))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, nil, ts))
+		// Rows aren't inserted until they're flushed.
+		require.Empty(t, fake.inserts())
+		require.NoError(t, sink.Flush(ctx))
+		// Flushing without buffered rows doesn't insert.
+		require.NoError(t, sink.Flush(ctx))
+
+		require.Equal(t, []string{
+			"writer INSERT INTO `analytics`.`orders` FORMAT JSONEachRow\n" +
+				`{"_sign":1,"_version":1641092645000000001,"id":1,"note":null,` +
+				`"placed":"2022-01-02T03:04:05.678Z","region":"eu","tags":["a",null],"total":12345678901234567.89}` + "\n" +
+				`{"_sign":-1,"_version":1641092645000000001,"id":2,"region":"us"}` + "\n",
+		}, fake.inserts())
+	})
+
+	t.Run(`insert error`, func(t *testing.T) {
+		sink, err := makeSink(fmt.Sprintf(`clickhouse://%s/analytics`, host), jsonOpts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, sink.Close()) }()
+		require.NoError(t, sink.EmitRow(ctx, tableDescriptorTopic{descFor(t, `orders`)}, []byte(`["eu", 1]`),
+			[]byte(`{"after": {"id": 1, "region": "eu", "total": null, "placed": null, "tags": null, "note": "fail"}}`),
+			ts, ts, zeroAlloc))
+		require.Regexp(t, `inserting into clickhouse: 500 Internal Server Error: Code: 27. DB::Exception: Cannot parse input`,
+			sink.Flush(ctx))
+	})
+
+	t.Run(`validate tables`, func(t *testing.T) {
+		sink, err := makeSink(fmt.Sprintf(`clickhouse://%s/analytics`, host), jsonOpts)
+		require.NoError(t, err)
+		for name, expected := range map[string]string{
+			`missing`:          `clickhouse table analytics.missing does not exist`,
+			`orders_merge`:     `clickhouse table analytics.orders_merge has engine MergeTree, which requires ReplacingMergeTree`,
+			`orders_sorted`:    `clickhouse table analytics.orders_sorted is sorted by \(id\), table orders_sorted requires \(region, id\)`,
+			`orders_no_note`:   `clickhouse table analytics.orders_no_note has no column note`,
+			`orders_note_int`:  `column note of clickhouse table analytics.orders_note_int has type Int64, column of type STRING requires one of String`,
+			`orders_no_sign`:   `clickhouse table analytics.orders_no_sign requires column _sign of type Int8, whose name can be set with sign_column`,
+			`orders_version32`: `clickhouse table analytics.orders_version32 requires column _version of type UInt64, whose name can be set with version_column`,
+		} {
+			require.Regexp(t, expected, sink.validateTables(ctx, []catalog.TableDescriptor{descFor(t, name)}), name)
+		}
+
+		desc, err := parseTableDesc(`CREATE TABLE orders (id INT PRIMARY KEY, b BIT)`)
+		require.NoError(t, err)
+		require.EqualError(t, sink.validateTables(ctx, []catalog.TableDescriptor{desc}),
+			`column b of type BIT is not supported by clickhouse sinks`)
+
+		sink, err = makeSink(fmt.Sprintf(`clickhouse://%s/analytics?version_column=note`, host), jsonOpts)
+		require.NoError(t, err)
+		require.EqualError(t, sink.validateTables(ctx, []catalog.TableDescriptor{descFor(t, `orders`)}),
+			`column note of table orders conflicts with the version_column or sign_column of clickhouse sink`)
+
+		sink, err = makeSink(fmt.Sprintf(`clickhouse://%s/events`, host), jsonOpts)
+		require.NoError(t, err)
+		require.EqualError(t, sink.Dial(), `clickhouse database events does not exist`)
+	})
+
+	t.Run(`invalid`, func(t *testing.T) {
+		for uri, expected := range map[string]string{
+			`clickhouse:///analytics`:                                  `host must be specified`,
+			`clickhouse://ch/analytics?version_column=v&sign_column=v`: `version_column and sign_column must differ`,
+			`clickhouse://ch/analytics?tls_enabled=maybe`:              `tls_enabled`,
+			`clickhouse://ch/analytics?topic_prefix=a_`:                `unknown clickhouse sink query parameters: topic_prefix`,
+		} {
+			_, err := makeSink(uri, jsonOpts)
+			require.Regexp(t, expected, err, uri)
+		}
+
+		uri := `clickhouse://ch/analytics`
+		for opts, expected := range map[[3]string]string{
+			{`avro`, `wrapped`, ``}:   `clickhouse sink requires format=json`,
+			{`json`, `key_only`, ``}:  `envelope=key_only is not supported by clickhouse sinks`,
+			{`json`, `wrapped`, `op`}: `delete_format=op is not supported by clickhouse sinks`,
+		} {
+			_, err := makeSink(uri, map[string]string{
+				changefeedbase.OptFormat:       opts[0],
+				changefeedbase.OptEnvelope:     opts[1],
+				changefeedbase.OptDeleteFormat: opts[2],
+			})
+			require.Regexp(t, expected, err)
+		}
+
+		for uri, expected := range map[string]string{
+			`clickhouse://ch`:                      `http://ch:8123`,
+			`clickhouse://ch:9000/analytics`:       `http://ch:9000`,
+			`clickhouse://ch?tls_enabled=true`:     `https://ch:8443`,
+			`clickhouse://ch:443?tls_enabled=true`: `https://ch:443`,
+		} {
+			sink, err := makeSink(uri, jsonOpts)
+			require.NoError(t, err)
+			require.Equal(t, expected, sink.baseURL, uri)
+		}
+		sink, err := makeSink(`clickhouse://ch`, jsonOpts)
+		require.NoError(t, err)
+		require.Equal(t, `default`, sink.database)
+	})
+}
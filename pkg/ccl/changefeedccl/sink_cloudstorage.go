@@ -13,11 +13,13 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 
@@ -27,6 +29,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/cloud"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
@@ -66,6 +69,10 @@ type cloudStorageSinkFile struct {
 	alloc         kvevent.Alloc
 	oldestMVCC    hlc.Timestamp
 	recordMetrics recordEmittedMessagesCallback
+	// lastWrite orders the files by their last write, for max_open_files.
+	lastWrite int64
+	// orc, if set, assembles the contents of an ORC file in buf.
+	orc *orcWriter
 }
 
 var _ io.Writer = &cloudStorageSinkFile{}
@@ -79,6 +86,14 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 	return f.buf.Write(p)
 }
 
+// size returns the number of bytes buffered for the file.
+func (f *cloudStorageSinkFile) size() int {
+	if f.orc != nil {
+		return f.orc.size()
+	}
+	return f.buf.Len()
+}
+
 // cloudStorageSink writes changefeed output to files in a cloud storage bucket
 // (S3/GCS/HTTP) maintaining CDC's ordering guarantees (see below) for each
 // row through lexicographical filename ordering.
@@ -106,6 +121,8 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // 3. All rows in a file are from the same table. Further, all rows in a file are
 // from the same schema version of that table, and so all have the same schema.
 // 4. All files are partitioned into folders by the date part of the filename.
+// With the event_time option, data files are instead partitioned into folders
+// of their topic by the event time of their rows (see eventTimePartitionFormats).
 //
 // Two methods of the cloudStorageSink on each data emitting processor are
 // called. EmitRow is called with each row change and Flush is called before
@@ -172,9 +189,13 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // by a given `<sink_id>` and <session_id> is a unique identifying string for the job
 // session running the `changeAggregator` that owns this sink.
 //
-// `<ext>` implies the format of the file: currently the only option is
-// `ndjson`, which means a text file conforming to the "Newline Delimited JSON"
-// spec.
+// `<ext>` implies the format of the file: `ndjson` means a text file conforming
+// to the "Newline Delimited JSON" spec, and `avrobin` means a sequence of
+// records, each of which is the length-prefixed key followed by the
+// length-prefixed value. Both are encoded in the confluent wire format, which
+// prefixes every message with the ID of the schema registry schema used to
+// encode it. `orc` means an Apache ORC file with a column per table column
+// plus columns for the envelope metadata.
 //
 // This naming convention of data files is carefully chosen in order to preserve
 // the external ordering guarantees of CDC. Naming output files in this fashion
@@ -182,8 +203,8 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // name, table schema version pair within a given job session. This ensures that
 // all row updates for a given span are read in an order that preserves the CDC
 // ordering guarantees, even in the presence of job restarts (see proof below).
-// Each record in the data files is a value, keys are not included, so the
-// `envelope` option must be set to `value_only`. Within a file, records are not
+// Each record in the ndjson data files is a value, keys are not included, so
+// the `envelope` option must be set to `value_only`. Within a file, records are not
 // guaranteed to be sorted by timestamp. A duplicate of some records might exist
 // in a different file or even in the same file.
 //
@@ -196,7 +217,7 @@ func (f *cloudStorageSinkFile) Write(p []byte) (int, error) {
 // deleted, included in hive queries, etc). A typical user of cloudStorageSink
 // would periodically do exactly this.
 //
-// Still TODO is writing out data schemas, Avro support, bounding memory usage.
+// Still TODO is writing out data schemas, bounding memory usage.
 //
 // Now what follows is a proof of why the above is correct even in the presence
 // of multiple job restarts. We begin by establishing some terminology and by
@@ -281,9 +302,26 @@ type cloudStorageSink struct {
 	targetMaxFileSize int64
 	settings          *cluster.Settings
 	partitionFormat   string
+	// eventTimePartitionFormat, if set, partitions data files by the MVCC
+	// timestamps of their rows rather than by the time they're flushed. See
+	// eventTimePartitionFormats.
+	eventTimePartitionFormat string
+	// maxOpenFiles, if set, bounds the number of files buffered at once. The
+	// least recently written file is flushed to make room for another one.
+	maxOpenFiles int
+	// writes counts the writes to files, ordering them for maxOpenFiles.
+	writes int64
 
 	ext          string
 	rowDelimiter []byte
+	// lengthPrefixRecords, if set, writes each record as the length-prefixed
+	// key followed by the length-prefixed value instead of writing the value
+	// followed by rowDelimiter.
+	lengthPrefixRecords bool
+	// orc, if set, writes the records into ORC files. The records are encoded
+	// by orcEncoder.
+	orc     bool
+	orcOpts orcOptions
 
 	compression string
 
@@ -300,9 +338,21 @@ type cloudStorageSink struct {
 	// frontier as of the time of the last `Flush()` call. If `Flush()` hasn't been
 	// called, these fields are based on the statement time of the changefeed.
 	dataFileTs        string
+	dataFileMinTs     hlc.Timestamp
 	dataFilePartition string
 	prevFilename      string
 	metrics           *sliMetrics
+
+	// scratch is used to assemble length-prefixed records.
+	scratch []byte
+
+	// columnComments, if set, returns the column comments included in the
+	// schema sidecar files written for JSON messages with the column_comments
+	// option. See writeSchemaSidecar.
+	columnComments columnCommentsFetcher
+	// schemaSidecars holds the table versions whose schema sidecar files have
+	// been written by this sink.
+	schemaSidecars map[cloudStorageSinkKey]struct{}
 }
 
 const sinkCompressionGzip = "gzip"
@@ -320,6 +370,21 @@ var partitionDateFormats = map[string]string{
 }
 var defaultPartitionFormat = partitionDateFormats["daily"]
 
+// With the event_time option, data files are partitioned by the MVCC
+// timestamps of their rows, which the option adds to them as their event time,
+// in the Hive layout, under a folder per topic, e.g. to
+// topic/dt=2022-01-02/file.ndjson, which lets query engines prune partitions
+// by the date of rows. Each file only holds rows of a single partition: the
+// rows of a topic buffered across a partition boundary between two flushes,
+// e.g. around midnight, are written to a file in each partition, the names of
+// which are ordered as usual. Resolved timestamp files are still partitioned by
+// partitionDateFormats, outside of the folders of topics.
+var eventTimePartitionFormats = map[string]string{
+	"flat":   "",
+	"daily":  "dt=2006-01-02",
+	"hourly": "dt=2006-01-02/hr=15",
+}
+
 func makeCloudStorageSink(
 	ctx context.Context,
 	u sinkURL,
@@ -358,7 +423,8 @@ func makeCloudStorageSink(
 		metrics:      m,
 	}
 
-	if partitionFormat := u.consumeParam(changefeedbase.SinkParamPartitionFormat); partitionFormat != "" {
+	partitionFormat := u.consumeParam(changefeedbase.SinkParamPartitionFormat)
+	if partitionFormat != "" {
 		dateFormat, ok := partitionDateFormats[partitionFormat]
 		if !ok {
 			return nil, errors.Errorf("invalid partition_format of %s", partitionFormat)
@@ -366,9 +432,26 @@ func makeCloudStorageSink(
 
 		s.partitionFormat = dateFormat
 	}
+	if v, ok := opts[changefeedbase.OptMaxOpenFiles]; ok {
+		if s.maxOpenFiles, err = strconv.Atoi(v); err != nil || s.maxOpenFiles <= 0 {
+			return nil, errors.Errorf(`%s must be a positive integer: %q`,
+				changefeedbase.OptMaxOpenFiles, v)
+		}
+	}
+	if _, ok := opts[changefeedbase.OptEventTime]; ok {
+		if changefeedbase.FormatType(opts[changefeedbase.OptFormat]) != changefeedbase.OptFormatJSON {
+			return nil, errors.Errorf(`%s is only usable with %s=%s`,
+				changefeedbase.OptEventTime, changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+		}
+		if partitionFormat == "" {
+			partitionFormat = "daily"
+		}
+		s.eventTimePartitionFormat = eventTimePartitionFormats[partitionFormat]
+	}
 
 	if s.timestampOracle != nil {
 		s.dataFileTs = cloudStorageFormatTime(s.timestampOracle.inclusiveLowerBoundTS())
+		s.dataFileMinTs = s.timestampOracle.inclusiveLowerBoundTS()
 		s.dataFilePartition = s.timestampOracle.inclusiveLowerBoundTS().GoTime().Format(s.partitionFormat)
 	}
 
@@ -378,6 +461,22 @@ func makeCloudStorageSink(
 		// would require a bit of refactoring.
 		s.ext = `.ndjson`
 		s.rowDelimiter = []byte{'\n'}
+	case changefeedbase.OptFormatAvro, changefeedbase.DeprecatedOptFormatAvro:
+		// Avro messages are binary and the value doesn't include the key, so
+		// both are written out with a length prefix.
+		s.ext = `.avrobin`
+		s.lengthPrefixRecords = true
+	case changefeedbase.OptFormatMsgpack:
+		// As with Avro, the value doesn't include the key unless key_in_value
+		// is set.
+		s.ext = `.msgpackbin`
+		s.lengthPrefixRecords = true
+	case changefeedbase.OptFormatORC:
+		// ORC files include the primary key columns and the envelope metadata
+		// as columns.
+		s.ext = `.orc`
+		s.orc = true
+		s.orcOpts = makeORCOptions(opts)
 	default:
 		return nil, errors.Errorf(`this sink is incompatible with %s=%s`,
 			changefeedbase.OptFormat, opts[changefeedbase.OptFormat])
@@ -390,11 +489,15 @@ func makeCloudStorageSink(
 			changefeedbase.OptEnvelope, opts[changefeedbase.OptEnvelope])
 	}
 
-	if _, ok := opts[changefeedbase.OptKeyInValue]; !ok {
+	if _, ok := opts[changefeedbase.OptKeyInValue]; !ok && !s.lengthPrefixRecords && !s.orc {
 		return nil, errors.Errorf(`this sink requires the WITH %s option`, changefeedbase.OptKeyInValue)
 	}
 
 	if codec, ok := opts[changefeedbase.OptCompression]; ok && codec != "" {
+		if s.orc {
+			return nil, errors.Errorf(`%s is not supported with %s=%s`,
+				changefeedbase.OptCompression, changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+		}
 		if strings.EqualFold(codec, "gzip") {
 			s.compression = sinkCompressionGzip
 			s.ext = s.ext + ".gz"
@@ -411,27 +514,56 @@ func makeCloudStorageSink(
 }
 
 func (s *cloudStorageSink) getOrCreateFile(
-	topic TopicDescriptor, eventMVCC hlc.Timestamp,
-) *cloudStorageSinkFile {
-	key := cloudStorageSinkKey{topic.GetName(), int64(topic.GetVersion())}
+	ctx context.Context, topic TopicDescriptor, eventMVCC hlc.Timestamp,
+) (*cloudStorageSinkFile, error) {
+	s.writes++
+	key := cloudStorageSinkKey{topic: topic.GetName(), schemaID: int64(topic.GetVersion())}
+	if s.eventTimePartitionFormat != "" {
+		key.partition = eventMVCC.GoTime().Format(s.eventTimePartitionFormat)
+	}
 	if item := s.files.Get(key); item != nil {
 		f := item.(*cloudStorageSinkFile)
 		if eventMVCC.Less(f.oldestMVCC) {
 			f.oldestMVCC = eventMVCC
 		}
-		return f
+		f.lastWrite = s.writes
+		return f, nil
+	}
+	if s.maxOpenFiles > 0 && s.files.Len() >= s.maxOpenFiles {
+		if err := s.flushLeastRecentlyWritten(ctx); err != nil {
+			return nil, err
+		}
 	}
 	f := &cloudStorageSinkFile{
 		cloudStorageSinkKey: key,
 		recordMetrics:       s.metrics.recordEmittedMessages(),
 		oldestMVCC:          eventMVCC,
+		lastWrite:           s.writes,
 	}
 	switch s.compression {
 	case sinkCompressionGzip:
 		f.codec = gzip.NewWriter(&f.buf)
 	}
 	s.files.ReplaceOrInsert(f)
-	return f
+	return f, nil
+}
+
+// flushLeastRecentlyWritten flushes the least recently written file, along
+// with the files of older versions of its topic, which must precede it (see
+// flushTopicVersions). Rows of its topic written later go to a new file, the
+// name of which follows the flushed one's, as its fileID is greater.
+func (s *cloudStorageSink) flushLeastRecentlyWritten(ctx context.Context) error {
+	var lru *cloudStorageSinkFile
+	s.files.Ascend(func(i btree.Item) bool {
+		if f := i.(*cloudStorageSinkFile); lru == nil || f.lastWrite < lru.lastWrite {
+			lru = f
+		}
+		return true
+	})
+	if lru == nil {
+		return nil
+	}
+	return s.flushTopicVersions(ctx, lru.topic, lru.schemaID)
 }
 
 // EmitRow implements the Sink interface.
@@ -446,17 +578,48 @@ func (s *cloudStorageSink) EmitRow(
 		return errors.New(`cannot EmitRow on a closed sink`)
 	}
 
-	file := s.getOrCreateFile(topic, mvcc)
-	file.alloc.Merge(&alloc)
+	if s.columnComments != nil {
+		if err := s.writeSchemaSidecar(ctx, topic); err != nil {
+			return err
+		}
+	}
 
-	if _, err := file.Write(value); err != nil {
+	file, err := s.getOrCreateFile(ctx, topic, mvcc)
+	if err != nil {
 		return err
 	}
-	if _, err := file.Write(s.rowDelimiter); err != nil {
-		return err
+	file.alloc.Merge(&alloc)
+
+	if s.orc {
+		if file.orc == nil {
+			desc, ok := topic.(catalog.TableDescriptor)
+			if !ok {
+				return errors.AssertionFailedf(`unexpected topic type %T for %s=%s`,
+					topic, changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+			}
+			file.orc = makeORCWriter(desc, s.orcOpts, &file.buf)
+		}
+		file.rawSize += len(value)
+		file.numMessages++
+		if err := file.orc.addRow(value); err != nil {
+			return err
+		}
+	} else if s.lengthPrefixRecords {
+		s.scratch = appendLengthPrefixed(s.scratch[:0], key)
+		s.scratch = appendLengthPrefixed(s.scratch, value)
+		if _, err := file.Write(s.scratch); err != nil {
+			return err
+		}
+	} else {
+		if _, err := file.Write(value); err != nil {
+			return err
+		}
+		if _, err := file.Write(s.rowDelimiter); err != nil {
+			return err
+		}
 	}
 
-	if int64(file.buf.Len()) > s.targetMaxFileSize {
+	if int64(file.size()) > s.targetMaxFileSize {
 		if err := s.flushTopicVersions(ctx, file.topic, file.schemaID); err != nil {
 			return err
 		}
@@ -464,6 +627,48 @@ func (s *cloudStorageSink) EmitRow(
 	return nil
 }
 
+// writeSchemaSidecar writes the schema sidecar file describing the columns of
+// the version of the table of topic, unless this sink already has. Sidecar
+// files aren't partitioned by date, and are named after the topic and schema
+// ID of the data files they describe, e.g. `foo-1.schema.json`.
+func (s *cloudStorageSink) writeSchemaSidecar(ctx context.Context, topic TopicDescriptor) error {
+	key := cloudStorageSinkKey{topic: topic.GetName(), schemaID: int64(topic.GetVersion())}
+	if _, ok := s.schemaSidecars[key]; ok {
+		return nil
+	}
+	desc, ok := topic.(catalog.TableDescriptor)
+	if !ok {
+		return errors.AssertionFailedf(`unexpected topic type %T for %s`,
+			topic, changefeedbase.OptColumnComments)
+	}
+	comments, err := s.columnComments(ctx, desc)
+	if err != nil {
+		return err
+	}
+	sidecar, err := makeJSONSchemaSidecar(desc, comments)
+	if err != nil {
+		return err
+	}
+	filename := fmt.Sprintf(`%s-%x.schema.json`, key.topic, key.schemaID)
+	if err := cloud.WriteFile(ctx, s.es, filename, bytes.NewReader(sidecar)); err != nil {
+		return err
+	}
+	if s.schemaSidecars == nil {
+		s.schemaSidecars = make(map[cloudStorageSinkKey]struct{})
+	}
+	s.schemaSidecars[key] = struct{}{}
+	return nil
+}
+
+// appendLengthPrefixed appends the 4 byte big-endian length of msg followed by
+// msg to buf.
+func appendLengthPrefixed(buf []byte, msg []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(msg)))
+	buf = append(buf, l[:]...)
+	return append(buf, msg...)
+}
+
 // EmitResolvedTimestamp implements the Sink interface.
 func (s *cloudStorageSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
@@ -486,7 +691,11 @@ func (s *cloudStorageSink) EmitResolvedTimestamp(
 	if log.V(1) {
 		log.Infof(ctx, "writing file %s %s", filename, resolved.AsOfSystemTime())
 	}
-	return cloud.WriteFile(ctx, s.es, filepath.Join(part, filename), bytes.NewReader(payload))
+	metadata := map[string]string{
+		cloudStorageMetadataResolved: resolved.AsOfSystemTime(),
+	}
+	return cloud.WriteFileWithMetadata(
+		ctx, s.es, filepath.Join(part, filename), bytes.NewReader(payload), metadata)
 }
 
 // flushTopicVersions flushes all open files for the provided topic up to and
@@ -508,19 +717,19 @@ func (s *cloudStorageSink) EmitResolvedTimestamp(
 func (s *cloudStorageSink) flushTopicVersions(
 	ctx context.Context, topic string, maxVersionToFlush int64,
 ) (err error) {
-	var toRemoveAlloc [2]int64    // generally avoid allocating
-	toRemove := toRemoveAlloc[:0] // schemaIDs of flushed files
+	var toRemoveAlloc [2]cloudStorageSinkKey // generally avoid allocating
+	toRemove := toRemoveAlloc[:0]            // keys of flushed files
 	gte := cloudStorageSinkKey{topic: topic}
 	lt := cloudStorageSinkKey{topic: topic, schemaID: maxVersionToFlush + 1}
 	s.files.AscendRange(gte, lt, func(i btree.Item) (wantMore bool) {
 		f := i.(*cloudStorageSinkFile)
 		if err = s.flushFile(ctx, f); err == nil {
-			toRemove = append(toRemove, f.schemaID)
+			toRemove = append(toRemove, f.cloudStorageSinkKey)
 		}
 		return err == nil
 	})
-	for _, v := range toRemove {
-		s.files.Delete(cloudStorageSinkKey{topic: topic, schemaID: v})
+	for _, k := range toRemove {
+		s.files.Delete(k)
 	}
 	return err
 }
@@ -547,10 +756,35 @@ func (s *cloudStorageSink) Flush(ctx context.Context) error {
 	// to use for naming files until the next `Flush()`. See comment on cloudStorageSink
 	// for an overview of the naming convention and proof of correctness.
 	s.dataFileTs = cloudStorageFormatTime(s.timestampOracle.inclusiveLowerBoundTS())
+	s.dataFileMinTs = s.timestampOracle.inclusiveLowerBoundTS()
 	s.dataFilePartition = s.timestampOracle.inclusiveLowerBoundTS().GoTime().Format(s.partitionFormat)
 	return nil
 }
 
+// FlushDurable implements the SinkWithDurableAck interface. Flush writes out
+// every buffered file, and files are finalized, so durable, once their
+// writers are closed.
+func (s *cloudStorageSink) FlushDurable(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
+// Keys of the metadata attached to the files written by the sink, on the
+// storage providers which support it (currently GCS), so that pipelines
+// triggered by new files needn't parse their names. Files only become visible,
+// with their metadata, once they are fully written.
+const (
+	// cloudStorageMetadataTable is the topic of the rows of a data file.
+	cloudStorageMetadataTable = `crdb-table`
+	// cloudStorageMetadataRowCount is the number of rows in a data file.
+	cloudStorageMetadataRowCount = `crdb-row-count`
+	// cloudStorageMetadataMinTimestamp is the timestamp a data file is named
+	// after, an inclusive lower bound on the timestamps of its rows.
+	cloudStorageMetadataMinTimestamp = `crdb-min-timestamp`
+	// cloudStorageMetadataResolved is the resolved timestamp of a RESOLVED
+	// file.
+	cloudStorageMetadataResolved = `crdb-resolved`
+)
+
 // file should not be used after flushing.
 func (s *cloudStorageSink) flushFile(ctx context.Context, file *cloudStorageSinkFile) error {
 	defer file.alloc.Release(ctx)
@@ -566,6 +800,9 @@ func (s *cloudStorageSink) flushFile(ctx context.Context, file *cloudStorageSink
 			return err
 		}
 	}
+	if file.orc != nil {
+		file.orc.finish()
+	}
 
 	// We use this monotonically increasing fileID to ensure correct ordering
 	// among files emitted at the same timestamp during the same job session.
@@ -583,7 +820,17 @@ func (s *cloudStorageSink) flushFile(ctx context.Context, file *cloudStorageSink
 	}
 	s.prevFilename = filename
 	compressedBytes := file.buf.Len()
-	if err := cloud.WriteFile(ctx, s.es, filepath.Join(s.dataFilePartition, filename), bytes.NewReader(file.buf.Bytes())); err != nil {
+	metadata := map[string]string{
+		cloudStorageMetadataTable:        file.topic,
+		cloudStorageMetadataRowCount:     strconv.Itoa(file.numMessages),
+		cloudStorageMetadataMinTimestamp: s.dataFileMinTs.AsOfSystemTime(),
+	}
+	path := filepath.Join(s.dataFilePartition, filename)
+	if s.eventTimePartitionFormat != "" {
+		path = filepath.Join(file.topic, file.partition, filename)
+	}
+	if err := cloud.WriteFileWithMetadata(ctx, s.es, path,
+		bytes.NewReader(file.buf.Bytes()), metadata); err != nil {
 		return err
 	}
 	file.recordMetrics(file.numMessages, file.oldestMVCC, file.rawSize, compressedBytes)
@@ -602,9 +849,27 @@ func (s *cloudStorageSink) Dial() error {
 	return nil
 }
 
+// errHealthCheckDone is used to stop the listing performed by CheckHealth
+// after the first entry.
+var errHealthCheckDone = errors.New("health check done")
+
+// CheckHealth implements the SinkWithHealthCheck interface.
+func (s *cloudStorageSink) CheckHealth(ctx context.Context) error {
+	err := s.es.List(ctx, "", "", func(string) error {
+		return errHealthCheckDone
+	})
+	if errors.Is(err, errHealthCheckDone) {
+		return nil
+	}
+	return err
+}
+
 type cloudStorageSinkKey struct {
 	topic    string
 	schemaID int64
+	// partition is the event time partition of the rows of the file, with
+	// the event_time option.
+	partition string
 }
 
 func (k cloudStorageSinkKey) Less(other btree.Item) bool {
@@ -619,10 +884,13 @@ func (k cloudStorageSinkKey) Less(other btree.Item) bool {
 }
 
 func keyLess(a, b cloudStorageSinkKey) bool {
-	if a.topic == b.topic {
+	if a.topic != b.topic {
+		return a.topic < b.topic
+	}
+	if a.schemaID != b.schemaID {
 		return a.schemaID < b.schemaID
 	}
-	return a.topic < b.topic
+	return a.partition < b.partition
 }
 
 // generateChangefeedSessionID generates a unique string that is used to
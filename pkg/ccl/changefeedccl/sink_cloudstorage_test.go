@@ -13,6 +13,7 @@ import (
 	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"net/url"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/cloud"
 	_ "github.com/cockroachdb/cockroach/pkg/cloud/impl" // register cloud storage providers
@@ -32,6 +34,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
@@ -196,6 +199,126 @@ func TestCloudStorageSink(t *testing.T) {
 		require.Equal(t, `{"resolved":"5.0000000000"}`, string(resolvedFile))
 	})
 
+	t.Run(`avro`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		sinkDir := `avro`
+		avroOpts := map[string]string{
+			changefeedbase.OptFormat:   string(changefeedbase.OptFormatAvro),
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+		}
+		s, err := makeCloudStorageSink(
+			ctx, sinkURI(sinkDir, unlimitedFileSize), 1, settings,
+			avroOpts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		// Messages in the confluent wire format: the magic byte, followed by the
+		// schema registry ID, followed by the avro payload.
+		k1 := []byte{changefeedbase.ConfluentAvroWireFormatMagic, 0, 0, 0, 1, 'k', '1'}
+		v1 := []byte{changefeedbase.ConfluentAvroWireFormatMagic, 0, 0, 0, 2, 'v', '1'}
+		k2 := []byte{changefeedbase.ConfluentAvroWireFormatMagic, 0, 0, 0, 1, 'k', '2'}
+		var deleted []byte
+		require.NoError(t, s.EmitRow(ctx, t1, k1, v1, ts(1), ts(1), zeroAlloc))
+		require.NoError(t, s.EmitRow(ctx, t1, k2, deleted, ts(2), ts(2), zeroAlloc))
+		require.NoError(t, s.Flush(ctx))
+
+		files := slurpDir(t, sinkDir)
+		require.Equal(t, 1, len(files))
+		keys, values, err := cdctest.SplitAvroFileRecords([]byte(files[0]))
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{k1, k2}, keys)
+		require.Equal(t, [][]byte{v1, {}}, values)
+
+		id, payload, err := changefeedbase.DecodeConfluentAvroHeader(values[0])
+		require.NoError(t, err)
+		require.Equal(t, int32(2), id)
+		require.Equal(t, []byte(`v1`), payload)
+	})
+
+	t.Run(`column comments`, func(t *testing.T) {
+		desc, err := parseTableDesc(`CREATE TABLE t1 (a INT PRIMARY KEY, b STRING)`)
+		require.NoError(t, err)
+		t1 := tableDescriptorTopic{desc}
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		sinkDir := `column-comments`
+		s, err := makeCloudStorageSink(
+			ctx, sinkURI(sinkDir, unlimitedFileSize), 1, settings,
+			opts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		var fetches int
+		s.(*cloudStorageSink).columnComments = func(
+			context.Context, catalog.TableDescriptor,
+		) (map[descpb.ColumnID]string, error) {
+			fetches++
+			return map[descpb.ColumnID]string{2: `the b column`}, nil
+		}
+
+		// The sidecar is written once for each version of each table.
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v1`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v2`), ts(2), ts(2), zeroAlloc))
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, 1, fetches)
+
+		sidecar, err := ioutil.ReadFile(filepath.Join(dir, sinkDir, `t1-1.schema.json`))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"table": "t1", "version": 1, "columns": [
+			{"name": "a", "type": "INT8", "nullable": false},
+			{"name": "b", "type": "STRING", "nullable": true, "comment": "the b column"}
+		]}`, string(sidecar))
+	})
+
+	t.Run(`metadata`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		_, err = sf.Forward(testSpan, ts(3))
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		sinkDir := `metadata`
+		s, err := makeCloudStorageSink(
+			ctx, sinkURI(sinkDir, unlimitedFileSize), 1, settings,
+			opts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		es := &metadataRecordingStorage{
+			ExternalStorage: s.(*cloudStorageSink).es,
+			metadata:        make(map[string]map[string]string),
+		}
+		s.(*cloudStorageSink).es = es
+
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v1`), ts(4), ts(4), zeroAlloc))
+		require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v2`), ts(4), ts(4), zeroAlloc))
+		require.NoError(t, s.Flush(ctx))
+		require.NoError(t, s.EmitResolvedTimestamp(ctx, e, ts(5)))
+
+		var dataFiles []map[string]string
+		for name, metadata := range es.metadata {
+			if strings.HasSuffix(name, `.RESOLVED`) {
+				require.Equal(t, map[string]string{`crdb-resolved`: `5.0000000000`}, metadata)
+			} else {
+				dataFiles = append(dataFiles, metadata)
+			}
+		}
+		require.Len(t, es.metadata, 2)
+		require.Equal(t, []map[string]string{{
+			`crdb-table`:         `t1`,
+			`crdb-row-count`:     `2`,
+			`crdb-min-timestamp`: `3.0000000001`,
+		}}, dataFiles)
+	})
+
 	forwardFrontier := func(f *span.Frontier, s roachpb.Span, wall int64) bool {
 		forwarded, err := f.Forward(s, ts(wall))
 		require.NoError(t, err)
@@ -595,6 +718,135 @@ func TestCloudStorageSink(t *testing.T) {
 		}
 	})
 
+	t.Run(`event-time`, func(t *testing.T) {
+		t1 := makeTopic(`t1`)
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		eventTimeOpts := map[string]string{changefeedbase.OptEventTime: ``}
+		for k, v := range opts {
+			eventTimeOpts[k] = v
+		}
+
+		before := time.Date(2000, time.January, 1, 23, 59, 59, 999000000, time.UTC)
+		after := time.Date(2000, time.January, 2, 0, 0, 1, 0, time.UTC)
+
+		for _, tc := range []struct {
+			format          string
+			expectedFolders []string
+		}{
+			{"", []string{"t1/dt=2000-01-01", "t1/dt=2000-01-02"}},
+			{"hourly", []string{"t1/dt=2000-01-01/hr=23", "t1/dt=2000-01-02/hr=00"}},
+			{"flat", []string{"t1"}},
+		} {
+			t.Run(tc.format, func(t *testing.T) {
+				dir := `event-time-` + tc.format
+				u := sinkURI(dir, unlimitedFileSize)
+				if tc.format != "" {
+					u.addParam(changefeedbase.SinkParamPartitionFormat, tc.format)
+				}
+				s, err := makeCloudStorageSink(
+					ctx, u, 1, settings, eventTimeOpts, timestampOracle, externalStorageFromURI, user, nil,
+				)
+				require.NoError(t, err)
+				defer func() { require.NoError(t, s.Close()) }()
+
+				// Rows buffered across a day boundary are written to a file in
+				// the partition of each day.
+				require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v1`), ts(before.UnixNano()), ts(before.UnixNano()), zeroAlloc))
+				require.NoError(t, s.EmitRow(ctx, t1, noKey, []byte(`v2`), ts(after.UnixNano()), ts(after.UnixNano()), zeroAlloc))
+				require.NoError(t, s.Flush(ctx))
+				require.ElementsMatch(t, tc.expectedFolders, listLeafDirectories(dir))
+				if tc.format == "flat" {
+					require.Equal(t, []string{"v1\n", "v2\n"}, slurpDir(t, dir))
+				} else {
+					require.Equal(t, []string{"v1\n"}, slurpDir(t, filepath.Join(dir, tc.expectedFolders[0])))
+					require.Equal(t, []string{"v2\n"}, slurpDir(t, filepath.Join(dir, tc.expectedFolders[1])))
+				}
+			})
+		}
+
+		_, err = makeCloudStorageSink(ctx, sinkURI(`event-time-avro`, unlimitedFileSize), 1, settings,
+			map[string]string{
+				changefeedbase.OptFormat:    string(changefeedbase.OptFormatAvro),
+				changefeedbase.OptEnvelope:  string(changefeedbase.OptEnvelopeWrapped),
+				changefeedbase.OptEventTime: ``,
+			}, timestampOracle, externalStorageFromURI, user, nil)
+		require.EqualError(t, err, `event_time is only usable with format=json`)
+	})
+
+	t.Run(`max-open-files`, func(t *testing.T) {
+		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
+		sf, err := span.MakeFrontier(testSpan)
+		require.NoError(t, err)
+		timestampOracle := &changeAggregatorLowerBoundOracle{sf: sf}
+		maxOpenFilesOpts := map[string]string{changefeedbase.OptMaxOpenFiles: `3`}
+		for k, v := range opts {
+			maxOpenFilesOpts[k] = v
+		}
+		dir := `max-open-files`
+		s, err := makeCloudStorageSink(
+			ctx, sinkURI(dir, unlimitedFileSize), 1,
+			settings, maxOpenFilesOpts, timestampOracle, externalStorageFromURI, user, nil,
+		)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		files := s.(*cloudStorageSink).files
+
+		// Interleave the rows of a hot topic, whose file is never the least
+		// recently written, with those of many others, whose files keep being
+		// flushed and reopened.
+		const numTopics, numRounds = 10, 5
+		var topics []tableDescriptorTopic
+		for i := 0; i < numTopics; i++ {
+			topics = append(topics, makeTopic(fmt.Sprintf(`t%d`, i)))
+		}
+		for r := 0; r < numRounds; r++ {
+			for i := 1; i < numTopics; i++ {
+				for _, topic := range []tableDescriptorTopic{topics[0], topics[i]} {
+					value := fmt.Sprintf(`%s-%d`, topic.GetName(), r)
+					require.NoError(t, s.EmitRow(ctx, topic, noKey, []byte(value), ts(1), ts(1), zeroAlloc))
+					require.LessOrEqual(t, files.Len(), 3)
+				}
+			}
+		}
+		require.NoError(t, s.Flush(ctx))
+
+		// Every row is written once, and the rows of each topic are ordered
+		// by the names of the files holding them.
+		rowsByTopic := make(map[string][]string)
+		var hotFiles int
+		for _, file := range slurpDir(t, dir) {
+			if strings.HasPrefix(file, `t0-`) {
+				hotFiles++
+			}
+			for _, row := range strings.Split(strings.TrimSuffix(file, "\n"), "\n") {
+				topic := strings.Split(row, `-`)[0]
+				rowsByTopic[topic] = append(rowsByTopic[topic], row)
+			}
+		}
+		require.Equal(t, 1, hotFiles)
+		for i, topic := range topics {
+			var expected []string
+			for r := 0; r < numRounds; r++ {
+				n := 1
+				if i == 0 {
+					n = numTopics - 1
+				}
+				for j := 0; j < n; j++ {
+					expected = append(expected, fmt.Sprintf(`%s-%d`, topic.GetName(), r))
+				}
+			}
+			require.Equal(t, expected, rowsByTopic[topic.GetName()])
+		}
+
+		_, err = makeCloudStorageSink(ctx, sinkURI(dir, unlimitedFileSize), 1, settings,
+			map[string]string{changefeedbase.OptMaxOpenFiles: `0`}, timestampOracle,
+			externalStorageFromURI, user, nil)
+		require.EqualError(t, err, `max_open_files must be a positive integer: "0"`)
+	})
+
 	t.Run(`file-ordering`, func(t *testing.T) {
 		t1 := makeTopic(`t1`)
 		testSpan := roachpb.Span{Key: []byte("a"), EndKey: []byte("b")}
@@ -705,3 +957,21 @@ func TestCloudStorageSink(t *testing.T) {
 		}, slurpDir(t, dir))
 	})
 }
+
+// metadataRecordingStorage is an ExternalStorage which records the metadata
+// attached to the files written to it.
+type metadataRecordingStorage struct {
+	cloud.ExternalStorage
+	metadata map[string]map[string]string
+}
+
+var _ cloud.ExternalStorageWithMetadata = (*metadataRecordingStorage)(nil)
+
+// WriterWithMetadata implements the cloud.ExternalStorageWithMetadata
+// interface.
+func (s *metadataRecordingStorage) WriterWithMetadata(
+	ctx context.Context, basename string, metadata map[string]string,
+) (io.WriteCloser, error) {
+	s.metadata[basename] = metadata
+	return s.Writer(ctx, basename)
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+// deadLetterSink delegates to the changefeed's sink, and additionally emits
+// the rows which could not be encoded under the changefeed's format to a
+// second, dead letter sink, so that the changefeed can continue past them.
+// Currently only rows which cannot be encoded as avro, or whose column of the
+// topic_from_column option can't name a topic, are dead lettered.
+//
+// Dead lettered rows are emitted as JSON objects holding the row as encoded
+// with the wrapped envelope, under `row`, along with the encoding `error` and
+// its `reason`.
+type deadLetterSink struct {
+	wrapped     Sink
+	deadLetters Sink
+	encoder     *jsonEncoder
+	metrics     *Metrics
+}
+
+var _ Sink = (*deadLetterSink)(nil)
+
+// makeDeadLetterSink returns a deadLetterSink wrapping the sink of the
+// changefeed described by feedCfg, which must have the dead_letter_sink
+// option set.
+func makeDeadLetterSink(
+	ctx context.Context,
+	serverCfg *execinfra.ServerConfig,
+	feedCfg jobspb.ChangefeedDetails,
+	wrapped Sink,
+	timestampOracle timestampLowerBoundOracle,
+	user security.SQLUsername,
+	jobID jobspb.JobID,
+	metrics *Metrics,
+	m *sliMetrics,
+) (*deadLetterSink, error) {
+	deadLetterCfg := feedCfg
+	deadLetterCfg.SinkURI = feedCfg.Opts[changefeedbase.OptDeadLetterSink]
+	deadLetterCfg.Opts = make(map[string]string, len(feedCfg.Opts))
+	for k, v := range feedCfg.Opts {
+		switch k {
+		case changefeedbase.OptDeadLetterSink, changefeedbase.OptConfluentSchemaRegistry,
+			changefeedbase.OptAvroSchemaPrefix, changefeedbase.OptAvroNamespace,
+			changefeedbase.OptAvroRecordName, changefeedbase.OptSubjectPrefix, changefeedbase.OptDiff:
+		default:
+			deadLetterCfg.Opts[k] = v
+		}
+	}
+	deadLetterCfg.Opts[changefeedbase.OptFormat] = string(changefeedbase.OptFormatJSON)
+	deadLetterCfg.Opts[changefeedbase.OptEnvelope] = string(changefeedbase.OptEnvelopeWrapped)
+	deadLetterCfg.Opts[changefeedbase.OptKeyInValue] = ``
+	deadLetterCfg.Opts[changefeedbase.OptUpdatedTimestamps] = ``
+
+	encoder, err := makeJSONEncoder(deadLetterCfg.Opts, deadLetterCfg.Targets)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters, err := getSink(ctx, serverCfg, deadLetterCfg, timestampOracle, user, jobID, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, `creating %s`, changefeedbase.OptDeadLetterSink)
+	}
+	return &deadLetterSink{
+		wrapped:     wrapped,
+		deadLetters: deadLetters,
+		encoder:     encoder,
+		metrics:     metrics,
+	}, nil
+}
+
+// deadLetterReason returns the reason for which a row which failed to be
+// encoded or routed with the given error should be dead lettered, if the
+// error was caused by the row itself.
+func deadLetterReason(err error) (string, bool) {
+	if errors.Is(err, errInvalidTopicColumnValue) {
+		return deadLetterReasonInvalidTopic, true
+	}
+	return avroDeadLetterReason(err)
+}
+
+// emitDeadLetter emits a row which could not be encoded to the dead letter
+// sink.
+func (s *deadLetterSink) emitDeadLetter(
+	ctx context.Context, row encodeRow, reason string, encodeErr error, alloc kvevent.Alloc,
+) error {
+	key, err := s.encoder.EncodeKey(ctx, row)
+	if err != nil {
+		return err
+	}
+	key = append([]byte(nil), key...)
+	value, err := s.encoder.EncodeValue(ctx, row)
+	if err != nil {
+		return err
+	}
+	rowJSON, err := json.ParseJSON(string(value))
+	if err != nil {
+		return err
+	}
+	deadLetter, err := json.MakeJSON(map[string]interface{}{
+		`row`:    rowJSON,
+		`error`:  encodeErr.Error(),
+		`reason`: reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch reason {
+	case deadLetterReasonUnsupportedValue:
+		s.metrics.DeadLetteredUnsupportedValue.Inc(1)
+	case deadLetterReasonSchemaRejected:
+		s.metrics.DeadLetteredSchemaRejected.Inc(1)
+	case deadLetterReasonInvalidTopic:
+		s.metrics.DeadLetteredInvalidTopic.Inc(1)
+	}
+	return s.deadLetters.EmitRow(ctx, tableDescriptorTopic{row.tableDesc},
+		key, []byte(deadLetter.String()), row.updated, row.mvccTimestamp, alloc)
+}
+
+// EmitRow implements the Sink interface.
+func (s *deadLetterSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	return s.wrapped.EmitRow(ctx, topic, key, value, updated, mvcc, alloc)
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *deadLetterSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	return s.wrapped.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+// Flush implements the Sink interface. The dead letter sink is flushed
+// along with the changefeed's sink, so that the changefeed's progress does
+// not advance past rows which have not been durably dead lettered.
+func (s *deadLetterSink) Flush(ctx context.Context) error {
+	if err := s.deadLetters.Flush(ctx); err != nil {
+		return err
+	}
+	return s.wrapped.Flush(ctx)
+}
+
+// FlushDurable implements the SinkWithDurableAck interface.
+func (s *deadLetterSink) FlushDurable(ctx context.Context) error {
+	if err := flushDurable(ctx, s.deadLetters); err != nil {
+		return err
+	}
+	return flushDurable(ctx, s.wrapped)
+}
+
+// Close implements the Sink interface.
+func (s *deadLetterSink) Close() error {
+	return errors.CombineErrors(s.deadLetters.Close(), s.wrapped.Close())
+}
+
+// Dial implements the Sink interface.
+func (s *deadLetterSink) Dial() error {
+	return s.wrapped.Dial()
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil/pgdate"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterReason(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		err    error
+		reason string
+		ok     bool
+	}{
+		{errors.Mark(errors.New(`boom`), errAvroUnsupportedValue), deadLetterReasonUnsupportedValue, true},
+		{errors.Wrap(errors.Mark(errors.New(`boom`), errAvroSchemaRejected), `registering`),
+			deadLetterReasonSchemaRejected, true},
+		{errors.Mark(errors.New(`boom`), errInvalidTopicColumnValue), deadLetterReasonInvalidTopic, true},
+		{errors.New(`boom`), ``, false},
+	} {
+		reason, ok := deadLetterReason(changefeedbase.MarkRetryableError(tc.err))
+		require.Equal(t, tc.ok, ok, tc.err)
+		require.Equal(t, tc.reason, reason, tc.err)
+	}
+}
+
+func TestDeadLetterSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b DATE)`)
+	require.NoError(t, err)
+	targets := jobspb.ChangefeedTargets{
+		tableDesc.GetID(): jobspb.ChangefeedTarget{StatementTimeName: tableDesc.GetName()},
+	}
+	row := encodeRow{
+		datums: rowenc.EncDatumRow{
+			rowenc.EncDatum{Datum: tree.NewDInt(1)},
+			rowenc.EncDatum{Datum: tree.NewDDate(pgdate.PosInfDate)},
+		},
+		updated:       hlc.Timestamp{WallTime: 1},
+		tableDesc:     tableDesc,
+		prevTableDesc: tableDesc,
+	}
+
+	reg := cdctest.StartTestSchemaRegistry()
+	defer reg.Close()
+	avroEncoder, err := getEncoder(map[string]string{
+		changefeedbase.OptFormat:                  string(changefeedbase.OptFormatAvro),
+		changefeedbase.OptConfluentSchemaRegistry: reg.URL(),
+	}, targets)
+	require.NoError(t, err)
+	_, encodeErr := avroEncoder.EncodeValue(ctx, row)
+	require.Regexp(t, `infinite date not yet supported with avro`, encodeErr)
+	reason, ok := avroDeadLetterReason(encodeErr)
+	require.True(t, ok)
+	require.Equal(t, deadLetterReasonUnsupportedValue, reason)
+
+	encoder, err := makeJSONEncoder(map[string]string{
+		changefeedbase.OptEnvelope:          string(changefeedbase.OptEnvelopeWrapped),
+		changefeedbase.OptKeyInValue:        ``,
+		changefeedbase.OptUpdatedTimestamps: ``,
+	}, targets)
+	require.NoError(t, err)
+	wrapped, deadLetters := &replayRecordingSink{}, &replayRecordingSink{}
+	metrics := MakeMetrics(time.Minute).(*Metrics)
+	sink := &deadLetterSink{
+		wrapped:     wrapped,
+		deadLetters: deadLetters,
+		encoder:     encoder,
+		metrics:     metrics,
+	}
+	require.NoError(t, sink.emitDeadLetter(ctx, row, reason, errors.New(`boom`), zeroAlloc))
+	require.NoError(t, sink.Flush(ctx))
+
+	require.Empty(t, wrapped.events)
+	require.Equal(t, []string{
+		`foo: [1]->{"error": "boom", "reason": "unsupported_value", "row": ` +
+			`{"after": {"a": 1, "b": "infinity"}, "key": [1], "updated": "1.0000000000"}}`,
+	}, deadLetters.events)
+	require.Equal(t, 1, deadLetters.flushes)
+	require.Equal(t, 1, wrapped.flushes)
+	require.Equal(t, int64(1), metrics.DeadLetteredUnsupportedValue.Count())
+	require.Equal(t, int64(0), metrics.DeadLetteredSchemaRejected.Count())
+}
@@ -0,0 +1,419 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The file sink appends rows and resolved timestamps to a file in the
+// external IO directory of each node running the changefeed, e.g.
+// file:///cdc/changes.ndjson, so that a consumer on the node can follow it as
+// tail -F does. Unlike the cloud storage sinks, which write a new object for
+// each flush, it keeps appending to the same path.
+//
+// Each row is written as its encoded value, and each resolved timestamp as
+// its encoded payload, e.g. {"resolved":"1641092645000000000.0000000000"},
+// followed by a newline. Since JSON values never hold a raw newline, every
+// line is a message. With the wrapped envelope, rows hold an after field
+// and their key, which the key_in_value option is set for so that deletes
+// tell which row was deleted, while resolved timestamps hold a resolved
+// field. With the row envelope, deletes must be written with
+// delete_format=op, which holds their key.
+//
+// Rows are buffered in memory, their allocations held against the memory
+// budget of the changefeed, and appended, then synced, by Flush, so that
+// a consumer reading the file after a crash sees a prefix of the messages,
+// as a crash can only leave the last line incomplete, which is truncated
+// when the file is opened again. Once appending would make the file larger
+// than the file_size parameter, 64MiB by default, it's rotated: it is
+// renamed with a suffix numbering the rotated files from 1, e.g.
+// changes.ndjson.1, and a new file is started.
+//
+// The change aggregators running on a node, and the change frontier if it
+// runs there, share the file, each appending whole lines. The rows emitted
+// by the aggregators of other nodes are written to the files of those nodes,
+// and the resolved timestamps only to the file of the node running the
+// change frontier.
+
+const fileSinkDefaultMaxFileSize = 64 << 20 // 64MiB
+
+func isFileSink(u *url.URL) bool {
+	return u.Scheme == changefeedbase.SinkSchemeFile
+}
+
+// fileSink emits to a sinkFile.
+type fileSink struct {
+	path        string
+	maxFileSize int64
+
+	file *sinkFile
+	// pending holds the lines emitted since the last flush, and alloc the
+	// memory of their rows, released once they're appended.
+	pending bytes.Buffer
+	alloc   kvevent.Alloc
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*fileSink)(nil)
+
+func makeFileSink(
+	u sinkURL, opts map[string]string, externalIODir string, m *sliMetrics,
+) (Sink, error) {
+	if changefeedbase.FormatType(opts[changefeedbase.OptFormat]) != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`file sink requires %s=%s`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+	}
+	envelope := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope])
+	switch envelope {
+	case changefeedbase.OptEnvelopeWrapped, changefeedbase.OptEnvelopeRow:
+	default:
+		return nil, errors.Errorf(`%s=%s is not supported by file sinks`,
+			changefeedbase.OptEnvelope, envelope)
+	}
+	// Each line must tell which row was deleted, which tombstones and null
+	// values don't, nor the after field of the row envelope.
+	deleteFormat := changefeedbase.DeleteFormat(opts[changefeedbase.OptDeleteFormat])
+	switch {
+	case deleteFormat == changefeedbase.OptDeleteFormatTombstone,
+		deleteFormat == changefeedbase.OptDeleteFormatNull:
+		return nil, errors.Errorf(`%s=%s is not supported by file sinks, as deletes would not have their key`,
+			changefeedbase.OptDeleteFormat, deleteFormat)
+	case envelope == changefeedbase.OptEnvelopeRow && deleteFormat != changefeedbase.OptDeleteFormatOp:
+		return nil, errors.Errorf(`file sink requires %s=%s with %s=%s, so that deletes have their key`,
+			changefeedbase.OptDeleteFormat, changefeedbase.OptDeleteFormatOp,
+			changefeedbase.OptEnvelope, changefeedbase.OptEnvelopeRow)
+	}
+
+	if u.Host != `` {
+		return nil, errors.Errorf(`file sink URL must not have a host, found %q`, u.Host)
+	}
+	if u.Path == `` || strings.HasSuffix(u.Path, `/`) {
+		return nil, errors.Errorf(`path of the file must be specified for file sink`)
+	}
+	path, err := fileSinkPath(externalIODir, u.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{path: path, maxFileSize: fileSinkDefaultMaxFileSize, metrics: m}
+	if fileSize := u.consumeParam(changefeedbase.SinkParamFileSize); fileSize != `` {
+		if s.maxFileSize, err = humanizeutil.ParseBytes(fileSize); err != nil {
+			return nil, pgerror.Wrapf(err, pgcode.Syntax, `parsing %s`, fileSize)
+		}
+		if s.maxFileSize <= 0 {
+			return nil, errors.Errorf(`%s must be positive`, changefeedbase.SinkParamFileSize)
+		}
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown file sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	return s, nil
+}
+
+// fileSinkPath returns the path of the file of the file sink URL path p,
+// which is relative to the external IO directory of the node, as are the
+// paths of nodelocal URLs.
+func fileSinkPath(externalIODir, p string) (string, error) {
+	if externalIODir == `` {
+		return ``, errors.Errorf(`local file access is disabled`)
+	}
+	dir, err := filepath.Abs(externalIODir)
+	if err != nil {
+		return ``, err
+	}
+	path := filepath.Join(dir, p)
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return ``, errors.Errorf(
+			`local file access to paths outside of external-io-dir is not allowed: %s`, p)
+	}
+	return path, nil
+}
+
+// Dial implements the Sink interface.
+func (s *fileSink) Dial() error {
+	file, err := openSinkFile(s.path, s.maxFileSize)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *fileSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(value)+1, sinkDoesNotCompress)
+
+	if s.file == nil {
+		alloc.Release(ctx)
+		return errors.New(`file sink is not open`)
+	}
+	s.alloc.Merge(&alloc)
+	s.pending.Write(value)
+	s.pending.WriteByte('\n')
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *fileSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	if s.file == nil {
+		return errors.New(`file sink is not open`)
+	}
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, ``, resolved)
+	if err != nil {
+		return err
+	}
+	// The resolved timestamp is appended after any rows of the sink not yet
+	// flushed, which it covers.
+	s.pending.Write(payload)
+	s.pending.WriteByte('\n')
+	return s.flush(ctx)
+}
+
+// Flush implements the Sink interface.
+func (s *fileSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	if s.file == nil {
+		return errors.New(`file sink is not open`)
+	}
+	return s.flush(ctx)
+}
+
+func (s *fileSink) flush(ctx context.Context) error {
+	if s.pending.Len() == 0 {
+		return nil
+	}
+	if err := s.file.append(s.pending.Bytes()); err != nil {
+		return err
+	}
+	s.pending.Reset()
+	s.alloc.Release(ctx)
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.pending.Reset()
+	s.alloc.Release(context.Background())
+	return err
+}
+
+// sinkFile is a file appended to by file sinks. The sinks of the change
+// aggregators and the change frontier running on a node share the sinkFile
+// of their path, which is closed once all of them have closed it.
+type sinkFile struct {
+	path        string
+	maxFileSize int64
+	// refs is the number of opens not yet closed, guarded by the mutex of
+	// sinkFiles.
+	refs int
+
+	mu struct {
+		syncutil.Mutex
+		f    *os.File
+		size int64
+		// rotated is the suffix of the last rotated file.
+		rotated int
+	}
+}
+
+// sinkFiles holds the open files of the process by path.
+var sinkFiles struct {
+	syncutil.Mutex
+	files map[string]*sinkFile
+}
+
+// openSinkFile opens the file of a file sink at path, creating it and its
+// directory if they don't exist. If a file is already open at path, it is
+// shared, along with its maximum size.
+func openSinkFile(path string, maxFileSize int64) (*sinkFile, error) {
+	sinkFiles.Lock()
+	defer sinkFiles.Unlock()
+	if file, ok := sinkFiles.files[path]; ok {
+		file.refs++
+		return file, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrapf(err, `creating the directory of %s`, path)
+	}
+	rotated, err := lastRotatedSinkFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &sinkFile{path: path, maxFileSize: maxFileSize, refs: 1}
+	file.mu.rotated = rotated
+	if err := file.openLocked(); err != nil {
+		return nil, err
+	}
+	if sinkFiles.files == nil {
+		sinkFiles.files = make(map[string]*sinkFile)
+	}
+	sinkFiles.files[path] = file
+	return file, nil
+}
+
+// lastRotatedSinkFile returns the suffix of the last rotated file of path, or
+// 0 if there is none.
+func lastRotatedSinkFile(path string) (int, error) {
+	matches, err := filepath.Glob(path + `.*`)
+	if err != nil {
+		return 0, err
+	}
+	var last int
+	for _, m := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(m, path+`.`)); err == nil && n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+// openLocked opens the file at the path of f for appending, truncating the
+// incomplete line a crash may have left at its end.
+func (f *sinkFile) openLocked() error {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, `opening %s`, f.path)
+	}
+	size, err := completeLinesSize(file)
+	if err == nil {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, `truncating the incomplete line of %s`, f.path)
+	}
+	f.mu.f, f.mu.size = file, size
+	return nil
+}
+
+// completeLinesSize returns the size of the complete lines at the start of
+// file, up to its last newline.
+func completeLinesSize(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	const chunkSize = 4096
+	buf := make([]byte, chunkSize)
+	for end := info.Size(); end > 0; {
+		start := end - chunkSize
+		if start < 0 {
+			start = 0
+		}
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// append appends lines, which end with a newline, to the file, and syncs it,
+// rotating the file first if it would grow past its maximum size.
+func (f *sinkFile) append(lines []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mu.f == nil {
+		return errors.Errorf(`%s is closed`, f.path)
+	}
+	if f.mu.size > 0 && f.mu.size+int64(len(lines)) > f.maxFileSize {
+		if err := f.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := f.mu.f.Write(lines)
+	if err == nil {
+		err = f.mu.f.Sync()
+	}
+	if err != nil {
+		// Drop what was written of the lines, which are emitted again as
+		// the changefeed retries.
+		if n > 0 {
+			_ = f.mu.f.Truncate(f.mu.size)
+		}
+		return errors.Wrapf(err, `appending to %s`, f.path)
+	}
+	f.mu.size += int64(n)
+	return nil
+}
+
+// rotateLocked renames the file with the next rotated suffix and opens a new
+// one at its path.
+func (f *sinkFile) rotateLocked() error {
+	if err := f.mu.f.Close(); err != nil {
+		return errors.Wrapf(err, `closing %s`, f.path)
+	}
+	f.mu.f = nil
+	rotated := fmt.Sprintf(`%s.%d`, f.path, f.mu.rotated+1)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return errors.Wrapf(err, `rotating %s`, f.path)
+	}
+	f.mu.rotated++
+	return f.openLocked()
+}
+
+// Close closes the file, once all its opens have closed it.
+func (f *sinkFile) Close() error {
+	sinkFiles.Lock()
+	defer sinkFiles.Unlock()
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+	delete(sinkFiles.files, f.path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mu.f == nil {
+		return nil
+	}
+	err := f.mu.f.Close()
+	f.mu.f = nil
+	return err
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+
+	foo := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: `foo`, ID: 52}).BuildImmutableTable()}
+	jsonOpts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	makeSink := func(t *testing.T, uri string) Sink {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeFileSink(sinkURL{URL: u}, jsonOpts, dir, nil)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+	readFile := func(t *testing.T, name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(b)
+	}
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+	resolved := func(t *testing.T, i int64) string {
+		payload, err := (&jsonEncoder{}).EncodeResolvedTimestamp(ctx, ``, ts(i))
+		require.NoError(t, err)
+		return string(payload) + "\n"
+	}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := makeSink(t, `file:///cdc/changes.ndjson`)
+		// Two sinks share the file, as on a node running several aggregators.
+		other := makeSink(t, `file:///cdc/changes.ndjson`)
+
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"after": {"a": 1}}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, other.EmitRow(ctx, foo, []byte(`[2]`), []byte(`{"after": {"a": 2}}`), ts(1), ts(1), zeroAlloc))
+		// Rows aren't written until they're flushed.
+		require.Equal(t, ``, readFile(t, `cdc/changes.ndjson`))
+		require.NoError(t, other.Flush(ctx))
+		require.NoError(t, sink.Flush(ctx))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts(2)))
+		require.NoError(t, other.Close())
+		require.NoError(t, sink.Close())
+
+		require.Equal(t, `{"after": {"a": 2}}`+"\n"+`{"after": {"a": 1}}`+"\n"+resolved(t, 2),
+			readFile(t, `cdc/changes.ndjson`))
+	})
+
+	t.Run(`memory`, func(t *testing.T) {
+		var pool testAllocPool
+		sink := makeSink(t, `file:///memory.ndjson`)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"after": {"a": 1}}`), ts(1), ts(1), pool.alloc()))
+		}
+		// The memory of the rows is held until they're written.
+		require.EqualValues(t, 3, pool.used())
+		require.NoError(t, sink.Flush(ctx))
+		require.EqualValues(t, 0, pool.used())
+
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"after": {"a": 1}}`), ts(1), ts(1), pool.alloc()))
+		require.NoError(t, sink.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run(`truncate incomplete line`, func(t *testing.T) {
+		path := filepath.Join(dir, `crash.ndjson`)
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"after": {"a": 1}}`+"\n"+`{"after": {"a"`), 0644))
+		sink := makeSink(t, `file:///crash.ndjson`)
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[2]`), []byte(`{"after": {"a": 2}}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, sink.Flush(ctx))
+		require.NoError(t, sink.Close())
+		require.Equal(t, `{"after": {"a": 1}}`+"\n"+`{"after": {"a": 2}}`+"\n", readFile(t, `crash.ndjson`))
+	})
+
+	t.Run(`rotate`, func(t *testing.T) {
+		// Each row is 20 bytes with its newline, so two fit in a file.
+		sink := makeSink(t, `file:///rotate.ndjson?file_size=40B`)
+		for i := 1; i <= 5; i++ {
+			value := []byte(`{"after": {"a": ` + string(rune('0'+i)) + `}}`)
+			require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), value, ts(1), ts(1), zeroAlloc))
+			require.NoError(t, sink.Flush(ctx))
+		}
+		require.NoError(t, sink.Close())
+		require.Equal(t, `{"after": {"a": 1}}`+"\n"+`{"after": {"a": 2}}`+"\n", readFile(t, `rotate.ndjson.1`))
+		require.Equal(t, `{"after": {"a": 3}}`+"\n"+`{"after": {"a": 4}}`+"\n", readFile(t, `rotate.ndjson.2`))
+		require.Equal(t, `{"after": {"a": 5}}`+"\n", readFile(t, `rotate.ndjson`))
+
+		// Rotation continues from the last rotated file.
+		sink = makeSink(t, `file:///rotate.ndjson?file_size=40B`)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"after": {"a": 6}}`), ts(1), ts(1), zeroAlloc))
+			require.NoError(t, sink.Flush(ctx))
+		}
+		require.NoError(t, sink.Close())
+		require.Equal(t, `{"after": {"a": 5}}`+"\n"+`{"after": {"a": 6}}`+"\n", readFile(t, `rotate.ndjson.3`))
+		_, err := os.Stat(filepath.Join(dir, `rotate.ndjson.4`))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run(`invalid`, func(t *testing.T) {
+		for uri, expected := range map[string]string{
+			`file:///../escape.ndjson`:               `local file access to paths outside of external-io-dir is not allowed`,
+			`file://host/changes.ndjson`:             `file sink URL must not have a host`,
+			`file:///cdc/`:                           `path of the file must be specified for file sink`,
+			`file:///changes.ndjson?file_size=big`:   `parsing big`,
+			`file:///changes.ndjson?topic_prefix=a_`: `unknown file sink query parameters: topic_prefix`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeFileSink(sinkURL{URL: u}, jsonOpts, dir, nil)
+			require.Regexp(t, expected, err, uri)
+		}
+
+		u, err := url.Parse(`file:///changes.ndjson`)
+		require.NoError(t, err)
+		_, err = makeFileSink(sinkURL{URL: u}, jsonOpts, ``, nil)
+		require.Regexp(t, `local file access is disabled`, err)
+
+		for opts, expected := range map[[3]string]string{
+			{`avro`, `wrapped`, ``}:          `file sink requires format=json`,
+			{`json`, `key_only`, ``}:         `envelope=key_only is not supported by file sinks`,
+			{`json`, `row`, ``}:              `file sink requires delete_format=op with envelope=row`,
+			{`json`, `row`, `null`}:          `delete_format=null is not supported by file sinks`,
+			{`json`, `wrapped`, `tombstone`}: `delete_format=tombstone is not supported by file sinks`,
+		} {
+			_, err := makeFileSink(sinkURL{URL: u}, map[string]string{
+				changefeedbase.OptFormat:       opts[0],
+				changefeedbase.OptEnvelope:     opts[1],
+				changefeedbase.OptDeleteFormat: opts[2],
+			}, dir, nil)
+			require.Regexp(t, expected, err)
+		}
+	})
+}
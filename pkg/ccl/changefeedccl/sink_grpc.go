@@ -0,0 +1,276 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/sinkpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcDialTimeout bounds the time spent establishing a connection.
+const grpcDialTimeout = 10 * time.Second
+
+func isGRPCSink(u *url.URL) bool {
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeGRPC, changefeedbase.SinkSchemeGRPCTLS:
+		return true
+	default:
+		return false
+	}
+}
+
+// grpcSink emits to a user service implementing the sinkpb.ChangefeedSink
+// service. Rows and resolved timestamps are sent as messages on a single
+// Emit stream and Flush waits for the server to acknowledge every message
+// sent before it.
+type grpcSink struct {
+	addr      string
+	tlsConfig credentials.TransportCredentials
+	md        metadata.MD
+
+	conn   *grpc.ClientConn
+	stream sinkpb.ChangefeedSink_EmitClient
+	cancel context.CancelFunc
+	// sent is the sequence number of the last message sent on the stream.
+	sent uint64
+
+	mu struct {
+		syncutil.Mutex
+		// acked is the highest sequence number acknowledged by the server.
+		acked uint64
+		// err is set once the stream has failed.
+		err error
+		// ackCh is closed and replaced whenever acked or err change.
+		ackCh chan struct{}
+	}
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*grpcSink)(nil)
+
+func makeGRPCSink(u sinkURL, opts map[string]string, m *sliMetrics) (Sink, error) {
+	host := u.Hostname()
+	if host == `` || u.Port() == `` {
+		return nil, errors.Errorf(`host and port must be specified for grpc sink`)
+	}
+
+	sink := &grpcSink{
+		addr:    u.Host,
+		metrics: m,
+	}
+
+	var tlsSkipVerify bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamSkipTLSVerify, &tlsSkipVerify); err != nil {
+		return nil, err
+	}
+	var caCert []byte
+	if err := u.decodeBase64(changefeedbase.SinkParamCACert, &caCert); err != nil {
+		return nil, err
+	}
+	if u.Scheme == changefeedbase.SinkSchemeGRPCTLS {
+		tlsConfig, err := makeTLSConfig(host, caCert, tlsSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		sink.tlsConfig = credentials.NewTLS(tlsConfig)
+	} else if tlsSkipVerify || caCert != nil {
+		return nil, errors.Errorf(`%s and %s require the %s scheme`,
+			changefeedbase.SinkParamSkipTLSVerify, changefeedbase.SinkParamCACert,
+			changefeedbase.SinkSchemeGRPCTLS)
+	}
+
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown grpc sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	if md, ok := opts[changefeedbase.OptGRPCMetadata]; ok {
+		var err error
+		if sink.md, err = parseGRPCMetadata(md); err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
+
+// parseGRPCMetadata parses the value of the grpc_metadata option, a JSON
+// object of string values.
+func parseGRPCMetadata(s string) (metadata.MD, error) {
+	var kvs map[string]string
+	if err := gojson.Unmarshal([]byte(s), &kvs); err != nil {
+		return nil, errors.Wrapf(err, `%s must be a JSON object of strings`,
+			changefeedbase.OptGRPCMetadata)
+	}
+	md := metadata.MD{}
+	for k, v := range kvs {
+		md.Append(k, v)
+	}
+	return md, nil
+}
+
+// redactGRPCMetadata redacts the grpc_metadata option, which usually holds
+// credentials.
+func redactGRPCMetadata(_ string) string {
+	return "redacted"
+}
+
+// Dial implements the Sink interface.
+func (s *grpcSink) Dial() error {
+	dialOpts := []grpc.DialOption{grpc.WithBlock()}
+	if s.tlsConfig != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(s.tlsConfig))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), grpcDialTimeout)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, s.addr, dialOpts...)
+	if err != nil {
+		return errors.Wrapf(err, `connecting to grpc sink at %s`, s.addr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if len(s.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, s.md)
+	}
+	stream, err := sinkpb.NewChangefeedSinkClient(conn).Emit(ctx)
+	if err != nil {
+		cancel()
+		_ = conn.Close()
+		return errors.Wrapf(err, `opening stream to grpc sink at %s`, s.addr)
+	}
+	s.conn, s.stream, s.cancel = conn, stream, cancel
+	s.mu.ackCh = make(chan struct{})
+	go s.receiveAcks(stream)
+	return nil
+}
+
+// receiveAcks records the acknowledgements received from the server until
+// the stream fails or is closed.
+func (s *grpcSink) receiveAcks(stream sinkpb.ChangefeedSink_EmitClient) {
+	for {
+		ack, err := stream.Recv()
+		s.mu.Lock()
+		if err != nil {
+			s.mu.err = errors.Wrap(err, `grpc sink stream failed`)
+		} else if ack.Sequence > s.mu.acked {
+			s.mu.acked = ack.Sequence
+		}
+		close(s.mu.ackCh)
+		s.mu.ackCh = make(chan struct{})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *grpcSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer alloc.Release(ctx)
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	return s.send(ctx, &sinkpb.Message{Row: &sinkpb.Row{
+		Topic:         topicDescr.GetName(),
+		Key:           key,
+		Value:         value,
+		Updated:       updated,
+		MVCCTimestamp: mvcc,
+	}})
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *grpcSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, &sinkpb.Message{Resolved: &sinkpb.Resolved{
+		Payload:  payload,
+		Resolved: resolved,
+	}})
+}
+
+func (s *grpcSink) send(ctx context.Context, msg *sinkpb.Message) error {
+	if s.stream == nil {
+		return errors.New(`grpc sink is not connected`)
+	}
+	s.sent++
+	msg.Sequence = s.sent
+	if err := s.stream.Send(msg); err != nil {
+		// The stream has failed; Recv returns the status of the RPC, which
+		// describes the failure better than the error returned by Send.
+		return s.waitForAcks(ctx, s.sent)
+	}
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *grpcSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.waitForAcks(ctx, s.sent)
+}
+
+// waitForAcks waits until the server has acknowledged every message up to and
+// including seq, returning an error if the stream fails first.
+func (s *grpcSink) waitForAcks(ctx context.Context, seq uint64) error {
+	for {
+		s.mu.Lock()
+		acked, err, ackCh := s.mu.acked, s.mu.err, s.mu.ackCh
+		s.mu.Unlock()
+		if acked >= seq {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ackCh:
+		}
+	}
+}
+
+// Close implements the Sink interface.
+func (s *grpcSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	_ = s.stream.CloseSend()
+	s.cancel()
+	err := s.conn.Close()
+	s.conn, s.stream = nil, nil
+	return err
+}
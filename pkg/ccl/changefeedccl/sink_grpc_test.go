@@ -0,0 +1,147 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/sinkpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeGRPCSinkServer records the messages it receives and acknowledges each
+// of them. A row for the topic `fail` fails the stream.
+type fakeGRPCSinkServer struct {
+	mu struct {
+		syncutil.Mutex
+		messages      []sinkpb.Message
+		authorization []string
+	}
+}
+
+var _ sinkpb.ChangefeedSinkServer = (*fakeGRPCSinkServer)(nil)
+
+// Emit implements the sinkpb.ChangefeedSinkServer interface.
+func (s *fakeGRPCSinkServer) Emit(stream sinkpb.ChangefeedSink_EmitServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	s.mu.Lock()
+	s.mu.authorization = md.Get(`authorization`)
+	s.mu.Unlock()
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if msg.Row != nil && msg.Row.Topic == `fail` {
+			return errors.New(`cannot accept row`)
+		}
+		s.mu.Lock()
+		s.mu.messages = append(s.mu.messages, *msg)
+		s.mu.Unlock()
+		if err := stream.Send(&sinkpb.Ack{Sequence: msg.Sequence}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestGRPCSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	require.NoError(t, err)
+	server := &fakeGRPCSinkServer{}
+	grpcServer := grpc.NewServer()
+	sinkpb.RegisterChangefeedSinkServer(grpcServer, server)
+	go func() { _ = grpcServer.Serve(ln) }()
+	defer grpcServer.Stop()
+
+	makeTopic := func(name string) tableDescriptorTopic {
+		return tableDescriptorTopic{
+			tabledesc.NewBuilder(&descpb.TableDescriptor{Name: name, ID: 52}).BuildImmutableTable()}
+	}
+
+	makeSink := func(t *testing.T, uri string, opts map[string]string) Sink {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeGRPCSink(sinkURL{URL: u}, opts, nil)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := makeSink(t, fmt.Sprintf(`grpc://%s`, ln.Addr()), map[string]string{
+			changefeedbase.OptGRPCMetadata: `{"authorization": "Bearer secret"}`,
+		})
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		ts := hlc.Timestamp{WallTime: 1}
+		require.NoError(t, sink.EmitRow(ctx, makeTopic(`foo`), []byte(`[1]`), []byte(`{"a":1}`), ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts))
+		require.NoError(t, sink.Flush(ctx))
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		require.Equal(t, []string{`Bearer secret`}, server.mu.authorization)
+		require.Equal(t, []sinkpb.Message{{
+			Sequence: 1,
+			Row: &sinkpb.Row{
+				Topic: `foo`, Key: []byte(`[1]`), Value: []byte(`{"a":1}`), Updated: ts, MVCCTimestamp: ts,
+			},
+		}, {
+			Sequence: 2,
+			Resolved: &sinkpb.Resolved{Payload: []byte(`{"__crdb__":{"resolved":"1.0000000000"}}`), Resolved: ts},
+		}}, server.mu.messages)
+		server.mu.messages = nil
+	})
+
+	t.Run(`stream failure`, func(t *testing.T) {
+		sink := makeSink(t, fmt.Sprintf(`grpc://%s`, ln.Addr()), nil)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, makeTopic(`fail`), []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.Regexp(t, `cannot accept row`, sink.Flush(ctx))
+	})
+
+	t.Run(`invalid params`, func(t *testing.T) {
+		for uri, expectedErr := range map[string]string{
+			`grpc://localhost`: `host and port must be specified for grpc sink`,
+			`grpc://localhost:1234?insecure_tls_skip_verify=true`: `insecure_tls_skip_verify and ca_cert require the grpcs scheme`,
+			`grpc://localhost:1234?foo=bar`:                       `unknown grpc sink query parameters: foo`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeGRPCSink(sinkURL{URL: u}, nil, nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+
+		u, err := url.Parse(`grpc://localhost:1234`)
+		require.NoError(t, err)
+		_, err = makeGRPCSink(sinkURL{URL: u}, map[string]string{
+			changefeedbase.OptGRPCMetadata: `{"authorization": 1}`,
+		}, nil)
+		require.Regexp(t, `grpc_metadata must be a JSON object of strings`, err)
+	})
+}
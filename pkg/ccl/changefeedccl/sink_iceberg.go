@@ -0,0 +1,1111 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	gojson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc/valueside"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/linkedin/goavro/v2"
+)
+
+// The iceberg sink writes rows into Apache Iceberg tables, committing them
+// through a catalog which implements the Iceberg REST catalog API, e.g.
+//
+//   iceberg://catalog.example.com:8181/analytics?storage=s3%3A%2F%2Fbucket%2Fwarehouse%3FAUTH%3Dimplicit
+//
+// writes the rows of each target table into the Iceberg table of the same
+// name in the `analytics` namespace. The storage parameter is the escaped
+// external storage URI of the warehouse, which the location of every table
+// must be within. The optional bearer_token parameter authenticates to the
+// catalog, warehouse selects one of the warehouses of the catalog, and
+// tls_enabled=false connects to the catalog over plain HTTP. Other kinds of
+// catalogs, such as AWS Glue, aren't supported.
+//
+// The sink requires format=orc. It buffers the latest version of each row
+// and, on each flush, commits a snapshot to every table with buffered rows
+// which adds
+//
+//   - an ORC data file holding the rows which were inserted or updated, and
+//   - an ORC equality delete file holding the primary keys of all the rows,
+//     including the deleted ones.
+//
+// Equality deletes only apply to data files with a lower sequence number, so
+// the snapshot replaces the previous versions of its rows with the new ones.
+// This also makes commits idempotent: rows which are emitted again after a
+// restart replace the versions committed before it. Position deletes aren't
+// written, as the sink doesn't know which files hold the previous versions of
+// rows.
+//
+// Changefeeds flush their sinks before checkpointing a resolved timestamp, so
+// every row below a checkpoint has been committed when the checkpoint is
+// written. Each table is committed separately, so readers of several tables
+// may see a resolved timestamp's rows of one table before those of another.
+//
+// Only unpartitioned tables of format version 2 are supported. Each column
+// written by the changefeed must have a field of the same name and of the
+// corresponding type in the Iceberg table (see icebergTypeForType); this is
+// checked when the changefeed is created and whenever a table's schema
+// changes.
+
+// icebergMaxCommitAttempts bounds the number of times a snapshot is committed
+// when the commit conflicts with another writer of the table.
+const icebergMaxCommitAttempts = 10
+
+// icebergRequestTimeout bounds the requests made to the catalog.
+const icebergRequestTimeout = time.Minute
+
+// errIcebergCommitConflict is returned when a commit fails because the table
+// was changed since it was loaded.
+var errIcebergCommitConflict = errors.New(`iceberg table was modified concurrently`)
+
+func isIcebergSink(u *url.URL) bool {
+	return u.Scheme == changefeedbase.SinkSchemeIceberg
+}
+
+// icebergTypeForType returns the Iceberg type of the fields which columns of
+// the given SQL type are written to. Types without an Iceberg counterpart,
+// including DECIMAL, whose values may not fit a fixed precision and scale,
+// are written as strings.
+func icebergTypeForType(typ *types.T) string {
+	switch typ.Family() {
+	case types.BoolFamily:
+		return `boolean`
+	case types.IntFamily:
+		if typ.Width() == 64 {
+			return `long`
+		}
+		return `int`
+	case types.FloatFamily:
+		if typ.Width() == 32 {
+			return `float`
+		}
+		return `double`
+	case types.BytesFamily:
+		return `binary`
+	case types.DateFamily:
+		return `date`
+	case types.TimestampFamily:
+		return `timestamp`
+	case types.TimestampTZFamily:
+		return `timestamptz`
+	default:
+		return `string`
+	}
+}
+
+// icebergORCKind returns the ORC type which Iceberg readers expect for
+// columns of the given SQL type.
+func icebergORCKind(typ *types.T) orcTypeKind {
+	switch icebergTypeForType(typ) {
+	case `int`:
+		return orcInt
+	case `timestamptz`:
+		return orcTimestampInstant
+	default:
+		return orcKindForType(typ)
+	}
+}
+
+// icebergField is a field of an Iceberg schema. Its type is either the name
+// of a primitive type or a nested type, which the sink doesn't write.
+type icebergField struct {
+	ID       int               `json:"id"`
+	Name     string            `json:"name"`
+	Required bool              `json:"required"`
+	Type     gojson.RawMessage `json:"type"`
+}
+
+func (f icebergField) primitiveType() string {
+	var typ string
+	if err := gojson.Unmarshal(f.Type, &typ); err != nil {
+		return string(f.Type)
+	}
+	return typ
+}
+
+type icebergSchema struct {
+	Type     string         `json:"type"`
+	SchemaID int            `json:"schema-id"`
+	Fields   []icebergField `json:"fields"`
+}
+
+type icebergSnapshot struct {
+	SnapshotID     int64  `json:"snapshot-id"`
+	SequenceNumber int64  `json:"sequence-number"`
+	ManifestList   string `json:"manifest-list"`
+}
+
+// icebergTableMetadata is the subset of the metadata of an Iceberg table used
+// by the sink.
+type icebergTableMetadata struct {
+	FormatVersion      int             `json:"format-version"`
+	TableUUID          string          `json:"table-uuid"`
+	Location           string          `json:"location"`
+	LastSequenceNumber int64           `json:"last-sequence-number"`
+	CurrentSchemaID    int             `json:"current-schema-id"`
+	Schemas            []icebergSchema `json:"schemas"`
+	DefaultSpecID      int             `json:"default-spec-id"`
+	PartitionSpecs     []struct {
+		SpecID int                 `json:"spec-id"`
+		Fields []gojson.RawMessage `json:"fields"`
+	} `json:"partition-specs"`
+	CurrentSnapshotID *int64            `json:"current-snapshot-id"`
+	Snapshots         []icebergSnapshot `json:"snapshots"`
+	Refs              map[string]struct {
+		SnapshotID int64 `json:"snapshot-id"`
+	} `json:"refs"`
+}
+
+func (m *icebergTableMetadata) currentSchema() (icebergSchema, bool) {
+	for _, s := range m.Schemas {
+		if s.SchemaID == m.CurrentSchemaID {
+			return s, true
+		}
+	}
+	return icebergSchema{}, false
+}
+
+// mainSnapshot returns the snapshot of the main branch of the table, or nil if
+// the table has no snapshots yet.
+func (m *icebergTableMetadata) mainSnapshot() *icebergSnapshot {
+	id := int64(-1)
+	if ref, ok := m.Refs[`main`]; ok {
+		id = ref.SnapshotID
+	} else if m.CurrentSnapshotID != nil {
+		id = *m.CurrentSnapshotID
+	}
+	for i := range m.Snapshots {
+		if m.Snapshots[i].SnapshotID == id {
+			return &m.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// icebergCatalog is a client of an Iceberg REST catalog.
+type icebergCatalog struct {
+	client *httputil.Client
+	// baseURL is the URL of the catalog's API, e.g. https://host:8181/v1.
+	baseURL   string
+	token     string
+	warehouse string
+	// prefix is the path prefix of the catalog's API, returned by its
+	// configuration endpoint.
+	prefix string
+}
+
+// icebergCatalogError is the error response of the catalog.
+type icebergCatalogError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
+
+// do sends a request to the catalog, decoding the response into out if it
+// isn't nil.
+func (c *icebergCatalog) do(
+	ctx context.Context, method string, urlPath string, body interface{}, out interface{},
+) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = gojson.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+urlPath, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	if c.token != `` {
+		req.Header.Set(`Authorization`, `Bearer `+c.token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, `connecting to iceberg catalog`)
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.StatusCode == http.StatusConflict:
+		return errIcebergCommitConflict
+	case res.StatusCode >= 300:
+		var catalogErr icebergCatalogError
+		msg := string(resBody)
+		if err := gojson.Unmarshal(resBody, &catalogErr); err == nil && catalogErr.Error.Message != `` {
+			msg = catalogErr.Error.Message
+		}
+		return errors.Errorf(`iceberg catalog request %s %s failed: %s: %s`,
+			method, urlPath, res.Status, msg)
+	}
+	if out == nil || len(resBody) == 0 {
+		return nil
+	}
+	return gojson.Unmarshal(resBody, out)
+}
+
+// loadConfig fetches the configuration of the catalog, which may override the
+// path prefix of the API.
+func (c *icebergCatalog) loadConfig(ctx context.Context) error {
+	urlPath := `/config`
+	if c.warehouse != `` {
+		urlPath += `?warehouse=` + url.QueryEscape(c.warehouse)
+	}
+	var config struct {
+		Defaults  map[string]string `json:"defaults"`
+		Overrides map[string]string `json:"overrides"`
+	}
+	if err := c.do(ctx, http.MethodGet, urlPath, nil, &config); err != nil {
+		return err
+	}
+	c.prefix = config.Overrides[`prefix`]
+	if c.prefix == `` {
+		c.prefix = config.Defaults[`prefix`]
+	}
+	return nil
+}
+
+func (c *icebergCatalog) namespacePath(namespace []string) string {
+	p := `/namespaces/` + url.PathEscape(strings.Join(namespace, "\x1f"))
+	if c.prefix != `` {
+		p = `/` + url.PathEscape(c.prefix) + p
+	}
+	return p
+}
+
+func (c *icebergCatalog) checkNamespace(ctx context.Context, namespace []string) error {
+	return c.do(ctx, http.MethodGet, c.namespacePath(namespace), nil, nil)
+}
+
+func (c *icebergCatalog) tablePath(namespace []string, table string) string {
+	return c.namespacePath(namespace) + `/tables/` + url.PathEscape(table)
+}
+
+func (c *icebergCatalog) loadTable(
+	ctx context.Context, namespace []string, table string,
+) (*icebergTableMetadata, error) {
+	var res struct {
+		Metadata icebergTableMetadata `json:"metadata"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.tablePath(namespace, table), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res.Metadata, nil
+}
+
+// commitSnapshot adds the snapshot to the table and makes it the head of its
+// main branch, provided that the head is still parent, which is nil if the
+// table had no snapshots. It returns the new metadata of the table.
+func (c *icebergCatalog) commitSnapshot(
+	ctx context.Context,
+	namespace []string,
+	table string,
+	tableUUID string,
+	parent *icebergSnapshot,
+	snapshot map[string]interface{},
+) (*icebergTableMetadata, error) {
+	var parentID interface{}
+	if parent != nil {
+		parentID = parent.SnapshotID
+	}
+	req := map[string]interface{}{
+		`requirements`: []interface{}{
+			map[string]interface{}{`type`: `assert-table-uuid`, `uuid`: tableUUID},
+			map[string]interface{}{`type`: `assert-ref-snapshot-id`, `ref`: `main`, `snapshot-id`: parentID},
+		},
+		`updates`: []interface{}{
+			map[string]interface{}{`action`: `add-snapshot`, `snapshot`: snapshot},
+			map[string]interface{}{
+				`action`: `set-snapshot-ref`, `ref-name`: `main`, `type`: `branch`,
+				`snapshot-id`: snapshot[`snapshot-id`],
+			},
+		},
+	}
+	var res struct {
+		Metadata icebergTableMetadata `json:"metadata"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.tablePath(namespace, table), req, &res); err != nil {
+		return nil, err
+	}
+	return &res.Metadata, nil
+}
+
+// The Avro schemas of manifests and manifest lists. They only include the
+// fields written by the sink; readers fill in the other optional fields.
+const (
+	icebergManifestEntrySchema = `{
+  "type": "record", "name": "manifest_entry", "fields": [
+    {"name": "status", "type": "int", "field-id": 0},
+    {"name": "snapshot_id", "type": ["null", "long"], "default": null, "field-id": 1},
+    {"name": "sequence_number", "type": ["null", "long"], "default": null, "field-id": 3},
+    {"name": "file_sequence_number", "type": ["null", "long"], "default": null, "field-id": 4},
+    {"name": "data_file", "field-id": 2, "type": {
+      "type": "record", "name": "r2", "fields": [
+        {"name": "content", "type": "int", "field-id": 134},
+        {"name": "file_path", "type": "string", "field-id": 100},
+        {"name": "file_format", "type": "string", "field-id": 101},
+        {"name": "partition", "type": {"type": "record", "name": "r102", "fields": []}, "field-id": 102},
+        {"name": "record_count", "type": "long", "field-id": 103},
+        {"name": "file_size_in_bytes", "type": "long", "field-id": 104},
+        {"name": "equality_ids", "default": null, "field-id": 135,
+         "type": ["null", {"type": "array", "items": "int", "element-id": 136}]}
+      ]}}
+  ]}`
+	icebergManifestFileSchema = `{
+  "type": "record", "name": "manifest_file", "fields": [
+    {"name": "manifest_path", "type": "string", "field-id": 500},
+    {"name": "manifest_length", "type": "long", "field-id": 501},
+    {"name": "partition_spec_id", "type": "int", "field-id": 502},
+    {"name": "content", "type": "int", "field-id": 517},
+    {"name": "sequence_number", "type": "long", "field-id": 515},
+    {"name": "min_sequence_number", "type": "long", "field-id": 516},
+    {"name": "added_snapshot_id", "type": "long", "field-id": 503},
+    {"name": "added_files_count", "type": "int", "field-id": 504},
+    {"name": "existing_files_count", "type": "int", "field-id": 505},
+    {"name": "deleted_files_count", "type": "int", "field-id": 506},
+    {"name": "added_rows_count", "type": "long", "field-id": 512},
+    {"name": "existing_rows_count", "type": "long", "field-id": 513},
+    {"name": "deleted_rows_count", "type": "long", "field-id": 514}
+  ]}`
+)
+
+// The content types of Iceberg data files and manifests.
+const (
+	icebergContentData            = 0
+	icebergContentEqualityDeletes = 2
+
+	icebergManifestContentData    = 0
+	icebergManifestContentDeletes = 1
+)
+
+// icebergColumnMapping maps the columns of a version of a table to the fields
+// of its Iceberg table.
+type icebergColumnMapping struct {
+	version descpb.DescriptorVersion
+	// columns are the columns written by the changefeed, in the order of the
+	// values of orcEncoder, and fieldIDs the IDs of their fields.
+	columns  []catalog.Column
+	fieldIDs []int
+	// keyOrdinals are the indexes in columns of the primary key columns.
+	keyOrdinals []int
+}
+
+// icebergRow is the latest version of a row buffered by the sink.
+type icebergRow struct {
+	datums  tree.Datums
+	deleted bool
+}
+
+// icebergTable holds the state of an Iceberg table written by the sink.
+type icebergTable struct {
+	name     string
+	meta     *icebergTableMetadata
+	mapping  icebergColumnMapping
+	location string
+
+	// rows holds the buffered rows, keyed by their encoded primary key.
+	rows        map[string]icebergRow
+	numMessages int
+	rawSize     int
+	oldestMVCC  hlc.Timestamp
+	alloc       kvevent.Alloc
+}
+
+// icebergSink commits rows to Iceberg tables. See the comment at the top of
+// the file.
+type icebergSink struct {
+	catalog   *icebergCatalog
+	namespace []string
+	// storageRoot is the storage URI without its query parameters, which is
+	// the prefix of the locations of the tables.
+	storageRoot string
+	es          cloud.ExternalStorage
+
+	opts          orcOptions
+	alloc         tree.DatumAlloc
+	scratch       []byte
+	manifestCodec *goavro.Codec
+	listCodec     *goavro.Codec
+
+	tables  map[descpb.ID]*icebergTable
+	metrics *sliMetrics
+}
+
+var _ Sink = (*icebergSink)(nil)
+var _ tableValidatingSink = (*icebergSink)(nil)
+
+func makeIcebergSink(
+	ctx context.Context,
+	u sinkURL,
+	opts map[string]string,
+	makeExternalStorageFromURI cloud.ExternalStorageFromURIFactory,
+	user security.SQLUsername,
+	m *sliMetrics,
+) (Sink, error) {
+	if changefeedbase.FormatType(opts[changefeedbase.OptFormat]) != changefeedbase.OptFormatORC {
+		return nil, errors.Errorf(`iceberg sink requires %s=%s`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatORC)
+	}
+	if u.Host == `` {
+		return nil, errors.Errorf(`host of the catalog must be specified for iceberg sink`)
+	}
+	var namespace []string
+	if ns := strings.Trim(u.Path, `/`); ns != `` {
+		namespace = strings.Split(ns, `.`)
+	} else {
+		return nil, errors.Errorf(`namespace must be specified for iceberg sink`)
+	}
+
+	storage := u.consumeParam(changefeedbase.SinkParamStorage)
+	if storage == `` {
+		return nil, errors.Errorf(`%s must be specified for iceberg sink`, changefeedbase.SinkParamStorage)
+	}
+	storageURL, err := url.Parse(storage)
+	if err != nil {
+		return nil, errors.Wrapf(err, `parsing %s of iceberg sink`, changefeedbase.SinkParamStorage)
+	}
+	storageURL.RawQuery = ``
+	storageRoot := strings.TrimSuffix(storageURL.String(), `/`)
+
+	tlsEnabled := true
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return nil, err
+	}
+	scheme := `https`
+	if !tlsEnabled {
+		scheme = `http`
+	}
+	c := &icebergCatalog{
+		baseURL:   fmt.Sprintf(`%s://%s/v1`, scheme, u.Host),
+		token:     u.consumeParam(changefeedbase.SinkParamBearerToken),
+		warehouse: u.consumeParam(changefeedbase.SinkParamWarehouse),
+	}
+	if c.client, err = makeWebhookClient(u, icebergRequestTimeout); err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown iceberg sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	s := &icebergSink{
+		catalog:     c,
+		namespace:   namespace,
+		storageRoot: storageRoot,
+		opts:        makeORCOptions(opts),
+		tables:      make(map[descpb.ID]*icebergTable),
+		metrics:     m,
+	}
+	if s.manifestCodec, err = goavro.NewCodec(icebergManifestEntrySchema); err != nil {
+		return nil, errors.NewAssertionErrorWithWrappedErrf(err, `parsing iceberg manifest schema`)
+	}
+	if s.listCodec, err = goavro.NewCodec(icebergManifestFileSchema); err != nil {
+		return nil, errors.NewAssertionErrorWithWrappedErrf(err, `parsing iceberg manifest list schema`)
+	}
+	if s.es, err = makeExternalStorageFromURI(ctx, storage, user); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dial implements the Sink interface. It checks that the catalog is
+// reachable and has the namespace of the sink.
+func (s *icebergSink) Dial() error {
+	ctx, cancel := context.WithTimeout(context.Background(), icebergRequestTimeout)
+	defer cancel()
+	if err := s.catalog.loadConfig(ctx); err != nil {
+		return err
+	}
+	return errors.Wrapf(s.catalog.checkNamespace(ctx, s.namespace),
+		`checking iceberg namespace %s`, strings.Join(s.namespace, `.`))
+}
+
+// validateTables implements the tableValidatingSink interface.
+func (s *icebergSink) validateTables(ctx context.Context, tables []catalog.TableDescriptor) error {
+	for _, desc := range tables {
+		meta, err := s.catalog.loadTable(ctx, s.namespace, desc.GetName())
+		if err != nil {
+			return errors.Wrapf(err, `loading iceberg table %s`, desc.GetName())
+		}
+		if _, err := s.mapColumns(desc, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapColumns maps the columns of the table to the fields of its Iceberg table,
+// returning an error if the Iceberg table can't hold the table's rows.
+func (s *icebergSink) mapColumns(
+	desc catalog.TableDescriptor, meta *icebergTableMetadata,
+) (icebergColumnMapping, error) {
+	m := icebergColumnMapping{version: desc.GetVersion()}
+	name := desc.GetName()
+	if meta.FormatVersion != 2 {
+		return m, errors.Errorf(`iceberg table %s has format version %d, only version 2 is supported`,
+			name, meta.FormatVersion)
+	}
+	for _, spec := range meta.PartitionSpecs {
+		if spec.SpecID == meta.DefaultSpecID && len(spec.Fields) > 0 {
+			return m, errors.Errorf(`iceberg table %s is partitioned, which is not supported`, name)
+		}
+	}
+	schema, ok := meta.currentSchema()
+	if !ok {
+		return m, errors.Errorf(`iceberg table %s has no schema with ID %d`, name, meta.CurrentSchemaID)
+	}
+	fields := make(map[string]icebergField, len(schema.Fields))
+	for _, f := range schema.Fields {
+		fields[f.Name] = f
+	}
+	written := make(map[string]struct{})
+	for _, col := range desc.PublicColumns() {
+		if !s.opts.includeColumn(col) {
+			continue
+		}
+		f, ok := fields[col.GetName()]
+		if !ok {
+			return m, errors.Errorf(`iceberg table %s has no field for column %s`, name, col.GetName())
+		}
+		if expected := icebergTypeForType(col.GetType()); f.primitiveType() != expected {
+			return m, errors.Errorf(`field %s of iceberg table %s has type %s, column of type %s requires %s`,
+				f.Name, name, f.primitiveType(), col.GetType().SQLString(), expected)
+		}
+		m.columns = append(m.columns, col)
+		m.fieldIDs = append(m.fieldIDs, f.ID)
+		written[f.Name] = struct{}{}
+	}
+	for _, f := range schema.Fields {
+		if _, ok := written[f.Name]; !ok && f.Required {
+			return m, errors.Errorf(`required field %s of iceberg table %s has no column`, f.Name, name)
+		}
+	}
+	for i := 0; i < desc.GetPrimaryIndex().NumKeyColumns(); i++ {
+		id := desc.GetPrimaryIndex().GetKeyColumnID(i)
+		for j, col := range m.columns {
+			if col.GetID() == id {
+				m.keyOrdinals = append(m.keyOrdinals, j)
+			}
+		}
+	}
+	if len(m.keyOrdinals) != desc.GetPrimaryIndex().NumKeyColumns() {
+		return m, errors.Errorf(`primary key columns of table %s must be written to iceberg`, name)
+	}
+	return m, nil
+}
+
+// getTable returns the state of the Iceberg table of desc, loading it from the
+// catalog when the table is first written or its schema changes.
+func (s *icebergSink) getTable(
+	ctx context.Context, desc catalog.TableDescriptor,
+) (*icebergTable, error) {
+	t, ok := s.tables[desc.GetID()]
+	if ok && t.mapping.version == desc.GetVersion() {
+		return t, nil
+	}
+	if ok && len(t.rows) > 0 {
+		// The buffered rows were decoded with the previous version of the
+		// table, so commit them before switching to the new one.
+		if err := s.commit(ctx, t); err != nil {
+			return nil, err
+		}
+	}
+	meta, err := s.catalog.loadTable(ctx, s.namespace, desc.GetName())
+	if err != nil {
+		return nil, errors.Wrapf(err, `loading iceberg table %s`, desc.GetName())
+	}
+	mapping, err := s.mapColumns(desc, meta)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		t = &icebergTable{name: desc.GetName(), rows: make(map[string]icebergRow)}
+		s.tables[desc.GetID()] = t
+	}
+	t.meta, t.mapping = meta, mapping
+	return t, nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *icebergSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	desc, ok := topic.(catalog.TableDescriptor)
+	if !ok {
+		return errors.AssertionFailedf(`unexpected topic type %T for iceberg sink`, topic)
+	}
+	t, err := s.getTable(ctx, desc)
+	if err != nil {
+		return err
+	}
+
+	row := icebergRow{datums: make(tree.Datums, len(t.mapping.columns))}
+	rest := value
+	for i, col := range t.mapping.columns {
+		if row.datums[i], rest, err = valueside.Decode(&s.alloc, col.GetType(), rest); err != nil {
+			return err
+		}
+	}
+	var deleted tree.Datum
+	if deleted, rest, err = valueside.Decode(&s.alloc, types.Bool, rest); err != nil {
+		return err
+	}
+	row.deleted = bool(tree.MustBeDBool(deleted))
+	// The updated timestamp, if any, isn't written to the table.
+
+	s.scratch = s.scratch[:0]
+	for _, i := range t.mapping.keyOrdinals {
+		if s.scratch, err = valueside.Encode(
+			s.scratch, valueside.NoColumnID, row.datums[i], nil,
+		); err != nil {
+			return err
+		}
+	}
+	t.rows[string(s.scratch)] = row
+	t.alloc.Merge(&alloc)
+	t.numMessages++
+	t.rawSize += len(value)
+	if t.oldestMVCC.IsEmpty() || mvcc.Less(t.oldestMVCC) {
+		t.oldestMVCC = mvcc
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface. Resolved timestamps
+// aren't written to the tables: every row below a resolved timestamp has been
+// committed by the flush which precedes it.
+func (s *icebergSink) EmitResolvedTimestamp(context.Context, Encoder, hlc.Timestamp) error {
+	defer s.metrics.recordResolvedCallback()()
+	return nil
+}
+
+// Flush implements the Sink interface. It commits a snapshot to each table
+// with buffered rows.
+func (s *icebergSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	for _, t := range s.tables {
+		if len(t.rows) == 0 {
+			continue
+		}
+		if err := s.commit(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushDurable implements the SinkWithDurableAck interface. Flush commits the
+// buffered rows to the catalog.
+func (s *icebergSink) FlushDurable(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
+// icebergFile is a file written to the table's location.
+type icebergFile struct {
+	path        string
+	size        int64
+	recordCount int64
+}
+
+// writeFile writes the contents of a file to the data or metadata directory
+// of the table.
+func (s *icebergSink) writeFile(
+	ctx context.Context, t *icebergTable, dir string, name string, contents []byte,
+) (string, error) {
+	location := strings.TrimSuffix(t.meta.Location, `/`)
+	if location != s.storageRoot && !strings.HasPrefix(location, s.storageRoot+`/`) {
+		return ``, errors.Errorf(`location %s of iceberg table %s is not within the sink's storage %s`,
+			location, t.name, s.storageRoot)
+	}
+	rel := path.Join(strings.TrimPrefix(location, s.storageRoot), dir, name)
+	if err := cloud.WriteFile(ctx, s.es, strings.TrimPrefix(rel, `/`), bytes.NewReader(contents)); err != nil {
+		return ``, err
+	}
+	return location + `/` + dir + `/` + name, nil
+}
+
+// readFile reads a file of the table given its absolute path.
+func (s *icebergSink) readFile(ctx context.Context, absPath string) ([]byte, error) {
+	if !strings.HasPrefix(absPath, s.storageRoot+`/`) {
+		return nil, errors.Errorf(`iceberg file %s is not within the sink's storage %s`,
+			absPath, s.storageRoot)
+	}
+	r, err := s.es.ReadFile(ctx, strings.TrimPrefix(absPath, s.storageRoot+`/`))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// writeORCFile writes the given columns of the buffered rows, skipping deleted
+// rows if live is set, as an ORC file.
+func (s *icebergSink) writeORCFile(
+	ctx context.Context, t *icebergTable, ordinals []int, live bool,
+) (icebergFile, error) {
+	columns := make([]*orcColumn, len(ordinals))
+	for i, ord := range ordinals {
+		col := t.mapping.columns[ord]
+		columns[i] = &orcColumn{
+			name: col.GetName(), typ: col.GetType(), kind: icebergORCKind(col.GetType()),
+			icebergID: t.mapping.fieldIDs[ord],
+		}
+	}
+	var buf bytes.Buffer
+	w := makeORCWriterForColumns(columns, &buf)
+	var f icebergFile
+	datums := make(tree.Datums, len(ordinals))
+	for _, row := range t.rows {
+		if live && row.deleted {
+			continue
+		}
+		for i, ord := range ordinals {
+			datums[i] = row.datums[ord]
+		}
+		if err := w.addDatums(datums); err != nil {
+			return f, err
+		}
+		f.recordCount++
+	}
+	w.finish()
+	var err error
+	f.size = int64(buf.Len())
+	f.path, err = s.writeFile(ctx, t, `data`, uuid.FastMakeV4().String()+`.orc`, buf.Bytes())
+	return f, err
+}
+
+// writeManifest writes a manifest adding the given file, returning the
+// manifest list entry of the manifest without its sequence numbers.
+func (s *icebergSink) writeManifest(
+	ctx context.Context,
+	t *icebergTable,
+	snapshotID int64,
+	file icebergFile,
+	content int,
+	equalityIDs []interface{},
+) (map[string]interface{}, error) {
+	schema, _ := t.meta.currentSchema()
+	schemaJSON, err := gojson.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	manifestContent := icebergManifestContentData
+	contentName := `data`
+	var eqIDs interface{}
+	if content == icebergContentEqualityDeletes {
+		manifestContent, contentName = icebergManifestContentDeletes, `deletes`
+		eqIDs = goavro.Union(`array`, equalityIDs)
+	}
+
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:     &buf,
+		Codec: s.manifestCodec,
+		MetaData: map[string][]byte{
+			`schema`:            schemaJSON,
+			`schema-id`:         []byte(strconv.Itoa(schema.SchemaID)),
+			`partition-spec`:    []byte(`[]`),
+			`partition-spec-id`: []byte(strconv.Itoa(t.meta.DefaultSpecID)),
+			`format-version`:    []byte(`2`),
+			`content`:           []byte(contentName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The snapshot ID and sequence numbers of added files are inherited from
+	// the manifest list, so the manifest can be reused if the commit is
+	// retried with a new sequence number.
+	if err := w.Append([]interface{}{map[string]interface{}{
+		`status`:               int32(1), // ADDED
+		`snapshot_id`:          nil,
+		`sequence_number`:      nil,
+		`file_sequence_number`: nil,
+		`data_file`: map[string]interface{}{
+			`content`:            int32(content),
+			`file_path`:          file.path,
+			`file_format`:        `ORC`,
+			`partition`:          map[string]interface{}{},
+			`record_count`:       file.recordCount,
+			`file_size_in_bytes`: file.size,
+			`equality_ids`:       eqIDs,
+		},
+	}}); err != nil {
+		return nil, err
+	}
+	manifestPath, err := s.writeFile(ctx, t, `metadata`,
+		uuid.FastMakeV4().String()+`-m0.avro`, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		`manifest_path`:        manifestPath,
+		`manifest_length`:      int64(buf.Len()),
+		`partition_spec_id`:    int32(t.meta.DefaultSpecID),
+		`content`:              int32(manifestContent),
+		`added_snapshot_id`:    snapshotID,
+		`added_files_count`:    int32(1),
+		`existing_files_count`: int32(0),
+		`deleted_files_count`:  int32(0),
+		`added_rows_count`:     file.recordCount,
+		`existing_rows_count`:  int64(0),
+		`deleted_rows_count`:   int64(0),
+	}, nil
+}
+
+// icebergAvroValue returns the value of the first of the given fields present
+// in a decoded Avro record, unwrapping union values.
+func icebergAvroValue(record map[string]interface{}, fields ...string) interface{} {
+	for _, f := range fields {
+		v, ok := record[f]
+		if !ok {
+			continue
+		}
+		if u, ok := v.(map[string]interface{}); ok {
+			for _, inner := range u {
+				return inner
+			}
+		}
+		return v
+	}
+	return nil
+}
+
+// readManifestList reads the entries of the manifest list of a snapshot,
+// keeping the fields written by the sink. Writers name some of the fields
+// differently, so they are looked up by each of their names.
+func (s *icebergSink) readManifestList(
+	ctx context.Context, snapshot *icebergSnapshot,
+) ([]interface{}, error) {
+	contents, err := s.readFile(ctx, snapshot.ManifestList)
+	if err != nil {
+		return nil, errors.Wrapf(err, `reading manifest list of snapshot %d`, snapshot.SnapshotID)
+	}
+	r, err := goavro.NewOCFReader(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	fields := [][]string{
+		{`manifest_path`}, {`manifest_length`}, {`partition_spec_id`}, {`content`},
+		{`sequence_number`}, {`min_sequence_number`}, {`added_snapshot_id`},
+		{`added_files_count`, `added_data_files_count`},
+		{`existing_files_count`, `existing_data_files_count`},
+		{`deleted_files_count`, `deleted_data_files_count`},
+		{`added_rows_count`}, {`existing_rows_count`}, {`deleted_rows_count`},
+	}
+	var entries []interface{}
+	for r.Scan() {
+		v, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf(`unexpected manifest list entry %T`, v)
+		}
+		entry := make(map[string]interface{}, len(fields))
+		for _, names := range fields {
+			value := icebergAvroValue(record, names...)
+			if value == nil {
+				return nil, errors.Errorf(`manifest list entry is missing field %s`, names[0])
+			}
+			entry[names[0]] = value
+		}
+		entries = append(entries, entry)
+	}
+	return entries, r.Err()
+}
+
+// icebergSnapshotID returns a new random snapshot ID, derived from a UUID as
+// Iceberg's reference implementation does.
+func icebergSnapshotID() int64 {
+	b := uuid.FastMakeV4().GetBytes()
+	return int64((binary.BigEndian.Uint64(b[:8]) ^ binary.BigEndian.Uint64(b[8:])) & math.MaxInt64)
+}
+
+// commit writes the buffered rows of the table into new files and commits
+// a snapshot adding them to the table.
+func (s *icebergSink) commit(ctx context.Context, t *icebergTable) error {
+	defer t.alloc.Release(ctx)
+	recordMetrics := s.metrics.recordEmittedMessages()
+
+	snapshotID := icebergSnapshotID()
+	var manifests []map[string]interface{}
+	var written int64
+	var data icebergFile
+	for _, row := range t.rows {
+		if !row.deleted {
+			data.recordCount++
+		}
+	}
+	if data.recordCount > 0 {
+		allOrdinals := make([]int, len(t.mapping.columns))
+		for i := range allOrdinals {
+			allOrdinals[i] = i
+		}
+		var err error
+		if data, err = s.writeORCFile(ctx, t, allOrdinals, true /* live */); err != nil {
+			return err
+		}
+		written += data.size
+		m, err := s.writeManifest(ctx, t, snapshotID, data, icebergContentData, nil)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, m)
+	}
+	deletes, err := s.writeORCFile(ctx, t, t.mapping.keyOrdinals, false /* live */)
+	if err != nil {
+		return err
+	}
+	written += deletes.size
+	equalityIDs := make([]interface{}, len(t.mapping.keyOrdinals))
+	for i, ord := range t.mapping.keyOrdinals {
+		equalityIDs[i] = int32(t.mapping.fieldIDs[ord])
+	}
+	m, err := s.writeManifest(ctx, t, snapshotID, deletes, icebergContentEqualityDeletes, equalityIDs)
+	if err != nil {
+		return err
+	}
+	manifests = append(manifests, m)
+
+	for attempt := 1; ; attempt++ {
+		meta, err := s.commitManifests(ctx, t, snapshotID, manifests, data.recordCount)
+		if err == nil {
+			t.meta = meta
+			break
+		}
+		if !errors.Is(err, errIcebergCommitConflict) || attempt == icebergMaxCommitAttempts {
+			return errors.Wrapf(err, `committing to iceberg table %s`, t.name)
+		}
+		// Another writer committed to the table, reload it and try again on
+		// top of its snapshot.
+		if t.meta, err = s.catalog.loadTable(ctx, s.namespace, t.name); err != nil {
+			return errors.Wrapf(err, `loading iceberg table %s`, t.name)
+		}
+	}
+
+	recordMetrics(t.numMessages, t.oldestMVCC, t.rawSize, int(written))
+	t.rows = make(map[string]icebergRow)
+	t.numMessages, t.rawSize, t.oldestMVCC = 0, 0, hlc.Timestamp{}
+	return nil
+}
+
+// commitManifests writes a manifest list holding the manifests of the
+// table's current snapshot and the new manifests, and commits a snapshot with
+// that list.
+func (s *icebergSink) commitManifests(
+	ctx context.Context,
+	t *icebergTable,
+	snapshotID int64,
+	manifests []map[string]interface{},
+	addedRows int64,
+) (*icebergTableMetadata, error) {
+	parent := t.meta.mainSnapshot()
+	seq := t.meta.LastSequenceNumber + 1
+
+	var entries []interface{}
+	if parent != nil {
+		var err error
+		if entries, err = s.readManifestList(ctx, parent); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range manifests {
+		entry := make(map[string]interface{}, len(m)+2)
+		for k, v := range m {
+			entry[k] = v
+		}
+		entry[`sequence_number`] = seq
+		entry[`min_sequence_number`] = seq
+		entries = append(entries, entry)
+	}
+
+	metadata := map[string][]byte{
+		`snapshot-id`:     []byte(strconv.FormatInt(snapshotID, 10)),
+		`sequence-number`: []byte(strconv.FormatInt(seq, 10)),
+		`format-version`:  []byte(`2`),
+	}
+	var parentID interface{}
+	if parent != nil {
+		parentID = parent.SnapshotID
+		metadata[`parent-snapshot-id`] = []byte(strconv.FormatInt(parent.SnapshotID, 10))
+	}
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Codec: s.listCodec, MetaData: metadata})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Append(entries); err != nil {
+		return nil, err
+	}
+	listPath, err := s.writeFile(ctx, t, `metadata`,
+		fmt.Sprintf(`snap-%d-%d-%s.avro`, snapshotID, seq, uuid.FastMakeV4()), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	schema, _ := t.meta.currentSchema()
+	snapshot := map[string]interface{}{
+		`snapshot-id`:        snapshotID,
+		`parent-snapshot-id`: parentID,
+		`sequence-number`:    seq,
+		`timestamp-ms`:       timeutil.Now().UnixNano() / int64(time.Millisecond),
+		`manifest-list`:      listPath,
+		`schema-id`:          schema.SchemaID,
+		`summary`: map[string]string{
+			`operation`:     `overwrite`,
+			`added-records`: strconv.FormatInt(addedRows, 10),
+		},
+	}
+	return s.catalog.commitSnapshot(ctx, s.namespace, t.name, t.meta.TableUUID, parent, snapshot)
+}
+
+// Close implements the Sink interface.
+func (s *icebergSink) Close() error {
+	s.tables = nil
+	s.catalog.client.CloseIdleConnections()
+	return s.es.Close()
+}
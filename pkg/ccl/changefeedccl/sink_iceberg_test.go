@@ -0,0 +1,270 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/blobs"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIcebergCatalog serves the endpoints of the Iceberg REST catalog used by
+// the iceberg sink for a single table, foo, in the namespace db.
+type fakeIcebergCatalog struct {
+	mu struct {
+		syncutil.Mutex
+		meta icebergTableMetadata
+		// conflicts is the number of commits to fail with a conflict.
+		conflicts int
+		commits   int
+	}
+}
+
+func (c *fakeIcebergCatalog) commits() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.commits
+}
+
+func (c *fakeIcebergCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	respond := func(v interface{}) {
+		_ = gojson.NewEncoder(w).Encode(v)
+	}
+	switch r.URL.Path {
+	case `/v1/config`:
+		respond(map[string]interface{}{`overrides`: map[string]string{`prefix`: `cat`}})
+	case `/v1/cat/namespaces/db`:
+		respond(map[string]interface{}{`namespace`: []string{`db`}})
+	case `/v1/cat/namespaces/db/tables/foo`:
+		if r.Method == http.MethodPost {
+			if c.mu.conflicts > 0 {
+				c.mu.conflicts--
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			var req struct {
+				Updates []struct {
+					Action   string          `json:"action"`
+					Snapshot icebergSnapshot `json:"snapshot"`
+				} `json:"updates"`
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := gojson.Unmarshal(body, &req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			snapshot := req.Updates[0].Snapshot
+			c.mu.meta.Snapshots = append(c.mu.meta.Snapshots, snapshot)
+			c.mu.meta.LastSequenceNumber = snapshot.SequenceNumber
+			c.mu.meta.CurrentSnapshotID = &snapshot.SnapshotID
+			c.mu.commits++
+		}
+		respond(map[string]interface{}{`metadata`: c.mu.meta})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		respond(map[string]interface{}{`error`: map[string]interface{}{
+			`message`: `not found: ` + r.URL.Path, `code`: 404,
+		}})
+	}
+}
+
+func TestIcebergSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+	settings := cluster.MakeTestingClusterSettings()
+	settings.ExternalIODir = dir
+	clientFactory := blobs.TestBlobServiceClient(settings.ExternalIODir)
+	externalStorageFromURI := func(
+		ctx context.Context, uri string, user security.SQLUsername,
+	) (cloud.ExternalStorage, error) {
+		return cloud.ExternalStorageFromURI(ctx, uri, base.ExternalIODirConfig{}, settings,
+			clientFactory, user, nil, nil)
+	}
+
+	fake := &fakeIcebergCatalog{}
+	fake.mu.meta = icebergTableMetadata{
+		FormatVersion:   2,
+		TableUUID:       `b9d7c1b6-3f5e-4c8a-9a55-4f1bc1f9e2a1`,
+		Location:        `nodelocal://0/warehouse/db/foo`,
+		CurrentSchemaID: 0,
+		Schemas: []icebergSchema{{Type: `struct`, Fields: []icebergField{
+			{ID: 1, Name: `a`, Required: true, Type: gojson.RawMessage(`"long"`)},
+			{ID: 2, Name: `b`, Type: gojson.RawMessage(`"string"`)},
+		}}},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tableDesc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	require.NoError(t, err)
+	opts := map[string]string{changefeedbase.OptFormat: string(changefeedbase.OptFormatORC)}
+	encoder, err := makeORCEncoder(opts)
+	require.NoError(t, err)
+
+	makeSink := func(t *testing.T) *icebergSink {
+		u, err := url.Parse(`iceberg://` + strings.TrimPrefix(srv.URL, `http://`) +
+			`/db?tls_enabled=false&storage=` + url.QueryEscape(`nodelocal://0/warehouse`))
+		require.NoError(t, err)
+		s, err := makeIcebergSink(ctx, sinkURL{URL: u}, opts, externalStorageFromURI,
+			security.RootUserName(), nil)
+		require.NoError(t, err)
+		require.NoError(t, s.Dial())
+		return s.(*icebergSink)
+	}
+	emit := func(t *testing.T, s Sink, values string, deleted bool) {
+		rows, err := parseValues(tableDesc, values)
+		require.NoError(t, err)
+		for _, row := range rows {
+			value, err := encoder.EncodeValue(ctx, encodeRow{
+				datums: row, deleted: deleted, tableDesc: tableDesc,
+			})
+			require.NoError(t, err)
+			ts := hlc.Timestamp{WallTime: 1}
+			require.NoError(t, s.EmitRow(ctx, tableDescriptorTopic{tableDesc}, nil, value, ts, ts, zeroAlloc))
+		}
+	}
+	// manifestList returns the content and sequence number of each manifest
+	// of the table's current snapshot.
+	manifestList := func(t *testing.T) [][2]int64 {
+		fake.mu.Lock()
+		list := fake.mu.meta.mainSnapshot().ManifestList
+		fake.mu.Unlock()
+		contents, err := ioutil.ReadFile(filepath.Join(dir, strings.TrimPrefix(list, `nodelocal://0/`)))
+		require.NoError(t, err)
+		r, err := goavro.NewOCFReader(bytes.NewReader(contents))
+		require.NoError(t, err)
+		var manifests [][2]int64
+		for r.Scan() {
+			v, err := r.Read()
+			require.NoError(t, err)
+			entry := v.(map[string]interface{})
+			manifests = append(manifests, [2]int64{
+				int64(entry[`content`].(int32)), entry[`sequence_number`].(int64),
+			})
+		}
+		return manifests
+	}
+
+	t.Run(`commit`, func(t *testing.T) {
+		s := makeSink(t)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		require.NoError(t, s.validateTables(ctx, []catalog.TableDescriptor{tableDesc}))
+
+		// Nothing is committed until the sink is flushed, and only the latest
+		// version of each row is written.
+		emit(t, s, `VALUES (1, 'one'), (2, 'two'), (1, 'uno')`, false)
+		emit(t, s, `VALUES (3, NULL)`, true)
+		require.Equal(t, 0, fake.commits())
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, 1, fake.commits())
+		require.Equal(t, [][2]int64{
+			{icebergManifestContentData, 1}, {icebergManifestContentDeletes, 1},
+		}, manifestList(t))
+		table := s.tables[tableDesc.GetID()]
+		require.Empty(t, table.rows)
+
+		// Flushing without buffered rows doesn't commit.
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, 1, fake.commits())
+
+		// A commit which only deletes rows has no data manifest, and keeps the
+		// manifests of the previous snapshot.
+		emit(t, s, `VALUES (2, NULL)`, true)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, [][2]int64{
+			{icebergManifestContentData, 1}, {icebergManifestContentDeletes, 1},
+			{icebergManifestContentDeletes, 2},
+		}, manifestList(t))
+	})
+
+	t.Run(`conflict`, func(t *testing.T) {
+		s := makeSink(t)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		fake.mu.Lock()
+		fake.mu.conflicts = 2
+		commits := fake.mu.commits
+		fake.mu.Unlock()
+		emit(t, s, `VALUES (4, 'four')`, false)
+		require.NoError(t, s.Flush(ctx))
+		require.Equal(t, commits+1, fake.commits())
+		require.Len(t, manifestList(t), 5)
+	})
+
+	t.Run(`incompatible schema`, func(t *testing.T) {
+		s := makeSink(t)
+		defer func() { require.NoError(t, s.Close()) }()
+
+		desc, err := parseTableDesc(`CREATE TABLE foo (a INT PRIMARY KEY, b BYTES)`)
+		require.NoError(t, err)
+		require.EqualError(t, s.validateTables(ctx, []catalog.TableDescriptor{desc}),
+			`field b of iceberg table foo has type string, column of type BYTES requires binary`)
+
+		desc, err = parseTableDesc(`CREATE TABLE foo (b STRING PRIMARY KEY)`)
+		require.NoError(t, err)
+		require.EqualError(t, s.validateTables(ctx, []catalog.TableDescriptor{desc}),
+			`required field a of iceberg table foo has no column`)
+
+		desc, err = parseTableDesc(`CREATE TABLE bar (a INT PRIMARY KEY)`)
+		require.NoError(t, err)
+		require.Regexp(t, `loading iceberg table bar: .* not found`,
+			s.validateTables(ctx, []catalog.TableDescriptor{desc}))
+	})
+
+	t.Run(`invalid params`, func(t *testing.T) {
+		for uri, expectedErr := range map[string]string{
+			`iceberg:///db?storage=nodelocal%3A%2F%2F0%2Fw`:                `host of the catalog must be specified for iceberg sink`,
+			`iceberg://catalog?storage=nodelocal%3A%2F%2F0%2Fw`:            `namespace must be specified for iceberg sink`,
+			`iceberg://catalog/db`:                                         `storage must be specified for iceberg sink`,
+			`iceberg://catalog/db?storage=nodelocal%3A%2F%2F0%2Fw&foo=bar`: `unknown iceberg sink query parameters: foo`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeIcebergSink(ctx, sinkURL{URL: u}, opts, externalStorageFromURI,
+				security.RootUserName(), nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+
+		u, err := url.Parse(`iceberg://catalog/db?storage=nodelocal%3A%2F%2F0%2Fw`)
+		require.NoError(t, err)
+		_, err = makeIcebergSink(ctx, sinkURL{URL: u}, map[string]string{
+			changefeedbase.OptFormat: string(changefeedbase.OptFormatJSON),
+		}, externalStorageFromURI, security.RootUserName(), nil)
+		require.EqualError(t, err, `iceberg sink requires format=orc`)
+	})
+}
@@ -0,0 +1,585 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	gojson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/errors"
+)
+
+// The influxdb sink writes the rows of time-series tables to InfluxDB as
+// points of its line protocol, e.g.
+//
+//   influxdb://influx.example.com:8086?org=acme&bucket=metrics&token=...&tag_columns=host,region&time_column=ts
+//
+// writes each row to the `metrics` bucket of the `acme` organization, through
+// the /api/v2/write endpoint, authenticated with the token, or with the
+// user:password of the URI, which InfluxDB 1.8 accepts as a token. Requests
+// are sent over HTTPS unless tls_enabled=false, verified with the optional
+// ca_cert.
+//
+// Each row is a point of the measurement named by the measurement parameter,
+// which defaults to the name of the row's table. The columns listed by
+// tag_columns are its tags, the columns listed by field_columns, which
+// default to the other columns of the table, are its fields, and its
+// timestamp is the value of time_column, a TIMESTAMP or TIMESTAMPTZ column,
+// or the MVCC timestamp of the row if time_column isn't set or the column is
+// NULL. The mapping is checked against the tables when the changefeed is
+// created and whenever a table's schema changes.
+//
+// The sink requires format=json, with the wrapped or row envelope. Integer
+// columns are written as integer fields, float and decimal columns as float
+// fields, boolean columns as boolean fields, and other supported columns (see
+// influxDBFieldTypes) as string fields. Tags and fields which are NULL are
+// left out, as are points whose fields are all NULL. Deletes aren't written,
+// since the line protocol can't express them.
+//
+// Points are buffered and written in a single request on each flush, or once
+// the buffer holds influxDBMaxBufferedBytes. Flush returns once InfluxDB has
+// acknowledged the write, and returns its error if it rejected any of the
+// points, including partial writes, in which InfluxDB keeps the other points.
+// A point written again, e.g. after a restart, replaces the point with the
+// same measurement, tags and timestamp. Resolved timestamps aren't written.
+
+const (
+	// influxDBRequestTimeout bounds the requests made to InfluxDB.
+	influxDBRequestTimeout = time.Minute
+	// influxDBMaxBufferedBytes bounds the size of the points which are
+	// buffered before they're written, without waiting for a Flush.
+	influxDBMaxBufferedBytes = 4 << 20
+)
+
+func isInfluxDBSink(u *url.URL) bool {
+	return u.Scheme == changefeedbase.SinkSchemeInfluxDB
+}
+
+// influxDBFieldType is the type of an InfluxDB field.
+type influxDBFieldType int
+
+const (
+	influxDBFieldString influxDBFieldType = iota
+	influxDBFieldInteger
+	influxDBFieldFloat
+	influxDBFieldBoolean
+)
+
+// influxDBFieldTypes returns the type of the fields written for columns of
+// the given SQL type, and whether the type is supported by the sink, for
+// tags as well as for fields.
+func influxDBFieldTypes(typ *types.T) (influxDBFieldType, bool) {
+	switch typ.Family() {
+	case types.IntFamily:
+		return influxDBFieldInteger, true
+	case types.FloatFamily, types.DecimalFamily:
+		return influxDBFieldFloat, true
+	case types.BoolFamily:
+		return influxDBFieldBoolean, true
+	case types.StringFamily, types.CollatedStringFamily, types.EnumFamily, types.UuidFamily,
+		types.INetFamily, types.DateFamily, types.TimeFamily, types.TimeTZFamily,
+		types.TimestampFamily, types.TimestampTZFamily, types.IntervalFamily:
+		return influxDBFieldString, true
+	default:
+		return 0, false
+	}
+}
+
+// influxDBLineEscaper escapes measurements, and influxDBKeyEscaper escapes
+// tag keys, tag values and field keys, in the line protocol.
+var (
+	influxDBLineEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxDBKeyEscaper  = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	// influxDBStringEscaper escapes string field values, which are quoted.
+	influxDBStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// influxDBColumn is a tag or field column of a table.
+type influxDBColumn struct {
+	name      string
+	fieldType influxDBFieldType
+	// key is the escaped tag or field key of the column.
+	key string
+}
+
+// influxDBTable is the mapping of a version of a table to points.
+type influxDBTable struct {
+	version descpb.DescriptorVersion
+	// measurement is the escaped measurement of the table's points.
+	measurement string
+	tags        []influxDBColumn
+	fields      []influxDBColumn
+	// timeColumn is the time column, if any, and timeType its type.
+	timeColumn string
+	timeType   *types.T
+}
+
+// influxDBSink writes rows to InfluxDB. See the comment at the top of the file.
+type influxDBSink struct {
+	baseURL     string
+	authHeader  string
+	org, bucket string
+	client      *httputil.Client
+
+	wrapped      bool
+	omitVirtual  bool
+	measurement  string
+	tagColumns   []string
+	fieldColumns []string
+	timeColumn   string
+
+	tables map[descpb.ID]*influxDBTable
+	// buf holds the buffered points, in the line protocol.
+	buf bytes.Buffer
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*influxDBSink)(nil)
+var _ tableValidatingSink = (*influxDBSink)(nil)
+
+// splitColumnList splits a comma-separated list of column names.
+func splitColumnList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, `,`) {
+		if name = strings.TrimSpace(name); name != `` {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func makeInfluxDBSink(u sinkURL, opts map[string]string, m *sliMetrics) (Sink, error) {
+	if changefeedbase.FormatType(opts[changefeedbase.OptFormat]) != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`influxdb sink requires %s=%s`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+	}
+	envelope := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope])
+	switch envelope {
+	case changefeedbase.OptEnvelopeWrapped, changefeedbase.OptEnvelopeRow:
+	default:
+		return nil, errors.Errorf(`%s=%s is not supported by influxdb sinks`,
+			changefeedbase.OptEnvelope, envelope)
+	}
+	switch deleteFormat := changefeedbase.DeleteFormat(opts[changefeedbase.OptDeleteFormat]); deleteFormat {
+	case ``, changefeedbase.OptDeleteFormatAfterNull, changefeedbase.OptDeleteFormatTombstone,
+		changefeedbase.OptDeleteFormatNull:
+	default:
+		return nil, errors.Errorf(`%s=%s is not supported by influxdb sinks`,
+			changefeedbase.OptDeleteFormat, deleteFormat)
+	}
+	if u.Host == `` {
+		return nil, errors.Errorf(`host must be specified for influxdb sink`)
+	}
+
+	s := &influxDBSink{
+		org:          u.consumeParam(changefeedbase.SinkParamInfluxDBOrg),
+		bucket:       u.consumeParam(changefeedbase.SinkParamInfluxDBBucket),
+		wrapped:      envelope == changefeedbase.OptEnvelopeWrapped,
+		omitVirtual:  opts[changefeedbase.OptVirtualColumns] == string(changefeedbase.OptVirtualColumnsOmitted),
+		measurement:  u.consumeParam(changefeedbase.SinkParamInfluxDBMeasurement),
+		tagColumns:   splitColumnList(u.consumeParam(changefeedbase.SinkParamInfluxDBTagColumns)),
+		fieldColumns: splitColumnList(u.consumeParam(changefeedbase.SinkParamInfluxDBFieldColumns)),
+		timeColumn:   u.consumeParam(changefeedbase.SinkParamInfluxDBTimeColumn),
+		tables:       make(map[descpb.ID]*influxDBTable),
+		metrics:      m,
+	}
+	if s.bucket == `` {
+		return nil, errors.Errorf(`%s must be specified for influxdb sink`, changefeedbase.SinkParamInfluxDBBucket)
+	}
+	if s.org == `` {
+		return nil, errors.Errorf(`%s must be specified for influxdb sink`, changefeedbase.SinkParamInfluxDBOrg)
+	}
+
+	columns := make(map[string]string)
+	for _, c := range []struct {
+		param   string
+		columns []string
+	}{
+		{changefeedbase.SinkParamInfluxDBTagColumns, s.tagColumns},
+		{changefeedbase.SinkParamInfluxDBFieldColumns, s.fieldColumns},
+		{changefeedbase.SinkParamInfluxDBTimeColumn, splitColumnList(s.timeColumn)},
+	} {
+		for _, name := range c.columns {
+			if param, ok := columns[name]; ok {
+				return nil, errors.Errorf(`column %s is listed by both %s and %s`, name, param, c.param)
+			}
+			columns[name] = c.param
+		}
+	}
+
+	token := u.consumeParam(changefeedbase.SinkParamInfluxDBToken)
+	if u.User != nil {
+		if token != `` {
+			return nil, errors.Errorf(`%s and user credentials can't both be specified for influxdb sink`,
+				changefeedbase.SinkParamInfluxDBToken)
+		}
+		password, _ := u.User.Password()
+		token = u.User.Username() + `:` + password
+	}
+	if token != `` {
+		s.authHeader = `Token ` + token
+	}
+
+	tlsEnabled := true
+	if _, err := u.consumeBool(changefeedbase.SinkParamTLSEnabled, &tlsEnabled); err != nil {
+		return nil, err
+	}
+	scheme := `https`
+	if !tlsEnabled {
+		scheme = `http`
+	}
+	s.baseURL = fmt.Sprintf(`%s://%s`, scheme, u.Host)
+	var err error
+	if s.client, err = makeWebhookClient(u, influxDBRequestTimeout); err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown influxdb sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	return s, nil
+}
+
+// Dial implements the Sink interface. It checks that InfluxDB is reachable.
+func (s *influxDBSink) Dial() error {
+	ctx, cancel := context.WithTimeout(context.Background(), influxDBRequestTimeout)
+	defer cancel()
+	return errors.Wrapf(s.do(ctx, http.MethodGet, `/ping`, nil), `connecting to influxdb at %s`, s.baseURL)
+}
+
+// validateTables implements the tableValidatingSink interface.
+func (s *influxDBSink) validateTables(ctx context.Context, tables []catalog.TableDescriptor) error {
+	for _, desc := range tables {
+		if _, err := s.mapTable(desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapTable maps the columns of the table to the tags, fields and timestamp of
+// its points, returning an error if they can't be.
+func (s *influxDBSink) mapTable(desc catalog.TableDescriptor) (*influxDBTable, error) {
+	name := desc.GetName()
+	columns := make(map[string]catalog.Column)
+	for _, col := range desc.PublicColumns() {
+		if s.omitVirtual && col.IsVirtual() {
+			continue
+		}
+		columns[col.GetName()] = col
+	}
+	mapColumn := func(colName string) (influxDBColumn, error) {
+		col, ok := columns[colName]
+		if !ok {
+			return influxDBColumn{}, errors.Errorf(`table %s has no column %s`, name, colName)
+		}
+		fieldType, ok := influxDBFieldTypes(col.GetType())
+		if !ok {
+			return influxDBColumn{}, errors.Errorf(`column %s of type %s is not supported by influxdb sinks`,
+				colName, col.GetType().SQLString())
+		}
+		return influxDBColumn{name: colName, fieldType: fieldType, key: influxDBKeyEscaper.Replace(colName)}, nil
+	}
+
+	measurement := s.measurement
+	if measurement == `` {
+		measurement = name
+	}
+	t := &influxDBTable{
+		version:     desc.GetVersion(),
+		measurement: influxDBLineEscaper.Replace(measurement),
+	}
+	mapped := make(map[string]struct{})
+	for _, colName := range s.tagColumns {
+		col, err := mapColumn(colName)
+		if err != nil {
+			return nil, err
+		}
+		t.tags = append(t.tags, col)
+		mapped[colName] = struct{}{}
+	}
+	if s.timeColumn != `` {
+		col, ok := columns[s.timeColumn]
+		if !ok {
+			return nil, errors.Errorf(`table %s has no column %s`, name, s.timeColumn)
+		}
+		switch col.GetType().Family() {
+		case types.TimestampFamily, types.TimestampTZFamily:
+		default:
+			return nil, errors.Errorf(`%s %s of table %s has type %s, which requires TIMESTAMP or TIMESTAMPTZ`,
+				changefeedbase.SinkParamInfluxDBTimeColumn, s.timeColumn, name, col.GetType().SQLString())
+		}
+		t.timeColumn, t.timeType = s.timeColumn, col.GetType()
+		mapped[s.timeColumn] = struct{}{}
+	}
+	fieldColumns := s.fieldColumns
+	if fieldColumns == nil {
+		for _, col := range desc.PublicColumns() {
+			if _, ok := mapped[col.GetName()]; !ok && (!s.omitVirtual || !col.IsVirtual()) {
+				fieldColumns = append(fieldColumns, col.GetName())
+			}
+		}
+	}
+	for _, colName := range fieldColumns {
+		col, err := mapColumn(colName)
+		if err != nil {
+			return nil, errors.Wrapf(err, `mapping the fields of table %s, which can be set with %s`,
+				name, changefeedbase.SinkParamInfluxDBFieldColumns)
+		}
+		t.fields = append(t.fields, col)
+	}
+	if len(t.fields) == 0 {
+		return nil, errors.Errorf(`table %s has no field columns`, name)
+	}
+	return t, nil
+}
+
+// getTable returns the mapping of desc to points, mapping it when the table
+// is first written or its schema changes.
+func (s *influxDBSink) getTable(desc catalog.TableDescriptor) (*influxDBTable, error) {
+	if t, ok := s.tables[desc.GetID()]; ok && t.version == desc.GetVersion() {
+		return t, nil
+	}
+	t, err := s.mapTable(desc)
+	if err != nil {
+		return nil, err
+	}
+	s.tables[desc.GetID()] = t
+	return t, nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *influxDBSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer alloc.Release(ctx)
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	desc, ok := topic.(catalog.TableDescriptor)
+	if !ok {
+		return errors.AssertionFailedf(`unexpected topic type %T for influxdb sink`, topic)
+	}
+	t, err := s.getTable(desc)
+	if err != nil {
+		return err
+	}
+	row, err := decodeJSONRowValue(value, s.wrapped)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return nil
+	}
+	line, err := t.appendPoint(nil, row, mvcc)
+	if err != nil {
+		return errors.Wrapf(err, `writing row of table %s`, desc.GetName())
+	}
+	if line == nil {
+		return nil
+	}
+	s.buf.Write(line)
+	if s.buf.Len() >= influxDBMaxBufferedBytes {
+		return s.write(ctx)
+	}
+	return nil
+}
+
+// appendPoint appends the line of the point of the row, or nothing if all of
+// its fields are NULL.
+func (t *influxDBTable) appendPoint(
+	buf []byte, row map[string]interface{}, mvcc hlc.Timestamp,
+) ([]byte, error) {
+	line := append(buf, t.measurement...)
+	for _, tag := range t.tags {
+		v := row[tag.name]
+		if v == nil {
+			continue
+		}
+		s := fmt.Sprint(v)
+		if s == `` {
+			continue
+		}
+		if strings.Contains(s, "\n") {
+			return nil, errors.Errorf(`tag %s contains a newline`, tag.name)
+		}
+		line = append(line, ',')
+		line = append(line, tag.key...)
+		line = append(line, '=')
+		line = append(line, influxDBKeyEscaper.Replace(s)...)
+	}
+	numFields := 0
+	for _, field := range t.fields {
+		v := row[field.name]
+		if v == nil {
+			continue
+		}
+		if numFields == 0 {
+			line = append(line, ' ')
+		} else {
+			line = append(line, ',')
+		}
+		numFields++
+		line = append(line, field.key...)
+		line = append(line, '=')
+		var err error
+		if line, err = appendInfluxDBFieldValue(line, field.fieldType, v); err != nil {
+			return nil, errors.Wrapf(err, `field %s`, field.name)
+		}
+	}
+	if numFields == 0 {
+		return buf, nil
+	}
+
+	ts := mvcc.WallTime
+	if t.timeType != nil {
+		if v, ok := row[t.timeColumn]; ok && v != nil {
+			s, ok := v.(string)
+			if !ok {
+				return nil, errors.Errorf(`unexpected JSON value for %s: %v`, t.timeType.SQLString(), v)
+			}
+			layout := time.RFC3339Nano
+			if t.timeType.Family() == types.TimestampFamily {
+				layout = `2006-01-02T15:04:05.999999999`
+			}
+			parsed, err := time.Parse(layout, s)
+			if err != nil {
+				return nil, errors.Wrapf(err, `parsing %s`, t.timeType.SQLString())
+			}
+			ts = parsed.UnixNano()
+		}
+	}
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, ts, 10)
+	return append(line, '\n'), nil
+}
+
+// appendInfluxDBFieldValue appends a field value of the given type, from the
+// value of its column as decoded by decodeJSONWithNumbers.
+func appendInfluxDBFieldValue(
+	buf []byte, fieldType influxDBFieldType, v interface{},
+) ([]byte, error) {
+	switch fieldType {
+	case influxDBFieldInteger:
+		n, ok := v.(gojson.Number)
+		if !ok {
+			return nil, errors.Errorf(`unexpected JSON value for an integer: %v`, v)
+		}
+		return append(append(buf, n...), 'i'), nil
+	case influxDBFieldFloat:
+		// NaN and infinities are rendered as strings, and have no
+		// representation in the line protocol.
+		n, ok := v.(gojson.Number)
+		if !ok {
+			return nil, errors.Errorf(`unsupported value for a float: %v`, v)
+		}
+		return append(buf, n...), nil
+	case influxDBFieldBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf(`unexpected JSON value for a boolean: %v`, v)
+		}
+		return strconv.AppendBool(buf, b), nil
+	default:
+		buf = append(buf, '"')
+		buf = append(buf, influxDBStringEscaper.Replace(fmt.Sprint(v))...)
+		return append(buf, '"'), nil
+	}
+}
+
+// EmitResolvedTimestamp implements the Sink interface. Resolved timestamps
+// aren't written: every row below a resolved timestamp has been written by
+// the flush which precedes it.
+func (s *influxDBSink) EmitResolvedTimestamp(context.Context, Encoder, hlc.Timestamp) error {
+	defer s.metrics.recordResolvedCallback()()
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *influxDBSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.write(ctx)
+}
+
+// write writes the buffered points and waits for their acknowledgement.
+func (s *influxDBSink) write(ctx context.Context) error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	params := url.Values{}
+	params.Set(`org`, s.org)
+	params.Set(`bucket`, s.bucket)
+	params.Set(`precision`, `ns`)
+	if err := s.do(ctx, http.MethodPost, `/api/v2/write?`+params.Encode(), s.buf.Bytes()); err != nil {
+		return errors.Wrapf(err, `writing to influxdb bucket %s`, s.bucket)
+	}
+	s.buf.Reset()
+	return nil
+}
+
+// influxDBError is the body of the responses of InfluxDB to failed requests.
+type influxDBError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// do sends a request to InfluxDB, returning an error unless it succeeds.
+func (s *influxDBSink) do(ctx context.Context, method string, urlPath string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+urlPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(`Content-Type`, `text/plain; charset=utf-8`)
+	if s.authHeader != `` {
+		req.Header.Set(authorizationHeader, s.authHeader)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		var influxErr influxDBError
+		msg := string(resBody)
+		if err := gojson.Unmarshal(resBody, &influxErr); err == nil && influxErr.Message != `` {
+			msg = influxErr.Message
+		}
+		return errors.Errorf(`%s: %s`, res.Status, msg)
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *influxDBSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInfluxDBServer replies as InfluxDB would to the requests of the
+// influxdb sink, recording the requests it receives, and rejects the points
+// of writes holding `fail` as a partial write.
+type fakeInfluxDBServer struct {
+	mu struct {
+		syncutil.Mutex
+		requests []string
+	}
+}
+
+func (s *fakeInfluxDBServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.mu.requests = append(s.mu.requests, fmt.Sprintf(`%s %s %s`+"\n%s",
+		r.Method, r.URL.RequestURI(), r.Header.Get(`Authorization`), body))
+	s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == `/ping`:
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path != `/api/v2/write`:
+		w.WriteHeader(http.StatusNotFound)
+	case strings.Contains(string(body), `fail`):
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"invalid","message":"partial write: field type conflict: dropped=1"}`))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeInfluxDBServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.mu.requests
+	s.mu.requests = nil
+	return requests
+}
+
+func TestInfluxDBSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	fake := &fakeInfluxDBServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, `http://`)
+
+	cpuDesc, err := parseTableDesc(`CREATE TABLE cpu (` +
+		`host STRING, region STRING, ts TIMESTAMPTZ, usage FLOAT, cores INT, up BOOL, note STRING, ` +
+		`PRIMARY KEY (host, ts))`)
+	require.NoError(t, err)
+	cpuTopic := tableDescriptorTopic{cpuDesc}
+	jsonOpts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	makeSink := func(uri string, opts map[string]string) (*influxDBSink, error) {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeInfluxDBSink(sinkURL{URL: u}, opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		return sink.(*influxDBSink), nil
+	}
+	dialSink := func(t *testing.T, params string) *influxDBSink {
+		sink, err := makeSink(fmt.Sprintf(`influxdb://%s?tls_enabled=false&org=acme&bucket=metrics&%s`,
+			host, params), jsonOpts)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		require.NoError(t, sink.validateTables(ctx, []catalog.TableDescriptor{cpuDesc}))
+		fake.requests()
+		return sink
+	}
+	ts := hlc.Timestamp{WallTime: 1641092645000000001}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := dialSink(t, `token=secret&tag_columns=host,region&field_columns=usage,cores,up,note&time_column=ts`)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": {"host": "a b", "region": "us,east", `+
+			`"ts": "2022-01-02T03:04:05.678Z", "usage": 0.5, "cores": 8, "up": true, "note": "say \"hi\""}}`),
+			ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": {"host": "c", "region": null, `+
+			`"ts": null, "usage": null, "cores": 12345678901234567, "up": null, "note": null}}`),
+			ts, ts, zeroAlloc))
+		// Deletes and points without fields aren't written.
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": null}`), ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": {"host": "d", "region": null, `+
+			`"ts": null, "usage": null, "cores": null, "up": null, "note": null}}`), ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, nil, ts))
+		require.NoError(t, sink.Flush(ctx))
+		// Flushing without buffered points doesn't write.
+		require.NoError(t, sink.Flush(ctx))
+
+		require.Equal(t, []string{
+			"POST /api/v2/write?bucket=metrics&org=acme&precision=ns Token secret\n" +
+				`cpu,host=a\ b,region=us\,east usage=0.5,cores=8i,up=true,note="say \"hi\"" 1641092645678000000` + "\n" +
+				`cpu,host=c cores=12345678901234567i 1641092645000000001` + "\n",
+		}, fake.requests())
+	})
+
+	t.Run(`default fields`, func(t *testing.T) {
+		sink := dialSink(t, `measurement=load%20avg&tag_columns=host`)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": {"host": "a", "region": "eu", `+
+			`"ts": "2022-01-02T03:04:05Z", "usage": 1, "cores": 2, "up": false, "note": null}}`),
+			ts, ts, zeroAlloc))
+		require.NoError(t, sink.Flush(ctx))
+		require.Equal(t, []string{
+			"POST /api/v2/write?bucket=metrics&org=acme&precision=ns \n" +
+				`load\ avg,host=a region="eu",ts="2022-01-02T03:04:05Z",usage=1,cores=2i,up=false 1641092645000000001` + "\n",
+		}, fake.requests())
+	})
+
+	t.Run(`partial write`, func(t *testing.T) {
+		sink := dialSink(t, `tag_columns=host`)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, cpuTopic, nil, []byte(`{"after": {"host": "a", "region": null, `+
+			`"ts": null, "usage": null, "cores": null, "up": null, "note": "fail"}}`), ts, ts, zeroAlloc))
+		require.Regexp(t, `writing to influxdb bucket metrics: 400 Bad Request: partial write: field type conflict`,
+			sink.Flush(ctx))
+	})
+
+	t.Run(`validate tables`, func(t *testing.T) {
+		for params, expected := range map[string]string{
+			`tag_columns=dc`:                  `table cpu has no column dc`,
+			`time_column=cores`:               `time_column cores of table cpu has type INT8, which requires TIMESTAMP or TIMESTAMPTZ`,
+			`field_columns=missing`:           `mapping the fields of table cpu, which can be set with field_columns: table cpu has no column missing`,
+			`tag_columns=host&field_columns=`: ``,
+		} {
+			sink, err := makeSink(fmt.Sprintf(`influxdb://%s?org=acme&bucket=metrics&%s`, host, params), jsonOpts)
+			require.NoError(t, err)
+			err = sink.validateTables(ctx, []catalog.TableDescriptor{cpuDesc})
+			if expected == `` {
+				require.NoError(t, err, params)
+			} else {
+				require.EqualError(t, err, expected, params)
+			}
+		}
+
+		sink, err := makeSink(fmt.Sprintf(`influxdb://%s?org=acme&bucket=metrics`, host), jsonOpts)
+		require.NoError(t, err)
+		desc, err := parseTableDesc(`CREATE TABLE blobs (a INT PRIMARY KEY, b BYTES)`)
+		require.NoError(t, err)
+		require.EqualError(t, sink.validateTables(ctx, []catalog.TableDescriptor{desc}),
+			`mapping the fields of table blobs, which can be set with field_columns: `+
+				`column b of type BYTES is not supported by influxdb sinks`)
+		desc, err = parseTableDesc(`CREATE TABLE tags_only (a INT PRIMARY KEY)`)
+		require.NoError(t, err)
+		sink, err = makeSink(fmt.Sprintf(`influxdb://%s?org=acme&bucket=metrics&tag_columns=a`, host), jsonOpts)
+		require.NoError(t, err)
+		require.EqualError(t, sink.validateTables(ctx, []catalog.TableDescriptor{desc}),
+			`table tags_only has no field columns`)
+	})
+
+	t.Run(`invalid`, func(t *testing.T) {
+		for uri, expected := range map[string]string{
+			`influxdb:///?org=acme&bucket=metrics`:                              `host must be specified`,
+			`influxdb://influx?org=acme`:                                        `bucket must be specified`,
+			`influxdb://influx?bucket=metrics`:                                  `org must be specified`,
+			`influxdb://influx?org=acme&bucket=b&tag_columns=a&field_columns=a`: `column a is listed by both tag_columns and field_columns`,
+			`influxdb://influx?org=acme&bucket=b&tag_columns=a&time_column=a`:   `column a is listed by both tag_columns and time_column`,
+			`influxdb://u:p@influx?org=acme&bucket=b&token=t`:                   `token and user credentials can't both be specified`,
+			`influxdb://influx?org=acme&bucket=b&tables=foo`:                    `unknown influxdb sink query parameters: tables`,
+		} {
+			_, err := makeSink(uri, jsonOpts)
+			require.Regexp(t, expected, err, uri)
+		}
+
+		uri := `influxdb://influx?org=acme&bucket=b`
+		for opts, expected := range map[[3]string]string{
+			{`avro`, `wrapped`, ``}:   `influxdb sink requires format=json`,
+			{`json`, `key_only`, ``}:  `envelope=key_only is not supported by influxdb sinks`,
+			{`json`, `wrapped`, `op`}: `delete_format=op is not supported by influxdb sinks`,
+		} {
+			_, err := makeSink(uri, map[string]string{
+				changefeedbase.OptFormat:       opts[0],
+				changefeedbase.OptEnvelope:     opts[1],
+				changefeedbase.OptDeleteFormat: opts[2],
+			})
+			require.Regexp(t, expected, err)
+		}
+
+		sink, err := makeSink(`influxdb://u:p@influx?org=acme&bucket=b`, jsonOpts)
+		require.NoError(t, err)
+		require.Equal(t, `Token u:p`, sink.authHeader)
+		require.Equal(t, `https://influx`, sink.baseURL)
+	})
+}
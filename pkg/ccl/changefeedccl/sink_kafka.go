@@ -15,6 +15,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -86,6 +88,16 @@ type kafkaSink struct {
 	client         kafkaClient
 	producer       sarama.AsyncProducer
 	topics         map[descpb.ID]string
+	// topicPrefix prefixes the names of the topics derived by the
+	// topic_from_column option.
+	topicPrefix string
+	// resolvedTopic, if set, is the only topic resolved timestamps are emitted
+	// to. Otherwise, they're emitted to every partition of every topic.
+	resolvedTopic string
+	// resolvedNullValue is set if resolved timestamps are emitted as records
+	// without a value, with the resolved_null_value option. See
+	// kafkaHeaderResolved.
+	resolvedNullValue bool
 
 	lastMetadataRefresh time.Time
 
@@ -94,12 +106,39 @@ type kafkaSink struct {
 	scratch      bufalloc.ByteAllocator
 	metrics      *sliMetrics
 
+	// inflightSlots, if set, holds a token for each message in flight, so
+	// that emitting blocks once the sink_concurrency option's number of
+	// messages are awaiting acknowledgement.
+	inflightSlots chan struct{}
+
+	// mvccRecordTimestamps is set if the timestamps of the records of rows are
+	// their MVCC commit time, with kafka_record_timestamp='mvcc'.
+	mvccRecordTimestamps bool
+
+	// partitionTimeBucket, if set, is the partition_time_bucket option, by
+	// which rows are partitioned by time bucket. See timeBucketPartition.
+	partitionTimeBucket time.Duration
+
+	// sequencer, if set, is the sequencer the sink numbers the messages it
+	// emits as, with the sequence_numbers option. sequences holds the last
+	// sequence number it assigned to each partition. See sequencedSink.
+	sequencer    string
+	sequences    map[kafkaPartition]int64
+	partitioners map[string]sarama.Partitioner
+
 	// Only synchronized between the client goroutine and the worker goroutine.
 	mu struct {
 		syncutil.Mutex
 		inflight int64
 		flushErr error
 		flushCh  chan struct{}
+		// resend holds the messages Kafka rejected because the produce
+		// request they were batched in was too large, along with the messages
+		// acknowledged after them in their partitions, in the order they were
+		// acknowledged. They're emitted again by Flush, in smaller batches.
+		// resendPartitions holds the partitions of the rejected messages.
+		resend           []kafkaResend
+		resendPartitions map[kafkaPartition]struct{}
 	}
 }
 
@@ -185,10 +224,61 @@ func (s *kafkaSink) Dial() error {
 			`connecting to kafka: %s`, s.bootstrapAddrs)
 	}
 	s.client = client
+	if s.mvccRecordTimestamps {
+		if err := s.checkTopicTimestampTypes(client); err != nil {
+			return err
+		}
+	}
 	s.start()
 	return nil
 }
 
+// checkTopicTimestampTypes returns an error if any of the topics of the sink
+// is configured with message.timestamp.type=LogAppendTime, which has the
+// brokers overwrite the MVCC timestamps set on records. Topics whose
+// configuration can't be described, e.g. because they don't exist yet or
+// because the changefeed's user isn't authorized to, aren't checked.
+func (s *kafkaSink) checkTopicTimestampTypes(client sarama.Client) error {
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		log.Warningf(s.ctx, "not checking the timestamp type of kafka topics: %v", err)
+		return nil
+	}
+	// Closing the admin would close the client, which the sink owns.
+	return validateKafkaTimestampTypes(s.ctx, admin.DescribeConfig, s.Topics())
+}
+
+// kafkaTimestampTypeConfig is the topic configuration which decides whether
+// brokers keep the timestamps set by producers (CreateTime) or overwrite them
+// with the time they append records to the log (LogAppendTime).
+const kafkaTimestampTypeConfig = `message.timestamp.type`
+
+func validateKafkaTimestampTypes(
+	ctx context.Context,
+	describe func(sarama.ConfigResource) ([]sarama.ConfigEntry, error),
+	topics []string,
+) error {
+	for _, topic := range topics {
+		entries, err := describe(sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: []string{kafkaTimestampTypeConfig},
+		})
+		if err != nil {
+			log.Warningf(ctx, "not checking the timestamp type of kafka topic %s: %v", topic, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Name == kafkaTimestampTypeConfig && entry.Value != `CreateTime` {
+				return errors.Errorf(`%s='%s' requires %s=CreateTime on topic %s, found %s`,
+					changefeedbase.OptKafkaRecordTimestamp, changefeedbase.OptKafkaRecordTimestampMVCC,
+					kafkaTimestampTypeConfig, topic, entry.Value)
+			}
+		}
+	}
+	return nil
+}
+
 // Close implements the Sink interface.
 func (s *kafkaSink) Close() error {
 	close(s.stopWorkerCh)
@@ -203,12 +293,58 @@ func (s *kafkaSink) Close() error {
 	return nil
 }
 
+// CheckHealth implements the SinkWithHealthCheck interface.
+func (s *kafkaSink) CheckHealth(ctx context.Context) error {
+	// s.client is only nil in tests.
+	if s.client == nil {
+		return nil
+	}
+	topics := make([]string, 0, len(s.topics))
+	for _, topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	return s.client.RefreshMetadata(topics...)
+}
+
 type messageMetadata struct {
 	alloc         kvevent.Alloc
 	updateMetrics recordEmittedMessagesCallback
 	mvcc          hlc.Timestamp
+	// partitioned is set if the sink assigned the message to its partition,
+	// rather than leaving it to the producer.
+	partitioned bool
+	// routingKey, if set, is hashed to pick the partition of the message
+	// instead of its key. See routedTopic.
+	routingKey []byte
+	// timeBucket, if set, picks the partition of the message from the bucket
+	// of its MVCC timestamp instead of its key. See timeBucketPartition.
+	timeBucket time.Duration
 }
 
+// kafkaPartition identifies a partition of a topic.
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
+const (
+	// kafkaHeaderSequencer and kafkaHeaderSequence are the headers which hold
+	// the sequencer and sequence number of messages with the sequence_numbers
+	// option.
+	kafkaHeaderSequencer = `crdb-sequencer`
+	kafkaHeaderSequence  = `crdb-sequence`
+	// kafkaHeaderResolved is the header which holds the resolved timestamp of
+	// the records emitted with the resolved_null_value option, which have
+	// neither a key nor a value. The timestamp is the decimal rendering of
+	// its HLC timestamp, `<wall time nanos>.<logical>`, as in the resolved
+	// field of JSON payloads. The timestamp of the records is the wall time
+	// of the resolved timestamp, which lets processors deriving watermarks
+	// from the timestamps of records use them as is. Like other resolved
+	// timestamps, they're emitted to every partition of each topic, or of the
+	// resolved_topic.
+	kafkaHeaderResolved = `crdb-resolved`
+)
+
 // EmitRow implements the Sink interface.
 func (s *kafkaSink) EmitRow(
 	ctx context.Context,
@@ -217,20 +353,107 @@ func (s *kafkaSink) EmitRow(
 	updated, mvcc hlc.Timestamp,
 	alloc kvevent.Alloc,
 ) error {
+	meta := messageMetadata{
+		alloc: alloc, mvcc: mvcc, timeBucket: s.partitionTimeBucket,
+		updateMetrics: s.metrics.recordEmittedMessages(),
+	}
+	if rt, ok := topicDescr.(routedTopic); ok {
+		topicDescr, meta.routingKey = rt.TopicDescriptor, rt.routingKey
+	}
 	topic, isKnownTopic := s.topics[topicDescr.GetID()]
 	if !isKnownTopic {
 		return errors.Errorf(`cannot emit to undeclared topic: %s`, topicDescr.GetName())
 	}
+	if ct, ok := topicDescr.(columnTopic); ok {
+		topic = s.topicPrefix + ct.value
+	}
 
 	msg := &sarama.ProducerMessage{
-		Topic:    topic,
-		Key:      sarama.ByteEncoder(key),
-		Value:    sarama.ByteEncoder(value),
-		Metadata: messageMetadata{alloc: alloc, mvcc: mvcc, updateMetrics: s.metrics.recordEmittedMessages()},
+		Topic: topic,
+		Key:   sarama.ByteEncoder(key),
+	}
+	// A nil value, as emitted for deletes with the compaction_tombstones
+	// option, is produced as a record without a value, which log compaction
+	// treats as a tombstone. An empty value isn't one.
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
 	}
+	if s.mvccRecordTimestamps {
+		msg.Timestamp = mvcc.GoTime()
+	}
+	if s.sequencer != `` {
+		// The partitioner reads the routing key from the metadata.
+		msg.Metadata = meta
+		if err := s.sequence(msg); err != nil {
+			return err
+		}
+		meta.partitioned = true
+	}
+	msg.Metadata = meta
 	return s.emitMessage(ctx, msg)
 }
 
+// startSequences implements the sequencedSink interface.
+func (s *kafkaSink) startSequences(sequencer string, last []jobspb.ChangefeedSequence) {
+	s.sequencer = sequencer
+	s.sequences = make(map[kafkaPartition]int64)
+	s.partitioners = make(map[string]sarama.Partitioner)
+	for _, seq := range last {
+		if seq.Sequencer == sequencer {
+			s.sequences[kafkaPartition{topic: seq.Topic, partition: seq.Partition}] = seq.Sequence
+		}
+	}
+}
+
+// flushedSequences implements the sequencedSink interface.
+func (s *kafkaSink) flushedSequences() []jobspb.ChangefeedSequence {
+	sequences := make([]jobspb.ChangefeedSequence, 0, len(s.sequences))
+	for p, seq := range s.sequences {
+		sequences = append(sequences, jobspb.ChangefeedSequence{
+			Sequencer: s.sequencer, Topic: p.topic, Partition: p.partition, Sequence: seq,
+		})
+	}
+	sort.Slice(sequences, func(i, j int) bool {
+		if sequences[i].Topic != sequences[j].Topic {
+			return sequences[i].Topic < sequences[j].Topic
+		}
+		return sequences[i].Partition < sequences[j].Partition
+	})
+	return sequences
+}
+
+// sequence numbers msg with the next sequence number of its partition, in its
+// headers. A message with a key is assigned to a partition as the producer
+// would, since the partition must be known to number it; messages without a
+// key must already be assigned to one.
+func (s *kafkaSink) sequence(msg *sarama.ProducerMessage) error {
+	if msg.Key != nil {
+		partitions, err := s.client.Partitions(msg.Topic)
+		if err != nil {
+			return err
+		}
+		partitioner, ok := s.partitioners[msg.Topic]
+		if !ok {
+			partitioner = newChangefeedPartitioner(msg.Topic)
+			s.partitioners[msg.Topic] = partitioner
+		}
+		choice, err := partitioner.Partition(msg, int32(len(partitions)))
+		if err != nil {
+			return err
+		}
+		msg.Partition = partitions[choice]
+	}
+	p := kafkaPartition{topic: msg.Topic, partition: msg.Partition}
+	s.sequences[p]++
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(kafkaHeaderSequencer), Value: []byte(s.sequencer)},
+		sarama.RecordHeader{
+			Key: []byte(kafkaHeaderSequence), Value: []byte(strconv.FormatInt(s.sequences[p], 10)),
+		},
+	)
+	return nil
+}
+
 // EmitResolvedTimestamp implements the Sink interface.
 func (s *kafkaSink) EmitResolvedTimestamp(
 	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
@@ -246,42 +469,79 @@ func (s *kafkaSink) EmitResolvedTimestamp(
 	// actively working on stability. At the same time, revisit this tuning.
 	const metadataRefreshMinDuration = time.Minute
 	if timeutil.Since(s.lastMetadataRefresh) > metadataRefreshMinDuration {
-		topics := make([]string, 0, len(s.topics))
+		topics := make([]string, 0, len(s.topics)+1)
 		for _, topic := range s.topics {
 			topics = append(topics, topic)
 		}
+		if s.resolvedTopic != `` {
+			topics = append(topics, s.resolvedTopic)
+		}
 		if err := s.client.RefreshMetadata(topics...); err != nil {
 			return err
 		}
 		s.lastMetadataRefresh = timeutil.Now()
 	}
 
+	// Resolved timestamps are only emitted once all the rows at or below them
+	// have been flushed, so a consumer of the resolved topic which sees a
+	// resolved timestamp can rely on every such row being present in the data
+	// topics. Rows above the resolved timestamp may have been written to the
+	// data topics before or after it.
+	if s.resolvedTopic != `` {
+		return s.emitResolvedTimestampToTopic(ctx, encoder, s.resolvedTopic, resolved)
+	}
 	for _, topic := range s.topics {
+		if err := s.emitResolvedTimestampToTopic(ctx, encoder, topic, resolved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitResolvedTimestampToTopic emits the resolved timestamp to every
+// partition of the given topic.
+func (s *kafkaSink) emitResolvedTimestampToTopic(
+	ctx context.Context, encoder Encoder, topic string, resolved hlc.Timestamp,
+) error {
+	var value sarama.Encoder
+	if !s.resolvedNullValue {
 		payload, err := encoder.EncodeResolvedTimestamp(ctx, topic, resolved)
 		if err != nil {
 			return err
 		}
 		s.scratch, payload = s.scratch.Copy(payload, 0 /* extraCap */)
+		value = sarama.ByteEncoder(payload)
+	}
 
-		// sarama caches this, which is why we have to periodically refresh the
-		// metadata above. Staleness here does not impact correctness. Some new
-		// partitions will miss this resolved timestamp, but they'll eventually
-		// be picked up and get later ones.
-		partitions, err := s.client.Partitions(topic)
-		if err != nil {
-			return err
+	// sarama caches this, which is why we have to periodically refresh the
+	// metadata above. Staleness here does not impact correctness. Some new
+	// partitions will miss this resolved timestamp, but they'll eventually
+	// be picked up and get later ones.
+	partitions, err := s.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		msg := &sarama.ProducerMessage{
+			Topic:     topic,
+			Partition: partition,
+			Key:       nil,
+			Value:     value,
 		}
-		for _, partition := range partitions {
-			msg := &sarama.ProducerMessage{
-				Topic:     topic,
-				Partition: partition,
-				Key:       nil,
-				Value:     sarama.ByteEncoder(payload),
-			}
-			if err := s.emitMessage(ctx, msg); err != nil {
+		if s.resolvedNullValue {
+			msg.Timestamp = resolved.GoTime()
+			msg.Headers = []sarama.RecordHeader{{
+				Key: []byte(kafkaHeaderResolved), Value: []byte(resolved.AsOfSystemTime()),
+			}}
+		}
+		if s.sequencer != `` {
+			if err := s.sequence(msg); err != nil {
 				return err
 			}
 		}
+		if err := s.emitMessage(ctx, msg); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -290,6 +550,22 @@ func (s *kafkaSink) EmitResolvedTimestamp(
 func (s *kafkaSink) Flush(ctx context.Context) error {
 	defer s.metrics.recordFlushRequestCallback()()
 
+	if err := s.waitForInflight(ctx); err != nil {
+		return err
+	}
+	return s.retryTooLarge(ctx)
+}
+
+// FlushDurable implements the SinkWithDurableAck interface. With the
+// durable_resolved option, the producer requires acknowledgements from all
+// in-sync replicas, so the messages acknowledged by Flush are durable.
+func (s *kafkaSink) FlushDurable(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
+// waitForInflight waits for the messages in flight to be acknowledged, and
+// returns the first error any of them failed with.
+func (s *kafkaSink) waitForInflight(ctx context.Context) error {
 	flushCh := make(chan struct{}, 1)
 
 	s.mu.Lock()
@@ -321,11 +597,104 @@ func (s *kafkaSink) Flush(ctx context.Context) error {
 	}
 }
 
+// kafkaResend is a message emitted again by Flush.
+type kafkaResend struct {
+	msg *sarama.ProducerMessage
+	// rejected is set if Kafka rejected the message, rather than
+	// acknowledging it after a rejected message of its partition.
+	rejected bool
+}
+
+// retryTooLarge emits the messages Kafka rejected for being batched in a
+// produce request larger than it accepts again, in smaller batches. The
+// producer can only batch messages in flight, so they're emitted a batch at a
+// time, waiting for each batch to be acknowledged before the next. The size
+// of the batches is halved every time some of their messages are rejected
+// again, until a message is rejected on its own, which fails the flush.
+//
+// Messages emitted after a rejected message to its partition may have been
+// written before it is retried. Kafka acknowledges the messages of a
+// partition in order, so every message acknowledged after a rejected one in
+// its partition is emitted again after it, and the partition ends with the
+// messages in their original order, as when the changefeed retries: a
+// consumer may see a message again, but never without the newer versions of
+// its row after it.
+func (s *kafkaSink) retryTooLarge(ctx context.Context) error {
+	msgs := s.takeResend()
+	for batchSize := len(msgs); len(msgs) > 0; {
+		if batchSize == 1 {
+			for _, r := range msgs {
+				if r.rejected {
+					return errors.Wrapf(sarama.ErrMessageSizeTooLarge,
+						`message of %d bytes to topic %s`, kafkaMessageSize(r.msg), r.msg.Topic)
+				}
+			}
+		}
+		batchSize = (batchSize + 1) / 2
+		if log.V(1) {
+			log.Infof(ctx, "retrying %d messages rejected by kafka in batches of %d", len(msgs), batchSize)
+		}
+		retry := msgs
+		for len(retry) > 0 {
+			n := batchSize
+			if n > len(retry) {
+				n = len(retry)
+			}
+			for _, r := range retry[:n] {
+				m := r.msg
+				// The producer owns the messages it was handed, so they're
+				// copied rather than emitted again.
+				if err := s.emitMessage(ctx, &sarama.ProducerMessage{
+					Topic:     m.Topic,
+					Partition: m.Partition,
+					Key:       m.Key,
+					Value:     m.Value,
+					Headers:   m.Headers,
+					Metadata:  m.Metadata,
+					Timestamp: m.Timestamp,
+				}); err != nil {
+					return err
+				}
+			}
+			retry = retry[n:]
+			if err := s.waitForInflight(ctx); err != nil {
+				return err
+			}
+		}
+		// The messages to emit again are only taken once all the messages of
+		// this round were acknowledged, since the messages of later batches
+		// may have to be emitted again after those rejected in earlier ones.
+		msgs = s.takeResend()
+	}
+	return nil
+}
+
+func (s *kafkaSink) takeResend() []kafkaResend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.mu.resend
+	s.mu.resend = nil
+	s.mu.resendPartitions = nil
+	return msgs
+}
+
+func kafkaMessageSize(m *sarama.ProducerMessage) int {
+	var size int
+	if m.Key != nil {
+		size += m.Key.Length()
+	}
+	if m.Value != nil {
+		size += m.Value.Length()
+	}
+	return size
+}
+
 func (s *kafkaSink) startInflightMessage(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.mu.inflight++
+	s.metrics.recordInflight(1)
 	if log.V(2) {
 		log.Infof(ctx, "emitting %d inflight records to kafka", s.mu.inflight)
 	}
@@ -333,6 +702,13 @@ func (s *kafkaSink) startInflightMessage(ctx context.Context) error {
 }
 
 func (s *kafkaSink) emitMessage(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if s.inflightSlots != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case s.inflightSlots <- struct{}{}:
+		}
+	}
 	if err := s.startInflightMessage(ctx); err != nil {
 		return err
 	}
@@ -362,16 +738,42 @@ func (s *kafkaSink) workerLoop() {
 			ackMsg, ackError = err.Msg, err.Err
 		}
 
+		// Messages rejected for the size of their batch are kept, along with
+		// their allocations, until they're emitted again by Flush, and so are
+		// the messages acknowledged after them in their partitions.
+		partition := kafkaPartition{topic: ackMsg.Topic, partition: ackMsg.Partition}
+		tooLarge := errors.Is(ackError, sarama.ErrMessageSizeTooLarge)
+		resend := tooLarge
+		if ackError == nil {
+			s.mu.Lock()
+			_, resend = s.mu.resendPartitions[partition]
+			s.mu.Unlock()
+		}
 		if m, ok := ackMsg.Metadata.(messageMetadata); ok {
 			if ackError == nil {
-				m.updateMetrics(1, m.mvcc, ackMsg.Key.Length()+ackMsg.Value.Length(), sinkDoesNotCompress)
+				m.updateMetrics(1, m.mvcc, kafkaMessageSize(ackMsg), sinkDoesNotCompress)
+			}
+			if !resend {
+				m.alloc.Release(s.ctx)
 			}
-			m.alloc.Release(s.ctx)
+		}
+
+		if s.inflightSlots != nil {
+			<-s.inflightSlots
 		}
 
 		s.mu.Lock()
 		s.mu.inflight--
-		if s.mu.flushErr == nil && ackError != nil {
+		s.metrics.recordInflight(-1)
+		if resend {
+			s.mu.resend = append(s.mu.resend, kafkaResend{msg: ackMsg, rejected: tooLarge})
+			if tooLarge {
+				if s.mu.resendPartitions == nil {
+					s.mu.resendPartitions = make(map[kafkaPartition]struct{})
+				}
+				s.mu.resendPartitions[partition] = struct{}{}
+			}
+		} else if s.mu.flushErr == nil && ackError != nil {
 			s.mu.flushErr = ackError
 		}
 
@@ -408,12 +810,35 @@ func (p *changefeedPartitioner) RequiresConsistency() bool { return true }
 func (p *changefeedPartitioner) Partition(
 	message *sarama.ProducerMessage, numPartitions int32,
 ) (int32, error) {
-	if message.Key == nil {
+	m, ok := message.Metadata.(messageMetadata)
+	if message.Key == nil || (ok && m.partitioned) {
 		return message.Partition, nil
 	}
+	if ok && m.timeBucket > 0 {
+		return timeBucketPartition(m.mvcc, m.timeBucket, numPartitions), nil
+	}
+	if ok && m.routingKey != nil {
+		return p.hash.Partition(&sarama.ProducerMessage{
+			Topic: message.Topic, Key: sarama.ByteEncoder(m.routingKey),
+		}, numPartitions)
+	}
 	return p.hash.Partition(message, numPartitions)
 }
 
+// timeBucketPartition returns the partition of a row committed at mvcc with
+// the partition_time_bucket option: the index of the bucket of the given size
+// its timestamp falls into, counted from the Unix epoch, modulo the number of
+// partitions. With hour long buckets and 24 partitions, the rows committed
+// in each hour of the day are in a partition of their own.
+//
+// Unlike the default partitioning by key, this doesn't keep the rows of a key
+// in one partition: a key updated in two buckets has rows in two partitions,
+// which consumers may read out of order. The option is rejected along with
+// those which depend on the rows of a key staying in one partition.
+func timeBucketPartition(mvcc hlc.Timestamp, bucket time.Duration, numPartitions int32) int32 {
+	return int32((mvcc.WallTime / bucket.Nanoseconds()) % int64(numPartitions))
+}
+
 func makeTopicsMap(
 	prefix string, name string, targets jobspb.ChangefeedTargets,
 ) map[descpb.ID]string {
@@ -643,9 +1068,88 @@ func buildKafkaConfig(u sinkURL, opts map[string]string) (*sarama.Config, error)
 	if err := saramaCfg.Apply(config); err != nil {
 		return nil, errors.Wrap(err, "failed to apply kafka client configuration")
 	}
+
+	if v, ok := opts[changefeedbase.OptKafkaIdempotent]; ok {
+		idempotent, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Errorf(`%s must be a boolean: %q`, changefeedbase.OptKafkaIdempotent, v)
+		}
+		if idempotent {
+			if err := applyIdempotentProducerConfig(config, saramaCfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Sequence numbers and resolved_null_value timestamps are carried in
+	// record headers.
+	for _, opt := range []string{changefeedbase.OptSequenceNumbers, changefeedbase.OptResolvedNullValue} {
+		if _, ok := opts[opt]; ok && !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return nil, errors.Errorf(`%s requires a Kafka version of at least %s, found %s in %s`,
+				opt, sarama.V0_11_0_0, config.Version, changefeedbase.OptKafkaSinkConfig)
+		}
+	}
+	if v, ok := opts[changefeedbase.OptKafkaRecordTimestamp]; ok {
+		switch changefeedbase.KafkaRecordTimestamp(v) {
+		case changefeedbase.OptKafkaRecordTimestampProduce:
+		case changefeedbase.OptKafkaRecordTimestampMVCC:
+			// Records only carry timestamps as of the v1 message format.
+			if !config.Version.IsAtLeast(sarama.V0_10_0_0) {
+				return nil, errors.Errorf(`%s='%s' requires a Kafka version of at least %s, found %s in %s`,
+					changefeedbase.OptKafkaRecordTimestamp, v, sarama.V0_10_0_0, config.Version,
+					changefeedbase.OptKafkaSinkConfig)
+			}
+		default:
+			return nil, errors.Errorf(`unknown %s: %s`, changefeedbase.OptKafkaRecordTimestamp, v)
+		}
+	}
+	if _, ok := opts[changefeedbase.OptDurableResolved]; ok {
+		// Messages are only durable once they're replicated to all in-sync
+		// replicas.
+		if saramaCfg.RequiredAcks != "" {
+			if acks, err := parseRequiredAcks(saramaCfg.RequiredAcks); err != nil || acks != sarama.WaitForAll {
+				return nil, errors.Errorf(`%s requires RequiredAcks to be "ALL" in %s`,
+					changefeedbase.OptDurableResolved, changefeedbase.OptKafkaSinkConfig)
+			}
+		}
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	}
 	return config, nil
 }
 
+// applyIdempotentProducerConfig enables sarama's idempotent producer, which
+// has the brokers discard the duplicates of a record that the producer's
+// retries would otherwise write. Duplicates are still emitted when the
+// changefeed itself retries, e.g. after a restart, since that would require
+// transactions: records written since the last resolved timestamp would have
+// to be committed with it, and aborted if the changefeed restarts before it.
+// The version of sarama we use can't produce transactional records (it only
+// initializes producer IDs for idempotence), so neither the sink as a whole
+// nor the topics of particular targets can be written transactionally.
+//
+// The idempotent producer requires acknowledgements from all in-sync replicas
+// and at most one in-flight request per broker, which reduces throughput,
+// especially to brokers with a high latency.
+func applyIdempotentProducerConfig(config *sarama.Config, saramaCfg *saramaConfig) error {
+	if saramaCfg.RequiredAcks != "" {
+		if acks, err := parseRequiredAcks(saramaCfg.RequiredAcks); err != nil || acks != sarama.WaitForAll {
+			return errors.Errorf(`%s requires RequiredAcks to be "ALL" in %s`,
+				changefeedbase.OptKafkaIdempotent, changefeedbase.OptKafkaSinkConfig)
+		}
+	}
+	if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return errors.Errorf(`%s requires a Kafka version of at least %s, found %s in %s`,
+			changefeedbase.OptKafkaIdempotent, sarama.V0_11_0_0, config.Version,
+			changefeedbase.OptKafkaSinkConfig)
+	}
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Net.MaxOpenRequests = 1
+	if config.Producer.Retry.Max == 0 {
+		config.Producer.Retry.Max = 1
+	}
+	return nil
+}
+
 func makeKafkaSink(
 	ctx context.Context,
 	u sinkURL,
@@ -669,9 +1173,67 @@ func makeKafkaSink(
 		kafkaCfg:       config,
 		bootstrapAddrs: u.Host,
 		topics:         makeTopicsMap(kafkaTopicPrefix, kafkaTopicName, targets),
+		topicPrefix:    kafkaTopicPrefix,
 		metrics:        m,
 	}
 
+	if v, ok := opts[changefeedbase.OptSinkConcurrency]; ok {
+		concurrency, err := strconv.Atoi(v)
+		if err != nil || concurrency <= 0 {
+			return nil, errors.Errorf(`%s must be a positive integer: %q`,
+				changefeedbase.OptSinkConcurrency, v)
+		}
+		sink.inflightSlots = make(chan struct{}, concurrency)
+	}
+	sink.mvccRecordTimestamps = changefeedbase.KafkaRecordTimestamp(
+		opts[changefeedbase.OptKafkaRecordTimestamp]) == changefeedbase.OptKafkaRecordTimestampMVCC
+	if v, ok := opts[changefeedbase.OptPartitionTimeBucket]; ok {
+		bucket, err := time.ParseDuration(v)
+		if err != nil || bucket <= 0 {
+			return nil, errors.Errorf(`%s must be a positive duration: %q`,
+				changefeedbase.OptPartitionTimeBucket, v)
+		}
+		for _, opt := range []string{
+			changefeedbase.OptShardAwareRouting, changefeedbase.OptCompactionTombstones,
+		} {
+			if _, ok := opts[opt]; ok {
+				return nil, errors.Errorf(
+					`%s is not usable with %s, which requires the rows of a key to stay in one partition`,
+					changefeedbase.OptPartitionTimeBucket, opt)
+			}
+		}
+		sink.partitionTimeBucket = bucket
+	}
+	_, sink.resolvedNullValue = opts[changefeedbase.OptResolvedNullValue]
+
+	if resolvedTopic := u.consumeParam(changefeedbase.SinkParamResolvedTopic); resolvedTopic != `` {
+		if _, ok := opts[changefeedbase.OptResolvedTimestamps]; !ok {
+			return nil, errors.Errorf(`%s requires the %s option`,
+				changefeedbase.SinkParamResolvedTopic, changefeedbase.OptResolvedTimestamps)
+		}
+		if err := validateKafkaTopicName(resolvedTopic); err != nil {
+			return nil, errors.Wrapf(err, `invalid %s`, changefeedbase.SinkParamResolvedTopic)
+		}
+		for _, topic := range sink.topics {
+			if topic == resolvedTopic {
+				return nil, errors.Errorf(`%s %q is also used for changefeed rows`,
+					changefeedbase.SinkParamResolvedTopic, resolvedTopic)
+			}
+		}
+		sink.resolvedTopic = resolvedTopic
+	}
+
+	// The topics derived by topic_from_column are only known to the change
+	// aggregators which route rows to them, so resolved timestamps can't be
+	// emitted to them.
+	_, topicFromColumn := opts[changefeedbase.OptTopicFromColumn]
+	_, resolved := opts[changefeedbase.OptResolvedTimestamps]
+	if topicFromColumn && resolved && sink.resolvedTopic == `` {
+		return nil, errors.Errorf(`%s with %s requires the %s sink parameter`,
+			changefeedbase.OptTopicFromColumn, changefeedbase.OptResolvedTimestamps,
+			changefeedbase.SinkParamResolvedTopic)
+	}
+
 	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
 		return nil, errors.Errorf(
 			`unknown kafka sink query parameters: %s`, strings.Join(unknownParams, ", "))
@@ -679,3 +1241,25 @@ func makeKafkaSink(
 
 	return sink, nil
 }
+
+// maxKafkaTopicNameLen is the maximum length of a kafka topic name.
+const maxKafkaTopicNameLen = 249
+
+// validateKafkaTopicName returns an error if the given name can't be used as
+// a kafka topic. Valid names consist of at most 249 ASCII alphanumerics, '.',
+// '_' and '-' characters, and are neither "." nor "..".
+func validateKafkaTopicName(name string) error {
+	if name == `.` || name == `..` {
+		return errors.Errorf(`topic name %q is not allowed`, name)
+	}
+	if len(name) > maxKafkaTopicNameLen {
+		return errors.Errorf(`topic name %q is longer than %d characters`, name, maxKafkaTopicNameLen)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '.' || r == '_' || r == '-') {
+			return errors.Errorf(`topic name %q contains the illegal character %q`, name, r)
+		}
+	}
+	return nil
+}
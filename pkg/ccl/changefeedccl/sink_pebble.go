@@ -0,0 +1,364 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"net/url"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+)
+
+// The pebble sink stores the latest value of each key, and the highest
+// resolved timestamp, in a Pebble store in a directory of the local
+// filesystem of each node running the changefeed, e.g. pebble:///cdc. Like
+// the paths of file sinks, the directory is relative to the external IO
+// directory of the node. It's meant for test harnesses and for consumers
+// which want a durable copy of a feed without an external system, and is read
+// with PebbleSinkStore.
+//
+// All integers are big-endian. The rows are stored under the keys
+//
+//   0x01, the topic, 0x00, then the encoded key of the row
+//
+// each holding
+//
+//   updated   int64 wall time, then int32 logical time
+//   mvcc      int64 wall time, then int32 logical time
+//   value     the bytes of the encoded value
+//
+// A row replaces the stored row of its key unless that one has a higher mvcc
+// timestamp, so that rows emitted again as the changefeed retries don't roll
+// back newer ones. Deletions are stored as their encoded value, which for
+// example is {"after": null} with the JSON wrapped envelope. The resolved
+// timestamp is stored under the key 0x02, as an int64 wall time then int32
+// logical time, and is only ever forwarded.
+//
+// Rows are buffered in memory, their allocations held against the memory
+// budget of the changefeed, and written to the store, synced, by Flush.
+
+const (
+	pebbleSinkRowPrefix   = 0x01
+	pebbleSinkResolvedKey = 0x02
+
+	// pebbleSinkTimestampLen is the length of an encoded timestamp.
+	pebbleSinkTimestampLen = 12
+)
+
+func isPebbleSink(u *url.URL) bool {
+	return u.Scheme == changefeedbase.SinkSchemePebble
+}
+
+// pebbleSink emits to a PebbleSinkStore.
+type pebbleSink struct {
+	dir   string
+	store *PebbleSinkStore
+	// pending holds the rows emitted since the last flush, and alloc their
+	// memory, released once they're written.
+	pending []PebbleSinkRow
+	alloc   kvevent.Alloc
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*pebbleSink)(nil)
+
+func makePebbleSink(u sinkURL, externalIODir string, m *sliMetrics) (Sink, error) {
+	if u.Host != `` {
+		return nil, errors.Errorf(`pebble sink URL must not have a host, found %q`, u.Host)
+	}
+	if u.Path == `` {
+		return nil, errors.Errorf(`directory of the store must be specified for pebble sink`)
+	}
+	dir, err := fileSinkPath(externalIODir, u.Path)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown pebble sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	return &pebbleSink{dir: dir, metrics: m}, nil
+}
+
+// Dial implements the Sink interface.
+func (s *pebbleSink) Dial() error {
+	store, err := OpenPebbleSinkStore(s.dir)
+	if err != nil {
+		return err
+	}
+	s.store = store
+	return nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *pebbleSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	if s.store == nil {
+		alloc.Release(ctx)
+		return errors.New(`pebble sink is not open`)
+	}
+	s.alloc.Merge(&alloc)
+	s.pending = append(s.pending, PebbleSinkRow{
+		Topic:   topicDescr.GetName(),
+		Key:     append([]byte(nil), key...),
+		Value:   append([]byte(nil), value...),
+		Updated: updated,
+		MVCC:    mvcc,
+	})
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *pebbleSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	if s.store == nil {
+		return errors.New(`pebble sink is not open`)
+	}
+	return s.store.forwardResolved(resolved)
+}
+
+// Flush implements the Sink interface.
+func (s *pebbleSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+
+	if s.store == nil {
+		return errors.New(`pebble sink is not open`)
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := s.store.writeRows(s.pending); err != nil {
+		return err
+	}
+	s.pending = s.pending[:0]
+	s.alloc.Release(ctx)
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *pebbleSink) Close() error {
+	if s.store == nil {
+		return nil
+	}
+	err := s.store.Close()
+	s.store, s.pending = nil, nil
+	s.alloc.Release(context.Background())
+	return err
+}
+
+// PebbleSinkRow is a row stored by the pebble sink.
+type PebbleSinkRow struct {
+	Topic         string
+	Key, Value    []byte
+	Updated, MVCC hlc.Timestamp
+}
+
+// PebbleSinkStore is the store of the pebble sink in a directory. Pebble only
+// lets a directory be opened once, so the sinks of the change aggregators and
+// the change frontier running on a node, and the readers in the same process,
+// share a PebbleSinkStore, which is closed once all of them have closed it.
+// Other processes can read the store once the changefeed has stopped.
+type PebbleSinkStore struct {
+	dir string
+	db  *pebble.DB
+	// refs is the number of opens not yet closed, guarded by the mutex of
+	// pebbleSinkStores.
+	refs int
+
+	// writeMu serializes the writes which read the stored rows and resolved
+	// timestamp before replacing them.
+	writeMu syncutil.Mutex
+}
+
+// pebbleSinkStores holds the open stores of the process by directory.
+var pebbleSinkStores struct {
+	syncutil.Mutex
+	stores map[string]*PebbleSinkStore
+}
+
+// OpenPebbleSinkStore opens the store of the pebble sink in dir, creating it
+// if it doesn't exist. It must be closed.
+func OpenPebbleSinkStore(dir string) (*PebbleSinkStore, error) {
+	pebbleSinkStores.Lock()
+	defer pebbleSinkStores.Unlock()
+	if s, ok := pebbleSinkStores.stores[dir]; ok {
+		s.refs++
+		return s, nil
+	}
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, errors.Wrapf(err, `opening pebble sink store at %s`, dir)
+	}
+	s := &PebbleSinkStore{dir: dir, db: db, refs: 1}
+	if pebbleSinkStores.stores == nil {
+		pebbleSinkStores.stores = make(map[string]*PebbleSinkStore)
+	}
+	pebbleSinkStores.stores[dir] = s
+	return s, nil
+}
+
+// Close releases the store, closing it once every open has been closed.
+func (s *PebbleSinkStore) Close() error {
+	pebbleSinkStores.Lock()
+	defer pebbleSinkStores.Unlock()
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	delete(pebbleSinkStores.stores, s.dir)
+	return s.db.Close()
+}
+
+// Get returns the stored row of key in topic, and whether there is one.
+func (s *PebbleSinkStore) Get(topic string, key []byte) (PebbleSinkRow, bool, error) {
+	value, closer, err := s.db.Get(pebbleSinkRowKey(topic, key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return PebbleSinkRow{}, false, nil
+	} else if err != nil {
+		return PebbleSinkRow{}, false, err
+	}
+	defer closer.Close()
+	row, err := decodePebbleSinkRow(topic, key, value)
+	return row, err == nil, err
+}
+
+// Rows returns the stored rows of topic, ordered by their encoded keys.
+func (s *PebbleSinkStore) Rows(topic string) ([]PebbleSinkRow, error) {
+	prefix := pebbleSinkRowKey(topic, nil)
+	upper := append([]byte(nil), prefix...)
+	upper[len(upper)-1]++
+	it := s.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: upper})
+	var rows []PebbleSinkRow
+	for valid := it.First(); valid; valid = it.Next() {
+		key := append([]byte(nil), it.Key()[len(prefix):]...)
+		row, err := decodePebbleSinkRow(topic, key, it.Value())
+		if err != nil {
+			_ = it.Close()
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, it.Close()
+}
+
+// Resolved returns the highest resolved timestamp stored, or the zero
+// timestamp if none has been.
+func (s *PebbleSinkStore) Resolved() (hlc.Timestamp, error) {
+	value, closer, err := s.db.Get([]byte{pebbleSinkResolvedKey})
+	if errors.Is(err, pebble.ErrNotFound) {
+		return hlc.Timestamp{}, nil
+	} else if err != nil {
+		return hlc.Timestamp{}, err
+	}
+	defer closer.Close()
+	if len(value) != pebbleSinkTimestampLen {
+		return hlc.Timestamp{}, errors.Errorf(`malformed resolved timestamp in pebble sink store`)
+	}
+	return decodePebbleSinkTimestamp(value), nil
+}
+
+// writeRows writes rows, in order, and syncs them.
+func (s *PebbleSinkStore) writeRows(rows []PebbleSinkRow) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// The batch is indexed so that the rows written by it are read back when
+	// a key is emitted more than once.
+	b := s.db.NewIndexedBatch()
+	defer b.Close()
+	for _, row := range rows {
+		key := pebbleSinkRowKey(row.Topic, row.Key)
+		stored, closer, err := b.Get(key)
+		if err == nil {
+			newer := len(stored) >= 2*pebbleSinkTimestampLen &&
+				row.MVCC.Less(decodePebbleSinkTimestamp(stored[pebbleSinkTimestampLen:]))
+			_ = closer.Close()
+			if newer {
+				continue
+			}
+		} else if !errors.Is(err, pebble.ErrNotFound) {
+			return err
+		}
+		value := make([]byte, 0, 2*pebbleSinkTimestampLen+len(row.Value))
+		value = appendPebbleSinkTimestamp(value, row.Updated)
+		value = appendPebbleSinkTimestamp(value, row.MVCC)
+		value = append(value, row.Value...)
+		if err := b.Set(key, value, nil); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(b.Commit(pebble.Sync), `writing to pebble sink store`)
+}
+
+// forwardResolved stores resolved unless a higher resolved timestamp is
+// stored.
+func (s *PebbleSinkStore) forwardResolved(resolved hlc.Timestamp) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	stored, err := s.Resolved()
+	if err != nil || resolved.LessEq(stored) {
+		return err
+	}
+	value := appendPebbleSinkTimestamp(nil, resolved)
+	return errors.Wrap(s.db.Set([]byte{pebbleSinkResolvedKey}, value, pebble.Sync),
+		`writing to pebble sink store`)
+}
+
+func pebbleSinkRowKey(topic string, key []byte) []byte {
+	k := make([]byte, 0, len(topic)+len(key)+2)
+	k = append(k, pebbleSinkRowPrefix)
+	k = append(k, topic...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+func decodePebbleSinkRow(topic string, key, value []byte) (PebbleSinkRow, error) {
+	if len(value) < 2*pebbleSinkTimestampLen {
+		return PebbleSinkRow{}, errors.Errorf(`malformed row in pebble sink store`)
+	}
+	return PebbleSinkRow{
+		Topic:   topic,
+		Key:     key,
+		Value:   append([]byte(nil), value[2*pebbleSinkTimestampLen:]...),
+		Updated: decodePebbleSinkTimestamp(value),
+		MVCC:    decodePebbleSinkTimestamp(value[pebbleSinkTimestampLen:]),
+	}, nil
+}
+
+func appendPebbleSinkTimestamp(buf []byte, ts hlc.Timestamp) []byte {
+	var b [pebbleSinkTimestampLen]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(ts.WallTime))
+	binary.BigEndian.PutUint32(b[8:], uint32(ts.Logical))
+	return append(buf, b[:]...)
+}
+
+func decodePebbleSinkTimestamp(b []byte) hlc.Timestamp {
+	return hlc.Timestamp{
+		WallTime: int64(binary.BigEndian.Uint64(b[:8])),
+		Logical:  int32(binary.BigEndian.Uint32(b[8:12])),
+	}
+}
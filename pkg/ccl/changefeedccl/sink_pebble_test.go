@@ -0,0 +1,127 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPebbleSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+
+	makeTopic := func(name string) tableDescriptorTopic {
+		return tableDescriptorTopic{
+			tabledesc.NewBuilder(&descpb.TableDescriptor{Name: name, ID: 52}).BuildImmutableTable()}
+	}
+	makeSink := func(t *testing.T) Sink {
+		sink, err := makePebbleSink(sinkURL{URL: &url.URL{Scheme: `pebble`, Path: `/store`}}, dir, nil)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+	ts := func(i int64) hlc.Timestamp { return hlc.Timestamp{WallTime: i} }
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := makeSink(t)
+		// Two sinks share the store, as on a node running several aggregators.
+		other := makeSink(t)
+		foo, bar := makeTopic(`foo`), makeTopic(`bar`)
+
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"a":1}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[2]`), []byte(`{"a":2}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"a":3}`), ts(2), ts(3), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, bar, []byte(`[1]`), []byte(`{"b":1}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, sink.Flush(ctx))
+		// An older row, emitted again, doesn't replace the newer one.
+		require.NoError(t, other.EmitRow(ctx, foo, []byte(`[1]`), []byte(`{"a":1}`), ts(1), ts(1), zeroAlloc))
+		require.NoError(t, other.Flush(ctx))
+		require.NoError(t, other.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts(4)))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts(3)))
+
+		require.NoError(t, other.Close())
+		require.NoError(t, sink.Close())
+	})
+
+	t.Run(`read`, func(t *testing.T) {
+		store, err := OpenPebbleSinkStore(filepath.Join(dir, `store`))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, store.Close()) }()
+
+		rows, err := store.Rows(`foo`)
+		require.NoError(t, err)
+		require.Equal(t, []PebbleSinkRow{
+			{Topic: `foo`, Key: []byte(`[1]`), Value: []byte(`{"a":3}`), Updated: ts(2), MVCC: ts(3)},
+			{Topic: `foo`, Key: []byte(`[2]`), Value: []byte(`{"a":2}`), Updated: ts(1), MVCC: ts(1)},
+		}, rows)
+
+		row, ok, err := store.Get(`bar`, []byte(`[1]`))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(`{"b":1}`), row.Value)
+		_, ok, err = store.Get(`bar`, []byte(`[2]`))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		resolved, err := store.Resolved()
+		require.NoError(t, err)
+		require.Equal(t, ts(4), resolved)
+	})
+
+	t.Run(`memory`, func(t *testing.T) {
+		var pool testAllocPool
+		sink := makeSink(t)
+		baz := makeTopic(`baz`)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, sink.EmitRow(ctx, baz, []byte(`[1]`), []byte(`{"c":1}`), ts(1), ts(1), pool.alloc()))
+		}
+		// The memory of the rows is held until they're written.
+		require.EqualValues(t, 3, pool.used())
+		require.NoError(t, sink.Flush(ctx))
+		require.EqualValues(t, 0, pool.used())
+
+		require.NoError(t, sink.EmitRow(ctx, baz, []byte(`[2]`), []byte(`{"c":2}`), ts(1), ts(1), pool.alloc()))
+		require.NoError(t, sink.Close())
+		require.EqualValues(t, 0, pool.used())
+	})
+
+	t.Run(`invalid params`, func(t *testing.T) {
+		for uri, expectedErr := range map[string]string{
+			`pebble://localhost/cdc`: `pebble sink URL must not have a host, found "localhost"`,
+			`pebble://`:              `directory of the store must be specified for pebble sink`,
+			`pebble:///cdc?foo=bar`:  `unknown pebble sink query parameters: foo`,
+			`pebble:///../escape`:    `local file access to paths outside of external-io-dir is not allowed: /../escape`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makePebbleSink(sinkURL{URL: u}, dir, nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+
+		u, err := url.Parse(`pebble:///cdc`)
+		require.NoError(t, err)
+		_, err = makePebbleSink(sinkURL{URL: u}, ``, nil)
+		require.EqualError(t, err, `local file access is disabled`)
+	})
+}
@@ -267,6 +267,13 @@ func (p *pubsubSink) Flush(ctx context.Context) error {
 
 }
 
+// FlushDurable implements the SinkWithDurableAck interface. Flush waits for
+// the results of the publish calls, which are only successful once the
+// messages are stored by Pub/Sub.
+func (p *pubsubSink) FlushDurable(ctx context.Context) error {
+	return p.Flush(ctx)
+}
+
 // Close closes all the channels and shutdowns the topic
 func (p *pubsubSink) Close() error {
 	p.client.closeTopics()
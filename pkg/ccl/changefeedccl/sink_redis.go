@@ -0,0 +1,397 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	redisDefaultPort = `6379`
+	// redisDialTimeout bounds the time spent establishing a connection.
+	redisDialTimeout = 10 * time.Second
+	// redisMaxPendingReplies bounds the number of XADD commands which are
+	// pipelined before their replies are read, so that a long stretch of
+	// EmitRow calls without a Flush does not buffer unbounded replies.
+	redisMaxPendingReplies = 1024
+)
+
+func isRedisSink(u *url.URL) bool {
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeRedis, changefeedbase.SinkSchemeRedisTLS:
+		return true
+	default:
+		return false
+	}
+}
+
+// redisSink emits to Redis Streams. Each row is appended with XADD to a stream
+// named after its table, with the encoded key and value stored in the `key`
+// and `value` fields of the stream entry. Resolved timestamps are appended to
+// every stream with a single `resolved` field.
+//
+// Commands are pipelined: EmitRow writes XADD commands to the connection
+// without waiting for their replies, and Flush waits for all outstanding
+// replies.
+type redisSink struct {
+	addr      string
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	db        int
+	// maxLen, if positive, approximately caps the length of each stream using
+	// XADD's MAXLEN ~ option.
+	maxLen int64
+
+	streams     map[string]struct{}
+	targetNames map[descpb.ID]string
+
+	conn    net.Conn
+	w       *bufio.Writer
+	r       *bufio.Reader
+	pending int
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*redisSink)(nil)
+
+func makeRedisSink(u sinkURL, targets jobspb.ChangefeedTargets, m *sliMetrics) (Sink, error) {
+	host, port := u.Hostname(), u.Port()
+	if host == `` {
+		return nil, errors.Errorf(`host must be specified for redis sink`)
+	}
+	if port == `` {
+		port = redisDefaultPort
+	}
+
+	sink := &redisSink{
+		addr:        net.JoinHostPort(host, port),
+		streams:     make(map[string]struct{}),
+		targetNames: make(map[descpb.ID]string),
+		metrics:     m,
+	}
+
+	if u.User != nil {
+		sink.username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			sink.password = password
+		} else {
+			// A URI of the form redis://password@host authenticates with the
+			// legacy single-argument AUTH.
+			sink.username, sink.password = ``, u.User.Username()
+		}
+	}
+
+	if db := strings.TrimPrefix(u.Path, `/`); db != `` {
+		var err error
+		if sink.db, err = strconv.Atoi(db); err != nil || sink.db < 0 {
+			return nil, errors.Errorf(`invalid redis database %q`, db)
+		}
+	}
+
+	if maxLen := u.consumeParam(changefeedbase.SinkParamRedisMaxLen); maxLen != `` {
+		var err error
+		if sink.maxLen, err = strconv.ParseInt(maxLen, 10, 64); err != nil || sink.maxLen <= 0 {
+			return nil, errors.Errorf(`param %s must be a positive integer: %q`,
+				changefeedbase.SinkParamRedisMaxLen, maxLen)
+		}
+	}
+
+	var tlsSkipVerify bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamSkipTLSVerify, &tlsSkipVerify); err != nil {
+		return nil, err
+	}
+	var caCert []byte
+	if err := u.decodeBase64(changefeedbase.SinkParamCACert, &caCert); err != nil {
+		return nil, err
+	}
+	if u.Scheme == changefeedbase.SinkSchemeRedisTLS {
+		var err error
+		if sink.tlsConfig, err = makeTLSConfig(host, caCert, tlsSkipVerify); err != nil {
+			return nil, err
+		}
+	} else if tlsSkipVerify || caCert != nil {
+		return nil, errors.Errorf(`%s and %s require the %s scheme`,
+			changefeedbase.SinkParamSkipTLSVerify, changefeedbase.SinkParamCACert,
+			changefeedbase.SinkSchemeRedisTLS)
+	}
+
+	topicPrefix := u.consumeParam(changefeedbase.SinkParamTopicPrefix)
+	for id, t := range targets {
+		stream := topicPrefix + t.StatementTimeName
+		sink.streams[stream] = struct{}{}
+		sink.targetNames[id] = stream
+	}
+
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown redis sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	return sink, nil
+}
+
+// Dial implements the Sink interface.
+func (s *redisSink) Dial() error {
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, `tcp`, s.addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(`tcp`, s.addr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, `connecting to redis at %s`, s.addr)
+	}
+	s.conn = conn
+	s.w = bufio.NewWriter(conn)
+	s.r = bufio.NewReader(conn)
+
+	if s.password != `` {
+		args := [][]byte{[]byte(`AUTH`)}
+		if s.username != `` {
+			args = append(args, []byte(s.username))
+		}
+		args = append(args, []byte(s.password))
+		if err := s.do(args...); err != nil {
+			_ = s.Close()
+			return errors.Wrap(err, `authenticating to redis`)
+		}
+	}
+	if s.db != 0 {
+		if err := s.do([]byte(`SELECT`), []byte(strconv.Itoa(s.db))); err != nil {
+			_ = s.Close()
+			return errors.Wrapf(err, `selecting redis database %d`, s.db)
+		}
+	}
+	return nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *redisSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer alloc.Release(ctx)
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	stream, ok := s.targetNames[topicDescr.GetID()]
+	if !ok {
+		return errors.Errorf(`cannot emit to undeclared topic: %s`, topicDescr.GetName())
+	}
+	return s.xadd(stream, []byte(`key`), key, []byte(`value`), value)
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *redisSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	for stream := range s.streams {
+		payload, err := encoder.EncodeResolvedTimestamp(ctx, stream, resolved)
+		if err != nil {
+			return err
+		}
+		if err := s.xadd(stream, []byte(`resolved`), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush implements the Sink interface.
+func (s *redisSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	return s.awaitReplies()
+}
+
+// Close implements the Sink interface.
+func (s *redisSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// xadd pipelines an XADD of the given field/value pairs to the stream.
+func (s *redisSink) xadd(stream string, fieldsAndValues ...[]byte) error {
+	args := make([][]byte, 0, 6+len(fieldsAndValues))
+	args = append(args, []byte(`XADD`), []byte(stream))
+	if s.maxLen > 0 {
+		args = append(args, []byte(`MAXLEN`), []byte(`~`), []byte(strconv.FormatInt(s.maxLen, 10)))
+	}
+	args = append(args, []byte(`*`))
+	args = append(args, fieldsAndValues...)
+	if err := writeRESPCommand(s.w, args...); err != nil {
+		return err
+	}
+	s.pending++
+	if s.pending >= redisMaxPendingReplies {
+		return s.awaitReplies()
+	}
+	return nil
+}
+
+// awaitReplies writes any buffered commands and reads the replies for all
+// pipelined commands, returning the first error reply.
+func (s *redisSink) awaitReplies() error {
+	if s.conn == nil {
+		return errors.New(`redis sink is not connected`)
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for ; s.pending > 0; s.pending-- {
+		if _, err := readRESPReply(s.r); err != nil {
+			if !errors.HasType(err, redisError(``)) {
+				// The connection is in an unknown state; the changefeed must
+				// reconnect before emitting again.
+				s.pending = 0
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// do sends a single command and waits for its reply.
+func (s *redisSink) do(args ...[]byte) error {
+	if err := writeRESPCommand(s.w, args...); err != nil {
+		return err
+	}
+	s.pending++
+	return s.awaitReplies()
+}
+
+// redisError is an error reply returned by the redis server.
+type redisError string
+
+func (e redisError) Error() string {
+	return `redis: ` + string(e)
+}
+
+// writeRESPCommand writes a command as a RESP array of bulk strings.
+func writeRESPCommand(w *bufio.Writer, args ...[]byte) error {
+	var scratch [20]byte
+	if err := w.WriteByte('*'); err != nil {
+		return err
+	}
+	if _, err := w.Write(strconv.AppendInt(scratch[:0], int64(len(args)), 10)); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := w.WriteByte('$'); err != nil {
+			return err
+		}
+		if _, err := w.Write(strconv.AppendInt(scratch[:0], int64(len(arg)), 10)); err != nil {
+			return err
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if _, err := w.Write(arg); err != nil {
+			return err
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRESPReply reads a single RESP value. Simple strings, integers and bulk
+// strings are returned as []byte (nil for a null bulk string) and arrays as
+// []interface{}. Error replies are returned as a redisError.
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New(`redis: empty reply`)
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, errors.Wrap(err, `redis: invalid bulk string length`)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, errors.Wrap(err, `redis: invalid array length`)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = readRESPReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	default:
+		return nil, errors.Errorf(`redis: unexpected reply type %q`, line[0])
+	}
+}
+
+// readRESPLine reads a CRLF terminated line, without the terminator.
+func readRESPLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New(`redis: malformed reply line`)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedisServer accepts connections and records the commands it receives,
+// replying as redis would to AUTH, SELECT and XADD. XADDs to the stream named
+// `fail` receive an error reply.
+type fakeRedisServer struct {
+	ln net.Listener
+
+	mu struct {
+		syncutil.Mutex
+		commands []string
+	}
+}
+
+func startFakeRedisServer(t *testing.T) *fakeRedisServer {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	require.NoError(t, err)
+	s := &fakeRedisServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for id := 1; ; id++ {
+		req, err := readRESPReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range req.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+		s.mu.Lock()
+		s.mu.commands = append(s.mu.commands, strings.Join(args, ` `))
+		s.mu.Unlock()
+
+		switch {
+		case args[0] == `XADD` && args[1] == `fail`:
+			_, _ = w.WriteString("-ERR cannot append to stream\r\n")
+		case args[0] == `XADD`:
+			entryID := fmt.Sprintf(`%d-0`, id)
+			_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(entryID), entryID)
+		default:
+			_, _ = w.WriteString("+OK\r\n")
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeRedisServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mu.commands...)
+}
+
+func TestRedisSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	server := startFakeRedisServer(t)
+	defer server.ln.Close()
+
+	makeTopic := func(name string, id descpb.ID) tableDescriptorTopic {
+		return tableDescriptorTopic{
+			tabledesc.NewBuilder(&descpb.TableDescriptor{Name: name, ID: id}).BuildImmutableTable()}
+	}
+	fooTopic, failTopic := makeTopic(`foo`, 52), makeTopic(`fail`, 53)
+	targets := jobspb.ChangefeedTargets{
+		fooTopic.GetID():  jobspb.ChangefeedTarget{StatementTimeName: `foo`},
+		failTopic.GetID(): jobspb.ChangefeedTarget{StatementTimeName: `fail`},
+	}
+
+	makeSink := func(t *testing.T, uri string) Sink {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeRedisSink(sinkURL{URL: u}, targets, nil)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := makeSink(t, fmt.Sprintf(`redis://user:pass@%s/2?maxlen=100`, server.ln.Addr()))
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[1]`), []byte(`{"a":1}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[2]`), []byte(`{"a":2}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, sink.Flush(ctx))
+
+		require.Equal(t, []string{
+			`AUTH user pass`,
+			`SELECT 2`,
+			`XADD foo MAXLEN ~ 100 * key [1] value {"a":1}`,
+			`XADD foo MAXLEN ~ 100 * key [2] value {"a":2}`,
+		}, server.commands()[:4])
+	})
+
+	t.Run(`error reply`, func(t *testing.T) {
+		sink := makeSink(t, fmt.Sprintf(`redis://%s`, server.ln.Addr()))
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, failTopic, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, fooTopic, []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.EqualError(t, sink.Flush(ctx), `redis: ERR cannot append to stream`)
+		// Replies for all pipelined commands were consumed.
+		require.NoError(t, sink.Flush(ctx))
+	})
+
+	t.Run(`invalid params`, func(t *testing.T) {
+		for uri, expectedErr := range map[string]string{
+			`redis://localhost?maxlen=0`:                      `param maxlen must be a positive integer: "0"`,
+			`redis://localhost/db`:                            `invalid redis database "db"`,
+			`redis://localhost?insecure_tls_skip_verify=true`: `insecure_tls_skip_verify and ca_cert require the rediss scheme`,
+			`redis://localhost?foo=bar`:                       `unknown redis sink query parameters: foo`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeRedisSink(sinkURL{URL: u}, targets, nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+	})
+}
@@ -9,14 +9,18 @@
 package changefeedccl
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	gosql "database/sql"
 	"fmt"
 	"hash"
 	"hash/fnv"
+	"io/ioutil"
 	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
@@ -33,10 +37,14 @@ const (
 		message_id INT,
 		key BYTES, value BYTES,
 		resolved BYTES,
+		compression STRING,
 		PRIMARY KEY (topic, partition, message_id)
 	)`
-	sqlSinkEmitStmt = `INSERT INTO "%s" (topic, partition, message_id, key, value, resolved)`
-	sqlSinkEmitCols = 6
+	// sqlSinkAddCompressionStmt adds the compression column to tables
+	// created before it existed, which compressing sinks write to.
+	sqlSinkAddCompressionStmt = `ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS compression STRING`
+	sqlSinkEmitStmt           = `INSERT INTO "%s" (topic, partition, message_id, key, value, resolved%s)`
+	sqlSinkEmitCols           = 6
 	// Some amount of batching to mirror a bit how kafkaSink works.
 	sqlSinkRowBatchSize = 3
 	// While sqlSink is only used for testing, hardcode the number of
@@ -52,6 +60,12 @@ const (
 // table gets 3 partitions. Similar to kafkaSink, the order between two emits is
 // only preserved if they are emitted to by the same node and to the same
 // partition.
+//
+// With the compression option, the value and resolved columns are compressed,
+// and the compression column names the codec they're compressed with. It is
+// NULL for uncompressed rows, which are written without it, so that tables
+// created before the column existed can still be written to by sinks which
+// don't compress. Sinks which do add it to such tables.
 type sqlSink struct {
 	db *gosql.DB
 
@@ -65,6 +79,9 @@ type sqlSink struct {
 
 	targetNames map[descpb.ID]string
 	metrics     *sliMetrics
+
+	// compression, if set, is the codec the payloads are compressed with.
+	compression string
 }
 
 // TODO(dan): Make tableName configurable or based on the job ID or
@@ -72,7 +89,11 @@ type sqlSink struct {
 const sqlSinkTableName = `sqlsink`
 
 func makeSQLSink(
-	u sinkURL, tableName string, targets jobspb.ChangefeedTargets, m *sliMetrics,
+	u sinkURL,
+	tableName string,
+	targets jobspb.ChangefeedTargets,
+	opts map[string]string,
+	m *sliMetrics,
 ) (Sink, error) {
 	// Swap the changefeed prefix for the sql connection one that sqlSink
 	// expects.
@@ -100,14 +121,21 @@ func makeSQLSink(
 			`unknown SQL sink query parameters: %s`, strings.Join(unknownParams, ", "))
 	}
 
-	return &sqlSink{
+	s := &sqlSink{
 		uri:         uri,
 		tableName:   tableName,
 		topics:      topics,
 		hasher:      fnv.New32a(),
 		targetNames: targetNames,
 		metrics:     m,
-	}, nil
+	}
+	if codec, ok := opts[changefeedbase.OptCompression]; ok && codec != "" {
+		if !strings.EqualFold(codec, sinkCompressionGzip) {
+			return nil, errors.Errorf(`unsupported compression codec %q`, codec)
+		}
+		s.compression = sinkCompressionGzip
+	}
+	return s, nil
 }
 
 func (s *sqlSink) Dial() error {
@@ -119,6 +147,12 @@ func (s *sqlSink) Dial() error {
 		db.Close()
 		return err
 	}
+	if s.compression != `` {
+		if _, err := db.Exec(fmt.Sprintf(sqlSinkAddCompressionStmt, s.tableName)); err != nil {
+			db.Close()
+			return err
+		}
+	}
 	s.db = db
 	return nil
 }
@@ -132,13 +166,23 @@ func (s *sqlSink) EmitRow(
 	alloc kvevent.Alloc,
 ) error {
 	defer alloc.Release(ctx)
-	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+	recordEmitted := s.metrics.recordEmittedMessages()
 
 	topic := s.targetNames[topicDescr.GetID()]
 	if _, ok := s.topics[topic]; !ok {
 		return errors.Errorf(`cannot emit to undeclared topic: %s`, topic)
 	}
 
+	emittedBytes, compressedBytes := len(key)+len(value), sinkDoesNotCompress
+	if s.compression != `` {
+		var err error
+		if value, err = s.compress(value); err != nil {
+			return err
+		}
+		compressedBytes = len(key) + len(value)
+	}
+	defer recordEmitted(1, mvcc, emittedBytes, compressedBytes)
+
 	// Hashing logic copied from sarama.HashPartitioner.
 	s.hasher.Reset()
 	if _, err := s.hasher.Write(key); err != nil {
@@ -165,7 +209,13 @@ func (s *sqlSink) EmitResolvedTimestamp(
 		if err != nil {
 			return err
 		}
-		s.scratch, payload = s.scratch.Copy(payload, 0 /* extraCap */)
+		if s.compression != `` {
+			if payload, err = s.compress(payload); err != nil {
+				return err
+			}
+		} else {
+			s.scratch, payload = s.scratch.Copy(payload, 0 /* extraCap */)
+		}
 		for partition := int32(0); partition < sqlSinkNumPartitions; partition++ {
 			if err := s.emit(ctx, topic, partition, noKey, noValue, payload); err != nil {
 				return err
@@ -183,12 +233,57 @@ func (s *sqlSink) emit(
 	// same producer to the same partition).
 	messageID := builtins.GenerateUniqueInt(base.SQLInstanceID(partition))
 	s.rowBuf = append(s.rowBuf, topic, partition, messageID, key, value, resolved)
-	if len(s.rowBuf)/sqlSinkEmitCols >= sqlSinkRowBatchSize {
+	if s.compression != `` {
+		s.rowBuf = append(s.rowBuf, s.compression)
+	}
+	if len(s.rowBuf)/s.emitCols() >= sqlSinkRowBatchSize {
 		return s.Flush(ctx)
 	}
 	return nil
 }
 
+// emitCols returns the number of columns written for each row.
+func (s *sqlSink) emitCols() int {
+	if s.compression != `` {
+		return sqlSinkEmitCols + 1
+	}
+	return sqlSinkEmitCols
+}
+
+// compress compresses a payload with the codec of the compression option. The
+// payload is copied, so it is safe to retain.
+func (s *sqlSink) compress(payload []byte) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressSQLSinkPayload decompresses the value or resolved column of a row
+// of the sql sink, given its compression column.
+func decompressSQLSinkPayload(compression gosql.NullString, payload []byte) ([]byte, error) {
+	if !compression.Valid || len(payload) == 0 {
+		return payload, nil
+	}
+	if compression.String != sinkCompressionGzip {
+		return nil, errors.Errorf(`unsupported compression codec %q`, compression.String)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 // Flush implements the Sink interface.
 func (s *sqlSink) Flush(ctx context.Context) error {
 	defer s.metrics.recordFlushRequestCallback()()
@@ -198,11 +293,16 @@ func (s *sqlSink) Flush(ctx context.Context) error {
 	}
 
 	var stmt strings.Builder
-	fmt.Fprintf(&stmt, sqlSinkEmitStmt, s.tableName)
+	var compressionCol string
+	if s.compression != `` {
+		compressionCol = `, compression`
+	}
+	fmt.Fprintf(&stmt, sqlSinkEmitStmt, s.tableName, compressionCol)
+	emitCols := s.emitCols()
 	for i := 0; i < len(s.rowBuf); i++ {
 		if i == 0 {
 			stmt.WriteString(` VALUES (`)
-		} else if i%sqlSinkEmitCols == 0 {
+		} else if i%emitCols == 0 {
 			stmt.WriteString(`),(`)
 		} else {
 			stmt.WriteString(`,`)
@@ -218,6 +318,12 @@ func (s *sqlSink) Flush(ctx context.Context) error {
 	return nil
 }
 
+// FlushDurable implements the SinkWithDurableAck interface. Flush inserts the
+// buffered rows with a committed statement.
+func (s *sqlSink) FlushDurable(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
 // Close implements the Sink interface.
 func (s *sqlSink) Close() error {
 	return s.db.Close()
@@ -0,0 +1,409 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	gojson "encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// The syslog sink sends each row and resolved timestamp as an RFC 5424
+// message, e.g. for a SIEM. The scheme picks the transport: syslog:// sends
+// each message in a UDP datagram, syslog-tcp:// over TCP and syslog-tls://
+// over TLS, both framed by octet counting (RFC 6587 and RFC 5425). A row is
+// sent as
+//
+//   <133>1 2022-01-02T03:04:05.000006Z host cockroachdb - row
+//     [changefeed@32473 table="foo" op="update" key="[1]" updated="..." mvcc="..."]
+//     {"after": ...}
+//
+// on one line, with the facility and severity of the sink in its priority,
+// the MVCC timestamp of the row as its timestamp, and its encoded value as
+// its message. The op is derived from the value: delete if it has no after
+// image, insert if its before image is null, update if its before image is
+// set, and upsert without the diff option. Resolved timestamps are sent with
+// the message ID resolved, a resolved parameter and their encoded payload as
+// their message. 32473 is the enterprise number reserved for documentation
+// (RFC 5612), which the sd_id parameter replaces.
+//
+// Syslog has no acknowledgements. Flush returns once the messages have been
+// written to the connection, so messages may be lost if the collector fails,
+// and UDP is best-effort: datagrams may be dropped or reordered silently.
+
+const (
+	syslogDefaultPort    = `514`
+	syslogDefaultTLSPort = `6514`
+	// syslogDialTimeout bounds the time spent connecting to the collector,
+	// and syslogWriteTimeout the time spent writing to it.
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 30 * time.Second
+
+	syslogDefaultFacility = 16 // local0
+	syslogDefaultSeverity = 5  // notice
+	syslogDefaultAppName  = `cockroachdb`
+	syslogDefaultSDID     = `changefeed@32473`
+
+	syslogTimestampFormat = `2006-01-02T15:04:05.000000Z07:00`
+)
+
+// syslogFacilities and syslogSeverities are the names of the facilities and
+// severities of RFC 5424, by their codes.
+var syslogFacilities = []string{
+	`kern`, `user`, `mail`, `daemon`, `auth`, `syslog`, `lpr`, `news`,
+	`uucp`, `cron`, `authpriv`, `ftp`, `ntp`, `security`, `console`, `solaris-cron`,
+	`local0`, `local1`, `local2`, `local3`, `local4`, `local5`, `local6`, `local7`,
+}
+
+var syslogSeverities = []string{
+	`emerg`, `alert`, `crit`, `err`, `warning`, `notice`, `info`, `debug`,
+}
+
+func isSyslogSink(u *url.URL) bool {
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeSyslog, changefeedbase.SinkSchemeSyslogTCP,
+		changefeedbase.SinkSchemeSyslogTLS:
+		return true
+	default:
+		return false
+	}
+}
+
+// syslogSink emits RFC 5424 messages to a syslog collector.
+type syslogSink struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+	// priority is the PRI of messages, from the facility and severity.
+	priority string
+	hostname string
+	appName  string
+	sdID     string
+
+	conn net.Conn
+	// w buffers the messages written over TCP and TLS. Messages sent over UDP
+	// are written to conn directly, one datagram each.
+	w   *bufio.Writer
+	buf bytes.Buffer
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*syslogSink)(nil)
+
+func makeSyslogSink(u sinkURL, opts map[string]string, m *sliMetrics) (Sink, error) {
+	if changefeedbase.FormatType(opts[changefeedbase.OptFormat]) != changefeedbase.OptFormatJSON {
+		return nil, errors.Errorf(`syslog sink requires %s=%s`,
+			changefeedbase.OptFormat, changefeedbase.OptFormatJSON)
+	}
+	// The op of rows is derived from their before and after images.
+	if envelope := changefeedbase.EnvelopeType(opts[changefeedbase.OptEnvelope]); envelope !=
+		changefeedbase.OptEnvelopeWrapped {
+		return nil, errors.Errorf(`%s=%s is not supported by syslog sinks`,
+			changefeedbase.OptEnvelope, envelope)
+	}
+	switch deleteFormat := changefeedbase.DeleteFormat(opts[changefeedbase.OptDeleteFormat]); deleteFormat {
+	case ``, changefeedbase.OptDeleteFormatAfterNull, changefeedbase.OptDeleteFormatTombstone,
+		changefeedbase.OptDeleteFormatNull:
+	default:
+		return nil, errors.Errorf(`%s=%s is not supported by syslog sinks`,
+			changefeedbase.OptDeleteFormat, deleteFormat)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == `` {
+		return nil, errors.Errorf(`host must be specified for syslog sink`)
+	}
+	sink := &syslogSink{
+		appName: syslogDefaultAppName,
+		sdID:    syslogDefaultSDID,
+		metrics: m,
+	}
+	switch u.Scheme {
+	case changefeedbase.SinkSchemeSyslog:
+		sink.network = `udp`
+	case changefeedbase.SinkSchemeSyslogTCP, changefeedbase.SinkSchemeSyslogTLS:
+		sink.network = `tcp`
+	}
+	if port == `` {
+		port = syslogDefaultPort
+		if u.Scheme == changefeedbase.SinkSchemeSyslogTLS {
+			port = syslogDefaultTLSPort
+		}
+	}
+	sink.addr = net.JoinHostPort(host, port)
+
+	facility, err := parseSyslogCode(u, changefeedbase.SinkParamSyslogFacility,
+		syslogFacilities, syslogDefaultFacility)
+	if err != nil {
+		return nil, err
+	}
+	severity, err := parseSyslogCode(u, changefeedbase.SinkParamSyslogSeverity,
+		syslogSeverities, syslogDefaultSeverity)
+	if err != nil {
+		return nil, err
+	}
+	sink.priority = strconv.Itoa(facility*8 + severity)
+
+	if appName := u.consumeParam(changefeedbase.SinkParamSyslogAppName); appName != `` {
+		if !isSyslogPrintUSASCII(appName, 48) {
+			return nil, errors.Errorf(`param %s must be at most 48 printable ASCII characters: %q`,
+				changefeedbase.SinkParamSyslogAppName, appName)
+		}
+		sink.appName = appName
+	}
+	if sdID := u.consumeParam(changefeedbase.SinkParamSyslogSDID); sdID != `` {
+		if !isSyslogPrintUSASCII(sdID, 32) || strings.ContainsAny(sdID, `= ]"`) {
+			return nil, errors.Errorf(`param %s must be a valid structured data ID: %q`,
+				changefeedbase.SinkParamSyslogSDID, sdID)
+		}
+		sink.sdID = sdID
+	}
+
+	var tlsSkipVerify bool
+	if _, err := u.consumeBool(changefeedbase.SinkParamSkipTLSVerify, &tlsSkipVerify); err != nil {
+		return nil, err
+	}
+	var caCert []byte
+	if err := u.decodeBase64(changefeedbase.SinkParamCACert, &caCert); err != nil {
+		return nil, err
+	}
+	if u.Scheme == changefeedbase.SinkSchemeSyslogTLS {
+		if sink.tlsConfig, err = makeTLSConfig(host, caCert, tlsSkipVerify); err != nil {
+			return nil, err
+		}
+	} else if tlsSkipVerify || caCert != nil {
+		return nil, errors.Errorf(`%s and %s require the %s scheme`,
+			changefeedbase.SinkParamSkipTLSVerify, changefeedbase.SinkParamCACert,
+			changefeedbase.SinkSchemeSyslogTLS)
+	}
+
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown syslog sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+
+	sink.hostname = `-`
+	if hostname, err := os.Hostname(); err == nil && isSyslogPrintUSASCII(hostname, 255) {
+		sink.hostname = hostname
+	}
+	return sink, nil
+}
+
+// parseSyslogCode consumes the param naming a facility or severity, given
+// either by name or by code, and returns its code.
+func parseSyslogCode(u sinkURL, param string, names []string, defaultCode int) (int, error) {
+	v := u.consumeParam(param)
+	if v == `` {
+		return defaultCode, nil
+	}
+	for code, name := range names {
+		if strings.EqualFold(v, name) {
+			return code, nil
+		}
+	}
+	if code, err := strconv.Atoi(v); err == nil && code >= 0 && code < len(names) {
+		return code, nil
+	}
+	return 0, errors.Errorf(`param %s must be one of %s, or a code from 0 to %d: %q`,
+		param, strings.Join(names, `, `), len(names)-1, v)
+}
+
+// isSyslogPrintUSASCII returns whether s is a non-empty string of at most
+// maxLen printable ASCII characters, as required of the header fields.
+func isSyslogPrintUSASCII(s string, maxLen int) bool {
+	if s == `` || len(s) > maxLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 33 || s[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// Dial implements the Sink interface.
+func (s *syslogSink) Dial() error {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, s.network, s.addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(s.network, s.addr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, `connecting to syslog collector at %s`, s.addr)
+	}
+	s.conn = conn
+	if s.network == `tcp` {
+		s.w = bufio.NewWriter(conn)
+	}
+	return nil
+}
+
+// EmitRow implements the Sink interface.
+func (s *syslogSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer alloc.Release(ctx)
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	op, err := syslogRowOp(value)
+	if err != nil {
+		return err
+	}
+	s.buf.Reset()
+	s.writeHeader(mvcc, `row`)
+	s.buf.WriteString(` [` + s.sdID)
+	writeSyslogParam(&s.buf, `table`, topicDescr.GetName())
+	writeSyslogParam(&s.buf, `op`, op)
+	writeSyslogParam(&s.buf, `key`, string(key))
+	writeSyslogParam(&s.buf, `updated`, updated.AsOfSystemTime())
+	writeSyslogParam(&s.buf, `mvcc`, mvcc.AsOfSystemTime())
+	s.buf.WriteString(`] `)
+	s.buf.Write(value)
+	return s.send()
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *syslogSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, ``, resolved)
+	if err != nil {
+		return err
+	}
+	s.buf.Reset()
+	s.writeHeader(resolved, `resolved`)
+	s.buf.WriteString(` [` + s.sdID)
+	writeSyslogParam(&s.buf, `resolved`, resolved.AsOfSystemTime())
+	s.buf.WriteString(`] `)
+	s.buf.Write(payload)
+	return s.send()
+}
+
+// writeHeader writes the header of a message with the given timestamp and
+// message ID to buf.
+func (s *syslogSink) writeHeader(ts hlc.Timestamp, msgID string) {
+	s.buf.WriteString(`<` + s.priority + `>1 `)
+	s.buf.WriteString(ts.GoTime().UTC().Format(syslogTimestampFormat))
+	s.buf.WriteString(` ` + s.hostname + ` ` + s.appName + ` - ` + msgID)
+}
+
+// send sends the message in buf.
+func (s *syslogSink) send() error {
+	if s.conn == nil {
+		return errors.New(`syslog sink is not connected`)
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return err
+	}
+	if s.w == nil {
+		_, err := s.conn.Write(s.buf.Bytes())
+		return errors.Wrap(err, `writing to syslog collector`)
+	}
+	var scratch [20]byte
+	if _, err := s.w.Write(strconv.AppendInt(scratch[:0], int64(s.buf.Len()), 10)); err != nil {
+		return errors.Wrap(err, `writing to syslog collector`)
+	}
+	if err := s.w.WriteByte(' '); err != nil {
+		return errors.Wrap(err, `writing to syslog collector`)
+	}
+	_, err := s.w.Write(s.buf.Bytes())
+	return errors.Wrap(err, `writing to syslog collector`)
+}
+
+// Flush implements the Sink interface.
+func (s *syslogSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	if s.conn == nil {
+		return errors.New(`syslog sink is not connected`)
+	}
+	if s.w == nil {
+		return nil
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return err
+	}
+	return errors.Wrap(s.w.Flush(), `writing to syslog collector`)
+}
+
+// Close implements the Sink interface.
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.w = nil, nil
+	return err
+}
+
+// syslogRowOp returns the op of a row whose value in the wrapped envelope is
+// given.
+func syslogRowOp(value []byte) (string, error) {
+	if len(value) == 0 {
+		return `delete`, nil
+	}
+	var row map[string]gojson.RawMessage
+	if err := gojson.Unmarshal(value, &row); err != nil {
+		return ``, errors.Wrap(err, `decoding value`)
+	}
+	isNull := func(v gojson.RawMessage) bool {
+		return v == nil || bytes.Equal(v, []byte(`null`))
+	}
+	if isNull(row[`after`]) {
+		return `delete`, nil
+	}
+	before, withDiff := row[`before`]
+	switch {
+	case !withDiff:
+		return `upsert`, nil
+	case isNull(before):
+		return `insert`, nil
+	default:
+		return `update`, nil
+	}
+}
+
+// writeSyslogParam writes a structured data parameter to buf, escaping the
+// characters which must be escaped in its value.
+func writeSyslogParam(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(` ` + name + `="`)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
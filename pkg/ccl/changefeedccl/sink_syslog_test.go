@@ -0,0 +1,167 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/stretchr/testify/require"
+)
+
+// readSyslogFrame reads a message framed by octet counting.
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	n, err := r.ReadString(' ')
+	if err != nil {
+		return ``, err
+	}
+	length, err := strconv.Atoi(n[:len(n)-1])
+	if err != nil {
+		return ``, err
+	}
+	msg := make([]byte, length)
+	_, err = io.ReadFull(r, msg)
+	return string(msg), err
+}
+
+func TestSyslogSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	opts := map[string]string{
+		changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}
+	topic := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: `foo`, ID: 52}).BuildImmutableTable()}
+	ts := hlc.Timestamp{WallTime: 1640995200000001000, Logical: 2}
+	rows := []struct{ key, value string }{
+		{`[1]`, `{"after": {"a": 1}}`},
+		{`[1]`, `{"after": {"a": 1}, "before": null}`},
+		{`[1]`, `{"after": {"a": 1}, "before": {"a": 0}}`},
+		{`["a\"]"]`, `{"after": null}`},
+	}
+	makeSink := func(t *testing.T, uri string) Sink {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := makeSyslogSink(sinkURL{URL: u}, opts, nil)
+		require.NoError(t, err)
+		sink.(*syslogSink).hostname = `host`
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+	emit := func(t *testing.T, sink Sink) {
+		for _, r := range rows {
+			require.NoError(t, sink.EmitRow(ctx, topic, []byte(r.key), []byte(r.value), ts, ts, zeroAlloc))
+		}
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts))
+		require.NoError(t, sink.Flush(ctx))
+	}
+	const header = `<133>1 2022-01-01T00:00:00.000001Z host cockroachdb - `
+	expected := []string{
+		header + `row [changefeed@32473 table="foo" op="upsert" key="[1]" updated="1640995200000001000.0000000002" mvcc="1640995200000001000.0000000002"] {"after": {"a": 1}}`,
+		header + `row [changefeed@32473 table="foo" op="insert" key="[1]" updated="1640995200000001000.0000000002" mvcc="1640995200000001000.0000000002"] {"after": {"a": 1}, "before": null}`,
+		header + `row [changefeed@32473 table="foo" op="update" key="[1]" updated="1640995200000001000.0000000002" mvcc="1640995200000001000.0000000002"] {"after": {"a": 1}, "before": {"a": 0}}`,
+		header + `row [changefeed@32473 table="foo" op="delete" key="[\"a\\\"\]\"\]" updated="1640995200000001000.0000000002" mvcc="1640995200000001000.0000000002"] {"after": null}`,
+		header + `resolved [changefeed@32473 resolved="1640995200000001000.0000000002"] {"__crdb__":{"resolved":"1640995200000001000.0000000002"}}`,
+	}
+
+	t.Run(`tcp`, func(t *testing.T) {
+		ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+		require.NoError(t, err)
+		defer ln.Close()
+		received := make(chan []string, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			var msgs []string
+			for range expected {
+				msg, err := readSyslogFrame(r)
+				if err != nil {
+					break
+				}
+				msgs = append(msgs, msg)
+			}
+			received <- msgs
+		}()
+
+		sink := makeSink(t, `syslog-tcp://`+ln.Addr().String())
+		defer func() { require.NoError(t, sink.Close()) }()
+		emit(t, sink)
+		require.Equal(t, expected, <-received)
+	})
+
+	t.Run(`udp`, func(t *testing.T) {
+		conn, err := net.ListenPacket(`udp`, `127.0.0.1:0`)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sink := makeSink(t, `syslog://`+conn.LocalAddr().String())
+		defer func() { require.NoError(t, sink.Close()) }()
+		emit(t, sink)
+		buf := make([]byte, 4096)
+		for _, msg := range expected {
+			n, _, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, msg, string(buf[:n]))
+		}
+	})
+
+	t.Run(`params`, func(t *testing.T) {
+		u, err := url.Parse(`syslog-tls://collector?facility=auth&severity=3&app_name=cdc&sd_id=audit@1`)
+		require.NoError(t, err)
+		sink, err := makeSyslogSink(sinkURL{URL: u}, opts, nil)
+		require.NoError(t, err)
+		s := sink.(*syslogSink)
+		require.Equal(t, `collector:6514`, s.addr)
+		require.Equal(t, `35`, s.priority)
+		require.Equal(t, `cdc`, s.appName)
+		require.Equal(t, `audit@1`, s.sdID)
+		require.NotNil(t, s.tlsConfig)
+
+		for uri, expectedErr := range map[string]string{
+			`syslog:///`:                      `host must be specified for syslog sink`,
+			`syslog://collector?facility=x`:   `param facility must be one of kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp, ntp, security, console, solaris-cron, local0, local1, local2, local3, local4, local5, local6, local7, or a code from 0 to 23: "x"`,
+			`syslog://collector?severity=8`:   `param severity must be one of emerg, alert, crit, err, warning, notice, info, debug, or a code from 0 to 7: "8"`,
+			`syslog://collector?sd_id=a%20b`:  `param sd_id must be a valid structured data ID: "a b"`,
+			`syslog://collector?ca_cert=Zm9v`: `insecure_tls_skip_verify and ca_cert require the syslog-tls scheme`,
+			`syslog://collector?foo=bar`:      `unknown syslog sink query parameters: foo`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeSyslogSink(sinkURL{URL: u}, opts, nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+
+		u, err = url.Parse(`syslog://collector`)
+		require.NoError(t, err)
+		_, err = makeSyslogSink(sinkURL{URL: u}, map[string]string{
+			changefeedbase.OptFormat:   string(changefeedbase.OptFormatJSON),
+			changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeKeyOnly),
+		}, nil)
+		require.EqualError(t, err, `envelope=key_only is not supported by syslog sinks`)
+	})
+}
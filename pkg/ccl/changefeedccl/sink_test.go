@@ -10,7 +10,10 @@ package changefeedccl
 
 import (
 	"context"
+	gosql "database/sql"
+	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 	"sync"
 	"testing"
@@ -22,16 +25,20 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
@@ -324,6 +331,374 @@ func TestKafkaTopicNameProvided(t *testing.T) {
 	require.Equal(t, topicOverride, m.Topic)
 }
 
+func TestKafkaSinkResolvedTopic(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t1", "t2")
+	defer cleanup()
+	sink.client = &fakeKafkaClient{}
+	sink.resolvedTopic = `progress`
+
+	encoder, err := makeJSONEncoder(map[string]string{
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}, makeChangefeedTargets("t1", "t2"))
+	require.NoError(t, err)
+
+	// The resolved timestamp is emitted once, to the resolved topic, rather
+	// than to each of the data topics.
+	resolved := hlc.Timestamp{WallTime: 1}
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, encoder, resolved))
+	m := <-p.inputCh
+	require.Equal(t, `progress`, m.Topic)
+	require.Equal(t, sarama.ByteEncoder(`{"resolved":"1.0000000000"}`), m.Value)
+	select {
+	case m := <-p.inputCh:
+		t.Fatalf(`unexpected message to topic %s`, m.Topic)
+	default:
+	}
+
+	for params, expectedErr := range map[string]string{
+		`resolved_topic=progress`: `resolved_topic requires the resolved option`,
+		`resolved_topic=t1`:       `resolved_topic "t1" is also used for changefeed rows`,
+		`resolved_topic=a%2Fb`:    `invalid resolved_topic: topic name "a/b" contains the illegal character '/'`,
+		`resolved_topic=..`:       `invalid resolved_topic: topic name ".." is not allowed`,
+	} {
+		u, err := url.Parse(`kafka://localhost?` + params)
+		require.NoError(t, err)
+		opts := map[string]string{changefeedbase.OptResolvedTimestamps: ``}
+		if params == `resolved_topic=progress` {
+			opts = map[string]string{}
+		}
+		_, err = makeKafkaSink(ctx, sinkURL{URL: u}, makeChangefeedTargets("t1"), opts, nil)
+		require.EqualError(t, err, expectedErr, params)
+	}
+}
+
+// partitionedKafkaClient is a fakeKafkaClient whose topics have the given
+// partitions.
+type partitionedKafkaClient struct {
+	fakeKafkaClient
+	partitions []int32
+}
+
+func (c *partitionedKafkaClient) Partitions(string) ([]int32, error) {
+	return c.partitions, nil
+}
+
+func TestKafkaSinkSequenceNumbers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(10)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+	sink.client = &partitionedKafkaClient{partitions: []int32{0, 1, 2}}
+	encoder, err := makeJSONEncoder(map[string]string{
+		changefeedbase.OptEnvelope: string(changefeedbase.OptEnvelopeWrapped),
+	}, makeChangefeedTargets("t"))
+	require.NoError(t, err)
+
+	// The sink continues from the checkpointed sequence numbers of its own
+	// sequencer, and ignores those of the other sequencers.
+	sink.startSequences(`1`, []jobspb.ChangefeedSequence{
+		{Sequencer: `1`, Topic: `t`, Partition: 0, Sequence: 10},
+		{Sequencer: `2`, Topic: `t`, Partition: 1, Sequence: 20},
+	})
+	last := map[int32]int64{0: 10}
+	hash := sarama.NewHashPartitioner(`t`)
+	checkNext := func(t *testing.T, m *sarama.ProducerMessage) {
+		t.Helper()
+		if m.Key != nil {
+			choice, err := hash.Partition(m, 3)
+			require.NoError(t, err)
+			require.Equal(t, choice, m.Partition)
+		}
+		last[m.Partition]++
+		require.Equal(t, []sarama.RecordHeader{
+			{Key: []byte(`crdb-sequencer`), Value: []byte(`1`)},
+			{Key: []byte(`crdb-sequence`), Value: []byte(strconv.FormatInt(last[m.Partition], 10))},
+		}, m.Headers)
+		p.successesCh <- m
+	}
+
+	for _, key := range []string{`a`, `b`, `c`, `a`, `d`} {
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(key), nil, zeroTS, zeroTS, zeroAlloc))
+		checkNext(t, <-p.inputCh)
+	}
+	// Resolved timestamps are numbered in each partition they're emitted to.
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, encoder, hlc.Timestamp{WallTime: 1}))
+	for i := 0; i < 3; i++ {
+		checkNext(t, <-p.inputCh)
+	}
+	require.NoError(t, sink.Flush(ctx))
+
+	var expected []jobspb.ChangefeedSequence
+	for _, partition := range []int32{0, 1, 2} {
+		expected = append(expected, jobspb.ChangefeedSequence{
+			Sequencer: `1`, Topic: `t`, Partition: partition, Sequence: last[partition],
+		})
+	}
+	require.Equal(t, expected, sink.flushedSequences())
+
+	require.Equal(t, []jobspb.ChangefeedSequence{
+		{Sequencer: `1`, Topic: `t`, Partition: 0, Sequence: 12},
+		{Sequencer: `2`, Topic: `t`, Partition: 1, Sequence: 20},
+		{Sequencer: `1`, Topic: `t`, Partition: 1, Sequence: 3},
+	}, mergeSequences([]jobspb.ChangefeedSequence{
+		{Sequencer: `1`, Topic: `t`, Partition: 0, Sequence: 10},
+		{Sequencer: `2`, Topic: `t`, Partition: 1, Sequence: 20},
+	}, []jobspb.ChangefeedSequence{
+		{Sequencer: `1`, Topic: `t`, Partition: 0, Sequence: 12},
+		{Sequencer: `1`, Topic: `t`, Partition: 1, Sequence: 3},
+	}))
+}
+
+func TestKafkaSinkRecordTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+
+	mvcc := hlc.Timestamp{WallTime: 1e18, Logical: 1}
+	updated := hlc.Timestamp{WallTime: 2e18}
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), nil, updated, mvcc, zeroAlloc))
+	m := <-p.inputCh
+	require.True(t, m.Timestamp.IsZero())
+	p.successesCh <- m
+
+	sink.mvccRecordTimestamps = true
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`k`), nil, updated, mvcc, zeroAlloc))
+	m = <-p.inputCh
+	require.Equal(t, mvcc.GoTime(), m.Timestamp)
+	p.successesCh <- m
+	require.NoError(t, sink.Flush(ctx))
+
+	describe := func(configs map[string]string) func(sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+		return func(r sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+			v, ok := configs[r.Name]
+			if !ok {
+				return nil, sarama.ErrUnknownTopicOrPartition
+			}
+			return []sarama.ConfigEntry{{Name: `message.timestamp.type`, Value: v}}, nil
+		}
+	}
+	// Topics which can't be described aren't checked.
+	require.NoError(t, validateKafkaTimestampTypes(ctx, describe(map[string]string{
+		`a`: `CreateTime`,
+	}), []string{`a`, `b`}))
+	require.EqualError(t, validateKafkaTimestampTypes(ctx, describe(map[string]string{
+		`a`: `CreateTime`, `b`: `LogAppendTime`,
+	}), []string{`a`, `b`}),
+		`kafka_record_timestamp='mvcc' requires message.timestamp.type=CreateTime on topic b, found LogAppendTime`)
+}
+
+func TestKafkaSinkPartitionTimeBucket(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(1)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+	sink.partitionTimeBucket = time.Hour
+	partitioner := newChangefeedPartitioner(`t`)
+
+	// Rows land in the partition of the hour they were committed in, whatever
+	// their key.
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		key       string
+		mvcc      time.Time
+		partition int32
+	}{
+		{`a`, day.Add(5*time.Hour + 30*time.Minute), 5},
+		{`b`, day.Add(5*time.Hour + 59*time.Minute), 5},
+		{`a`, day.Add(23 * time.Hour), 23},
+		{`a`, day.Add(24*time.Hour + 10*time.Minute), 0},
+	} {
+		mvcc := hlc.Timestamp{WallTime: tc.mvcc.UnixNano()}
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(tc.key), nil, mvcc, mvcc, zeroAlloc))
+		m := <-p.inputCh
+		partition, err := partitioner.Partition(m, 24)
+		require.NoError(t, err)
+		require.Equal(t, tc.partition, partition, `%s at %s`, tc.key, tc.mvcc)
+		p.successesCh <- m
+	}
+	require.NoError(t, sink.Flush(ctx))
+
+	// With fewer partitions than buckets in a day, the buckets wrap around.
+	require.Equal(t, int32(7), timeBucketPartition(
+		hlc.Timestamp{WallTime: day.Add(5 * time.Hour).UnixNano()}, time.Hour, 10))
+}
+
+func TestKafkaSinkResolvedNullValue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(10)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t1", "t2")
+	defer cleanup()
+	sink.client = &partitionedKafkaClient{partitions: []int32{0, 1}}
+	sink.resolvedNullValue = true
+
+	resolved := hlc.Timestamp{WallTime: 1646337600123456789, Logical: 3}
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, resolved))
+	var emitted []string
+	for i := 0; i < 4; i++ {
+		m := <-p.inputCh
+		require.Nil(t, m.Key)
+		require.Nil(t, m.Value)
+		require.Equal(t, resolved.GoTime(), m.Timestamp)
+		require.Len(t, m.Headers, 1)
+		require.Equal(t, `crdb-resolved`, string(m.Headers[0].Key))
+		// The header can be parsed back into the resolved timestamp.
+		parsed, err := tree.ParseHLC(string(m.Headers[0].Value))
+		require.NoError(t, err)
+		require.Equal(t, resolved, parsed)
+		emitted = append(emitted, fmt.Sprintf(`%s/%d`, m.Topic, m.Partition))
+		p.successesCh <- m
+	}
+	require.NoError(t, sink.Flush(ctx))
+	sort.Strings(emitted)
+	require.Equal(t, []string{`t1/0`, `t1/1`, `t2/0`, `t2/1`}, emitted)
+}
+
+func TestKafkaSinkConcurrency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(2)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+	sink.inflightSlots = make(chan struct{}, 1)
+
+	// The second message waits for the first to be acknowledged.
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`1`), nil, zeroTS, zeroTS, zeroAlloc))
+	m1 := <-p.inputCh
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	require.True(t, testutils.IsError(
+		sink.EmitRow(timeoutCtx, topic(`t`), []byte(`2`), nil, zeroTS, zeroTS, zeroAlloc),
+		`context deadline exceeded`,
+	))
+	p.successesCh <- m1
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`2`), nil, zeroTS, zeroTS, zeroAlloc))
+	p.successesCh <- <-p.inputCh
+	require.NoError(t, sink.Flush(ctx))
+
+	for _, v := range []string{`0`, `-1`, `x`} {
+		u, err := url.Parse(`kafka://localhost`)
+		require.NoError(t, err)
+		opts := map[string]string{changefeedbase.OptSinkConcurrency: v}
+		_, err = makeKafkaSink(ctx, sinkURL{URL: u}, makeChangefeedTargets("t"), opts, nil)
+		require.EqualError(t, err, `sink_concurrency must be a positive integer: "`+v+`"`)
+	}
+
+	// The cap is split across the change aggregators.
+	for _, tc := range []struct {
+		v    string
+		n    int32
+		want string
+	}{
+		{v: `8`, n: 1, want: `8`},
+		{v: `8`, n: 3, want: `2`},
+		{v: `2`, n: 4, want: `1`},
+		{v: `x`, n: 4, want: `x`},
+	} {
+		feedCfg := jobspb.ChangefeedDetails{
+			Opts: map[string]string{changefeedbase.OptSinkConcurrency: tc.v},
+		}
+		share := aggregatorSinkConfig(feedCfg, tc.n)
+		require.Equal(t, tc.want, share.Opts[changefeedbase.OptSinkConcurrency])
+		require.Equal(t, tc.v, feedCfg.Opts[changefeedbase.OptSinkConcurrency])
+	}
+}
+
+func TestKafkaSinkSplitRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	p := newAsyncProducerMock(4)
+	sink, cleanup := makeTestKafkaSink(t, noTopicPrefix, defaultTopicName, p, "t")
+	defer cleanup()
+
+	flush := func() chan error {
+		errCh := make(chan error, 1)
+		go func() { errCh <- sink.Flush(ctx) }()
+		return errCh
+	}
+	rejectTooLarge := func(m *sarama.ProducerMessage) {
+		p.errorsCh <- &sarama.ProducerError{Msg: m, Err: sarama.ErrMessageSizeTooLarge}
+	}
+
+	// Kafka rejects the batch of all four messages, so they're retried two
+	// at a time.
+	for _, key := range []string{`1`, `2`, `3`, `4`} {
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(key), nil, zeroTS, zeroTS, zeroAlloc))
+	}
+	for i := 0; i < 4; i++ {
+		rejectTooLarge(<-p.inputCh)
+	}
+	errCh := flush()
+	var retried []string
+	for batch := 0; batch < 2; batch++ {
+		m1, m2 := <-p.inputCh, <-p.inputCh
+		select {
+		case m := <-p.inputCh:
+			t.Fatalf(`unexpected message %s in a batch of 2`, m.Key)
+		case <-time.After(10 * time.Millisecond):
+		}
+		for _, m := range []*sarama.ProducerMessage{m1, m2} {
+			key, err := m.Key.Encode()
+			require.NoError(t, err)
+			retried = append(retried, string(key))
+			p.successesCh <- m
+		}
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, []string{`1`, `2`, `3`, `4`}, retried)
+
+	// Messages acknowledged after a rejected message of their partition are
+	// emitted again after it, so that they end up in their original order.
+	for _, key := range []string{`1`, `2`, `3`} {
+		require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(key), nil, zeroTS, zeroTS, zeroAlloc))
+	}
+	p.successesCh <- <-p.inputCh
+	rejectTooLarge(<-p.inputCh)
+	p.successesCh <- <-p.inputCh
+	errCh = flush()
+	retried = nil
+	for i := 0; i < 2; i++ {
+		m := <-p.inputCh
+		key, err := m.Key.Encode()
+		require.NoError(t, err)
+		retried = append(retried, string(key))
+		p.successesCh <- m
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, []string{`2`, `3`}, retried)
+
+	// A message which Kafka rejects on its own fails the flush.
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`5`), nil, zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, sink.EmitRow(ctx, topic(`t`), []byte(`6`), nil, zeroTS, zeroTS, zeroAlloc))
+	rejectTooLarge(<-p.inputCh)
+	rejectTooLarge(<-p.inputCh)
+	errCh = flush()
+	p.successesCh <- <-p.inputCh
+	rejectTooLarge(<-p.inputCh)
+	require.True(t, testutils.IsError(<-errCh, `message of 1 bytes to topic t: .*too large`))
+}
+
 func TestKafkaTopicNameWithPrefix(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -407,7 +782,7 @@ func TestSQLSink(t *testing.T) {
 		barTopic.GetID(): jobspb.ChangefeedTarget{StatementTimeName: `bar`},
 	}
 	const testTableName = `sink`
-	sink, err := makeSQLSink(sinkURL{URL: &pgURL}, testTableName, targets, nil)
+	sink, err := makeSQLSink(sinkURL{URL: &pgURL}, testTableName, targets, nil /* opts */, nil)
 	require.NoError(t, err)
 	require.NoError(t, sink.(*sqlSink).Dial())
 	defer func() { require.NoError(t, sink.Close()) }()
@@ -504,6 +879,48 @@ func TestSQLSink(t *testing.T) {
 			{`foo`, `2`, ``, ``, `0.000000001,0`},
 		},
 	)
+
+	// Compressed payloads are marked with their codec.
+	gzSink, err := makeSQLSink(sinkURL{URL: &pgURL}, `sink_gz`, targets,
+		map[string]string{changefeedbase.OptCompression: `gzip`}, nil)
+	require.NoError(t, err)
+	require.NoError(t, gzSink.(*sqlSink).Dial())
+	defer func() { require.NoError(t, gzSink.Close()) }()
+	require.NoError(t, gzSink.EmitRow(ctx, fooTopic, []byte(`foo0`), []byte(`v0`), zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, gzSink.Flush(ctx))
+	var key, value []byte
+	var compression gosql.NullString
+	sqlDB.QueryRow(t, `SELECT key, value, compression FROM sink_gz`).Scan(&key, &value, &compression)
+	require.Equal(t, `foo0`, string(key))
+	require.Equal(t, gosql.NullString{String: `gzip`, Valid: true}, compression)
+	require.NotEqual(t, `v0`, string(value))
+	value, err = decompressSQLSinkPayload(compression, value)
+	require.NoError(t, err)
+	require.Equal(t, `v0`, string(value))
+
+	// Tables created before the compression column existed are written to
+	// without it, until a compressing sink adds it.
+	sqlDB.Exec(t, `CREATE TABLE sink_old (
+		topic STRING, partition INT, message_id INT, key BYTES, value BYTES, resolved BYTES,
+		PRIMARY KEY (topic, partition, message_id)
+	)`)
+	oldSink, err := makeSQLSink(sinkURL{URL: &pgURL}, `sink_old`, targets, nil /* opts */, nil)
+	require.NoError(t, err)
+	require.NoError(t, oldSink.(*sqlSink).Dial())
+	defer func() { require.NoError(t, oldSink.Close()) }()
+	require.NoError(t, oldSink.EmitRow(ctx, fooTopic, []byte(`foo0`), []byte(`v0`), zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, oldSink.Flush(ctx))
+	sqlDB.CheckQueryResults(t, `SELECT key, value FROM sink_old`, [][]string{{`foo0`, `v0`}})
+
+	gzOldSink, err := makeSQLSink(sinkURL{URL: &pgURL}, `sink_old`, targets,
+		map[string]string{changefeedbase.OptCompression: `gzip`}, nil)
+	require.NoError(t, err)
+	require.NoError(t, gzOldSink.(*sqlSink).Dial())
+	defer func() { require.NoError(t, gzOldSink.Close()) }()
+	require.NoError(t, gzOldSink.EmitRow(ctx, barTopic, []byte(`bar0`), []byte(`v0`), zeroTS, zeroTS, zeroAlloc))
+	require.NoError(t, gzOldSink.Flush(ctx))
+	sqlDB.CheckQueryResults(t, `SELECT topic, compression IS NULL FROM sink_old ORDER BY topic`,
+		[][]string{{`bar`, `false`}, {`foo`, `true`}})
 }
 
 func TestSaramaConfigOptionParsing(t *testing.T) {
@@ -641,6 +1058,100 @@ func TestSaramaConfigOptionParsing(t *testing.T) {
 	})
 }
 
+func TestKafkaIdempotentProducerConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	buildConfig := func(opts map[string]string) (*sarama.Config, error) {
+		u, err := url.Parse(`kafka://nope`)
+		require.NoError(t, err)
+		return buildKafkaConfig(sinkURL{URL: u}, opts)
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{})
+		require.NoError(t, err)
+		require.False(t, cfg.Producer.Idempotent)
+
+		cfg, err = buildConfig(map[string]string{changefeedbase.OptKafkaIdempotent: `false`})
+		require.NoError(t, err)
+		require.False(t, cfg.Producer.Idempotent)
+	})
+	t.Run("enabled", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{
+			changefeedbase.OptKafkaIdempotent: `true`,
+			changefeedbase.OptKafkaSinkConfig: `{"RequiredAcks": "ALL", "Version": "2.0.0"}`,
+		})
+		require.NoError(t, err)
+		require.True(t, cfg.Producer.Idempotent)
+		require.Equal(t, sarama.WaitForAll, cfg.Producer.RequiredAcks)
+		require.Equal(t, 1, cfg.Net.MaxOpenRequests)
+		require.GreaterOrEqual(t, cfg.Producer.Retry.Max, 1)
+		require.NoError(t, cfg.Validate())
+	})
+	t.Run("incompatible", func(t *testing.T) {
+		for opts, expectedErr := range map[string]string{
+			`{"RequiredAcks": "ONE"}`: `kafka_idempotent requires RequiredAcks to be "ALL" in kafka_sink_config`,
+			`{"Version": "0.10.2.0"}`: `kafka_idempotent requires a Kafka version of at least 0.11.0.0, found 0.10.2.0 in kafka_sink_config`,
+		} {
+			_, err := buildConfig(map[string]string{
+				changefeedbase.OptKafkaIdempotent: `true`,
+				changefeedbase.OptKafkaSinkConfig: opts,
+			})
+			require.EqualError(t, err, expectedErr, opts)
+		}
+
+		_, err := buildConfig(map[string]string{changefeedbase.OptKafkaIdempotent: `yes please`})
+		require.EqualError(t, err, `kafka_idempotent must be a boolean: "yes please"`)
+	})
+	t.Run("durable resolved", func(t *testing.T) {
+		cfg, err := buildConfig(map[string]string{changefeedbase.OptDurableResolved: ``})
+		require.NoError(t, err)
+		require.Equal(t, sarama.WaitForAll, cfg.Producer.RequiredAcks)
+
+		_, err = buildConfig(map[string]string{
+			changefeedbase.OptDurableResolved: ``,
+			changefeedbase.OptKafkaSinkConfig: `{"RequiredAcks": "ONE"}`,
+		})
+		require.EqualError(t, err, `durable_resolved requires RequiredAcks to be "ALL" in kafka_sink_config`)
+	})
+	t.Run("sequence numbers", func(t *testing.T) {
+		_, err := buildConfig(map[string]string{changefeedbase.OptSequenceNumbers: ``})
+		require.NoError(t, err)
+
+		_, err = buildConfig(map[string]string{
+			changefeedbase.OptSequenceNumbers: ``,
+			changefeedbase.OptKafkaSinkConfig: `{"Version": "0.10.2.0"}`,
+		})
+		require.EqualError(t, err, `sequence_numbers requires a Kafka version of at least 0.11.0.0, found 0.10.2.0 in kafka_sink_config`)
+	})
+	t.Run("resolved null value", func(t *testing.T) {
+		_, err := buildConfig(map[string]string{changefeedbase.OptResolvedNullValue: ``})
+		require.NoError(t, err)
+
+		_, err = buildConfig(map[string]string{
+			changefeedbase.OptResolvedNullValue: ``,
+			changefeedbase.OptKafkaSinkConfig:   `{"Version": "0.10.2.0"}`,
+		})
+		require.EqualError(t, err, `resolved_null_value requires a Kafka version of at least 0.11.0.0, found 0.10.2.0 in kafka_sink_config`)
+	})
+	t.Run("record timestamp", func(t *testing.T) {
+		for _, v := range []string{`produce`, `mvcc`} {
+			_, err := buildConfig(map[string]string{changefeedbase.OptKafkaRecordTimestamp: v})
+			require.NoError(t, err)
+		}
+
+		_, err := buildConfig(map[string]string{
+			changefeedbase.OptKafkaRecordTimestamp: `mvcc`,
+			changefeedbase.OptKafkaSinkConfig:      `{"Version": "0.9.0.0"}`,
+		})
+		require.EqualError(t, err, `kafka_record_timestamp='mvcc' requires a Kafka version of at least 0.10.0.0, found 0.9.0.0 in kafka_sink_config`)
+
+		_, err = buildConfig(map[string]string{changefeedbase.OptKafkaRecordTimestamp: `updated`})
+		require.EqualError(t, err, `unknown kafka_record_timestamp: updated`)
+	})
+}
+
 func TestKafkaSinkTracksMemory(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
@@ -680,3 +1191,291 @@ func TestKafkaSinkTracksMemory(t *testing.T) {
 	require.EqualValues(t, 0, p.outstanding())
 	require.EqualValues(t, 0, pool.used())
 }
+
+func TestRateLimitingSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sli, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	wrapped, err := makeNullSink(sinkURL{URL: &url.URL{}}, nil)
+	require.NoError(t, err)
+	topic := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: "foo"}).BuildImmutableTable()}
+
+	t.Run(`shares`, func(t *testing.T) {
+		require.Equal(t, int64(100), aggregatorShare(100, 0))
+		require.Equal(t, int64(100), aggregatorShare(100, 1))
+		require.Equal(t, int64(33), aggregatorShare(100, 3))
+		require.Equal(t, int64(1), aggregatorShare(2, 3))
+		require.Equal(t, int64(0), aggregatorShare(0, 3))
+	})
+
+	t.Run(`rows`, func(t *testing.T) {
+		sink := makeRateLimitingSink(wrapped, 0 /* bytesPerSec */, 20 /* rowsPerSec */, sli)
+		start := timeutil.Now()
+		// The first 20 rows use up the burst, the remaining 10 rows must wait
+		// for quota at 20 rows per second.
+		for i := 0; i < 30; i++ {
+			require.NoError(t, sink.EmitRow(ctx, topic, []byte(`k`), []byte(`v`), zeroTS, zeroTS, zeroAlloc))
+		}
+		require.GreaterOrEqual(t, int64(timeutil.Since(start)), int64(400*time.Millisecond))
+		require.Equal(t, int64(0), sli.RateLimited.Value())
+	})
+
+	t.Run(`bytes`, func(t *testing.T) {
+		sink := makeRateLimitingSink(wrapped, 1 /* bytesPerSec */, 0 /* rowsPerSec */, sli)
+		// The first row puts the bucket into debt, so the next row has to wait
+		// for several seconds. It is reported as rate limited until it gives up.
+		require.NoError(t, sink.EmitRow(ctx, topic, []byte(`key`), []byte(`value`), zeroTS, zeroTS, zeroAlloc))
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- sink.EmitRow(waitCtx, topic, []byte(`key`), []byte(`value`), zeroTS, zeroTS, zeroAlloc)
+		}()
+		testutils.SucceedsSoon(t, func() error {
+			if v := sli.RateLimited.Value(); v != 1 {
+				return errors.Newf(`expected 1 rate limited aggregator, found %d`, v)
+			}
+			return nil
+		})
+		cancel()
+		require.True(t, errors.Is(<-errCh, context.Canceled))
+		require.Equal(t, int64(0), sli.RateLimited.Value())
+	})
+}
+
+func TestLaggingSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	mm := mon.NewMonitorWithLimit(
+		"test-mm", mon.MemoryResource, 1024,
+		nil, nil,
+		128 /* small allocation increment */, 100,
+		cluster.MakeTestingClusterSettings())
+	mm.Start(ctx, nil, mon.MakeStandaloneBudget(1024))
+	defer mm.Stop(ctx)
+
+	topic := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: "foo"}).BuildImmutableTable()}
+	start := timeutil.Unix(1000, 0)
+	ts := func(seconds int64) hlc.Timestamp {
+		return hlc.Timestamp{WallTime: start.Add(time.Duration(seconds) * time.Second).UnixNano()}
+	}
+	now := start
+	wrapped := &replayRecordingSink{}
+	acc := mm.MakeBoundAccount()
+	sink := makeLaggingSink(wrapped, &acc, time.Hour, nil /* heldBytesGauge */, nil, /* window */
+		func() time.Time { return now })
+	defer func() { require.NoError(t, sink.Close()) }()
+	// Two of these rows fit in the monitor, but not three.
+	value := string(make([]byte, 200))
+	emit := func(ctx context.Context, key string, updated hlc.Timestamp) error {
+		return sink.EmitRow(ctx, topic, []byte(key), []byte(value), updated, updated, zeroAlloc)
+	}
+	keys := func() []string {
+		var keys []string
+		for _, ev := range wrapped.events {
+			keys = append(keys, ev[:len(`foo: k1`)])
+		}
+		return keys
+	}
+
+	// Rows are released in the order of their updated timestamps.
+	require.NoError(t, emit(ctx, `k1`, ts(2)))
+	require.NoError(t, emit(ctx, `k2`, ts(1)))
+	require.NoError(t, sink.release(ctx, ts(1)))
+	require.Equal(t, []string{`foo: k2`}, keys())
+	require.NoError(t, emit(ctx, `k3`, ts(3)))
+	require.NotZero(t, acc.Used())
+
+	// Once the monitor is exhausted, the oldest row is emitted as soon as it
+	// ages past the lag, and not before.
+	errCh := make(chan error, 1)
+	waitCtx, cancel := context.WithCancel(ctx)
+	go func() { errCh <- emit(waitCtx, `k4`, ts(4)) }()
+	select {
+	case err := <-errCh:
+		t.Fatalf(`expected the row to wait for the lag, got %v`, err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	require.True(t, errors.Is(<-errCh, context.Canceled))
+	require.Equal(t, []string{`foo: k2`}, keys())
+
+	now = start.Add(time.Hour + 2*time.Second)
+	require.NoError(t, emit(ctx, `k4`, ts(4)))
+	require.Equal(t, []string{`foo: k2`, `foo: k1`}, keys())
+	require.NoError(t, sink.release(ctx, ts(4)))
+	require.Equal(t, []string{`foo: k2`, `foo: k1`, `foo: k3`, `foo: k4`}, keys())
+
+	// Outside of the emit_window, the sink fails instead of waiting for the
+	// window to open.
+	window, err := parseEmitWindow(`09:30-17:00`)
+	require.NoError(t, err)
+	now = time.Date(2022, 3, 1, 18, 0, 0, 0, time.UTC)
+	wrapped.events = nil
+	windowAcc := mm.MakeBoundAccount()
+	windowSink := makeLaggingSink(wrapped, &windowAcc, 0 /* lag */, nil /* heldBytesGauge */, window,
+		func() time.Time { return now })
+	defer func() { require.NoError(t, windowSink.Close()) }()
+	for _, key := range []string{`k1`, `k2`} {
+		require.NoError(t, windowSink.EmitRow(ctx, topic, []byte(key), []byte(value), ts(1), ts(1), zeroAlloc))
+	}
+	err = windowSink.EmitRow(ctx, topic, []byte(`k3`), []byte(value), ts(1), ts(1), zeroAlloc)
+	require.True(t, changefeedbase.IsRetryableError(err))
+	require.Regexp(t, `rows held back by emit_window until 2022-03-02T09:30:00Z exceed the memory budget`, err)
+	require.Empty(t, wrapped.events)
+}
+
+func TestDedupSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sli, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	topic := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: "foo"}).BuildImmutableTable()}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	makeMonitor := func(budget int64) *mon.BytesMonitor {
+		mm := mon.NewMonitorWithLimit(
+			"test-mm", mon.MemoryResource, budget,
+			nil, nil,
+			1 /* allocation increment */, 100,
+			cluster.MakeTestingClusterSettings())
+		mm.Start(ctx, nil, mon.MakeStandaloneBudget(budget))
+		return mm
+	}
+	mm := makeMonitor(1 << 20)
+	defer mm.Stop(ctx)
+	acc := mm.MakeBoundAccount()
+	wrapped := &replayRecordingSink{}
+	sink := makeDedupSink(wrapped, &acc, sli)
+	emit := func(key, value string, mvcc hlc.Timestamp) {
+		require.NoError(t, sink.EmitRow(ctx, topic, []byte(key), []byte(value), mvcc, mvcc, zeroAlloc))
+	}
+
+	// Rows are dropped if a row with the same key and MVCC timestamp was
+	// emitted, regardless of their values.
+	emit(`k1`, `v1`, ts(1))
+	emit(`k1`, `v1`, ts(1))
+	emit(`k1`, `v2`, ts(2))
+	emit(`k2`, `v1`, ts(1))
+	emit(`k2`, `v1`, ts(1))
+	require.Equal(t, []string{`foo: k1->v1`, `foo: k1->v2`, `foo: k2->v1`}, wrapped.events)
+	require.Equal(t, int64(2), sli.Deduplicated.Value())
+
+	// Rows are forgotten once the frontier passes them.
+	sink.release(ctx, ts(1))
+	emit(`k1`, `v1`, ts(1))
+	emit(`k1`, `v2`, ts(2))
+	require.Equal(t, []string{`foo: k1->v1`, `foo: k1->v2`, `foo: k2->v1`, `foo: k1->v1`}, wrapped.events)
+	require.Equal(t, int64(3), sli.Deduplicated.Value())
+
+	require.NoError(t, sink.Close())
+
+	// Once the monitor is exhausted, the oldest rows are forgotten first.
+	wrapped.events = nil
+	small := makeMonitor(2 * dedupKey{topic: `foo`, key: `k1`}.size())
+	defer small.Stop(ctx)
+	acc = small.MakeBoundAccount()
+	sink = makeDedupSink(wrapped, &acc, sli)
+	defer func() { require.NoError(t, sink.Close()) }()
+	emit(`k1`, `v1`, ts(1))
+	emit(`k2`, `v1`, ts(2))
+	emit(`k3`, `v1`, ts(3))
+	emit(`k1`, `v1`, ts(1))
+	emit(`k3`, `v1`, ts(3))
+	require.Equal(t, []string{`foo: k1->v1`, `foo: k2->v1`, `foo: k3->v1`, `foo: k1->v1`}, wrapped.events)
+}
+
+// failingSink records the rows emitted to it like a replayRecordingSink,
+// failing every call while err is set.
+type failingSink struct {
+	replayRecordingSink
+	err error
+}
+
+func (s *failingSink) EmitRow(
+	ctx context.Context,
+	topic TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.replayRecordingSink.EmitRow(ctx, topic, key, value, updated, mvcc, alloc)
+}
+
+func (s *failingSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.replayRecordingSink.EmitResolvedTimestamp(ctx, encoder, resolved)
+}
+
+func (s *failingSink) Flush(ctx context.Context) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.replayRecordingSink.Flush(ctx)
+}
+
+func TestAtMostOnceSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	sli, err := newAggregateMetrics(time.Minute).getOrCreateScope(defaultSLIScope)
+	require.NoError(t, err)
+	topic := tableDescriptorTopic{
+		tabledesc.NewBuilder(&descpb.TableDescriptor{Name: "foo"}).BuildImmutableTable()}
+	ts := hlc.Timestamp{WallTime: 1}
+
+	wrapped := &failingSink{}
+	sink := makeAtMostOnceSink(wrapped, sli)
+	emit := func(key string) {
+		require.NoError(t, sink.EmitRow(ctx, topic, []byte(key), []byte(`v`), ts, ts, zeroAlloc))
+	}
+
+	// Nothing is dropped while the sink works.
+	emit(`k1`)
+	require.NoError(t, sink.Flush(ctx))
+	require.Equal(t, int64(0), sli.Dropped.Value())
+
+	// Rows which fail to be emitted are dropped, as are those emitted since the
+	// last successful flush when a flush fails.
+	emit(`k2`)
+	wrapped.err = errors.New(`sink unavailable`)
+	emit(`k3`)
+	require.Equal(t, int64(1), sli.Dropped.Value())
+	require.NoError(t, sink.Flush(ctx))
+	require.Equal(t, int64(2), sli.Dropped.Value())
+	require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts))
+	require.Equal(t, int64(2), sli.Dropped.Value())
+
+	// The changefeed continues once the sink recovers.
+	wrapped.err = nil
+	emit(`k4`)
+	require.NoError(t, sink.Flush(ctx))
+	require.Equal(t, []string{`foo: k1->v`, `foo: k2->v`, `foo: k4->v`}, wrapped.events)
+	require.Equal(t, int64(2), sli.Dropped.Value())
+
+	// Errors are returned once the changefeed is shutting down.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	wrapped.err = context.Canceled
+	require.Error(t, sink.EmitRow(canceledCtx, topic, []byte(`k5`), []byte(`v`), ts, ts, zeroAlloc))
+	require.Error(t, sink.Flush(canceledCtx))
+	require.Equal(t, int64(2), sli.Dropped.Value())
+}
@@ -0,0 +1,364 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The unix sink streams rows and resolved timestamps to a consumer listening
+// on a Unix domain socket on the same machine, e.g. unix:///cdc.sock. Like the
+// paths of file sinks, the path of the socket is relative to the external IO
+// directory of the node.
+// All integers are big-endian. On connecting, the sink writes the 5 byte
+// header "CRDB" followed by the version of the protocol, currently 1. It then
+// writes a frame for each message:
+//
+//   type      1 byte: 'R' for a row, 'T' for a resolved timestamp
+//   sequence  uint64: 1 for the first frame, incremented for each frame
+//
+// followed, for a row, by
+//
+//   topic     uint32 length, then the bytes of the topic
+//   key       uint32 length, then the bytes of the encoded key
+//   value     uint32 length, then the bytes of the encoded value
+//   updated   int64 wall time, then int32 logical time
+//   mvcc      int64 wall time, then int32 logical time
+//
+// and, for a resolved timestamp, by
+//
+//   payload   uint32 length, then the bytes of the encoded resolved timestamp
+//   resolved  int64 wall time, then int32 logical time
+//
+// The consumer acknowledges frames by writing back the uint64 sequence number
+// of the last frame it has processed; each acknowledgement covers every frame
+// before it. Flush waits for the acknowledgement of the last frame written.
+// The consumer closes the connection to signal an error, after which the
+// changefeed retries by reconnecting and emitting from its last checkpoint.
+
+const (
+	unixSinkMagic   = "CRDB"
+	unixSinkVersion = 1
+
+	// Types of the frames of rows and resolved timestamps.
+	unixSinkFrameRow      = 'R'
+	unixSinkFrameResolved = 'T'
+)
+
+// unixSinkDialTimeout bounds the time spent connecting to the socket.
+const unixSinkDialTimeout = 10 * time.Second
+
+func isUnixSink(u *url.URL) bool {
+	return u.Scheme == changefeedbase.SinkSchemeUnix
+}
+
+// unixSink emits to a consumer listening on a Unix domain socket, using the
+// framing protocol above.
+type unixSink struct {
+	path string
+
+	conn net.Conn
+	w    *bufio.Writer
+	// sent is the sequence number of the last frame written.
+	sent uint64
+	// scratch is reused to encode the fixed size fields of frames.
+	scratch [12]byte
+
+	mu struct {
+		syncutil.Mutex
+		// acked is the highest sequence number acknowledged by the consumer.
+		acked uint64
+		// err is set once the connection has failed.
+		err error
+		// ackCh is closed and replaced whenever acked or err change.
+		ackCh chan struct{}
+	}
+
+	metrics *sliMetrics
+}
+
+var _ Sink = (*unixSink)(nil)
+
+func makeUnixSink(u sinkURL, externalIODir string, m *sliMetrics) (Sink, error) {
+	if u.Host != `` {
+		return nil, errors.Errorf(`unix sink URL must not have a host, found %q`, u.Host)
+	}
+	if u.Path == `` {
+		return nil, errors.Errorf(`path of the socket must be specified for unix sink`)
+	}
+	path, err := fileSinkPath(externalIODir, u.Path)
+	if err != nil {
+		return nil, err
+	}
+	if unknownParams := u.remainingQueryParams(); len(unknownParams) > 0 {
+		return nil, errors.Errorf(
+			`unknown unix sink query parameters: %s`, strings.Join(unknownParams, ", "))
+	}
+	return &unixSink{path: path, metrics: m}, nil
+}
+
+// Dial implements the Sink interface.
+func (s *unixSink) Dial() error {
+	conn, err := net.DialTimeout(`unix`, s.path, unixSinkDialTimeout)
+	if err != nil {
+		return errors.Wrapf(err, `connecting to unix sink at %s`, s.path)
+	}
+	s.conn, s.w = conn, bufio.NewWriter(conn)
+	s.mu.ackCh = make(chan struct{})
+	if _, err := s.w.WriteString(unixSinkMagic); err != nil {
+		return s.connFailed(err)
+	}
+	if err := s.w.WriteByte(unixSinkVersion); err != nil {
+		return s.connFailed(err)
+	}
+	go s.receiveAcks(conn)
+	return nil
+}
+
+// receiveAcks records the acknowledgements received from the consumer until
+// the connection fails or is closed.
+func (s *unixSink) receiveAcks(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var buf [8]byte
+	for {
+		_, err := io.ReadFull(r, buf[:])
+		s.mu.Lock()
+		if err != nil {
+			s.mu.err = errors.Wrap(err, `unix sink connection failed`)
+		} else if seq := binary.BigEndian.Uint64(buf[:]); seq > s.mu.acked {
+			s.mu.acked = seq
+		}
+		close(s.mu.ackCh)
+		s.mu.ackCh = make(chan struct{})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// EmitRow implements the Sink interface.
+func (s *unixSink) EmitRow(
+	ctx context.Context,
+	topicDescr TopicDescriptor,
+	key, value []byte,
+	updated, mvcc hlc.Timestamp,
+	alloc kvevent.Alloc,
+) error {
+	defer alloc.Release(ctx)
+	defer s.metrics.recordEmittedMessages()(1, mvcc, len(key)+len(value), sinkDoesNotCompress)
+
+	if err := s.writeHeader(unixSinkFrameRow); err != nil {
+		return err
+	}
+	for _, b := range [][]byte{[]byte(topicDescr.GetName()), key, value} {
+		if err := s.writeBytes(b); err != nil {
+			return err
+		}
+	}
+	if err := s.writeTimestamp(updated); err != nil {
+		return err
+	}
+	return s.writeTimestamp(mvcc)
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *unixSink) EmitResolvedTimestamp(
+	ctx context.Context, encoder Encoder, resolved hlc.Timestamp,
+) error {
+	defer s.metrics.recordResolvedCallback()()
+
+	payload, err := encoder.EncodeResolvedTimestamp(ctx, "", resolved)
+	if err != nil {
+		return err
+	}
+	if err := s.writeHeader(unixSinkFrameResolved); err != nil {
+		return err
+	}
+	if err := s.writeBytes(payload); err != nil {
+		return err
+	}
+	return s.writeTimestamp(resolved)
+}
+
+// writeHeader writes the type and sequence number of a new frame.
+func (s *unixSink) writeHeader(frameType byte) error {
+	if s.conn == nil {
+		return errors.New(`unix sink is not connected`)
+	}
+	if err := s.checkErr(); err != nil {
+		return err
+	}
+	s.sent++
+	if err := s.w.WriteByte(frameType); err != nil {
+		return s.connFailed(err)
+	}
+	binary.BigEndian.PutUint64(s.scratch[:8], s.sent)
+	return s.write(s.scratch[:8])
+}
+
+// writeBytes writes b, prefixed with its length.
+func (s *unixSink) writeBytes(b []byte) error {
+	binary.BigEndian.PutUint32(s.scratch[:4], uint32(len(b)))
+	if err := s.write(s.scratch[:4]); err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+func (s *unixSink) writeTimestamp(ts hlc.Timestamp) error {
+	binary.BigEndian.PutUint64(s.scratch[:8], uint64(ts.WallTime))
+	binary.BigEndian.PutUint32(s.scratch[8:12], uint32(ts.Logical))
+	return s.write(s.scratch[:12])
+}
+
+func (s *unixSink) write(b []byte) error {
+	if _, err := s.w.Write(b); err != nil {
+		return s.connFailed(err)
+	}
+	return nil
+}
+
+// connFailed returns the error which failed the connection, which the ack
+// receiver may have recorded with more detail than err.
+func (s *unixSink) connFailed(err error) error {
+	if recorded := s.checkErr(); recorded != nil {
+		return recorded
+	}
+	return errors.Wrap(err, `writing to unix sink`)
+}
+
+func (s *unixSink) checkErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.err
+}
+
+// Flush implements the Sink interface.
+func (s *unixSink) Flush(ctx context.Context) error {
+	defer s.metrics.recordFlushRequestCallback()()
+	if s.conn == nil {
+		return errors.New(`unix sink is not connected`)
+	}
+	if err := s.w.Flush(); err != nil {
+		return s.connFailed(err)
+	}
+	return s.waitForAcks(ctx, s.sent)
+}
+
+// waitForAcks waits until the consumer has acknowledged every frame up to and
+// including seq, returning an error if the connection fails first.
+func (s *unixSink) waitForAcks(ctx context.Context, seq uint64) error {
+	for {
+		s.mu.Lock()
+		acked, err, ackCh := s.mu.acked, s.mu.err, s.mu.ackCh
+		s.mu.Unlock()
+		if acked >= seq {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ackCh:
+		}
+	}
+}
+
+// Close implements the Sink interface.
+func (s *unixSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.w = nil, nil
+	return err
+}
+
+// unixSinkFrame is a frame read by readUnixSinkFrame.
+type unixSinkFrame struct {
+	frameType     byte
+	sequence      uint64
+	topic         string
+	key, value    []byte
+	updated, mvcc hlc.Timestamp
+	// payload and resolved are set for resolved timestamp frames.
+	payload  []byte
+	resolved hlc.Timestamp
+}
+
+// readUnixSinkFrame reads a frame written by the unix sink. It is the
+// reference implementation of the consumer side of the protocol.
+func readUnixSinkFrame(r io.Reader) (unixSinkFrame, error) {
+	var f unixSinkFrame
+	var buf [12]byte
+	if _, err := io.ReadFull(r, buf[:9]); err != nil {
+		return f, err
+	}
+	f.frameType, f.sequence = buf[0], binary.BigEndian.Uint64(buf[1:9])
+	readBytes := func() ([]byte, error) {
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return nil, err
+		}
+		b := make([]byte, binary.BigEndian.Uint32(buf[:4]))
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	readTimestamp := func() (hlc.Timestamp, error) {
+		if _, err := io.ReadFull(r, buf[:12]); err != nil {
+			return hlc.Timestamp{}, err
+		}
+		return hlc.Timestamp{
+			WallTime: int64(binary.BigEndian.Uint64(buf[:8])),
+			Logical:  int32(binary.BigEndian.Uint32(buf[8:12])),
+		}, nil
+	}
+	var err error
+	switch f.frameType {
+	case unixSinkFrameRow:
+		var topic []byte
+		if topic, err = readBytes(); err != nil {
+			return f, err
+		}
+		f.topic = string(topic)
+		if f.key, err = readBytes(); err != nil {
+			return f, err
+		}
+		if f.value, err = readBytes(); err != nil {
+			return f, err
+		}
+		if f.updated, err = readTimestamp(); err != nil {
+			return f, err
+		}
+		f.mvcc, err = readTimestamp()
+	case unixSinkFrameResolved:
+		if f.payload, err = readBytes(); err != nil {
+			return f, err
+		}
+		f.resolved, err = readTimestamp()
+	default:
+		err = errors.Errorf(`unknown unix sink frame type %q`, f.frameType)
+	}
+	return f, err
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUnixSinkConsumer records the frames it reads from each connection and
+// acknowledges each of them. A row for the topic `fail` closes the
+// connection.
+type fakeUnixSinkConsumer struct {
+	mu struct {
+		syncutil.Mutex
+		frames []unixSinkFrame
+	}
+}
+
+func (c *fakeUnixSinkConsumer) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_ = c.consume(conn)
+		}()
+	}
+}
+
+func (c *fakeUnixSinkConsumer) consume(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != unixSinkMagic || header[4] != unixSinkVersion {
+		return io.ErrUnexpectedEOF
+	}
+	for {
+		f, err := readUnixSinkFrame(r)
+		if err != nil {
+			return err
+		}
+		if f.topic == `fail` {
+			return nil
+		}
+		c.mu.Lock()
+		c.mu.frames = append(c.mu.frames, f)
+		c.mu.Unlock()
+		var ack [8]byte
+		binary.BigEndian.PutUint64(ack[:], f.sequence)
+		if _, err := conn.Write(ack[:]); err != nil {
+			return err
+		}
+	}
+}
+
+func TestUnixSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, `cdc.sock`)
+	ln, err := net.Listen(`unix`, path)
+	require.NoError(t, err)
+	defer ln.Close()
+	consumer := &fakeUnixSinkConsumer{}
+	go consumer.serve(ln)
+
+	makeTopic := func(name string) tableDescriptorTopic {
+		return tableDescriptorTopic{
+			tabledesc.NewBuilder(&descpb.TableDescriptor{Name: name, ID: 52}).BuildImmutableTable()}
+	}
+	makeSink := func(t *testing.T) Sink {
+		sink, err := makeUnixSink(sinkURL{URL: &url.URL{Scheme: `unix`, Path: `/cdc.sock`}}, dir, nil)
+		require.NoError(t, err)
+		require.NoError(t, sink.Dial())
+		return sink
+	}
+
+	t.Run(`emit`, func(t *testing.T) {
+		sink := makeSink(t)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		ts := hlc.Timestamp{WallTime: 1, Logical: 2}
+		require.NoError(t, sink.EmitRow(ctx, makeTopic(`foo`), []byte(`[1]`), []byte(`{"a":1}`), ts, ts.Next(), zeroAlloc))
+		require.NoError(t, sink.EmitRow(ctx, makeTopic(`foo`), []byte(`[2]`), nil, ts, ts, zeroAlloc))
+		require.NoError(t, sink.EmitResolvedTimestamp(ctx, &jsonEncoder{}, ts))
+		require.NoError(t, sink.Flush(ctx))
+
+		consumer.mu.Lock()
+		defer consumer.mu.Unlock()
+		require.Equal(t, []unixSinkFrame{{
+			frameType: unixSinkFrameRow, sequence: 1,
+			topic: `foo`, key: []byte(`[1]`), value: []byte(`{"a":1}`), updated: ts, mvcc: ts.Next(),
+		}, {
+			frameType: unixSinkFrameRow, sequence: 2,
+			topic: `foo`, key: []byte(`[2]`), value: []byte{}, updated: ts, mvcc: ts,
+		}, {
+			frameType: unixSinkFrameResolved, sequence: 3,
+			payload: []byte(`{"__crdb__":{"resolved":"1.0000000002"}}`), resolved: ts,
+		}}, consumer.mu.frames)
+		consumer.mu.frames = nil
+	})
+
+	t.Run(`connection failure`, func(t *testing.T) {
+		sink := makeSink(t)
+		defer func() { require.NoError(t, sink.Close()) }()
+
+		require.NoError(t, sink.EmitRow(ctx, makeTopic(`fail`), []byte(`[1]`), []byte(`{}`), zeroTS, zeroTS, zeroAlloc))
+		require.Regexp(t, `unix sink connection failed`, sink.Flush(ctx))
+	})
+
+	t.Run(`invalid params`, func(t *testing.T) {
+		for uri, expectedErr := range map[string]string{
+			`unix://localhost/cdc.sock`: `unix sink URL must not have a host, found "localhost"`,
+			`unix://`:                   `path of the socket must be specified for unix sink`,
+			`unix:///cdc.sock?foo=bar`:  `unknown unix sink query parameters: foo`,
+			`unix:///../escape.sock`:    `local file access to paths outside of external-io-dir is not allowed: /../escape.sock`,
+		} {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			_, err = makeUnixSink(sinkURL{URL: u}, dir, nil)
+			require.EqualError(t, err, expectedErr, uri)
+		}
+
+		u, err := url.Parse(`unix:///cdc.sock`)
+		require.NoError(t, err)
+		_, err = makeUnixSink(sinkURL{URL: u}, ``, nil)
+		require.EqualError(t, err, `local file access is disabled`)
+	})
+}
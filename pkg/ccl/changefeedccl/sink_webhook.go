@@ -18,7 +18,6 @@ import (
 	"hash/crc32"
 	"io/ioutil"
 	"math"
-	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -325,6 +324,7 @@ func makeWebhookSink(
 	params.Del(changefeedbase.SinkParamCACert)
 	params.Del(changefeedbase.SinkParamClientCert)
 	params.Del(changefeedbase.SinkParamClientKey)
+	params.Del(changefeedbase.SinkParamDNSCacheTTL)
 	sinkURLParsed.RawQuery = params.Encode()
 	sink.url = sinkURL{URL: sinkURLParsed}
 
@@ -332,12 +332,19 @@ func makeWebhookSink(
 }
 
 func makeWebhookClient(u sinkURL, timeout time.Duration) (*httputil.Client, error) {
+	dnsCacheTTL := defaultDNSCacheTTL
+	if v := u.consumeParam(changefeedbase.SinkParamDNSCacheTTL); v != `` {
+		var err error
+		if dnsCacheTTL, err = time.ParseDuration(v); err != nil || dnsCacheTTL < 0 {
+			return nil, errors.Errorf(`param %s must be a non-negative duration: %q`,
+				changefeedbase.SinkParamDNSCacheTTL, v)
+		}
+	}
+	transport := newSinkHTTPTransport(timeout, dnsCacheTTL)
 	client := &httputil.Client{
 		Client: &http.Client{
-			Timeout: timeout,
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{Timeout: timeout}).DialContext,
-			},
+			Timeout:   timeout,
+			Transport: transport,
 		},
 	}
 
@@ -348,8 +355,6 @@ func makeWebhookClient(u sinkURL, timeout time.Duration) (*httputil.Client, erro
 		clientKey     []byte
 	}{}
 
-	transport := client.Transport.(*http.Transport)
-
 	if _, err := u.consumeBool(changefeedbase.SinkParamSkipTLSVerify, &dialConfig.tlsSkipVerify); err != nil {
 		return nil, err
 	}
@@ -609,7 +614,7 @@ func (s *webhookSink) sendMessage(ctx context.Context, reqBody []byte) error {
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
+	defer gracefulClose(ctx, res.Body)
 
 	if !(res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices) {
 		resBody, err := ioutil.ReadAll(res.Body)
@@ -621,6 +626,31 @@ func (s *webhookSink) sendMessage(ctx context.Context, reqBody []byte) error {
 	return nil
 }
 
+// CheckHealth implements the SinkWithHealthCheck interface. The endpoint is
+// healthy if it accepts the request, or only rejects its HEAD method; other
+// responses, e.g. 401 for revoked credentials or 5xx for a failing backend,
+// mean that rows couldn't be delivered to it.
+func (s *webhookSink) CheckHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url.String(), nil)
+	if err != nil {
+		return err
+	}
+	if s.authHeader != "" {
+		req.Header.Set(authorizationHeader, s.authHeader)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer gracefulClose(ctx, res.Body)
+
+	if res.StatusCode == http.StatusMethodNotAllowed ||
+		(res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices) {
+		return nil
+	}
+	return errors.Newf("health check failed with HTTP status: %s", res.Status)
+}
+
 // workerIndex assigns rows each to a worker goroutine based on the hash of its
 // primary key. This is to ensure that each message with the same key gets
 // deterministically assigned to the same worker. Since we have a channel per
@@ -735,6 +765,13 @@ func (s *webhookSink) Flush(ctx context.Context) error {
 	}
 }
 
+// FlushDurable implements the SinkWithDurableAck interface. Flush waits for a
+// successful response to every request, with which the endpoint accepts the
+// messages.
+func (s *webhookSink) FlushDurable(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
 func (s *webhookSink) Close() error {
 	s.exitWorkers()
 	// ignore errors here since we're closing the sink anyway
@@ -12,6 +12,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -266,11 +267,14 @@ func TestWebhookSinkWithAuthOptions(t *testing.T) {
 		require.NoError(t, err)
 
 		testSendAndReceiveRows(t, sinkSrc, sinkDest)
+		require.NoError(t, sinkSrc.(*webhookSink).CheckHealth(context.Background()))
 
 		// no credentials should result in a 401
 		delete(opts, changefeedbase.OptWebhookAuthHeader)
 		sinkSrcNoCreds, err := setupWebhookSinkWithDetails(context.Background(), details, parallelism, timeutil.DefaultTimeSource{})
 		require.NoError(t, err)
+		require.EqualError(t, sinkSrcNoCreds.(*webhookSink).CheckHealth(context.Background()),
+			"health check failed with HTTP status: 401 Unauthorized")
 		require.NoError(t, sinkSrcNoCreds.EmitRow(context.Background(), nil, []byte("[1001]"), []byte("{\"after\":{\"col1\":\"val1\",\"rowid\":1000},\"key\":[1001],\"topic:\":\"foo\"}"), zeroTS, zeroTS, zeroAlloc))
 
 		require.EqualError(t, sinkSrcNoCreds.Flush(context.Background()), "401 Unauthorized: ")
@@ -634,3 +638,60 @@ func TestWebhookSinkShutsDownOnError(t *testing.T) {
 		webhookSinkTestfn(i)
 	}
 }
+
+func TestWebhookSinkReusesConnections(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	cert, _, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+
+	// The sink is addressed by host name, so that its addresses are looked up,
+	// which the certificate of the mock sink isn't valid for.
+	sinkDestHost, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	sinkDestHost.Host = net.JoinHostPort(`localhost`, sinkDestHost.Port())
+	params := sinkDestHost.Query()
+	params.Set(changefeedbase.SinkParamSkipTLSVerify, "true")
+	sinkDestHost.RawQuery = params.Encode()
+
+	details := jobspb.ChangefeedDetails{
+		SinkURI: fmt.Sprintf("webhook-%s", sinkDestHost.String()),
+		Opts:    getGenericWebhookSinkOptions(),
+	}
+
+	connsOpened := sinkHTTPStats.connsOpened.Count()
+	connsReused := sinkHTTPStats.connsReused.Count()
+	dnsLookups := sinkHTTPStats.dnsLookups.Count()
+
+	sinkSrc, err := setupWebhookSinkWithDetails(ctx, details, 1, timeutil.DefaultTimeSource{})
+	require.NoError(t, err)
+
+	const numEmits = 100
+	var pool testAllocPool
+	for i := 0; i < numEmits; i++ {
+		key := []byte(fmt.Sprintf("[%d]", i))
+		value := []byte(fmt.Sprintf(`{"after":{"col1":"val1","rowid":%d},"key":[%d],"topic:":"foo"}`, i, i))
+		require.NoError(t, sinkSrc.EmitRow(ctx, nil, key, value, zeroTS, zeroTS, pool.alloc()))
+		require.NoError(t, sinkSrc.Flush(ctx))
+	}
+	require.EqualValues(t, 0, pool.used())
+
+	// Every request after the first is sent over the connection it opened,
+	// whose host was looked up once.
+	require.Equal(t, int64(1), sinkHTTPStats.connsOpened.Count()-connsOpened)
+	require.GreaterOrEqual(t, sinkHTTPStats.connsReused.Count()-connsReused, int64(numEmits-1))
+	require.Equal(t, int64(1), sinkHTTPStats.dnsLookups.Count()-dnsLookups)
+
+	require.NoError(t, sinkSrc.Close())
+	sinkDest.Close()
+
+	// Bad DNS cache TTLs are rejected.
+	params.Set(changefeedbase.SinkParamDNSCacheTTL, "-1s")
+	sinkDestHost.RawQuery = params.Encode()
+	details.SinkURI = fmt.Sprintf("webhook-%s", sinkDestHost.String())
+	_, err = setupWebhookSinkWithDetails(ctx, details, 1, timeutil.DefaultTimeSource{})
+	require.EqualError(t, err, `param dns_cache_ttl must be a non-negative duration: "-1s"`)
+}
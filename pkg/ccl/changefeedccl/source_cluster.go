@@ -0,0 +1,43 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+)
+
+// sourceClusterField returns the source_cluster field added to the metadata
+// of rows and resolved timestamps for the source_cluster option, which tells
+// consumers aggregating the changefeeds of several clusters which cluster, and
+// which tenant of it, emitted each message.
+func sourceClusterField(cfg *execinfra.ServerConfig) (map[string]interface{}, error) {
+	_, tenantID, err := keys.DecodeTenantPrefix(cfg.Codec.TenantPrefix())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		`cluster_id`: cfg.ClusterID.Get().String(),
+		`tenant_id`:  int64(tenantID.ToUint64()),
+	}, nil
+}
+
+// setSourceCluster sets the source_cluster field of the encoder, which is
+// only added by JSON encoders with the source_cluster option.
+func setSourceCluster(e Encoder, field map[string]interface{}) {
+	switch e := e.(type) {
+	case *jsonEncoder:
+		e.sourceCluster = field
+	case *perTargetEncoder:
+		setSourceCluster(e.Encoder, field)
+		for _, targetEncoder := range e.targets {
+			setSourceCluster(targetEncoder, field)
+		}
+	}
+}
@@ -680,11 +680,18 @@ func (c *tableFeed) Next() (*cdctest.TestFeedMessage, error) {
 			for rows.Next() {
 				m := &cdctest.TestFeedMessage{}
 				var msgID int64
+				var compression gosql.NullString
 				if err := rows.Scan(
-					&m.Topic, &m.Partition, &msgID, &m.Key, &m.Value, &m.Resolved,
+					&m.Topic, &m.Partition, &msgID, &m.Key, &m.Value, &m.Resolved, &compression,
 				); err != nil {
 					return err
 				}
+				if m.Value, err = decompressSQLSinkPayload(compression, m.Value); err != nil {
+					return err
+				}
+				if m.Resolved, err = decompressSQLSinkPayload(compression, m.Resolved); err != nil {
+					return err
+				}
 
 				// Scan turns NULL bytes columns into a 0-length, non-nil byte
 				// array, which is pretty unexpected. Nil them out before returning.
@@ -1251,8 +1258,11 @@ func (k *kafkaFeed) Next() (*cdctest.TestFeedMessage, error) {
 		if err := decode(msg.Key, &fm.Key); err != nil {
 			return nil, err
 		}
-		if err := decode(msg.Value, &fm.Value); err != nil {
-			return nil, err
+		// Tombstones have no value to decode.
+		if msg.Value != nil {
+			if err := decode(msg.Value, &fm.Value); err != nil {
+				return nil, err
+			}
 		}
 
 		if isNew := k.markSeen(fm); isNew {
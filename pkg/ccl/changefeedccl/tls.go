@@ -12,10 +12,8 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
-	"net/http"
 	"strconv"
 
-	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -40,7 +38,32 @@ func decodeBase64FromString(src string, dest *[]byte) error {
 	return nil
 }
 
-func newClientFromTLSKeyPair(caCert []byte) (*httputil.Client, error) {
+// makeTLSConfig returns the TLS configuration for connecting to serverName,
+// trusting caCert in addition to the system root CAs if it is set.
+func makeTLSConfig(serverName string, caCert []byte, skipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	}
+	if caCert != nil {
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load system root CA pool")
+		}
+		if caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("failed to parse certificate data:%s", string(caCert))
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
+
+// newTLSConfigFromCACert returns the TLS configuration of clients trusting the
+// system's root CAs and caCert.
+func newTLSConfigFromCACert(caCert []byte) (*tls.Config, error) {
 	rootCAs, err := x509.SystemCertPool()
 	if err != nil {
 		return nil, errors.Wrap(err, "could not load system root CA pool")
@@ -53,14 +76,7 @@ func newClientFromTLSKeyPair(caCert []byte) (*httputil.Client, error) {
 		return nil, errors.Errorf("failed to parse certificate data:%s", string(caCert))
 	}
 
-	tlsConfig := &tls.Config{
+	return &tls.Config{
 		RootCAs: rootCAs,
-	}
-
-	client := httputil.NewClientWithTimeout(httputil.StandardHTTPTimeout)
-	transport := client.Client.Transport.(*http.Transport)
-	transport.TLSClientConfig = tlsConfig
-	client.Client.Transport = transport
-
-	return client, nil
+	}, nil
 }
@@ -0,0 +1,151 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strconv"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/errors"
+)
+
+// errInvalidTopicColumnValue marks the errors of rows whose column value
+// can't name a topic, which are emitted to the dead letter sink, if there is
+// one, rather than failing the changefeed.
+var errInvalidTopicColumnValue = errors.New(`invalid topic_from_column value`)
+
+// defaultTopicFromColumnMaxTopics is the number of topics each change
+// aggregator may derive for the topic_from_column option, unless
+// topic_from_column_max_topics says otherwise.
+const defaultTopicFromColumnMaxTopics = 100
+
+// getTopicFromColumnMaxTopics returns the number of topics each change
+// aggregator may derive for the topic_from_column option.
+func getTopicFromColumnMaxTopics(opts map[string]string) (int, error) {
+	v, ok := opts[changefeedbase.OptTopicFromColumnMaxTopics]
+	if !ok {
+		return defaultTopicFromColumnMaxTopics, nil
+	}
+	maxTopics, err := strconv.Atoi(v)
+	if err != nil || maxTopics <= 0 {
+		return 0, errors.Errorf(`%s must be a positive integer: %q`,
+			changefeedbase.OptTopicFromColumnMaxTopics, v)
+	}
+	return maxTopics, nil
+}
+
+// validateTopicColumn returns an error if the column named by the
+// topic_from_column option can't be used to route the rows of the table.
+func validateTopicColumn(tableDesc catalog.TableDescriptor, column string) error {
+	col, err := tableDesc.FindColumnWithName(tree.Name(column))
+	if err != nil || !col.Public() {
+		return errors.Errorf(`%s column %q does not exist in table %s`,
+			changefeedbase.OptTopicFromColumn, column, tableDesc.GetName())
+	}
+	switch col.GetType().Family() {
+	case types.StringFamily, types.IntFamily:
+		return nil
+	default:
+		return errors.Errorf(`%s column %q of table %s must be a STRING or INT, not %s`,
+			changefeedbase.OptTopicFromColumn, column, tableDesc.GetName(), col.GetType().SQLString())
+	}
+}
+
+// columnTopic is the topic of a row routed by the topic_from_column option:
+// the topic of its table, renamed after the value of the column.
+type columnTopic struct {
+	TopicDescriptor
+	value string
+}
+
+// columnTopicRouter routes rows to topics named after the value of the column
+// of the topic_from_column option. The sink names the topic by prefixing the
+// value with its topic prefix. Rows whose value is NULL are routed to the
+// topic of their table.
+//
+// Ordering guarantees are unchanged within each topic: every version of a row
+// with the same key and column value is emitted to the same topic in order.
+// Versions of a row whose column value changes are emitted to different
+// topics, so consumers can't order them relative to one another.
+type columnTopicRouter struct {
+	column    string
+	maxTopics int
+	// topics are the distinct values routed to so far.
+	topics map[string]struct{}
+	alloc  tree.DatumAlloc
+}
+
+func makeColumnTopicRouter(column string, maxTopics int) *columnTopicRouter {
+	return &columnTopicRouter{
+		column:    column,
+		maxTopics: maxTopics,
+		topics:    make(map[string]struct{}),
+	}
+}
+
+// topicFor returns the topic of the row. Deleted rows are routed by the value
+// of the column before the delete, since the columns of a deleted row which
+// are not part of its primary key are NULL.
+func (r *columnTopicRouter) topicFor(row encodeRow) (TopicDescriptor, error) {
+	tableTopic := tableDescriptorTopic{row.tableDesc}
+	desc, datums := row.tableDesc, row.datums
+	if row.deleted {
+		if row.prevDeleted || row.prevDatums == nil {
+			return tableTopic, nil
+		}
+		desc, datums = row.prevTableDesc, row.prevDatums
+	}
+
+	ord := -1
+	for i, col := range desc.PublicColumns() {
+		if col.GetName() == r.column {
+			ord = i
+			break
+		}
+	}
+	if ord < 0 {
+		return nil, errors.Errorf(`%s column %q does not exist in table %s`,
+			changefeedbase.OptTopicFromColumn, r.column, desc.GetName())
+	}
+	datum := datums[ord]
+	if err := datum.EnsureDecoded(desc.PublicColumns()[ord].GetType(), &r.alloc); err != nil {
+		return nil, err
+	}
+
+	var value string
+	switch d := datum.Datum.(type) {
+	case *tree.DString:
+		value = string(*d)
+	case *tree.DInt:
+		value = strconv.FormatInt(int64(*d), 10)
+	default:
+		if datum.Datum == tree.DNull {
+			return tableTopic, nil
+		}
+		return nil, errors.Mark(errors.Errorf(`%s column %q of table %s must be a STRING or INT, not %s`,
+			changefeedbase.OptTopicFromColumn, r.column, desc.GetName(), datum.Datum.ResolvedType().SQLString()),
+			errInvalidTopicColumnValue)
+	}
+	if err := validateKafkaTopicName(value); err != nil {
+		return nil, errors.Mark(errors.Wrapf(err, `invalid topic derived from %s column %q of table %s`,
+			changefeedbase.OptTopicFromColumn, r.column, desc.GetName()), errInvalidTopicColumnValue)
+	}
+	if _, ok := r.topics[value]; !ok {
+		if len(r.topics) >= r.maxTopics {
+			return nil, errors.WithHintf(
+				errors.Errorf(`%s derived more than %d topics`, changefeedbase.OptTopicFromColumn, r.maxTopics),
+				`the limit can be raised with the %s option`, changefeedbase.OptTopicFromColumnMaxTopics)
+		}
+		r.topics[value] = struct{}{}
+	}
+	return columnTopic{TopicDescriptor: tableTopic, value: value}, nil
+}
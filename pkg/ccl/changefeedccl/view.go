@@ -0,0 +1,317 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/errors"
+)
+
+// Changefeeds can target views which project columns of a single table, e.g.
+//
+//   CREATE VIEW v AS SELECT a, b AS c FROM t
+//
+// by watching the table and emitting its rows as the rows of the view: each
+// change to a row of t is emitted as a row of v with columns a and c, under
+// the topic of v. The view is expanded when the changefeed is created: its
+// columns are captured by the ID of the columns of the table they project, so
+// the changefeed keeps emitting the same columns even if the view is replaced
+// later on. Since a view has a single table, its resolved timestamps are
+// those of the table: every change to the view's rows at or before a resolved
+// timestamp has been emitted.
+//
+// Only views whose query selects columns of a single table, without any
+// filter, expression, join, aggregation, ordering or limit, are supported, and
+// they must select the primary key columns of the table, which key the rows
+// of the view. Changes to columns of the table which the view doesn't select
+// are emitted as rows of the view which didn't change.
+//
+// TODO(cdc): Views joining several tables would require re-evaluating the
+// join for each change of any of them, which can change any number of rows
+// of the view, and resolved timestamps spanning all of their tables.
+
+// resolveViewTarget returns the table watched for a view, along with the
+// projection of its columns, or an error if the shape of the view's query
+// isn't supported.
+func resolveViewTarget(
+	ctx context.Context,
+	p sql.PlanHookState,
+	view catalog.TableDescriptor,
+	statementTime hlc.Timestamp,
+) (catalog.TableDescriptor, *jobspb.ChangefeedView, error) {
+	if view.MaterializedView() {
+		return nil, nil, errors.Errorf(`CHANGEFEED cannot target materialized views: %s`, view.GetName())
+	}
+	unsupported := func(reason string) error {
+		return errors.WithHint(
+			errors.Errorf(`CHANGEFEED cannot target view %s: %s`, view.GetName(), reason),
+			`only views selecting columns of a single table, including its primary key, are supported`)
+	}
+
+	stmt, err := parser.ParseOne(view.GetViewQuery())
+	if err != nil {
+		return nil, nil, err
+	}
+	sel, ok := stmt.AST.(*tree.Select)
+	if !ok {
+		return nil, nil, unsupported(`its query is not a SELECT`)
+	}
+	if sel.With != nil || sel.OrderBy != nil || sel.Limit != nil || sel.Locking != nil {
+		return nil, nil, unsupported(`its query has a WITH, ORDER BY, LIMIT or locking clause`)
+	}
+	clause, ok := sel.Select.(*tree.SelectClause)
+	if !ok {
+		return nil, nil, unsupported(`its query is not a simple SELECT`)
+	}
+	if clause.Distinct || clause.DistinctOn != nil || clause.GroupBy != nil ||
+		clause.Having != nil || clause.Window != nil {
+		return nil, nil, unsupported(`its query aggregates rows`)
+	}
+	if clause.Where != nil {
+		return nil, nil, unsupported(`its query filters rows`)
+	}
+	if len(clause.From.Tables) != 1 {
+		return nil, nil, unsupported(`its query selects from more than one table`)
+	}
+	from, ok := clause.From.Tables[0].(*tree.AliasedTableExpr)
+	if !ok {
+		return nil, nil, unsupported(`its query joins tables`)
+	}
+	tn, ok := from.Expr.(*tree.TableName)
+	if !ok {
+		return nil, nil, unsupported(`its query does not select from a table`)
+	}
+	if len(view.GetDependsOn()) != 1 {
+		return nil, nil, unsupported(`its query depends on more than one relation`)
+	}
+
+	// The names in the view's query are fully qualified, so they resolve to
+	// the same table regardless of the current database.
+	descs, err := getTableDescriptors(ctx, p,
+		&tree.TargetList{Tables: tree.TablePatterns{tn}}, statementTime, hlc.Timestamp{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var table catalog.TableDescriptor
+	for _, desc := range descs {
+		if t, ok := desc.(catalog.TableDescriptor); ok && t.GetID() == view.GetDependsOn()[0] {
+			table = t
+		}
+	}
+	if table == nil {
+		return nil, nil, errors.Errorf(`table %s of view %s not found`, tn, view.GetName())
+	}
+	if table.IsView() {
+		return nil, nil, unsupported(`its query selects from view ` + table.GetName())
+	}
+	if table.IsSequence() || table.IsVirtualTable() {
+		return nil, nil, unsupported(`its query does not select from a table`)
+	}
+
+	viewColumns := view.PublicColumns()
+	if len(clause.Exprs) != len(viewColumns) {
+		return nil, nil, errors.AssertionFailedf(`view %s has %d columns but its query selects %d`,
+			view.GetName(), len(viewColumns), len(clause.Exprs))
+	}
+	projection := &jobspb.ChangefeedView{ID: view.GetID(), Name: view.GetName()}
+	projected := make(map[descpb.ColumnID]struct{}, len(clause.Exprs))
+	for i, expr := range clause.Exprs {
+		colName, ok := expr.Expr.(*tree.UnresolvedName)
+		if !ok || colName.Star {
+			return nil, nil, unsupported(`its query selects an expression: ` + tree.AsString(expr.Expr))
+		}
+		col, err := table.FindColumnWithName(tree.Name(colName.Parts[0]))
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := projected[col.GetID()]; ok {
+			return nil, nil, unsupported(`its query selects column ` + col.GetName() + ` more than once`)
+		}
+		projected[col.GetID()] = struct{}{}
+		projection.Columns = append(projection.Columns, jobspb.ChangefeedView_Column{
+			Name:     viewColumns[i].GetName(),
+			ColumnID: col.GetID(),
+		})
+	}
+	primaryIndex := table.GetPrimaryIndex()
+	for i := 0; i < primaryIndex.NumKeyColumns(); i++ {
+		if _, ok := projected[primaryIndex.GetKeyColumnID(i)]; !ok {
+			return nil, nil, unsupported(`its query does not select primary key column ` +
+				primaryIndex.GetKeyColumnName(i) + ` of table ` + table.GetName())
+		}
+	}
+	return table, projection, nil
+}
+
+// targetIDOf returns the ID under which the descriptor of a target is
+// watched: the ID of its table if it's a view.
+func targetIDOf(targets jobspb.ChangefeedTargets, id descpb.ID) descpb.ID {
+	for tableID, target := range targets {
+		if target.View != nil && target.View.ID == id {
+			return tableID
+		}
+	}
+	return id
+}
+
+// projectViewTable returns a descriptor of the rows of a view over a version
+// of its table: a copy of the table's descriptor named after the view, with
+// only the columns selected by the view, named as they are in the view and
+// in its order.
+func projectViewTable(
+	table catalog.TableDescriptor, view *jobspb.ChangefeedView,
+) (catalog.TableDescriptor, error) {
+	desc := protoutil.Clone(table.TableDesc()).(*descpb.TableDescriptor)
+	desc.Name = view.Name
+	byID := make(map[descpb.ColumnID]descpb.ColumnDescriptor, len(desc.Columns))
+	for _, col := range desc.Columns {
+		byID[col.ID] = col
+	}
+	names := make(map[descpb.ColumnID]string, len(view.Columns))
+	desc.Columns = make([]descpb.ColumnDescriptor, 0, len(view.Columns))
+	for _, c := range view.Columns {
+		col, ok := byID[c.ColumnID]
+		if !ok {
+			return nil, errors.Errorf(`column %s of view %s no longer exists in table %s`,
+				c.Name, view.Name, table.GetName())
+		}
+		col.Name = c.Name
+		desc.Columns = append(desc.Columns, col)
+		names[c.ColumnID] = c.Name
+	}
+	projectColumns := func(ids []descpb.ColumnID) ([]descpb.ColumnID, []string) {
+		var keptIDs []descpb.ColumnID
+		var keptNames []string
+		for _, id := range ids {
+			if name, ok := names[id]; ok {
+				keptIDs = append(keptIDs, id)
+				keptNames = append(keptNames, name)
+			}
+		}
+		return keptIDs, keptNames
+	}
+	for i, id := range desc.PrimaryIndex.KeyColumnIDs {
+		name, ok := names[id]
+		if !ok {
+			return nil, errors.Errorf(`primary key column %s of table %s is not a column of view %s`,
+				desc.PrimaryIndex.KeyColumnNames[i], table.GetName(), view.Name)
+		}
+		desc.PrimaryIndex.KeyColumnNames[i] = name
+	}
+	desc.PrimaryIndex.StoreColumnIDs, desc.PrimaryIndex.StoreColumnNames = projectColumns(
+		desc.PrimaryIndex.StoreColumnIDs)
+	for i := range desc.Families {
+		family := &desc.Families[i]
+		family.ColumnIDs, family.ColumnNames = projectColumns(family.ColumnIDs)
+	}
+	// The rows of the view are only ever encoded, from its public columns and
+	// primary key.
+	desc.Indexes = nil
+	desc.Checks = nil
+	desc.Mutations = nil
+	desc.UniqueWithoutIndexConstraints = nil
+	return tabledesc.NewBuilder(desc).BuildImmutableTable(), nil
+}
+
+// viewProjector projects the rows of tables watched for views into rows of
+// the views.
+type viewProjector struct {
+	// views are the views of the watched tables, by the ID of their table.
+	views map[descpb.ID]*jobspb.ChangefeedView
+	// projections are the projections of each version of the tables.
+	projections map[tableIDAndVersion]*viewProjection
+}
+
+// viewProjection projects the rows of a version of a table into rows of its
+// view.
+type viewProjection struct {
+	desc catalog.TableDescriptor
+	// ordinals are the ordinals of the public columns of the table which
+	// make up the columns of the view.
+	ordinals []int
+}
+
+// makeViewProjector returns a viewProjector for the targets of a changefeed,
+// or nil if none of them is a view.
+func makeViewProjector(targets jobspb.ChangefeedTargets) *viewProjector {
+	var v *viewProjector
+	for id, target := range targets {
+		if target.View == nil {
+			continue
+		}
+		if v == nil {
+			v = &viewProjector{
+				views:       make(map[descpb.ID]*jobspb.ChangefeedView),
+				projections: make(map[tableIDAndVersion]*viewProjection),
+			}
+		}
+		v.views[id] = target.View
+	}
+	return v
+}
+
+// project replaces the row, and its previous version if any, with the rows
+// of the view of its table, if its table is watched for a view.
+func (v *viewProjector) project(r *encodeRow) error {
+	view, ok := v.views[r.tableDesc.GetID()]
+	if !ok {
+		return nil
+	}
+	var err error
+	if r.tableDesc, r.datums, err = v.projectRow(view, r.tableDesc, r.datums); err != nil {
+		return err
+	}
+	if r.prevTableDesc != nil {
+		r.prevTableDesc, r.prevDatums, err = v.projectRow(view, r.prevTableDesc, r.prevDatums)
+	}
+	return err
+}
+
+func (v *viewProjector) projectRow(
+	view *jobspb.ChangefeedView, desc catalog.TableDescriptor, datums rowenc.EncDatumRow,
+) (catalog.TableDescriptor, rowenc.EncDatumRow, error) {
+	key := makeTableIDAndVersion(desc.GetID(), desc.GetVersion())
+	p, ok := v.projections[key]
+	if !ok {
+		projected, err := projectViewTable(desc, view)
+		if err != nil {
+			return nil, nil, err
+		}
+		p = &viewProjection{desc: projected}
+		ordinals := catalog.ColumnIDToOrdinalMap(desc.PublicColumns())
+		for _, c := range view.Columns {
+			ord, ok := ordinals.Get(c.ColumnID)
+			if !ok {
+				return nil, nil, errors.Errorf(`column %s of view %s is not public in table %s`,
+					c.Name, view.Name, desc.GetName())
+			}
+			p.ordinals = append(p.ordinals, ord)
+		}
+		v.projections[key] = p
+	}
+	if datums == nil {
+		return p.desc, nil, nil
+	}
+	projected := make(rowenc.EncDatumRow, len(p.ordinals))
+	for i, ord := range p.ordinals {
+		projected[i] = datums[ord]
+	}
+	return p.desc, projected, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+// watchColumns are the columns of the watch_columns option: updates which
+// change none of them are dropped.
+type watchColumns []string
+
+// parseWatchColumns parses the comma separated column names of the
+// watch_columns option.
+func parseWatchColumns(s string) (watchColumns, error) {
+	var columns watchColumns
+	for _, column := range strings.Split(s, `,`) {
+		column = strings.TrimSpace(column)
+		if column == `` {
+			return nil, errors.Errorf(`%s must be a comma separated list of column names: %q`,
+				changefeedbase.OptWatchColumns, s)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// validate returns an error if one of the columns doesn't exist in the
+// table.
+func (c watchColumns) validate(tableDesc catalog.TableDescriptor) error {
+	for _, column := range c {
+		col, err := tableDesc.FindColumnWithName(tree.Name(column))
+		if err != nil || !col.Public() {
+			return errors.Errorf(`%s column %q does not exist in table %s`,
+				changefeedbase.OptWatchColumns, column, tableDesc.GetName())
+		}
+	}
+	return nil
+}
+
+// changed returns whether the row is not an update, or is an update which
+// changed at least one of the columns. Inserts, deletes and the rows scanned
+// by initial scans and backfills always count as changed, and so does a
+// column added or dropped since the previous version of the row.
+func (c watchColumns) changed(
+	r encodeRow, evalCtx *tree.EvalContext, alloc *tree.DatumAlloc,
+) (bool, error) {
+	if r.backfill || r.deleted || r.prevDeleted || r.prevDatums == nil {
+		return true, nil
+	}
+	for _, column := range c {
+		datum, ok, err := columnDatum(r.tableDesc, r.datums, column, alloc)
+		if err != nil || !ok {
+			return true, err
+		}
+		prevDatum, ok, err := columnDatum(r.prevTableDesc, r.prevDatums, column, alloc)
+		if err != nil || !ok {
+			return true, err
+		}
+		if datum.Compare(evalCtx, prevDatum) != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// columnDatum returns the decoded datum of the named column of a row, and
+// whether the table has the column.
+func columnDatum(
+	desc catalog.TableDescriptor, datums rowenc.EncDatumRow, column string, alloc *tree.DatumAlloc,
+) (tree.Datum, bool, error) {
+	for i, col := range desc.PublicColumns() {
+		if col.GetName() != column {
+			continue
+		}
+		if err := datums[i].EnsureDecoded(col.GetType(), alloc); err != nil {
+			return nil, false, err
+		}
+		return datums[i].Datum, true, nil
+	}
+	return nil, false, nil
+}
@@ -103,7 +103,7 @@ func streamKVs(
 	telemetry.Count(`replication.create.ok`)
 	var checkpoint jobspb.ChangefeedProgress_Checkpoint
 	if err := changefeeddist.StartDistChangefeed(
-		ctx, p, 0, details, spans, startTS, checkpoint, resultsCh,
+		ctx, p, 0, details, spans, startTS, checkpoint, nil /* sequences */, 0 /* epoch */, resultsCh,
 	); err != nil {
 		telemetry.Count("replication.done.fail")
 		return err
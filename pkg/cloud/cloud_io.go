@@ -16,6 +16,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,6 +27,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/sysutil"
@@ -48,6 +50,19 @@ var httpCustomCA = settings.RegisterStringSetting(
 	"",
 ).WithPublic()
 
+var httpDNSCacheTTL = settings.RegisterDurationSetting(
+	settings.TenantWritable,
+	"cloudstorage.http.dns_cache_ttl",
+	"how long the addresses of the hosts of HTTP and S3 storage are cached for (0 disables the cache)",
+	30*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// httpMaxIdleConnsPerHost bounds the idle connections kept open to each host
+// of cloud storage, so that long-running clients, e.g. those of changefeeds
+// uploading files, reuse their connections rather than reopening them.
+const httpMaxIdleConnsPerHost = 64
+
 // HTTPRetryOptions defines the tunable settings which control the retry of HTTP
 // operations.
 var HTTPRetryOptions = retry.Options{
@@ -58,7 +73,9 @@ var HTTPRetryOptions = retry.Options{
 }
 
 // MakeHTTPClient makes an http client configured with the common settings used
-// for interacting with cloud storage (timeouts, retries, CA certs, etc).
+// for interacting with cloud storage (timeouts, retries, CA certs, etc). Its
+// connections are kept open between requests, and the addresses of their
+// hosts are cached for cloudstorage.http.dns_cache_ttl.
 func MakeHTTPClient(settings *cluster.Settings) (*http.Client, error) {
 	var tlsConf *tls.Config
 	if pem := httpCustomCA.Get(&settings.SV); pem != "" {
@@ -74,6 +91,10 @@ func MakeHTTPClient(settings *cluster.Settings) (*http.Client, error) {
 	t := http.DefaultTransport.(*http.Transport).Clone()
 	// Add our custom CA.
 	t.TLSClientConfig = tlsConf
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t.DialContext = httputil.NewCachingDialer(
+		dialer, httpDNSCacheTTL.Get(&settings.SV), httputil.DialerStats{}).DialContext
+	t.MaxIdleConnsPerHost = httpMaxIdleConnsPerHost
 	return &http.Client{Transport: t}, nil
 }
 
@@ -331,6 +352,19 @@ func (s *backgroundPipe) Close() error {
 // WriteFile is a helper for writing the content of a Reader to the given path
 // of an ExternalStorage.
 func WriteFile(ctx context.Context, dest ExternalStorage, basename string, src io.Reader) error {
+	return WriteFileWithMetadata(ctx, dest, basename, src, nil /* metadata */)
+}
+
+// WriteFileWithMetadata is like WriteFile, but attaches the given metadata to
+// the file if dest is an ExternalStorageWithMetadata. Other destinations
+// ignore the metadata.
+func WriteFileWithMetadata(
+	ctx context.Context,
+	dest ExternalStorage,
+	basename string,
+	src io.Reader,
+	metadata map[string]string,
+) error {
 	var span *tracing.Span
 	ctx, span = tracing.ChildSpan(ctx, fmt.Sprintf("%s.WriteFile", dest.Conf().Provider.String()))
 	defer span.Finish()
@@ -339,7 +373,13 @@ func WriteFile(ctx context.Context, dest ExternalStorage, basename string, src i
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
-	w, err := dest.Writer(ctx, basename)
+	var w io.WriteCloser
+	var err error
+	if withMetadata, ok := dest.(ExternalStorageWithMetadata); ok && len(metadata) > 0 {
+		w, err = withMetadata.WriterWithMetadata(ctx, basename, metadata)
+	} else {
+		w, err = dest.Writer(ctx, basename)
+	}
 	if err != nil {
 		return errors.Wrap(err, "opening object for writing")
 	}
@@ -90,6 +90,19 @@ type ExternalStorage interface {
 	Size(ctx context.Context, basename string) (int64, error)
 }
 
+// ExternalStorageWithMetadata is implemented by the ExternalStorage
+// implementations which can attach custom metadata to the files they write.
+type ExternalStorageWithMetadata interface {
+	ExternalStorage
+
+	// WriterWithMetadata is like Writer, but attaches the given metadata to the
+	// file. The file and its metadata only become visible once the writer is
+	// closed.
+	WriterWithMetadata(
+		ctx context.Context, basename string, metadata map[string]string,
+	) (io.WriteCloser, error)
+}
+
 // ListingFn describes functions passed to ExternalStorage.ListFiles.
 type ListingFn func(string) error
 
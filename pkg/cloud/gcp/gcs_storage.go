@@ -18,6 +18,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"unicode"
 
 	gcs "cloud.google.com/go/storage"
 	"github.com/cockroachdb/cockroach/pkg/base"
@@ -155,6 +156,20 @@ func makeGCSStorage(
 }
 
 func (g *gcsStorage) Writer(ctx context.Context, basename string) (io.WriteCloser, error) {
+	return g.WriterWithMetadata(ctx, basename, nil /* metadata */)
+}
+
+var _ cloud.ExternalStorageWithMetadata = (*gcsStorage)(nil)
+
+// WriterWithMetadata implements the cloud.ExternalStorageWithMetadata
+// interface. GCS attaches the metadata to the object when the upload is
+// finalized, so that object finalize notifications carry it.
+func (g *gcsStorage) WriterWithMetadata(
+	ctx context.Context, basename string, metadata map[string]string,
+) (io.WriteCloser, error) {
+	if err := validateObjectMetadata(metadata); err != nil {
+		return nil, err
+	}
 	ctx, sp := tracing.ChildSpan(ctx, "gcs.Writer")
 	defer sp.Finish()
 	sp.RecordStructured(&types.StringValue{Value: fmt.Sprintf("gcs.Writer: %s",
@@ -163,9 +178,53 @@ func (g *gcsStorage) Writer(ctx context.Context, basename string) (io.WriteClose
 	if !gcsChunkingEnabled.Get(&g.settings.SV) {
 		w.ChunkSize = 0
 	}
+	if len(metadata) > 0 {
+		w.Metadata = metadata
+	}
 	return w, nil
 }
 
+// maxObjectMetadataBytes is the limit GCS places on the total size of the
+// keys and values of the custom metadata of an object.
+const maxObjectMetadataBytes = 8 << 10
+
+// validateObjectMetadata returns an error if GCS would reject the custom
+// metadata of an object. Keys are sent as the names of HTTP headers, so they
+// must be HTTP tokens, and values must not contain control characters.
+func validateObjectMetadata(metadata map[string]string) error {
+	var size int
+	for k, v := range metadata {
+		if k == `` {
+			return errors.New("object metadata keys must not be empty")
+		}
+		for _, c := range k {
+			if c > unicode.MaxASCII || !isHTTPTokenChar(byte(c)) {
+				return errors.Newf("invalid character %q in object metadata key %q", c, k)
+			}
+		}
+		for _, c := range v {
+			if unicode.IsControl(c) {
+				return errors.Newf("invalid character %q in value of object metadata key %q", c, k)
+			}
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxObjectMetadataBytes {
+		return errors.Newf("object metadata of %d bytes exceeds the limit of %d bytes",
+			size, maxObjectMetadataBytes)
+	}
+	return nil
+}
+
+// isHTTPTokenChar returns whether c may appear in an HTTP token, as defined by
+// RFC 7230.
+func isHTTPTokenChar(c byte) bool {
+	if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
 // ReadFile is shorthand for ReadFileAt with offset 0.
 func (g *gcsStorage) ReadFile(ctx context.Context, basename string) (io.ReadCloser, error) {
 	reader, _, err := g.ReadFileAt(ctx, basename, 0)
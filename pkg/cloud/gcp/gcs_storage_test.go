@@ -17,6 +17,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
@@ -190,3 +191,28 @@ func TestCompressedGCS(t *testing.T) {
 
 	require.Equal(t, string(content1), string(content2))
 }
+
+func TestValidateObjectMetadata(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	require.NoError(t, validateObjectMetadata(nil))
+	require.NoError(t, validateObjectMetadata(map[string]string{
+		`crdb-table`: `t1`, `crdb-row-count`: `2`, `crdb-note`: `café`,
+	}))
+
+	for _, tc := range []struct {
+		metadata map[string]string
+		err      string
+	}{
+		{map[string]string{``: `v`}, `object metadata keys must not be empty`},
+		{map[string]string{`a key`: `v`}, `invalid character ' ' in object metadata key "a key"`},
+		{map[string]string{`clé`: `v`}, `invalid character 'é' in object metadata key "clé"`},
+		{map[string]string{`k`: "a\nb"}, `invalid character '\n' in value of object metadata key "k"`},
+		{
+			map[string]string{`k`: strings.Repeat(`v`, maxObjectMetadataBytes)},
+			`object metadata of 8193 bytes exceeds the limit of 8192 bytes`,
+		},
+	} {
+		require.EqualError(t, validateObjectMetadata(tc.metadata), tc.err)
+	}
+}
@@ -23,6 +23,13 @@ func (d *delegator) delegateShowChangefeedJobs(n *tree.ShowChangefeedJobs) (tree
 
 	// Note: changefeed_details may contain sensitive credentials in sink_uri. This information is redacted when marshaling
 	// to JSON in ChangefeedDetails.MarshalJSONPB.
+	//
+	// Note: sink_connected is the sink health recorded in the changefeed's
+	// progress by its health checks, and NULL until its sink has been checked,
+	// or if the changefeed isn't running.
+	//
+	// Note: emitted_by_table holds the messages and bytes emitted for each
+	// table, as of the last checkpoint of the changefeed's progress.
 	const (
 		selectClause = `
 WITH payload AS (
@@ -31,7 +38,11 @@ WITH payload AS (
     crdb_internal.pb_to_json(
       'cockroach.sql.jobs.jobspb.Payload', 
       payload, false, true
-    )->'changefeed' AS changefeed_details 
+    )->'changefeed' AS changefeed_details, 
+    crdb_internal.pb_to_json(
+      'cockroach.sql.jobs.jobspb.Progress', 
+      progress, false, true
+    )->'changefeed' AS changefeed_progress 
   FROM 
     system.jobs
 ) 
@@ -63,7 +74,14 @@ SELECT
       table_id = ANY (descriptor_ids)
   ) AS full_table_names, 
   changefeed_details->'opts'->>'topics' AS topics,
-  changefeed_details->'opts'->>'format' AS format 
+  changefeed_details->'opts'->>'format' AS format,
+  CASE WHEN status = 'running' THEN 
+    CASE changefeed_progress->>'sinkHealth' 
+      WHEN 'SINK_CONNECTED' THEN true 
+      WHEN 'SINK_UNREACHABLE' THEN false 
+    END 
+  END AS sink_connected, 
+  changefeed_progress->'emittedByTable' AS emitted_by_table 
 FROM 
   crdb_internal.jobs 
   INNER JOIN payload ON id = job_id`
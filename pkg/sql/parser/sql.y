@@ -318,6 +318,12 @@ func (u *sqlSymUnion) functionReference() tree.FunctionReference {
 func (u *sqlSymUnion) tablePatterns() tree.TablePatterns {
     return u.val.(tree.TablePatterns)
 }
+func (u *sqlSymUnion) changefeedTarget() tree.ChangefeedTarget {
+    return u.val.(tree.ChangefeedTarget)
+}
+func (u *sqlSymUnion) changefeedTargets() tree.ChangefeedTargets {
+    return u.val.(tree.ChangefeedTargets)
+}
 func (u *sqlSymUnion) tableNames() tree.TableNames {
     return u.val.(tree.TableNames)
 }
@@ -849,7 +855,7 @@ func (u *sqlSymUnion) setVar() *tree.SetVar {
 %token <str> RANGE RANGES READ REAL REASON REASSIGN RECURSIVE RECURRING REF REFERENCES REFRESH
 %token <str> REGCLASS REGION REGIONAL REGIONS REGNAMESPACE REGPROC REGPROCEDURE REGROLE REGTYPE REINDEX
 %token <str> RELOCATE REMOVE_PATH RENAME REPEATABLE REPLACE REPLICATION
-%token <str> RELEASE RESET RESTORE RESTRICT RESTRICTED RESUME RETURNING RETRY REVISION_HISTORY
+%token <str> RELEASE RESET RESTORE RESTRICT RESTRICTED RESUME RESYNC RETURNING RETRY REVISION_HISTORY
 %token <str> REVOKE RIGHT ROLE ROLES ROLLBACK ROLLUP ROUTINES ROW ROWS RSHIFT RULE RUNNING
 
 %token <str> SAVEPOINT SCANS SCATTER SCHEDULE SCHEDULES SCHEMA SCHEMAS SCRUB SEARCH SECOND SELECT SEQUENCE SEQUENCES
@@ -1130,6 +1136,7 @@ func (u *sqlSymUnion) setVar() *tree.SetVar {
 %type <[]string> opt_incremental
 %type <tree.KVOption> kv_option
 %type <[]tree.KVOption> kv_option_list opt_with_options var_set_list opt_with_schedule_options
+%type <[]tree.KVOption> opt_changefeed_target_options
 %type <*tree.BackupOptions> opt_with_backup_options backup_options backup_options_list
 %type <*tree.RestoreOptions> opt_with_restore_options restore_options restore_options_list
 %type <*tree.CopyOptions> opt_with_copy_options copy_options copy_options_list
@@ -1399,6 +1406,8 @@ func (u *sqlSymUnion) setVar() *tree.SetVar {
 %type <[]tree.ColumnID> opt_tableref_col_list tableref_col_list
 
 %type <tree.TargetList> targets targets_roles target_types changefeed_targets
+%type <tree.ChangefeedTargets> create_changefeed_targets changefeed_target_list
+%type <tree.ChangefeedTarget> changefeed_target
 %type <*tree.TargetList> opt_on_targets_roles opt_backup_targets
 %type <tree.RoleSpecList> for_grantee_clause
 %type <privilege.List> privileges
@@ -3785,25 +3794,72 @@ create_stats_option:
 // CREATE CHANGEFEED
 // FOR <targets> [INTO sink] [WITH <options>]
 //
+// Targets:
+//    <table> [( <options> )] [, ...]
+//    Options given for a table override the changefeed's options for it.
+//
 // Sink: Data caputre stream stream destination.  Enterprise only.
 create_changefeed_stmt:
-  CREATE CHANGEFEED FOR changefeed_targets opt_changefeed_sink opt_with_options
+  CREATE CHANGEFEED FOR create_changefeed_targets opt_changefeed_sink opt_with_options
   {
+    targets := $4.changefeedTargets()
     $$.val = &tree.CreateChangefeed{
-      Targets: $4.targetList(),
+      Targets: targets.TargetList(),
+      TargetOptions: targets.TargetOptions(),
       SinkURI: $5.expr(),
       Options: $6.kvOptions(),
     }
   }
-| EXPERIMENTAL CHANGEFEED FOR changefeed_targets opt_with_options
+| EXPERIMENTAL CHANGEFEED FOR create_changefeed_targets opt_with_options
   {
     /* SKIP DOC */
+    targets := $4.changefeedTargets()
     $$.val = &tree.CreateChangefeed{
-      Targets: $4.targetList(),
+      Targets: targets.TargetList(),
+      TargetOptions: targets.TargetOptions(),
       Options: $5.kvOptions(),
     }
   }
 
+create_changefeed_targets:
+  changefeed_target_list
+  {
+    $$.val = $1.changefeedTargets()
+  }
+| TABLE changefeed_target_list
+  {
+    $$.val = $2.changefeedTargets()
+  }
+
+changefeed_target_list:
+  changefeed_target
+  {
+    $$.val = tree.ChangefeedTargets{$1.changefeedTarget()}
+  }
+| changefeed_target_list ',' changefeed_target
+  {
+    $$.val = append($1.changefeedTargets(), $3.changefeedTarget())
+  }
+
+changefeed_target:
+  table_name opt_changefeed_target_options
+  {
+    $$.val = tree.ChangefeedTarget{
+      Table: $1.unresolvedObjectName().ToUnresolvedName(),
+      Options: $2.kvOptions(),
+    }
+  }
+
+opt_changefeed_target_options:
+  '(' kv_option_list ')'
+  {
+    $$.val = $2.kvOptions()
+  }
+| /* EMPTY */
+  {
+    $$.val = nil
+  }
+
 changefeed_targets:
   single_table_pattern_list
   {
@@ -4312,7 +4368,10 @@ explain_option_list:
 // %Help: ALTER CHANGEFEED - alter an existing changefeed
 // %Category: CCL
 // %Text:
-// ALTER CHANGEFEED <job_id> {{ADD|DROP} <targets...>}...
+// ALTER CHANGEFEED <job_id> {{ADD|DROP} <targets...> | RESYNC}...
+//
+// The changefeed must be paused, and applies the changes once resumed,
+// unless it only RESYNCs, which a running changefeed restarts to perform.
 alter_changefeed_stmt:
   ALTER CHANGEFEED a_expr alter_changefeed_cmds
   {
@@ -4348,6 +4407,11 @@ alter_changefeed_cmd:
       Targets: $2.targetList(),
     }
   }
+  // ALTER CHANGEFEED <job_id> RESYNC
+| RESYNC
+  {
+    $$.val = &tree.AlterChangefeedResync{}
+  }
 
 // %Help: PREPARE - prepare a statement for later execution
 // %Category: Misc
@@ -13679,6 +13743,7 @@ unreserved_keyword:
 | RESTRICT
 | RESTRICTED
 | RESUME
+| RESYNC
 | RETRY
 | REVISION_HISTORY
 | REVOKE
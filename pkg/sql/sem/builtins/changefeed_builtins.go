@@ -0,0 +1,123 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package builtins
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/errors"
+)
+
+// ChangefeedAvroSchemas returns the key and value Avro schemas a changefeed
+// with the given options would generate for the named table, along with the
+// subjects they would be registered under. It is injected by changefeedccl.
+var ChangefeedAvroSchemas func(
+	evalCtx *tree.EvalContext, tableName string, opts map[string]string,
+) (json.JSON, error)
+
+func initChangefeedBuiltins() {
+	// Add all changefeedBuiltins to the Builtins map after a sanity check.
+	for k, v := range changefeedBuiltins {
+		if _, exists := builtins[k]; exists {
+			panic("duplicate builtin: " + k)
+		}
+		builtins[k] = v
+	}
+}
+
+// changefeedBuiltins contains the changefeed built-in functions indexed by
+// name.
+//
+// For use in other packages, see AllBuiltinNames and GetBuiltinProperties().
+var changefeedBuiltins = map[string]builtinDefinition{
+	"crdb_internal.changefeed_avro_schema": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         categorySystemInfo,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types: tree.ArgTypes{
+				{"table_name", types.String},
+			},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(evalCtx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				return changefeedAvroSchemas(evalCtx, string(tree.MustBeDString(args[0])), nil /* opts */)
+			},
+			Info: "Returns the Avro schemas of the keys and values a changefeed with format=avro " +
+				"would emit for the given table, and the subjects it would register them under.",
+			Volatility: tree.VolatilityStable,
+		},
+		tree.Overload{
+			Types: tree.ArgTypes{
+				{"table_name", types.String},
+				{"options", types.Jsonb},
+			},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(evalCtx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				opts, err := changefeedOptionsFromJSON(tree.MustBeDJSON(args[1]).JSON)
+				if err != nil {
+					return nil, err
+				}
+				return changefeedAvroSchemas(evalCtx, string(tree.MustBeDString(args[0])), opts)
+			},
+			Info: "Returns the Avro schemas of the keys and values a changefeed with format=avro " +
+				"and the given options would emit for the given table, and the subjects it would " +
+				"register them under. The options are an object mapping the names of changefeed " +
+				"options to their values, which are null for options without a value.",
+			Volatility: tree.VolatilityStable,
+		},
+	),
+}
+
+func changefeedAvroSchemas(
+	evalCtx *tree.EvalContext, tableName string, opts map[string]string,
+) (tree.Datum, error) {
+	if ChangefeedAvroSchemas == nil {
+		return nil, errors.New("changefeed avro schemas require a CCL binary")
+	}
+	j, err := ChangefeedAvroSchemas(evalCtx, tableName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tree.NewDJSON(j), nil
+}
+
+// changefeedOptionsFromJSON converts an object mapping the names of
+// changefeed options to their values to the options of a changefeed.
+func changefeedOptionsFromJSON(j json.JSON) (map[string]string, error) {
+	if j.Type() != json.ObjectJSONType {
+		return nil, pgerror.New(pgcode.InvalidParameterValue, "changefeed options must be an object")
+	}
+	it, err := j.ObjectIter()
+	if err != nil {
+		return nil, err
+	}
+	opts := make(map[string]string)
+	for it.Next() {
+		switch v := it.Value(); v.Type() {
+		case json.NullJSONType:
+			opts[it.Key()] = ``
+		case json.StringJSONType:
+			s, err := v.AsText()
+			if err != nil {
+				return nil, err
+			}
+			opts[it.Key()] = *s
+		default:
+			return nil, pgerror.Newf(pgcode.InvalidParameterValue,
+				"value of changefeed option %s must be a string or null", it.Key())
+		}
+	}
+	return opts, nil
+}
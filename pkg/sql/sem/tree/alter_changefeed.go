@@ -48,9 +48,11 @@ type AlterChangefeedCmd interface {
 
 func (*AlterChangefeedAddTarget) alterChangefeedCmd()  {}
 func (*AlterChangefeedDropTarget) alterChangefeedCmd() {}
+func (*AlterChangefeedResync) alterChangefeedCmd()     {}
 
 var _ AlterChangefeedCmd = &AlterChangefeedAddTarget{}
 var _ AlterChangefeedCmd = &AlterChangefeedDropTarget{}
+var _ AlterChangefeedCmd = &AlterChangefeedResync{}
 
 // AlterChangefeedAddTarget represents an ADD <targets> command
 type AlterChangefeedAddTarget struct {
@@ -73,3 +75,11 @@ func (node *AlterChangefeedDropTarget) Format(ctx *FmtCtx) {
 	ctx.WriteString(" DROP ")
 	ctx.FormatNode(&node.Targets.Tables)
 }
+
+// AlterChangefeedResync represents a RESYNC command
+type AlterChangefeedResync struct{}
+
+// Format implements the NodeFormatter interface.
+func (node *AlterChangefeedResync) Format(ctx *FmtCtx) {
+	ctx.WriteString(" RESYNC")
+}
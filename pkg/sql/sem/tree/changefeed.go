@@ -13,12 +13,50 @@ package tree
 // CreateChangefeed represents a CREATE CHANGEFEED statement.
 type CreateChangefeed struct {
 	Targets TargetList
-	SinkURI Expr
-	Options KVOptions
+	// TargetOptions, if non-nil, holds the options overriding Options for each
+	// of the tables in Targets, or nil for the tables without overrides.
+	TargetOptions []KVOptions
+	SinkURI       Expr
+	Options       KVOptions
 }
 
 var _ Statement = &CreateChangefeed{}
 
+// ChangefeedTarget is a table targeted by a CREATE CHANGEFEED statement,
+// along with the options overriding the changefeed's options for it, if any.
+type ChangefeedTarget struct {
+	Table   TablePattern
+	Options KVOptions
+}
+
+// ChangefeedTargets is the list of tables targeted by a CREATE CHANGEFEED
+// statement.
+type ChangefeedTargets []ChangefeedTarget
+
+// TargetList returns the tables targeted by the changefeed.
+func (t ChangefeedTargets) TargetList() TargetList {
+	tables := make(TablePatterns, len(t))
+	for i := range t {
+		tables[i] = t[i].Table
+	}
+	return TargetList{Tables: tables}
+}
+
+// TargetOptions returns the options of each of the tables targeted by the
+// changefeed, or nil if none of them has options.
+func (t ChangefeedTargets) TargetOptions() []KVOptions {
+	for i := range t {
+		if t[i].Options != nil {
+			opts := make([]KVOptions, len(t))
+			for j := range t {
+				opts[j] = t[j].Options
+			}
+			return opts
+		}
+	}
+	return nil
+}
+
 // Format implements the NodeFormatter interface.
 func (node *CreateChangefeed) Format(ctx *FmtCtx) {
 	if node.SinkURI != nil {
@@ -29,7 +67,22 @@ func (node *CreateChangefeed) Format(ctx *FmtCtx) {
 		ctx.WriteString("EXPERIMENTAL ")
 	}
 	ctx.WriteString("CHANGEFEED FOR ")
-	ctx.FormatNode(&node.Targets)
+	if node.TargetOptions == nil {
+		ctx.FormatNode(&node.Targets)
+	} else {
+		ctx.WriteString("TABLE ")
+		for i, t := range node.Targets.Tables {
+			if i > 0 {
+				ctx.WriteString(", ")
+			}
+			ctx.FormatNode(t)
+			if opts := node.TargetOptions[i]; opts != nil {
+				ctx.WriteString(" (")
+				ctx.FormatNode(&opts)
+				ctx.WriteString(")")
+			}
+		}
+	}
 	if node.SinkURI != nil {
 		ctx.WriteString(" INTO ")
 		ctx.FormatNode(node.SinkURI)
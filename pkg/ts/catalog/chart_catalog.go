@@ -1175,6 +1175,12 @@ var charts = []sectionDescription{
 	{
 		Organization: [][]string{{ReplicationLayer, "Changefeed"}},
 		Charts: []chartDescription{
+			{
+				Title: "Dropped Messages",
+				Metrics: []string{
+					"changefeed.dropped_messages",
+				},
+			},
 			{
 				Title: "Emitted Bytes",
 				Metrics: []string{
@@ -59,6 +59,21 @@ export default function(props: GraphDashboardProps) {
       </Axis>
     </LineGraph>,
 
+    <LineGraph
+      title="Dropped Messages"
+      sources={storeSources}
+      tooltip={`Messages lost by changefeeds with the at_most_once option
+        because they failed to be emitted to the sink.`}
+    >
+      <Axis units={AxisUnits.Count} label="messages">
+        <Metric
+          name="cr.node.changefeed.dropped_messages"
+          title="Dropped Messages"
+          nonNegativeRate
+        />
+      </Axis>
+    </LineGraph>,
+
     <LineGraph title="Sink Timings" sources={storeSources}>
       <Axis units={AxisUnits.Duration} label="time">
         <Metric
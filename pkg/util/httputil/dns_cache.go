@@ -0,0 +1,123 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package httputil
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// DialerStats counts the connections opened by a CachingDialer and the
+// lookups of their hosts. Any of its counters may be nil.
+type DialerStats struct {
+	ConnsOpened  *metric.Counter
+	DNSLookups   *metric.Counter
+	DNSCacheHits *metric.Counter
+}
+
+func inc(c *metric.Counter) {
+	if c != nil {
+		c.Inc(1)
+	}
+}
+
+// CachingDialer dials the addresses of hosts it cached for ttl, rather than
+// resolving them for each connection, which long-running HTTP clients, e.g.
+// those of changefeed sinks, would otherwise do whenever their connections
+// are closed.
+type CachingDialer struct {
+	dialer *net.Dialer
+	ttl    time.Duration
+	stats  DialerStats
+	mu     struct {
+		syncutil.Mutex
+		hosts map[string]cachedHostAddrs
+	}
+}
+
+type cachedHostAddrs struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// NewCachingDialer returns a CachingDialer which dials with dialer and caches
+// the addresses of hosts for ttl, or resolves them for each connection if
+// it's zero. Its connections and lookups are counted by stats.
+func NewCachingDialer(dialer *net.Dialer, ttl time.Duration, stats DialerStats) *CachingDialer {
+	return &CachingDialer{dialer: dialer, ttl: ttl, stats: stats}
+}
+
+// DialContext dials the address, like net.Dialer.DialContext, trying the
+// cached addresses of its host in turn. The addresses of a host are forgotten
+// once none of them can be dialed, in case the host moved.
+func (d *CachingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || d.ttl == 0 || net.ParseIP(host) != nil {
+		return d.dial(ctx, network, addr)
+	}
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addrs {
+		var conn net.Conn
+		if conn, err = d.dial(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	d.mu.Lock()
+	delete(d.mu.hosts, host)
+	d.mu.Unlock()
+	return nil, err
+}
+
+func (d *CachingDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err == nil {
+		inc(d.stats.ConnsOpened)
+	}
+	return conn, err
+}
+
+// lookup returns the addresses of the host, from the cache if they were
+// looked up less than ttl ago.
+func (d *CachingDialer) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := timeutil.Now()
+	d.mu.Lock()
+	cached, ok := d.mu.hosts[host]
+	d.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		inc(d.stats.DNSCacheHits)
+		return cached.addrs, nil
+	}
+
+	inc(d.stats.DNSLookups)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Errorf(`no addresses found for host %s`, host)
+	}
+	d.mu.Lock()
+	if d.mu.hosts == nil {
+		d.mu.hosts = make(map[string]cachedHostAddrs)
+	}
+	d.mu.hosts[host] = cachedHostAddrs{addrs: addrs, expires: now.Add(d.ttl)}
+	d.mu.Unlock()
+	return addrs, nil
+}